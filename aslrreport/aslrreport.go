@@ -0,0 +1,121 @@
+// Package aslrreport measures how much of the address space layout of processes is actually randomized.
+package aslrreport
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"sort"
+
+	"github.com/polyverse/masche/common"
+	"github.com/polyverse/masche/process"
+)
+
+// Names of the components whose base address is measured. Libraries are reported as LibraryPrefix followed by their
+// path.
+const (
+	// Executable is the lowest address of the main executable, its slide for position independent executables.
+	Executable = "executable"
+	// Heap is the start of the heap relative to Executable.
+	Heap = "heap"
+	// Stack is the end of the primary stack.
+	Stack = "stack"
+	// Mmap is the end of the highest mapping below the stack, which approximates the base of the mmap area.
+	Mmap = "mmap"
+
+	LibraryPrefix = "library:"
+)
+
+// Sample is the layout observed in a single process.
+type Sample struct {
+	Pid   int                `json:"pid"`
+	Bases map[string]uintptr `json:"bases"`
+}
+
+// ComponentEntropy describes the randomization observed for one component across all the samples.
+type ComponentEntropy struct {
+	Component string `json:"component"`
+	// Samples is the number of samples that contain the component.
+	Samples  int `json:"samples"`
+	Distinct int `json:"distinct"`
+	// RandomizedBits is the number of address bits that weren't the same in every sample.
+	RandomizedBits int `json:"randomizedBits"`
+	// Entropy is the Shannon entropy, in bits, of the observed values. It can't exceed log2(Samples).
+	Entropy float64 `json:"entropy"`
+}
+
+// Report is the result of Analyze.
+type Report struct {
+	Samples    []Sample           `json:"samples"`
+	Components []ComponentEntropy `json:"components"`
+}
+
+// Analyze measures the layout of procs, which should be repeated launches of the same binary. The entropy of each
+// component is computed from the values observed across them, so a single process only reports its layout.
+//
+// Processes that can't be analyzed are reported as softerrors and left out of the report. The samples are sorted by
+// pid.
+func Analyze(procs []process.Process) (report Report, harderror error, softerrors []error) {
+	if len(procs) == 0 {
+		return report, fmt.Errorf("No processes to analyze"), nil
+	}
+
+	for _, p := range procs {
+		bases, err, serrs := componentBases(p)
+		softerrors = append(softerrors, serrs...)
+		if err != nil {
+			softerrors = append(softerrors, &common.LocatedError{Pid: p.Pid(),
+				Err: fmt.Errorf("Skipping process %d: %v", p.Pid(), err)})
+			continue
+		}
+		report.Samples = append(report.Samples, Sample{Pid: p.Pid(), Bases: bases})
+	}
+	sort.SliceStable(report.Samples, func(i, j int) bool { return report.Samples[i].Pid < report.Samples[j].Pid })
+	common.SortSoftErrors(softerrors)
+
+	if len(report.Samples) == 0 {
+		return report, fmt.Errorf("None of the processes could be analyzed"), softerrors
+	}
+
+	report.Components = componentEntropies(report.Samples)
+	return report, nil, softerrors
+}
+
+// componentEntropies computes the entropy of every component found in samples, sorted by component name.
+func componentEntropies(samples []Sample) []ComponentEntropy {
+	values := make(map[string][]uintptr)
+	for _, sample := range samples {
+		for component, base := range sample.Bases {
+			values[component] = append(values[component], base)
+		}
+	}
+
+	components := make([]ComponentEntropy, 0, len(values))
+	for component, bases := range values {
+		counts := make(map[uintptr]int)
+		var varying uint64
+		for _, base := range bases {
+			counts[base]++
+			varying |= uint64(base ^ bases[0])
+		}
+
+		entropy := 0.0
+		for _, count := range counts {
+			p := float64(count) / float64(len(bases))
+			entropy -= p * math.Log2(p)
+		}
+
+		components = append(components, ComponentEntropy{
+			Component:      component,
+			Samples:        len(bases),
+			Distinct:       len(counts),
+			RandomizedBits: bits.OnesCount64(varying),
+			Entropy:        entropy,
+		})
+	}
+
+	sort.Slice(components, func(i, j int) bool {
+		return components[i].Component < components[j].Component
+	})
+	return components
+}
@@ -0,0 +1,60 @@
+package aslrreport
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/polyverse/masche/common"
+	"github.com/polyverse/masche/process"
+)
+
+func componentBases(p process.Process) (bases map[string]uintptr, harderror error, softerrors []error) {
+	exe, err := process.ProcessExe(p.Pid())
+	if err != nil {
+		return nil, err, nil
+	}
+
+	entries, err := common.ReadMapsFile(uint(p.Pid()))
+	if err != nil {
+		return nil, err, nil
+	}
+
+	bases = make(map[string]uintptr)
+	var heap, stackStart uintptr
+	for _, entry := range entries {
+		switch {
+		case entry.Path == exe:
+			if _, ok := bases[Executable]; !ok {
+				bases[Executable] = entry.Start
+			}
+		case entry.Path == "[heap]":
+			heap = entry.Start
+		case entry.Path == "[stack]":
+			stackStart = entry.Start
+			bases[Stack] = entry.End
+		case strings.HasPrefix(entry.Path, "/"):
+			if _, ok := bases[LibraryPrefix+entry.Path]; !ok {
+				bases[LibraryPrefix+entry.Path] = entry.Start
+			}
+		}
+	}
+
+	exeBase, ok := bases[Executable]
+	if !ok {
+		return nil, fmt.Errorf("The executable %s of process %d is not mapped", exe, p.Pid()), nil
+	}
+	if heap != 0 {
+		bases[Heap] = heap - exeBase
+	}
+
+	// The vDSO and friends may be mapped between the mmap area and the stack.
+	for _, entry := range entries {
+		if stackStart != 0 && entry.End <= stackStart && !strings.HasPrefix(entry.Path, "[") &&
+			entry.End > bases[Mmap] {
+
+			bases[Mmap] = entry.End
+		}
+	}
+
+	return bases, nil, nil
+}
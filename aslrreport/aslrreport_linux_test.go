@@ -0,0 +1,65 @@
+package aslrreport
+
+import (
+	"debug/elf"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/polyverse/masche/process"
+	"github.com/polyverse/masche/test"
+)
+
+func TestAnalyze(t *testing.T) {
+	if randomization, err := ioutil.ReadFile("/proc/sys/kernel/randomize_va_space"); err == nil &&
+		strings.TrimSpace(string(randomization)) == "0" {
+
+		t.Skip("Address space randomization is disabled")
+	}
+	f, err := elf.Open(test.GetTestCasePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pie := f.Type == elf.ET_DYN
+	f.Close()
+	if !pie {
+		t.Skip("The test case is not a position independent executable")
+	}
+
+	var procs []process.Process
+	for i := 0; i < 8; i++ {
+		cmd, err := test.LaunchTestCaseAndWaitForInitialization()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer cmd.Process.Kill()
+
+		proc, err, softerrors := process.OpenFromPid(cmd.Process.Pid)
+		test.PrintSoftErrors(softerrors)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer proc.Close()
+		procs = append(procs, proc)
+	}
+
+	report, err, softerrors := Analyze(procs)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Samples) != len(procs) {
+		t.Fatal("Expected a sample for each process and got", report.Samples)
+	}
+
+	for _, component := range report.Components {
+		if component.Component != Executable {
+			continue
+		}
+		if component.RandomizedBits == 0 || component.Entropy == 0 {
+			t.Error("No randomization observed in the executable base", component)
+		}
+		return
+	}
+	t.Error("The executable base was not reported")
+}
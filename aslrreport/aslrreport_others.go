@@ -0,0 +1,13 @@
+// +build windows darwin
+
+package aslrreport
+
+import (
+	"fmt"
+
+	"github.com/polyverse/masche/process"
+)
+
+func componentBases(p process.Process) (bases map[string]uintptr, harderror error, softerrors []error) {
+	return nil, fmt.Errorf("The address space layout report is not implemented on this platform"), nil
+}
@@ -0,0 +1,33 @@
+package aslrreport
+
+import (
+	"testing"
+)
+
+func TestComponentEntropies(t *testing.T) {
+	samples := []Sample{
+		{Pid: 1, Bases: map[string]uintptr{Executable: 0x1000, Stack: 0x9000}},
+		{Pid: 2, Bases: map[string]uintptr{Executable: 0x3000, Stack: 0x9000}},
+		{Pid: 3, Bases: map[string]uintptr{Executable: 0x5000}},
+		{Pid: 4, Bases: map[string]uintptr{Executable: 0x7000}},
+	}
+
+	components := componentEntropies(samples)
+	if len(components) != 2 {
+		t.Fatal("Expected 2 components and got", components)
+	}
+
+	exe := components[0]
+	if exe.Component != Executable || exe.Samples != 4 || exe.Distinct != 4 || exe.RandomizedBits != 2 ||
+		exe.Entropy != 2 {
+
+		t.Error("Unexpected executable entropy", exe)
+	}
+
+	stack := components[1]
+	if stack.Component != Stack || stack.Samples != 2 || stack.Distinct != 1 || stack.RandomizedBits != 0 ||
+		stack.Entropy != 0 {
+
+		t.Error("Unexpected stack entropy", stack)
+	}
+}
@@ -0,0 +1,156 @@
+package common
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Availability tells if a feature of masche works, and if it doesn't, why.
+type Availability int
+
+const (
+	// Available means that the feature works.
+	Available Availability = iota
+	// NeedsPrivilege means that the feature works on this platform, but not with the privileges masche is running
+	// with.
+	NeedsPrivilege
+	// UnsupportedPlatform means that the feature isn't implemented for this operating system or architecture.
+	UnsupportedPlatform
+	// UnsupportedKernel means that the feature is implemented for this platform, but the running kernel lacks what it
+	// needs (i.e. it's too old, or it was built without it).
+	UnsupportedKernel
+)
+
+func (a Availability) String() string {
+	switch a {
+	case Available:
+		return "Available"
+	case NeedsPrivilege:
+		return "NeedsPrivilege"
+	case UnsupportedPlatform:
+		return "UnsupportedPlatform"
+	case UnsupportedKernel:
+		return "UnsupportedKernel"
+	}
+	return "Unknown"
+}
+
+// Capability is the availability of a feature, with a human readable reason when it isn't available.
+type Capability struct {
+	Feature      string       `json:"feature"`
+	Availability Availability `json:"availability"`
+	Reason       string       `json:"reason,omitempty"`
+}
+
+func (c Capability) String() string {
+	if c.Reason == "" {
+		return fmt.Sprintf("%s: %v", c.Feature, c.Availability)
+	}
+	return fmt.Sprintf("%s: %v (%s)", c.Feature, c.Availability, c.Reason)
+}
+
+// CapabilityProbe cheaply finds out if a feature works. It's called with pid 0 to probe the host, and with the pid of
+// a process to probe the feature on it. The probes of a process are only called if the feature is available on the
+// host.
+type CapabilityProbe func(pid int) (availability Availability, reason string)
+
+var capabilities = struct {
+	sync.Mutex
+	probes map[string]CapabilityProbe
+	host   map[string]Capability
+	procs  map[int]processCapabilities
+}{
+	probes: map[string]CapabilityProbe{},
+	host:   map[string]Capability{},
+	procs:  map[int]processCapabilities{},
+}
+
+// processCapabilities are the cached capabilities of a process. The start time tells it apart from a later process
+// with the same pid, which replaces it in the cache.
+type processCapabilities struct {
+	start  uint64
+	matrix map[string]Capability
+}
+
+// RegisterCapability adds a feature to the matrix returned by Capabilities and ProcessCapabilities. Every feature that
+// can be unavailable registers itself, from the init function of the package that implements it.
+func RegisterCapability(feature string, probe CapabilityProbe) {
+	capabilities.Lock()
+	defer capabilities.Unlock()
+	if _, ok := capabilities.probes[feature]; ok {
+		panic(fmt.Sprintf("capability %s registered twice", feature))
+	}
+	capabilities.probes[feature] = probe
+}
+
+// Capabilities returns the availability of every registered feature on this host, sorted by feature. The probes run
+// once, and their results are cached for the life of the program.
+func Capabilities() []Capability {
+	capabilities.Lock()
+	defer capabilities.Unlock()
+	return sortedCapabilities(hostCapabilities())
+}
+
+// HostAvailable tells if a feature is available on this host. Unregistered features aren't.
+func HostAvailable(feature string) bool {
+	capabilities.Lock()
+	defer capabilities.Unlock()
+	c, ok := hostCapabilities()[feature]
+	return ok && c.Availability == Available
+}
+
+// ProcessCapabilities returns the availability of every registered feature on the process with the given pid, sorted
+// by feature. Features that aren't available on the host aren't available on any process. The results are cached
+// while the process lives, on the platforms where a process can be told apart from a later one with the same pid.
+func ProcessCapabilities(pid int) []Capability {
+	capabilities.Lock()
+	defer capabilities.Unlock()
+
+	start, cacheable := processStartTime(pid)
+	if cached, ok := capabilities.procs[pid]; ok && cacheable && cached.start == start {
+		return sortedCapabilities(cached.matrix)
+	}
+
+	result := map[string]Capability{}
+	for feature, c := range hostCapabilities() {
+		if c.Availability == Available {
+			availability, reason := capabilities.probes[feature](pid)
+			c = Capability{Feature: feature, Availability: availability, Reason: reason}
+		}
+		result[feature] = c
+	}
+	if cacheable {
+		capabilities.procs[pid] = processCapabilities{start: start, matrix: result}
+	}
+	return sortedCapabilities(result)
+}
+
+// FindCapability returns the capability of the given feature in a matrix, or false if it isn't in it.
+func FindCapability(matrix []Capability, feature string) (c Capability, found bool) {
+	for _, c := range matrix {
+		if c.Feature == feature {
+			return c, true
+		}
+	}
+	return Capability{}, false
+}
+
+// hostCapabilities probes the features that weren't probed yet. It must be called with capabilities locked.
+func hostCapabilities() map[string]Capability {
+	for feature, probe := range capabilities.probes {
+		if _, ok := capabilities.host[feature]; !ok {
+			availability, reason := probe(0)
+			capabilities.host[feature] = Capability{Feature: feature, Availability: availability, Reason: reason}
+		}
+	}
+	return capabilities.host
+}
+
+func sortedCapabilities(m map[string]Capability) (matrix []Capability) {
+	for _, c := range m {
+		matrix = append(matrix, c)
+	}
+	sort.Slice(matrix, func(i, j int) bool { return matrix[i].Feature < matrix[j].Feature })
+	return matrix
+}
@@ -0,0 +1,10 @@
+package common
+
+// processStartTime returns the start time of a process, which tells it apart from a later process with the same pid.
+func processStartTime(pid int) (start uint64, ok bool) {
+	stat, err := ReadStatFile(uint(pid))
+	if err != nil {
+		return 0, false
+	}
+	return stat.StartTime, true
+}
@@ -0,0 +1,82 @@
+package common
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeFakeStat writes the stat file of a fake process with the given start time.
+func writeFakeStat(t *testing.T, pid int, start uint64) {
+	dir := filepath.Join(ProcRoot, fmt.Sprint(pid))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	data := fmt.Sprintf("%d (fake) S 1 %d %d 0 -1 4194560 0 0 0 0 0 0 0 0 20 0 1 0 %d 0 0 "+
+		"18446744073709551615 1 1 0 0 0 0 0 0 0 0 0 0 17 0 0 0 0 0 0 0 0 0 0 0 0 0 0\n", pid, pid, pid, start)
+	if err := ioutil.WriteFile(filepath.Join(dir, "stat"), []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	defer func(root string) { ProcRoot = root }(ProcRoot)
+	ProcRoot = t.TempDir()
+	const pid = 4242
+	writeFakeStat(t, pid, 100)
+
+	probes := map[string]int{}
+	RegisterCapability("test-available", func(pid int) (Availability, string) {
+		probes[fmt.Sprint("test-available", pid)]++
+		if pid == 0 {
+			return Available, ""
+		}
+		return NeedsPrivilege, "not ours"
+	})
+	RegisterCapability("test-unsupported", func(pid int) (Availability, string) {
+		probes[fmt.Sprint("test-unsupported", pid)]++
+		return UnsupportedKernel, "too old"
+	})
+
+	for i := 0; i < 2; i++ {
+		matrix := Capabilities()
+		if c, ok := FindCapability(matrix, "test-available"); !ok || c.Availability != Available {
+			t.Errorf("Expected test-available to be available on the host, got %v", c)
+		}
+		if c, ok := FindCapability(matrix, "test-unsupported"); !ok || c.Availability != UnsupportedKernel ||
+			c.Reason != "too old" {
+
+			t.Errorf("Expected test-unsupported to be unsupported on the host, got %v", c)
+		}
+		if !HostAvailable("test-available") || HostAvailable("test-unsupported") || HostAvailable("test-unknown") {
+			t.Error("HostAvailable doesn't agree with Capabilities")
+		}
+
+		matrix = ProcessCapabilities(pid)
+		if c, _ := FindCapability(matrix, "test-available"); c.Availability != NeedsPrivilege {
+			t.Errorf("Expected test-available to need privileges on the process, got %v", c)
+		}
+		if c, _ := FindCapability(matrix, "test-unsupported"); c.Availability != UnsupportedKernel {
+			t.Errorf("Expected test-unsupported to be unsupported on the process, got %v", c)
+		}
+	}
+	for i := 1; i < len(Capabilities()); i++ {
+		if Capabilities()[i-1].Feature >= Capabilities()[i].Feature {
+			t.Error("The capabilities aren't sorted")
+		}
+	}
+
+	expected := map[string]int{"test-available0": 1, "test-unsupported0": 1, fmt.Sprint("test-available", pid): 1}
+	if fmt.Sprint(probes) != fmt.Sprint(expected) {
+		t.Errorf("Expected the probes %v and got %v", expected, probes)
+	}
+
+	// Another process got the same pid.
+	writeFakeStat(t, pid, 200)
+	ProcessCapabilities(pid)
+	if probes[fmt.Sprint("test-available", pid)] != 2 {
+		t.Error("The capabilities of a process that reused the pid were cached")
+	}
+}
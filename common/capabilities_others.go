@@ -0,0 +1,8 @@
+// +build windows darwin
+
+package common
+
+// processStartTime returns false, as processes aren't told apart from later ones with the same pid on this platform.
+func processStartTime(pid int) (start uint64, ok bool) {
+	return 0, false
+}
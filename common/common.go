@@ -1 +1,16 @@
 package common
+
+import (
+	"fmt"
+	"math"
+)
+
+// MemFileOffset returns the offset of address in a file that maps the address space, like /proc/PID/mem, to read size
+// bytes from it. Offsets are int64, so the addresses from 1<<63 on, which are the kernel's on the platforms that have
+// them, can't be read through such files, and converting them would give a negative offset.
+func MemFileOffset(address uintptr, size int) (int64, error) {
+	if uint64(address) > math.MaxInt64 || size < 0 || uint64(size) > math.MaxInt64-uint64(address)+1 {
+		return 0, fmt.Errorf("The %d bytes at %x are beyond the offsets of a file", size, address)
+	}
+	return int64(address), nil
+}
@@ -1,39 +1,64 @@
 package common
 
 import (
+	"bufio"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"math/bits"
+	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 )
 
+// ProcRoot is where the proc filesystem is mounted. It's a variable so tests can use a fake one.
+var ProcRoot = "/proc"
+
+// SysRoot is where the sys filesystem is mounted. It's a variable so tests can use a fake one.
+var SysRoot = "/sys"
+
+// ProcFilePath returns the path of a file in the proc directory of the process with the given pid.
+func ProcFilePath(pid uint, file string) string {
+	return filepath.Join(ProcRoot, fmt.Sprintf("%d", pid), file)
+}
+
 func MapsFilePathFromPid(pid uint) string {
-	return filepath.Join("/proc", fmt.Sprintf("%d", pid), "maps")
+	return ProcFilePath(pid, "maps")
 }
 
 func MemFilePathFromPid(pid uint) string {
-	return filepath.Join("/proc", fmt.Sprintf("%d", pid), "mem")
+	return ProcFilePath(pid, "mem")
 }
 
-//Parses the memory limits of a mapping as found in /proc/PID/maps
+// Parses the memory limits of a mapping as found in /proc/PID/maps
+//
+// The limits are checked to fit in a uintptr, so the mappings of a 64 bit process can't be truncated by a 32 bit
+// build, and the end to be above the start. The end of the highest mapping is the end of the address space of the
+// process, like 0x7ffffffff000 with the 48 bit addresses of 4-level page tables on x86-64, or 0xfffffffffff000 with
+// the 57 bit ones of 5-level page tables, whose user half ends at 1<<56. It's never 1<<64, so it always fits.
 func ParseMapsFileMemoryLimits(limits string) (start uintptr, end uintptr, err error) {
 	fields := strings.Split(limits, "-")
 	if len(fields) != 2 {
 		return 0, 0, fmt.Errorf("Invalid memory limits, it must have two hexa numbers separeted by a single -")
 	}
 
-	start64, err := strconv.ParseUint(fields[0], 16, 64)
+	start64, err := strconv.ParseUint(fields[0], 16, bits.UintSize)
 	if err != nil {
 		return 0, 0, err
 	}
 	start = uintptr(start64)
 
-	end64, err := strconv.ParseUint(fields[1], 16, 64)
+	end64, err := strconv.ParseUint(fields[1], 16, bits.UintSize)
 	if err != nil {
 		return 0, 0, err
 	}
 	end = uintptr(end64)
 
+	if end <= start {
+		return 0, 0, fmt.Errorf("Invalid memory limits, the end %x is not above the start %x", end, start)
+	}
 	return
 }
 
@@ -52,3 +77,292 @@ func SplitMapsFileEntry(entry string) []string {
 	res = append(res, strings.TrimLeft(entry, " "))
 	return res
 }
+
+// MapsEntry is a parsed line of a /proc/PID/maps file.
+type MapsEntry struct {
+	Start       uintptr
+	End         uintptr
+	Permissions string
+	Offset      uint64
+	DevMajor    uint32
+	DevMinor    uint32
+	Inode       uint64
+	Path        string
+}
+
+// ParseMapsFileEntry parses a line of a /proc/PID/maps file.
+func ParseMapsFileEntry(line string) (entry MapsEntry, err error) {
+	items := SplitMapsFileEntry(line)
+	if len(items) != 6 {
+		return entry, fmt.Errorf("Unrecognised maps line: %s", line)
+	}
+
+	entry.Start, entry.End, err = ParseMapsFileMemoryLimits(items[0])
+	if err != nil {
+		return entry, err
+	}
+
+	entry.Permissions = items[1]
+	if !validPermissions(entry.Permissions) {
+		return entry, fmt.Errorf("Invalid permissions in maps line: %s", line)
+	}
+	entry.Offset, err = strconv.ParseUint(items[2], 16, 64)
+	if err != nil {
+		return entry, fmt.Errorf("Invalid offset in maps line %s (%v)", line, err)
+	}
+
+	dev := strings.Split(items[3], ":")
+	if len(dev) != 2 {
+		return entry, fmt.Errorf("Invalid device in maps line: %s", line)
+	}
+	major, err := strconv.ParseUint(dev[0], 16, 32)
+	if err != nil {
+		return entry, fmt.Errorf("Invalid device in maps line %s (%v)", line, err)
+	}
+	minor, err := strconv.ParseUint(dev[1], 16, 32)
+	if err != nil {
+		return entry, fmt.Errorf("Invalid device in maps line %s (%v)", line, err)
+	}
+	entry.DevMajor, entry.DevMinor = uint32(major), uint32(minor)
+
+	entry.Inode, err = strconv.ParseUint(items[4], 10, 64)
+	if err != nil {
+		return entry, fmt.Errorf("Invalid inode in maps line %s (%v)", line, err)
+	}
+
+	entry.Path = items[5]
+	return entry, nil
+}
+
+// validPermissions tells if perms are the permissions of a mapping, like r-xp.
+func validPermissions(perms string) bool {
+	return len(perms) == 4 && (perms[0] == 'r' || perms[0] == '-') && (perms[1] == 'w' || perms[1] == '-') &&
+		(perms[2] == 'x' || perms[2] == '-') && (perms[3] == 'p' || perms[3] == 's')
+}
+
+// ReadMapsFile returns all the entries of the maps file of the process with the given pid.
+func ReadMapsFile(pid uint) (entries []MapsEntry, err error) {
+	mapsFile, err := os.Open(MapsFilePathFromPid(pid))
+	if err != nil {
+		return nil, err
+	}
+	defer mapsFile.Close()
+
+	return ParseMapsFile(mapsFile)
+}
+
+// ParseMapsFile parses the contents of a /proc/PID/maps file.
+func ParseMapsFile(r io.Reader) (entries []MapsEntry, err error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		entry, err := ParseMapsFileEntry(scanner.Text())
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, scanner.Err()
+}
+
+func SmapsFilePathFromPid(pid uint) string {
+	return ProcFilePath(pid, "smaps")
+}
+
+// SmapsEntry is a mapping of a /proc/PID/smaps file. Its sizes are in bytes.
+type SmapsEntry struct {
+	MapsEntry
+	Rss          uint64
+	PrivateDirty uint64
+	SharedDirty  uint64
+	// Anonymous is the memory that isn't backed by the mapped file, like the private copies of modified pages.
+	Anonymous uint64
+	Swap      uint64
+}
+
+// ParseSmapsFile parses the contents of a /proc/PID/smaps file. Only the sizes in SmapsEntry are taken from the
+// lines following each mapping.
+func ParseSmapsFile(r io.Reader) (entries []SmapsEntry, err error) {
+	fields := map[string]func(*SmapsEntry) *uint64{
+		"Rss:":           func(e *SmapsEntry) *uint64 { return &e.Rss },
+		"Private_Dirty:": func(e *SmapsEntry) *uint64 { return &e.PrivateDirty },
+		"Shared_Dirty:":  func(e *SmapsEntry) *uint64 { return &e.SharedDirty },
+		"Anonymous:":     func(e *SmapsEntry) *uint64 { return &e.Anonymous },
+		"Swap:":          func(e *SmapsEntry) *uint64 { return &e.Swap },
+	}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		items := strings.Fields(line)
+		if len(items) == 0 {
+			continue
+		}
+
+		if !strings.HasSuffix(items[0], ":") {
+			mapping, err := ParseMapsFileEntry(line)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, SmapsEntry{MapsEntry: mapping})
+			continue
+		}
+
+		field, ok := fields[items[0]]
+		if !ok || len(entries) == 0 {
+			continue
+		}
+		if len(items) != 3 || items[2] != "kB" {
+			return nil, fmt.Errorf("Unrecognised smaps line: %s", line)
+		}
+		size, err := strconv.ParseUint(items[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid size in smaps line %s (%v)", line, err)
+		}
+		*field(&entries[len(entries)-1]) = size * 1024
+	}
+
+	return entries, scanner.Err()
+}
+
+// ReadSmapsFile returns all the entries of the smaps file of the process with the given pid.
+func ReadSmapsFile(pid uint) (entries []SmapsEntry, err error) {
+	smapsFile, err := os.Open(SmapsFilePathFromPid(pid))
+	if err != nil {
+		return nil, err
+	}
+	defer smapsFile.Close()
+
+	return ParseSmapsFile(smapsFile)
+}
+
+// DevMajorMinor splits a device number, as found in syscall.Stat_t.Dev, in its major and minor numbers.
+func DevMajorMinor(dev uint64) (major uint32, minor uint32) {
+	major = uint32(((dev >> 8) & 0xfff) | ((dev >> 32) & 0xfffff000))
+	minor = uint32((dev & 0xff) | ((dev >> 12) & 0xffffff00))
+	return
+}
+
+func PagemapFilePathFromPid(pid uint) string {
+	return ProcFilePath(pid, "pagemap")
+}
+
+func StatFilePathFromPid(pid uint) string {
+	return ProcFilePath(pid, "stat")
+}
+
+// ProcStat holds the fields of a /proc/PID/stat file used by masche. See proc(5) for their meaning.
+type ProcStat struct {
+	Pid   int
+	Comm  string
+	State string
+	Ppid  int
+	// Flags are the PF_* flags of the kernel, like PF_EXITING.
+	Flags    uint64
+	Minflt   uint64
+	Majflt   uint64
+	Utime    uint64
+	Stime    uint64
+	Priority int64
+	Nice     int64
+	// StartTime is the time the process started after system boot, in clock ticks.
+	StartTime uint64
+}
+
+// ParseStatFile parses the contents of a /proc/PID/stat file.
+//
+// The second field is the command name between parentheses, and it can contain spaces and parentheses itself, so
+// the rest of the fields are the ones after the last ')'.
+func ParseStatFile(data []byte) (stat ProcStat, err error) {
+	contents := string(data)
+	open := strings.Index(contents, "(")
+	close := strings.LastIndex(contents, ")")
+	if open == -1 || close < open {
+		return stat, fmt.Errorf("Invalid stat file, command name not found")
+	}
+
+	stat.Pid, err = strconv.Atoi(strings.TrimSpace(contents[:open]))
+	if err != nil {
+		return stat, fmt.Errorf("Invalid pid in stat file (%v)", err)
+	}
+	stat.Comm = contents[open+1 : close]
+
+	// fields[0] is the third field of the file.
+	fields := strings.Fields(contents[close+1:])
+	if len(fields) < 20 {
+		return stat, fmt.Errorf("Invalid stat file, only %d fields after the command name", len(fields))
+	}
+
+	stat.State = fields[0]
+	stat.Ppid, err = strconv.Atoi(fields[1])
+	if err != nil {
+		return stat, fmt.Errorf("Invalid ppid in stat file (%v)", err)
+	}
+
+	uints := []struct {
+		field int
+		dest  *uint64
+	}{{6, &stat.Flags}, {7, &stat.Minflt}, {9, &stat.Majflt}, {11, &stat.Utime}, {12, &stat.Stime},
+		{19, &stat.StartTime}}
+	for _, u := range uints {
+		*u.dest, err = strconv.ParseUint(fields[u.field], 10, 64)
+		if err != nil {
+			return stat, fmt.Errorf("Invalid field %d in stat file (%v)", u.field+3, err)
+		}
+	}
+
+	stat.Priority, err = strconv.ParseInt(fields[15], 10, 64)
+	if err != nil {
+		return stat, fmt.Errorf("Invalid priority in stat file (%v)", err)
+	}
+	stat.Nice, err = strconv.ParseInt(fields[16], 10, 64)
+	if err != nil {
+		return stat, fmt.Errorf("Invalid nice in stat file (%v)", err)
+	}
+
+	return stat, nil
+}
+
+// PF_EXITING is the flag of the processes that are exiting, whose memory and executable may already be gone while they
+// don't look like zombies yet.
+const PF_EXITING = 0x4
+
+// ClockTicks is the value of sysconf(_SC_CLK_TCK), the unit of the times in stat files, which is 100 on every Linux
+// architecture supported by Go.
+const ClockTicks = 100
+
+// StartedAt returns the time the process started, given the time the system booted.
+func (s ProcStat) StartedAt(boot time.Time) time.Time {
+	return boot.Add(time.Duration(s.StartTime) * time.Second / ClockTicks)
+}
+
+// BootTime returns the time the system booted, from the btime line of /proc/stat. It's in whole seconds, and so the
+// same every time it's read, unlike now minus the uptime, which lets the start times of processes computed from it be
+// compared between reads.
+func BootTime() (time.Time, error) {
+	path := filepath.Join(ProcRoot, "stat")
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if value := strings.TrimPrefix(line, "btime "); value != line {
+			seconds, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+			if err != nil {
+				return time.Time{}, fmt.Errorf("Invalid boot time in %s (%v)", path, err)
+			}
+			return time.Unix(seconds, 0), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("No boot time in %s", path)
+}
+
+// ReadStatFile reads and parses the stat file of the process with the given pid.
+func ReadStatFile(pid uint) (stat ProcStat, err error) {
+	data, err := ioutil.ReadFile(StatFilePathFromPid(pid))
+	if err != nil {
+		return stat, err
+	}
+
+	return ParseStatFile(data)
+}
@@ -1,6 +1,10 @@
 package common
 
 import (
+	"io/ioutil"
+	"math/bits"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -57,6 +61,23 @@ func TestParseMapsFileMemoryLimits(t *testing.T) {
 		}
 	}
 
+	if bits.UintSize == 64 {
+		// The top of the user address space with 48 bit addresses, the 52 bit ones of arm64 and the 57 bit ones of
+		// 5-level page tables on x86-64, and the highest pages of the kernel's, like the vsyscall page.
+		for limits, expected := range map[string][2]uintptr{
+			"7fffffffe000-7ffffffff000":         {0x7fffffffe000, 0x7ffffffff000},
+			"fffffffffe000-ffffffffff000":       {0xfffffffffe000, 0xffffffffff000},
+			"ffffffffffe000-fffffffffff000":     {0xffffffffffe000, 0xfffffffffff000},
+			"ffffffffffffe000-fffffffffffff000": {^uintptr(0) &^ 0x1fff, ^uintptr(0) &^ 0xfff},
+			"ffffffffff600000-ffffffffff601000": {^uintptr(0) &^ 0x9fffff, ^uintptr(0) &^ 0x9fefff},
+		} {
+			start, end, err := ParseMapsFileMemoryLimits(limits)
+			if err != nil || start != expected[0] || end != expected[1] {
+				t.Errorf("Parsed %s as %x-%x (%v)", limits, start, end, err)
+			}
+		}
+	}
+
 	var invalidMemoryLimits = []string{
 		"a",
 		"aa-",
@@ -64,6 +85,9 @@ func TestParseMapsFileMemoryLimits(t *testing.T) {
 		"NonAlpha-1",
 		"1-NonAlpha",
 		"1-1-1",
+		"2000-1000",
+		"1000-1000",
+		"ffffffffffffe000-10000000000000000",
 	}
 
 	for _, limits := range invalidMemoryLimits {
@@ -88,3 +112,174 @@ func compareStringSlices(a []string, b []string) bool {
 
 	return true
 }
+
+func TestParseMapsFileEntry(t *testing.T) {
+	entry, err := ParseMapsFileEntry(
+		"7fb8faf65000-7fb8faf66000 r-xp 00023000 fd:01 922969                     /lib/x86_64-linux-gnu/ld-2.19.so")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := MapsEntry{
+		Start:       0x7fb8faf65000,
+		End:         0x7fb8faf66000,
+		Permissions: "r-xp",
+		Offset:      0x23000,
+		DevMajor:    0xfd,
+		DevMinor:    0x01,
+		Inode:       922969,
+		Path:        "/lib/x86_64-linux-gnu/ld-2.19.so",
+	}
+	if entry != expected {
+		t.Error("Expected", expected, "and got", entry)
+	}
+
+	if _, err := ParseMapsFileEntry("7fb8faf65000-7fb8faf66000 r-xp 00023000 fd01 922969"); err == nil {
+		t.Error("An error should have been returned for an invalid device")
+	}
+}
+
+func TestParseSmapsFile(t *testing.T) {
+	smaps := `55d4c5a00000-55d4c5a02000 r--p 00000000 fd:01 1048601                    /usr/bin/cat
+Size:                  8 kB
+Rss:                   8 kB
+Private_Dirty:         0 kB
+VmFlags: rd mr mw me sd
+7ffd1e5c4000-7ffd1e5e5000 rw-p 00000000 00:00 0                          [stack]
+Size:                132 kB
+Rss:                  12 kB
+Shared_Dirty:          4 kB
+Private_Dirty:        12 kB
+Anonymous:            12 kB
+Swap:                  8 kB
+VmFlags: rd wr mr mw me gd ac
+`
+	entries, err := ParseSmapsFile(strings.NewReader(smaps))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatal("Expected 2 entries and got", entries)
+	}
+
+	if entries[0].Path != "/usr/bin/cat" || entries[0].Rss != 8*1024 || entries[0].PrivateDirty != 0 {
+		t.Error("Unexpected first entry", entries[0])
+	}
+	stack := entries[1]
+	if stack.Path != "[stack]" || stack.Rss != 12*1024 || stack.PrivateDirty != 12*1024 ||
+		stack.SharedDirty != 4*1024 || stack.Anonymous != 12*1024 || stack.Swap != 8*1024 {
+
+		t.Error("Unexpected stack entry", stack)
+	}
+}
+
+func TestDevMajorMinor(t *testing.T) {
+	major, minor := DevMajorMinor(0x10303)
+	if major != 0x103 || minor != 0x3 {
+		t.Errorf("Expected 103:3 and got %x:%x", major, minor)
+	}
+}
+
+func TestParseStatFile(t *testing.T) {
+	data := []byte("4242 (a (weird) name) S 1 4242 4242 0 -1 4194560 1130 0 2 0 17 5 0 0 20 0 1 0 3478 " +
+		"2703360 332 18446744073709551615 1 1 0 0 0 0 0 0 0 0 0 0 17 0 0 0 0 0 0 0 0 0 0 0 0 0 0\n")
+
+	stat, err := ParseStatFile(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := ProcStat{
+		Pid:       4242,
+		Comm:      "a (weird) name",
+		State:     "S",
+		Ppid:      1,
+		Flags:     4194560,
+		Minflt:    1130,
+		Majflt:    2,
+		Utime:     17,
+		Stime:     5,
+		Priority:  20,
+		Nice:      0,
+		StartTime: 3478,
+	}
+	if stat != expected {
+		t.Errorf("Expected %+v and got %+v", expected, stat)
+	}
+
+	for _, invalid := range []string{"", "4242 (name S 1", "4242 (name) S 1 2 3"} {
+		if _, err := ParseStatFile([]byte(invalid)); err == nil {
+			t.Errorf("An error should have been returned when parsing %q", invalid)
+		}
+	}
+}
+
+// The boot time is the same every time it's read.
+func TestBootTime(t *testing.T) {
+	first, err := BootTime()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if again, err := BootTime(); err != nil || !again.Equal(first) {
+			t.Fatalf("The system booted at %v and then at %v, %v", first, again, err)
+		}
+	}
+
+	defer func(root string) { ProcRoot = root }(ProcRoot)
+	ProcRoot = t.TempDir()
+	for contents, expected := range map[string]int64{
+		"cpu  1 2 3 4\nbtime 1600000000\nprocesses 42\n": 1600000000,
+		"cpu  1 2 3 4\nbtime x\n":                        0,
+		"cpu  1 2 3 4\n":                                 0,
+	} {
+		if err := ioutil.WriteFile(filepath.Join(ProcRoot, "stat"), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		boot, err := BootTime()
+		if expected != 0 && (err != nil || boot.Unix() != expected) || expected == 0 && err == nil {
+			t.Errorf("Expected the boot time %d from %q, got %v, %v", expected, contents, boot, err)
+		}
+	}
+}
+
+func FuzzParseMapsFileEntry(f *testing.F) {
+	f.Add("7fb8faf65000-7fb8faf66000 rw-p 00023000 08:01 922969                     /lib/x86_64-linux-gnu/ld-2.19.so")
+	f.Add("7fb8faf66000-7fb8faf67000 rw-p 00000000 00:00 0")
+	f.Add("7fff231a6000-7fff231c7000 rw-p 00000000 00:00 0          [stack]")
+	f.Add("ffffffffff600000-ffffffffff601000 --xp 00000000 00:00 0                  [vsyscall]")
+	f.Fuzz(func(t *testing.T, line string) {
+		entry, err := ParseMapsFileEntry(line)
+		if err != nil {
+			return
+		}
+		if entry.Start >= entry.End || len(entry.Permissions) != 4 {
+			t.Errorf("Invalid entry %+v parsed from %q", entry, line)
+		}
+	})
+}
+
+func FuzzParseSmapsFile(f *testing.F) {
+	f.Add("7fb8faf65000-7fb8faf66000 rw-p 00023000 08:01 922969 /lib/ld.so\nSize: 4 kB\nRss: 4 kB\n" +
+		"Anonymous: 4 kB\nVmFlags: rd wr mr mw me ac\n")
+	f.Add("7fff231a6000-7fff231c7000 rw-p 00000000 00:00 0 [stack]\nSwap: 0 kB\nShared_Dirty:\t12 kB\n")
+	f.Fuzz(func(t *testing.T, contents string) {
+		entries, err := ParseSmapsFile(strings.NewReader(contents))
+		if err != nil {
+			return
+		}
+		for _, entry := range entries {
+			if entry.Start >= entry.End || len(entry.Permissions) != 4 {
+				t.Errorf("Invalid entry %+v parsed from %q", entry, contents)
+			}
+		}
+	})
+}
+
+func FuzzParseStatFile(f *testing.F) {
+	f.Add([]byte("42 (cat) R 1 42 42 0 -1 4194304 96 0 0 0 0 0 0 0 20 0 1 0 123 8192 200 18446744073709551615"))
+	f.Add([]byte("7 (a) b) (c) S 1 7 7 0 -1 0 1 2 3 4 5 6 0 0 20 0 1 0 99 0 0"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		ParseStatFile(data)
+	})
+}
@@ -0,0 +1,36 @@
+package common
+
+import (
+	"math"
+	"math/bits"
+	"testing"
+)
+
+func TestMemFileOffset(t *testing.T) {
+	if bits.UintSize != 64 {
+		t.Skip("Every address of a 32 bit process is an offset")
+	}
+	type read struct {
+		address uintptr
+		size    int
+	}
+	top := uintptr(math.MaxInt64)
+
+	for _, valid := range []read{{0, 0}, {0x1000, 0x1000}, {0x7ffffffff000 - 0x1000, 0x1000}, {top - 0xfff, 0x1000},
+		{top, 1}} {
+
+		offset, err := MemFileOffset(valid.address, valid.size)
+		if err != nil || offset != int64(valid.address) {
+			t.Errorf("Reading %d bytes at %x gave offset %x (%v)", valid.size, valid.address, offset, err)
+		}
+	}
+
+	// The kernel's addresses, like the vsyscall page, aren't offsets of /proc/PID/mem.
+	for _, invalid := range []read{{0x1000, -1}, {top - 0xfff, 0x1001}, {top + 1, 1},
+		{^uintptr(0) &^ 0x9fffff, 0x1000}} {
+
+		if _, err := MemFileOffset(invalid.address, invalid.size); err == nil {
+			t.Errorf("Reading %d bytes at %x should fail", invalid.size, invalid.address)
+		}
+	}
+}
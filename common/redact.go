@@ -0,0 +1,110 @@
+package common
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Redacted replaces the secrets removed by a Redactor.
+const Redacted = "[REDACTED]"
+
+// ArgvRule redacts the arguments that match a regexp.
+type ArgvRule struct {
+	// Pattern matches the arguments to redact. If it has a group only its first group is redacted, otherwise the whole
+	// argument is.
+	Pattern *regexp.Regexp
+	// Next redacts the whole argument after the matching one instead, for flags whose value is a separate argument.
+	Next bool
+}
+
+// Redactor removes secrets from arguments and environments when they are serialized. It never changes the slices it
+// redacts, it returns redacted copies.
+type Redactor struct {
+	// Env is called with the key and value of every environment variable. If it returns true, the variable is
+	// serialized with the value it returns instead of its own. If it's nil no variable is redacted.
+	Env func(key, value string) (string, bool)
+	// Argv are the rules applied to the arguments.
+	Argv []ArgvRule
+}
+
+// NoRedaction serializes arguments and environments as they are.
+var NoRedaction = &Redactor{}
+
+// The rules of the DefaultRedactor.
+var (
+	defaultSecretVar = regexp.MustCompile(`^AWS_|_(TOKEN|PASSWORD|PASSWD|SECRET)$`)
+	defaultArgvRules = []ArgvRule{
+		{Pattern: regexp.MustCompile(`(?i)^--?(?:password|passwd|token|secret|api[-_]?key)=(.*)$`)},
+		{Pattern: regexp.MustCompile(`(?i)^--?(?:password|passwd|token|secret|api[-_]?key)$`), Next: true},
+	}
+)
+
+// DefaultRedactor returns the Redactor used to serialize arguments and environments when no other is chosen. It
+// redacts:
+//   - the AWS_* variables, and the *_TOKEN, *_PASSWORD, *_PASSWD and *_SECRET ones.
+//   - the values of the --password, --passwd, --token, --secret and --api-key flags, with one or two dashes, given as
+//     --flag=value or as --flag value.
+func DefaultRedactor() *Redactor {
+	return &Redactor{
+		Env: func(key, value string) (string, bool) {
+			if defaultSecretVar.MatchString(strings.ToUpper(key)) {
+				return Redacted, true
+			}
+			return value, false
+		},
+		Argv: defaultArgvRules,
+	}
+}
+
+// RedactArgv returns a copy of argv with the secrets redacted. A nil r is the DefaultRedactor.
+func (r *Redactor) RedactArgv(argv []string) []string {
+	if r == nil {
+		r = DefaultRedactor()
+	}
+	redacted := make([]string, len(argv))
+	for i := range argv {
+		redacted[i] = r.redactArg(argv, i)
+	}
+	return redacted
+}
+
+// RedactEnvp returns a copy of envp, whose entries are KEY=value, with the secrets redacted. A nil r is the
+// DefaultRedactor.
+func (r *Redactor) RedactEnvp(envp []string) []string {
+	if r == nil {
+		r = DefaultRedactor()
+	}
+	redacted := make([]string, len(envp))
+	for i, variable := range envp {
+		redacted[i] = variable
+		key, value, ok := strings.Cut(variable, "=")
+		if !ok || r.Env == nil {
+			continue
+		}
+		if replacement, redact := r.Env(key, value); redact {
+			redacted[i] = key + "=" + replacement
+		}
+	}
+	return redacted
+}
+
+func (r *Redactor) redactArg(argv []string, i int) string {
+	for _, rule := range r.Argv {
+		if rule.Next {
+			if i > 0 && rule.Pattern.MatchString(argv[i-1]) {
+				return Redacted
+			}
+			continue
+		}
+
+		match := rule.Pattern.FindStringSubmatchIndex(argv[i])
+		if match == nil {
+			continue
+		}
+		if len(match) < 4 || match[2] == -1 {
+			return Redacted
+		}
+		return argv[i][:match[2]] + Redacted + argv[i][match[3]:]
+	}
+	return argv[i]
+}
@@ -0,0 +1,27 @@
+package common
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRedactor(t *testing.T) {
+	var r *Redactor
+	argv := []string{"db", "--password=hunter2", "-Token", "t0k3n", "--api_key=", "--tokens=x", "-v"}
+	expected := []string{"db", "--password=" + Redacted, "-Token", Redacted, "--api_key=" + Redacted, "--tokens=x", "-v"}
+	if redacted := r.RedactArgv(argv); !reflect.DeepEqual(redacted, expected) {
+		t.Errorf("Expected %q, got %q", expected, redacted)
+	}
+	if argv[1] != "--password=hunter2" {
+		t.Errorf("The arguments were changed: %q", argv)
+	}
+
+	envp := []string{"HOME=/root", "aws_region=eu", "DB_PASSWD=x", "NOVALUE"}
+	expected = []string{"HOME=/root", "aws_region=" + Redacted, "DB_PASSWD=" + Redacted, "NOVALUE"}
+	if redacted := r.RedactEnvp(envp); !reflect.DeepEqual(redacted, expected) {
+		t.Errorf("Expected %q, got %q", expected, redacted)
+	}
+	if redacted := NoRedaction.RedactEnvp(envp); !reflect.DeepEqual(redacted, envp) {
+		t.Errorf("NoRedaction redacted %q", redacted)
+	}
+}
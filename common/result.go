@@ -0,0 +1,101 @@
+package common
+
+import (
+	"errors"
+	"sort"
+)
+
+// Most of masche's functions return (result, harderror, softerrors):
+//   - A harderror means that the operation failed, and the result is the zero value of its type.
+//   - Softerrors report problems that didn't prevent getting a result, like a region that couldn't be read. They are
+//     returned even along with a harderror, as they may explain it.
+// The helpers in this file make it easy to follow these rules.
+
+// Softerrors accumulates the softerrors of an operation.
+type Softerrors []error
+
+// Add appends the given errors, ignoring the nil ones.
+func (s *Softerrors) Add(errs ...error) {
+	for _, err := range errs {
+		if err != nil {
+			*s = append(*s, err)
+		}
+	}
+}
+
+// Result returns result, harderror and softerrors following the rules above: if harderror isn't nil, the zero value
+// of T is returned instead of result.
+func Result[T any](result T, harderror error, softerrors []error) (T, error, []error) {
+	if harderror != nil {
+		var zero T
+		return zero, harderror, softerrors
+	}
+	return result, nil, softerrors
+}
+
+// ErrProcessNotFound and ErrPermissionDenied are matched by the errors of the processes that don't exist, and of the
+// ones the current process isn't allowed to access. They are defined here so the errors of every platform, like the
+// ones of the C code, can match them. The process package exports them too.
+var (
+	ErrProcessNotFound  = errors.New("no such process")
+	ErrPermissionDenied = errors.New("permission denied")
+)
+
+// LocatedError is an error about a process, or about an address of its memory.
+type LocatedError struct {
+	Pid     int
+	Address uintptr
+	Err     error
+}
+
+func (e *LocatedError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *LocatedError) Unwrap() error {
+	return e.Err
+}
+
+// Location returns the process and address e is about.
+func (e *LocatedError) Location() (pid int, address uintptr) {
+	return e.Pid, e.Address
+}
+
+// Locator is implemented by the errors that know which process, and optionally which address, they are about.
+type Locator interface {
+	Location() (pid int, address uintptr)
+}
+
+// SortSoftErrors sorts softerrors by the process and then by the address they are about, so the same problems are
+// always reported in the same order. Errors wrapping a Locator are located by it; the rest go first, in the order they
+// were found.
+func SortSoftErrors(softerrors []error) {
+	type located struct {
+		err     error
+		known   bool
+		pid     int
+		address uintptr
+	}
+	sorted := make([]located, len(softerrors))
+	for i, err := range softerrors {
+		sorted[i].err = err
+		var l Locator
+		if errors.As(err, &l) {
+			sorted[i].known = true
+			sorted[i].pid, sorted[i].address = l.Location()
+		}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		switch {
+		case a.known != b.known:
+			return !a.known
+		case a.pid != b.pid:
+			return a.pid < b.pid
+		}
+		return a.address < b.address
+	})
+	for i := range sorted {
+		softerrors[i] = sorted[i].err
+	}
+}
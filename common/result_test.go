@@ -0,0 +1,56 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// pidError is a Locator that isn't a LocatedError.
+type pidError int
+
+func (e pidError) Error() string {
+	return fmt.Sprintf("process %d", int(e))
+}
+
+func (e pidError) Location() (pid int, address uintptr) {
+	return int(e), 0
+}
+
+func TestSortSoftErrors(t *testing.T) {
+	first, second := errors.New("first"), errors.New("second")
+	sorted := []error{
+		first,
+		second,
+		pidError(1),
+		&LocatedError{Pid: 1, Address: 0x1000, Err: errors.New("1 at 1000")},
+		fmt.Errorf("wrapped: %w", &LocatedError{Pid: 1, Address: 0x2000, Err: errors.New("1 at 2000")}),
+		&LocatedError{Pid: 2, Err: errors.New("2")},
+		pidError(10),
+	}
+
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 20; i++ {
+		shuffled := append([]error(nil), sorted...)
+		r.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+		// The unlocated errors keep the order they were found in.
+		if indexOf(shuffled, second) < indexOf(shuffled, first) {
+			continue
+		}
+		SortSoftErrors(shuffled)
+		if !reflect.DeepEqual(shuffled, sorted) {
+			t.Fatalf("Expected %v, got %v", sorted, shuffled)
+		}
+	}
+}
+
+func indexOf(errs []error, target error) int {
+	for i, err := range errs {
+		if err == target {
+			return i
+		}
+	}
+	return -1
+}
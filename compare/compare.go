@@ -0,0 +1,312 @@
+// Package compare reports the differences between two processes, like the same service built two different ways.
+package compare
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/polyverse/masche/aslrreport"
+	"github.com/polyverse/masche/listlibs"
+	"github.com/polyverse/masche/memaccess"
+	"github.com/polyverse/masche/memsearch"
+	"github.com/polyverse/masche/process"
+)
+
+// Classes of memory regions, see Classify.
+const (
+	ClassExecutable = "executable"
+	ClassLibrary    = "library"
+	ClassHeap       = "heap"
+	ClassStack      = "stack"
+	ClassAnonymous  = "anonymous"
+	// ClassSpecial are the other regions provided by the kernel, like [vdso].
+	ClassSpecial = "special"
+)
+
+// Aspects of the processes that are compared, see Difference.
+const (
+	AspectExecutable = "executable"
+	AspectModule     = "module"
+	AspectClass      = "class"
+	AspectRWX        = "rwx"
+	AspectBase       = "base"
+	AspectPattern    = "pattern"
+)
+
+// Options modifies the behaviour of Processes. Its zero value compares everything but patterns, strictly.
+type Options struct {
+	// Patterns are searched in both processes, and the amount of matches of each one is compared.
+	Patterns []memsearch.Pattern
+	// PatternNames name the patterns in the differences. Patterns without a name are called by their index.
+	PatternNames []string
+	// Search are the options of the pattern search.
+	Search memsearch.SearchOptions
+
+	// IgnoreBases doesn't report the differences of base addresses: the ASLR slides and the stack and heap
+	// placements.
+	IgnoreBases bool
+	// IgnoreExecutableName doesn't report that the executables have different names.
+	IgnoreExecutableName bool
+	// SizeTolerance is how much the totals of a region class can differ without being reported, relative to the
+	// biggest of them. For example, 0.1 ignores differences of up to 10%.
+	SizeTolerance float64
+}
+
+// Profile are the compared facts of a process.
+type Profile struct {
+	Pid        int    `json:"pid"`
+	Executable string `json:"executable"`
+	// Modules are the names of the loaded libraries, without their paths, sorted.
+	Modules []string `json:"modules"`
+	// Classes are the bytes of memory of each region class.
+	Classes map[string]uint64 `json:"classes"`
+	// RWX are the regions that are writable and executable at the same time.
+	RWX []memaccess.MemoryRegion `json:"rwx"`
+	// Bases are the base addresses reported by aslrreport. They are missing where it's not implemented.
+	Bases map[string]uintptr `json:"bases,omitempty"`
+	// Matches is the amount of matches of each pattern.
+	Matches []int `json:"matches,omitempty"`
+}
+
+// Difference is something that two processes don't have in common.
+type Difference struct {
+	Aspect string `json:"aspect"`
+	// Item is what differs within the aspect, like a module or class name. It's empty if there's only one thing to
+	// compare, like for AspectExecutable.
+	Item string `json:"item,omitempty"`
+	// A and B describe the item in each process. An empty one means that the process doesn't have it at all, which
+	// is a capability present in only one of them.
+	A string `json:"a"`
+	B string `json:"b"`
+}
+
+func (d Difference) String() string {
+	describe := func(s string) string {
+		if s == "" {
+			return "absent"
+		}
+		return s
+	}
+	item := d.Aspect
+	if d.Item != "" {
+		item += " " + d.Item
+	}
+	return fmt.Sprintf("%s: %s vs %s", item, describe(d.A), describe(d.B))
+}
+
+// Comparison is the result of Processes.
+type Comparison struct {
+	A           Profile      `json:"a"`
+	B           Profile      `json:"b"`
+	Differences []Difference `json:"differences"`
+}
+
+// Processes compares a and b, and returns their profiles and the differences between them, grouped by aspect.
+func Processes(a, b process.Process, opts Options) (comparison Comparison, harderror error, softerrors []error) {
+	comparison.A, harderror, softerrors = GetProfile(a, opts)
+	if harderror != nil {
+		return Comparison{}, harderror, softerrors
+	}
+
+	var serrs []error
+	comparison.B, harderror, serrs = GetProfile(b, opts)
+	softerrors = append(softerrors, serrs...)
+	if harderror != nil {
+		return Comparison{}, harderror, softerrors
+	}
+
+	comparison.Differences = Profiles(comparison.A, comparison.B, opts)
+	return comparison, nil, softerrors
+}
+
+// GetProfile gets the profile of p. Only the patterns of opts are used.
+func GetProfile(p process.Process, opts Options) (profile Profile, harderror error, softerrors []error) {
+	profile.Pid = p.Pid()
+	profile.Executable, harderror, softerrors = p.Name()
+	if harderror != nil {
+		return Profile{}, harderror, softerrors
+	}
+	if exe, err := process.ProcessExe(p.Pid()); err == nil {
+		profile.Executable = exe
+	}
+
+	libraries, harderror, serrs := listlibs.ListLoadedLibraries(p)
+	softerrors = append(softerrors, serrs...)
+	if harderror != nil {
+		return Profile{}, harderror, softerrors
+	}
+	profile.Modules = moduleNames(libraries)
+
+	regions, harderror, serrs := memaccess.MemoryRegions(p)
+	softerrors = append(softerrors, serrs...)
+	if harderror != nil {
+		return Profile{}, harderror, softerrors
+	}
+	profile.Classes = make(map[string]uint64)
+	for _, region := range regions {
+		profile.Classes[Classify(region, profile.Executable)] += uint64(region.Size)
+		if region.Access&(memaccess.Writable|memaccess.Executable) == memaccess.Writable|memaccess.Executable {
+			profile.RWX = append(profile.RWX, region)
+		}
+	}
+
+	// The layout is just informative, some platforms can't report it.
+	report, err, serrs := aslrreport.Analyze([]process.Process{p})
+	softerrors = append(softerrors, serrs...)
+	if err != nil {
+		softerrors = append(softerrors, fmt.Errorf("Unable to get the layout of process %d (%v)", p.Pid(), err))
+	} else {
+		profile.Bases = report.Samples[0].Bases
+	}
+
+	if len(opts.Patterns) > 0 {
+		matches, _, harderror, serrs := memsearch.FindAll(p, 0, opts.Patterns, opts.Search)
+		softerrors = append(softerrors, serrs...)
+		if harderror != nil {
+			return Profile{}, harderror, softerrors
+		}
+		profile.Matches = make([]int, len(opts.Patterns))
+		for _, m := range matches {
+			profile.Matches[m.Pattern]++
+		}
+	}
+
+	return profile, nil, softerrors
+}
+
+// Classify returns the class of region in a process whose executable is exe.
+func Classify(region memaccess.MemoryRegion, exe string) string {
+	switch kind := region.Kind; {
+	case kind == "":
+		return ClassAnonymous
+	case kind == exe:
+		return ClassExecutable
+	case kind == "[heap]":
+		return ClassHeap
+	case strings.HasPrefix(kind, "[stack"):
+		return ClassStack
+	case strings.HasPrefix(kind, "["):
+		return ClassSpecial
+	}
+	return ClassLibrary
+}
+
+func moduleNames(libraries []string) []string {
+	seen := make(map[string]bool)
+	modules := make([]string, 0, len(libraries))
+	for _, library := range libraries {
+		name := filepath.Base(strings.TrimSuffix(library, " (deleted)"))
+		if !seen[name] {
+			seen[name] = true
+			modules = append(modules, name)
+		}
+	}
+	sort.Strings(modules)
+	return modules
+}
+
+// Profiles returns the differences between the profiles a and b, grouped by aspect.
+func Profiles(a, b Profile, opts Options) (differences []Difference) {
+	add := func(aspect, item, valueA, valueB string) {
+		if valueA != valueB {
+			differences = append(differences, Difference{Aspect: aspect, Item: item, A: valueA, B: valueB})
+		}
+	}
+
+	if !opts.IgnoreExecutableName {
+		add(AspectExecutable, "", filepath.Base(a.Executable), filepath.Base(b.Executable))
+	}
+
+	modulesA, modulesB := presence(a.Modules), presence(b.Modules)
+	for _, module := range union(modulesA, modulesB) {
+		add(AspectModule, module, modulesA[module], modulesB[module])
+	}
+
+	for _, class := range union(a.Classes, b.Classes) {
+		sizeA, sizeB := a.Classes[class], b.Classes[class]
+		if sizeA == 0 || sizeB == 0 || !withinTolerance(sizeA, sizeB, opts.SizeTolerance) {
+			add(AspectClass, class, formatSize(sizeA), formatSize(sizeB))
+		}
+	}
+
+	add(AspectRWX, "", formatRegions(a.RWX), formatRegions(b.RWX))
+
+	if !opts.IgnoreBases {
+		for _, component := range union(a.Bases, b.Bases) {
+			add(AspectBase, component, formatBase(a.Bases, component), formatBase(b.Bases, component))
+		}
+	}
+
+	for i := 0; i < len(a.Matches) || i < len(b.Matches); i++ {
+		name := fmt.Sprintf("%d", i)
+		if i < len(opts.PatternNames) && opts.PatternNames[i] != "" {
+			name = opts.PatternNames[i]
+		}
+		add(AspectPattern, name, formatCount(a.Matches, i), formatCount(b.Matches, i))
+	}
+
+	return differences
+}
+
+func presence(items []string) map[string]string {
+	present := make(map[string]string, len(items))
+	for _, item := range items {
+		present[item] = "present"
+	}
+	return present
+}
+
+// union returns the keys of a and b, sorted.
+func union[V any](a, b map[string]V) []string {
+	keys := make([]string, 0, len(a)+len(b))
+	for key := range a {
+		keys = append(keys, key)
+	}
+	for key := range b {
+		if _, ok := a[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func withinTolerance(a, b uint64, tolerance float64) bool {
+	return math.Abs(float64(a)-float64(b)) <= tolerance*math.Max(float64(a), float64(b))
+}
+
+func formatSize(size uint64) string {
+	if size == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d bytes", size)
+}
+
+func formatRegions(regions []memaccess.MemoryRegion) string {
+	if len(regions) == 0 {
+		return ""
+	}
+	var size uint64
+	for _, region := range regions {
+		size += uint64(region.Size)
+	}
+	return fmt.Sprintf("%d regions, %d bytes", len(regions), size)
+}
+
+func formatBase(bases map[string]uintptr, component string) string {
+	base, ok := bases[component]
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("0x%x", base)
+}
+
+func formatCount(counts []int, i int) string {
+	if i >= len(counts) || counts[i] == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d matches", counts[i])
+}
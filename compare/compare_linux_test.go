@@ -0,0 +1,129 @@
+package compare
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/polyverse/masche/memsearch"
+	"github.com/polyverse/masche/process"
+	"github.com/polyverse/masche/test"
+)
+
+var markerOptions = Options{
+	Patterns:      []memsearch.Pattern{{Bytes: []byte("MASCHEMK")}},
+	PatternNames:  []string{"marker"},
+	IgnoreBases:   true,
+	SizeTolerance: 0.1,
+}
+
+func openPid(t *testing.T, pid int) process.Process {
+	p, err, softerrors := process.OpenFromPid(pid)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func launchTestCase(t *testing.T, env ...string) process.Process {
+	for i := 0; i < len(env); i += 2 {
+		os.Setenv(env[i], env[i+1])
+		defer os.Unsetenv(env[i])
+	}
+	cmd, err := test.LaunchTestCaseAndWaitForInitialization()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cmd.Process.Kill(); cmd.Wait() })
+	return openPid(t, cmd.Process.Pid)
+}
+
+func compare(t *testing.T, a, b process.Process) Comparison {
+	comparison, err, softerrors := Processes(a, b, markerOptions)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return comparison
+}
+
+func TestSameBinary(t *testing.T) {
+	a, b := launchTestCase(t), launchTestCase(t)
+	defer a.Close()
+	defer b.Close()
+
+	comparison := compare(t, a, b)
+	if len(comparison.Differences) != 0 {
+		t.Errorf("Expected no differences, got %v", comparison.Differences)
+	}
+	if comparison.A.Pid != a.Pid() || comparison.A.Executable != test.GetTestCasePath() ||
+		comparison.A.Classes[ClassExecutable] == 0 || comparison.A.Classes[ClassHeap] == 0 ||
+		len(comparison.A.Matches) != 1 || comparison.A.Matches[0] == 0 {
+
+		t.Errorf("Unexpected profile %+v", comparison.A)
+	}
+
+	// Without tolerance, every base is reported, the ASLR slides included.
+	differences := Profiles(comparison.A, comparison.B, Options{})
+	for _, d := range differences {
+		if d.Aspect != AspectBase && d.Aspect != AspectClass {
+			t.Errorf("Unexpected difference %v", d)
+		}
+	}
+
+	data, err := json.Marshal(comparison)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Comparison
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded.A.Modules, comparison.A.Modules) || decoded.A.Matches[0] != comparison.A.Matches[0] {
+		t.Errorf("Unexpected JSON round trip %+v", decoded.A)
+	}
+}
+
+func TestDifferentModules(t *testing.T) {
+	preload := filepath.Join(filepath.Dir(test.GetTestCasePath()), "libpreload.so")
+	a, b := launchTestCase(t), launchTestCase(t, "LD_PRELOAD", preload)
+	defer a.Close()
+	defer b.Close()
+
+	expected := []Difference{{Aspect: AspectModule, Item: "libpreload.so", A: "", B: "present"}}
+	if comparison := compare(t, a, b); !reflect.DeepEqual(comparison.Differences, expected) {
+		t.Errorf("Expected differences %v, got %v", expected, comparison.Differences)
+	}
+}
+
+func TestDifferentBinary(t *testing.T) {
+	sleep, err := exec.LookPath("sleep")
+	if err != nil {
+		t.Skip("sleep(1) not found")
+	}
+	cmd := exec.Command(sleep, "60")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { cmd.Process.Kill(); cmd.Wait() }()
+
+	a, b := launchTestCase(t), openPid(t, cmd.Process.Pid)
+	defer a.Close()
+	defer b.Close()
+
+	comparison := compare(t, a, b)
+	found := make(map[string]Difference)
+	for _, d := range comparison.Differences {
+		found[d.Aspect+" "+d.Item] = d
+	}
+	if d := found[AspectExecutable+" "]; d.A != "test" || d.B != filepath.Base(comparison.B.Executable) {
+		t.Errorf("Expected the executables to differ, got %v", comparison.Differences)
+	}
+	if d := found[AspectPattern+" marker"]; d.A == "" || d.B != "" {
+		t.Errorf("Expected the marker only in the test case, got %v", comparison.Differences)
+	}
+}
@@ -0,0 +1,162 @@
+// Package cooperate lets processes that aren't dumpable, so their memory can only be read with CAP_SYS_PTRACE, become
+// dumpable for masche when it asks, without giving it more privileges. The target process links this package and
+// calls Listen, and masche calls SetDumpable with the same secret before reading it, and again after to restore it.
+//
+// The requests are authenticated with an HMAC of a challenge sent by the target, so only the holders of the secret
+// can change it, and a request can't be replayed. Only Linux processes can change if they are dumpable.
+package cooperate
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// Timeout is how long a request can take, from connecting to the reply.
+const Timeout = 5 * time.Second
+
+// ErrRefused is wrapped by the errors of SetDumpable when the target refused the request, like when the secret is
+// wrong.
+var ErrRefused = errors.New("the target refused the request")
+
+// Listener serves the requests to change if the process is dumpable on a unix socket.
+type Listener struct {
+	listener net.Listener
+	secret   []byte
+	done     chan struct{}
+}
+
+// Listen creates the unix socket path and serves the requests made with secret on it, until Close is called. Anyone
+// who can connect to the socket can try, so the secret should be long and random.
+func Listen(path string, secret []byte) (*Listener, error) {
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("An empty secret would let anyone change if the process is dumpable")
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to listen on %s (%v)", path, err)
+	}
+	l := &Listener{listener: listener, secret: append([]byte(nil), secret...), done: make(chan struct{})}
+	go l.serve()
+	return l, nil
+}
+
+// Close stops serving requests and removes the socket.
+func (l *Listener) Close() error {
+	close(l.done)
+	return l.listener.Close()
+}
+
+func (l *Listener) serve() {
+	for {
+		conn, err := l.listener.Accept()
+		if err != nil {
+			select {
+			case <-l.done:
+				return
+			default:
+			}
+			// Errors accepting a connection, like running out of file descriptors, don't stop the listener.
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+		go l.handle(conn)
+	}
+}
+
+// handle sends a challenge to conn, and runs the command that comes back if it's signed with the challenge.
+func (l *Listener) handle(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(Timeout))
+
+	nonce := make([]byte, 32)
+	if _, err := rand.Read(nonce); err != nil {
+		fmt.Fprintf(conn, "error Unable to make a challenge (%v)\n", err)
+		return
+	}
+	challenge := hex.EncodeToString(nonce)
+	if _, err := fmt.Fprintln(conn, challenge); err != nil {
+		return
+	}
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return
+	}
+	fields := strings.Fields(line)
+	if len(fields) != 3 {
+		fmt.Fprintln(conn, "error Malformed request")
+		return
+	}
+	command, mac := fields[0]+" "+fields[1], fields[2]
+	expected := sign(l.secret, challenge, command)
+	if given, err := hex.DecodeString(mac); err != nil || !hmac.Equal(given, expected) {
+		fmt.Fprintln(conn, "error Unauthorized")
+		return
+	}
+
+	switch command {
+	case "dumpable 1":
+		err = setDumpable(true)
+	case "dumpable 0":
+		err = setDumpable(false)
+	default:
+		err = fmt.Errorf("Unknown command %s", command)
+	}
+	if err != nil {
+		fmt.Fprintf(conn, "error %v\n", err)
+		return
+	}
+	fmt.Fprintln(conn, "ok")
+}
+
+// sign returns the HMAC of command in reply to challenge.
+func sign(secret []byte, challenge string, command string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(challenge + " " + command))
+	return mac.Sum(nil)
+}
+
+// SetDumpable asks the process listening on the unix socket path to become dumpable, or to stop being so, with the
+// secret it was given. The process is dumpable when SetDumpable returns without error.
+func SetDumpable(path string, secret []byte, dumpable bool) error {
+	conn, err := net.DialTimeout("unix", path, Timeout)
+	if err != nil {
+		return fmt.Errorf("Unable to connect to %s (%v)", path, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(Timeout))
+
+	r := bufio.NewReader(conn)
+	challenge, err := r.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("Unable to read the challenge of %s (%v)", path, err)
+	}
+	challenge = strings.TrimSpace(challenge)
+	if strings.HasPrefix(challenge, "error ") {
+		return fmt.Errorf("%s: %s (%w)", path, strings.TrimPrefix(challenge, "error "), ErrRefused)
+	}
+
+	command := "dumpable 0"
+	if dumpable {
+		command = "dumpable 1"
+	}
+	if _, err := fmt.Fprintf(conn, "%s %s\n", command, hex.EncodeToString(sign(secret, challenge, command))); err != nil {
+		return fmt.Errorf("Unable to send the request to %s (%v)", path, err)
+	}
+	reply, err := r.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("Unable to read the reply of %s (%v)", path, err)
+	}
+	if reply = strings.TrimSpace(reply); reply != "ok" {
+		return fmt.Errorf("%s: %s (%w)", path, strings.TrimPrefix(reply, "error "), ErrRefused)
+	}
+	return nil
+}
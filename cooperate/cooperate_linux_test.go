@@ -0,0 +1,145 @@
+package cooperate
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/polyverse/masche/process"
+	"github.com/polyverse/masche/test"
+)
+
+// The test binary runs as a cooperative target when these variables are set.
+const (
+	socketVariable = "MASCHE_COOPERATE_SOCKET"
+	secretVariable = "MASCHE_COOPERATE_SECRET"
+)
+
+func TestMain(m *testing.M) {
+	if socket := os.Getenv(socketVariable); socket != "" {
+		os.Exit(target(socket, []byte(os.Getenv(secretVariable))))
+	}
+	os.Exit(m.Run())
+}
+
+// target listens on socket and stops being dumpable, as a process guarding its secrets would, and closes its stdout
+// once initialized, as the test case. Processes of root also switch to the nobody user, so their memory is guarded
+// from other users.
+func target(socket string, secret []byte) int {
+	l, err := Listen(socket, secret)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer l.Close()
+	if os.Geteuid() == 0 {
+		if err := syscall.Setuid(65534); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	if err := setDumpable(false); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	os.Stdout.Close()
+	select {}
+}
+
+func dumpability(t *testing.T, pid int) process.Dumpability {
+	info, err := process.GetProcessInfo(pid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return (*info).GetDumpable()
+}
+
+// notDumpable tells if the soft errors of an access level say the process isn't dumpable.
+func notDumpable(softerrors []error) bool {
+	for _, softerror := range softerrors {
+		if errors.Is(softerror, process.ErrNotDumpable) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSetDumpable(t *testing.T) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		t.Fatal(err)
+	}
+	socket := filepath.Join(t.TempDir(), "cooperate.sock")
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = append(os.Environ(), socketVariable+"="+socket, secretVariable+"="+string(secret))
+	cmd.Stderr = os.Stderr
+	if err := test.StartAndWaitForInitialization(cmd); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+	pid := cmd.Process.Pid
+
+	if d := dumpability(t, pid); d != process.NotDumpable {
+		t.Fatalf("The target is %v before the request", d)
+	}
+	// Root reads the memory of processes that aren't dumpable, the rest can't even open them.
+	if p, err, _ := process.OpenFromPid(pid); err != nil {
+		if !errors.Is(err, process.ErrPermissionDenied) {
+			t.Errorf("Unexpected error opening the target %v", err)
+		}
+	} else {
+		level, err, softerrors := p.AccessLevel()
+		if err != nil || level != process.FullAccess && !notDumpable(softerrors) {
+			t.Errorf("The access level %v of the target doesn't say it isn't dumpable: %v %v", level, err,
+				softerrors)
+		}
+		p.Close()
+	}
+
+	if err := SetDumpable(socket, []byte("wrong secret"), true); !errors.Is(err, ErrRefused) {
+		t.Errorf("Expected a refusal of the wrong secret, got %v", err)
+	}
+	if d := dumpability(t, pid); d != process.NotDumpable {
+		t.Errorf("The wrong secret made the target %v", d)
+	}
+
+	if err := SetDumpable(socket, secret, true); err != nil {
+		t.Fatal(err)
+	}
+	if d := dumpability(t, pid); d != process.Dumpable {
+		t.Errorf("The target is %v after the request", d)
+	}
+	p, err, softerrors := process.OpenFromPid(pid)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if level, err, _ := p.AccessLevel(); err != nil || level != process.FullAccess {
+		t.Errorf("The target is dumpable, but its access level is %v (%v)", level, err)
+	}
+
+	if err := SetDumpable(socket, secret, false); err != nil {
+		t.Fatal(err)
+	}
+	if d := dumpability(t, pid); d != process.NotDumpable {
+		t.Errorf("The target is %v after restoring it", d)
+	}
+}
+
+func TestListenErrors(t *testing.T) {
+	if _, err := Listen(filepath.Join(t.TempDir(), "cooperate.sock"), nil); err == nil {
+		t.Error("Listened without a secret")
+	}
+	if _, err := Listen(filepath.Join(t.TempDir(), "missing", "cooperate.sock"), []byte("secret")); err == nil {
+		t.Error("Listened on a socket in a missing directory")
+	}
+	if err := SetDumpable(filepath.Join(t.TempDir(), "cooperate.sock"), []byte("secret"), true); err == nil {
+		t.Error("Made a missing target dumpable")
+	}
+}
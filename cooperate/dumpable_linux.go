@@ -0,0 +1,19 @@
+package cooperate
+
+import (
+	"syscall"
+)
+
+// prSetDumpable is PR_SET_DUMPABLE, which the syscall package lacks.
+const prSetDumpable = 4
+
+func setDumpable(dumpable bool) error {
+	value := uintptr(0)
+	if dumpable {
+		value = 1
+	}
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetDumpable, value, 0); errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+// +build windows darwin
+
+package cooperate
+
+import (
+	"fmt"
+)
+
+func setDumpable(dumpable bool) error {
+	return fmt.Errorf("Changing if a process is dumpable is not implemented on this platform")
+}
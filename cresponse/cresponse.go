@@ -20,6 +20,12 @@ func (err CError) Error() string {
 	return fmt.Sprintf("System error number %d: %s", err.number, err.description)
 }
 
+// Unwrap makes errors.Is(err, common.ErrPermissionDenied) and errors.Is(err, common.ErrProcessNotFound) true for the
+// error numbers of the platform that mean it.
+func (err CError) Unwrap() error {
+	return errorKinds[err.number]
+}
+
 // GetResponsesErrors returns the Go representation of the errors present in a C.reponse_t.
 //
 // NOTE: cgo types are private to each module, so exporting a function that expects a *C.response_t doesn't make sense,
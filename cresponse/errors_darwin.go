@@ -0,0 +1,9 @@
+package cresponse
+
+import "github.com/polyverse/masche/common"
+
+// errorKinds are the kern_return_t codes that tell that a process doesn't exist. task_for_pid fails with the generic
+// KERN_FAILURE when it's denied, which other calls return for unrelated failures, so it isn't mapped.
+var errorKinds = map[int]error{
+	4: common.ErrProcessNotFound, // KERN_INVALID_ARGUMENT
+}
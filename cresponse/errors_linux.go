@@ -0,0 +1,4 @@
+package cresponse
+
+// errorKinds is empty, as no C code returns responses on Linux.
+var errorKinds = map[int]error{}
@@ -0,0 +1,10 @@
+package cresponse
+
+import "github.com/polyverse/masche/common"
+
+// errorKinds are the error codes that tell that a process can't be accessed, or doesn't exist: OpenProcess fails with
+// ERROR_INVALID_PARAMETER for pids without a process.
+var errorKinds = map[int]error{
+	5:  common.ErrPermissionDenied, // ERROR_ACCESS_DENIED
+	87: common.ErrProcessNotFound,  // ERROR_INVALID_PARAMETER
+}
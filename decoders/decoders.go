@@ -0,0 +1,168 @@
+// Package decoders decodes well-known structures found in the memory of a process, like the headers of the executables
+// mapped in it and the bookkeeping of the dynamic loader and the thread library, so scan hits can be inspected without
+// decoding dumps by hand.
+package decoders
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/polyverse/masche/memaccess"
+	"github.com/polyverse/masche/process"
+)
+
+// DecoderID identifies one of the structures the package decodes.
+type DecoderID string
+
+const (
+	// ELF64Header decodes the header of a 64 bits ELF image into an elf.Header64.
+	ELF64Header DecoderID = "elf64-header"
+	// ELF64ProgramHeaders decodes the header of a 64 bits ELF image mapped from the start of its file, like the
+	// loader maps them, and the program headers it points to, into a []elf.Prog64.
+	ELF64ProgramHeaders DecoderID = "elf64-program-headers"
+	// PEHeaders decodes the DOS and NT headers of a PE image into a PEImageHeaders.
+	PEHeaders DecoderID = "pe-headers"
+	// LinkMap decodes an entry of the list of objects loaded by the glibc dynamic loader into a LinkMapEntry.
+	LinkMap DecoderID = "link-map"
+	// Pthread decodes the fields of a glibc x86-64 thread descriptor, the struct pthread that pthread_self returns,
+	// into a PthreadDescriptor.
+	Pthread DecoderID = "pthread"
+)
+
+// Decoded is a structure decoded from memory.
+type Decoded struct {
+	Decoder DecoderID `json:"decoder"`
+	Address uintptr   `json:"address"`
+	// Value is the decoded structure, of the type documented by the decoder.
+	Value interface{} `json:"value"`
+	// Diagnostics are the reasons to doubt that the memory holds the structure. A structure without them looks valid.
+	Diagnostics []string `json:"diagnostics,omitempty"`
+}
+
+// Valid tells if the decoded structure looks valid.
+func (d Decoded) Valid() bool {
+	return len(d.Diagnostics) == 0
+}
+
+// DecodeOptions modifies the behaviour of DecodeIn.
+type DecodeOptions struct {
+	// GlibcVersion is the version of glibc that made the structures, like "2.36". The layout of some of them, like
+	// the thread descriptors, depends on it. DecodeAt finds it in the process when it's empty.
+	GlibcVersion string
+}
+
+// decoder decodes the structure at address.
+type decoder func(r *reader, address uintptr, opts DecodeOptions) (value interface{}, diagnostics []string, err error)
+
+var decoderFuncs = map[DecoderID]decoder{
+	ELF64Header:         decodeELF64Header,
+	ELF64ProgramHeaders: decodeELF64ProgramHeaders,
+	PEHeaders:           decodePEHeaders,
+	LinkMap:             decodeLinkMap,
+	Pthread:             decodePthread,
+}
+
+// decoderArchs are the architectures whose structures the decoders that depend on them decode. The rest decode any
+// image, as they read the byte order and size of its words from it.
+var decoderArchs = map[DecoderID]func(process.TargetArch) bool{
+	LinkMap: func(t process.TargetArch) bool { return t.PointerSize == 8 && !t.BigEndian },
+	Pthread: func(t process.TargetArch) bool { return t.Arch == process.ArchX86_64 },
+}
+
+// DecodeAt decodes the structure identified by id at address in the memory of p. The harderror is only set if the
+// memory can't be read, the decoder doesn't exist, or it doesn't know the structure on the architecture of p, which
+// is a process.ArchError: memory that doesn't look like the structure is decoded anyway and explained in the
+// Diagnostics.
+func DecodeAt(p process.Process, address uintptr, id DecoderID) (decoded Decoded, harderror error,
+	softerrors []error) {
+
+	var opts DecodeOptions
+	if id == Pthread {
+		version, err := processGlibcVersion(p.Pid())
+		if err != nil {
+			softerrors = append(softerrors, fmt.Errorf("Unable to find the glibc version of process %d: %v",
+				p.Pid(), err))
+		}
+		opts.GlibcVersion = version
+	}
+
+	decoded, harderror, serrs := DecodeIn(memaccess.ProcessBackend(p), address, id, opts)
+	softerrors = append(softerrors, serrs...)
+	if harderror == nil && id == Pthread {
+		if tid := decoded.Value.(PthreadDescriptor).TID; tid != 0 && !threadExists(p.Pid(), int(tid)) {
+			decoded.Diagnostics = append(decoded.Diagnostics, fmt.Sprintf("Thread %d isn't a thread of process %d",
+				tid, p.Pid()))
+		}
+	}
+	return decoded, harderror, softerrors
+}
+
+// DecodeIn works as DecodeAt, but it decodes the memory of a MemoryBackend.
+func DecodeIn(b memaccess.MemoryBackend, address uintptr, id DecoderID, opts DecodeOptions) (decoded Decoded,
+	harderror error, softerrors []error) {
+
+	decode, ok := decoderFuncs[id]
+	if !ok {
+		return decoded, fmt.Errorf("Unknown decoder %q", id), nil
+	}
+	if supports, ok := decoderArchs[id]; ok {
+		target, harderror, softerrors := memaccess.BackendArch(b)
+		if harderror == nil {
+			harderror = process.CheckArch(b.Info().Pid, fmt.Sprintf("The %s decoder", id), target, supports)
+		}
+		if harderror != nil {
+			return decoded, harderror, softerrors
+		}
+	}
+	r := &reader{b: b}
+	value, diagnostics, harderror := decode(r, address, opts)
+	if harderror != nil {
+		return decoded, harderror, r.softerrors
+	}
+	return Decoded{Decoder: id, Address: address, Value: value, Diagnostics: diagnostics}, nil, r.softerrors
+}
+
+// nativeOrder is the byte order of the structures of glibc, which are decoded as they are on x86-64.
+var nativeOrder = binary.LittleEndian
+
+// reader reads typed values from a MemoryBackend. Every structure decoded is little endian, except ELF images that
+// say otherwise.
+type reader struct {
+	b          memaccess.MemoryBackend
+	softerrors []error
+}
+
+func (r *reader) read(address uintptr, buf []byte) error {
+	harderror, softerrors := r.b.ReadAt(address, buf)
+	r.softerrors = append(r.softerrors, softerrors...)
+	return harderror
+}
+
+// value reads the fixed size value v points to from address.
+func (r *reader) value(address uintptr, order binary.ByteOrder, v interface{}) error {
+	buf := make([]byte, binary.Size(v))
+	if err := r.read(address, buf); err != nil {
+		return err
+	}
+	return binary.Read(bytes.NewReader(buf), order, v)
+}
+
+// cString reads a NUL terminated string of up to 4KiB.
+func (r *reader) cString(address uintptr) (string, error) {
+	var s []byte
+	chunk := make([]byte, 256)
+	for len(s) < 4096 {
+		// Reads are aligned so they never cross into the next page, which may not be mapped.
+		n := 256 - int(address%256)
+		if err := r.read(address, chunk[:n]); err != nil {
+			return "", err
+		}
+		if i := bytes.IndexByte(chunk[:n], 0); i != -1 {
+			return string(append(s, chunk[:i]...)), nil
+		}
+		s = append(s, chunk[:n]...)
+		address += uintptr(n)
+	}
+	return "", fmt.Errorf("String at %x is too long", address)
+}
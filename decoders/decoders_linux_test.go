@@ -0,0 +1,130 @@
+package decoders
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/polyverse/masche/common"
+	"github.com/polyverse/masche/memaccess"
+	"github.com/polyverse/masche/process"
+	"github.com/polyverse/masche/test"
+)
+
+// dtDebug is the dynamic section entry that the loader sets to the address of its r_debug structure.
+const dtDebug = 21
+
+func TestDecodeTestCase(t *testing.T) {
+	cmd, err := test.LaunchTestCaseAndWaitForInitialization()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	p, err, softerrors := process.OpenFromPid(cmd.Process.Pid)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	f, err := elf.Open(test.GetTestCasePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	entries, err := common.ReadMapsFile(uint(p.Pid()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var base uintptr
+	for _, entry := range entries {
+		if entry.Path == test.GetTestCasePath() && entry.Offset == 0 {
+			base = entry.Start
+			break
+		}
+	}
+	if base == 0 {
+		t.Fatal("The test case isn't mapped")
+	}
+
+	// The header and the program headers are those of the file.
+	decoded, err, softerrors := DecodeAt(p, base, ELF64Header)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := decoded.Value.(elf.Header64)
+	if !decoded.Valid() || elf.Type(header.Type) != f.Type || elf.Machine(header.Machine) != f.Machine ||
+		int(header.Phnum) != len(f.Progs) {
+
+		t.Errorf("Unexpected header %+v (%v)", header, decoded.Diagnostics)
+	}
+	decoded, err, softerrors = DecodeAt(p, base, ELF64ProgramHeaders)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	progs := decoded.Value.([]elf.Prog64)
+	var dynamic uintptr
+	for i, prog := range progs {
+		if elf.ProgType(prog.Type) != f.Progs[i].Type || prog.Vaddr != f.Progs[i].Vaddr {
+			t.Errorf("Program header %d is %+v, expected %+v", i, prog, f.Progs[i].ProgHeader)
+		}
+		if elf.ProgType(prog.Type) == elf.PT_DYNAMIC {
+			dynamic = base + uintptr(prog.Vaddr)
+		}
+	}
+	if !decoded.Valid() || dynamic == 0 {
+		t.Fatalf("Expected valid program headers with a dynamic section, got %v", decoded.Diagnostics)
+	}
+
+	// The loader's list of objects starts with the test case, and has libc in it.
+	rMap := linkMapHead(t, p, dynamic)
+	var names []string
+	for address := rMap; address != 0 && len(names) < 64; {
+		decoded, err, softerrors = DecodeAt(p, address, LinkMap)
+		test.PrintSoftErrors(softerrors)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry := decoded.Value.(LinkMapEntry)
+		if len(names) == 0 && (entry.Name != "" || entry.Addr != uint64(base) || entry.Ld != uint64(dynamic) ||
+			entry.Prev != 0) {
+
+			t.Errorf("Unexpected first entry %+v", entry)
+		}
+		if len(names) > 0 && !decoded.Valid() {
+			t.Errorf("Entry %+v isn't valid: %v", entry, decoded.Diagnostics)
+		}
+		names = append(names, filepath.Base(entry.Name))
+		address = uintptr(entry.Next)
+	}
+	if len(names) < 3 || !strings.Contains(strings.Join(names, " "), "libc.so") {
+		t.Errorf("Unexpected objects %v", names)
+	}
+}
+
+// linkMapHead returns the first entry of the loader's list of objects, from the r_debug that the DT_DEBUG entry of
+// the dynamic section points to.
+func linkMapHead(t *testing.T, p process.Process, dynamic uintptr) uintptr {
+	buf := make([]byte, 16)
+	for address := dynamic; ; address += 16 {
+		if err, _ := memaccess.CopyMemory(p, address, buf); err != nil {
+			t.Fatal(err)
+		}
+		tag, value := binary.LittleEndian.Uint64(buf), binary.LittleEndian.Uint64(buf[8:])
+		if tag == uint64(elf.DT_NULL) {
+			t.Fatal("No DT_DEBUG in the dynamic section")
+		}
+		if tag == dtDebug {
+			// struct r_debug { int r_version; struct link_map *r_map; ... }
+			if err, _ := memaccess.CopyMemory(p, uintptr(value)+8, buf[:8]); err != nil {
+				t.Fatal(err)
+			}
+			return uintptr(binary.LittleEndian.Uint64(buf))
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package decoders
+
+import (
+	"debug/elf"
+	"debug/pe"
+	"encoding/binary"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/polyverse/masche/memaccess"
+	"github.com/polyverse/masche/memaccess/backendtest"
+	"github.com/polyverse/masche/process"
+)
+
+// staticBackend returns the memory of an x86-64 process, the architecture of the layouts of the test data.
+func staticBackend(t *testing.T, address uintptr, data []byte) memaccess.MemoryBackend {
+	b, err := memaccess.NewStaticBackend(memaccess.BackendInfo{Kind: "static", Pid: 42}, []memaccess.Segment{
+		{Region: memaccess.MemoryRegion{Address: address, Size: uint(len(data)), Access: memaccess.Readable},
+			Data: data}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.SetTargetArch(process.TargetOf(process.ArchX86_64))
+	return b
+}
+
+func TestDecodePEHeaders(t *testing.T) {
+	image := make([]byte, 0x400)
+	copy(image, "MZ")
+	binary.LittleEndian.PutUint32(image[lfanewOffset:], 0x80)
+	copy(image[0x80:], "PE\x00\x00")
+	file := pe.FileHeader{Machine: pe.IMAGE_FILE_MACHINE_AMD64, NumberOfSections: 3,
+		SizeOfOptionalHeader: uint16(binary.Size(pe.OptionalHeader64{}) - 8*binary.Size(pe.DataDirectory{}))}
+	optional := pe.OptionalHeader64{Magic: optionalHeader64Magic, AddressOfEntryPoint: 0x1234,
+		ImageBase: 0x140000000, NumberOfRvaAndSizes: 8}
+	optional.DataDirectory[1] = pe.DataDirectory{VirtualAddress: 0x2000, Size: 0x100}
+	optional.DataDirectory[12] = pe.DataDirectory{VirtualAddress: 0xdead, Size: 0xbeef}
+	buf := &sliceWriter{b: image[0x84:]}
+	binary.Write(buf, binary.LittleEndian, file)
+	binary.Write(buf, binary.LittleEndian, optional)
+
+	decoded, err, _ := DecodeIn(staticBackend(t, 0x10000, image), 0x10000, PEHeaders, DecodeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	headers := decoded.Value.(PEImageHeaders)
+	expected := optional
+	expected.DataDirectory[12] = pe.DataDirectory{}
+	if !decoded.Valid() || headers.DOS.Lfanew != 0x80 || headers.FileHeader != file ||
+		!reflect.DeepEqual(headers.OptionalHeader, &expected) {
+
+		t.Errorf("Unexpected headers %+v (%v)", headers, decoded.Diagnostics)
+	}
+
+	// The NT signature is checked.
+	copy(image[0x80:], "PX")
+	decoded, err, _ = DecodeIn(staticBackend(t, 0x10000, image), 0x10000, PEHeaders, DecodeOptions{})
+	if err != nil || decoded.Valid() {
+		t.Errorf("Expected diagnostics for a bad signature, got %+v (%v)", decoded, err)
+	}
+}
+
+// sliceWriter writes into a slice.
+type sliceWriter struct {
+	b []byte
+}
+
+func (w *sliceWriter) Write(p []byte) (int, error) {
+	n := copy(w.b, p)
+	w.b = w.b[n:]
+	return n, nil
+}
+
+func TestDecodePthread(t *testing.T) {
+	// The descriptor of a thread of glibc 2.36, at the top of its stack block.
+	const address = 0x7f0000801000 - 0x900
+	data := make([]byte, 0x900)
+	order := binary.LittleEndian
+	order.PutUint64(data[0:], address)
+	order.PutUint64(data[0x10:], address)
+	order.PutUint32(data[pthreadTIDOffset:], 4242)
+	order.PutUint64(data[0x690:], 0x7f0000000000)
+	order.PutUint64(data[0x698:], 0x801000)
+	order.PutUint64(data[0x6a0:], 0x1000)
+	b := staticBackend(t, address, data)
+
+	decoded, err, _ := DecodeIn(b, address, Pthread, DecodeOptions{GlibcVersion: "2.36"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := PthreadDescriptor{Self: address, TID: 4242, StackBlock: 0x7f0000000000, StackBlockSize: 0x801000,
+		GuardSize: 0x1000, GlibcVersion: "2.36"}
+	if !decoded.Valid() || decoded.Value != expected {
+		t.Errorf("Expected %+v, got %+v (%v)", expected, decoded.Value, decoded.Diagnostics)
+	}
+
+	// Only the thread id is known for other versions.
+	decoded, err, _ = DecodeIn(b, address, Pthread, DecodeOptions{GlibcVersion: "2.17"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Valid() || decoded.Value != (PthreadDescriptor{Self: address, TID: 4242}) {
+		t.Errorf("Expected only the thread id and a diagnostic, got %+v (%v)", decoded.Value, decoded.Diagnostics)
+	}
+
+	// A descriptor outside its stack block isn't valid.
+	decoded, err, _ = DecodeIn(b, address+8, Pthread, DecodeOptions{GlibcVersion: "2.36"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Valid() {
+		t.Errorf("Expected diagnostics decoding the wrong address, got %+v", decoded.Value)
+	}
+
+	if _, err, _ := DecodeIn(b, address, "malloc-chunk", DecodeOptions{}); err == nil {
+		t.Errorf("Expected an error for an unknown decoder")
+	}
+}
+
+// The structures of glibc are only decoded with the layouts of their architecture, while images describe themselves.
+func TestDecodeForeignArch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "core")
+	err := backendtest.WriteCoreDump(path, elf.EM_ARM, elf.ELFCLASS32, elf.ELFDATA2LSB, 0x10000, make([]byte, 0x1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	core, err := memaccess.OpenCoreDump(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer core.Close()
+
+	for _, id := range []DecoderID{LinkMap, Pthread} {
+		if _, err, _ := DecodeIn(core, 0x10000, id, DecodeOptions{GlibcVersion: "2.36"}); !errors.Is(err,
+			process.ErrArchMismatch) {
+			t.Errorf("Expected an architecture mismatch decoding %s, got %v", id, err)
+		}
+	}
+	if _, err, _ := DecodeIn(core, 0x10000, ELF64Header, DecodeOptions{}); err != nil {
+		t.Errorf("Unable to decode an image in the memory of another architecture (%v)", err)
+	}
+}
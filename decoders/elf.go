@@ -0,0 +1,95 @@
+package decoders
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+)
+
+// maxProgramHeaders bounds the program headers decoded, so a corrupt header doesn't make the decoder read megabytes.
+const maxProgramHeaders = 1024
+
+func decodeELF64Header(r *reader, address uintptr, opts DecodeOptions) (value interface{}, diagnostics []string,
+	err error) {
+
+	header, _, diagnostics, err := readELF64Header(r, address)
+	return header, diagnostics, err
+}
+
+// readELF64Header reads an ELF header and returns the byte order it says the image has.
+func readELF64Header(r *reader, address uintptr) (header elf.Header64, order binary.ByteOrder, diagnostics []string,
+	err error) {
+
+	var ident [elf.EI_NIDENT]byte
+	if err := r.read(address, ident[:]); err != nil {
+		return header, nil, nil, err
+	}
+	order = binary.ByteOrder(binary.LittleEndian)
+	switch elf.Data(ident[elf.EI_DATA]) {
+	case elf.ELFDATA2LSB:
+	case elf.ELFDATA2MSB:
+		order = binary.BigEndian
+	default:
+		diagnostics = append(diagnostics, fmt.Sprintf("Unknown data encoding %v", elf.Data(ident[elf.EI_DATA])))
+	}
+	if err := r.value(address, order, &header); err != nil {
+		return header, nil, nil, err
+	}
+
+	if !bytes.Equal(header.Ident[:4], []byte(elf.ELFMAG)) {
+		diagnostics = append(diagnostics, fmt.Sprintf("Bad magic %q", header.Ident[:4]))
+	}
+	if class := elf.Class(header.Ident[elf.EI_CLASS]); class != elf.ELFCLASS64 {
+		diagnostics = append(diagnostics, fmt.Sprintf("Class %v isn't ELFCLASS64", class))
+	}
+	if header.Version != uint32(elf.EV_CURRENT) {
+		diagnostics = append(diagnostics, fmt.Sprintf("Unknown version %d", header.Version))
+	}
+	if header.Ehsize != uint16(binary.Size(header)) {
+		diagnostics = append(diagnostics, fmt.Sprintf("Header size %d, expected %d", header.Ehsize,
+			binary.Size(header)))
+	}
+	if header.Phnum > 0 && header.Phentsize != uint16(binary.Size(elf.Prog64{})) {
+		diagnostics = append(diagnostics, fmt.Sprintf("Program header size %d, expected %d", header.Phentsize,
+			binary.Size(elf.Prog64{})))
+	}
+	return header, order, diagnostics, nil
+}
+
+func decodeELF64ProgramHeaders(r *reader, address uintptr, opts DecodeOptions) (value interface{},
+	diagnostics []string, err error) {
+
+	header, order, diagnostics, err := readELF64Header(r, address)
+	if err != nil {
+		return nil, nil, err
+	}
+	count := int(header.Phnum)
+	if count > maxProgramHeaders {
+		diagnostics = append(diagnostics, fmt.Sprintf("%d program headers, only the first %d are decoded", count,
+			maxProgramHeaders))
+		count = maxProgramHeaders
+	}
+	progs := make([]elf.Prog64, count)
+	if count == 0 {
+		return progs, diagnostics, nil
+	}
+	if err := r.value(address+uintptr(header.Phoff), order, progs); err != nil {
+		return nil, nil, fmt.Errorf("Unable to read the program headers at offset %x: %v", header.Phoff, err)
+	}
+
+	loads := 0
+	for i, prog := range progs {
+		if elf.ProgType(prog.Type) == elf.PT_LOAD {
+			loads++
+			if prog.Filesz > prog.Memsz {
+				diagnostics = append(diagnostics, fmt.Sprintf("Segment %d has more bytes in the file than in memory",
+					i))
+			}
+		}
+	}
+	if loads == 0 && elf.Type(header.Type) != elf.ET_REL && elf.Type(header.Type) != elf.ET_CORE {
+		diagnostics = append(diagnostics, "No loadable segments")
+	}
+	return progs, diagnostics, nil
+}
@@ -0,0 +1,170 @@
+package decoders
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// LinkMapEntry is an entry of the list of loaded objects of the glibc dynamic loader, a struct link_map. The list
+// starts at the r_map of the r_debug structure the loader points the DT_DEBUG entry of the dynamic section to.
+type LinkMapEntry struct {
+	// Addr is the difference between the addresses where the object is loaded and its virtual addresses.
+	Addr uint64 `json:"addr"`
+	// Name is the path of the object. It's empty for the executable.
+	Name string `json:"name"`
+	// Ld is the address of the dynamic section of the object.
+	Ld   uint64 `json:"ld"`
+	Next uint64 `json:"next"`
+	Prev uint64 `json:"prev"`
+}
+
+// linkMapWords are the fields of a struct link_map in order: l_addr, l_name, l_ld, l_next and l_prev.
+type linkMapWords [5]uint64
+
+func decodeLinkMap(r *reader, address uintptr, opts DecodeOptions) (value interface{}, diagnostics []string,
+	err error) {
+
+	var words linkMapWords
+	if err := r.value(address, nativeOrder, &words); err != nil {
+		return nil, nil, err
+	}
+	entry := LinkMapEntry{Addr: words[0], Ld: words[2], Next: words[3], Prev: words[4]}
+	if words[1] == 0 {
+		diagnostics = append(diagnostics, "No name")
+	} else if entry.Name, err = r.cString(uintptr(words[1])); err != nil {
+		diagnostics = append(diagnostics, fmt.Sprintf("Unreadable name at %x (%v)", words[1], err))
+	}
+	if entry.Ld == 0 {
+		diagnostics = append(diagnostics, "No dynamic section")
+	}
+
+	// The neighbours of the entry must point back to it.
+	if entry.Next != 0 {
+		var next linkMapWords
+		if err := r.value(uintptr(entry.Next), nativeOrder, &next); err != nil {
+			diagnostics = append(diagnostics, fmt.Sprintf("Unreadable next entry at %x (%v)", entry.Next, err))
+		} else if next[4] != uint64(address) {
+			diagnostics = append(diagnostics, fmt.Sprintf("The next entry points back to %x", next[4]))
+		}
+	}
+	if entry.Prev != 0 {
+		var prev linkMapWords
+		if err := r.value(uintptr(entry.Prev), nativeOrder, &prev); err != nil {
+			diagnostics = append(diagnostics, fmt.Sprintf("Unreadable previous entry at %x (%v)", entry.Prev, err))
+		} else if prev[3] != uint64(address) {
+			diagnostics = append(diagnostics, fmt.Sprintf("The previous entry points forward to %x", prev[3]))
+		}
+	}
+	return entry, diagnostics, nil
+}
+
+// PthreadDescriptor are the fields of a glibc thread descriptor that tell which thread it is and where its stack
+// is.
+type PthreadDescriptor struct {
+	// Self is the pointer to the descriptor stored at its start, which must be its own address.
+	Self uint64 `json:"self"`
+	TID  int32  `json:"tid"`
+	// StackBlock and StackBlockSize are the memory allocated for the stack of the thread, guard included. They are
+	// zero for the main thread, whose stack isn't allocated by glibc, and when the layout of the descriptor isn't
+	// known for the glibc version.
+	StackBlock     uint64 `json:"stackBlock,omitempty"`
+	StackBlockSize uint64 `json:"stackBlockSize,omitempty"`
+	GuardSize      uint64 `json:"guardSize,omitempty"`
+	// GlibcVersion is the version of glibc whose layout was used.
+	GlibcVersion string `json:"glibcVersion,omitempty"`
+}
+
+// pthreadLayout are the offsets of the fields of struct pthread for a range of glibc versions.
+type pthreadLayout struct {
+	min, max                          glibcVersion
+	stackBlock, stackBlockSize, guard uintptr
+}
+
+const (
+	// pthreadTIDOffset is the offset of the tid of a struct pthread on x86-64, which follows the tcbhead_t and the
+	// list of threads in every 2.x version.
+	pthreadTIDOffset = 0x2d0
+)
+
+// pthreadLayouts are the x86-64 layouts of the stack fields of struct pthread. Only layouts that were checked against
+// live threads are listed.
+var pthreadLayouts = []pthreadLayout{
+	{min: glibcVersion{2, 36}, max: glibcVersion{2, 36}, stackBlock: 0x690, stackBlockSize: 0x698, guard: 0x6a0},
+}
+
+func decodePthread(r *reader, address uintptr, opts DecodeOptions) (value interface{}, diagnostics []string,
+	err error) {
+
+	// The descriptor starts with a tcbhead_t { void *tcb; dtv_t *dtv; void *self; ... }, whose tcb and self point to
+	// the descriptor.
+	var head [3]uint64
+	if err := r.value(address, nativeOrder, &head); err != nil {
+		return nil, nil, err
+	}
+	descriptor := PthreadDescriptor{Self: head[0]}
+	if head[0] != uint64(address) || head[2] != uint64(address) {
+		diagnostics = append(diagnostics, fmt.Sprintf("The self pointers %x and %x don't point to the descriptor",
+			head[0], head[2]))
+	}
+	if err := r.value(address+pthreadTIDOffset, nativeOrder, &descriptor.TID); err != nil {
+		return nil, nil, err
+	}
+	if descriptor.TID <= 0 {
+		diagnostics = append(diagnostics, fmt.Sprintf("Invalid thread id %d", descriptor.TID))
+	}
+
+	version, err := parseGlibcVersion(opts.GlibcVersion)
+	if err != nil {
+		diagnostics = append(diagnostics, fmt.Sprintf("The stack fields aren't decoded: %v", err))
+		return descriptor, diagnostics, nil
+	}
+	for _, layout := range pthreadLayouts {
+		if version.less(layout.min) || layout.max.less(version) {
+			continue
+		}
+		var fields [3]uint64
+		for i, offset := range []uintptr{layout.stackBlock, layout.stackBlockSize, layout.guard} {
+			if err := r.value(address+offset, nativeOrder, &fields[i]); err != nil {
+				return nil, nil, err
+			}
+		}
+		descriptor.StackBlock, descriptor.StackBlockSize, descriptor.GuardSize = fields[0], fields[1], fields[2]
+		descriptor.GlibcVersion = opts.GlibcVersion
+		// glibc puts the descriptor of the threads it creates at the top of their stack block.
+		end := descriptor.StackBlock + descriptor.StackBlockSize
+		if descriptor.StackBlock != 0 && (descriptor.GuardSize > descriptor.StackBlockSize ||
+			uint64(address) < descriptor.StackBlock || uint64(address) >= end) {
+
+			diagnostics = append(diagnostics, fmt.Sprintf("The descriptor isn't in its stack block %x-%x",
+				descriptor.StackBlock, end))
+		}
+		return descriptor, diagnostics, nil
+	}
+	diagnostics = append(diagnostics, fmt.Sprintf("The stack fields aren't decoded: the layout of glibc %s is "+
+		"unknown", opts.GlibcVersion))
+	return descriptor, diagnostics, nil
+}
+
+// glibcVersion is a major and minor glibc version.
+type glibcVersion [2]int
+
+func (v glibcVersion) less(other glibcVersion) bool {
+	return v[0] < other[0] || v[0] == other[0] && v[1] < other[1]
+}
+
+func parseGlibcVersion(s string) (version glibcVersion, err error) {
+	if s == "" {
+		return version, fmt.Errorf("the glibc version is unknown")
+	}
+	parts := strings.SplitN(s, ".", 3)
+	if len(parts) < 2 {
+		return version, fmt.Errorf("invalid glibc version %q", s)
+	}
+	for i := range version {
+		if version[i], err = strconv.Atoi(parts[i]); err != nil {
+			return version, fmt.Errorf("invalid glibc version %q", s)
+		}
+	}
+	return version, nil
+}
@@ -0,0 +1,62 @@
+package decoders
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/polyverse/masche/common"
+)
+
+// libcFileName matches the names of the glibc libraries, which used to have their version in them.
+var libcFileName = regexp.MustCompile(`^libc(-(\d+\.\d+))?\.so`)
+
+// processGlibcVersion returns the version of the glibc mapped by the process. It's taken from the name of the library
+// or, when it doesn't have it, from the banner in the library.
+func processGlibcVersion(pid int) (version string, err error) {
+	entries, err := common.ReadMapsFile(uint(pid))
+	if err != nil {
+		return "", err
+	}
+	for _, entry := range entries {
+		m := libcFileName.FindStringSubmatch(filepath.Base(entry.Path))
+		if m == nil {
+			continue
+		}
+		if m[2] != "" {
+			return m[2], nil
+		}
+		// The library is read through the root of the process, which can be in another mount namespace.
+		data, err := ioutil.ReadFile(filepath.Join(common.ProcFilePath(uint(pid), "root"), entry.Path))
+		if err != nil {
+			return "", err
+		}
+		return bannerVersion(data)
+	}
+	return "", fmt.Errorf("No glibc is mapped")
+}
+
+// bannerVersion returns the version in the "GNU C Library ... release version 2.36." banner of glibc.
+func bannerVersion(data []byte) (string, error) {
+	marker := []byte("release version ")
+	i := bytes.Index(data, marker)
+	if i == -1 {
+		return "", fmt.Errorf("No version banner in glibc")
+	}
+	version := data[i+len(marker):]
+	end := bytes.IndexFunc(version, func(r rune) bool { return r != '.' && (r < '0' || r > '9') })
+	if end == -1 {
+		end = len(version)
+	}
+	return strings.TrimSuffix(string(version[:end]), "."), nil
+}
+
+func threadExists(pid int, tid int) bool {
+	_, err := os.Stat(common.ProcFilePath(uint(pid), filepath.Join("task", strconv.Itoa(tid))))
+	return err == nil
+}
@@ -0,0 +1,15 @@
+// +build windows darwin
+
+package decoders
+
+import (
+	"fmt"
+)
+
+func processGlibcVersion(pid int) (version string, err error) {
+	return "", fmt.Errorf("glibc is only found on Linux")
+}
+
+func threadExists(pid int, tid int) bool {
+	return true
+}
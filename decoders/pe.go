@@ -0,0 +1,111 @@
+package decoders
+
+import (
+	"debug/pe"
+	"encoding/binary"
+	"fmt"
+)
+
+// DOSHeader is the part of the DOS header of a PE image that matters to find its NT headers.
+type DOSHeader struct {
+	// Magic is "MZ".
+	Magic uint16 `json:"magic"`
+	// Lfanew is the offset of the NT headers from the start of the image.
+	Lfanew uint32 `json:"lfanew"`
+}
+
+// PEImageHeaders are the headers of a PE image.
+type PEImageHeaders struct {
+	DOS DOSHeader `json:"dos"`
+	// Signature is "PE\0\0".
+	Signature  uint32        `json:"signature"`
+	FileHeader pe.FileHeader `json:"fileHeader"`
+	// OptionalHeader is a *pe.OptionalHeader32 or a *pe.OptionalHeader64, depending on its magic, or nil if the
+	// magic is unknown. The data directories beyond NumberOfRvaAndSizes are zero.
+	OptionalHeader interface{} `json:"optionalHeader"`
+}
+
+const (
+	dosMagic    = 0x5a4d     // MZ
+	peSignature = 0x00004550 // PE\0\0
+	// lfanewOffset is the offset of e_lfanew in the DOS header, and maxLfanew the largest one accepted: real images
+	// have their NT headers in their first page.
+	lfanewOffset = 0x3c
+	maxLfanew    = 0x1000
+
+	optionalHeader32Magic = 0x10b
+	optionalHeader64Magic = 0x20b
+)
+
+func decodePEHeaders(r *reader, address uintptr, opts DecodeOptions) (value interface{}, diagnostics []string,
+	err error) {
+
+	var headers PEImageHeaders
+	if err := r.value(address, binary.LittleEndian, &headers.DOS.Magic); err != nil {
+		return nil, nil, err
+	}
+	if err := r.value(address+lfanewOffset, binary.LittleEndian, &headers.DOS.Lfanew); err != nil {
+		return nil, nil, err
+	}
+	if headers.DOS.Magic != dosMagic {
+		diagnostics = append(diagnostics, fmt.Sprintf("Bad DOS magic %04x", headers.DOS.Magic))
+	}
+	if headers.DOS.Lfanew > maxLfanew || headers.DOS.Lfanew%4 != 0 {
+		diagnostics = append(diagnostics, fmt.Sprintf("Implausible NT headers offset %x", headers.DOS.Lfanew))
+		return headers, diagnostics, nil
+	}
+
+	nt := address + uintptr(headers.DOS.Lfanew)
+	if err := r.value(nt, binary.LittleEndian, &headers.Signature); err != nil {
+		return nil, nil, err
+	}
+	if err := r.value(nt+4, binary.LittleEndian, &headers.FileHeader); err != nil {
+		return nil, nil, err
+	}
+	if headers.Signature != peSignature {
+		diagnostics = append(diagnostics, fmt.Sprintf("Bad NT signature %08x", headers.Signature))
+	}
+
+	optional := nt + 4 + uintptr(binary.Size(headers.FileHeader))
+	var magic uint16
+	if err := r.value(optional, binary.LittleEndian, &magic); err != nil {
+		return nil, nil, err
+	}
+	var directories *[16]pe.DataDirectory
+	var rvas uint32
+	var fixedSize int
+	switch magic {
+	case optionalHeader32Magic:
+		header := &pe.OptionalHeader32{}
+		if err := r.value(optional, binary.LittleEndian, header); err != nil {
+			return nil, nil, err
+		}
+		directories, rvas, headers.OptionalHeader = &header.DataDirectory, header.NumberOfRvaAndSizes, header
+		fixedSize = binary.Size(header) - binary.Size(header.DataDirectory)
+	case optionalHeader64Magic:
+		header := &pe.OptionalHeader64{}
+		if err := r.value(optional, binary.LittleEndian, header); err != nil {
+			return nil, nil, err
+		}
+		directories, rvas, headers.OptionalHeader = &header.DataDirectory, header.NumberOfRvaAndSizes, header
+		fixedSize = binary.Size(header) - binary.Size(header.DataDirectory)
+	default:
+		diagnostics = append(diagnostics, fmt.Sprintf("Unknown optional header magic %04x", magic))
+		return headers, diagnostics, nil
+	}
+
+	if rvas > uint32(len(directories)) {
+		diagnostics = append(diagnostics, fmt.Sprintf("%d data directories, at most %d expected", rvas,
+			len(directories)))
+		rvas = uint32(len(directories))
+	}
+	for i := int(rvas); i < len(directories); i++ {
+		directories[i] = pe.DataDirectory{}
+	}
+	expected := fixedSize + int(rvas)*binary.Size(pe.DataDirectory{})
+	if int(headers.FileHeader.SizeOfOptionalHeader) != expected {
+		diagnostics = append(diagnostics, fmt.Sprintf("Optional header size %d, expected %d",
+			headers.FileHeader.SizeOfOptionalHeader, expected))
+	}
+	return headers, diagnostics, nil
+}
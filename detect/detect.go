@@ -0,0 +1,164 @@
+// Package detect looks for processes whose code isn't the code of the executable they claim to run, like the ones
+// hollowed by malware, which starts a legitimate executable and replaces its code in memory.
+//
+// Hollowing combines signals into a verdict. Each signal has legitimate causes, which are turned off for the
+// executables known to have them with a Suppression:
+//
+//   - EntryInAnonymousMemory: runtimes that copy their code to anonymous huge pages at startup (HHVM, and others
+//     tuned with hugepage text remapping) and self-extracting executables, like the ones packed with UPX.
+//   - TextPrivateDirty: self-patching runtimes, JITs that compile into or patch their own image, binary
+//     instrumentation and hot patching tools (DynamoRIO, Frida), and executables with text relocations. JITs that
+//     only generate code in anonymous memory, like the JVM or V8, don't trigger any signal.
+//   - HeaderMismatch: packers that rewrite the headers they unpack, and the same self-patching tools.
+//   - ExecutableDeleted: executables replaced by an upgrade while they run. It's too common to tell hollowing apart
+//     on its own, so its weight never reaches DefaultThreshold.
+package detect
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/polyverse/masche/process"
+)
+
+// Signal is a kind of evidence of hollowing.
+type Signal string
+
+const (
+	// HeaderMismatch is an ELF header or program header table in memory, at the base of the main module, that isn't
+	// the one of the executable on disk: another entry point, section layout or build-id.
+	HeaderMismatch Signal = "headerMismatch"
+	// TextPrivateDirty is code of the main module that was all written to, so none of it is the executable's.
+	TextPrivateDirty Signal = "textPrivateDirty"
+	// EntryInAnonymousMemory is an entry point in anonymous executable memory instead of in the main module.
+	EntryInAnonymousMemory Signal = "entryInAnonymousMemory"
+	// ExecutableDeleted is an executable that was deleted, or replaced, after the process started.
+	ExecutableDeleted Signal = "executableDeleted"
+)
+
+// signalWeights are the probabilities that a process showing each signal was hollowed.
+var signalWeights = map[Signal]float64{
+	HeaderMismatch:         0.8,
+	TextPrivateDirty:       0.6,
+	EntryInAnonymousMemory: 0.6,
+	ExecutableDeleted:      0.2,
+}
+
+// DefaultThreshold is the confidence from which a process is considered hollowed when Options.Threshold is 0.
+const DefaultThreshold = 0.5
+
+// Evidence is a signal shown by a process.
+type Evidence struct {
+	Signal Signal `json:"signal"`
+	// Detail describes what was observed, like the entry points on disk and in memory.
+	Detail string  `json:"detail"`
+	Weight float64 `json:"weight"`
+	// Suppressed is the reason of the suppression that discounted the evidence, if any.
+	Suppressed string `json:"suppressed,omitempty"`
+}
+
+func (e Evidence) String() string {
+	if e.Suppressed != "" {
+		return fmt.Sprintf("%s: %s (suppressed: %s)", e.Signal, e.Detail, e.Suppressed)
+	}
+	return fmt.Sprintf("%s: %s", e.Signal, e.Detail)
+}
+
+// Verdict is the result of Hollowing.
+type Verdict struct {
+	Pid        int    `json:"pid"`
+	Executable string `json:"executable"`
+	// Hollowed is true if the confidence reached the threshold.
+	Hollowed bool `json:"hollowed"`
+	// Confidence is the probability that the process was hollowed given the evidence that wasn't suppressed, which
+	// are taken as independent: 1 minus the product of 1 minus each weight.
+	Confidence float64    `json:"confidence"`
+	Evidence   []Evidence `json:"evidence,omitempty"`
+}
+
+func (v Verdict) String() string {
+	evidence := make([]string, len(v.Evidence))
+	for i, e := range v.Evidence {
+		evidence[i] = e.String()
+	}
+	verdict := "not hollowed"
+	if v.Hollowed {
+		verdict = "hollowed"
+	}
+	return fmt.Sprintf("Process %d (%s) %s, confidence %.2f: [%s]", v.Pid, v.Executable, verdict, v.Confidence,
+		strings.Join(evidence, ", "))
+}
+
+// Suppression discounts signals known to have a legitimate cause in some executables.
+type Suppression struct {
+	// Exe are path.Match globs of the executables suppressed. Every executable is if there are none.
+	Exe []string `json:"exe,omitempty"`
+	// Signals are the signals suppressed. Every signal is if there are none.
+	Signals []Signal `json:"signals,omitempty"`
+	// Reason is reported in the suppressed evidence.
+	Reason string `json:"reason"`
+}
+
+func (s Suppression) matches(exe string, signal Signal) bool {
+	exeMatches := len(s.Exe) == 0
+	for _, glob := range s.Exe {
+		if matched, _ := path.Match(glob, exe); matched {
+			exeMatches = true
+			break
+		}
+	}
+	if !exeMatches {
+		return false
+	}
+	for _, suppressed := range s.Signals {
+		if suppressed == signal {
+			return true
+		}
+	}
+	return len(s.Signals) == 0
+}
+
+// Options tune Hollowing.
+type Options struct {
+	Suppressions []Suppression
+	// Threshold is the confidence from which a process is considered hollowed, DefaultThreshold if 0.
+	Threshold float64
+}
+
+// Hollowing tells if p was hollowed, from the evidence gathered about its main module. The evidence is reported even if
+// it doesn't reach the threshold, for the reports to show it. Evidence that can't be gathered, like headers that can't
+// be read, is skipped and reported as a softerror.
+func Hollowing(p process.Process, opts Options) (verdict Verdict, harderror error, softerrors []error) {
+	exe, evidence, harderror, softerrors := gatherEvidence(p)
+	if harderror != nil {
+		return verdict, harderror, softerrors
+	}
+	return judge(p.Pid(), exe, evidence, opts), nil, softerrors
+}
+
+// judge weighs evidence, which is modified, into a verdict.
+func judge(pid int, exe string, evidence []Evidence, opts Options) Verdict {
+	threshold := opts.Threshold
+	if threshold == 0 {
+		threshold = DefaultThreshold
+	}
+
+	innocence := 1.0
+	for i := range evidence {
+		e := &evidence[i]
+		e.Weight = signalWeights[e.Signal]
+		for _, s := range opts.Suppressions {
+			if s.matches(exe, e.Signal) {
+				e.Suppressed = s.Reason
+				break
+			}
+		}
+		if e.Suppressed == "" {
+			innocence *= 1 - e.Weight
+		}
+	}
+	confidence := 1 - innocence
+	return Verdict{Pid: pid, Executable: exe, Hollowed: confidence >= threshold, Confidence: confidence,
+		Evidence: evidence}
+}
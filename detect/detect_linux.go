@@ -0,0 +1,233 @@
+package detect
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/polyverse/masche/common"
+	"github.com/polyverse/masche/memaccess"
+	"github.com/polyverse/masche/process"
+)
+
+// deletedSuffix is appended by the kernel to the target of /proc/PID/exe when the executable was deleted.
+const deletedSuffix = " (deleted)"
+
+// atEntry is the auxv entry holding the entry point of the executable.
+const atEntry = 9
+
+// ntGNUBuildID is the type of the note holding the build-id.
+const ntGNUBuildID = 3
+
+func gatherEvidence(p process.Process) (exe string, evidence []Evidence, harderror error, softerrors []error) {
+	pid := p.Pid()
+	exePath := common.ProcFilePath(uint(pid), "exe")
+	link, err := os.Readlink(exePath)
+	if err != nil {
+		return "", nil, fmt.Errorf("Unable to read the executable of process %d (%v)", pid, err), nil
+	}
+	exe = strings.TrimSuffix(link, deletedSuffix)
+	if exe != link {
+		evidence = append(evidence, Evidence{Signal: ExecutableDeleted,
+			Detail: fmt.Sprintf("The executable %s was deleted", exe)})
+	}
+
+	entries, err := common.ReadSmapsFile(uint(pid))
+	if err != nil {
+		return exe, nil, err, nil
+	}
+
+	// /proc/PID/exe opens the file the process runs even if it was deleted.
+	f, err := os.Open(exePath)
+	if err != nil {
+		return exe, nil, err, nil
+	}
+	defer f.Close()
+	file, err := elf.NewFile(f)
+	if err != nil {
+		return exe, nil, fmt.Errorf("Unable to parse the executable %s of process %d (%v)", exe, pid, err), nil
+	}
+
+	var base uintptr
+	var code, copiedCode uint64
+	for _, entry := range entries {
+		if strings.TrimSuffix(entry.Path, deletedSuffix) != exe {
+			continue
+		}
+		if base == 0 && entry.Offset == 0 {
+			base = entry.Start
+		}
+		if strings.Contains(entry.Permissions, "x") {
+			code += entry.Rss
+			copiedCode += entry.Anonymous
+		}
+	}
+	// Pages written to are private copies, which smaps counts as anonymous. Private_Dirty would also count the pages of
+	// an executable written to disk that weren't flushed yet.
+	if code > 0 && copiedCode == code {
+		evidence = append(evidence, Evidence{Signal: TextPrivateDirty,
+			Detail: fmt.Sprintf("All the %d resident bytes of code of %s are private copies", code, exe)})
+	}
+
+	if base == 0 {
+		softerrors = append(softerrors, &common.LocatedError{Pid: pid,
+			Err: fmt.Errorf("The headers of %s are not mapped", exe)})
+	} else {
+		details, err, serrs := compareHeaders(p, f, file, base)
+		softerrors = append(softerrors, serrs...)
+		if err != nil {
+			softerrors = append(softerrors, &common.LocatedError{Pid: pid, Address: base,
+				Err: fmt.Errorf("Unable to compare the headers of %s: %v", exe, err)})
+		}
+		for _, detail := range details {
+			evidence = append(evidence, Evidence{Signal: HeaderMismatch, Detail: detail})
+		}
+	}
+
+	entry, err := entryPoint(pid, file)
+	if err != nil {
+		softerrors = append(softerrors, &common.LocatedError{Pid: pid, Err: err})
+	} else {
+		for _, e := range entries {
+			if e.Start <= entry && entry < e.End && e.Path == "" && strings.Contains(e.Permissions, "x") {
+				evidence = append(evidence, Evidence{Signal: EntryInAnonymousMemory,
+					Detail: fmt.Sprintf("The entry point 0x%x is in anonymous memory 0x%x-0x%x", entry, e.Start,
+						e.End)})
+			}
+		}
+	}
+	return exe, evidence, nil, softerrors
+}
+
+// compareHeaders compares the ELF header, the program header table and the build-id of the executable in f, which
+// file is parsed from, with the ones in memory at base. It returns a detail for each difference.
+func compareHeaders(p process.Process, f io.ReaderAt, file *elf.File, base uintptr) (details []string,
+	harderror error, softerrors []error) {
+
+	if file.Class != elf.ELFCLASS64 {
+		return nil, fmt.Errorf("Only 64 bit executables are supported, not %v", file.Class), nil
+	}
+
+	var onDisk elf.Header64
+	if err := binary.Read(io.NewSectionReader(f, 0, int64(binary.Size(onDisk))), file.ByteOrder,
+		&onDisk); err != nil {
+
+		return nil, err, nil
+	}
+	headerSize := int(onDisk.Phoff) + int(onDisk.Phnum)*int(onDisk.Phentsize)
+	if headerSize > os.Getpagesize() || int(onDisk.Phoff) < binary.Size(onDisk) {
+		return nil, fmt.Errorf("The program header table isn't in the first page"), nil
+	}
+	disk := make([]byte, headerSize)
+	if _, err := f.ReadAt(disk, 0); err != nil {
+		return nil, err, nil
+	}
+	memory := make([]byte, headerSize)
+	harderror, softerrors = memaccess.CopyMemory(p, base, memory)
+	if harderror != nil {
+		return nil, harderror, softerrors
+	}
+
+	var inMemory elf.Header64
+	binary.Read(bytes.NewReader(memory), file.ByteOrder, &inMemory)
+	if inMemory.Entry != onDisk.Entry {
+		details = append(details, fmt.Sprintf("The entry point is 0x%x on disk and 0x%x in memory", onDisk.Entry,
+			inMemory.Entry))
+	}
+	inMemory.Entry = onDisk.Entry
+	var header bytes.Buffer
+	binary.Write(&header, file.ByteOrder, &inMemory)
+	if !bytes.Equal(header.Bytes(), disk[:header.Len()]) {
+		details = append(details, "The ELF header differs from the one on disk")
+	}
+	if !bytes.Equal(memory[onDisk.Phoff:], disk[onDisk.Phoff:]) {
+		details = append(details, "The program header table differs from the one on disk")
+	}
+
+	diskID, memoryID, err := buildIDs(p, file, base)
+	if err != nil {
+		softerrors = append(softerrors, err)
+	} else if !bytes.Equal(diskID, memoryID) {
+		details = append(details, fmt.Sprintf("The build-id is %x on disk and %x in memory", diskID, memoryID))
+	}
+	return details, nil, softerrors
+}
+
+// buildIDs returns the build-id of file, and the one of the image loaded at base. Both are nil if file has none.
+func buildIDs(p process.Process, file *elf.File, base uintptr) (disk, memory []byte, err error) {
+	// The lowest segment is loaded at base.
+	var bias uintptr
+	for _, prog := range file.Progs {
+		if prog.Type == elf.PT_LOAD && prog.Off == 0 {
+			bias = base - uintptr(prog.Vaddr)
+			break
+		}
+	}
+
+	for _, prog := range file.Progs {
+		if prog.Type != elf.PT_NOTE {
+			continue
+		}
+		notes, err := ioutil.ReadAll(prog.Open())
+		if err != nil {
+			return nil, nil, err
+		}
+		id, offset := buildID(notes, file.ByteOrder)
+		if id == nil {
+			continue
+		}
+		memory = make([]byte, len(id))
+		if err, _ := memaccess.CopyMemory(p, bias+uintptr(prog.Vaddr)+uintptr(offset), memory); err != nil {
+			return nil, nil, err
+		}
+		return id, memory, nil
+	}
+	return nil, nil, nil
+}
+
+// buildID returns the descriptor of the NT_GNU_BUILD_ID note in notes, and its offset in them.
+func buildID(notes []byte, order binary.ByteOrder) (id []byte, offset int) {
+	align := func(n uint32) int { return int((n + 3) &^ 3) }
+	for i := 0; i+12 <= len(notes); {
+		namesz, descsz, typ := order.Uint32(notes[i:]), order.Uint32(notes[i+4:]), order.Uint32(notes[i+8:])
+		desc := i + 12 + align(namesz)
+		if desc+int(descsz) > len(notes) {
+			break
+		}
+		if typ == ntGNUBuildID && string(notes[i+12:i+12+int(namesz)]) == "GNU\x00" {
+			return notes[desc : desc+int(descsz)], desc
+		}
+		i = desc + align(descsz)
+	}
+	return nil, 0
+}
+
+// entryPoint returns the entry point of the process with pid from its auxiliary vector, where the kernel wrote where
+// it loaded the entry point of the executable.
+func entryPoint(pid int, file *elf.File) (uintptr, error) {
+	auxv, err := ioutil.ReadFile(common.ProcFilePath(uint(pid), "auxv"))
+	if err != nil {
+		return 0, err
+	}
+	wordSize := 8
+	if file.Class == elf.ELFCLASS32 {
+		wordSize = 4
+	}
+	for i := 0; i+2*wordSize <= len(auxv); i += 2 * wordSize {
+		var key, value uint64
+		if wordSize == 8 {
+			key, value = file.ByteOrder.Uint64(auxv[i:]), file.ByteOrder.Uint64(auxv[i+wordSize:])
+		} else {
+			key, value = uint64(file.ByteOrder.Uint32(auxv[i:])), uint64(file.ByteOrder.Uint32(auxv[i+wordSize:]))
+		}
+		if key == atEntry {
+			return uintptr(value), nil
+		}
+	}
+	return 0, fmt.Errorf("No AT_ENTRY entry in the auxiliary vector")
+}
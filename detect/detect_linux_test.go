@@ -0,0 +1,77 @@
+package detect
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/polyverse/masche/process"
+	"github.com/polyverse/masche/test"
+)
+
+// hollowing launches a copy of the test case with args, and returns the verdict about it. The copy is deleted if
+// deleted is true.
+func hollowing(t *testing.T, deleted bool, args ...string) Verdict {
+	exe, err := test.CopyTestCase(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(exe, args...)
+	if err := test.StartAndWaitForInitialization(cmd); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+	if deleted {
+		if err := os.Remove(exe); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p, err, softerrors := process.OpenFromPid(cmd.Process.Pid)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	verdict, err, softerrors := Hollowing(p, Options{})
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verdict.Pid != cmd.Process.Pid || verdict.Executable != exe {
+		t.Error("Unexpected process in verdict", verdict)
+	}
+	return verdict
+}
+
+// signals returns the signals of the evidence of verdict.
+func signals(verdict Verdict) map[Signal]bool {
+	s := make(map[Signal]bool)
+	for _, e := range verdict.Evidence {
+		s[e.Signal] = true
+	}
+	return s
+}
+
+func TestHollowing(t *testing.T) {
+	verdict := hollowing(t, false)
+	if verdict.Hollowed || len(verdict.Evidence) != 0 {
+		t.Error("The test case shouldn't look hollowed", verdict)
+	}
+
+	// The test case replaces its code with an anonymous copy and changes its entry point.
+	verdict = hollowing(t, true, "--hollow")
+	s := signals(verdict)
+	if !verdict.Hollowed || !s[HeaderMismatch] || !s[EntryInAnonymousMemory] || !s[ExecutableDeleted] ||
+		s[TextPrivateDirty] {
+
+		t.Error("Unexpected verdict for the hollowed test case", verdict)
+	}
+
+	verdict = hollowing(t, false, "--dirty-text")
+	s = signals(verdict)
+	if !verdict.Hollowed || len(s) != 1 || !s[TextPrivateDirty] {
+		t.Error("Unexpected verdict for the test case with patched code", verdict)
+	}
+}
@@ -0,0 +1,13 @@
+// +build windows darwin
+
+package detect
+
+import (
+	"fmt"
+
+	"github.com/polyverse/masche/process"
+)
+
+func gatherEvidence(p process.Process) (exe string, evidence []Evidence, harderror error, softerrors []error) {
+	return "", nil, fmt.Errorf("The hollowing detection is not implemented on this platform"), nil
+}
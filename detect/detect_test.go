@@ -0,0 +1,61 @@
+package detect
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestJudge(t *testing.T) {
+	evidence := func() []Evidence {
+		return []Evidence{{Signal: EntryInAnonymousMemory, Detail: "entry"}, {Signal: ExecutableDeleted,
+			Detail: "deleted"}}
+	}
+
+	verdict := judge(1, "/opt/app", evidence(), Options{})
+	if !verdict.Hollowed || math.Abs(verdict.Confidence-0.68) > 1e-9 || verdict.Evidence[0].Weight != 0.6 {
+		t.Error("Unexpected verdict", verdict)
+	}
+
+	// Runtimes that remap their code to huge pages run from anonymous memory.
+	hugepages := Suppression{Exe: []string{"/opt/*"}, Signals: []Signal{EntryInAnonymousMemory},
+		Reason: "code remapped to huge pages"}
+	verdict = judge(1, "/opt/app", evidence(), Options{Suppressions: []Suppression{hugepages}})
+	if verdict.Hollowed || math.Abs(verdict.Confidence-0.2) > 1e-9 ||
+		verdict.Evidence[0].Suppressed != hugepages.Reason || verdict.Evidence[1].Suppressed != "" {
+
+		t.Error("Unexpected suppressed verdict", verdict)
+	}
+	if !strings.Contains(verdict.String(), "(suppressed: code remapped to huge pages)") {
+		t.Error("Unexpected description", verdict)
+	}
+
+	verdict = judge(1, "/usr/bin/app", evidence(), Options{Suppressions: []Suppression{hugepages}})
+	if !verdict.Hollowed {
+		t.Error("The suppression should only apply to /opt", verdict)
+	}
+
+	verdict = judge(1, "/opt/app", evidence(), Options{Threshold: 0.9})
+	if verdict.Hollowed {
+		t.Error("The verdict should be below the threshold", verdict)
+	}
+
+	verdict = judge(1, "/opt/app", nil, Options{})
+	if verdict.Hollowed || verdict.Confidence != 0 {
+		t.Error("A process without evidence shouldn't be hollowed", verdict)
+	}
+}
+
+func TestVerdictJSON(t *testing.T) {
+	verdict := judge(7, "/bin/app", []Evidence{{Signal: HeaderMismatch, Detail: "entry"}}, Options{})
+	data, err := json.Marshal(verdict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"pid":7,"executable":"/bin/app","hollowed":true,"confidence":0.8,` +
+		`"evidence":[{"signal":"headerMismatch","detail":"entry","weight":0.8}]}`
+	if string(data) != expected {
+		t.Errorf("Got %s, expected %s", data, expected)
+	}
+}
@@ -0,0 +1,117 @@
+// This program dumps the heap of a process to a file: the regions compare.Classify considers heap or, where the
+// platform doesn't name the heap, the anonymous writable ones.
+//
+//	./dumpheap -pid 1234 -o heap.bin
+//
+// It prints the regions it dumped, as text or as JSON, and exits with 2 on errors.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/polyverse/masche/compare"
+	"github.com/polyverse/masche/memaccess"
+	"github.com/polyverse/masche/process"
+)
+
+var (
+	pid     = flag.Int("pid", 0, "process id to dump")
+	out     = flag.String("o", "", "file where the heap is written")
+	jsonOut = flag.Bool("json", false, "print the dumped regions as JSON")
+)
+
+// result is the JSON output.
+type result struct {
+	Regions []memaccess.MemoryRegion `json:"regions"`
+	Bytes   uint64                   `json:"bytes"`
+}
+
+func fail(err error) {
+	log.Print(err)
+	os.Exit(2)
+}
+
+func main() {
+	flag.Parse()
+	log.SetFlags(0)
+	if *pid == 0 || *out == "" {
+		fail(fmt.Errorf("The -pid and -o flags are required"))
+	}
+
+	p, harderror, softerrors := process.OpenFromPid(*pid)
+	if harderror != nil {
+		fail(harderror)
+	}
+	defer p.Close()
+	for _, e := range softerrors {
+		log.Print(e)
+	}
+
+	regions, harderror, softerrors := memaccess.MemoryRegions(p)
+	for _, e := range softerrors {
+		log.Print(e)
+	}
+	if harderror != nil {
+		fail(harderror)
+	}
+
+	f, err := os.Create(*out)
+	if err != nil {
+		fail(err)
+	}
+	defer f.Close()
+
+	var res result
+	for _, region := range heapRegions(regions) {
+		buf := make([]byte, region.Size)
+		if harderror, _ := memaccess.CopyMemory(p, region.Address, buf); harderror != nil {
+			log.Printf("Skipping %v: %v", region, harderror)
+			continue
+		}
+		if _, err := f.Write(buf); err != nil {
+			fail(err)
+		}
+		res.Regions = append(res.Regions, region)
+		res.Bytes += uint64(region.Size)
+	}
+	if err := f.Close(); err != nil {
+		fail(err)
+	}
+
+	if *jsonOut {
+		if err := json.NewEncoder(os.Stdout).Encode(res); err != nil {
+			fail(err)
+		}
+		return
+	}
+	for _, region := range res.Regions {
+		fmt.Println(region)
+	}
+	fmt.Printf("dumped %d bytes from %d regions to %s\n", res.Bytes, len(res.Regions), *out)
+}
+
+// heapRegions returns the readable heap regions, or the anonymous writable ones if none is named as heap.
+func heapRegions(regions []memaccess.MemoryRegion) (heap []memaccess.MemoryRegion) {
+	var anonymous []memaccess.MemoryRegion
+	for _, region := range regions {
+		if region.Access&memaccess.Readable == 0 {
+			continue
+		}
+		switch compare.Classify(region, "") {
+		case compare.ClassHeap:
+			heap = append(heap, region)
+		case compare.ClassAnonymous:
+			if region.Access&memaccess.Writable != 0 {
+				anonymous = append(anonymous, region)
+			}
+		}
+	}
+	if len(heap) == 0 {
+		return anonymous
+	}
+	return heap
+}
@@ -0,0 +1,51 @@
+package examples
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestWatchValue(t *testing.T) {
+	pid := launch(t)
+	// The second marker changes its magic to XASCHEMK on SIGUSR1.
+	mutable := findMarkers(t, pid)[1]
+
+	cmd := exec.Command(binaries["watchvalue"], "-pid", pid, "-addr", fmt.Sprintf("0x%x", mutable.Address),
+		"-interval", "10ms", "-changes", "1", "-timeout", "10s")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	lines := bufio.NewScanner(stdout)
+	if !lines.Scan() || !strings.HasPrefix(lines.Text(), "initial") || !strings.Contains(lines.Text(), `"MASCHEMK"`) {
+		t.Fatalf("Unexpected first line %q", lines.Text())
+	}
+	target, _ := os.FindProcess(mutable.Pid)
+	target.Signal(syscall.SIGUSR1)
+	if !lines.Scan() || !strings.HasPrefix(lines.Text(), "changed") || !strings.Contains(lines.Text(), `"XASCHEMK"`) {
+		t.Errorf("Unexpected change line %q", lines.Text())
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("watchvalue failed: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Error("watchvalue didn't exit after the change")
+	}
+}
@@ -0,0 +1,186 @@
+// The examples are built and run against the test case, the way users run them.
+package examples
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/polyverse/masche/test"
+)
+
+var examples = []string{"findpattern", "dumpheap", "watchvalue", "liblist", "memsearch", "pgrep", "qualify"}
+
+// binaries are the built examples, by name.
+var binaries = map[string]string{}
+
+func TestMain(m *testing.M) {
+	os.Exit(func() int {
+		dir, err := ioutil.TempDir("", "masche-examples")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer os.RemoveAll(dir)
+
+		for _, name := range examples {
+			binary := filepath.Join(dir, name)
+			if runtime.GOOS == "windows" {
+				binary += ".exe"
+			}
+			out, err := exec.Command("go", "build", "-o", binary, "./"+name).CombinedOutput()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Building %s: %v\n%s", name, err, out)
+				return 1
+			}
+			binaries[name] = binary
+		}
+		return m.Run()
+	}())
+}
+
+// run runs an example, and returns its stdout and exit code.
+func run(t *testing.T, name string, args ...string) (stdout string, code int) {
+	cmd := exec.Command(binaries[name], args...)
+	var out bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, os.Stderr
+	err := cmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return out.String(), exitErr.ExitCode()
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	return out.String(), 0
+}
+
+func launch(t *testing.T) (pid string) {
+	cmd, err := test.LaunchTestCaseAndWaitForInitialization()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cmd.Process.Kill(); cmd.Wait() })
+	return fmt.Sprint(cmd.Process.Pid)
+}
+
+// match is a memsearch.Match as a JSON consumer sees it.
+type match struct {
+	Pid     int     `json:"pid"`
+	Address uintptr `json:"address"`
+	Pattern int     `json:"pattern"`
+}
+
+// findMarkers returns the matches of the magic of the markers of the test case, found with findpattern.
+func findMarkers(t *testing.T, pid string) []match {
+	out, code := run(t, "findpattern", "-pid", pid, "-string", "MASCHEMK", "-json")
+	if code != 0 {
+		t.Fatalf("findpattern exited with %d", code)
+	}
+	var result struct {
+		Matches []match `json:"matches"`
+		Stats   struct {
+			Matches int `json:"matches"`
+		} `json:"stats"`
+	}
+	if err := json.Unmarshal([]byte(out), &result); err != nil {
+		t.Fatalf("Invalid JSON report %q (%v)", out, err)
+	}
+	if len(result.Matches) != 4 || result.Stats.Matches != 4 {
+		t.Fatalf("Expected the 4 markers of the test case, got %+v", result)
+	}
+	return result.Matches
+}
+
+func TestFindPattern(t *testing.T) {
+	pid := launch(t)
+
+	out, code := run(t, "findpattern", "-pid", pid, "-string", "Un dia vi una vaca vestida de uniforme",
+		"-short-circuit", "global")
+	if code != 0 || !strings.Contains(out, "pattern 0 in") || !strings.Contains(out, "1 matches in") {
+		t.Errorf("findpattern exited with %d and printed:\n%s", code, out)
+	}
+
+	findMarkers(t, pid)
+
+	if out, code := run(t, "findpattern", "-pid", pid, "-hex", "00"+strings.Repeat("ff", 31)+"00"); code != 1 {
+		t.Errorf("findpattern of a missing pattern exited with %d and printed:\n%s", code, out)
+	}
+	if _, code := run(t, "findpattern", "-pid", pid, "-string", "x", "-short-circuit", "sometimes"); code != 2 {
+		t.Errorf("findpattern with an invalid short circuit exited with %d", code)
+	}
+}
+
+func TestDumpHeap(t *testing.T) {
+	pid := launch(t)
+	dump := filepath.Join(t.TempDir(), "heap.bin")
+
+	out, code := run(t, "dumpheap", "-pid", pid, "-o", dump, "-json")
+	if code != 0 {
+		t.Fatalf("dumpheap exited with %d", code)
+	}
+	var result struct {
+		Regions []json.RawMessage `json:"regions"`
+		Bytes   int64             `json:"bytes"`
+	}
+	if err := json.Unmarshal([]byte(out), &result); err != nil || len(result.Regions) == 0 {
+		t.Fatalf("Invalid JSON report %q (%v)", out, err)
+	}
+
+	data, err := ioutil.ReadFile(dump)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(data)) != result.Bytes || bytes.Count(data, []byte("MASCHEMK")) != 4 {
+		t.Errorf("Expected %d bytes with the 4 markers of the test case, got %d bytes with %d", result.Bytes,
+			len(data), bytes.Count(data, []byte("MASCHEMK")))
+	}
+}
+
+func TestLibList(t *testing.T) {
+	pid := launch(t)
+	library := map[string]string{"linux": "libc", "darwin": "libSystem", "windows": "(?i)kernel32"}[runtime.GOOS]
+
+	out, code := run(t, "liblist", "-pid", pid, "-r", library)
+	if code != 0 || !strings.Contains(out, "["+pid+"] "+test.GetTestCasePath()) {
+		t.Errorf("liblist exited with %d and printed:\n%s", code, out)
+	}
+
+	out, code = run(t, "liblist", "-pid", pid, "-r", library, "-json")
+	var result []struct {
+		Pid       int      `json:"pid"`
+		Libraries []string `json:"libraries"`
+	}
+	if err := json.Unmarshal([]byte(out), &result); err != nil || code != 0 || len(result) != 1 ||
+		fmt.Sprint(result[0].Pid) != pid || len(result[0].Libraries) == 0 {
+		t.Errorf("liblist -json exited with %d and printed %s (%v)", code, out, err)
+	}
+
+	if _, code := run(t, "liblist", "-pid", pid, "-r", "no library has this name"); code != 1 {
+		t.Errorf("liblist of a missing library exited with %d", code)
+	}
+}
+
+func TestQualify(t *testing.T) {
+	out, code := run(t, "qualify", "-target", test.GetTestCasePath(), "-maps", "0,8", "-read", "1048576",
+		"-duration", "200ms")
+	var profile struct {
+		Version     int `json:"version"`
+		Enumeration []struct {
+			Maps int `json:"maps"`
+		} `json:"enumeration"`
+	}
+	if err := json.Unmarshal([]byte(out), &profile); err != nil || code != 0 || profile.Version != 1 ||
+		len(profile.Enumeration) != 2 || profile.Enumeration[1].Maps != 8 {
+		t.Errorf("qualify exited with %d and printed %s (%v)", code, out, err)
+	}
+
+	if _, code := run(t, "qualify", "-maps", "0,x"); code != 1 {
+		t.Errorf("qualify with an invalid map count exited with %d", code)
+	}
+}
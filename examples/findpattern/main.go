@@ -0,0 +1,126 @@
+// This program searches the memory of a process for a pattern with memsearch.FindAll, and prints the matches and the
+// stats of the scan, as text or as JSON.
+//
+//	./findpattern -pid 1234 -string "secret"
+//	./findpattern -pid 1234 -hex "deadbeef" -short-circuit global -json
+//
+// With -v it prints which features of masche are available for the process first.
+//
+// It exits with 1 if the pattern isn't found, and with 2 on errors.
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/polyverse/masche/common"
+	"github.com/polyverse/masche/memsearch"
+	"github.com/polyverse/masche/process"
+)
+
+var (
+	pid             = flag.Int("pid", 0, "process id to search")
+	str             = flag.String("string", "", "pattern to search for, as a string")
+	hexPattern      = flag.String("hex", "", "pattern to search for, hex encoded")
+	address         = flag.Uint64("addr", 0, "address where the search starts")
+	shortCircuit    = flag.String("short-circuit", "none", "when to stop looking: none, region or global")
+	bufferSize      = flag.Uint("buffer-size", 0, "bytes read at once, 0 for the default")
+	preferFileReads = flag.Bool("prefer-file-reads", false, "read unmodified file backed memory from the files")
+	jsonOut         = flag.Bool("json", false, "print the matches and the stats as JSON")
+	verbose         = flag.Bool("v", false, "print which features are available for the process before searching")
+)
+
+var shortCircuits = map[string]memsearch.ShortCircuit{
+	"none":   memsearch.NoShortCircuit,
+	"region": memsearch.PerRegion,
+	"global": memsearch.Global,
+}
+
+// result is the JSON output.
+type result struct {
+	Matches []memsearch.Match   `json:"matches"`
+	Stats   memsearch.ScanStats `json:"stats"`
+}
+
+func fail(err error) {
+	log.Print(err)
+	os.Exit(2)
+}
+
+func main() {
+	flag.Parse()
+	log.SetFlags(0)
+
+	pattern, opts, err := parseFlags()
+	if err != nil {
+		fail(err)
+	}
+
+	p, harderror, softerrors := process.OpenFromPid(*pid)
+	if harderror != nil {
+		fail(harderror)
+	}
+	defer p.Close()
+	for _, e := range softerrors {
+		log.Print(e)
+	}
+	if *verbose {
+		for _, c := range common.ProcessCapabilities(*pid) {
+			log.Print(c)
+		}
+	}
+
+	matches, stats, harderror, softerrors := memsearch.FindAll(p, uintptr(*address), []memsearch.Pattern{pattern}, opts)
+	for _, e := range softerrors {
+		log.Print(e)
+	}
+	if harderror != nil {
+		fail(harderror)
+	}
+
+	if *jsonOut {
+		if err := json.NewEncoder(os.Stdout).Encode(result{Matches: matches, Stats: stats}); err != nil {
+			fail(err)
+		}
+	} else {
+		for _, m := range matches {
+			fmt.Printf("0x%x pattern %d in %v\n", m.Address, m.Pattern, m.Region)
+		}
+		fmt.Printf("%d matches in %d regions (%d bytes scanned)\n", stats.Matches, stats.RegionsScanned,
+			stats.BytesScanned)
+	}
+	if len(matches) == 0 {
+		os.Exit(1)
+	}
+}
+
+// parseFlags builds the pattern and the options of the search from the flags.
+func parseFlags() (pattern memsearch.Pattern, opts memsearch.SearchOptions, err error) {
+	if *pid == 0 {
+		return pattern, opts, fmt.Errorf("The -pid flag is required")
+	}
+	switch {
+	case *str != "" && *hexPattern != "":
+		return pattern, opts, fmt.Errorf("Only one of -string and -hex can be given")
+	case *str != "":
+		pattern.Bytes = []byte(*str)
+	case *hexPattern != "":
+		pattern.Bytes, err = hex.DecodeString(*hexPattern)
+		if err != nil {
+			return pattern, opts, fmt.Errorf("Invalid -hex pattern (%v)", err)
+		}
+	default:
+		return pattern, opts, fmt.Errorf("A pattern is required, use -string or -hex")
+	}
+
+	sc, ok := shortCircuits[*shortCircuit]
+	if !ok {
+		return pattern, opts, fmt.Errorf("Unknown -short-circuit %q", *shortCircuit)
+	}
+	opts = memsearch.SearchOptions{ShortCircuit: sc, BufferSize: *bufferSize, PreferFileReads: *preferFileReads}
+	return pattern, opts, nil
+}
@@ -0,0 +1,106 @@
+// This program can be used to check if any process is running a given dynamic library.
+// The -r flag specifies a regexp over the filename of the library, for example:
+// ./liblist -r="libc" will match all programs that have the libc loaded as a dynamic library.
+//
+// With -pid only that process is checked, and with -json the result is printed as JSON. It exits with 1 if no process
+// has a matching library, and with 2 on errors.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+
+	"github.com/polyverse/masche/listlibs"
+	"github.com/polyverse/masche/process"
+)
+
+var (
+	rstr    = flag.String("r", "", "library name regexp")
+	pid     = flag.Int("pid", 0, "only check the process with this pid")
+	jsonOut = flag.Bool("json", false, "print the result as JSON")
+)
+
+// procLibs are the matching libraries of a process.
+type procLibs struct {
+	Pid       int      `json:"pid"`
+	Name      string   `json:"name"`
+	Libraries []string `json:"libraries"`
+}
+
+func main() {
+	flag.Parse()
+	log.SetFlags(0)
+
+	r, err := regexp.Compile(*rstr)
+	if err != nil {
+		log.Print(err)
+		os.Exit(2)
+	}
+
+	var ps []process.Process
+	var hard error
+	var softs []error
+	if *pid != 0 {
+		var p process.Process
+		p, hard, softs = process.OpenFromPid(*pid)
+		ps = []process.Process{p}
+	} else {
+		ps, hard, softs = process.OpenAll()
+	}
+	if hard != nil {
+		log.Print(hard)
+		os.Exit(2)
+	}
+	defer process.CloseAll(ps)
+	for _, e := range softs {
+		log.Println(e)
+	}
+
+	matches, softs := findProcWithLib(r, ps)
+	for _, e := range softs {
+		log.Println(e)
+	}
+
+	if *jsonOut {
+		if err := json.NewEncoder(os.Stdout).Encode(matches); err != nil {
+			log.Print(err)
+			os.Exit(2)
+		}
+	} else {
+		fmt.Printf("Processes matching: %s\n", *rstr)
+		for _, m := range matches {
+			fmt.Printf("[%d] %s\n", m.Pid, m.Name)
+			for _, l := range m.Libraries {
+				fmt.Printf("\t%s\n", l)
+			}
+		}
+	}
+	if len(matches) == 0 {
+		os.Exit(1)
+	}
+}
+
+// findProcWithLib returns the processes with libraries matching r, sorted by pid. The processes that can't be
+// inspected are reported as softerrors.
+func findProcWithLib(r *regexp.Regexp, ps []process.Process) (matches []procLibs, softerrors []error) {
+	for _, p := range ps {
+		libs, hard, softs := listlibs.GetMatchingLoadedLibraries(p, r)
+		softerrors = append(softerrors, softs...)
+		if hard != nil {
+			softerrors = append(softerrors, fmt.Errorf("Process %d: %v", p.Pid(), hard))
+			continue
+		}
+		if len(libs) == 0 {
+			continue
+		}
+		name, _, _ := p.Name()
+		matches = append(matches, procLibs{Pid: p.Pid(), Name: name, Libraries: libs})
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].Pid < matches[j].Pid })
+	return matches, softerrors
+}
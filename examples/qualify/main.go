@@ -0,0 +1,56 @@
+// This program qualifies a host before production sweeps are scheduled on it: it runs the battery of the qualify
+// package against the test case of masche, and prints the profile of the host as JSON.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/polyverse/masche/qualify"
+)
+
+var (
+	target    = flag.String("target", "", "Path of the test case of masche (test/tools/test).")
+	maps      = flag.String("maps", "", "Comma separated amounts of files mapped by the enumeration targets.")
+	readBytes = flag.Uint64("read", 0, "Bytes read from each backend to measure its throughput.")
+	bandwidth = flag.Uint64("bandwidth", 0, "Bytes per second the sustained scan is limited to.")
+	duration  = flag.Duration("duration", 0, "Duration of the sustained scan.")
+	timeout   = flag.Duration("timeout", time.Minute, "Time the whole battery can take.")
+)
+
+func main() {
+	flag.Parse()
+
+	opts := qualify.Options{Target: *target, ReadBytes: *readBytes, ScanBandwidth: *bandwidth, ScanDuration: *duration}
+	if *maps != "" {
+		for _, count := range strings.Split(*maps, ",") {
+			n, err := strconv.Atoi(count)
+			if err != nil {
+				log.Fatalf("Invalid map count %q", count)
+			}
+			opts.MapCounts = append(opts.MapCounts, n)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	profile, harderror, softerrors := qualify.Qualify(ctx, opts)
+	for _, err := range softerrors {
+		log.Println(err)
+	}
+	if harderror != nil {
+		log.Fatal(harderror)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(profile); err != nil {
+		log.Fatal(err)
+	}
+}
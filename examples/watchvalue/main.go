@@ -0,0 +1,108 @@
+// This program watches a value in the memory of a process, and prints it every time it changes.
+//
+//	./watchvalue -pid 1234 -addr 0x7ffd1000 -size 8 -interval 100ms
+//
+// With -changes it exits after that many changes, and with -timeout it gives up after that long, exiting with 1. It
+// exits with 2 on errors, like when the value can't be read anymore.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/polyverse/masche/memaccess"
+	"github.com/polyverse/masche/process"
+)
+
+var (
+	pid      = flag.Int("pid", 0, "process id to watch")
+	addr     = flag.String("addr", "", "address of the value, decimal or 0x prefixed hex")
+	size     = flag.Uint("size", 8, "size of the value in bytes")
+	interval = flag.Duration("interval", 100*time.Millisecond, "time between reads")
+	changes  = flag.Int("changes", 0, "exit after this many changes, 0 to watch forever")
+	timeout  = flag.Duration("timeout", 0, "give up after this long, 0 to never give up")
+	jsonOut  = flag.Bool("json", false, "print each value as a JSON line")
+)
+
+// event is a value read, printed with -json.
+type event struct {
+	Time    time.Time `json:"time"`
+	Address uintptr   `json:"address"`
+	Value   []byte    `json:"value"`
+	Initial bool      `json:"initial"`
+}
+
+func fail(err error) {
+	log.Print(err)
+	os.Exit(2)
+}
+
+func main() {
+	flag.Parse()
+	log.SetFlags(0)
+	address, err := strconv.ParseUint(*addr, 0, 64)
+	if *pid == 0 || err != nil || *size == 0 {
+		fail(fmt.Errorf("The -pid, -addr and -size flags are required and -addr must be a number"))
+	}
+
+	// The preopened memory file lets each read be a single system call, without allocations.
+	p, harderror, softerrors := process.OpenFromPidWithOptions(*pid, process.OpenOptions{PreopenResources: true})
+	if harderror != nil {
+		fail(harderror)
+	}
+	defer p.Close()
+	for _, e := range softerrors {
+		log.Print(e)
+	}
+
+	var deadline <-chan time.Time
+	if *timeout > 0 {
+		deadline = time.After(*timeout)
+	}
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+
+	// The value is read into one buffer while the other keeps the previous one.
+	value, previous := make([]byte, *size), []byte(nil)
+	for seen := 0; *changes == 0 || seen < *changes; {
+		if _, err := memaccess.ReadInto(p, uintptr(address), value); err != nil {
+			fail(err)
+		}
+
+		if previous == nil || !bytes.Equal(previous, value) {
+			report(event{Time: time.Now(), Address: uintptr(address), Value: value, Initial: previous == nil},
+				previous)
+			if previous == nil {
+				previous = make([]byte, *size)
+			} else {
+				seen++
+			}
+			previous, value = value, previous
+		}
+
+		select {
+		case <-ticker.C:
+		case <-deadline:
+			log.Printf("Timed out after %v", *timeout)
+			os.Exit(1)
+		}
+	}
+}
+
+func report(e event, previous []byte) {
+	if *jsonOut {
+		if err := json.NewEncoder(os.Stdout).Encode(e); err != nil {
+			fail(err)
+		}
+	} else if e.Initial {
+		fmt.Printf("initial 0x%x: %x %q\n", e.Address, e.Value, e.Value)
+	} else {
+		fmt.Printf("changed 0x%x: %x %q -> %x %q\n", e.Address, previous, previous, e.Value, e.Value)
+	}
+}
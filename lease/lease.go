@@ -0,0 +1,193 @@
+// Package lease coordinates the scans of many instances of masche on the same host with an advisory lease file, so
+// they don't scan at the same time and double their impact. It only works among the instances that use it.
+package lease
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultPath is the lease file used when none is given.
+const DefaultPath = "/var/run/masche.lease"
+
+// Info is the metadata of a lease, as stored in the lease file.
+type Info struct {
+	// Owner names the instance holding the lease, and ID tells apart instances with the same owner.
+	Owner string `json:"owner"`
+	ID    string `json:"id"`
+	// Pid is the pid of the holder.
+	Pid int `json:"pid"`
+	// Expires is when the lease is free for others if the holder doesn't renew it, as after a crash.
+	Expires time.Time `json:"expires"`
+	// Target is the pid of the process being scanned by the holder, or 0.
+	Target int `json:"target,omitempty"`
+}
+
+// Active tells if the lease is held at t.
+func (i Info) Active(t time.Time) bool {
+	return i.ID != "" && t.Before(i.Expires)
+}
+
+// ErrHeld is the error returned when another instance holds the lease.
+var ErrHeld = errors.New("the lease is held by another instance")
+
+// HeldError tells who holds a lease that couldn't be acquired.
+type HeldError struct {
+	Holder Info
+}
+
+func (e *HeldError) Error() string {
+	return fmt.Sprintf("%v: %s (pid %d) until %s", ErrHeld, e.Holder.Owner, e.Holder.Pid,
+		e.Holder.Expires.Format(time.RFC3339))
+}
+
+func (e *HeldError) Unwrap() error {
+	return ErrHeld
+}
+
+// Lease is a lease held by this instance.
+type Lease struct {
+	path string
+	ttl  time.Duration
+	info Info
+}
+
+// sequence tells apart the leases acquired by this instance.
+var sequence int64
+
+// Acquire takes the lease at path for owner, for ttl. If another instance holds it, it fails with a *HeldError.
+// Leases that expired are taken over, so an instance that crashed only delays the others until its lease expires.
+func Acquire(path string, owner string, ttl time.Duration) (*Lease, error) {
+	if ttl <= 0 {
+		return nil, fmt.Errorf("Invalid lease duration %v", ttl)
+	}
+	id := fmt.Sprintf("%d-%d-%d", os.Getpid(), time.Now().UnixNano(), atomic.AddInt64(&sequence, 1))
+	l := &Lease{path: path, ttl: ttl, info: Info{Owner: owner, ID: id, Pid: os.Getpid()}}
+
+	err := update(path, func(current Info) (Info, error) {
+		now := time.Now()
+		if current.Active(now) {
+			return current, &HeldError{Holder: current}
+		}
+		l.info.Expires = now.Add(ttl).Round(0)
+		return l.info, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Wait works as Acquire, but if another instance holds the lease it tries again every poll until it's acquired or
+// ctx is done.
+func Wait(ctx context.Context, path string, owner string, ttl time.Duration, poll time.Duration) (*Lease, error) {
+	for {
+		l, err := Acquire(path, owner, ttl)
+		if !errors.Is(err, ErrHeld) {
+			return l, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(poll):
+		}
+	}
+}
+
+// Inspect returns the metadata of the lease at path. The lease is free if it isn't Active.
+func Inspect(path string) (Info, error) {
+	var info Info
+	err := withLock(path, func(f *os.File) error {
+		var err error
+		info, err = read(f)
+		return err
+	})
+	return info, err
+}
+
+// Info returns the metadata of the lease as this instance wrote it.
+func (l *Lease) Info() Info {
+	return l.info
+}
+
+// Renew extends the lease for its duration from now, and records the pid of the process being scanned. It fails
+// with a *HeldError if the lease expired and another instance took it.
+func (l *Lease) Renew(target int) error {
+	return update(l.path, func(current Info) (Info, error) {
+		if current.ID != l.info.ID && current.Active(time.Now()) {
+			return current, &HeldError{Holder: current}
+		}
+		l.info.Expires = time.Now().Add(l.ttl).Round(0)
+		l.info.Target = target
+		return l.info, nil
+	})
+}
+
+// Release frees the lease, unless another instance took it after it expired.
+func (l *Lease) Release() error {
+	return update(l.path, func(current Info) (Info, error) {
+		if current.ID != l.info.ID {
+			return current, nil
+		}
+		return Info{}, nil
+	})
+}
+
+// update replaces the metadata in the lease file with the one returned by change, while holding the lock of the file.
+// If change fails the file isn't modified.
+func update(path string, change func(current Info) (Info, error)) error {
+	return withLock(path, func(f *os.File) error {
+		current, err := read(f)
+		if err != nil {
+			return err
+		}
+		next, err := change(current)
+		if err != nil || next == current {
+			return err
+		}
+		var data []byte
+		if next.ID != "" {
+			if data, err = json.Marshal(next); err != nil {
+				return err
+			}
+		}
+		if err := f.Truncate(0); err != nil {
+			return err
+		}
+		_, err = f.WriteAt(data, 0)
+		return err
+	})
+}
+
+// withLock runs f with the lease file open and exclusively locked.
+func withLock(path string, f func(file *os.File) error) error {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := lock(file); err != nil {
+		return fmt.Errorf("Unable to lock the lease file %s: %v", path, err)
+	}
+	defer unlock(file)
+	return f(file)
+}
+
+// read parses the metadata in the lease file. An empty file is a free lease, and so is a corrupt one, which a writer
+// that crashed may leave behind.
+func read(f *os.File) (info Info, err error) {
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return info, err
+	}
+	if len(data) == 0 || json.Unmarshal(data, &info) != nil {
+		return Info{}, nil
+	}
+	return info, nil
+}
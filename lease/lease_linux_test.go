@@ -0,0 +1,100 @@
+package lease
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLease(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "masche.lease")
+
+	a, err := Acquire(path, "ours", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Acquire(path, "theirs", time.Minute)
+	var held *HeldError
+	if !errors.As(err, &held) || held.Holder.Owner != "ours" || held.Holder.Pid != os.Getpid() {
+		t.Fatalf("Expected the lease to be held by us, got %v", err)
+	}
+
+	if err := a.Renew(42); err != nil {
+		t.Fatal(err)
+	}
+	info, err := Inspect(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.Active(time.Now()) || info.Target != 42 || info.ID != a.Info().ID || !info.Expires.Equal(a.Info().Expires) {
+		t.Errorf("Unexpected lease %+v, expected %+v", info, a.Info())
+	}
+
+	// Waiting gives up when the context is done, and takes the lease once it's released.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := Wait(ctx, path, "theirs", time.Minute, 10*time.Millisecond); err != context.DeadlineExceeded {
+		t.Errorf("Expected the wait to time out, got %v", err)
+	}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		a.Release()
+	}()
+	b, err := Wait(context.Background(), path, "theirs", time.Minute, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Releasing a lease that another instance holds leaves it alone.
+	if err := a.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := Inspect(path); err != nil || info.Owner != "theirs" {
+		t.Errorf("Expected the lease to be held by them, got %+v (%v)", info, err)
+	}
+	b.Release()
+}
+
+func TestStaleLease(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "masche.lease")
+
+	// An instance that crashed while holding the lease.
+	crashed := Info{Owner: "crashed", ID: "1-1-1", Pid: 1, Expires: time.Now().Add(-time.Second), Target: 7}
+	data, err := json.Marshal(crashed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	l, err := Acquire(path, "ours", time.Minute)
+	if err != nil {
+		t.Fatalf("Expected the expired lease to be taken over, got %v", err)
+	}
+
+	// A lease that expired and was taken can't be renewed.
+	short, err := Acquire(filepath.Join(t.TempDir(), "short.lease"), "ours", time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if _, err := Acquire(short.path, "theirs", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := short.Renew(0); !errors.Is(err, ErrHeld) {
+		t.Errorf("Expected ErrHeld renewing a lease taken by another instance, got %v", err)
+	}
+
+	// A corrupt file is a free lease.
+	l.Release()
+	if err := ioutil.WriteFile(path, []byte("{\"owner\": "), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Acquire(path, "ours", time.Minute); err != nil {
+		t.Errorf("Expected a corrupt lease to be free, got %v", err)
+	}
+}
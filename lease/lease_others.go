@@ -0,0 +1,16 @@
+// +build windows darwin
+
+package lease
+
+import (
+	"fmt"
+	"os"
+)
+
+func lock(f *os.File) error {
+	return fmt.Errorf("Locking lease files is not implemented on this platform")
+}
+
+func unlock(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,51 @@
+package listlibs
+
+import (
+	"github.com/polyverse/masche/process"
+)
+
+// Module is a loaded executable or library, and the modules it depends on.
+type Module struct {
+	Path   string `json:"path"`
+	Soname string `json:"soname,omitempty"`
+	// Needed are the names of the libraries the module requires, as they appear in its dynamic section.
+	Needed []string `json:"needed"`
+	// Dependencies are the paths of the loaded modules that satisfy Needed.
+	Dependencies []string `json:"dependencies"`
+}
+
+// AnomalyKind tells what is suspicious about a module.
+type AnomalyKind string
+
+const (
+	// NotNeeded modules are loaded but no other module needs them. They were injected with LD_PRELOAD or dlopen(3),
+	// which is not necessarily malicious as it's how plugins are loaded.
+	NotNeeded AnomalyKind = "not-needed"
+	// UnexpectedPath modules satisfy a dependency from outside the system library directories and the search path
+	// of the module that needs them.
+	UnexpectedPath AnomalyKind = "unexpected-path"
+	// Unresolved dependencies are needed by a module but no loaded module satisfies them.
+	Unresolved AnomalyKind = "unresolved"
+)
+
+// Anomaly is a suspicious finding in a ModuleGraph.
+type Anomaly struct {
+	Kind AnomalyKind `json:"kind"`
+	// Module is the path of the suspicious module, or the needed name for Unresolved anomalies.
+	Module string `json:"module"`
+	// NeededBy is the path of the module that needs Module, if any.
+	NeededBy string `json:"neededBy,omitempty"`
+}
+
+// ModuleGraph is the dependency graph of the modules loaded by a process.
+type ModuleGraph struct {
+	// Modules are in load order when it can be determined, with the executable first.
+	Modules   []Module  `json:"modules"`
+	Anomalies []Anomaly `json:"anomalies"`
+}
+
+// DependencyGraph resolves the dependencies between the modules loaded by p against each other, and reports the
+// modules that look injected.
+func DependencyGraph(p process.Process) (graph ModuleGraph, harderror error, softerrors []error) {
+	return dependencyGraph(p)
+}
@@ -0,0 +1,299 @@
+package listlibs
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/polyverse/masche/common"
+	"github.com/polyverse/masche/memaccess"
+	"github.com/polyverse/masche/process"
+)
+
+// systemLibraryDirs are the directories searched by the dynamic loader by default. Their subdirectories are
+// considered system directories too, as multiarch systems put the libraries there.
+var systemLibraryDirs = []string{"/lib", "/lib32", "/lib64", "/usr/lib", "/usr/lib32", "/usr/lib64", "/usr/local/lib"}
+
+// dtDebug is the dynamic section entry that the loader sets to the address of its r_debug structure.
+const dtDebug = 21
+
+func dependencyGraph(p process.Process) (graph ModuleGraph, harderror error, softerrors []error) {
+	exe, harderror := process.ProcessExe(p.Pid())
+	if harderror != nil {
+		return graph, harderror, nil
+	}
+	entries, harderror := common.ReadMapsFile(uint(p.Pid()))
+	if harderror != nil {
+		return graph, harderror, nil
+	}
+
+	paths, err, softerrors := loadOrder(p, exe, entries)
+	if err != nil {
+		softerrors = append(softerrors, fmt.Errorf("Unable to get the load order, using the address order (%v)", err))
+	}
+	// Add the modules mapped without the loader knowing about them.
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Path, "/") && !inSlice(entry.Path, paths) {
+			paths = append(paths, entry.Path)
+		}
+	}
+
+	var interp string
+	modules := make([]Module, 0, len(paths))
+	runpaths := make(map[string][]string)
+	for _, path := range paths {
+		f, err := elf.Open(path)
+		if err != nil {
+			// Not every mapped file is an ELF file.
+			continue
+		}
+		if path == exe {
+			interp = interpreter(f)
+		}
+
+		module := Module{Path: path}
+		module.Needed, err = f.ImportedLibraries()
+		if err != nil {
+			softerrors = append(softerrors, fmt.Errorf("Unable to read the dependencies of %s: %v", path, err))
+		}
+		if sonames, err := f.DynString(elf.DT_SONAME); err == nil && len(sonames) > 0 {
+			module.Soname = sonames[0]
+		}
+		runpaths[path] = searchPath(f, path)
+		f.Close()
+		modules = append(modules, module)
+	}
+
+	needed := make(map[string]bool)
+	for i := range modules {
+		module := &modules[i]
+		for _, name := range module.Needed {
+			dep, found := resolve(modules, name)
+			if !found {
+				graph.Anomalies = append(graph.Anomalies, Anomaly{Kind: Unresolved, Module: name,
+					NeededBy: module.Path})
+				continue
+			}
+			module.Dependencies = append(module.Dependencies, dep)
+			needed[dep] = true
+			if !expectedPath(dep, runpaths[module.Path]) {
+				graph.Anomalies = append(graph.Anomalies, Anomaly{Kind: UnexpectedPath, Module: dep,
+					NeededBy: module.Path})
+			}
+		}
+	}
+
+	for _, module := range modules {
+		if module.Path != exe && module.Path != interp && !needed[module.Path] {
+			graph.Anomalies = append(graph.Anomalies, Anomaly{Kind: NotNeeded, Module: module.Path})
+		}
+	}
+
+	graph.Modules = modules
+	return graph, nil, softerrors
+}
+
+// resolve finds the loaded module that satisfies a DT_NEEDED name.
+func resolve(modules []Module, name string) (path string, found bool) {
+	for _, module := range modules {
+		if module.Soname == name || filepath.Base(module.Path) == name || module.Path == name {
+			return module.Path, true
+		}
+	}
+	return "", false
+}
+
+func expectedPath(path string, searchPath []string) bool {
+	dir := filepath.Dir(path)
+	for _, system := range systemLibraryDirs {
+		if dir == system || strings.HasPrefix(dir, system+"/") {
+			return true
+		}
+	}
+	return inSlice(dir, searchPath)
+}
+
+// searchPath returns the DT_RPATH and DT_RUNPATH directories of f, which was loaded from path.
+func searchPath(f *elf.File, path string) (dirs []string) {
+	origin := filepath.Dir(path)
+	for _, tag := range []elf.DynTag{elf.DT_RPATH, elf.DT_RUNPATH} {
+		values, _ := f.DynString(tag)
+		for _, value := range values {
+			for _, dir := range strings.Split(value, ":") {
+				dir = strings.Replace(strings.Replace(dir, "${ORIGIN}", origin, -1), "$ORIGIN", origin, -1)
+				if real, err := filepath.EvalSymlinks(dir); err == nil {
+					dir = real
+				}
+				dirs = append(dirs, filepath.Clean(dir))
+			}
+		}
+	}
+	return dirs
+}
+
+func interpreter(f *elf.File) string {
+	for _, prog := range f.Progs {
+		if prog.Type != elf.PT_INTERP {
+			continue
+		}
+		data := make([]byte, prog.Filesz)
+		if _, err := prog.ReadAt(data, 0); err != nil {
+			return ""
+		}
+		path := string(bytes.TrimRight(data, "\x00"))
+		if real, err := filepath.EvalSymlinks(path); err == nil {
+			return real
+		}
+		return path
+	}
+	return ""
+}
+
+// loadOrder walks the loader's list of loaded objects (the link_map list of r_debug) and returns their paths. The
+// executable is always the first one. If the list can't be found the paths are returned in address order.
+func loadOrder(p process.Process, exe string, entries []common.MapsEntry) (paths []string, harderror error,
+	softerrors []error) {
+
+	paths = []string{exe}
+	f, err := elf.Open(exe)
+	if err != nil {
+		return paths, err, nil
+	}
+	defer f.Close()
+
+	r := memoryReader{p: p, order: f.ByteOrder, wordSize: 8}
+	if f.Class == elf.ELFCLASS32 {
+		r.wordSize = 4
+	}
+
+	bias, err := loadBias(f, exe, entries)
+	if err != nil {
+		return paths, err, nil
+	}
+
+	rDebug, err := r.dynamicValue(f, bias, dtDebug)
+	if err != nil || rDebug == 0 {
+		return paths, fmt.Errorf("No r_debug found in the dynamic section of %s (%v)", exe, err), r.softerrors
+	}
+
+	// struct r_debug { int r_version; struct link_map *r_map; ... }
+	linkMap, err := r.word(uintptr(rDebug) + uintptr(r.wordSize))
+	// struct link_map { ElfW(Addr) l_addr; char *l_name; ElfW(Dyn) *l_ld; struct link_map *l_next, *l_prev; }
+	for seen := 0; err == nil && linkMap != 0 && seen < 4096; seen++ {
+		var name uint64
+		name, err = r.word(uintptr(linkMap) + uintptr(r.wordSize))
+		if err != nil {
+			break
+		}
+		if name != 0 {
+			var path string
+			path, err = r.cString(uintptr(name))
+			if err != nil {
+				break
+			}
+			if real, err := filepath.EvalSymlinks(path); err == nil {
+				path = real
+			}
+			if strings.HasPrefix(path, "/") && !inSlice(path, paths) {
+				paths = append(paths, path)
+			}
+		}
+		linkMap, err = r.word(uintptr(linkMap) + 3*uintptr(r.wordSize))
+	}
+	return paths, err, r.softerrors
+}
+
+// loadBias returns the difference between the addresses where the executable is loaded and its virtual addresses.
+func loadBias(f *elf.File, exe string, entries []common.MapsEntry) (uintptr, error) {
+	if f.Type != elf.ET_DYN {
+		return 0, nil
+	}
+
+	lowest := ^uint64(0)
+	for _, prog := range f.Progs {
+		if prog.Type == elf.PT_LOAD && prog.Vaddr < lowest {
+			lowest = prog.Vaddr
+		}
+	}
+	lowest &^= uint64(os.Getpagesize() - 1)
+
+	for _, entry := range entries {
+		if entry.Path == exe && entry.Offset == 0 {
+			return entry.Start - uintptr(lowest), nil
+		}
+	}
+	return 0, fmt.Errorf("%s is not mapped", exe)
+}
+
+// memoryReader reads the loader's structures from the memory of a process.
+type memoryReader struct {
+	p          process.Process
+	order      binary.ByteOrder
+	wordSize   int
+	softerrors []error
+}
+
+func (r *memoryReader) read(address uintptr, buf []byte) error {
+	harderror, softerrors := memaccess.CopyMemory(r.p, address, buf)
+	r.softerrors = append(r.softerrors, softerrors...)
+	return harderror
+}
+
+func (r *memoryReader) word(address uintptr) (uint64, error) {
+	buf := make([]byte, r.wordSize)
+	if err := r.read(address, buf); err != nil {
+		return 0, err
+	}
+	if r.wordSize == 8 {
+		return r.order.Uint64(buf), nil
+	}
+	return uint64(r.order.Uint32(buf)), nil
+}
+
+// cString reads a NUL terminated string of up to 4KiB.
+func (r *memoryReader) cString(address uintptr) (string, error) {
+	var s []byte
+	chunk := make([]byte, 256)
+	for len(s) < 4096 {
+		// Reads are aligned so they never cross into the next page, which may not be mapped.
+		n := 256 - int(address%256)
+		if err := r.read(address, chunk[:n]); err != nil {
+			return "", err
+		}
+		if i := bytes.IndexByte(chunk[:n], 0); i != -1 {
+			return string(append(s, chunk[:i]...)), nil
+		}
+		s = append(s, chunk[:n]...)
+		address += uintptr(n)
+	}
+	return "", fmt.Errorf("String at %x is too long", address)
+}
+
+// dynamicValue returns the value of the first entry with the given tag of the dynamic section of f, as it is in
+// memory.
+func (r *memoryReader) dynamicValue(f *elf.File, bias uintptr, tag uint64) (uint64, error) {
+	for _, prog := range f.Progs {
+		if prog.Type != elf.PT_DYNAMIC {
+			continue
+		}
+
+		start := bias + uintptr(prog.Vaddr)
+		for offset := uintptr(0); offset+2*uintptr(r.wordSize) <= uintptr(prog.Memsz); offset += 2 * uintptr(r.wordSize) {
+			t, err := r.word(start + offset)
+			if err != nil {
+				return 0, err
+			}
+			if t == uint64(elf.DT_NULL) {
+				break
+			}
+			if t == tag {
+				return r.word(start + offset + uintptr(r.wordSize))
+			}
+		}
+	}
+	return 0, fmt.Errorf("No dynamic entry %d", tag)
+}
@@ -0,0 +1,57 @@
+package listlibs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/polyverse/masche/process"
+	"github.com/polyverse/masche/test"
+)
+
+func TestDependencyGraph(t *testing.T) {
+	preload := filepath.Join(filepath.Dir(test.GetTestCasePath()), "libpreload.so")
+	os.Setenv("LD_PRELOAD", preload)
+	cmd, err := test.LaunchTestCaseAndWaitForInitialization()
+	os.Unsetenv("LD_PRELOAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	proc, err, softerrors := process.OpenFromPid(cmd.Process.Pid)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proc.Close()
+
+	graph, err, softerrors := DependencyGraph(proc)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(graph.Modules) < 3 || graph.Modules[0].Path != test.GetTestCasePath() {
+		t.Fatal("Unexpected modules", graph.Modules)
+	}
+	// The loader puts preloaded libraries right after the executable.
+	if graph.Modules[1].Path != preload {
+		t.Error("Expected the preloaded library to be loaded second, but the modules are", graph.Modules)
+	}
+	if len(graph.Modules[0].Dependencies) == 0 {
+		t.Error("The test case has no resolved dependencies", graph.Modules[0])
+	}
+
+	flagged := false
+	for _, anomaly := range graph.Anomalies {
+		if anomaly.Module == preload && anomaly.Kind == NotNeeded {
+			flagged = true
+		} else {
+			t.Error("Unexpected anomaly", anomaly)
+		}
+	}
+	if !flagged {
+		t.Error("The preloaded library was not flagged")
+	}
+}
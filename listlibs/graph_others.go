@@ -0,0 +1,13 @@
+// +build windows darwin
+
+package listlibs
+
+import (
+	"fmt"
+
+	"github.com/polyverse/masche/process"
+)
+
+func dependencyGraph(p process.Process) (graph ModuleGraph, harderror error, softerrors []error) {
+	return graph, fmt.Errorf("Dependency graphs are not implemented on this platform"), nil
+}
@@ -2,16 +2,39 @@ package listlibs
 
 import (
 	"regexp"
+	"sort"
 
 	"github.com/polyverse/masche/process"
 )
 
-// ListLoadedLibraries lists all the libraries (their absolute paths) loaded by a process.
+// library is a loaded library, and the lowest address where it's loaded.
+type library struct {
+	path string
+	base uintptr
+}
+
+// ListLoadedLibraries lists all the libraries (their absolute paths) loaded by a process, sorted by the address where
+// they are loaded.
 func ListLoadedLibraries(p process.Process) (libraries []string, harderror error, softerrors []error) {
-	return listLoadedLibraries(p)
+	libs, harderror, softerrors := listLoadedLibraries(p)
+	if harderror != nil {
+		return nil, harderror, softerrors
+	}
+	return sortLibraries(libs), nil, softerrors
+}
+
+// sortLibraries returns the paths of libs sorted by base address. The platforms find them in different orders.
+func sortLibraries(libs []library) (paths []string) {
+	sort.SliceStable(libs, func(i, j int) bool { return libs[i].base < libs[j].base })
+	paths = make([]string, len(libs))
+	for i, lib := range libs {
+		paths[i] = lib.path
+	}
+	return paths
 }
 
-// GetMaGetMatchingLoadedLibraries lists the libraries loaded by process p whose path matches r.
+// GetMaGetMatchingLoadedLibraries lists the libraries loaded by process p whose path matches r, sorted by the address
+// where they are loaded.
 func GetMatchingLoadedLibraries(p process.Process, r *regexp.Regexp) (libraries []string, harderror error,
 	softerrors []error) {
 
@@ -14,7 +14,7 @@ static bool copy_string(process_handle_t handle, mach_vm_address_t from,
         char **to, response_t *response);
 
 response_t *list_loaded_libraries(process_handle_t handle, char ***libs,
-        size_t *count) {
+        uint64_t **addresses, size_t *count) {
 
     response_t *response = response_create();
 
@@ -22,6 +22,7 @@ response_t *list_loaded_libraries(process_handle_t handle, char ***libs,
 
     size_t path_array_size = PATH_ARRAY_ALLOC_SIZE;
     *libs = calloc(PATH_ARRAY_ALLOC_SIZE, sizeof(char *));
+    *addresses = calloc(PATH_ARRAY_ALLOC_SIZE, sizeof(uint64_t));
     *count = 0;
 
     struct task_dyld_info dyld_info;
@@ -89,6 +90,19 @@ response_t *list_loaded_libraries(process_handle_t handle, char ***libs,
 
     for (uint32_t i = 0; i < info_array_count; i++) {
 
+        /* The load address is the first pointer of the image_info */
+        uint64_t loadAddr = 0;
+        read_success = read_memory(
+            handle,
+            info_array_start_addr + i * size_image_info,
+            pointer_size,
+            (mach_vm_address_t) &loadAddr,
+            response
+        );
+        if (!read_success) {
+            return response;
+        }
+
         mach_vm_address_t pathAddr = 0;
         read_success = read_memory(
             handle,
@@ -116,16 +130,20 @@ response_t *list_loaded_libraries(process_handle_t handle, char ***libs,
         if (*count == path_array_size) {
             path_array_size *= 2;
             *libs = realloc(*libs, path_array_size * sizeof(char *));
+            *addresses = realloc(*addresses,
+                    path_array_size * sizeof(uint64_t));
         }
 
         (*libs)[*count] = path;
+        (*addresses)[*count] = loadAddr;
         (*count)++;
     }
 
     return response;
 }
 
-void free_loaded_libraries_list(char **list, size_t count) {
+void free_loaded_libraries_list(char **list, uint64_t *addresses,
+        size_t count) {
    for (size_t i = 0; i < count; i++) {
         if (list[i] != NULL) {
             free(list[i]);
@@ -133,6 +151,7 @@ void free_loaded_libraries_list(char **list, size_t count) {
    }
 
    free(list);
+   free(addresses);
 }
 
 static bool copy_string(process_handle_t handle, mach_vm_address_t from,
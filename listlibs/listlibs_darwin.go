@@ -11,16 +11,18 @@ import (
 	"unsafe"
 )
 
-func listLoadedLibraries(p process.Process) (libraries []string, harderror error, softerrors []error) {
+func listLoadedLibraries(p process.Process) (libraries []library, harderror error, softerrors []error) {
 	var ptr uintptr
 	var sizeT C.size_t
 	clibs := (***C.char)(C.malloc(C.size_t(unsafe.Sizeof(ptr))))
+	caddresses := (**C.uint64_t)(C.malloc(C.size_t(unsafe.Sizeof(ptr))))
 	count := (*C.size_t)(C.malloc(C.size_t(unsafe.Sizeof(sizeT))))
 	defer C.free(unsafe.Pointer(clibs))
+	defer C.free(unsafe.Pointer(caddresses))
 	defer C.free(unsafe.Pointer(count))
 
-	response := C.list_loaded_libraries((C.process_handle_t)(p.Handle()), clibs, count)
-	defer C.free_loaded_libraries_list(*clibs, *count)
+	response := C.list_loaded_libraries((C.process_handle_t)(p.Handle()), clibs, caddresses, count)
+	defer C.free_loaded_libraries_list(*clibs, *caddresses, *count)
 	harderror, softerrors = cresponse.GetResponsesErrors(unsafe.Pointer(response))
 	C.response_free(response)
 
@@ -28,12 +30,17 @@ func listLoadedLibraries(p process.Process) (libraries []string, harderror error
 		return
 	}
 
-	libraries = make([]string, 0, *count)
+	libraries = make([]library, 0, *count)
 	clibsSlice := *(*[]*C.char)(unsafe.Pointer(
 		&reflect.SliceHeader{
 			Data: uintptr(unsafe.Pointer(*clibs)),
 			Len:  int(*count),
 			Cap:  int(*count)}))
+	caddressesSlice := *(*[]C.uint64_t)(unsafe.Pointer(
+		&reflect.SliceHeader{
+			Data: uintptr(unsafe.Pointer(*caddresses)),
+			Len:  int(*count),
+			Cap:  int(*count)}))
 
 	processName, harderror, softs := p.Name()
 	if harderror != nil {
@@ -50,7 +57,7 @@ func listLoadedLibraries(p process.Process) (libraries []string, harderror error
 		if str == processName {
 			continue
 		}
-		libraries = append(libraries, str)
+		libraries = append(libraries, library{path: str, base: uintptr(caddressesSlice[i])})
 	}
 
 	return
@@ -2,13 +2,12 @@ package listlibs
 
 import (
 	"bufio"
-	"fmt"
 	"github.com/polyverse/masche/common"
 	"github.com/polyverse/masche/process"
 	"os"
 )
 
-func listLoadedLibraries(p process.Process) (libraries []string, harderror error, softerrors []error) {
+func listLoadedLibraries(p process.Process) (libraries []library, harderror error, softerrors []error) {
 
 	mapsFile, harderror := os.Open(common.MapsFilePathFromPid(uint(p.Pid())))
 	if harderror != nil {
@@ -22,16 +21,14 @@ func listLoadedLibraries(p process.Process) (libraries []string, harderror error
 		return
 	}
 
-	libs := make([]string, 0, 10)
+	libs := make([]library, 0, 10)
 	for scanner.Scan() {
-		line := scanner.Text()
-		items := common.SplitMapsFileEntry(line)
-
-		if len(items) != 6 {
-			return libs, fmt.Errorf("Unrecognised maps line: %s", line), softerrors
+		entry, err := common.ParseMapsFileEntry(scanner.Text())
+		if err != nil {
+			return nil, err, softerrors
 		}
 
-		path := items[5]
+		path := entry.Path
 		if path == processName {
 			continue
 		}
@@ -48,14 +45,29 @@ func listLoadedLibraries(p process.Process) (libraries []string, harderror error
 			continue
 		}
 
-		if inSlice(path, libs) {
+		if loaded(path, libs) {
 			continue
 		}
 
-		libs = append(libs, path)
+		libs = append(libs, library{path: path, base: entry.Start})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err, softerrors
+	}
+
+	return libs, nil, softerrors
+}
+
+// loaded tells if path is in libs. The first mapping of a library is its lowest, as the maps file is sorted.
+func loaded(path string, libs []library) bool {
+	for _, lib := range libs {
+		if lib.path == path {
+			return true
+		}
 	}
 
-	return libs, nil, nil
+	return false
 }
 
 func inSlice(s string, slice []string) bool {
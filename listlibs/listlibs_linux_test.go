@@ -0,0 +1,85 @@
+package listlibs
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/polyverse/masche/common"
+	"github.com/polyverse/masche/process"
+)
+
+// namedProcess is a process whose name is known without reading its proc files. Its name comes with a softerror.
+type namedProcess struct {
+	process.Process
+	pid  int
+	name string
+}
+
+func (p namedProcess) Pid() int {
+	return p.pid
+}
+
+func (p namedProcess) Name() (string, error, []error) {
+	return p.name, nil, []error{errors.New("The name was truncated")}
+}
+
+// writeFakeMaps writes the maps file of a fake process in the given proc root.
+func writeFakeMaps(t *testing.T, root string, pid int, maps string) {
+	dir := filepath.Join(root, strconv.Itoa(pid))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "maps"), []byte(maps), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestListLoadedLibrariesSoftErrors(t *testing.T) {
+	defer func(root string) { common.ProcRoot = root }(common.ProcRoot)
+	common.ProcRoot = t.TempDir()
+
+	const pid = 4545
+	writeFakeMaps(t, common.ProcRoot, pid, ""+
+		"00400000-00401000 r-xp 00000000 08:01 1000 /bin/fake\n"+
+		"7f0000000000-7f0000001000 r-xp 00000000 08:01 1001 /lib/libc.so\n"+
+		"7f0000001000-7f0000002000 rw-p 00001000 08:01 1001 /lib/libc.so\n"+
+		"7ffc00000000-7ffc00001000 rw-p 00000000 00:00 0 [stack]\n")
+
+	libs, err, softerrors := listLoadedLibraries(namedProcess{pid: pid, name: "/bin/fake"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []library{{path: "/lib/libc.so", base: 0x7f0000000000}}; !reflect.DeepEqual(libs, expected) {
+		t.Errorf("Expected %v, got %v", expected, libs)
+	}
+	// The softerrors of reading the name of the process are kept.
+	if len(softerrors) != 1 {
+		t.Error("Expected the softerror of the name, got", softerrors)
+	}
+}
+
+func TestListLoadedLibrariesInvalidMaps(t *testing.T) {
+	defer func(root string) { common.ProcRoot = root }(common.ProcRoot)
+	common.ProcRoot = t.TempDir()
+
+	const pid = 4546
+	writeFakeMaps(t, common.ProcRoot, pid, ""+
+		"7f0000000000-7f0000001000 r-xp 00000000 08:01 1001 /lib/libc.so\n"+
+		"not a maps line\n")
+
+	libs, err, softerrors := listLoadedLibraries(namedProcess{pid: pid, name: "/bin/fake"})
+	if err == nil {
+		t.Fatal("Expected an invalid maps line to fail")
+	}
+	if libs != nil {
+		t.Error("A failed listing returned libraries", libs)
+	}
+	if len(softerrors) != 1 {
+		t.Error("Expected the softerror of the name, got", softerrors)
+	}
+}
@@ -0,0 +1,26 @@
+package listlibs
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestSortLibraries(t *testing.T) {
+	libs := []library{
+		{path: "/lib/ld.so", base: 0x7f0000001000},
+		{path: "/lib/libc.so", base: 0x7f0000200000},
+		{path: "/usr/lib/libplugin.so", base: 0x7f0000300000},
+		{path: "/lib/libm.so", base: 0x7f1000000000},
+	}
+	expected := []string{"/lib/ld.so", "/lib/libc.so", "/usr/lib/libplugin.so", "/lib/libm.so"}
+
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 10; i++ {
+		shuffled := append([]library(nil), libs...)
+		r.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+		if paths := sortLibraries(shuffled); !reflect.DeepEqual(paths, expected) {
+			t.Fatalf("Expected %v, got %v", expected, paths)
+		}
+	}
+}
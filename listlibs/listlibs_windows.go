@@ -14,13 +14,13 @@ import (
 // #include "listlibs_windows.h"
 import "C"
 
-func listLoadedLibraries(p process.Process) (libraries []string, harderror error, softerrors []error) {
+func listLoadedLibraries(p process.Process) (libraries []library, harderror error, softerrors []error) {
 	r := C.getModules(C.process_handle_t(p.Handle()))
 	defer C.EnumProcessModulesResponse_Free(r)
 	if r.error != 0 {
 		return nil, fmt.Errorf("getModules failed with error: %d", r.error), nil
 	}
-	mods := make([]string, r.length)
+	mods := make([]library, r.length)
 	// We use this to access C arrays without doing manual pointer arithmetic.
 	cmods := *(*[]C.ModuleInfo)(unsafe.Pointer(
 		&reflect.SliceHeader{
@@ -28,7 +28,7 @@ func listLoadedLibraries(p process.Process) (libraries []string, harderror error
 			Len:  int(r.length),
 			Cap:  int(r.length)}))
 	for i, _ := range mods {
-		mods[i] = C.GoString(cmods[i].filename)
+		mods[i] = library{path: C.GoString(cmods[i].filename), base: uintptr(cmods[i].info.lpBaseOfDll)}
 	}
 	return mods, nil, nil
 }
@@ -0,0 +1,133 @@
+// Package masche is the entry point of the library for the most common task: finding some bytes or strings in the
+// memory of the processes with a given name. QuickScan does it with sensible defaults in a single call, and its
+// result keeps the report.ScanReport it was made from, so programs that outgrow it can keep using its results with
+// the report, memsearch and process packages, which give full control of each step.
+package masche
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/polyverse/masche/memsearch"
+	"github.com/polyverse/masche/process"
+	"github.com/polyverse/masche/report"
+)
+
+// QuickScanRequest is what QuickScan looks for, and where.
+type QuickScanRequest struct {
+	// NameRegexp selects the processes scanned by the path of their executable, as process.OpenByName does.
+	NameRegexp string
+	// Patterns and Strings are searched together, at least one is needed. The index of the pattern of each match
+	// counts the Patterns first, and then the Strings.
+	Patterns [][]byte
+	Strings  []string
+	// MaxMatchesPerProcess, if not zero, is the most matches kept for each process, the ones at the lowest addresses.
+	// The report of the result still has every match.
+	MaxMatchesPerProcess int
+}
+
+// ProcessMatches are the matches found in a process.
+type ProcessMatches struct {
+	Pid        int               `json:"pid"`
+	Executable string            `json:"executable"`
+	Matches    []memsearch.Match `json:"matches"`
+	// Truncated is true if the process had more matches than QuickScanRequest.MaxMatchesPerProcess.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// QuickScanSummary counts what a QuickScan did.
+type QuickScanSummary struct {
+	// Processes is the amount of processes whose name matched, and Scanned the amount of them that could be scanned.
+	Processes int `json:"processes"`
+	Scanned   int `json:"scanned"`
+	// WithMatches is the amount of processes with at least a match, and Matches the amount of matches in all of them,
+	// counting the ones left out by MaxMatchesPerProcess.
+	WithMatches int           `json:"withMatches"`
+	Matches     int           `json:"matches"`
+	Duration    time.Duration `json:"duration"`
+}
+
+// QuickScanResult is the result of QuickScan.
+type QuickScanResult struct {
+	// Processes are the scanned processes with at least a match, sorted by pid.
+	Processes []ProcessMatches `json:"processes"`
+	Summary   QuickScanSummary `json:"summary"`
+	// Patterns are the patterns searched, the index of the pattern of each match is in them.
+	Patterns []memsearch.Pattern `json:"-"`
+	// Report is the full report of the scan, which report.Diff compares with later scans and report.ResumeScan
+	// continues if the context was cancelled.
+	Report report.ScanReport `json:"report"`
+	// Softerrors are the problems that didn't stop the scan, like the processes that couldn't be scanned.
+	Softerrors []error `json:"-"`
+}
+
+// QuickScan searches the patterns and strings of req in the processes whose executable matches its regexp. It's the
+// recommended way to start using the library. It scans with the defaults of report.Scan, which verifies the matches,
+// and skips the pages the kernel maps in every process (see memsearch.SkipKernelMappings). Memory that isn't resident
+// is scanned too.
+//
+// If ctx is cancelled, the scan stops and ctx's error is returned with the result found so far. The Cursor of its
+// report resumes it, with the same patterns and memsearch.SkipKernelMappings. An error is also returned if the
+// request is invalid, or if the processes can't be listed.
+func QuickScan(ctx context.Context, req QuickScanRequest) (result QuickScanResult, err error) {
+	start := time.Now()
+	r, err := regexp.Compile(req.NameRegexp)
+	if err != nil {
+		return result, fmt.Errorf("Invalid process name regexp %q (%v)", req.NameRegexp, err)
+	}
+	if req.MaxMatchesPerProcess < 0 {
+		return result, fmt.Errorf("Invalid MaxMatchesPerProcess %d", req.MaxMatchesPerProcess)
+	}
+	for _, bytes := range req.Patterns {
+		result.Patterns = append(result.Patterns, memsearch.Pattern{Bytes: bytes})
+	}
+	for _, s := range req.Strings {
+		result.Patterns = append(result.Patterns, memsearch.Pattern{Bytes: []byte(s)})
+	}
+	compiled, err := memsearch.Compile(result.Patterns)
+	if err != nil {
+		return result, err
+	}
+
+	procs, err, softerrors := process.OpenByName(r)
+	result.Softerrors = softerrors
+	if err != nil {
+		return result, err
+	}
+	defer process.CloseAll(procs)
+
+	opts := memsearch.SearchOptions{
+		Compiled:   compiled,
+		SkipRegion: memsearch.SkipKernelMappings,
+		Checkpoint: func(cursor memsearch.Cursor) bool {
+			return ctx.Err() == nil
+		},
+	}
+	result.Report, err, softerrors = report.Scan(procs, result.Patterns, opts)
+	result.Softerrors = append(result.Softerrors, softerrors...)
+	if err != nil {
+		return result, err
+	}
+
+	result.Summary = QuickScanSummary{Processes: len(procs), Scanned: len(result.Report.Processes)}
+	for _, pr := range result.Report.Processes {
+		if len(pr.Hits) == 0 {
+			continue
+		}
+		matches := ProcessMatches{Pid: pr.Pid, Executable: pr.Executable}
+		for _, hit := range pr.Hits {
+			matches.Matches = append(matches.Matches, hit.Match)
+		}
+		result.Summary.WithMatches++
+		result.Summary.Matches += len(matches.Matches)
+		if req.MaxMatchesPerProcess != 0 && len(matches.Matches) > req.MaxMatchesPerProcess {
+			matches.Matches = matches.Matches[:req.MaxMatchesPerProcess]
+			matches.Truncated = true
+		}
+		result.Processes = append(result.Processes, matches)
+	}
+	result.Summary.Duration = time.Since(start)
+	return result, ctx.Err()
+}
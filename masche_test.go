@@ -0,0 +1,85 @@
+package masche
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"regexp"
+	"testing"
+
+	"github.com/polyverse/masche/report"
+	"github.com/polyverse/masche/test"
+)
+
+func TestQuickScan(t *testing.T) {
+	// A copy of its own is launched twice, so the test cases launched by other tests aren't scanned.
+	exe, err := test.CopyTestCase(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pids []int
+	for i := 0; i < 2; i++ {
+		cmd := exec.Command(exe)
+		if err := test.StartAndWaitForInitialization(cmd); err != nil {
+			t.Fatal(err)
+		}
+		defer cmd.Process.Kill()
+		pids = append(pids, cmd.Process.Pid)
+	}
+
+	req := QuickScanRequest{NameRegexp: "^" + regexp.QuoteMeta(exe) + "$", Patterns: [][]byte{{0xde, 0xad, 0xbe, 0xef}},
+		Strings: []string{"Un dia vi una vaca vestida de uniforme"}}
+	result, err := QuickScan(context.Background(), req)
+	test.PrintSoftErrors(result.Softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	summary := result.Summary
+	if summary.Processes != 2 || summary.Scanned != 2 || summary.WithMatches != 2 || len(result.Processes) != 2 {
+		t.Fatalf("Unexpected result %+v", result)
+	}
+	for i, pm := range result.Processes {
+		if pm.Pid != pids[i] || pm.Truncated || len(pm.Matches) == 0 {
+			t.Errorf("Unexpected matches of process %d: %+v", pids[i], pm)
+		}
+		for _, m := range pm.Matches {
+			if m.Pattern != 1 || string(result.Patterns[m.Pattern].Bytes) != req.Strings[0] {
+				t.Errorf("Unexpected match %v", m)
+			}
+		}
+	}
+	if len(result.Report.Processes) != 2 || len(result.Report.Processes[0].Hits) != len(result.Processes[0].Matches) {
+		t.Errorf("The report doesn't have the matches: %+v", result.Report)
+	}
+
+	// The report can be compared with the one of a scan made with the full API.
+	req.MaxMatchesPerProcess = 1
+	again, err := QuickScan(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := report.Diff(result.Report, again.Report); len(diff.New) != 0 || len(diff.Resolved) != 0 {
+		t.Errorf("The reports of the same processes differ: %+v", diff)
+	}
+	if again.Summary.Matches != summary.Matches || len(again.Processes) != 2 || len(again.Processes[0].Matches) != 1 ||
+		again.Processes[0].Truncated != (len(result.Processes[0].Matches) > 1) {
+
+		t.Errorf("Unexpected truncated result %+v", again)
+	}
+
+	// A cancelled scan can be resumed from its report.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cancelled, err := QuickScan(ctx, req)
+	if !errors.Is(err, context.Canceled) || cancelled.Report.Cursor == nil || len(cancelled.Processes) != 0 {
+		t.Errorf("Unexpected result of a cancelled scan %+v, %v", cancelled, err)
+	}
+
+	for _, invalid := range []QuickScanRequest{{NameRegexp: "("}, {NameRegexp: "x"},
+		{NameRegexp: "x", Strings: []string{"x"}, MaxMatchesPerProcess: -1}} {
+
+		if _, err := QuickScan(context.Background(), invalid); err == nil {
+			t.Errorf("The invalid request %+v was accepted", invalid)
+		}
+	}
+}
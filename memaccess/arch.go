@@ -0,0 +1,48 @@
+package memaccess
+
+import (
+	"github.com/polyverse/masche/common"
+	"github.com/polyverse/masche/process"
+)
+
+// ArchBackend is a MemoryBackend that knows the architecture of the process whose memory it holds, which the
+// features that read pointers or structures from it need. The backends of masche are all ArchBackends.
+type ArchBackend interface {
+	MemoryBackend
+	TargetArch() (arch process.TargetArch, harderror error, softerrors []error)
+}
+
+// BackendArch returns the architecture of the memory of b. It's unknown if b isn't an ArchBackend, as guessing it
+// would make the features that depend on it read garbage.
+func BackendArch(b MemoryBackend) (arch process.TargetArch, harderror error, softerrors []error) {
+	if ab, ok := b.(ArchBackend); ok {
+		return common.Result(ab.TargetArch())
+	}
+	return process.TargetOf(process.ArchUnknown), nil, nil
+}
+
+// TargetArch reads the architecture of the process, see process.Process.Architecture.
+func (b processBackend) TargetArch() (arch process.TargetArch, harderror error, softerrors []error) {
+	a, harderror, softerrors := b.p.Architecture()
+	if harderror != nil {
+		return arch, harderror, softerrors
+	}
+	return process.TargetOf(a), nil, softerrors
+}
+
+// TargetArch returns the architecture the memory of the backend was copied from, which is the one of the current
+// process unless SetTargetArch changed it.
+func (b *StaticBackend) TargetArch() (arch process.TargetArch, harderror error, softerrors []error) {
+	return b.arch, nil, nil
+}
+
+// SetTargetArch sets the architecture the memory of the backend was copied from, like the one of the machine of a
+// remote process.
+func (b *StaticBackend) SetTargetArch(arch process.TargetArch) {
+	b.arch = arch
+}
+
+// TargetArch returns the architecture of the dumped process, from the header of the dump.
+func (c *CoreDump) TargetArch() (arch process.TargetArch, harderror error, softerrors []error) {
+	return process.ElfTarget(c.file.Machine, c.file.Class, c.file.Data), nil, nil
+}
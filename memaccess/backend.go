@@ -0,0 +1,182 @@
+package memaccess
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/polyverse/masche/process"
+)
+
+// MemoryBackend is an address space that can be listed and read: the memory of a live process, a core dump, or any
+// other source of memory. The walks and searches work on any of them.
+type MemoryBackend interface {
+	// Regions returns all the memory regions of the address space, sorted by address and without overlaps.
+	Regions() (regions []MemoryRegion, harderror error, softerrors []error)
+	// ReadAt fills the entire buffer with the memory starting at address. If not all of it can be read it returns a
+	// hard error.
+	ReadAt(address uintptr, buf []byte) (harderror error, softerrors []error)
+	// Info describes the address space.
+	Info() BackendInfo
+}
+
+// BackendInfo describes the address space of a MemoryBackend.
+type BackendInfo struct {
+	// Kind is the kind of backend, like "process" or "core".
+	Kind string `json:"kind"`
+	// Pid is the process the memory belongs to, or zero if it's unknown.
+	Pid int `json:"pid"`
+	// Description identifies the address space in messages.
+	Description string `json:"description"`
+}
+
+func (i BackendInfo) String() string {
+	if i.Description != "" {
+		return i.Description
+	}
+	return i.Kind
+}
+
+// ProcessBackend returns a MemoryBackend that reads the memory of a live process.
+func ProcessBackend(p process.Process) MemoryBackend {
+	return processBackend{p}
+}
+
+type processBackend struct {
+	p process.Process
+}
+
+func (b processBackend) Regions() (regions []MemoryRegion, harderror error, softerrors []error) {
+	return MemoryRegions(b.p)
+}
+
+func (b processBackend) ReadAt(address uintptr, buf []byte) (harderror error, softerrors []error) {
+	return CopyMemory(b.p, address, buf)
+}
+
+func (b processBackend) Info() BackendInfo {
+	return BackendInfo{Kind: "process", Pid: b.p.Pid(), Description: fmt.Sprintf("Process %d", b.p.Pid())}
+}
+
+// Segment is a piece of the memory of a StaticBackend. Data holds the whole region, unless the region isn't readable.
+type Segment struct {
+	Region MemoryRegion
+	Data   []byte
+}
+
+// StaticBackend is a MemoryBackend over memory that was already copied, like a snapshot or test data.
+type StaticBackend struct {
+	info     BackendInfo
+	segments []Segment
+	arch     process.TargetArch
+}
+
+// NewStaticBackend returns a StaticBackend with the given segments, which must not overlap. Its architecture is the
+// one of the current process, see SetTargetArch.
+func NewStaticBackend(info BackendInfo, segments []Segment) (*StaticBackend, error) {
+	segments = append([]Segment(nil), segments...)
+	sort.Slice(segments, func(i, j int) bool { return segments[i].Region.Address < segments[j].Region.Address })
+
+	for i, segment := range segments {
+		region := segment.Region
+		if region.Size == 0 {
+			return nil, fmt.Errorf("Empty segment at %x", region.Address)
+		}
+		if region.Address+uintptr(region.Size) < region.Address {
+			return nil, fmt.Errorf("Segment %v wraps around the address space", region)
+		}
+		if region.Access&Readable != 0 && uint(len(segment.Data)) != region.Size {
+			return nil, fmt.Errorf("Segment %v has %d bytes of data", region, len(segment.Data))
+		}
+		if i > 0 {
+			previous := segments[i-1].Region
+			if previous.Address+uintptr(previous.Size) > region.Address {
+				return nil, fmt.Errorf("Segment %v overlaps %v", region, previous)
+			}
+		}
+	}
+
+	return &StaticBackend{info: info, segments: segments, arch: process.HostTarget()}, nil
+}
+
+func (b *StaticBackend) Regions() (regions []MemoryRegion, harderror error, softerrors []error) {
+	regions = make([]MemoryRegion, 0, len(b.segments))
+	for _, segment := range b.segments {
+		regions = append(regions, segment.Region)
+	}
+	return regions, nil, nil
+}
+
+func (b *StaticBackend) ReadAt(address uintptr, buf []byte) (harderror error, softerrors []error) {
+	for read := 0; read < len(buf); {
+		current := address + uintptr(read)
+		i := sort.Search(len(b.segments), func(i int) bool {
+			region := b.segments[i].Region
+			return region.Address+uintptr(region.Size) > current
+		})
+		if i == len(b.segments) || b.segments[i].Region.Address > current ||
+			b.segments[i].Region.Access&Readable == 0 {
+			return fmt.Errorf("Error while reading %d bytes starting at %x: %x is not readable", len(buf), address,
+				current), nil
+		}
+
+		segment := b.segments[i]
+		read += copy(buf[read:], segment.Data[current-segment.Region.Address:])
+	}
+	return nil, nil
+}
+
+func (b *StaticBackend) Info() BackendInfo {
+	return b.info
+}
+
+// nextReadableRun returns the readable run of regions containing address, starting at address, or the next one after
+// it.
+//
+// If there aren't more regions available the special value NoRegionAvailable is returned.
+func nextReadableRun(regions []MemoryRegion, address uintptr) MemoryRegion {
+	for _, run := range readableRuns(regions) {
+		if run.Address+uintptr(run.Size) <= address {
+			continue
+		}
+		if run.Address < address {
+			run.Size -= uint(address - run.Address)
+			run.Address = address
+		}
+		return run
+	}
+	return NoRegionAvailable
+}
+
+// ImageWatch detects that the process read by a MemoryBackend replaced its image with exec(2), which replaces its
+// whole address space. Backends that don't read a live process never change image.
+type ImageWatch struct {
+	p     process.Process
+	start process.Image
+}
+
+// WatchImage starts watching the image of the process read by b, if any. If its image can't be known, like on the
+// platforms where process.CurrentImage is not implemented, the returned watch never reports changes.
+func WatchImage(b MemoryBackend) *ImageWatch {
+	pb, ok := b.(processBackend)
+	if !ok {
+		return &ImageWatch{}
+	}
+	image, err := process.CurrentImage(pb.p)
+	if err != nil {
+		return &ImageWatch{}
+	}
+	return &ImageWatch{p: pb.p, start: image}
+}
+
+// Check returns a *process.ExecError if the process changed image since the watch started. Errors getting its
+// current image are ignored: the process may have exited, and reading its memory will fail anyway.
+func (w *ImageWatch) Check() error {
+	if w.p == nil {
+		return nil
+	}
+	image, err := process.CurrentImage(w.p)
+	if err != nil || image.Same(w.start) {
+		return nil
+	}
+	return &process.ExecError{Pid: w.p.Pid(), OldExecutable: w.start.Executable, NewExecutable: image.Executable}
+}
@@ -0,0 +1,415 @@
+package memaccess_test
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/polyverse/masche/memaccess"
+	"github.com/polyverse/masche/memaccess/backendtest"
+	"github.com/polyverse/masche/memsearch"
+	"github.com/polyverse/masche/process"
+	"github.com/polyverse/masche/test"
+)
+
+func TestProcessBackend(t *testing.T) {
+	cmd, err := test.LaunchTestCaseAndWaitForInitialization()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	proc, err, softerrors := process.OpenFromPid(cmd.Process.Pid)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proc.Close()
+
+	b := memaccess.ProcessBackend(proc)
+	if info := b.Info(); info.Pid != proc.Pid() {
+		t.Errorf("Expected the info of process %d, got %+v", proc.Pid(), info)
+	}
+	if arch, err, _ := memaccess.BackendArch(b); err != nil || arch != process.HostTarget() {
+		t.Errorf("Expected the test case to run on %v, got %v (%v)", process.HostTarget(), arch, err)
+	}
+
+	literal := []byte("Un dia vi una vaca vestida de uniforme")
+	matches, _, err, softerrors := memsearch.FindAllIn(b, 0, []memsearch.Pattern{{Bytes: literal}},
+		memsearch.SearchOptions{})
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) == 0 {
+		t.Fatal("The string literal of the test case was not found")
+	}
+
+	if err := backendtest.TestBackend(b, backendtest.Known{Address: matches[0].Address, Bytes: literal}); err != nil {
+		t.Error(err)
+	}
+}
+
+func segment(address uintptr, access memaccess.Access, kind string, data []byte) memaccess.Segment {
+	size := uint(len(data))
+	if data == nil {
+		size = 2
+	}
+	return memaccess.Segment{Region: memaccess.MemoryRegion{Address: address, Size: size, Access: access, Kind: kind},
+		Data: data}
+}
+
+// staticSegments are two contiguous segments, an inaccessible one, and one after a hole.
+var staticSegments = []memaccess.Segment{
+	segment(0x1000, memaccess.Readable, "a", []byte{1, 2, 3, 4}),
+	segment(0x1004, memaccess.Readable, "b", []byte{5, 6}),
+	segment(0x1006, memaccess.None, "guard", nil),
+	segment(0x2000, memaccess.Readable|memaccess.Writable, "", []byte{7, 8, 9}),
+}
+
+func TestStaticBackend(t *testing.T) {
+	b, err := memaccess.NewStaticBackend(memaccess.BackendInfo{Kind: "static"}, staticSegments)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = backendtest.TestBackend(b, backendtest.Known{Address: 0x1002, Bytes: []byte{3, 4, 5}},
+		backendtest.Known{Address: 0x2000, Bytes: []byte{7, 8, 9}})
+	if err != nil {
+		t.Error(err)
+	}
+
+	overlapping := append([]memaccess.Segment{segment(0x1fff, memaccess.None, "", nil)}, staticSegments...)
+	if _, err := memaccess.NewStaticBackend(memaccess.BackendInfo{Kind: "static"}, overlapping); err == nil {
+		t.Error("Overlapping segments were accepted")
+	}
+}
+
+func TestWalkBackend(t *testing.T) {
+	b, err := memaccess.NewStaticBackend(memaccess.BackendInfo{Kind: "static"}, staticSegments)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The contiguous readable segments are walked as a single run.
+	var walked [][]byte
+	var addresses []uintptr
+	err, softerrors := memaccess.WalkBackend(b, 0x1001, 3, func(address uintptr, buf []byte) bool {
+		addresses = append(addresses, address)
+		walked = append(walked, append([]byte(nil), buf...))
+		return true
+	})
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedAddresses := []uintptr{0x1001, 0x1004, 0x2000}
+	expected := [][]byte{{2, 3, 4}, {5, 6}, {7, 8, 9}}
+	if len(walked) != len(expected) {
+		t.Fatalf("Expected to walk %x at %x, walked %x at %x", expected, expectedAddresses, walked, addresses)
+	}
+	for i := range expected {
+		if addresses[i] != expectedAddresses[i] || !bytes.Equal(walked[i], expected[i]) {
+			t.Errorf("Expected to walk %x at %x, walked %x at %x", expected, expectedAddresses, walked, addresses)
+			break
+		}
+	}
+}
+
+// The regions at the top of the address space are read without their end, or the address after them, wrapping around
+// to 0.
+func TestTopOfAddressSpace(t *testing.T) {
+	// top is the start of the last page, where the user address space ends.
+	top := ^uintptr(0) &^ 0xfff
+	data := make([]byte, 0x2000)
+	for i := range data {
+		data[i] = byte(i / 0x100)
+	}
+	segments := []memaccess.Segment{segment(top-0x3000, memaccess.None, "guard", nil),
+		segment(top-0x2000, memaccess.Readable, "low", data[:0x1000]),
+		segment(top-0x1000, memaccess.Readable, "high", data[0x1000:])}
+	b, err := memaccess.NewStaticBackend(memaccess.BackendInfo{Kind: "static"}, segments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := backendtest.TestBackend(b, backendtest.Known{Address: top - 2, Bytes: []byte{0x1f, 0x1f}}); err != nil {
+		t.Error(err)
+	}
+
+	wrapping := []memaccess.Segment{segment(top, memaccess.Readable, "", make([]byte, 0x1000))}
+	if _, err := memaccess.NewStaticBackend(memaccess.BackendInfo{Kind: "static"}, wrapping); err == nil {
+		t.Error("A segment wrapping around the address space was accepted")
+	}
+
+	var walked []byte
+	err, _ = memaccess.WalkBackend(b, 0, 0x300, func(address uintptr, buf []byte) bool {
+		walked = append(walked, buf...)
+		return true
+	})
+	if err != nil || !bytes.Equal(walked, data) {
+		t.Errorf("Walked %d bytes up to the top of the address space (%v)", len(walked), err)
+	}
+
+	var last uintptr
+	err, _ = memaccess.SlidingWalkBackend(b, top-0x100, 0x300, func(address uintptr, buf []byte) bool {
+		last = address + uintptr(len(buf))
+		return true
+	})
+	if err != nil || last != top {
+		t.Errorf("The sliding walk ended at %x (%v)", last, err)
+	}
+
+	r := memaccess.NewBackendWindowedReader(b, memaccess.WindowOptions{Prefetch: 2})
+	defer r.Close()
+	window, err, _ := r.ReadWindow(top-0x10, 0x10)
+	if err != nil || !bytes.Equal(window, data[len(data)-0x10:]) {
+		t.Errorf("Read %x at the top of the address space (%v)", window, err)
+	}
+	if _, err, _ := r.ReadWindow(top-0x10, 0x1010); err == nil {
+		t.Error("A window wrapping around the address space should fail")
+	}
+	// Invalidating up to past the end of the address space drops the last pages.
+	r.Invalidate(top-0x10, 0x100000)
+	misses := r.Stats().Misses
+	if _, err, _ := r.ReadWindow(top-0x10, 0x10); err != nil || r.Stats().Misses != misses+1 {
+		t.Errorf("The last page wasn't invalidated (%v)", err)
+	}
+
+	h, err, _ := memaccess.RecordBackendRegionHistory(b, memaccess.HistoryOptions{Interval: time.Hour,
+		ChunkSize: 0x10000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Stop()
+	if hashes := h.Snapshots()[0].ChunkHashes; len(hashes) != 3 || len(hashes[1]) != 1 || len(hashes[2]) != 1 {
+		t.Errorf("Unexpected chunk hashes %v", hashes)
+	}
+}
+
+// coreSegment is a loadable segment of a core dump written by writeCoreDump. If data is shorter than size, the rest
+// of the segment was not dumped.
+type coreSegment struct {
+	address uintptr
+	size    uint64
+	flags   elf.ProgFlag
+	file    string
+	data    []byte
+}
+
+// writeCoreDump writes a little endian 64 bits core dump with the given segments, and notes with the pid and the
+// mapped files.
+func writeCoreDump(t testing.TB, path string, pid int, segments []coreSegment) {
+	order := binary.LittleEndian
+	note := func(buf *bytes.Buffer, noteType uint32, desc []byte) {
+		binary.Write(buf, order, []uint32{5, uint32(len(desc)), noteType})
+		buf.WriteString("CORE\x00\x00\x00\x00")
+		buf.Write(desc)
+		for buf.Len()%4 != 0 {
+			buf.WriteByte(0)
+		}
+	}
+
+	var notes bytes.Buffer
+	status := make([]byte, 336)
+	order.PutUint32(status[32:], uint32(pid))
+	note(&notes, uint32(elf.NT_PRSTATUS), status)
+
+	var files, names bytes.Buffer
+	count := uint64(0)
+	for _, s := range segments {
+		if s.file != "" {
+			binary.Write(&files, order, []uint64{uint64(s.address), uint64(s.address) + s.size, 0})
+			names.WriteString(s.file + "\x00")
+			count++
+		}
+	}
+	var fileNote bytes.Buffer
+	binary.Write(&fileNote, order, []uint64{count, 4096})
+	fileNote.Write(files.Bytes())
+	fileNote.Write(names.Bytes())
+	note(&notes, 0x46494c45, fileNote.Bytes())
+
+	const headerSize, progSize = 64, 56
+	offset := uint64(headerSize + progSize*(1+len(segments)))
+	progs := []elf.Prog64{{Type: uint32(elf.PT_NOTE), Off: offset, Filesz: uint64(notes.Len()), Align: 4}}
+	offset += uint64(notes.Len())
+	for _, s := range segments {
+		progs = append(progs, elf.Prog64{Type: uint32(elf.PT_LOAD), Flags: uint32(s.flags), Off: offset,
+			Vaddr: uint64(s.address), Filesz: uint64(len(s.data)), Memsz: s.size, Align: 4096})
+		offset += uint64(len(s.data))
+	}
+
+	var out bytes.Buffer
+	header := elf.Header64{Type: uint16(elf.ET_CORE), Machine: uint16(elf.EM_X86_64), Version: uint32(elf.EV_CURRENT),
+		Phoff: headerSize, Ehsize: headerSize, Phentsize: progSize, Phnum: uint16(len(progs))}
+	copy(header.Ident[:], elf.ELFMAG)
+	header.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS64)
+	header.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	header.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+	binary.Write(&out, order, header)
+	binary.Write(&out, order, progs)
+	out.Write(notes.Bytes())
+	for _, s := range segments {
+		out.Write(s.data)
+	}
+
+	if err := os.WriteFile(path, out.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCoreDump(t *testing.T) {
+	code := bytes.Repeat([]byte{0x90}, 0x2000)
+	copy(code[0x1ffc:], "Fake")
+	path := filepath.Join(t.TempDir(), "core")
+	writeCoreDump(t, path, 1234, []coreSegment{
+		{0x400000, 0x2000, elf.PF_R | elf.PF_X, "/usr/bin/fake", code},
+		{0x402000, 0x1000, elf.PF_R | elf.PF_W, "", append([]byte("Dump"), make([]byte, 0x1000-4)...)},
+		{0x7f0000000000, 0x1000, elf.PF_R, "/usr/lib/libfake.so", nil},
+	})
+
+	c, err := memaccess.OpenCoreDump(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if info := c.Info(); info.Pid != 1234 || info.Kind != "core" {
+		t.Errorf("Unexpected info %+v", info)
+	}
+	regions, _, _ := c.Regions()
+	if len(regions) != 3 || regions[0].Kind != "/usr/bin/fake" || regions[1].Kind != "" ||
+		regions[2].Kind != "/usr/lib/libfake.so" || regions[0].Access != memaccess.Readable|memaccess.Executable {
+		t.Errorf("Unexpected regions %v", regions)
+	}
+
+	// The library was not dumped.
+	if err, _ := c.ReadAt(0x7f0000000000, make([]byte, 1)); err == nil {
+		t.Error("Reading memory that was not dumped succeeded")
+	}
+
+	err = backendtest.TestBackend(c, backendtest.Known{Address: 0x401ffc, Bytes: []byte("FakeDump")})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBackendArch(t *testing.T) {
+	dir := t.TempDir()
+	native := filepath.Join(dir, "native")
+	writeCoreDump(t, native, 1234, []coreSegment{{0x400000, 0x1000, elf.PF_R, "", make([]byte, 0x1000)}})
+	foreign := []struct {
+		machine elf.Machine
+		class   elf.Class
+		data    elf.Data
+		arch    process.TargetArch
+	}{
+		{elf.EM_ARM, elf.ELFCLASS32, elf.ELFDATA2LSB, process.TargetArch{Arch: process.ArchARM, PointerSize: 4}},
+		{elf.EM_PPC64, elf.ELFCLASS64, elf.ELFDATA2MSB,
+			process.TargetArch{Arch: process.ArchUnknown, PointerSize: 8, BigEndian: true}},
+	}
+
+	check := func(b memaccess.MemoryBackend, expected process.TargetArch) {
+		t.Helper()
+		if arch, err, _ := memaccess.BackendArch(b); err != nil || arch != expected {
+			t.Errorf("Expected the architecture of %v to be %v, got %v (%v)", b.Info(), expected, arch, err)
+		}
+	}
+	c, err := memaccess.OpenCoreDump(native)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	check(c, process.TargetOf(process.ArchX86_64))
+
+	for i, f := range foreign {
+		path := filepath.Join(dir, fmt.Sprint(i))
+		if err := backendtest.WriteCoreDump(path, f.machine, f.class, f.data, 0x10000, []byte("Foreign")); err != nil {
+			t.Fatal(err)
+		}
+		c, err := memaccess.OpenCoreDump(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		check(c, f.arch)
+		if err := backendtest.TestBackend(c, backendtest.Known{Address: 0x10000, Bytes: []byte("Foreign")}); err != nil {
+			t.Error(err)
+		}
+	}
+
+	b, err := memaccess.NewStaticBackend(memaccess.BackendInfo{Kind: "static"}, staticSegments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(b, process.HostTarget())
+	b.SetTargetArch(foreign[0].arch)
+	check(b, foreign[0].arch)
+	// Backends that don't know their architecture don't guess it.
+	check(struct{ memaccess.MemoryBackend }{b}, process.TargetArch{Arch: process.ArchUnknown})
+}
+
+func TestRegionHistoryBounds(t *testing.T) {
+	b, err := memaccess.NewStaticBackend(memaccess.BackendInfo{Kind: "static"}, staticSegments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err, _ := memaccess.RecordBackendRegionHistory(b, memaccess.HistoryOptions{Interval: time.Hour,
+		MaxSnapshots: 3, ChunkSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Stop()
+	for i := 0; i < 9; i++ {
+		if err, _ := h.Snapshot(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	snapshots := h.Snapshots()
+	if len(snapshots) != 3 || h.Dropped() != 7 || snapshots[0].Time.After(snapshots[2].Time) {
+		t.Fatalf("Expected the 3 newest snapshots, got %d and %d dropped", len(snapshots), h.Dropped())
+	}
+	if len(snapshots[0].ChunkHashes) != len(snapshots[0].Regions) {
+		t.Errorf("Expected the chunk hashes of every region, got %v", snapshots[0].ChunkHashes)
+	}
+
+	data, err := json.Marshal(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var exported struct {
+		Snapshots []struct {
+			Generation uint64
+			Regions    []map[string]interface{}
+		}
+		Dropped int
+	}
+	if err := json.Unmarshal(data, &exported); err != nil {
+		t.Fatal(err)
+	}
+	if len(exported.Snapshots) != 3 || exported.Dropped != 7 ||
+		exported.Snapshots[2].Generation != snapshots[2].Generation ||
+		exported.Snapshots[2].Regions[0]["address"] != "0x1000" {
+
+		t.Errorf("Unexpected export %s", data)
+	}
+
+	// A byte budget smaller than two snapshots keeps only the newest one.
+	small, err, _ := memaccess.RecordBackendRegionHistory(b, memaccess.HistoryOptions{Interval: time.Hour,
+		MaxBytes: 300})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer small.Stop()
+	small.Snapshot()
+	if len(small.Snapshots()) != 1 || small.Dropped() != 1 {
+		t.Errorf("Expected a single snapshot, got %d and %d dropped", len(small.Snapshots()), small.Dropped())
+	}
+}
@@ -0,0 +1,165 @@
+// This package checks that implementations of memaccess.MemoryBackend behave like the ones in masche, so new backends
+// can verify themselves in their tests.
+package backendtest
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/polyverse/masche/memaccess"
+)
+
+// Known is memory whose contents are known beforehand.
+type Known struct {
+	Address uintptr
+	Bytes   []byte
+}
+
+// maxChecked is the maximum amount of regions whose reads are checked, to keep the checks fast on big address spaces.
+const maxChecked = 64
+
+// TestBackend checks that b follows the MemoryBackend contract and that it has the known memory. It returns an error
+// describing every problem found, or nil.
+//
+// Memory that can be read but changes between reads, like the one of a running process, may make it fail.
+// Use known memory that doesn't change.
+func TestBackend(b memaccess.MemoryBackend, known ...Known) error {
+	var problems []string
+	fail := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	info := b.Info()
+	if info.Kind == "" {
+		fail("Info() has no Kind: %+v", info)
+	}
+
+	// The architecture of the memory must be known, or unknown with a reason.
+	if ab, ok := b.(memaccess.ArchBackend); ok {
+		if arch, err, _ := ab.TargetArch(); err == nil && !arch.Known() {
+			fail("TargetArch() is unknown without an error")
+		}
+	}
+
+	regions, harderror, _ := b.Regions()
+	if harderror != nil {
+		return fmt.Errorf("%v: Regions() failed: %v", info, harderror)
+	}
+	if len(regions) == 0 {
+		fail("Regions() returned no regions")
+	}
+
+	for i, region := range regions {
+		if region.Size == 0 {
+			fail("%v is empty", region)
+		}
+		if region.Address+uintptr(region.Size) < region.Address {
+			fail("%v wraps around the address space", region)
+		}
+		if i > 0 && regions[i-1].Address+uintptr(regions[i-1].Size) > region.Address {
+			fail("%v is not sorted or overlaps %v", region, regions[i-1])
+		}
+	}
+
+	for _, k := range known {
+		if !readable(regions, k.Address, len(k.Bytes)) {
+			fail("Known bytes at %x are not in readable regions", k.Address)
+			continue
+		}
+		buf := make([]byte, len(k.Bytes))
+		if err, _ := b.ReadAt(k.Address, buf); err != nil {
+			fail("Unable to read the known bytes at %x: %v", k.Address, err)
+		} else if !bytes.Equal(buf, k.Bytes) {
+			fail("Read %x at %x, expected %x", buf, k.Address, k.Bytes)
+		}
+	}
+
+	checked := 0
+	for _, region := range regions {
+		if region.Access&memaccess.Readable == 0 || checked == maxChecked {
+			continue
+		}
+		checked++
+		checkReads(b, region, fail)
+	}
+
+	// Every address space has a hole somewhere: before the first region, between two of them or after the last one.
+	if hole, ok := findHole(regions); ok {
+		if err, _ := b.ReadAt(hole, make([]byte, 1)); err == nil {
+			fail("Reading unmapped memory at %x succeeded", hole)
+		}
+		if hole > 0 && readable(regions, hole-1, 1) {
+			if err, _ := b.ReadAt(hole-1, make([]byte, 2)); err == nil {
+				fail("Reading past the end of the memory at %x succeeded", hole)
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%v:\n%s", info, strings.Join(problems, "\n"))
+	}
+	return nil
+}
+
+// checkReads checks that reading the start of region at once and in two halves gives the same bytes, and that empty
+// reads succeed. Regions that can't be read at all are allowed, as some memory can't be read even if its permissions
+// say so.
+func checkReads(b memaccess.MemoryBackend, region memaccess.MemoryRegion, fail func(string, ...interface{})) {
+	size := region.Size
+	if size > 4096 {
+		size = 4096
+	}
+
+	whole := make([]byte, size)
+	if err, _ := b.ReadAt(region.Address, whole); err != nil {
+		return
+	}
+
+	half := size / 2
+	parts := make([]byte, size)
+	err1, _ := b.ReadAt(region.Address, parts[:half])
+	err2, _ := b.ReadAt(region.Address+uintptr(half), parts[half:])
+	if err1 != nil || err2 != nil {
+		fail("%v can be read at once but not in two parts: %v, %v", region, err1, err2)
+	} else if !bytes.Equal(whole, parts) {
+		fail("%v reads different bytes at once and in two parts", region)
+	}
+
+	if err, _ := b.ReadAt(region.Address, nil); err != nil {
+		fail("Empty read at %x failed: %v", region.Address, err)
+	}
+}
+
+// readable tells if the size bytes at address are all in readable regions.
+func readable(regions []memaccess.MemoryRegion, address uintptr, size int) bool {
+	end := address + uintptr(size)
+	for _, region := range regions {
+		regionEnd := region.Address + uintptr(region.Size)
+		if regionEnd <= address || region.Address > address {
+			continue
+		}
+		if region.Access&memaccess.Readable == 0 {
+			return false
+		}
+		if regionEnd >= end {
+			return true
+		}
+		address = regionEnd
+	}
+	return false
+}
+
+// findHole returns an address that isn't in any region, preferably right after the end of one.
+func findHole(regions []memaccess.MemoryRegion) (uintptr, bool) {
+	for i, region := range regions {
+		end := region.Address + uintptr(region.Size)
+		if end != 0 && (i+1 == len(regions) || regions[i+1].Address > end) {
+			return end, true
+		}
+	}
+	if len(regions) > 0 && regions[0].Address > 0 {
+		return regions[0].Address - 1, true
+	}
+	return 0, false
+}
@@ -0,0 +1,45 @@
+package backendtest
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"os"
+)
+
+// WriteCoreDump writes to path an ELF core dump of a process of any architecture, given by the machine, class and
+// data of its header, whose memory is a single readable and writable segment holding memory at address. It's the
+// fixture that tests the features that depend on the architecture against processes of other machines.
+func WriteCoreDump(path string, machine elf.Machine, class elf.Class, data elf.Data, address uint64,
+	memory []byte) error {
+
+	var order binary.ByteOrder = binary.LittleEndian
+	if data == elf.ELFDATA2MSB {
+		order = binary.BigEndian
+	}
+	ident := [elf.EI_NIDENT]byte{}
+	copy(ident[:], elf.ELFMAG)
+	ident[elf.EI_CLASS], ident[elf.EI_DATA], ident[elf.EI_VERSION] = byte(class), byte(data), byte(elf.EV_CURRENT)
+	flags := uint32(elf.PF_R | elf.PF_W)
+
+	var out bytes.Buffer
+	switch class {
+	case elf.ELFCLASS32:
+		const headerSize, progSize = 52, 32
+		binary.Write(&out, order, elf.Header32{Ident: ident, Type: uint16(elf.ET_CORE), Machine: uint16(machine),
+			Version: uint32(elf.EV_CURRENT), Phoff: headerSize, Ehsize: headerSize, Phentsize: progSize, Phnum: 1})
+		binary.Write(&out, order, elf.Prog32{Type: uint32(elf.PT_LOAD), Flags: flags, Off: headerSize + progSize,
+			Vaddr: uint32(address), Filesz: uint32(len(memory)), Memsz: uint32(len(memory)), Align: 4096})
+	case elf.ELFCLASS64:
+		const headerSize, progSize = 64, 56
+		binary.Write(&out, order, elf.Header64{Ident: ident, Type: uint16(elf.ET_CORE), Machine: uint16(machine),
+			Version: uint32(elf.EV_CURRENT), Phoff: headerSize, Ehsize: headerSize, Phentsize: progSize, Phnum: 1})
+		binary.Write(&out, order, elf.Prog64{Type: uint32(elf.PT_LOAD), Flags: flags, Off: headerSize + progSize,
+			Vaddr: address, Filesz: uint64(len(memory)), Memsz: uint64(len(memory)), Align: 4096})
+	default:
+		return fmt.Errorf("Unknown ELF class %v", class)
+	}
+	out.Write(memory)
+	return os.WriteFile(path, out.Bytes(), 0600)
+}
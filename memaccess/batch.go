@@ -0,0 +1,60 @@
+package memaccess
+
+import (
+	"github.com/polyverse/masche/common"
+	"github.com/polyverse/masche/process"
+)
+
+// ReadRequest is one of the reads of a batch.
+type ReadRequest struct {
+	Address uintptr
+	Size    uint
+}
+
+// ReadResult is the result of a ReadRequest: either all its Data, or the error that prevented reading it.
+type ReadResult struct {
+	Data []byte
+	Err  error
+}
+
+// BatchReader is implemented by the MemoryBackends that do many reads at once cheaper than one by one.
+type BatchReader interface {
+	// ReadBatch reads every request, and returns their results in the same order. A harderror is only returned if
+	// none of them can be read.
+	ReadBatch(reqs []ReadRequest) (results []ReadResult, harderror error, softerrors []error)
+}
+
+// ReadBatch reads many independent pieces of the memory of p at once. On Linux they are read with as few
+// process_vm_readv(2) calls as possible, instead of a system call per request, if CapabilityBatchRead is available.
+//
+// A request that can't be read doesn't stop the others: its result has the error. A harderror is only returned if
+// the process can't be read at all.
+func ReadBatch(p process.Process, reqs []ReadRequest) (results []ReadResult, harderror error, softerrors []error) {
+	return ReadBackendBatch(ProcessBackend(p), reqs)
+}
+
+// ReadBackendBatch works as ReadBatch, but it reads the memory of any MemoryBackend. If b isn't a BatchReader the
+// requests are read one by one.
+func ReadBackendBatch(b MemoryBackend, reqs []ReadRequest) (results []ReadResult, harderror error,
+	softerrors []error) {
+
+	if batcher, ok := b.(BatchReader); ok {
+		return common.Result(batcher.ReadBatch(reqs))
+	}
+	return common.Result(readEach(b, reqs))
+}
+
+func readEach(b MemoryBackend, reqs []ReadRequest) (results []ReadResult, harderror error, softerrors []error) {
+	results = make([]ReadResult, len(reqs))
+	for i, req := range reqs {
+		buf := make([]byte, req.Size)
+		err, serrs := b.ReadAt(req.Address, buf)
+		softerrors = append(softerrors, serrs...)
+		if err != nil {
+			results[i].Err = err
+		} else {
+			results[i].Data = buf
+		}
+	}
+	return results, nil, softerrors
+}
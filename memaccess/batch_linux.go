@@ -0,0 +1,88 @@
+package memaccess
+
+import (
+	"fmt"
+	"runtime"
+	"syscall"
+	"unsafe"
+
+	"github.com/polyverse/masche/common"
+	"github.com/polyverse/masche/process"
+)
+
+// sysProcessVMReadv is the number of process_vm_readv(2), which syscall lacks in some architectures. It's zero in the
+// ones it's unknown.
+var sysProcessVMReadv = map[string]uintptr{"386": 347, "amd64": 310, "arm": 376, "arm64": 270}[runtime.GOARCH]
+
+// iovMax is the most iovecs process_vm_readv takes in a call.
+const iovMax = 1024
+
+// remoteIovec is a struct iovec with an address of another process.
+type remoteIovec struct {
+	base   uintptr
+	length uintptr
+}
+
+func (b processBackend) ReadBatch(reqs []ReadRequest) (results []ReadResult, harderror error, softerrors []error) {
+	if sysProcessVMReadv == 0 || !common.HostAvailable(CapabilityBatchRead) {
+		return readEach(b, reqs)
+	}
+	if err := process.VerifyMatch(b.p); err != nil {
+		return nil, err, nil
+	}
+
+	// The data of all the requests shares a buffer, and every request with data gets an iovec.
+	total := uint(0)
+	for _, req := range reqs {
+		total += req.Size
+	}
+	data := make([]byte, total)
+	results = make([]ReadResult, len(reqs))
+	local := make([]syscall.Iovec, 0, len(reqs))
+	remote := make([]remoteIovec, 0, len(reqs))
+	owners := make([]int, 0, len(reqs))
+	for i, req := range reqs {
+		results[i].Data, data = data[:req.Size:req.Size], data[req.Size:]
+		if req.Size == 0 {
+			continue
+		}
+		l := syscall.Iovec{Base: &results[i].Data[0]}
+		l.SetLen(int(req.Size))
+		local = append(local, l)
+		remote = append(remote, remoteIovec{base: req.Address, length: uintptr(req.Size)})
+		owners = append(owners, i)
+	}
+
+	for next := 0; next < len(local); {
+		count := len(local) - next
+		if count > iovMax {
+			count = iovMax
+		}
+		n, _, errno := syscall.Syscall6(sysProcessVMReadv, uintptr(b.p.Pid()),
+			uintptr(unsafe.Pointer(&local[next])), uintptr(count),
+			uintptr(unsafe.Pointer(&remote[next])), uintptr(count), 0)
+		switch errno {
+		case 0:
+		case syscall.EFAULT:
+			// The first request isn't readable.
+			n = 0
+		case syscall.ENOSYS:
+			return readEach(b, reqs)
+		default:
+			return nil, fmt.Errorf("Error while reading the memory of process %d: %v", b.p.Pid(), errno), softerrors
+		}
+
+		// The kernel stops at the first address it can't read, so the requests before it were read entirely.
+		end := next + count
+		for ; next < end && remote[next].length <= n; next++ {
+			n -= remote[next].length
+		}
+		if next < end {
+			req := reqs[owners[next]]
+			results[owners[next]] = ReadResult{Err: fmt.Errorf("Error while reading %d bytes starting at %x: only "+
+				"%d of them are readable", req.Size, req.Address, n)}
+			next++
+		}
+	}
+	return results, nil, softerrors
+}
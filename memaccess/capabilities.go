@@ -0,0 +1,49 @@
+package memaccess
+
+import (
+	"fmt"
+
+	"github.com/polyverse/masche/common"
+	"github.com/polyverse/masche/process"
+)
+
+// Features of this package in the capabilities matrix.
+const (
+	// CapabilityMemoryRead is reading the memory of other processes, with CopyMemory, WalkMemory and the rest.
+	CapabilityMemoryRead = "memory-read"
+	// CapabilityBatchRead is reading many small pieces of memory in a few system calls with ReadBatch. Without it,
+	// ReadBatch reads them one by one.
+	CapabilityBatchRead = "batch-read"
+)
+
+func init() {
+	common.RegisterCapability(CapabilityMemoryRead, func(pid int) (common.Availability, string) {
+		if pid == 0 {
+			return common.Available, ""
+		}
+		return accessProbe(pid)
+	})
+	common.RegisterCapability(CapabilityBatchRead, batchReadProbe)
+}
+
+// accessProbe tells if the memory of the process with the given pid can be read, from its AccessLevel.
+func accessProbe(pid int) (common.Availability, string) {
+	p, harderror, _ := process.OpenFromPid(pid)
+	if harderror != nil {
+		return common.NeedsPrivilege, harderror.Error()
+	}
+	defer p.Close()
+
+	level, harderror, softerrors := p.AccessLevel()
+	if harderror != nil {
+		return common.NeedsPrivilege, harderror.Error()
+	}
+	if level != process.FullAccess {
+		reason := fmt.Sprintf("the access level of process %d is %v", pid, level)
+		if len(softerrors) > 0 {
+			reason += fmt.Sprintf(" (%v)", softerrors[0])
+		}
+		return common.NeedsPrivilege, reason
+	}
+	return common.Available, ""
+}
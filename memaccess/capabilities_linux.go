@@ -0,0 +1,32 @@
+package memaccess
+
+import (
+	"fmt"
+	"runtime"
+	"syscall"
+
+	"github.com/polyverse/masche/common"
+)
+
+// batchReadProbe makes an empty process_vm_readv call to find out if the kernel has it, and if the seccomp filter lets
+// us make it. The kernel returns early from empty calls, so processes are probed by their AccessLevel instead, which
+// needs the same permission.
+func batchReadProbe(pid int) (common.Availability, string) {
+	if pid != 0 {
+		return accessProbe(pid)
+	}
+	if sysProcessVMReadv == 0 {
+		return common.UnsupportedPlatform, fmt.Sprintf("the number of process_vm_readv is unknown on %s",
+			runtime.GOARCH)
+	}
+	_, _, errno := syscall.Syscall6(sysProcessVMReadv, uintptr(syscall.Getpid()), 0, 0, 0, 0, 0)
+	switch errno {
+	case 0:
+		return common.Available, ""
+	case syscall.ENOSYS:
+		return common.UnsupportedKernel, "the kernel lacks process_vm_readv"
+	case syscall.EPERM:
+		return common.NeedsPrivilege, "process_vm_readv is forbidden by a seccomp filter"
+	}
+	return common.UnsupportedKernel, fmt.Sprintf("process_vm_readv failed (%v)", errno)
+}
@@ -0,0 +1,11 @@
+// +build windows darwin
+
+package memaccess
+
+import (
+	"github.com/polyverse/masche/common"
+)
+
+func batchReadProbe(pid int) (common.Availability, string) {
+	return common.UnsupportedPlatform, "batched reads are only implemented on linux"
+}
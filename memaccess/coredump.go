@@ -0,0 +1,202 @@
+package memaccess
+
+import (
+	"bytes"
+	"debug/elf"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+// ntFile is the type of the note listing the files mapped by the process, see the kernel's fs/binfmt_elf.c.
+const ntFile = 0x46494c45
+
+// CoreDump is a MemoryBackend that reads the memory saved in an ELF core dump.
+//
+// Its regions are the loadable segments of the dump. Segments whose memory wasn't dumped, like the code of
+// libraries, are listed but can't be read. Their kind is the mapped file, when the dump says it.
+type CoreDump struct {
+	path     string
+	f        *os.File
+	file     *elf.File
+	segments []*elf.Prog
+	regions  []MemoryRegion
+	pid      int
+}
+
+// OpenCoreDump opens the core dump at path. It must be closed when it's not needed anymore.
+func OpenCoreDump(path string) (*CoreDump, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	file, err := elf.NewFile(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if file.Type != elf.ET_CORE {
+		f.Close()
+		return nil, fmt.Errorf("%s is not a core dump", path)
+	}
+
+	c := &CoreDump{path: path, f: f, file: file}
+	files := make(map[uintptr]string)
+	for _, prog := range file.Progs {
+		switch prog.Type {
+		case elf.PT_LOAD:
+			if prog.Memsz > 0 {
+				c.segments = append(c.segments, prog)
+			}
+		case elf.PT_NOTE:
+			if err := c.readNotes(prog, files); err != nil {
+				f.Close()
+				return nil, fmt.Errorf("Invalid notes in %s (%v)", path, err)
+			}
+		}
+	}
+	sort.Slice(c.segments, func(i, j int) bool { return c.segments[i].Vaddr < c.segments[j].Vaddr })
+
+	for _, prog := range c.segments {
+		access := None
+		if prog.Flags&elf.PF_R != 0 {
+			access |= Readable
+		}
+		if prog.Flags&elf.PF_W != 0 {
+			access |= Writable
+		}
+		if prog.Flags&elf.PF_X != 0 {
+			access |= Executable
+		}
+		c.regions = append(c.regions, MemoryRegion{Address: uintptr(prog.Vaddr), Size: uint(prog.Memsz),
+			Access: access, Kind: files[uintptr(prog.Vaddr)]})
+	}
+
+	return c, nil
+}
+
+// readNotes takes the pid from the first NT_PRSTATUS note, and the mapped files from the NT_FILE one.
+func (c *CoreDump) readNotes(prog *elf.Prog, files map[uintptr]string) error {
+	data, err := ioutil.ReadAll(prog.Open())
+	if err != nil {
+		return err
+	}
+
+	order := c.file.ByteOrder
+	wordSize := 8
+	if c.file.Class == elf.ELFCLASS32 {
+		wordSize = 4
+	}
+	word := func(b []byte) uint64 {
+		if wordSize == 4 {
+			return uint64(order.Uint32(b))
+		}
+		return order.Uint64(b)
+	}
+	align := func(n uint32) int { return int((n + 3) &^ 3) }
+
+	foundStatus := false
+	for len(data) >= 12 {
+		nameSize, descSize, noteType := order.Uint32(data), order.Uint32(data[4:]), order.Uint32(data[8:])
+		data = data[12:]
+		if align(nameSize)+align(descSize) > len(data) {
+			return fmt.Errorf("note of type %x is truncated", noteType)
+		}
+		desc := data[align(nameSize) : align(nameSize)+int(descSize)]
+		data = data[align(nameSize)+align(descSize):]
+
+		switch {
+		case noteType == uint32(elf.NT_PRSTATUS) && !foundStatus:
+			// pr_pid follows the signal info, the current signal and the pending and held signal masks.
+			offset := 12 + 4 + 2*wordSize
+			if len(desc) >= offset+4 {
+				c.pid = int(order.Uint32(desc[offset:]))
+				foundStatus = true
+			}
+		case noteType == ntFile:
+			// A count and the page size, then the start, end and offset of each mapping, and then their names.
+			if len(desc) < 2*wordSize {
+				return fmt.Errorf("NT_FILE note is truncated")
+			}
+			count := int(word(desc))
+			entries := desc[2*wordSize:]
+			if count < 0 || len(entries) < count*3*wordSize {
+				return fmt.Errorf("NT_FILE note is truncated")
+			}
+			names := bytes.Split(entries[count*3*wordSize:], []byte{0})
+			if len(names) < count {
+				return fmt.Errorf("NT_FILE note has %d names for %d files", len(names), count)
+			}
+			for i := 0; i < count; i++ {
+				files[uintptr(word(entries[i*3*wordSize:]))] = string(names[i])
+			}
+		}
+	}
+	return nil
+}
+
+// Close closes the core dump file.
+func (c *CoreDump) Close() error {
+	return c.f.Close()
+}
+
+// Pid returns the pid of the dumped process, or zero if the dump doesn't say it.
+func (c *CoreDump) Pid() int {
+	return c.pid
+}
+
+func (c *CoreDump) Regions() (regions []MemoryRegion, harderror error, softerrors []error) {
+	return append([]MemoryRegion(nil), c.regions...), nil, nil
+}
+
+func (c *CoreDump) ReadAt(address uintptr, buf []byte) (harderror error, softerrors []error) {
+	for read := 0; read < len(buf); {
+		current := uint64(address) + uint64(read)
+		i := sort.Search(len(c.segments), func(i int) bool {
+			return c.segments[i].Vaddr+c.segments[i].Memsz > current
+		})
+		if i == len(c.segments) || c.segments[i].Vaddr > current {
+			return fmt.Errorf("Error while reading %d bytes starting at %x: %x is not in the core dump", len(buf),
+				address, current), nil
+		}
+
+		prog := c.segments[i]
+		offset := current - prog.Vaddr
+		if offset >= prog.Filesz {
+			return fmt.Errorf("Error while reading %d bytes starting at %x: %x was not dumped", len(buf), address,
+				current), nil
+		}
+		n := len(buf) - read
+		if uint64(n) > prog.Filesz-offset {
+			n = int(prog.Filesz - offset)
+		}
+		if _, err := prog.ReadAt(buf[read:read+n], int64(offset)); err != nil {
+			return fmt.Errorf("Error while reading %d bytes starting at %x: %v", len(buf), address, err), nil
+		}
+		read += n
+	}
+	return nil, nil
+}
+
+// AdviseSequential tells the kernel that the parts of the dump with the memory from address to address+size will be
+// read sequentially, which makes CoreDump an Advisor.
+func (c *CoreDump) AdviseSequential(address uintptr, size uint) error {
+	start, end := uint64(address), uint64(address)+uint64(size)
+	for _, prog := range c.segments {
+		dumpedEnd := prog.Vaddr + prog.Filesz
+		if dumpedEnd <= start || prog.Vaddr >= end {
+			continue
+		}
+		from, to := max(start, prog.Vaddr), min(end, dumpedEnd)
+		err := adviseSequentialFile(c.f, int64(prog.Off+from-prog.Vaddr), int64(to-from))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *CoreDump) Info() BackendInfo {
+	return BackendInfo{Kind: "core", Pid: c.pid, Description: fmt.Sprintf("Core dump %s", c.path)}
+}
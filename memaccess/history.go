@@ -0,0 +1,276 @@
+package memaccess
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/polyverse/masche/process"
+)
+
+// HistoryOptions modifies the behaviour of a RegionHistory. Its zero value is a sensible default.
+type HistoryOptions struct {
+	// Interval is the time between snapshots. If it's zero DefaultHistoryInterval is used.
+	Interval time.Duration
+	// MaxSnapshots and MaxBytes bound the history, the oldest snapshots are dropped to stay within them. MaxBytes is
+	// an estimate of the memory used by the snapshots. If they are zero DefaultHistorySnapshots and
+	// DefaultHistoryBytes are used.
+	MaxSnapshots int
+	MaxBytes     int
+	// ChunkSize, if not zero, also hashes the contents of the readable regions in chunks of ChunkSize bytes, which
+	// tells what memory changed and not only what was mapped. It reads all the memory at every snapshot.
+	ChunkSize uint
+}
+
+// Defaults of the HistoryOptions, an hour of history taking up to 16MB.
+const (
+	DefaultHistoryInterval  = 10 * time.Second
+	DefaultHistorySnapshots = 360
+	DefaultHistoryBytes     = 16 << 20
+)
+
+// RegionSnapshot is the memory map of a process at some time.
+type RegionSnapshot struct {
+	Time time.Time `json:"time"`
+	// Generation is the hash of the regions, as returned by RegionsGeneration.
+	Generation uint64         `json:"generation"`
+	Regions    []MemoryRegion `json:"regions"`
+	// ChunkHashes are the hashes of the chunks of each region, in the order of Regions, if they were enabled in the
+	// options. Regions that couldn't be read have none.
+	ChunkHashes [][]uint64 `json:"chunkHashes,omitempty"`
+}
+
+// size estimates the memory used by the snapshot.
+func (s RegionSnapshot) size() int {
+	size := 64
+	for _, region := range s.Regions {
+		size += 40 + len(region.Kind)
+	}
+	for _, hashes := range s.ChunkHashes {
+		size += 24 + 8*len(hashes)
+	}
+	return size
+}
+
+// Appearance tells when a region appeared in the memory map.
+type Appearance struct {
+	// Region is the region as it was when it appeared.
+	Region MemoryRegion `json:"region"`
+	// FirstSeen is the time of the first snapshot with the region, and LastAbsent the time of the snapshot before it,
+	// so the region was mapped between them. LastAbsent is zero if the region is in the oldest snapshot of the
+	// history, which means it may be older.
+	FirstSeen  time.Time `json:"firstSeen"`
+	LastAbsent time.Time `json:"lastAbsent,omitempty"`
+}
+
+// RegionHistory records the memory map of a process periodically, so it can be looked at after the fact, like when
+// an alert fires about a region that may not be mapped anymore. The history is kept in a ring buffer of bounded size.
+type RegionHistory struct {
+	b    MemoryBackend
+	opts HistoryOptions
+
+	mu sync.Mutex
+	// ring holds the snapshots from first, the oldest, on. count of them are valid.
+	ring    []RegionSnapshot
+	first   int
+	count   int
+	bytes   int
+	dropped int
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// RecordRegionHistory starts recording the memory map of p. The first snapshot is taken before it returns, and a
+// harderror is returned if it can't be. Stop stops recording.
+func RecordRegionHistory(p process.Process, opts HistoryOptions) (h *RegionHistory, harderror error,
+	softerrors []error) {
+
+	return RecordBackendRegionHistory(ProcessBackend(p), opts)
+}
+
+// RecordBackendRegionHistory works as RecordRegionHistory, but it records the memory map of any MemoryBackend.
+func RecordBackendRegionHistory(b MemoryBackend, opts HistoryOptions) (h *RegionHistory, harderror error,
+	softerrors []error) {
+
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultHistoryInterval
+	}
+	if opts.MaxSnapshots <= 0 {
+		opts.MaxSnapshots = DefaultHistorySnapshots
+	}
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = DefaultHistoryBytes
+	}
+	h = &RegionHistory{b: b, opts: opts, ring: make([]RegionSnapshot, opts.MaxSnapshots), done: make(chan struct{})}
+	if harderror, softerrors = h.Snapshot(); harderror != nil {
+		return nil, harderror, softerrors
+	}
+	h.wg.Add(1)
+	go h.record()
+	return h, nil, softerrors
+}
+
+// record takes a snapshot every interval until the history is stopped. Snapshots that fail are skipped, the gap in
+// the history shows them.
+func (h *RegionHistory) record() {
+	defer h.wg.Done()
+	ticker := time.NewTicker(h.opts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.done:
+			return
+		case <-ticker.C:
+			h.Snapshot()
+		}
+	}
+}
+
+// Stop stops recording. The history can still be queried.
+func (h *RegionHistory) Stop() {
+	select {
+	case <-h.done:
+		return
+	default:
+	}
+	close(h.done)
+	h.wg.Wait()
+}
+
+// Snapshot takes a snapshot now, besides the periodic ones.
+func (h *RegionHistory) Snapshot() (harderror error, softerrors []error) {
+	regions, harderror, softerrors := h.b.Regions()
+	if harderror != nil {
+		return harderror, softerrors
+	}
+	snapshot := RegionSnapshot{Time: time.Now(), Generation: regionsHash(regions), Regions: regions}
+	if h.opts.ChunkSize > 0 {
+		snapshot.ChunkHashes = make([][]uint64, len(regions))
+		for i, region := range regions {
+			if region.Access&Readable == 0 {
+				continue
+			}
+			hashes, err, serrs := chunkHashes(h.b, region, h.opts.ChunkSize)
+			softerrors = append(softerrors, serrs...)
+			if err != nil {
+				softerrors = append(softerrors, fmt.Errorf("Unable to hash %v: %v", region, err))
+				continue
+			}
+			snapshot.ChunkHashes[i] = hashes
+		}
+	}
+	h.add(snapshot)
+	return nil, softerrors
+}
+
+func chunkHashes(b MemoryBackend, region MemoryRegion, chunkSize uint) (hashes []uint64, harderror error,
+	softerrors []error) {
+
+	buf := make([]byte, chunkSize)
+	// Counting the bytes left instead of comparing with the end of the region keeps the address from wrapping around
+	// in the last chunk of a region at the top of the address space.
+	for offset := uint(0); offset < region.Size; offset += chunkSize {
+		chunk := buf
+		if region.Size-offset < chunkSize {
+			chunk = buf[:region.Size-offset]
+		}
+		harderror, serrs := b.ReadAt(region.Address+uintptr(offset), chunk)
+		softerrors = append(softerrors, serrs...)
+		if harderror != nil {
+			return nil, harderror, softerrors
+		}
+		h := fnv.New64a()
+		h.Write(chunk)
+		hashes = append(hashes, h.Sum64())
+	}
+	return hashes, nil, softerrors
+}
+
+// add appends a snapshot, dropping the oldest ones to stay within the bounds.
+func (h *RegionHistory) add(snapshot RegionSnapshot) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	size := snapshot.size()
+	for h.count > 0 && (h.count == len(h.ring) || h.bytes+size > h.opts.MaxBytes) {
+		h.bytes -= h.ring[h.first].size()
+		h.ring[h.first] = RegionSnapshot{}
+		h.first = (h.first + 1) % len(h.ring)
+		h.count--
+		h.dropped++
+	}
+	if size > h.opts.MaxBytes {
+		// Not even the new snapshot fits.
+		h.dropped++
+		return
+	}
+	h.ring[(h.first+h.count)%len(h.ring)] = snapshot
+	h.count++
+	h.bytes += size
+}
+
+// Snapshots returns the snapshots in the history, the oldest first.
+func (h *RegionHistory) Snapshots() []RegionSnapshot {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	snapshots := make([]RegionSnapshot, h.count)
+	for i := range snapshots {
+		snapshots[i] = h.ring[(h.first+i)%len(h.ring)]
+	}
+	return snapshots
+}
+
+// Dropped returns the amount of snapshots dropped to keep the history within its bounds.
+func (h *RegionHistory) Dropped() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.dropped
+}
+
+// At returns the memory map at t: the last snapshot taken at or before t. It returns false if t is before the oldest
+// snapshot in the history.
+func (h *RegionHistory) At(t time.Time) (snapshot RegionSnapshot, ok bool) {
+	snapshots := h.Snapshots()
+	i := sort.Search(len(snapshots), func(i int) bool { return snapshots[i].Time.After(t) })
+	if i == 0 {
+		return RegionSnapshot{}, false
+	}
+	return snapshots[i-1], true
+}
+
+// FirstSeen tells when the region starting at address appeared, the last time it did if it was mapped many times. It
+// returns false if the region isn't in any snapshot of the history.
+func (h *RegionHistory) FirstSeen(address uintptr) (appearance Appearance, ok bool) {
+	snapshots := h.Snapshots()
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		region, found := regionAt(snapshots[i].Regions, address)
+		if !found {
+			if ok {
+				appearance.LastAbsent = snapshots[i].Time
+				return appearance, true
+			}
+			continue
+		}
+		appearance, ok = Appearance{Region: region, FirstSeen: snapshots[i].Time}, true
+	}
+	return appearance, ok
+}
+
+func regionAt(regions []MemoryRegion, address uintptr) (MemoryRegion, bool) {
+	i := sort.Search(len(regions), func(i int) bool { return regions[i].Address >= address })
+	if i < len(regions) && regions[i].Address == address {
+		return regions[i], true
+	}
+	return MemoryRegion{}, false
+}
+
+// MarshalJSON exports the history, with the amount of snapshots dropped from it.
+func (h *RegionHistory) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Snapshots []RegionSnapshot `json:"snapshots"`
+		Dropped   int              `json:"dropped"`
+	}{h.Snapshots(), h.Dropped()})
+}
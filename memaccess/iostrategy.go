@@ -0,0 +1,92 @@
+package memaccess
+
+import "os"
+
+// IOStrategy is how a walk reads the memory of a backend: the size of its reads, and the advice the kernel is given
+// about the files behind them.
+type IOStrategy struct {
+	// ReadSize is the size of each read. As regions are page aligned, the reads of a multiple of the page size are
+	// page aligned too.
+	ReadSize uint `json:"readSize"`
+	// Sequential advises the kernel that the files behind the backend are read sequentially, so it reads ahead
+	// further. It only applies to the backends that are Advisors; the mem file of a process has no readahead.
+	Sequential bool `json:"sequential"`
+}
+
+var (
+	// MemFileStrategy is the strategy of the backends of processes. Their mem file has no readahead and every read is
+	// a system call, so the reads are larger than the default buffer of the searches, but not so large that they
+	// don't fit in the caches of the CPU, which makes them slower again.
+	MemFileStrategy = IOStrategy{ReadSize: 256 * 1024}
+	// RegularFileStrategy is the strategy of the backends read from regular files, like core dumps: large reads, with
+	// the kernel reading ahead of them.
+	RegularFileStrategy = IOStrategy{ReadSize: 256 * 1024, Sequential: true}
+	// InMemoryStrategy is the strategy of the rest of the backends, whose memory is already in the memory of this
+	// process. Their reads are copies, so they are sized to fit in the caches of the CPU.
+	InMemoryStrategy = IOStrategy{ReadSize: 64 * 1024}
+)
+
+// Advisor is implemented by the MemoryBackends read from regular files, which can advise the kernel of how their
+// memory will be read.
+type Advisor interface {
+	// AdviseSequential tells the kernel that the files behind the memory from address to address+size will be read
+	// sequentially, so it reads ahead further. It's only a hint: errors can be ignored.
+	AdviseSequential(address uintptr, size uint) error
+}
+
+// IOStrategyFor picks the strategy of b: RegularFileStrategy for Advisors, MemFileStrategy for the backends of
+// processes and InMemoryStrategy for the rest.
+func IOStrategyFor(b MemoryBackend) IOStrategy {
+	switch b.(type) {
+	case Advisor:
+		return RegularFileStrategy
+	case processBackend:
+		return MemFileStrategy
+	}
+	return InMemoryStrategy
+}
+
+// AdviseSequentialFile tells the kernel that the length bytes of f from offset will be read sequentially, with
+// posix_fadvise(2). A length of zero is up to the end of the file. Telling it that they will be needed soon instead
+// made the walks slower, as the kernel reads them while the walk waits. It's only implemented on Linux, on the 64 bits
+// architectures; elsewhere it does nothing.
+func AdviseSequentialFile(f *os.File, offset int64, length int64) error {
+	return adviseSequentialFile(f, offset, length)
+}
+
+// WalkBackendWithStrategy works as WalkBackend, reading with strategy. If strategy is the zero value, the one picked
+// by IOStrategyFor is used.
+func WalkBackendWithStrategy(b MemoryBackend, startAddress uintptr, strategy IOStrategy, walkFn WalkFunc) (
+	harderror error, softerrors []error) {
+
+	if strategy == (IOStrategy{}) {
+		strategy = IOStrategyFor(b)
+	}
+	if strategy.ReadSize == 0 {
+		strategy.ReadSize = IOStrategyFor(b).ReadSize
+	}
+	if advisor, ok := b.(Advisor); ok && strategy.Sequential {
+		b = &advisingBackend{MemoryBackend: b, advisor: advisor}
+	}
+	return WalkBackend(b, startAddress, strategy.ReadSize, walkFn)
+}
+
+// advisingBackend advises the kernel that the memory of the backend is read sequentially, from each address a read
+// doesn't continue the last one at.
+type advisingBackend struct {
+	MemoryBackend
+	advisor Advisor
+	// next is where the last read ended.
+	next uintptr
+}
+
+func (b *advisingBackend) ReadAt(address uintptr, buf []byte) (harderror error, softerrors []error) {
+	if address != b.next {
+		regions, err, _ := b.Regions()
+		if err == nil {
+			b.advisor.AdviseSequential(address, nextReadableRun(regions, address).Size)
+		}
+	}
+	b.next = address + uintptr(len(buf))
+	return b.MemoryBackend.ReadAt(address, buf)
+}
@@ -0,0 +1,25 @@
+package memaccess
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+)
+
+// sysFadvise64 is the number of the fadvise64 system call on the 64 bits architectures, whose arguments aren't split
+// in halves.
+var sysFadvise64 = map[string]uintptr{"amd64": 221, "arm64": 223}[runtime.GOARCH]
+
+// fadvSequential is the POSIX_FADV_SEQUENTIAL advice of posix_fadvise(2).
+const fadvSequential = 2
+
+func adviseSequentialFile(f *os.File, offset int64, length int64) error {
+	if sysFadvise64 == 0 {
+		return nil
+	}
+	_, _, errno := syscall.Syscall6(sysFadvise64, f.Fd(), uintptr(offset), uintptr(length), fadvSequential, 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package memaccess_test
+
+import (
+	"bytes"
+	"debug/elf"
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"testing"
+
+	"github.com/polyverse/masche/memaccess"
+	"github.com/polyverse/masche/process"
+	"github.com/polyverse/masche/test"
+)
+
+// advisedBackend records the ranges it's advised to read sequentially.
+type advisedBackend struct {
+	*memaccess.StaticBackend
+	advised []memaccess.MemoryRegion
+}
+
+func (b *advisedBackend) AdviseSequential(address uintptr, size uint) error {
+	b.advised = append(b.advised, memaccess.MemoryRegion{Address: address, Size: size})
+	return nil
+}
+
+func TestWalkBackendWithStrategy(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789abcdef"), 0x10000)
+	static, err := memaccess.NewStaticBackend(memaccess.BackendInfo{Kind: "static"}, []memaccess.Segment{
+		segment(0x100000, memaccess.Readable, "", data),
+		segment(0x800000, memaccess.Readable, "", data[:0x1000]),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &advisedBackend{StaticBackend: static}
+	if memaccess.IOStrategyFor(b) != memaccess.RegularFileStrategy ||
+		memaccess.IOStrategyFor(static) != memaccess.InMemoryStrategy ||
+		memaccess.IOStrategyFor(memaccess.ProcessBackend(process.GetProcess(os.Getpid()))) !=
+			memaccess.MemFileStrategy {
+
+		t.Error("Unexpected strategies picked")
+	}
+
+	var walked []byte
+	var sizes []int
+	err, softerrors := memaccess.WalkBackendWithStrategy(b, 0, memaccess.IOStrategy{ReadSize: 0x10000,
+		Sequential: true}, func(address uintptr, buf []byte) bool {
+		walked = append(walked, buf...)
+		sizes = append(sizes, len(buf))
+		return true
+	})
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(walked, append(append([]byte(nil), data...), data[:0x1000]...)) || sizes[0] != 0x10000 {
+		t.Errorf("Unexpected walk of %d bytes in reads of %v", len(walked), sizes)
+	}
+	// Each region is read sequentially, from start to end.
+	if len(b.advised) != 2 || b.advised[0].Address != 0x100000 || b.advised[0].Size != uint(len(data)) ||
+		b.advised[1].Address != 0x800000 || b.advised[1].Size != 0x1000 {
+
+		t.Errorf("Unexpected advice %v", b.advised)
+	}
+
+	// Without Sequential the backend isn't advised.
+	b.advised = nil
+	memaccess.WalkBackendWithStrategy(b, 0, memaccess.InMemoryStrategy, func(uintptr, []byte) bool { return true })
+	if len(b.advised) != 0 {
+		t.Errorf("Unexpected advice %v", b.advised)
+	}
+}
+
+// dropFileCache evicts the pages of the file at path from the page cache, so it's read from the disk again.
+func dropFileCache(tb testing.TB, path string) {
+	sysFadvise64 := map[string]uintptr{"amd64": 221, "arm64": 223}[runtime.GOARCH]
+	if sysFadvise64 == 0 {
+		tb.Skip("Dropping the page cache is only implemented on amd64 and arm64")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer f.Close()
+	// Dirty pages aren't evicted, they must be written first.
+	if err := f.Sync(); err != nil {
+		tb.Fatal(err)
+	}
+	const fadvDontNeed = 4
+	if _, _, errno := syscall.Syscall6(sysFadvise64, f.Fd(), 0, 0, fadvDontNeed, 0, 0); errno != 0 {
+		tb.Fatal(errno)
+	}
+}
+
+// benchmarkWalkCoreDump walks a 64 MiB core dump with a cold cache, with strategy.
+func benchmarkWalkCoreDump(b *testing.B, strategy memaccess.IOStrategy) {
+	const size = 64 << 20
+	path := filepath.Join(b.TempDir(), "core")
+	writeCoreDump(b, path, 1234, []coreSegment{{0x400000, size, elf.PF_R | elf.PF_W, "", make([]byte, size)}})
+	c, err := memaccess.OpenCoreDump(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer c.Close()
+
+	b.SetBytes(size)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		dropFileCache(b, path)
+		b.StartTimer()
+		if err, _ := memaccess.WalkBackendWithStrategy(c, 0, strategy, func(uintptr, []byte) bool {
+			return true
+		}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// The default read size of the searches, without advice.
+func BenchmarkWalkCoreDumpUnadvised(b *testing.B) {
+	benchmarkWalkCoreDump(b, memaccess.IOStrategy{ReadSize: 64 * 1024})
+}
+
+func BenchmarkWalkCoreDumpStrategy(b *testing.B) {
+	benchmarkWalkCoreDump(b, memaccess.IOStrategy{})
+}
+
+// benchmarkWalkProcess walks the memory of the test case with strategy.
+func benchmarkWalkProcess(b *testing.B, strategy memaccess.IOStrategy) {
+	cmd, err := test.LaunchTestCaseAndWaitForInitialization()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+	proc, err, _ := process.OpenFromPid(cmd.Process.Pid)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer proc.Close()
+
+	backend := memaccess.ProcessBackend(proc)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var walked int64
+		memaccess.WalkBackendWithStrategy(backend, 0, strategy, func(address uintptr, buf []byte) bool {
+			walked += int64(len(buf))
+			return true
+		})
+		b.SetBytes(walked)
+	}
+}
+
+func BenchmarkWalkProcessSmallReads(b *testing.B) {
+	benchmarkWalkProcess(b, memaccess.IOStrategy{ReadSize: 64 * 1024})
+}
+
+func BenchmarkWalkProcessStrategy(b *testing.B) {
+	benchmarkWalkProcess(b, memaccess.IOStrategy{})
+}
@@ -0,0 +1,9 @@
+// +build windows darwin
+
+package memaccess
+
+import "os"
+
+func adviseSequentialFile(f *os.File, offset int64, length int64) error {
+	return nil
+}
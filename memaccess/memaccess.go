@@ -2,9 +2,14 @@
 package memaccess
 
 import (
+	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/polyverse/masche/common"
 	"github.com/polyverse/masche/process"
+	"hash/fnv"
+	"sort"
 	"strconv"
 )
 
@@ -82,24 +87,86 @@ var NoRegionAvailable MemoryRegion
 // NextMemoryRegion returns the next memory region at or after address
 //
 // If there aren't more regions available the special value NoRegionAvailable is returned.
+//
+// If address is inside a region, the returned region starts at address. That way, a walk that continues from the end
+// of each returned region visits every address at most once, even if the memory of the process is mapped and
+// unmapped while it runs. Changes behind the walk's address are not seen though; use RegionsGeneration to detect
+// them.
 func NextMemoryRegion(p process.Process, address uintptr) (region MemoryRegion, harderror error, softerrors []error) {
-	return nextMemoryRegion(p, address)
+	if provided, ok := p.(*providedProcess); ok {
+		var regions []MemoryRegion
+		regions, harderror, softerrors = provided.regions()
+		if harderror == nil {
+			region = regionAfter(regions, address)
+		}
+	} else {
+		region, harderror, softerrors = nextMemoryRegion(p, address)
+	}
+	if harderror == nil && region != NoRegionAvailable && region.Address < address {
+		region.Size -= uint(address - region.Address)
+		region.Address = address
+	}
+	return common.Result(region, harderror, softerrors)
+}
+
+// MemoryRegions returns all the memory regions of a process, sorted by address. On Linux they are a consistent
+// snapshot of its memory map. See RegisterRegionProvider to take them from elsewhere.
+func MemoryRegions(p process.Process) (regions []MemoryRegion, harderror error, softerrors []error) {
+	regions, harderror, softerrors = allRegions(p)
+	sortRegions(regions)
+	return common.Result(regions, harderror, softerrors)
+}
+
+// sortRegions sorts regions by address. The platforms list them in that order already, but no caller should depend on
+// it.
+func sortRegions(regions []MemoryRegion) {
+	sort.SliceStable(regions, func(i, j int) bool { return regions[i].Address < regions[j].Address })
+}
+
+// ErrRegionsChanged is reported as a softerror by the walks during which the memory regions of the process changed.
+var ErrRegionsChanged = errors.New("memory regions changed during the walk")
+
+// RegionsGeneration returns a hash of the memory regions of a process. It changes when regions are mapped, unmapped
+// or changed, so comparing it before and after an operation tells if the memory map changed meanwhile.
+func RegionsGeneration(p process.Process) (generation uint64, harderror error, softerrors []error) {
+	regions, harderror, softerrors := MemoryRegions(p)
+	if harderror != nil {
+		return 0, harderror, softerrors
+	}
+	return regionsHash(regions), nil, softerrors
+}
+
+func regionsHash(regions []MemoryRegion) uint64 {
+	h := fnv.New64a()
+	buf := make([]byte, 17)
+	for _, region := range regions {
+		binary.LittleEndian.PutUint64(buf, uint64(region.Address))
+		binary.LittleEndian.PutUint64(buf[8:], uint64(region.Size))
+		buf[16] = byte(region.Access)
+		h.Write(buf)
+		h.Write([]byte(region.Kind))
+		h.Write([]byte{0})
+	}
+	return h.Sum64()
 }
 
 // NextMemoryRegionAccess returns the next memory region at or after address at least the given access
 //
 // If there aren't more regions available the special value NoRegionAvailable is returned.
 func NextMemoryRegionAccess(p process.Process, address uintptr, access Access) (region MemoryRegion, harderror error, softerrors []error) {
-	region, harderror, softerrors = NextMemoryRegion(p, address)
-	if (harderror != nil) || (region == NoRegionAvailable) {
-		return NoRegionAvailable, harderror, softerrors
-	}
+	var errs common.Softerrors
+	for {
+		region, harderror, softerrors = NextMemoryRegion(p, address)
+		errs.Add(softerrors...)
+		if (harderror != nil) || (region == NoRegionAvailable) {
+			return NoRegionAvailable, harderror, errs
+		}
 
-	if (region.Access & access) != access {
-		region, harderror, softerrors = NextMemoryRegionAccess(p, region.Address+uintptr(region.Size), access)
+		if (region.Access & access) == access {
+			return region, nil, errs
+		}
+		address = region.Address + uintptr(region.Size)
 	}
-
-	return region, harderror, softerrors
 }
 
 // NextReadableMemoryRegion returns a memory region containing address, or the next readable region after address in
@@ -108,8 +175,13 @@ func NextMemoryRegionAccess(p process.Process, address uintptr, access Access) (
 // If there aren't more regions available the special value NoRegionAvailable is returned.
 func NextReadableMemoryRegion(p process.Process, address uintptr) (region MemoryRegion, harderror error, softerrors []error) {
 	r1, h1, s1 := NextMemoryRegionAccess(p, address, Readable)
+	if (h1 != nil) || (r1 == NoRegionAvailable) {
+		return NoRegionAvailable, h1, s1
+	}
+
 	for {
-		r2, h2, _ := NextMemoryRegionAccess(p, r1.Address+uintptr(r1.Size), Readable)
+		r2, h2, s2 := NextMemoryRegionAccess(p, r1.Address+uintptr(r1.Size), Readable)
+		s1 = append(s1, s2...)
 		if (h2 != nil) || (r2 == NoRegionAvailable) || (r2.Address > r1.Address+uintptr(r1.Size)) {
 			break
 		} // if
@@ -117,8 +189,7 @@ func NextReadableMemoryRegion(p process.Process, address uintptr) (region Memory
 		r1.Size += r2.Size
 	}
 
-	return r1, h1, s1
-	// return NextMemoryRegionAccess(p, address, Readable)
+	return r1, nil, s1
 }
 
 // CopyMemory fills the entire buffer with memory from the process starting in address (in the process address space).
@@ -135,69 +206,107 @@ type WalkFunc func(address uintptr, buf []byte) (keepSearching bool)
 // and calling walkFn with the buffer and the start address of the memory in the buffer. If walkFn returns false
 // WalkMemory stop reading the memory.
 //
+// The regions to read are taken from a snapshot of the memory map made when the walk starts, and no address is read
+// twice. If the memory map changed during the walk an error wrapping ErrRegionsChanged is added to the softerrors.
+// If the process replaced its image with exec(2), the walk stops with a *process.ExecError: walkFn may have been called
+// with memory of both images.
+//
 // NOTE: It can call to walkFn with a smaller buffer when reading the last part of a memory region.
 func WalkMemory(p process.Process, startAddress uintptr, bufSize uint, walkFn WalkFunc) (harderror error,
 	softerrors []error) {
+	return WalkBackend(ProcessBackend(p), startAddress, bufSize, walkFn)
+}
 
-	var region MemoryRegion
-	region, harderror, softerrors = NextReadableMemoryRegion(p, startAddress)
+// WalkBackend works as WalkMemory, but it reads the memory of any MemoryBackend.
+func WalkBackend(b MemoryBackend, startAddress uintptr, bufSize uint, walkFn WalkFunc) (harderror error,
+	softerrors []error) {
+
+	watch := WatchImage(b)
+	regions, harderror, softerrors := b.Regions()
 	if harderror != nil {
 		return
 	}
-
-	// The first region can start befor startAddress. If that happens, it must contain it. In that case, we set the
-	// region's Adrress to startAdress to behave as documented.
-	if region.Address < startAddress {
-		if region.Address+uintptr(region.Size) <= startAddress {
-			harderror = fmt.Errorf("First memory region doesn't contain the startAddress. This is a bug.")
-			return
+	generation := regionsHash(regions)
+	defer func() {
+		if harderror == nil {
+			if harderror = watch.Check(); harderror != nil {
+				return
+			}
 		}
-
-		region.Size -= uint(startAddress - region.Address)
-		region.Address = startAddress
-	}
+		current, err, _ := b.Regions()
+		if err == nil && regionsHash(current) != generation {
+			softerrors = append(softerrors, fmt.Errorf("%v: %w", b.Info(), ErrRegionsChanged))
+		}
+	}()
 
 	const max_retries int = 5
 
 	buf := make([]byte, bufSize)
-	retries := max_retries
-
-	for region != NoRegionAvailable {
 
-		keepWalking, addr, err, serrs := walkRegion(p, region, buf, walkFn)
-		softerrors = append(softerrors, serrs...)
+	// Nothing below cursor is read again.
+	cursor := startAddress
+	for _, region := range readableRuns(regions) {
+		end := region.Address + uintptr(region.Size)
+		if end <= cursor {
+			continue
+		}
+		if region.Address < cursor {
+			region.Size -= uint(cursor - region.Address)
+			region.Address = cursor
+		}
+		if err := watch.Check(); err != nil {
+			return err, softerrors
+		}
 
-		if err != nil && retries > 0 {
-			// An error occurred: retry using the nearest region to the address that failed.
-			retries--
-			region, harderror, serrs = NextReadableMemoryRegion(p, addr)
+		for retries := max_retries; ; retries-- {
+			keepWalking, addr, err, serrs := walkRegion(b, region, buf, walkFn)
 			softerrors = append(softerrors, serrs...)
-			if harderror != nil {
-				return
+			cursor = region.Address + uintptr(region.Size)
+
+			if err == nil {
+				if !keepWalking {
+					return
+				}
+				break
+			} else if retries == 0 {
+				// we have exceeded our retries, mark the error as soft error and keep going.
+				softerrors = append(softerrors, &common.LocatedError{Pid: b.Info().Pid, Address: addr,
+					Err: fmt.Errorf("Retries exceeded on reading %d bytes starting at %x: %s", len(buf), addr, err.Error())})
+				break
 			}
 
-			// if some chunk of this new region was already read we don't want to read it again.
-			if region.Address < addr {
-				region.Address = addr
+			// An error occurred: retry using the current region at the address that failed, as it may have changed.
+			current, err, serrs := b.Regions()
+			softerrors = append(softerrors, serrs...)
+			if err != nil {
+				return err, softerrors
 			}
+			region = nextReadableRun(current, addr)
+			if region == NoRegionAvailable {
+				return
+			}
+		}
+	}
+	return
+}
 
+// readableRuns merges the contiguous readable regions, and drops the rest.
+func readableRuns(regions []MemoryRegion) (runs []MemoryRegion) {
+	for _, region := range regions {
+		if region.Access&Readable == 0 {
 			continue
-		} else if err != nil {
-			// we have exceeded our retries, mark the error as soft error and keep going.
-			softerrors = append(softerrors, fmt.Errorf("Retries exceeded on reading %d bytes starting at %x: %s",
-				len(buf), addr, err.Error()))
-		} else if !keepWalking {
-			return
 		}
 
-		region, harderror, serrs = NextReadableMemoryRegion(p, region.Address+uintptr(region.Size))
-		softerrors = append(softerrors, serrs...)
-		if harderror != nil {
-			return
+		if len(runs) > 0 {
+			last := &runs[len(runs)-1]
+			if last.Address+uintptr(last.Size) == region.Address {
+				last.Size += region.Size
+				continue
+			}
 		}
-		retries = max_retries
+		runs = append(runs, region)
 	}
-	return
+	return runs
 }
 
 // This function walks through a single memory region calling walkFunc with a given buffer. It always fills as much of
@@ -208,7 +317,7 @@ func WalkMemory(p process.Process, startAddress uintptr, bufSize uint, walkFn Wa
 //
 // If any of the calls to walkFn returns false, this function inmediatly returns, with keepWalking set to false and no
 // hard error.
-func walkRegion(p process.Process, region MemoryRegion, buf []byte, walkFn WalkFunc) (keepWalking bool,
+func walkRegion(b MemoryBackend, region MemoryRegion, buf []byte, walkFn WalkFunc) (keepWalking bool,
 	errorAddress uintptr, harderror error, softerrors []error) {
 	softerrors = make([]error, 0)
 	keepWalking = true
@@ -218,7 +327,7 @@ func walkRegion(p process.Process, region MemoryRegion, buf []byte, walkFn WalkF
 			buf = buf[:remainingBytes]
 		}
 
-		err, serrs := CopyMemory(p, addr, buf)
+		err, serrs := b.ReadAt(addr, buf)
 		softerrors = append(softerrors, serrs...)
 
 		if err != nil {
@@ -245,6 +354,12 @@ func walkRegion(p process.Process, region MemoryRegion, buf []byte, walkFn WalkF
 // NOTE: It doesn't work with odd bufSize.
 func SlidingWalkMemory(p process.Process, startAddress uintptr, bufSize uint, walkFn WalkFunc) (
 	harderror error, softerrors []error) {
+	return SlidingWalkBackend(ProcessBackend(p), startAddress, bufSize, walkFn)
+}
+
+// SlidingWalkBackend works as SlidingWalkMemory, but it reads the memory of any MemoryBackend.
+func SlidingWalkBackend(b MemoryBackend, startAddress uintptr, bufSize uint, walkFn WalkFunc) (
+	harderror error, softerrors []error) {
 
 	if bufSize%2 != 0 {
 		return fmt.Errorf("SlidingWalkMemory doesn't support odd bufferSizes"), softerrors
@@ -254,7 +369,7 @@ func SlidingWalkMemory(p process.Process, startAddress uintptr, bufSize uint, wa
 	halfBufferSize := bufSize / 2
 	currentBufferStartsAt := uintptr(0)
 	bufferedBytes := uint(0)
-	harderror, softerrors = WalkMemory(p, startAddress, halfBufferSize,
+	harderror, softerrors = WalkBackend(b, startAddress, halfBufferSize,
 		func(address uintptr, currentBuffer []byte) (keepSearching bool) {
 
 			fromAnotherRegion := currentBufferStartsAt+uintptr(bufferedBytes) < address && currentBufferStartsAt != 0
@@ -32,6 +32,18 @@ func nextMemoryRegion(p process.Process, address uintptr) (region MemoryRegion,
 	return MemoryRegion{uintptr(cRegion.start_address), uint(cRegion.length), Access(cRegion.access), C.GoString(cRegion.kind)}, harderror, softerrors
 }
 
+func memoryRegions(p process.Process) (regions []MemoryRegion, harderror error, softerrors []error) {
+	region, harderror, softerrors := nextMemoryRegion(p, 0)
+	for harderror == nil && region != NoRegionAvailable {
+		regions = append(regions, region)
+
+		var serrs []error
+		region, harderror, serrs = nextMemoryRegion(p, region.Address+uintptr(region.Size))
+		softerrors = append(softerrors, serrs...)
+	}
+	return regions, harderror, softerrors
+}
+
 func copyMemory(p process.Process, address uintptr, buffer []byte) (harderror error, softerrors []error) {
 	buf := unsafe.Pointer(&buffer[0])
 
@@ -59,3 +71,13 @@ func copyMemory(p process.Process, address uintptr, buffer []byte) (harderror er
 
 	return
 }
+
+func readInto(p process.Process, address uintptr, buf []byte) (n int, err error) {
+	if len(buf) == 0 {
+		return 0, nil
+	}
+	if harderror, _ := copyMemory(p, address, buf); harderror != nil {
+		return 0, harderror
+	}
+	return len(buf), nil
+}
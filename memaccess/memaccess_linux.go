@@ -9,64 +9,65 @@ import (
 )
 
 func nextMemoryRegion(p process.Process, address uintptr) (region MemoryRegion, harderror error, softerrors []error) {
+	regions, harderror, softerrors := memoryRegions(p)
+	if harderror != nil {
+		return
+	}
 
-	mapsFile, harderror := os.Open(common.MapsFilePathFromPid(uint(p.Pid())))
+	return regionAfter(regions, address), nil, softerrors
+}
+
+func memoryRegions(p process.Process) (regions []MemoryRegion, harderror error, softerrors []error) {
+	mapsFile, harderror := process.OpenResource(p, process.MapsResource)
 	if harderror != nil {
 		return
 	}
 	defer mapsFile.Close()
 
-	region = MemoryRegion{}
 	scanner := bufio.NewScanner(mapsFile)
 
 	for scanner.Scan() {
-		line := scanner.Text()
-		items := common.SplitMapsFileEntry(line)
-
-		if len(items) != 6 {
-			return region, fmt.Errorf("Unrecognised maps line: %s", line), softerrors
-		}
-
-		start, end, err := common.ParseMapsFileMemoryLimits(items[0])
+		entry, err := common.ParseMapsFileEntry(scanner.Text())
 		if err != nil {
-			return region, err, softerrors
+			return nil, err, softerrors
 		}
 
 		// Skip vsyscall as it can't be read. It's a special page mapped by the kernel to accelerate some syscalls.
-		if items[5] == "[vsyscall]" {
-			continue
-		}
-
-		if end <= address {
+		if entry.Path == "[vsyscall]" {
 			continue
 		}
 
 		access := None
-		if items[1][0] != '-' {
+		if entry.Permissions[0] != '-' {
 			access += Readable
 		}
-		if items[1][1] != '-' {
+		if entry.Permissions[1] != '-' {
 			access += Writable
 		}
-		if items[1][2] != '-' {
+		if entry.Permissions[2] != '-' {
 			access += Executable
 		}
-		return MemoryRegion{Address: start, Size: uint(end - start), Access: access, Kind: items[5]}, nil, softerrors
+		regions = append(regions, MemoryRegion{Address: entry.Start, Size: uint(entry.End - entry.Start),
+			Access: access, Kind: entry.Path})
 	}
 
-	return NoRegionAvailable, nil, softerrors
+	return regions, scanner.Err(), softerrors
 }
 
 func copyMemory(p process.Process, address uintptr, buffer []byte) (harderror error, softerrors []error) {
-	mem, harderror := os.Open(common.MemFilePathFromPid(uint(p.Pid())))
+	mem, harderror := process.OpenResource(p, process.MemResource)
 
 	if harderror != nil {
-		harderror := fmt.Errorf("Error while reading %d bytes starting at %x: %s", len(buffer), address, harderror)
+		harderror := fmt.Errorf("Error while reading %d bytes starting at %x: %w", len(buffer), address, harderror)
 		return harderror, softerrors
 	}
 	defer mem.Close()
 
-	bytes_read, harderror := mem.ReadAt(buffer, int64(address))
+	offset, harderror := common.MemFileOffset(address, len(buffer))
+	if harderror != nil {
+		return harderror, softerrors
+	}
+	bytes_read, harderror := mem.ReadAt(buffer, offset)
 	if harderror != nil {
 		harderror := fmt.Errorf("Error while reading %d bytes starting at %x: %s", len(buffer), address, harderror)
 		return harderror, softerrors
@@ -78,3 +79,30 @@ func copyMemory(p process.Process, address uintptr, buffer []byte) (harderror er
 
 	return nil, softerrors
 }
+
+func readInto(p process.Process, address uintptr, buf []byte) (n int, err error) {
+	offset, err := common.MemFileOffset(address, len(buf))
+	if err != nil {
+		return 0, err
+	}
+
+	// Only the preopened file is read without allocating, a new one is an *os.File in the heap. Both are read as an
+	// *os.File, as reading through an interface would move buf to the heap.
+	mem := process.PreopenedFile(p, process.MemResource)
+	if mem == nil {
+		if err := process.VerifyMatch(p); err != nil {
+			return 0, err
+		}
+		path := common.MemFilePathFromPid(uint(p.Pid()))
+		if mem, err = os.Open(path); err != nil {
+			return 0, fmt.Errorf("Error while reading %d bytes starting at %x: %w", len(buf), address,
+				process.ProcFileError(p.Pid(), path, err))
+		}
+		defer mem.Close()
+	}
+	n, err = mem.ReadAt(buf, offset)
+	if err != nil {
+		return n, fmt.Errorf("Error while reading %d bytes starting at %x: %v", len(buf), address, err)
+	}
+	return n, nil
+}
@@ -0,0 +1,1051 @@
+package memaccess
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/polyverse/masche/common"
+	"github.com/polyverse/masche/process"
+	"github.com/polyverse/masche/test"
+)
+
+func TestWalksUnderChurn(t *testing.T) {
+	cmd, err := test.LaunchTestCaseAndWaitForInitialization("--churn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	proc, err, softerrors := process.OpenFromPid(cmd.Process.Pid)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proc.Close()
+
+	changed := false
+	for start := time.Now(); time.Since(start) < time.Second; {
+		cursor := uintptr(0)
+		for {
+			region, err, _ := NextMemoryRegion(proc, cursor)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if region == NoRegionAvailable {
+				break
+			}
+			if region.Address < cursor {
+				t.Fatalf("%v was returned after reaching %x", region, cursor)
+			}
+			cursor = region.Address + uintptr(region.Size)
+		}
+
+		cursor = 0
+		err, softerrors := WalkMemory(proc, 0, 4096, func(address uintptr, buf []byte) bool {
+			if address < cursor {
+				t.Fatalf("%x was walked after reaching %x", address, cursor)
+			}
+			cursor = address + uintptr(len(buf))
+			return true
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, err := range softerrors {
+			changed = changed || errors.Is(err, ErrRegionsChanged)
+		}
+	}
+
+	if !changed {
+		t.Error("The changes of the memory regions were never detected")
+	}
+}
+
+// failingBackend fails to list its regions, with a softerror explaining why.
+type failingBackend struct{}
+
+var errFailingSoft = errors.New("the soft reason of the failure")
+
+func (failingBackend) Regions() ([]MemoryRegion, error, []error) {
+	return nil, errors.New("no regions"), []error{errFailingSoft}
+}
+
+func (failingBackend) ReadAt(address uintptr, buf []byte) (error, []error) {
+	return errors.New("no memory"), nil
+}
+
+func (failingBackend) Info() BackendInfo {
+	return BackendInfo{Kind: "failing"}
+}
+
+// sloppyBackend is a failingBackend whose extensions return results along with their harderrors, which the functions
+// calling them must drop.
+type sloppyBackend struct{ failingBackend }
+
+func (sloppyBackend) TargetArch() (process.TargetArch, error, []error) {
+	return process.TargetOf(process.ArchX86_64), errors.New("no arch"), []error{errFailingSoft}
+}
+
+func (sloppyBackend) ReadBatch(reqs []ReadRequest) ([]ReadResult, error, []error) {
+	return make([]ReadResult, len(reqs)), errors.New("no batch"), []error{errFailingSoft}
+}
+
+func containsError(errs []error, target error) bool {
+	for _, err := range errs {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// The functions returning (result, harderror, softerrors) must return a zero result along with a harderror, and keep
+// the softerrors.
+func TestErrorContract(t *testing.T) {
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	p := process.GetProcess(cmd.Process.Pid)
+
+	if region, err, _ := NextMemoryRegion(p, 0); err == nil || region != NoRegionAvailable {
+		t.Errorf("NextMemoryRegion of an exited process returned %v, %v", region, err)
+	}
+	if region, err, _ := NextMemoryRegionAccess(p, 0, Readable); err == nil || region != NoRegionAvailable {
+		t.Errorf("NextMemoryRegionAccess of an exited process returned %v, %v", region, err)
+	}
+	if region, err, _ := NextReadableMemoryRegion(p, 0); err == nil || region != NoRegionAvailable {
+		t.Errorf("NextReadableMemoryRegion of an exited process returned %v, %v", region, err)
+	}
+	if regions, err, _ := MemoryRegions(p); err == nil || regions != nil {
+		t.Errorf("MemoryRegions of an exited process returned %v, %v", regions, err)
+	}
+	if generation, err, _ := RegionsGeneration(p); err == nil || generation != 0 {
+		t.Errorf("RegionsGeneration of an exited process returned %v, %v", generation, err)
+	}
+	if err, _ := CopyMemory(p, 0x1000, make([]byte, 1)); err == nil {
+		t.Error("CopyMemory of an exited process succeeded")
+	}
+	if err, _ := WalkMemory(p, 0, 4096, func(uintptr, []byte) bool { return true }); err == nil {
+		t.Error("WalkMemory of an exited process succeeded")
+	}
+
+	walkFn := func(uintptr, []byte) bool {
+		t.Error("A failing backend was walked")
+		return false
+	}
+	if err, softerrors := WalkBackend(failingBackend{}, 0, 4096, walkFn); err == nil ||
+		!containsError(softerrors, errFailingSoft) {
+		t.Errorf("WalkBackend of a failing backend returned %v, %v", err, softerrors)
+	}
+	if err, softerrors := SlidingWalkBackend(failingBackend{}, 0, 4096, walkFn); err == nil ||
+		!containsError(softerrors, errFailingSoft) {
+		t.Errorf("SlidingWalkBackend of a failing backend returned %v, %v", err, softerrors)
+	}
+	if arch, err, softerrors := BackendArch(sloppyBackend{}); err == nil || arch != (process.TargetArch{}) ||
+		!containsError(softerrors, errFailingSoft) {
+		t.Errorf("BackendArch of a failing backend returned %v, %v, %v", arch, err, softerrors)
+	}
+	results, err, softerrors := ReadBackendBatch(sloppyBackend{}, []ReadRequest{{Address: 0x1000, Size: 1}})
+	if err == nil || results != nil || !containsError(softerrors, errFailingSoft) {
+		t.Errorf("ReadBackendBatch of a failing backend returned %v, %v, %v", results, err, softerrors)
+	}
+}
+
+func TestWalkMemoryDetectsExec(t *testing.T) {
+	second, err := test.CopyTestCase(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd, err := test.LaunchTestCaseAndWaitForInitialization("--exec", second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { cmd.Process.Kill(); cmd.Wait() }()
+
+	p, err, softerrors := process.OpenFromPid(cmd.Process.Pid)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	// The test case execs the copy in the middle of the walk.
+	execed := false
+	err, softerrors = WalkMemory(p, 0, 4096, func(address uintptr, buf []byte) bool {
+		if !execed {
+			execed = true
+			execAndWait(t, p, cmd)
+		}
+		return true
+	})
+
+	var execErr *process.ExecError
+	if !errors.As(err, &execErr) || !errors.Is(err, process.ErrProcessExeced) {
+		t.Fatalf("Expected an ExecError, got %v, softerrors %v", err, softerrors)
+	}
+	expected := process.ExecError{Pid: p.Pid(), OldExecutable: test.GetTestCasePath(), NewExecutable: second}
+	if *execErr != expected {
+		t.Errorf("Expected %+v, got %+v", expected, *execErr)
+	}
+}
+
+// execAndWait makes the test case exec the program given with --exec, and waits until it's running.
+func execAndWait(t *testing.T, p process.Process, cmd *exec.Cmd) {
+	before, err := process.CurrentImage(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Process.Signal(syscall.SIGUSR2); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		if image, err := process.CurrentImage(p); err == nil && !image.Same(before) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("The test case didn't exec")
+}
+
+func TestRegisterRegionProvider(t *testing.T) {
+	cmd, err := test.LaunchTestCaseAndWaitForInitialization()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	p, err, softerrors := process.OpenFromPid(cmd.Process.Pid)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	all, err, softerrors := MemoryRegions(p)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var heap []MemoryRegion
+	for _, region := range all {
+		if region.Kind == "[heap]" {
+			heap = append(heap, region)
+		}
+	}
+	if len(heap) == 0 {
+		t.Fatal("The test case has no heap")
+	}
+
+	// The provider only shows the heap.
+	provided := RegisterRegionProvider(p, func(process.Process) ([]MemoryRegion, error, []error) {
+		return heap, nil, nil
+	})
+	if regions, err, _ := MemoryRegions(provided); err != nil || !reflect.DeepEqual(regions, heap) {
+		t.Errorf("Expected the regions of the provider %v, got %v, %v", heap, regions, err)
+	}
+	if region, err, _ := NextMemoryRegion(provided, 0); err != nil || region != heap[0] {
+		t.Errorf("Expected the first region of the provider %v, got %v, %v", heap[0], region, err)
+	}
+	inHeap := func(address uintptr, size int) bool {
+		for _, region := range heap {
+			if address >= region.Address && address+uintptr(size) <= region.Address+uintptr(region.Size) {
+				return true
+			}
+		}
+		return false
+	}
+	for name, walk := range map[string]func(process.Process, uintptr, uint, WalkFunc) (error, []error){
+		"WalkMemory":        WalkMemory,
+		"SlidingWalkMemory": SlidingWalkMemory,
+	} {
+		read := 0
+		err, softerrors := walk(provided, 0, 4096, func(address uintptr, buf []byte) bool {
+			if !inHeap(address, len(buf)) {
+				t.Errorf("%s read %d bytes at %x, outside the provided regions", name, len(buf), address)
+				return false
+			}
+			read += len(buf)
+			return true
+		})
+		test.PrintSoftErrors(softerrors)
+		if err != nil || read == 0 {
+			t.Errorf("%s of the provided regions read %d bytes, %v", name, read, err)
+		}
+	}
+
+	// The original process still uses the platform's regions.
+	if regions, err, _ := MemoryRegions(p); err != nil || len(regions) != len(all) {
+		t.Errorf("The provider changed the regions of the original process: %v, %v", regions, err)
+	}
+}
+
+func TestRegionProviderFallback(t *testing.T) {
+	cmd, err := test.LaunchTestCaseAndWaitForInitialization()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	p, err, softerrors := process.OpenFromPid(cmd.Process.Pid)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	errProvider := errors.New("the provider is unavailable")
+	for name, provider := range map[string]RegionProvider{
+		"failing": func(process.Process) ([]MemoryRegion, error, []error) {
+			return nil, errProvider, nil
+		},
+		"overlapping": func(process.Process) ([]MemoryRegion, error, []error) {
+			return []MemoryRegion{{Address: 0x1000, Size: 0x2000, Access: Readable},
+				{Address: 0x2000, Size: 0x1000, Access: Readable}}, nil, nil
+		},
+		"unsorted": func(process.Process) ([]MemoryRegion, error, []error) {
+			return []MemoryRegion{{Address: 0x3000, Size: 0x1000, Access: Readable},
+				{Address: 0x1000, Size: 0x1000, Access: Readable}}, nil, nil
+		},
+	} {
+		regions, err, softerrors := MemoryRegions(RegisterRegionProvider(p, provider))
+		if err != nil || len(regions) == 0 || regions[0].Address == 0x1000 {
+			t.Errorf("The %s provider didn't fall back to the platform's regions: %v, %v", name, regions, err)
+		}
+		if len(softerrors) == 0 {
+			t.Errorf("The failure of the %s provider wasn't reported", name)
+		} else {
+			t.Logf("%s: %v", name, softerrors[len(softerrors)-1])
+		}
+	}
+}
+
+// mixedRequests returns requests that can be read, that can't, that are partially readable and that are empty, more
+// than fit in a single process_vm_readv call.
+func mixedRequests(t testing.TB, p process.Process) []ReadRequest {
+	regions, err, _ := MemoryRegions(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runs := readableRuns(regions)
+	if len(runs) < 2 {
+		t.Fatal("The test case has too few readable regions")
+	}
+
+	var reqs []ReadRequest
+	for i := 0; len(reqs) < 3*iovMax; i++ {
+		run := runs[i%len(runs)]
+		end := run.Address + uintptr(run.Size)
+		reqs = append(reqs,
+			ReadRequest{Address: run.Address + uintptr(i%64), Size: 16},
+			ReadRequest{Address: 8, Size: 8},
+			ReadRequest{Address: run.Address, Size: 0},
+			ReadRequest{Address: end - 4, Size: 8},
+			ReadRequest{Address: end - 8, Size: 8})
+	}
+	return reqs
+}
+
+func TestReadBatch(t *testing.T) {
+	cmd, err := test.LaunchTestCaseAndWaitForInitialization()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	p, err, softerrors := process.OpenFromPid(cmd.Process.Pid)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	reqs := mixedRequests(t, p)
+	results, err, softerrors := ReadBatch(p, reqs)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != len(reqs) {
+		t.Fatalf("Expected %d results, got %d", len(reqs), len(results))
+	}
+
+	failed := 0
+	for i, req := range reqs {
+		buf := make([]byte, req.Size)
+		err, _ := CopyMemory(p, req.Address, buf)
+		if (err == nil) != (results[i].Err == nil) {
+			t.Fatalf("Request %d %+v: CopyMemory returned %v and ReadBatch %v", i, req, err, results[i].Err)
+		}
+		if err != nil {
+			failed++
+		} else if !bytes.Equal(buf, results[i].Data) {
+			t.Fatalf("Request %d %+v: CopyMemory read %x and ReadBatch %x", i, req, buf, results[i].Data)
+		}
+	}
+	if failed == 0 || failed == len(reqs) {
+		t.Errorf("Expected a mix of readable and unreadable requests, %d of %d failed", failed, len(reqs))
+	}
+
+	// Without process_vm_readv every request is read by itself, with the same results.
+	defer func(sys uintptr) { sysProcessVMReadv = sys }(sysProcessVMReadv)
+	sysProcessVMReadv = 0
+	each, err, _ := ReadBatch(p, reqs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range reqs {
+		if (each[i].Err == nil) != (results[i].Err == nil) || !bytes.Equal(each[i].Data, results[i].Data) {
+			t.Fatalf("Request %d %+v: read %x, %v one by one and %x, %v in a batch", i, reqs[i], each[i].Data,
+				each[i].Err, results[i].Data, results[i].Err)
+		}
+	}
+}
+
+func benchmarkReadBatch(b *testing.B, batched bool) {
+	cmd, err := test.LaunchTestCaseAndWaitForInitialization()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	p, err, _ := process.OpenFromPid(cmd.Process.Pid)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer p.Close()
+	if !batched {
+		defer func(sys uintptr) { sysProcessVMReadv = sys }(sysProcessVMReadv)
+		sysProcessVMReadv = 0
+	}
+
+	// Only the readable requests, a failure costs a system call in both cases.
+	var reqs []ReadRequest
+	for _, req := range mixedRequests(b, p) {
+		if err, _ := CopyMemory(p, req.Address, make([]byte, req.Size)); err == nil {
+			reqs = append(reqs, req)
+		}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err, _ := ReadBatch(p, reqs); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReadBatch(b *testing.B) {
+	benchmarkReadBatch(b, true)
+}
+
+func BenchmarkReadOneByOne(b *testing.B) {
+	benchmarkReadBatch(b, false)
+}
+
+// selfPreopened opens the current process with its resources preopened, which ReadInto needs to not allocate.
+func selfPreopened(tb testing.TB) process.Process {
+	p, err, softerrors := process.OpenFromPidWithOptions(os.Getpid(), process.OpenOptions{PreopenResources: true})
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return p
+}
+
+func TestReadInto(t *testing.T) {
+	value := new(uint64)
+	*value = 0x0123456789abcdef
+	address := uintptr(unsafe.Pointer(value))
+	p := selfPreopened(t)
+	defer p.Close()
+
+	buf := make([]byte, 8)
+	if n, err := ReadInto(p, address, buf); err != nil || n != len(buf) ||
+		!bytes.Equal(buf, (*[8]byte)(unsafe.Pointer(value))[:]) {
+		t.Errorf("ReadInto returned %d, %v, %x", n, err, buf)
+	}
+	if v, err := ReadUint64(p, address); err != nil || v != *value {
+		t.Errorf("ReadUint64 returned %x, %v", v, err)
+	}
+	if v, err := ReadUint32(p, address); err != nil || v != *(*uint32)(unsafe.Pointer(value)) {
+		t.Errorf("ReadUint32 returned %x, %v", v, err)
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		ReadInto(p, address, buf)
+		ReadUint64(p, address)
+		ReadUint32(p, address)
+	})
+	if allocs != 0 {
+		t.Errorf("Reading allocated %v times", allocs)
+	}
+
+	if n, err := ReadInto(p, 0, buf); err == nil || n != 0 {
+		t.Errorf("ReadInto of an unmapped address returned %d, %v", n, err)
+	}
+
+	// Without the preopened file it still works, allocating.
+	self := process.GetProcess(os.Getpid())
+	if v, err := ReadUint64(self, address); err != nil || v != *value {
+		t.Errorf("ReadUint64 without preopened resources returned %x, %v", v, err)
+	}
+}
+
+func BenchmarkReadInto(b *testing.B) {
+	value := new(uint64)
+	p := selfPreopened(b)
+	defer p.Close()
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := ReadUint64(p, uintptr(unsafe.Pointer(value))); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCopyMemorySmall(b *testing.B) {
+	value := new(uint64)
+	p := selfPreopened(b)
+	defer p.Close()
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err, _ := CopyMemory(p, uintptr(unsafe.Pointer(value)), make([]byte, 8)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestCapabilitiesMatchBehavior(t *testing.T) {
+	cmd, err := test.LaunchTestCaseAndWaitForInitialization()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	pids := []int{cmd.Process.Pid}
+	if os.Geteuid() != 0 {
+		// init can't be read without privileges.
+		pids = append(pids, 1)
+	}
+	for _, pid := range pids {
+		p, err, softerrors := process.OpenFromPid(pid)
+		test.PrintSoftErrors(softerrors)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer p.Close()
+
+		regions, err, _ := MemoryRegions(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		runs := readableRuns(regions)
+		req := ReadRequest{Address: runs[0].Address, Size: 8}
+
+		matrix := common.ProcessCapabilities(pid)
+		memoryRead, _ := common.FindCapability(matrix, CapabilityMemoryRead)
+		err, _ = CopyMemory(p, req.Address, make([]byte, req.Size))
+		if (memoryRead.Availability == common.Available) != (err == nil) {
+			t.Errorf("Process %d: %v, but CopyMemory returned %v", pid, memoryRead, err)
+		}
+
+		batchRead, _ := common.FindCapability(matrix, CapabilityBatchRead)
+		results, err, _ := ReadBatch(p, []ReadRequest{req})
+		if err == nil {
+			err = results[0].Err
+		}
+		if batchRead.Availability == common.Available && err != nil {
+			t.Errorf("Process %d: %v, but ReadBatch returned %v", pid, batchRead, err)
+		}
+	}
+}
+
+// findMarker returns the address of the second marker of the test case, the one changed by SIGUSR1.
+func findMarker(t *testing.T, p process.Process) uintptr {
+	regions, err, _ := MemoryRegions(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found []uintptr
+	for _, run := range readableRuns(regions) {
+		buf := make([]byte, run.Size)
+		if err, _ := CopyMemory(p, run.Address, buf); err != nil {
+			continue
+		}
+		for offset := 0; ; {
+			i := bytes.Index(buf[offset:], []byte("MASCHEMK"))
+			if i == -1 {
+				break
+			}
+			found = append(found, run.Address+uintptr(offset+i))
+			offset += i + 1
+		}
+	}
+	if len(found) != 4 {
+		t.Fatalf("Expected the 4 markers of the test case, found %d", len(found))
+	}
+	return found[1]
+}
+
+func TestWindowedReader(t *testing.T) {
+	cmd, err := test.LaunchTestCaseAndWaitForInitialization()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	p, err, softerrors := process.OpenFromPid(cmd.Process.Pid)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	marker := findMarker(t, p)
+
+	r := NewWindowedReader(p, WindowOptions{Prefetch: 1})
+	defer r.Close()
+	read := func(address uintptr) string {
+		data, err, softerrors := r.ReadWindow(address, 8)
+		test.PrintSoftErrors(softerrors)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	if magic := read(marker); magic != "MASCHEMK" {
+		t.Fatalf("Read %q instead of the magic", magic)
+	}
+	read(marker + 1)
+	if stats := r.Stats(); stats.Misses != 1 || stats.Hits != 1 {
+		t.Errorf("Expected a miss and then a hit, got %+v", stats)
+	}
+
+	// The pages next to the marker are prefetched, unless they can't be read.
+	pageSize := uintptr(os.Getpagesize())
+	next := marker&^(pageSize-1) + pageSize
+	if err, _ := CopyMemory(p, next, make([]byte, 8)); err == nil {
+		deadline := time.Now().Add(5 * time.Second)
+		for r.Stats().Prefetched == 0 && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		before := r.Stats()
+		read(next)
+		if after := r.Stats(); before.Prefetched == 0 || after.Hits != before.Hits+1 {
+			t.Errorf("The next page wasn't prefetched: %+v before reading it, %+v after", before, after)
+		}
+	}
+
+	// The cached page doesn't see the change until it's invalidated.
+	if err := cmd.Process.Signal(syscall.SIGUSR1); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		buf := make([]byte, 8)
+		if err, _ := CopyMemory(p, marker, buf); err != nil {
+			t.Fatal(err)
+		}
+		if string(buf) == "XASCHEMK" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("The test case didn't change the marker")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if magic := read(marker); magic != "MASCHEMK" {
+		t.Errorf("Read %q from the cache instead of the old magic", magic)
+	}
+	r.Invalidate(marker, 8)
+	if magic := read(marker); magic != "XASCHEMK" {
+		t.Errorf("Read %q after invalidating the marker instead of the new magic", magic)
+	}
+
+	// Expired pages are read again.
+	expiring := NewWindowedReader(p, WindowOptions{MaxAge: time.Millisecond})
+	defer expiring.Close()
+	expiring.ReadWindow(marker, 8)
+	time.Sleep(5 * time.Millisecond)
+	expiring.ReadWindow(marker, 8)
+	if stats := expiring.Stats(); stats.Misses != 2 || stats.Hits != 0 {
+		t.Errorf("Expected the expired page to be read again, got %+v", stats)
+	}
+}
+
+// A suspended process is walked without changing, and runs again once resumed.
+func TestWalkSuspended(t *testing.T) {
+	cmd, err := test.LaunchTestCaseAndWaitForInitialization("--threads", "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	p, err, softerrors := process.OpenFromPid(cmd.Process.Pid)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	marker := findMarker(t, p)
+
+	defer p.Resume()
+	err, softerrors = p.Suspend()
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The handler that changes the marker doesn't run until the process is resumed.
+	if err := cmd.Process.Signal(syscall.SIGUSR1); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	found := false
+	err, softerrors = SlidingWalkMemory(p, 0, 4096, func(address uintptr, buf []byte) bool {
+		if address <= marker && marker+8 <= address+uintptr(len(buf)) {
+			found = bytes.HasPrefix(buf[marker-address:], []byte("MASCHEMK"))
+			return false
+		}
+		return true
+	})
+	test.PrintSoftErrors(softerrors)
+	if err != nil || !found {
+		t.Errorf("The marker of the suspended process changed, or wasn't walked (%v)", err)
+	}
+
+	if err, _ := p.Resume(); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 8)
+	for deadline := time.Now().Add(5 * time.Second); string(buf) != "XASCHEMK"; time.Sleep(10 * time.Millisecond) {
+		if err, _ := CopyMemory(p, marker, buf); err != nil {
+			t.Fatal(err)
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("The test case didn't run once resumed")
+		}
+	}
+}
+
+func TestRegionHistory(t *testing.T) {
+	cmd, err := test.LaunchTestCaseAndWaitForInitialization("--grow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	proc, err, softerrors := process.OpenFromPid(cmd.Process.Pid)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proc.Close()
+
+	h, err, softerrors := RecordRegionHistory(proc, HistoryOptions{Interval: 10 * time.Millisecond})
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Stop()
+	before := h.Snapshots()[0]
+
+	time.Sleep(50 * time.Millisecond)
+	mapped := time.Now()
+	if err := cmd.Process.Signal(syscall.SIGUSR2); err != nil {
+		t.Fatal(err)
+	}
+
+	// The new region is the one with the size mapped by the test case.
+	var grown MemoryRegion
+	for deadline := time.Now().Add(5 * time.Second); grown.Size == 0 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+		snapshots := h.Snapshots()
+		for _, region := range snapshots[len(snapshots)-1].Regions {
+			if _, ok := regionAt(before.Regions, region.Address); !ok && region.Size == 5*uint(os.Getpagesize()) {
+				grown = region
+			}
+		}
+	}
+	seen := time.Now()
+	h.Stop()
+	if grown.Size == 0 {
+		t.Fatal("The new region wasn't recorded")
+	}
+
+	appearance, ok := h.FirstSeen(grown.Address)
+	if !ok || appearance.Region != grown {
+		t.Fatalf("Expected the appearance of %v, got %+v", grown, appearance)
+	}
+	if appearance.LastAbsent.IsZero() || appearance.LastAbsent.After(appearance.FirstSeen) ||
+		appearance.FirstSeen.Before(mapped) || appearance.FirstSeen.After(seen) {
+
+		t.Errorf("Expected the region to appear between %v and %v, got %+v", mapped, seen, appearance)
+	}
+	if snapshot, ok := h.At(appearance.LastAbsent); !ok || !snapshot.Time.Equal(appearance.LastAbsent) {
+		t.Errorf("Expected the snapshot at %v, got %v", appearance.LastAbsent, snapshot.Time)
+	} else if _, found := regionAt(snapshot.Regions, grown.Address); found {
+		t.Errorf("The region is in the snapshot before it appeared")
+	}
+	if snapshot, ok := h.At(appearance.FirstSeen.Add(time.Microsecond)); !ok || snapshot.Generation == before.Generation {
+		t.Errorf("Expected a different layout after the region appeared, got %+v", snapshot)
+	}
+	if _, ok := h.At(before.Time.Add(-time.Second)); ok {
+		t.Error("Got a snapshot from before the history started")
+	}
+}
+
+const preopenHelperEnv = "MASCHE_PREOPEN_PID"
+
+// TestPreopenHelper is not a real test: it's run by TestPreopenResources in a subprocess, which opens the given pid
+// with PreopenResources, drops its privileges and reads the memory of the process.
+func TestPreopenHelper(t *testing.T) {
+	pidStr := os.Getenv(preopenHelperEnv)
+	if pidStr == "" {
+		t.Skip("only run as a helper of TestPreopenResources")
+	}
+	pid, err := strconv.Atoi(pidStr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opened, err, softerrors := process.OpenFromPidWithOptions(pid, process.OpenOptions{PreopenResources: true})
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer opened.Close()
+	// The preopened files are found through wrappers.
+	p, err, _ := process.NewCachedProcess(opened, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := syscall.Setgroups(nil); err != nil {
+		t.Skipf("Unable to drop privileges: %v", err)
+	}
+	if err := syscall.Setgid(65534); err != nil {
+		t.Skipf("Unable to drop privileges: %v", err)
+	}
+	if err := syscall.Setuid(65534); err != nil {
+		t.Skipf("Unable to drop privileges: %v", err)
+	}
+
+	findMarker(t, p)
+	if level, err, _ := p.AccessLevel(); err != nil || level != process.FullAccess {
+		t.Errorf("Expected full access through the preopened files, got %v (%v)", level, err)
+	}
+
+	// Opening the process anew needs the privileges that were dropped.
+	if _, err, _ := process.OpenFromPid(pid); err == nil {
+		t.Error("Opened the process without privileges")
+	}
+	if _, err, _ := opened.Name(); !errors.Is(err, process.ErrPrivilegesDropped) {
+		t.Errorf("Expected ErrPrivilegesDropped reading the name, got %v", err)
+	}
+	if _, err, _ := opened.Environ(); !errors.Is(err, process.ErrPrivilegesDropped) {
+		t.Errorf("Expected ErrPrivilegesDropped reading the environment, got %v", err)
+	}
+}
+
+func TestPreopenResources(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Privileges can only be dropped by root")
+	}
+	cmd, err := test.LaunchTestCaseAndWaitForInitialization()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	helper := exec.Command(os.Args[0], "-test.run=^TestPreopenHelper$", "-test.v")
+	helper.Env = append(os.Environ(), fmt.Sprintf("%s=%d", preopenHelperEnv, cmd.Process.Pid))
+	out, err := helper.CombinedOutput()
+	if strings.Contains(string(out), "--- SKIP") {
+		t.Skipf("The helper couldn't drop its privileges:\n%s", out)
+	}
+	if err != nil || !strings.Contains(string(out), "--- PASS: TestPreopenHelper") {
+		t.Errorf("The helper failed (%v):\n%s", err, out)
+	}
+}
+
+// mapAtTop maps size bytes ending at the top of the user address space, with 5 or 4-level page tables, without
+// replacing any mapping. It returns 0 if there was no room.
+func mapAtTop(size uintptr) uintptr {
+	const mapFixedNoreplace = 0x100000
+	for _, top := range []uint64{0x00fffffffffff000, 0x7ffffffff000} {
+		if uint64(^uintptr(0)) < top {
+			continue
+		}
+		want := uintptr(top) - size
+		address, _, errno := syscall.Syscall6(syscall.SYS_MMAP, want, size, syscall.PROT_READ|syscall.PROT_WRITE,
+			syscall.MAP_PRIVATE|syscall.MAP_ANONYMOUS|mapFixedNoreplace, ^uintptr(0), 0)
+		if errno != 0 {
+			continue
+		}
+		// Kernels before 4.17 take the address as a hint.
+		if address != want {
+			syscall.Syscall(syscall.SYS_MUNMAP, address, size, 0)
+			continue
+		}
+		return address
+	}
+	return 0
+}
+
+// The last bytes of the user address space are read and walked like any others.
+func TestReadTopOfAddressSpace(t *testing.T) {
+	const size = 0x2000
+	address := mapAtTop(size)
+	if address == 0 {
+		t.Skip("Unable to map memory at the top of the address space")
+	}
+	defer syscall.Syscall(syscall.SYS_MUNMAP, address, size, 0)
+	// The marker is written through the memory file, as a pointer can't be made from a uintptr.
+	marker := []byte("the end of the address space")
+	mem, err := os.OpenFile("/proc/self/mem", os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = mem.WriteAt(marker, int64(address+size-uintptr(len(marker))))
+	mem.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err, softerrors := process.OpenFromPid(os.Getpid())
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	region, err, softerrors := NextMemoryRegion(p, address)
+	test.PrintSoftErrors(softerrors)
+	if err != nil || region.Address != address || region.Address+uintptr(region.Size) != address+size {
+		t.Fatalf("Unexpected region %v at %x (%v)", region, address, err)
+	}
+
+	buf := make([]byte, len(marker))
+	err, softerrors = CopyMemory(p, address+size-uintptr(len(buf)), buf)
+	test.PrintSoftErrors(softerrors)
+	if err != nil || !bytes.Equal(buf, marker) {
+		t.Errorf("Read %q at the top of the address space (%v)", buf, err)
+	}
+	if err, _ := CopyMemory(p, address+size-uintptr(len(buf))+1, buf); err == nil {
+		t.Error("Reading past the top of the address space should fail")
+	}
+
+	var walked []byte
+	err, softerrors = WalkMemory(p, address, 0x1000, func(address uintptr, buf []byte) bool {
+		walked = append(walked, buf...)
+		return true
+	})
+	test.PrintSoftErrors(softerrors)
+	if err != nil || len(walked) < size || !bytes.HasSuffix(walked[:size], marker) {
+		t.Errorf("Walked %d bytes from the top mapping (%v)", len(walked), err)
+	}
+}
+
+func TestWriteTransaction(t *testing.T) {
+	shared := filepath.Join(t.TempDir(), "shared")
+	if err := ioutil.WriteFile(shared, []byte("shared read only page"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cmd, err := test.LaunchTestCaseAndWaitForInitialization("--map-shared", shared)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+	p, err, softerrors := process.OpenFromPid(cmd.Process.Pid)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	marker := findMarker(t, p)
+	payload := marker + 12
+	patch := []WriteRequest{
+		{Address: marker, Data: []byte("PATCHED!"), Expected: []byte("MASCHEMK")},
+		{Address: payload, Data: []byte("patched!"), Expected: []byte("payload!")},
+	}
+	read := func(address uintptr) string {
+		buf := make([]byte, 8)
+		if err, _ := CopyMemory(p, address, buf); err != nil {
+			t.Fatal(err)
+		}
+		return string(buf)
+	}
+
+	if _, err, _ := WriteTransaction(p, patch, WriteTransactionOptions{}); !errors.Is(err,
+		process.ErrMutationNotAllowed) {
+
+		t.Fatalf("A process that wasn't allowed was written (%v)", err)
+	}
+	token := process.MutationToken{Pid: p.Pid(), Executable: test.GetTestCasePath()}
+	if err := process.AllowMutation(p, token); err != nil {
+		t.Fatal(err)
+	}
+	defer process.RevokeMutation(p)
+
+	// The expected bytes of the second write don't match, so not even the first is made.
+	mismatch := append([]WriteRequest(nil), patch...)
+	mismatch[1].Expected = []byte("PAYLOAD!")
+	outcomes, err, softerrors := WriteTransaction(p, mismatch, WriteTransactionOptions{Suspend: true})
+	test.PrintSoftErrors(softerrors)
+	if !errors.Is(err, ErrExpectedMismatch) || outcomes[0].State != WriteNotApplied ||
+		outcomes[1].State != WriteFailed || read(marker) != "MASCHEMK" {
+
+		t.Errorf("Unexpected outcomes %+v of a mismatch (%v)", outcomes, err)
+	}
+
+	// The shared mapping can't be written, so the first write is rolled back.
+	var sharedAddress uintptr
+	regions, err, _ := MemoryRegions(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, region := range regions {
+		if region.Kind == shared {
+			sharedAddress = region.Address
+		}
+	}
+	failing := []WriteRequest{patch[0], {Address: sharedAddress, Data: []byte("SHARED")}}
+	if _, err, _ := WriteTransaction(p, failing, WriteTransactionOptions{}); !errors.Is(err, ErrTransactionFailed) {
+		t.Errorf("Writing to a read only region without ForceProtect should fail validation (%v)", err)
+	}
+	failing[1].ForceProtect = true
+	outcomes, err, softerrors = WriteTransaction(p, failing, WriteTransactionOptions{Suspend: true})
+	test.PrintSoftErrors(softerrors)
+	if !errors.Is(err, ErrTransactionFailed) || outcomes[0].State != WriteRolledBack ||
+		string(outcomes[0].Original) != "MASCHEMK" || outcomes[1].State != WriteFailed ||
+		read(marker) != "MASCHEMK" || read(sharedAddress) != "shared r" {
+
+		t.Errorf("Unexpected outcomes %+v of a failed transaction (%v)", outcomes, err)
+	}
+
+	outcomes, err, softerrors = WriteTransaction(p, patch, WriteTransactionOptions{Suspend: true})
+	test.PrintSoftErrors(softerrors)
+	if err != nil || outcomes[0].State != WriteApplied || outcomes[1].State != WriteApplied ||
+		read(marker) != "PATCHED!" || read(payload) != "patched!" {
+
+		t.Errorf("Unexpected outcomes %+v of a transaction (%v)", outcomes, err)
+	}
+	if stat, err := common.ReadStatFile(uint(p.Pid())); err != nil || stat.State == "T" {
+		t.Errorf("The process is still suspended after the transaction (%v)", err)
+	}
+}
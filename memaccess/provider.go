@@ -0,0 +1,91 @@
+package memaccess
+
+import (
+	"fmt"
+
+	"github.com/polyverse/masche/process"
+)
+
+// RegionProvider enumerates the memory regions of a process from a source other than the platform's, like a kernel
+// module or a hypervisor introspection API.
+//
+// The regions it returns must be sorted by address, must not overlap, must not be empty, and their addresses must be
+// absolute addresses in the address space of the process. The memory is still read with CopyMemory.
+type RegionProvider func(p process.Process) (regions []MemoryRegion, harderror error, softerrors []error)
+
+// RegisterRegionProvider returns p with its memory regions enumerated by provider: MemoryRegions, NextMemoryRegion,
+// the walks and the searches on the returned process use them instead of the platform's. Only the returned process
+// is affected, p itself and every other process still use the platform's regions.
+//
+// If provider returns a harderror, or regions that break its contract, the platform's regions are used instead and
+// the reason is added to the softerrors.
+func RegisterRegionProvider(p process.Process, provider RegionProvider) process.Process {
+	return &providedProcess{Process: p, provider: provider}
+}
+
+// providedProcess is a process whose memory regions come from a RegionProvider.
+type providedProcess struct {
+	process.Process
+	provider RegionProvider
+}
+
+func (p *providedProcess) Unwrap() process.Process {
+	return p.Process
+}
+
+func (p *providedProcess) regions() (regions []MemoryRegion, harderror error, softerrors []error) {
+	regions, harderror, softerrors = p.provider(p.Process)
+	if harderror == nil {
+		harderror = validateRegions(regions)
+	}
+	if harderror == nil {
+		return regions, nil, softerrors
+	}
+
+	softerrors = append(softerrors, fmt.Errorf("Region provider of process %d failed, using the platform's regions (%v)",
+		p.Pid(), harderror))
+	regions, harderror, serrs := memoryRegions(p.Process)
+	return regions, harderror, append(softerrors, serrs...)
+}
+
+// validateRegions checks that regions are sorted by address, don't overlap and aren't empty, as RegionProvider
+// requires.
+func validateRegions(regions []MemoryRegion) error {
+	for i, region := range regions {
+		if region.Size == 0 {
+			return fmt.Errorf("Empty region %v", region)
+		}
+		if region.Address+uintptr(region.Size) < region.Address {
+			return fmt.Errorf("Region %v wraps around the address space", region)
+		}
+		if i == 0 {
+			continue
+		}
+		previous := regions[i-1]
+		if region.Address < previous.Address {
+			return fmt.Errorf("Regions %v and %v are not sorted by address", previous, region)
+		}
+		if region.Address < previous.Address+uintptr(previous.Size) {
+			return fmt.Errorf("Regions %v and %v overlap", previous, region)
+		}
+	}
+	return nil
+}
+
+// allRegions returns the regions of p, from its RegionProvider if it has one.
+func allRegions(p process.Process) (regions []MemoryRegion, harderror error, softerrors []error) {
+	if provided, ok := p.(*providedProcess); ok {
+		return provided.regions()
+	}
+	return memoryRegions(p)
+}
+
+// regionAfter returns the first of the sorted regions that ends after address.
+func regionAfter(regions []MemoryRegion, address uintptr) MemoryRegion {
+	for _, region := range regions {
+		if region.Address+uintptr(region.Size) > address {
+			return region
+		}
+	}
+	return NoRegionAvailable
+}
@@ -0,0 +1,29 @@
+package memaccess
+
+import (
+	"unsafe"
+
+	"github.com/polyverse/masche/process"
+)
+
+// ReadInto reads len(buf) bytes of the memory of p starting at address, and returns how many were read, which are
+// less than len(buf) only with an error. It's meant for polling loops that read a small value thousands of times per
+// second, like watching a variable: on Linux it doesn't allocate when it succeeds if p was opened with
+// process.OpenOptions.PreopenResources, as it reads the preopened memory file. Otherwise it works as CopyMemory.
+func ReadInto(p process.Process, address uintptr, buf []byte) (n int, err error) {
+	return readInto(p, address, buf)
+}
+
+// ReadUint32 reads a uint32 in the byte order of the current machine, which is the one of p, with ReadInto. Like it,
+// it doesn't allocate on Linux if p was opened with PreopenResources.
+func ReadUint32(p process.Process, address uintptr) (value uint32, err error) {
+	_, err = readInto(p, address, (*[4]byte)(unsafe.Pointer(&value))[:])
+	return value, err
+}
+
+// ReadUint64 reads a uint64 in the byte order of the current machine, which is the one of p, with ReadInto. Like it,
+// it doesn't allocate on Linux if p was opened with PreopenResources.
+func ReadUint64(p process.Process, address uintptr) (value uint64, err error) {
+	_, err = readInto(p, address, (*[8]byte)(unsafe.Pointer(&value))[:])
+	return value, err
+}
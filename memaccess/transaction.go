@@ -0,0 +1,212 @@
+package memaccess
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/polyverse/masche/process"
+)
+
+// WriteRequest is one of the writes of a WriteTransaction.
+type WriteRequest struct {
+	Address uintptr
+	Data    []byte
+	// Expected, if not nil, are the bytes that must be at Address for the transaction to be applied, as in a
+	// compare-and-swap. It must be as long as Data.
+	Expected []byte
+	// ForceProtect allows writing to memory that isn't writable, like code. Otherwise the whole write must be in
+	// writable regions.
+	ForceProtect bool
+}
+
+// WriteTransactionOptions are the options of WriteTransaction.
+type WriteTransactionOptions struct {
+	// Suspend stops the process while the transaction is validated and applied, so it can't change the memory after
+	// it's compared with the expected bytes, nor run code that is half patched. Without it, the Expected bytes are
+	// still compared right before writing, but the process keeps running meanwhile.
+	Suspend bool
+}
+
+// WriteState is what happened to a WriteRequest in a transaction.
+type WriteState string
+
+const (
+	// WriteNotApplied is the state of the writes not made, because the transaction failed before them.
+	WriteNotApplied WriteState = "not-applied"
+	// WriteApplied is the state of the writes of a transaction that succeeded.
+	WriteApplied WriteState = "applied"
+	// WriteFailed is the state of the write that made the transaction fail.
+	WriteFailed WriteState = "failed"
+	// WriteRolledBack is the state of the writes made before the transaction failed, whose original bytes were
+	// restored. The write that failed is also rolled back if it was partly made, and keeps its Error.
+	WriteRolledBack WriteState = "rolled-back"
+	// WriteRollbackFailed is the state of the writes made before the transaction failed whose original bytes couldn't
+	// be restored. The memory of the process is left inconsistent.
+	WriteRollbackFailed WriteState = "rollback-failed"
+)
+
+// WriteOutcome is what happened to a WriteRequest.
+type WriteOutcome struct {
+	Address uintptr    `json:"address"`
+	State   WriteState `json:"state"`
+	// Original are the bytes that were at Address before the transaction, if they were read.
+	Original []byte `json:"original,omitempty"`
+	// Error is why the write, or its rollback, failed.
+	Error string `json:"error,omitempty"`
+}
+
+// ErrTransactionFailed is the error matched by the harderrors of WriteTransaction when it validated the writes and
+// didn't apply them all.
+var ErrTransactionFailed = errors.New("write transaction failed")
+
+// ErrExpectedMismatch is the error matched by the harderror of WriteTransaction when the memory of a write doesn't
+// have its Expected bytes.
+var ErrExpectedMismatch = errors.New("memory doesn't have the expected bytes")
+
+// WriteTransaction writes all of writes to the memory of p, or none of them. It's meant for patches that write
+// several dependent locations, like code and a pointer to it, which leave the process broken if only some of them are
+// made. p must have been allowed to be modified with process.AllowMutation. It's only implemented on Linux.
+//
+// Every write is validated before any is made: its memory must be mapped, and writable unless ForceProtect is set,
+// and it must have the Expected bytes if they are given. Then the writes are made in order and read back. If one
+// fails, the ones already made are rolled back in reverse order, restoring their original bytes. The outcome of each
+// write is returned in the same order as writes, even with a harderror, which matches ErrTransactionFailed and, if the
+// expected bytes weren't found, ErrExpectedMismatch.
+func WriteTransaction(p process.Process, writes []WriteRequest, opts WriteTransactionOptions) (
+	outcomes []WriteOutcome, harderror error, softerrors []error) {
+
+	if err := process.CheckMutation(p); err != nil {
+		return nil, err, nil
+	}
+	if len(writes) == 0 {
+		return nil, nil, nil
+	}
+	outcomes = make([]WriteOutcome, len(writes))
+	for i, w := range writes {
+		outcomes[i] = WriteOutcome{Address: w.Address, State: WriteNotApplied}
+		if len(w.Data) == 0 {
+			return outcomes, fmt.Errorf("Write %d at %x has no data", i, w.Address), nil
+		}
+		if w.Expected != nil && len(w.Expected) != len(w.Data) {
+			return outcomes, fmt.Errorf("Write %d at %x expects %d bytes but writes %d", i, w.Address,
+				len(w.Expected), len(w.Data)), nil
+		}
+	}
+
+	if opts.Suspend {
+		defer func() {
+			err, serrs := p.Resume()
+			softerrors = append(softerrors, serrs...)
+			if err != nil {
+				softerrors = append(softerrors, fmt.Errorf("Unable to resume process %d after writing (%v)",
+					p.Pid(), err))
+			}
+		}()
+		err, serrs := p.Suspend()
+		softerrors = append(softerrors, serrs...)
+		if err != nil {
+			return outcomes, err, softerrors
+		}
+	}
+
+	regions, harderror, serrs := MemoryRegions(p)
+	softerrors = append(softerrors, serrs...)
+	if harderror != nil {
+		return outcomes, harderror, softerrors
+	}
+	for i, w := range writes {
+		if err := checkWritable(regions, w); err != nil {
+			outcomes[i].State, outcomes[i].Error = WriteFailed, err.Error()
+			return outcomes, fmt.Errorf("%w: write %d: %v", ErrTransactionFailed, i, err), softerrors
+		}
+	}
+
+	// The original bytes are read right before writing, so comparing and writing is as atomic as the suspension
+	// makes it.
+	for i, w := range writes {
+		original := make([]byte, len(w.Data))
+		if err, serrs := CopyMemory(p, w.Address, original); err != nil {
+			softerrors = append(softerrors, serrs...)
+			outcomes[i].State, outcomes[i].Error = WriteFailed, err.Error()
+			return outcomes, fmt.Errorf("%w: write %d: %v", ErrTransactionFailed, i, err), softerrors
+		}
+		outcomes[i].Original = original
+		if w.Expected != nil && !bytes.Equal(original, w.Expected) {
+			err := fmt.Errorf("%w: found %x at %x, expected %x", ErrExpectedMismatch, original, w.Address, w.Expected)
+			outcomes[i].State, outcomes[i].Error = WriteFailed, err.Error()
+			return outcomes, fmt.Errorf("%w: write %d: %w", ErrTransactionFailed, i, err), softerrors
+		}
+	}
+
+	for i, w := range writes {
+		n, err := writeMemory(p, w.Address, w.Data)
+		if err == nil {
+			err = verifyWrite(p, w)
+		}
+		if err == nil {
+			outcomes[i].State = WriteApplied
+			continue
+		}
+		outcomes[i].State, outcomes[i].Error = WriteFailed, err.Error()
+		// A write made halfway, or that can't be verified, is rolled back with the ones before it.
+		if n > 0 {
+			outcomes[i].State = WriteApplied
+		}
+		softerrors = append(softerrors, rollback(p, writes[:i+1], outcomes[:i+1])...)
+		return outcomes, fmt.Errorf("%w: write %d: %v", ErrTransactionFailed, i, err), softerrors
+	}
+	return outcomes, nil, softerrors
+}
+
+// checkWritable checks that the memory of w is in regions, which are sorted, and that they are writable unless w
+// forces it.
+func checkWritable(regions []MemoryRegion, w WriteRequest) error {
+	address, end := w.Address, w.Address+uintptr(len(w.Data))
+	if end < address {
+		return fmt.Errorf("Write at %x of %d bytes overflows", w.Address, len(w.Data))
+	}
+	for _, region := range regions {
+		regionEnd := region.Address + uintptr(region.Size)
+		if regionEnd <= address || region.Address > address {
+			continue
+		}
+		if region.Access&Writable == 0 && !w.ForceProtect {
+			return fmt.Errorf("Region %v isn't writable", region)
+		}
+		if regionEnd >= end {
+			return nil
+		}
+		address = regionEnd
+	}
+	return fmt.Errorf("Address %x isn't mapped", address)
+}
+
+// verifyWrite reads w back.
+func verifyWrite(p process.Process, w WriteRequest) error {
+	written := make([]byte, len(w.Data))
+	if err, _ := CopyMemory(p, w.Address, written); err != nil {
+		return fmt.Errorf("Unable to verify the write (%v)", err)
+	}
+	if !bytes.Equal(written, w.Data) {
+		return fmt.Errorf("Wrote %x at %x, but read back %x", w.Data, w.Address, written)
+	}
+	return nil
+}
+
+// rollback restores the original bytes of the writes that were applied, in reverse order, and updates their outcomes.
+func rollback(p process.Process, writes []WriteRequest, outcomes []WriteOutcome) (softerrors []error) {
+	for i := len(writes) - 1; i >= 0; i-- {
+		if outcomes[i].State != WriteApplied {
+			continue
+		}
+		if _, err := writeMemory(p, writes[i].Address, outcomes[i].Original); err != nil {
+			outcomes[i].State, outcomes[i].Error = WriteRollbackFailed, err.Error()
+			softerrors = append(softerrors, fmt.Errorf("Unable to roll back the write at %x of process %d (%v)",
+				writes[i].Address, p.Pid(), err))
+			continue
+		}
+		outcomes[i].State = WriteRolledBack
+	}
+	return softerrors
+}
@@ -0,0 +1,34 @@
+package memaccess
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/polyverse/masche/common"
+	"github.com/polyverse/masche/process"
+)
+
+// writeMemory writes data at address in the memory of p through its mem file, which writes even to memory that isn't
+// writable, as a debugger does, unless it's a shared mapping. It returns how many bytes were written.
+func writeMemory(p process.Process, address uintptr, data []byte) (n int, err error) {
+	offset, err := common.MemFileOffset(address, len(data))
+	if err != nil {
+		return 0, err
+	}
+	if err := process.VerifyMatch(p); err != nil {
+		return 0, err
+	}
+	path := common.MemFilePathFromPid(uint(p.Pid()))
+	mem, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return 0, fmt.Errorf("Unable to open the memory of process %d for writing (%w)", p.Pid(),
+			process.ProcFileError(p.Pid(), path, err))
+	}
+	defer mem.Close()
+
+	n, err = mem.WriteAt(data, offset)
+	if err != nil {
+		return n, fmt.Errorf("Error while writing %d bytes starting at %x: %v", len(data), address, err)
+	}
+	return n, nil
+}
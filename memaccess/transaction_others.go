@@ -0,0 +1,13 @@
+// +build windows darwin
+
+package memaccess
+
+import (
+	"fmt"
+
+	"github.com/polyverse/masche/process"
+)
+
+func writeMemory(p process.Process, address uintptr, data []byte) (n int, err error) {
+	return 0, fmt.Errorf("Writing memory is not implemented on this platform")
+}
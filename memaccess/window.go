@@ -0,0 +1,302 @@
+package memaccess
+
+import (
+	"container/list"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/polyverse/masche/process"
+)
+
+// WindowOptions modifies the behaviour of a WindowedReader. Its zero value is a sensible default.
+type WindowOptions struct {
+	// CachePages is the most pages kept in the cache, the least recently used are evicted first. If it's zero
+	// DefaultWindowCachePages is used.
+	CachePages int
+	// Prefetch is the amount of pages before and after each window that are read in the background, so moving the
+	// window a little finds them cached.
+	Prefetch int
+	// MaxAge is how long a cached page is used before it's read again. If it's zero pages are used until they are
+	// evicted or invalidated, which is only right for memory that isn't expected to change.
+	MaxAge time.Duration
+}
+
+// DefaultWindowCachePages is the size of the cache of a WindowedReader when the options don't specify one.
+const DefaultWindowCachePages = 256
+
+// WindowStats counts the work done by a WindowedReader.
+type WindowStats struct {
+	// Hits and Misses count the pages of the windows found and not found in the cache. Expired pages are misses.
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+	// Prefetched is the amount of pages read in the background.
+	Prefetched uint64 `json:"prefetched"`
+	// Evicted is the amount of pages dropped from the cache to make room for others.
+	Evicted uint64 `json:"evicted"`
+}
+
+// WindowedReader reads small windows of the memory of a process, like the ones shown by a hex viewer, with low
+// latency. It caches the pages it reads and prefetches the pages around each window in the background.
+//
+// It's meant to be used by a single goroutine, like the loop of a user interface. Close stops the prefetching.
+type WindowedReader struct {
+	b        MemoryBackend
+	opts     WindowOptions
+	pageSize uintptr
+
+	mu sync.Mutex
+	// pages are the cached pages by address, and lru their addresses, the most recently used first.
+	pages map[uintptr]*list.Element
+	lru   *list.List
+	stats WindowStats
+	// generation is increased by every invalidation, so prefetches that started before one are discarded.
+	generation uint64
+
+	prefetch chan prefetchRequest
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+type cachedPage struct {
+	address uintptr
+	data    []byte
+	read    time.Time
+}
+
+type prefetchRequest struct {
+	pages      []uintptr
+	generation uint64
+}
+
+// NewWindowedReader returns a WindowedReader of the memory of p.
+func NewWindowedReader(p process.Process, opts WindowOptions) *WindowedReader {
+	return NewBackendWindowedReader(ProcessBackend(p), opts)
+}
+
+// NewBackendWindowedReader works as NewWindowedReader, but it reads the memory of any MemoryBackend.
+func NewBackendWindowedReader(b MemoryBackend, opts WindowOptions) *WindowedReader {
+	if opts.CachePages <= 0 {
+		opts.CachePages = DefaultWindowCachePages
+	}
+	r := &WindowedReader{
+		b:        b,
+		opts:     opts,
+		pageSize: uintptr(os.Getpagesize()),
+		pages:    make(map[uintptr]*list.Element),
+		lru:      list.New(),
+		prefetch: make(chan prefetchRequest, 1),
+		done:     make(chan struct{}),
+	}
+	if opts.Prefetch > 0 {
+		r.wg.Add(1)
+		go r.prefetcher()
+	}
+	return r
+}
+
+// ReadWindow returns the size bytes at address. The pages that aren't cached are read, and the ones around the
+// window are prefetched. A harderror is returned if any of the window can't be read.
+func (r *WindowedReader) ReadWindow(address uintptr, size uint) (data []byte, harderror error, softerrors []error) {
+	data = make([]byte, size)
+	if size == 0 {
+		return data, nil, nil
+	}
+	first := address &^ (r.pageSize - 1)
+	end := address + uintptr(size)
+	if end < address {
+		return nil, fmt.Errorf("The window of %d bytes at %x wraps around the address space", size, address), nil
+	}
+
+	// The loop stops at the last page instead of comparing with end, as the page after the last one of the address
+	// space is 0.
+	last := (end - 1) &^ (r.pageSize - 1)
+	for page := first; ; page += r.pageSize {
+		contents, ok := r.cached(page)
+		if !ok {
+			contents = make([]byte, r.pageSize)
+			harderror, serrs := r.b.ReadAt(page, contents)
+			softerrors = append(softerrors, serrs...)
+			if harderror != nil {
+				return nil, harderror, softerrors
+			}
+			r.store(page, contents, r.currentGeneration())
+		}
+
+		// The part of the page inside the window.
+		from, to := uintptr(0), r.pageSize
+		if page < address {
+			from = address - page
+		}
+		if page == last {
+			to = end - page
+		}
+		copy(data[page+from-address:], contents[from:to])
+		if page == last {
+			break
+		}
+	}
+
+	r.schedulePrefetch(first, end)
+	return data, nil, softerrors
+}
+
+// Invalidate drops the cached pages that overlap the size bytes at address, so they are read again.
+func (r *WindowedReader) Invalidate(address uintptr, size uint) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.generation++
+	if size == 0 {
+		return
+	}
+	// The bytes past the top of the address space are dropped, so the last page doesn't wrap around.
+	last := address + uintptr(size) - 1
+	if last < address {
+		last = ^uintptr(0)
+	}
+	last &^= r.pageSize - 1
+	for page := address &^ (r.pageSize - 1); ; page += r.pageSize {
+		if e, ok := r.pages[page]; ok {
+			r.lru.Remove(e)
+			delete(r.pages, page)
+		}
+		if page == last {
+			break
+		}
+	}
+}
+
+// InvalidateAll drops every cached page.
+func (r *WindowedReader) InvalidateAll() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.generation++
+	r.pages = make(map[uintptr]*list.Element)
+	r.lru.Init()
+}
+
+// Stats returns the counts of the work done so far.
+func (r *WindowedReader) Stats() WindowStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.stats
+}
+
+// Close stops the prefetching, and waits for the prefetch in progress, if any.
+func (r *WindowedReader) Close() {
+	select {
+	case <-r.done:
+		return
+	default:
+	}
+	close(r.done)
+	r.wg.Wait()
+}
+
+// cached returns a page from the cache, counting the hit or the miss.
+func (r *WindowedReader) cached(page uintptr) (data []byte, ok bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e, ok := r.pages[page]
+	if ok {
+		cp := e.Value.(*cachedPage)
+		if r.opts.MaxAge == 0 || time.Since(cp.read) <= r.opts.MaxAge {
+			r.stats.Hits++
+			r.lru.MoveToFront(e)
+			return cp.data, true
+		}
+	}
+	r.stats.Misses++
+	return nil, false
+}
+
+func (r *WindowedReader) currentGeneration() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.generation
+}
+
+// store caches a page read at the given generation, unless the cache was invalidated since then.
+func (r *WindowedReader) store(page uintptr, data []byte, generation uint64) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if generation != r.generation {
+		return false
+	}
+	if e, ok := r.pages[page]; ok {
+		r.lru.Remove(e)
+	}
+	r.pages[page] = r.lru.PushFront(&cachedPage{address: page, data: data, read: time.Now()})
+	for r.lru.Len() > r.opts.CachePages {
+		oldest := r.lru.Back()
+		r.lru.Remove(oldest)
+		delete(r.pages, oldest.Value.(*cachedPage).address)
+		r.stats.Evicted++
+	}
+	return true
+}
+
+// schedulePrefetch asks the prefetcher for the uncached pages around the window from first to end. If the prefetcher
+// is busy the previous request, which is further from where the window is now, is replaced.
+func (r *WindowedReader) schedulePrefetch(first uintptr, end uintptr) {
+	if r.opts.Prefetch <= 0 {
+		return
+	}
+	span := uintptr(r.opts.Prefetch) * r.pageSize
+	var pages []uintptr
+	r.mu.Lock()
+	for page := first - span; page != first && page < first; page += r.pageSize {
+		if _, ok := r.pages[page]; !ok {
+			pages = append(pages, page)
+		}
+	}
+	last := (end - 1) &^ (r.pageSize - 1)
+	for page := last + r.pageSize; page > last && page <= last+span; page += r.pageSize {
+		if _, ok := r.pages[page]; !ok {
+			pages = append(pages, page)
+		}
+	}
+	req := prefetchRequest{pages: pages, generation: r.generation}
+	r.mu.Unlock()
+	if len(pages) == 0 {
+		return
+	}
+
+	for {
+		select {
+		case r.prefetch <- req:
+			return
+		case <-r.prefetch:
+			// Drop the stale request and try again.
+		}
+	}
+}
+
+// prefetcher reads the pages it's asked for in the background, all of them at once.
+func (r *WindowedReader) prefetcher() {
+	defer r.wg.Done()
+	for {
+		select {
+		case <-r.done:
+			return
+		case req := <-r.prefetch:
+			reqs := make([]ReadRequest, len(req.pages))
+			for i, page := range req.pages {
+				reqs[i] = ReadRequest{Address: page, Size: uint(r.pageSize)}
+			}
+			// Pages that can't be read are left for ReadWindow to report.
+			results, harderror, _ := ReadBackendBatch(r.b, reqs)
+			if harderror != nil {
+				continue
+			}
+			for i, result := range results {
+				if result.Err == nil && r.store(req.pages[i], result.Data, req.generation) {
+					r.mu.Lock()
+					r.stats.Prefetched++
+					r.mu.Unlock()
+				}
+			}
+		}
+	}
+}
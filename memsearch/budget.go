@@ -0,0 +1,104 @@
+package memsearch
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"unsafe"
+)
+
+// ErrMemoryBudgetExceeded is returned by the scans that need more memory than their MemoryBudget has left.
+var ErrMemoryBudgetExceeded = errors.New("memory budget exceeded")
+
+// minBudgetBufferSize is the smallest buffer FindAll shrinks its buffer to when its MemoryBudget is short.
+const minBudgetBufferSize = uint(4096)
+
+// MemoryBudget bounds the memory used by the scans that share it, so scanning many processes at once can't exhaust
+// the memory of the host. It accounts for the read buffers and the matches while they are being collected; once a
+// scan returns its matches belong to the caller and they are no longer accounted for.
+//
+// When a scan would exceed the budget it first uses a smaller read buffer, down to 4KiB, and if that's not enough it
+// fails with ErrMemoryBudgetExceeded.
+//
+// A MemoryBudget is safe for concurrent use. A nil *MemoryBudget is unlimited.
+type MemoryBudget struct {
+	limit uint64
+
+	mu   sync.Mutex
+	used uint64
+	peak uint64
+}
+
+// NewMemoryBudget creates a MemoryBudget of limit bytes.
+func NewMemoryBudget(limit uint64) *MemoryBudget {
+	return &MemoryBudget{limit: limit}
+}
+
+// Used returns the amount of bytes currently reserved.
+func (b *MemoryBudget) Used() uint64 {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.used
+}
+
+// Peak returns the highest amount of bytes ever reserved at once.
+func (b *MemoryBudget) Peak() uint64 {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.peak
+}
+
+// Reserve accounts for n bytes used outside of the scans, like the buffers of the callers. It returns false, and
+// reserves nothing, if they don't fit in the budget. The bytes must be given back with Release.
+func (b *MemoryBudget) Reserve(n uint64) bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.used+n > b.limit {
+		return false
+	}
+	b.used += n
+	if b.used > b.peak {
+		b.peak = b.used
+	}
+	return true
+}
+
+// Release gives back n bytes reserved with Reserve or ReserveBuffer.
+func (b *MemoryBudget) Release(n uint64) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.used -= n
+}
+
+// ReserveBuffer reserves a read buffer of up to size bytes plus extra bytes, halving the buffer down to 4KiB while it
+// doesn't fit. It returns the size of the buffer reserved; size+extra bytes must be given back with Release.
+func (b *MemoryBudget) ReserveBuffer(size uint, extra uint) (uint, error) {
+	for !b.Reserve(uint64(extra) + uint64(size)) {
+		if size <= minBudgetBufferSize {
+			return 0, fmt.Errorf("Unable to allocate a read buffer: %w", ErrMemoryBudgetExceeded)
+		}
+		size /= 2
+		if size < minBudgetBufferSize {
+			size = minBudgetBufferSize
+		}
+	}
+	return size, nil
+}
+
+// matchCost is the memory accounted for a match.
+func matchCost(m Match) uint64 {
+	return uint64(unsafe.Sizeof(m)) + uint64(len(m.Bytes))
+}
@@ -0,0 +1,18 @@
+package memsearch
+
+import (
+	"github.com/polyverse/masche/common"
+)
+
+// Features of this package in the capabilities matrix.
+const (
+	// CapabilityImpact is measuring the ImpactReport of a scan, which samples the resident pages of the process.
+	CapabilityImpact = "impact"
+	// CapabilityFileReads is reading unmodified file backed memory from the files, with PreferFileReads.
+	CapabilityFileReads = "file-reads"
+)
+
+func init() {
+	common.RegisterCapability(CapabilityImpact, impactProbe)
+	common.RegisterCapability(CapabilityFileReads, fileReadsProbe)
+}
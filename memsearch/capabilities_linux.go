@@ -0,0 +1,40 @@
+package memsearch
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/polyverse/masche/common"
+)
+
+// impactProbe opens the pagemap file, which needs the same permission as reading the memory of the process.
+func impactProbe(pid int) (common.Availability, string) {
+	if pid == 0 {
+		return procFileProbe(common.PagemapFilePathFromPid(uint(os.Getpid())), true)
+	}
+	return procFileProbe(common.PagemapFilePathFromPid(uint(pid)), false)
+}
+
+// fileReadsProbe opens the smaps file, which tells what memory is the same as the files.
+func fileReadsProbe(pid int) (common.Availability, string) {
+	if pid == 0 {
+		return procFileProbe(common.SmapsFilePathFromPid(uint(os.Getpid())), true)
+	}
+	return procFileProbe(common.SmapsFilePathFromPid(uint(pid)), false)
+}
+
+// procFileProbe tells if a file of the proc filesystem can be read. Our own files are missing only if the kernel lacks
+// them, but the files of other processes are also missing if the proc filesystem hides them from us (hidepid).
+func procFileProbe(path string, own bool) (common.Availability, string) {
+	f, err := os.Open(path)
+	switch {
+	case err == nil:
+		f.Close()
+		return common.Available, ""
+	case os.IsPermission(err):
+		return common.NeedsPrivilege, err.Error()
+	case os.IsNotExist(err) && own:
+		return common.UnsupportedKernel, fmt.Sprintf("the kernel lacks %s", path)
+	}
+	return common.NeedsPrivilege, err.Error()
+}
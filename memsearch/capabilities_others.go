@@ -0,0 +1,15 @@
+// +build windows darwin
+
+package memsearch
+
+import (
+	"github.com/polyverse/masche/common"
+)
+
+func impactProbe(pid int) (common.Availability, string) {
+	return common.UnsupportedPlatform, "the impact of scans is only measured on linux"
+}
+
+func fileReadsProbe(pid int) (common.Availability, string) {
+	return common.UnsupportedPlatform, "file backed memory is only read from the files on linux"
+}
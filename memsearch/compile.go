@@ -0,0 +1,272 @@
+package memsearch
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+// automatonMinPatterns is the amount of patterns from which they are searched with an automaton. Searching fewer
+// patterns one by one is faster, as their finders are vectorized.
+var automatonMinPatterns = 16
+
+// CompiledPatterns are patterns prepared to be searched. Compiling many patterns builds an Aho-Corasick automaton
+// that finds all of them in a single pass over the memory, which takes time and memory to build, so a sweep of many
+// processes compiles them once and gives them to every scan in SearchOptions.Compiled.
+//
+// CompiledPatterns are immutable: the state of each scan is kept by the scan, so they can be searched by many scans at
+// once, from many goroutines.
+type CompiledPatterns struct {
+	patterns []Pattern
+	maxLen   int
+	// finders search the patterns one by one, when there's no automaton.
+	finders []finder
+	// automaton is nil when the patterns are searched one by one.
+	automaton *automaton
+}
+
+// Compile prepares patterns to be searched. The patterns mustn't be modified after they are compiled.
+func Compile(patterns []Pattern) (*CompiledPatterns, error) {
+	if err := checkPatterns(patterns); err != nil {
+		return nil, err
+	}
+	c := &CompiledPatterns{patterns: append([]Pattern(nil), patterns...)}
+	for _, pattern := range patterns {
+		if len(pattern.Bytes) > c.maxLen {
+			c.maxLen = len(pattern.Bytes)
+		}
+	}
+	if len(patterns) >= automatonMinPatterns {
+		c.automaton = buildAutomaton(patterns)
+	} else {
+		for _, pattern := range patterns {
+			c.finders = append(c.finders, newFinder(pattern.Bytes))
+		}
+	}
+	return c, nil
+}
+
+// Len returns the amount of patterns compiled.
+func (c *CompiledPatterns) Len() int {
+	return len(c.patterns)
+}
+
+// Size returns the approximate amount of memory the compiled patterns hold, in bytes, the bytes of the patterns
+// included.
+func (c *CompiledPatterns) Size() uint64 {
+	size := uint64(0)
+	for _, pattern := range c.patterns {
+		size += uint64(len(pattern.Bytes))
+	}
+	if c.automaton != nil {
+		size += c.automaton.size()
+	}
+	return size
+}
+
+// compiles tells if c is the compiled form of patterns.
+func (c *CompiledPatterns) compiles(patterns []Pattern) bool {
+	if len(patterns) != len(c.patterns) {
+		return false
+	}
+	for i := range patterns {
+		if !bytes.Equal(patterns[i].Bytes, c.patterns[i].Bytes) {
+			return false
+		}
+	}
+	return true
+}
+
+// search calls found with the index of the pattern and the offset in buf of each occurrence of the patterns in buf,
+// until it returns false. The occurrences of each pattern are found in order, and the patterns whose skip entry is
+// set, which found can set, are skipped.
+func (c *CompiledPatterns) search(buf []byte, skip []bool, found func(pattern int, index int) bool) {
+	if c.automaton != nil {
+		c.automaton.search(buf, func(pattern int, end int) bool {
+			if skip[pattern] {
+				return true
+			}
+			return found(pattern, end-len(c.patterns[pattern].Bytes))
+		})
+		return
+	}
+
+	for i := range c.patterns {
+		for from := 0; from < len(buf) && !skip[i]; {
+			index := c.finders[i].index(buf[from:])
+			if index == -1 {
+				break
+			}
+			index += from
+			from = index + 1
+			if !found(i, index) {
+				return
+			}
+		}
+	}
+}
+
+// automaton is an Aho-Corasick automaton. Its states are numbered in breadth first order, with the root as state 0.
+// The edges of state k are the edgeBytes and edgeTargets from edgeStart[k] to edgeStart[k+1], sorted by byte, and
+// the patterns that end in it are the outputs from outputStart[k] to outputStart[k+1].
+type automaton struct {
+	// root are the transitions of the root for every byte, to itself when it has no edge.
+	root        [256]int32
+	edgeStart   []int32
+	edgeBytes   []byte
+	edgeTargets []int32
+	// fail is the state of the longest proper suffix of the state that is also a state, and dict the nearest state
+	// with outputs following the fail links, or -1.
+	fail        []int32
+	dict        []int32
+	outputStart []int32
+	outputs     []int32
+}
+
+// trieNode is a node of the trie the automaton is built from. Children are linked from the first one, sorted.
+type trieNode struct {
+	label                              byte
+	firstChild, lastChild, nextSibling int32
+}
+
+func buildAutomaton(patterns []Pattern) *automaton {
+	// Inserting the patterns sorted only ever adds the last child of a node, and keeps the children sorted.
+	order := make([]int, len(patterns))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return bytes.Compare(patterns[order[i]].Bytes, patterns[order[j]].Bytes) < 0
+	})
+
+	nodes := []trieNode{{firstChild: -1, lastChild: -1, nextSibling: -1}}
+	ends := make([]int32, len(patterns))
+	var path []int32
+	var previous []byte
+	for _, i := range order {
+		pattern := patterns[i].Bytes
+		common := 0
+		for common < len(pattern) && common < len(previous) && pattern[common] == previous[common] {
+			common++
+		}
+		path = path[:common]
+		node := int32(0)
+		if common > 0 {
+			node = path[common-1]
+		}
+		for _, c := range pattern[common:] {
+			child := int32(len(nodes))
+			nodes = append(nodes, trieNode{label: c, firstChild: -1, lastChild: -1, nextSibling: -1})
+			if nodes[node].lastChild == -1 {
+				nodes[node].firstChild = child
+			} else {
+				nodes[nodes[node].lastChild].nextSibling = child
+			}
+			nodes[node].lastChild = child
+			node = child
+			path = append(path, node)
+		}
+		ends[i] = node
+		previous = pattern
+	}
+
+	// Renumber the nodes breadth first, so the fail link of every state is computed before the ones of its children.
+	a := &automaton{
+		edgeStart:   make([]int32, len(nodes)+1),
+		edgeBytes:   make([]byte, 0, len(nodes)-1),
+		edgeTargets: make([]int32, 0, len(nodes)-1),
+		fail:        make([]int32, len(nodes)),
+		dict:        make([]int32, len(nodes)),
+		outputStart: make([]int32, len(nodes)+1),
+		outputs:     make([]int32, 0, len(patterns)),
+	}
+	state := make([]int32, len(nodes))
+	queue := []int32{0}
+	for next := 0; next < len(queue); next++ {
+		a.edgeStart[next] = int32(len(a.edgeBytes))
+		for child := nodes[queue[next]].firstChild; child != -1; child = nodes[child].nextSibling {
+			state[child] = int32(len(queue))
+			queue = append(queue, child)
+			a.edgeBytes = append(a.edgeBytes, nodes[child].label)
+			a.edgeTargets = append(a.edgeTargets, state[child])
+		}
+	}
+	a.edgeStart[len(nodes)] = int32(len(a.edgeBytes))
+
+	patternsOf := make([][]int32, len(nodes))
+	for i, node := range ends {
+		patternsOf[state[node]] = append(patternsOf[state[node]], int32(i))
+	}
+	for k := range patternsOf {
+		a.outputStart[k] = int32(len(a.outputs))
+		a.outputs = append(a.outputs, patternsOf[k]...)
+	}
+	a.outputStart[len(nodes)] = int32(len(a.outputs))
+
+	for e := a.edgeStart[0]; e < a.edgeStart[1]; e++ {
+		a.root[a.edgeBytes[e]] = a.edgeTargets[e]
+	}
+	a.dict[0] = -1
+	for k := int32(0); k < int32(len(nodes)); k++ {
+		for e := a.edgeStart[k]; e < a.edgeStart[k+1]; e++ {
+			child := a.edgeTargets[e]
+			if k != 0 {
+				a.fail[child] = a.step(a.fail[k], a.edgeBytes[e])
+			}
+			if f := a.fail[child]; a.outputStart[f] != a.outputStart[f+1] {
+				a.dict[child] = f
+			} else {
+				a.dict[child] = a.dict[f]
+			}
+		}
+	}
+	return a
+}
+
+// step returns the state the automaton goes to from state when it reads c.
+func (a *automaton) step(state int32, c byte) int32 {
+	for state != 0 {
+		for e := a.edgeStart[state]; e < a.edgeStart[state+1]; e++ {
+			if a.edgeBytes[e] == c {
+				return a.edgeTargets[e]
+			}
+		}
+		state = a.fail[state]
+	}
+	return a.root[c]
+}
+
+// search calls found with the index of the pattern and the offset in buf of the end of each occurrence of the
+// patterns in buf, in the order they end, until it returns false.
+func (a *automaton) search(buf []byte, found func(pattern int, end int) bool) {
+	state := int32(0)
+	for i, c := range buf {
+		state = a.step(state, c)
+		for s := state; s > 0; s = a.dict[s] {
+			for _, pattern := range a.outputs[a.outputStart[s]:a.outputStart[s+1]] {
+				if !found(int(pattern), i+1) {
+					return
+				}
+			}
+		}
+	}
+}
+
+func (a *automaton) size() uint64 {
+	int32s := len(a.root) + len(a.edgeStart) + len(a.edgeTargets) + len(a.fail) + len(a.dict) + len(a.outputStart) +
+		len(a.outputs)
+	return uint64(4*int32s + len(a.edgeBytes))
+}
+
+// checkPatterns checks that there are patterns, and that none of them is empty.
+func checkPatterns(patterns []Pattern) error {
+	if len(patterns) == 0 {
+		return fmt.Errorf("No patterns to search for")
+	}
+	for i, pattern := range patterns {
+		if len(pattern.Bytes) == 0 {
+			return fmt.Errorf("Pattern %d is empty", i)
+		}
+	}
+	return nil
+}
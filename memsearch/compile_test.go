@@ -0,0 +1,212 @@
+package memsearch
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/polyverse/masche/memaccess"
+)
+
+// compiledPatternsFixture returns patterns of minLen to maxLen bytes and static backends, all of them made of the bytes
+// of alphabet. A small alphabet makes the patterns overlap and be prefixes and suffixes of each other. One in ten
+// patterns repeats the previous one.
+func compiledPatternsFixture(t testing.TB, alphabet string, minLen, maxLen int, count int, processes int) (
+	[]Pattern, []memaccess.MemoryBackend) {
+
+	r := rand.New(rand.NewSource(1))
+	word := func(n int) []byte {
+		w := make([]byte, n)
+		for i := range w {
+			w[i] = alphabet[r.Intn(len(alphabet))]
+		}
+		return w
+	}
+
+	patterns := make([]Pattern, count)
+	for i := range patterns {
+		if i > 0 && i%10 == 0 {
+			patterns[i] = Pattern{Bytes: patterns[i-1].Bytes}
+		} else {
+			patterns[i] = Pattern{Bytes: word(minLen + r.Intn(maxLen-minLen+1))}
+		}
+	}
+
+	backends := make([]memaccess.MemoryBackend, processes)
+	for i := range backends {
+		segments := []memaccess.Segment{
+			{Region: memaccess.MemoryRegion{Address: 0x1000, Size: 0x3000, Access: memaccess.Readable},
+				Data: word(0x3000)},
+			{Region: memaccess.MemoryRegion{Address: 0x10000, Size: 0x800, Access: memaccess.Readable},
+				Data: word(0x800)},
+		}
+		b, err := memaccess.NewStaticBackend(memaccess.BackendInfo{Kind: "static", Pid: 100 + i}, segments)
+		if err != nil {
+			t.Fatal(err)
+		}
+		backends[i] = b
+	}
+	return patterns, backends
+}
+
+// naiveFindAll finds every occurrence of the patterns in b, one offset at a time.
+func naiveFindAll(t testing.TB, b memaccess.MemoryBackend, patterns []Pattern) map[[2]uintptr]bool {
+	regions, err, _ := b.Regions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[[2]uintptr]bool{}
+	for _, region := range regions {
+		data := make([]byte, region.Size)
+		if err, _ := b.ReadAt(region.Address, data); err != nil {
+			t.Fatal(err)
+		}
+		for i, pattern := range patterns {
+			for off := 0; off+len(pattern.Bytes) <= len(data); off++ {
+				if bytes.Equal(data[off:off+len(pattern.Bytes)], pattern.Bytes) {
+					expected[[2]uintptr{region.Address + uintptr(off), uintptr(i)}] = true
+				}
+			}
+		}
+	}
+	return expected
+}
+
+func TestCompiledPatterns(t *testing.T) {
+	patterns, backends := compiledPatternsFixture(t, "abcd", 1, 12, 200, 1)
+	compiled, err := Compile(patterns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if compiled.automaton == nil || compiled.Len() != len(patterns) ||
+		compiled.Size() <= compiled.automaton.size() {
+
+		t.Fatalf("Unexpected compiled patterns: %d patterns, %d bytes", compiled.Len(), compiled.Size())
+	}
+
+	// Small buffers make occurrences span many reads.
+	matches, _, err, softerrors := FindAllIn(backends[0], 0, patterns, SearchOptions{BufferSize: 100,
+		Compiled: compiled})
+	if err != nil || len(softerrors) != 0 {
+		t.Fatal(err, softerrors)
+	}
+	expected := naiveFindAll(t, backends[0], patterns)
+	for i, m := range matches {
+		if !expected[[2]uintptr{m.Address, uintptr(m.Pattern)}] {
+			t.Errorf("Unexpected match %v", m)
+		}
+		if i > 0 && (matches[i-1].Address > m.Address || matches[i-1].Address == m.Address &&
+			matches[i-1].Pattern >= m.Pattern) {
+
+			t.Errorf("Match %v is out of order", m)
+		}
+	}
+	if len(matches) != len(expected) {
+		t.Errorf("Found %d matches, expected %d", len(matches), len(expected))
+	}
+
+	// The short circuits skip the patterns already found.
+	perRegion, _, err, _ := FindAllIn(backends[0], 0, patterns, SearchOptions{ShortCircuit: PerRegion,
+		Compiled: compiled})
+	if err != nil {
+		t.Fatal(err)
+	}
+	uncompiled, _, err, _ := FindAllIn(backends[0], 0, patterns, SearchOptions{ShortCircuit: PerRegion})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(perRegion, uncompiled) {
+		t.Errorf("The automaton found %d first occurrences per region, searching one by one found %d",
+			len(perRegion), len(uncompiled))
+	}
+
+	other := append([]Pattern{{Bytes: []byte("x")}}, patterns[1:]...)
+	if _, _, err, _ := FindAllIn(backends[0], 0, other, SearchOptions{Compiled: compiled}); err == nil {
+		t.Error("Searching patterns other than the compiled ones should fail")
+	}
+}
+
+// Many scans search the same compiled patterns at once. Run with -race.
+func TestCompiledPatternsConcurrent(t *testing.T) {
+	patterns, backends := compiledPatternsFixture(t, "abcd", 1, 12, 100, 8)
+	compiled, err := Compile(patterns)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := make([]int, len(backends))
+	for i, b := range backends {
+		expected[i] = len(naiveFindAll(t, b, patterns))
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 4*len(backends))
+	for round := 0; round < 4; round++ {
+		for i, b := range backends {
+			wg.Add(1)
+			go func(b memaccess.MemoryBackend, expected int) {
+				defer wg.Done()
+				matches, _, err, _ := FindAllIn(b, 0, patterns, SearchOptions{Compiled: compiled})
+				if err != nil {
+					errs <- err
+					return
+				}
+				if len(matches) != expected {
+					errs <- fmt.Errorf("Found %d matches in process %d, expected %d", len(matches), b.Info().Pid,
+						expected)
+				}
+			}(b, expected[i])
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+// binaryAlphabet has every byte, like the memory of a process.
+var binaryAlphabet = func() string {
+	b := make([]byte, 256)
+	for i := range b {
+		b[i] = byte(i)
+	}
+	return string(b)
+}()
+
+// A sweep of 50 processes compiling a large set of patterns once, or once per process.
+func BenchmarkCompiledSweep(b *testing.B) {
+	patterns, backends := compiledPatternsFixture(b, binaryAlphabet, 4, 16, 5000, 50)
+	sweep := func(b *testing.B, compileEach bool) {
+		for i := 0; i < b.N; i++ {
+			opts := SearchOptions{}
+			if !compileEach {
+				compiled, err := Compile(patterns)
+				if err != nil {
+					b.Fatal(err)
+				}
+				opts.Compiled = compiled
+			}
+			for _, backend := range backends {
+				if _, _, err, _ := FindAllIn(backend, 0, patterns, opts); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	}
+	b.Run("CompiledOnce", func(b *testing.B) { sweep(b, false) })
+	b.Run("CompiledPerProcess", func(b *testing.B) { sweep(b, true) })
+}
+
+func BenchmarkCompile(b *testing.B) {
+	patterns, _ := compiledPatternsFixture(b, binaryAlphabet, 4, 16, 50000, 0)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := Compile(patterns); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
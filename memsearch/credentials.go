@@ -0,0 +1,67 @@
+package memsearch
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/polyverse/masche/process"
+)
+
+// CredentialPolicy is what FindAll does when the process changes its credentials during the scan.
+type CredentialPolicy int
+
+const (
+	// AbortOnCredentialChange fails the scan with a *process.CredentialsError.
+	AbortOnCredentialChange CredentialPolicy = iota
+	// AnnotateCredentialChange finishes the scan, marks the matches found after the change was detected, and reports
+	// the change in ScanStats.CredentialChange.
+	AnnotateCredentialChange
+)
+
+// CredentialChecks makes FindAll check the credentials of the process, as returned by process.CurrentCredentials,
+// before scanning each region and at the end of the scan.
+type CredentialChecks struct {
+	OnChange CredentialPolicy
+	// MinInterval is the least time between checks, the checks before regions closer than it to the last one are
+	// skipped. The check at the end of the scan is always done.
+	MinInterval time.Duration
+}
+
+// credentialWatch detects the credential changes of the process being scanned. Changes are reported once.
+type credentialWatch struct {
+	p         process.Process
+	checks    CredentialChecks
+	start     process.Credentials
+	lastCheck time.Time
+	changed   bool
+}
+
+// watchCredentials starts watching the credentials of p. It returns a nil watch, which never reports changes, if
+// they aren't checked or can't be read.
+func watchCredentials(p process.Process, checks *CredentialChecks) (w *credentialWatch, softerrors []error) {
+	if checks == nil || p == nil {
+		return nil, nil
+	}
+	creds, err := process.CurrentCredentials(p)
+	if err != nil {
+		return nil, []error{fmt.Errorf("Unable to check the credentials of process %d during the scan (%v)",
+			p.Pid(), err)}
+	}
+	return &credentialWatch{p: p, checks: *checks, start: creds, lastCheck: time.Now()}, nil
+}
+
+// check returns the change of credentials, if they changed since the watch started. Unless force is set it's skipped
+// if the last check was less than MinInterval ago. Errors reading the current credentials are ignored, the process
+// may have exited and reading its memory will fail anyway.
+func (w *credentialWatch) check(force bool) *process.CredentialsError {
+	if w == nil || w.changed || !force && time.Since(w.lastCheck) < w.checks.MinInterval {
+		return nil
+	}
+	w.lastCheck = time.Now()
+	creds, err := process.CurrentCredentials(w.p)
+	if err != nil || creds == w.start {
+		return nil
+	}
+	w.changed = true
+	return &process.CredentialsError{Pid: w.p.Pid(), Before: w.start, After: creds}
+}
@@ -0,0 +1,186 @@
+package memsearch
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/polyverse/masche/common"
+	"github.com/polyverse/masche/memaccess"
+	"github.com/polyverse/masche/process"
+)
+
+// CursorVersion is the version of the Cursors made by this version of masche. Cursors of other versions can't be
+// resumed.
+const CursorVersion = 1
+
+// Cursor is the position of a scan, given to SearchOptions.Checkpoint. A paused scan continues from it with
+// ResumeFindAll, even in another run of the program, as it can be serialized to JSON.
+type Cursor struct {
+	Version int `json:"version"`
+	// Process is the index of the process being scanned when the scan is one of a sweep of many processes, like
+	// report.Scan.
+	Process int `json:"process"`
+	Pid     int `json:"pid"`
+	// Region is the address where the region being scanned starts, and Offset the amount of it already scanned.
+	// Both are zero before the first region.
+	Region uintptr `json:"region"`
+	Offset uintptr `json:"offset"`
+	// Stats are the stats of the scan up to the cursor.
+	Stats ScanStats `json:"stats"`
+}
+
+// Address is the address of the memory that the scan continues from.
+func (c Cursor) Address() uintptr {
+	return c.Region + c.Offset
+}
+
+// Disposition tells how a scan was resumed, or how much of a region a partial scan covered.
+type Disposition string
+
+const (
+	// Resumed scans continued where they were paused.
+	Resumed Disposition = "resumed"
+	// RegionChanged scans found that the region they were paused in was unmapped or changed, and continued from the
+	// same address, without the bytes before it that an occurrence spanning it needs.
+	RegionChanged Disposition = "region-changed"
+	// ProcessGone sweeps found that the process they were paused in had exited, and continued with the next one.
+	ProcessGone Disposition = "process-gone"
+
+	// Scanned regions were scanned entirely by a partial scan.
+	Scanned Disposition = "scanned"
+	// PartiallyScanned regions were being scanned when a partial scan was paused.
+	PartiallyScanned Disposition = "partially-scanned"
+	// Untouched regions weren't scanned at all by a partial scan.
+	Untouched Disposition = "untouched"
+)
+
+// RegionCoverage tells how much of a region a partial scan covered.
+type RegionCoverage struct {
+	// Region is the region as the scan saw it, starting at the address the scan or the region it resumed started.
+	Region      memaccess.MemoryRegion `json:"region"`
+	Disposition Disposition            `json:"disposition"`
+	// Scanned is the amount of bytes scanned from the start of the region.
+	Scanned uint `json:"scanned"`
+}
+
+// coverage returns the coverage of a scan of regions paused at address, in the region with index paused.
+func coverage(regions []memaccess.MemoryRegion, paused int, address uintptr) []RegionCoverage {
+	coverage := make([]RegionCoverage, len(regions))
+	for i, region := range regions {
+		coverage[i] = RegionCoverage{Region: region, Disposition: Untouched}
+		switch {
+		case i < paused:
+			coverage[i].Disposition, coverage[i].Scanned = Scanned, region.Size
+		case i == paused && address > region.Address:
+			coverage[i].Disposition, coverage[i].Scanned = PartiallyScanned, uint(address-region.Address)
+		}
+	}
+	return coverage
+}
+
+// ErrInvalidCursor is returned by ResumeFindAll for cursors of other versions or processes.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ResumeFindAll continues a scan of p paused at cursor, with the same patterns and options. Its matches are the ones
+// the scan would have found after the cursor, and its stats those of the whole scan. ScanStats.Resumed tells if the
+// scan could continue where it was paused.
+//
+// Checkpoints can't be combined with short circuits, sampling or summaries, whose state isn't kept in the cursor.
+func ResumeFindAll(p process.Process, cursor Cursor, patterns []Pattern, opts SearchOptions) (matches []Match,
+	stats ScanStats, harderror error, softerrors []error) {
+
+	if harderror = checkCursor(cursor, p.Pid()); harderror != nil {
+		return nil, stats, harderror, nil
+	}
+	opts.resume = &cursor
+	return FindAll(p, cursor.Address(), patterns, opts)
+}
+
+// ResumeFindAllIn works as ResumeFindAll, but it continues a scan made with FindAllIn.
+func ResumeFindAllIn(b memaccess.MemoryBackend, cursor Cursor, patterns []Pattern, opts SearchOptions) (
+	matches []Match, stats ScanStats, harderror error, softerrors []error) {
+
+	if harderror = checkCursor(cursor, b.Info().Pid); harderror != nil {
+		return nil, stats, harderror, nil
+	}
+	opts.resume = &cursor
+	return FindAllIn(b, cursor.Address(), patterns, opts)
+}
+
+func checkCursor(cursor Cursor, pid int) error {
+	if cursor.Version != CursorVersion {
+		return fmt.Errorf("%w: version %d, expected %d", ErrInvalidCursor, cursor.Version, CursorVersion)
+	}
+	if cursor.Pid != pid {
+		return fmt.Errorf("%w: it's of process %d, not %d", ErrInvalidCursor, cursor.Pid, pid)
+	}
+	return nil
+}
+
+// checkpoint gives the cursor at address, in region, to the Checkpoint of the options, and tells if the scan must go
+// on. Scans past their deadline don't go on.
+func (s *scanner) checkpoint(region memaccess.MemoryRegion, address uintptr) bool {
+	expired := s.progressed && !s.deadline.IsZero() && time.Now().After(s.deadline)
+	if s.opts.Checkpoint == nil && !expired {
+		return true
+	}
+	stats := s.stats
+	stats.Matches = s.resumedMatches + len(s.matches)
+	c := Cursor{Version: CursorVersion, Pid: s.b.Info().Pid, Region: region.Address, Offset: address - region.Address,
+		Stats: stats}
+	if !expired && s.opts.Checkpoint(c) {
+		return true
+	}
+	s.paused = &c
+	return false
+}
+
+// resumeRegions replaces the first of the regions to scan with the region the scan was paused in, if it's still
+// mapped as it was, and restores the stats of the cursor. It returns how the scan is resumed.
+func (s *scanner) resumeRegions(regions []memaccess.MemoryRegion) Disposition {
+	c := s.opts.resume
+	bufferSize := s.stats.BufferSize
+	s.stats = c.Stats
+	s.stats.BufferSize = bufferSize
+	s.resumedMatches = c.Stats.Matches
+	if c.Region == 0 && c.Offset == 0 {
+		return Resumed
+	}
+
+	all, err, _ := s.b.Regions()
+	if err != nil || len(regions) == 0 || regions[0].Address != c.Address() {
+		return RegionChanged
+	}
+	end := regions[0].Address + uintptr(regions[0].Size)
+	for _, region := range all {
+		if region.Address <= c.Region && region.Address+uintptr(region.Size) == end &&
+			region.Access&memaccess.Readable != 0 {
+
+			// The matches of the region are in the region as the paused scan saw it.
+			region.Size, region.Address = uint(end-c.Region), c.Region
+			regions[0] = region
+			if c.Offset > 0 {
+				s.carry = c.Address()
+			}
+			return Resumed
+		}
+	}
+	return RegionChanged
+}
+
+// readCarry reads the bytes before the address a resumed region is scanned from into the buffer, so the occurrences
+// that span that address are found. It returns how many bytes were read.
+func (s *scanner) readCarry(region memaccess.MemoryRegion, start uintptr) int {
+	from := region.Address
+	if start-from > uintptr(s.overlap) {
+		from = start - uintptr(s.overlap)
+	}
+	if harderror, serrs := s.b.ReadAt(from, s.buf[:start-from]); harderror != nil {
+		s.softerrors = append(s.softerrors, serrs...)
+		s.softerrors = append(s.softerrors, &common.LocatedError{Pid: s.b.Info().Pid, Address: from,
+			Err: fmt.Errorf("Unable to read the bytes before %x, where the scan was paused: %v", start, harderror)})
+		return 0
+	}
+	return int(start - from)
+}
@@ -0,0 +1,140 @@
+package memsearch
+
+import (
+	"fmt"
+
+	"github.com/polyverse/masche/common"
+	"github.com/polyverse/masche/memaccess"
+	"github.com/polyverse/masche/process"
+)
+
+// DivergenceOptions modifies the behaviour of CompareMappedFile. Its zero value is a sensible default.
+type DivergenceOptions struct {
+	// MaxBytes is the most bytes of the region compared, from its start. If it's zero it's 64 MiB.
+	MaxBytes uint64
+	// ChunkSize is the amount of memory compared at once. If it's zero DefaultBufferSize is used.
+	ChunkSize uint
+}
+
+// DivergentRange is a range of memory whose bytes aren't the ones read from the file it maps.
+type DivergentRange struct {
+	Address uintptr `json:"address"`
+	Size    uint    `json:"size"`
+}
+
+// Divergence is the result of CompareMappedFile.
+type Divergence struct {
+	Pid    int                    `json:"pid"`
+	Region memaccess.MemoryRegion `json:"region"`
+	Path   string                 `json:"path"`
+	// Dirty is the memory of the mapping that smaps counts as dirty, shared or private, which hasn't been written
+	// back to the file yet. The memory of a mapping without dirty pages isn't compared.
+	Dirty uint64 `json:"dirty"`
+	// Compared is the amount of bytes compared, and Truncated is true if DivergenceOptions.MaxBytes stopped the
+	// comparison before the end of the region.
+	Compared  uint64 `json:"compared"`
+	Truncated bool   `json:"truncated,omitempty"`
+	// Ranges are the runs of bytes that differ, sorted by address.
+	Ranges []DivergentRange `json:"ranges,omitempty"`
+}
+
+// readMappedFile reads the memory at address from the file of m. It's a variable so tests can make the file diverge.
+var readMappedFile = (*fileMapping).read
+
+// CompareMappedFile compares the memory of the shared file backed mapping of p holding address with the contents
+// read from its file, and reports the ranges that differ. It tells if reading the file is the same as reading the
+// memory, which PreferFileReads assumes. The smaps counters of the mapping are read first, and only the mappings
+// with dirty pages are compared. It's only implemented on Linux.
+//
+// On a local filesystem the dirty pages of a shared mapping are the page cache of the file, which is what reading it
+// returns even before they are written back, so they don't diverge. They do on the filesystems whose reads don't go
+// through the pages the process maps, like some network and FUSE filesystems.
+func CompareMappedFile(p process.Process, address uintptr, opts DivergenceOptions) (divergence Divergence,
+	harderror error, softerrors []error) {
+
+	if opts.MaxBytes == 0 {
+		opts.MaxBytes = 64 << 20
+	}
+	if opts.ChunkSize == 0 {
+		opts.ChunkSize = DefaultBufferSize
+	}
+
+	m, region, dirty, harderror := sharedFileMapping(p, address)
+	if harderror != nil {
+		return divergence, harderror, nil
+	}
+	defer m.close()
+	divergence = Divergence{Pid: p.Pid(), Region: region, Path: m.path, Dirty: dirty}
+	if dirty == 0 {
+		return divergence, nil, nil
+	}
+
+	b := processBackend(p)
+	memory := make([]byte, opts.ChunkSize)
+	file := make([]byte, opts.ChunkSize)
+	for start := m.start; start < m.end; start += uintptr(len(memory)) {
+		if divergence.Compared >= opts.MaxBytes {
+			divergence.Truncated = true
+			break
+		}
+		n := uint64(m.end - start)
+		if n > uint64(opts.ChunkSize) {
+			n = uint64(opts.ChunkSize)
+		}
+		if n > opts.MaxBytes-divergence.Compared {
+			n = opts.MaxBytes - divergence.Compared
+		}
+		memory, file = memory[:n], file[:n]
+
+		harderror, serrs := b.ReadAt(start, memory)
+		softerrors = append(softerrors, serrs...)
+		if harderror != nil {
+			return common.Result(divergence, harderror, softerrors)
+		}
+		if err := readMappedFile(m, start, file); err != nil {
+			return common.Result(divergence, fmt.Errorf("Unable to read %s (%v)", m.path, err), softerrors)
+		}
+		divergence.Ranges = appendDivergentRanges(divergence.Ranges, start, memory, file)
+		divergence.Compared += n
+	}
+	return divergence, nil, softerrors
+}
+
+// appendDivergentRanges appends the runs of bytes of memory, read at address, that differ from file. A run that
+// continues the last one of ranges extends it.
+func appendDivergentRanges(ranges []DivergentRange, address uintptr, memory []byte, file []byte) []DivergentRange {
+	for i := 0; i < len(memory); i++ {
+		if memory[i] == file[i] {
+			continue
+		}
+		at := address + uintptr(i)
+		if last := len(ranges) - 1; last >= 0 && ranges[last].Address+uintptr(ranges[last].Size) == at {
+			ranges[last].Size++
+		} else {
+			ranges = append(ranges, DivergentRange{Address: at, Size: 1})
+		}
+	}
+	return ranges
+}
+
+// withoutDivergent leaves out of mappings the ones that overlap a divergent range of the divergences of their
+// process, so their memory is read from the process.
+func withoutDivergent(mappings []*fileMapping, divergences []Divergence) []*fileMapping {
+	kept := mappings[:0]
+	for _, m := range mappings {
+		diverges := false
+		for _, d := range divergences {
+			for _, r := range d.Ranges {
+				if d.Pid == m.pid && r.Address < m.end && r.Address+uintptr(r.Size) > m.start {
+					diverges = true
+				}
+			}
+		}
+		if diverges {
+			m.close()
+		} else {
+			kept = append(kept, m)
+		}
+	}
+	return kept
+}
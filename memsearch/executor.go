@@ -0,0 +1,248 @@
+package memsearch
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/polyverse/masche/memaccess"
+)
+
+// Priority is the scheduling class of a scan run by an Executor.
+type Priority string
+
+const (
+	// Background scans, like sweeps, get a worker when no Interactive scan is waiting for one. It's the priority of
+	// scans that don't set one.
+	Background Priority = "background"
+	// Interactive scans, like a FindAll someone is waiting for, get the next worker that is free, before any
+	// Background scan scans its next region.
+	Interactive Priority = "interactive"
+)
+
+// priorities are the classes in the order their scans get workers.
+var priorities = []Priority{Interactive, Background}
+
+// ExecutorOptions configure an Executor.
+type ExecutorOptions struct {
+	// Workers is the amount of regions scanned at once by all the scans of the Executor. If it's zero it's the amount
+	// of CPUs.
+	Workers int
+	// BytesPerSecond, if not zero, bounds the rate at which the scans read memory: a region isn't started until the
+	// bytes of the regions scanned before it are paid for at that rate. A single region can still be read faster.
+	BytesPerSecond uint64
+	// OnRegion, if not nil, is called after each region is scanned, from the goroutine of the scan. It must be quick,
+	// as the scan waits for it.
+	OnRegion func(metrics RegionMetrics)
+}
+
+// JobStats are the work done by a scan run by an Executor.
+type JobStats struct {
+	// Job is SearchOptions.Job, or the pid of the scanned process if it's empty.
+	Job      string    `json:"job"`
+	Priority Priority  `json:"priority"`
+	Started  time.Time `json:"started"`
+	Regions  int       `json:"regions"`
+	Bytes    uint64    `json:"bytes"`
+	// Waited is the time the scan waited for a worker.
+	Waited time.Duration `json:"waited"`
+	// Throughput is the amount of bytes scanned per second since the scan started.
+	Throughput float64 `json:"throughput"`
+}
+
+// RegionMetrics are given to ExecutorOptions.OnRegion after each region is scanned.
+type RegionMetrics struct {
+	Job    JobStats               `json:"job"`
+	Region memaccess.MemoryRegion `json:"region"`
+	// Waited is the time the scan waited for a worker to scan the region.
+	Waited time.Duration `json:"waited"`
+	// QueueDepth is the amount of scans waiting for a worker once the region was scanned.
+	QueueDepth int `json:"queueDepth"`
+}
+
+// ExecutorStats are the state of an Executor.
+type ExecutorStats struct {
+	Workers int `json:"workers"`
+	// Busy is the amount of workers scanning a region.
+	Busy int `json:"busy"`
+	// QueueDepth is the amount of scans waiting for a worker.
+	QueueDepth int `json:"queueDepth"`
+	// Jobs are the scans running, sorted by the time they started.
+	Jobs []JobStats `json:"jobs"`
+}
+
+// Executor schedules the regions of the scans given to it in SearchOptions.Executor, so many scans running at once,
+// like the ones triggered by a watcher and a scheduled sweep, share a bounded amount of workers instead of
+// oversubscribing the host. A scan waits for a worker before each region, and the workers that get free are given to
+// the Interactive scans first, and then round-robin to the scans that waited the longest, so a scan of a large process
+// doesn't starve the rest.
+//
+// An Executor is safe for concurrent use. A nil *Executor doesn't schedule scans: they run as soon as they're
+// started, as many at once as there are.
+type Executor struct {
+	opts ExecutorOptions
+
+	mu      sync.Mutex
+	busy    int
+	waiting map[Priority][]*executorJob
+	jobs    map[*executorJob]bool
+	// nextStart is when the bytes scanned so far are paid for, if there's a BytesPerSecond.
+	nextStart time.Time
+}
+
+// SharedExecutor is an Executor with a worker per CPU, for the scans of a program to share.
+var SharedExecutor = NewExecutor(ExecutorOptions{})
+
+// NewExecutor creates an Executor.
+func NewExecutor(opts ExecutorOptions) *Executor {
+	if opts.Workers <= 0 {
+		opts.Workers = runtime.NumCPU()
+	}
+	return &Executor{opts: opts, waiting: make(map[Priority][]*executorJob), jobs: make(map[*executorJob]bool)}
+}
+
+// executorJob is a scan run by an Executor.
+type executorJob struct {
+	stats JobStats
+	// grant receives a value when the job is given a worker.
+	grant chan struct{}
+}
+
+// Stats returns the state of e.
+func (e *Executor) Stats() ExecutorStats {
+	if e == nil {
+		return ExecutorStats{}
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	stats := ExecutorStats{Workers: e.opts.Workers, Busy: e.busy, QueueDepth: e.queueDepth()}
+	for job := range e.jobs {
+		stats.Jobs = append(stats.Jobs, job.snapshot())
+	}
+	sort.Slice(stats.Jobs, func(i, j int) bool { return stats.Jobs[i].Started.Before(stats.Jobs[j].Started) })
+	return stats
+}
+
+// start registers a scan of b, which must call finish when it's done.
+func (e *Executor) start(b memaccess.MemoryBackend, opts SearchOptions) *executorJob {
+	if e == nil {
+		return nil
+	}
+	job := &executorJob{stats: JobStats{Job: opts.Job, Priority: opts.Priority, Started: time.Now()},
+		grant: make(chan struct{}, 1)}
+	if job.stats.Job == "" {
+		job.stats.Job = fmt.Sprint(b.Info().Pid)
+	}
+	if job.stats.Priority == "" {
+		job.stats.Priority = Background
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.jobs[job] = true
+	return job
+}
+
+// finish unregisters job, once its scan is done.
+func (e *Executor) finish(job *executorJob) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.jobs, job)
+	e.dispatch()
+}
+
+// acquire waits for a worker for job, and then for the bandwidth to be paid for. It returns the time it waited.
+func (e *Executor) acquire(job *executorJob) time.Duration {
+	if e == nil {
+		return 0
+	}
+	start := time.Now()
+	e.mu.Lock()
+	e.waiting[job.stats.Priority] = append(e.waiting[job.stats.Priority], job)
+	e.dispatch()
+	e.mu.Unlock()
+	<-job.grant
+
+	e.mu.Lock()
+	paidAt := e.nextStart
+	e.mu.Unlock()
+	if wait := time.Until(paidAt); wait > 0 {
+		time.Sleep(wait)
+	}
+	waited := time.Since(start)
+
+	e.mu.Lock()
+	job.stats.Waited += waited
+	e.mu.Unlock()
+	return waited
+}
+
+// release gives back the worker of job, which scanned bytes of region after waiting for the worker. If it's not the
+// last region of the scan the worker is given away when the scan asks for the next one, so it queues behind the scans
+// already waiting, and not behind the ones of lower priority.
+func (e *Executor) release(job *executorJob, region memaccess.MemoryRegion, bytes uint64, waited time.Duration,
+	last bool) {
+
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	job.stats.Regions++
+	job.stats.Bytes += bytes
+	if e.opts.BytesPerSecond != 0 {
+		now := time.Now()
+		if e.nextStart.Before(now) {
+			e.nextStart = now
+		}
+		e.nextStart = e.nextStart.Add(time.Duration(float64(bytes) / float64(e.opts.BytesPerSecond) *
+			float64(time.Second)))
+	}
+	e.busy--
+	if last {
+		e.dispatch()
+	}
+	metrics := RegionMetrics{Job: job.snapshot(), Region: region, Waited: waited, QueueDepth: e.queueDepth()}
+	e.mu.Unlock()
+
+	if e.opts.OnRegion != nil {
+		e.opts.OnRegion(metrics)
+	}
+}
+
+// dispatch gives the free workers to the jobs waiting, in order of priority and then of arrival. A job that scanned
+// a region waits behind the ones that were already waiting, which makes jobs of the same priority take turns.
+// e.mu must be held.
+func (e *Executor) dispatch() {
+	for _, priority := range priorities {
+		for e.busy < e.opts.Workers && len(e.waiting[priority]) > 0 {
+			job := e.waiting[priority][0]
+			e.waiting[priority] = e.waiting[priority][1:]
+			e.busy++
+			job.grant <- struct{}{}
+		}
+	}
+}
+
+// queueDepth returns the amount of jobs waiting for a worker. e.mu must be held.
+func (e *Executor) queueDepth() int {
+	depth := 0
+	for _, jobs := range e.waiting {
+		depth += len(jobs)
+	}
+	return depth
+}
+
+// snapshot returns the stats of job. The mutex of its Executor must be held.
+func (job *executorJob) snapshot() JobStats {
+	stats := job.stats
+	if elapsed := time.Since(stats.Started).Seconds(); elapsed > 0 {
+		stats.Throughput = float64(stats.Bytes) / elapsed
+	}
+	return stats
+}
@@ -0,0 +1,180 @@
+package memsearch
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/polyverse/masche/memaccess"
+)
+
+// executorBackend returns a backend of process pid with the given amount of small regions.
+func executorBackend(t *testing.T, pid int, regions int) memaccess.MemoryBackend {
+	var segments []memaccess.Segment
+	for i := 0; i < regions; i++ {
+		segments = append(segments, memaccess.Segment{Region: memaccess.MemoryRegion{
+			Address: uintptr(0x10000 * (i + 1)), Size: 0x100, Access: memaccess.Readable}, Data: make([]byte, 0x100)})
+	}
+	b, err := memaccess.NewStaticBackend(memaccess.BackendInfo{Kind: "static", Pid: pid}, segments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// executorRun records the order in which the regions of the scans run by an executor are scanned.
+type executorRun struct {
+	t        *testing.T
+	executor *Executor
+	wg       sync.WaitGroup
+
+	mu    sync.Mutex
+	order []string
+}
+
+// scan starts a scan named job of regions regions. If gate isn't nil the scan waits for it to be closed when it
+// starts scanning its first region, holding its worker.
+func (r *executorRun) scan(job string, priority Priority, regions int, gate chan struct{}) {
+	b := executorBackend(r.t, len(job), regions)
+	first := true
+	opts := SearchOptions{Executor: r.executor, Priority: priority, Job: job,
+		Checkpoint: func(cursor Cursor) bool {
+			r.mu.Lock()
+			r.order = append(r.order, job)
+			r.mu.Unlock()
+			if first && gate != nil {
+				<-gate
+			}
+			first = false
+			return true
+		}}
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		if _, _, err, _ := FindAllIn(b, 0, []Pattern{{Bytes: []byte("x")}}, opts); err != nil {
+			r.t.Error(err)
+		}
+	}()
+}
+
+// waitForQueue waits until depth scans are waiting for a worker.
+func (r *executorRun) waitForQueue(depth int) {
+	r.waitFor(func(stats ExecutorStats) bool { return stats.QueueDepth == depth })
+}
+
+// waitForBusy waits until busy workers are scanning a region.
+func (r *executorRun) waitForBusy(busy int) {
+	r.waitFor(func(stats ExecutorStats) bool { return stats.Busy == busy })
+}
+
+// waitForOrder waits until n regions were scanned, and returns the scans they belong to.
+func (r *executorRun) waitForOrder(n int) []string {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		r.mu.Lock()
+		order := append([]string(nil), r.order...)
+		r.mu.Unlock()
+		if len(order) >= n {
+			return order
+		}
+	}
+	r.t.Fatalf("Timed out waiting for %d regions to be scanned", n)
+	return nil
+}
+
+func (r *executorRun) waitFor(condition func(stats ExecutorStats) bool) {
+	for deadline := time.Now().Add(5 * time.Second); !condition(r.executor.Stats()); {
+		if time.Now().After(deadline) {
+			r.t.Fatalf("Timed out waiting for the executor: %+v", r.executor.Stats())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Two scans sharing the only free worker of a two worker executor take turns, region by region, and an interactive
+// scan takes the worker before the background scans scan their next region.
+func TestExecutor(t *testing.T) {
+	var metrics []RegionMetrics
+	var metricsMu sync.Mutex
+	executor := NewExecutor(ExecutorOptions{Workers: 2, OnRegion: func(m RegionMetrics) {
+		metricsMu.Lock()
+		metrics = append(metrics, m)
+		metricsMu.Unlock()
+	}})
+
+	// The blocker holds one of the workers until the end of the test.
+	blocker := make(chan struct{})
+	r := &executorRun{t: t, executor: executor}
+	r.scan("blocker", Background, 1, blocker)
+	r.waitForBusy(1)
+	gate := make(chan struct{})
+	r.scan("a", Background, 3, gate)
+	r.waitForBusy(2)
+	r.scan("bb", Background, 3, nil)
+	r.waitForQueue(1)
+	close(gate)
+
+	// a scans its first region while bb waits, and then they take turns.
+	expected := []string{"blocker", "a", "bb", "a", "bb", "a", "bb"}
+	if order := r.waitForOrder(len(expected)); !reflect.DeepEqual(order, expected) {
+		t.Errorf("Scanned %v, expected %v", order, expected)
+	}
+
+	// An interactive scan queued after a background one is scanned first, entirely.
+	r.mu.Lock()
+	r.order = nil
+	r.mu.Unlock()
+	gate = make(chan struct{})
+	r.scan("c", Background, 3, gate)
+	r.waitForBusy(2)
+	r.scan("dd", Background, 2, nil)
+	r.waitForQueue(1)
+	r.scan("iii", Interactive, 3, nil)
+	r.waitForQueue(2)
+	stats := executor.Stats()
+	if stats.Workers != 2 || stats.Busy != 2 || len(stats.Jobs) != 4 || stats.Jobs[0].Job != "blocker" {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+	close(gate)
+
+	// c scans its first region, then iii all of its regions, and then dd, which was waiting, and c take turns.
+	expected = []string{"c", "iii", "iii", "iii", "dd", "c", "dd", "c"}
+	if order := r.waitForOrder(len(expected)); !reflect.DeepEqual(order, expected) {
+		t.Errorf("Scanned %v, expected %v", order, expected)
+	}
+	close(blocker)
+	r.wg.Wait()
+
+	if len(metrics) != 1+6+3+2+3 {
+		t.Errorf("Got metrics of %d regions", len(metrics))
+	}
+	for _, m := range metrics {
+		if m.Job.Job == "a" && m.Job.Regions == 3 && (m.Job.Bytes != 0x300 || m.Job.Throughput <= 0) {
+			t.Errorf("Unexpected metrics %+v", m)
+		}
+	}
+	if stats := executor.Stats(); stats.Busy != 0 || stats.QueueDepth != 0 || len(stats.Jobs) != 0 {
+		t.Errorf("Unexpected stats once the scans finished %+v", stats)
+	}
+}
+
+func TestExecutorBandwidth(t *testing.T) {
+	executor := NewExecutor(ExecutorOptions{Workers: 1, BytesPerSecond: 0x100 * 20})
+	start := time.Now()
+	_, _, err, _ := FindAllIn(executorBackend(t, 1, 5), 0, []Pattern{{Bytes: []byte("x")}},
+		SearchOptions{Executor: executor})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The last 4 regions wait for the ones before them to be paid for, 50ms each.
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("Scanning 5 regions took %v", elapsed)
+	}
+
+	_, _, err, _ = FindAllIn(executorBackend(t, 1, 1), 0, []Pattern{{Bytes: []byte("x")}},
+		SearchOptions{Executor: executor, Priority: "urgent"})
+	if err == nil {
+		t.Error("An unknown priority should fail")
+	}
+}
@@ -0,0 +1,167 @@
+package memsearch
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/polyverse/masche/memaccess"
+)
+
+// RegionSource tells where the memory of a region was read from. They are only reported when
+// SearchOptions.PreferFileReads is set.
+type RegionSource struct {
+	Region       memaccess.MemoryRegion `json:"region"`
+	FileBytes    uint64                 `json:"fileBytes"`
+	ProcessBytes uint64                 `json:"processBytes"`
+	// Resolution tells how the path of the file the region was read from was resolved, if it was.
+	Resolution PathResolution `json:"resolution,omitempty"`
+}
+
+// PathResolution tells how the path of a mapped file was resolved to open it.
+type PathResolution string
+
+const (
+	// ResolvedInNamespace is a path resolved in the root of the process, /proc/<pid>/root, so it's the file the process
+	// sees when it's in a container or chroot.
+	ResolvedInNamespace PathResolution = "namespace"
+	// ResolvedOnHost is a path resolved in the root of the scanner, because the root of the process couldn't be
+	// traversed or the file wasn't there, as happens in chroots, whose maps file has the paths we see. The file is
+	// still only read if it's the one that is mapped.
+	ResolvedOnHost PathResolution = "host"
+)
+
+// processBackend returns the backend that reads the memory of a process. It's a variable so tests can deny access to
+// it.
+var processBackend = memaccess.ProcessBackend
+
+// fileMapping is a range of memory whose contents are the same as the file it maps.
+type fileMapping struct {
+	// pid is the process that maps the file, whose root its path is resolved in.
+	pid        int
+	start, end uintptr
+	path       string
+	offset     uint64
+	// dev and inode identify the mapped file, dev being its major and minor numbers.
+	dev, inode uint64
+
+	// file is opened the first time it's read, and then kept open until the scan ends. resolution tells how its path
+	// was resolved.
+	file       *os.File
+	size       int64
+	resolution PathResolution
+	// err is set if the file can't be read, so it isn't tried again.
+	err error
+}
+
+// read reads the memory starting at address from the mapped file. The last page of a mapping can extend past the end
+// of the file, and it's filled with zeroes in memory.
+func (m *fileMapping) read(address uintptr, buf []byte) error {
+	if m.err != nil {
+		return m.err
+	}
+	if m.file == nil {
+		if m.file, m.size, m.resolution, m.err = openMappedFile(m); m.err != nil {
+			return m.err
+		}
+		// Unmodified mappings are read from start to end, like the file, so the kernel can read ahead. It's only a hint.
+		memaccess.AdviseSequentialFile(m.file, int64(m.offset), int64(m.end-m.start))
+	}
+
+	pageSize := int64(os.Getpagesize())
+	offset := int64(m.offset) + int64(address-m.start)
+	if offset+int64(len(buf)) > (m.size+pageSize-1)/pageSize*pageSize {
+		return fmt.Errorf("%x is past the end of %s", address, m.path)
+	}
+
+	n := 0
+	if offset < m.size {
+		var err error
+		n, err = m.file.ReadAt(buf, offset)
+		if err != nil && int64(n) < m.size-offset {
+			return err
+		}
+	}
+	for i := n; i < len(buf); i++ {
+		buf[i] = 0
+	}
+	return nil
+}
+
+func (m *fileMapping) close() {
+	if m.file != nil {
+		m.file.Close()
+	}
+}
+
+// readFromFiles sets up the scanner to read the clean file backed memory of the process from its files.
+func (s *scanner) readFromFiles() error {
+	mappings, err := fileMappings(s.p)
+	if err != nil {
+		return err
+	}
+	mappings = withoutDivergent(mappings, s.opts.Divergences)
+	sort.Slice(mappings, func(i, j int) bool { return mappings[i].start < mappings[j].start })
+	s.files = mappings
+	s.recordSources = true
+	return nil
+}
+
+// read copies the memory of the process at address into buf, taking it from the mapped files when possible. If there
+// is an error, read is the amount of bytes at the start of buf that were read before it.
+func (s *scanner) read(address uintptr, buf []byte) (read int, harderror error, softerrors []error) {
+	for len(buf) > 0 {
+		n := uintptr(len(buf))
+
+		// The first mapping that ends after address.
+		i := sort.Search(len(s.files), func(i int) bool { return s.files[i].end > address })
+		if i < len(s.files) && s.files[i].start <= address {
+			m := s.files[i]
+			if m.end-address < n {
+				n = m.end - address
+			}
+
+			hadFailed := m.err != nil
+			err := readMappedFile(m, address, buf[:n])
+			if err == nil {
+				s.recordSource(uint64(n), 0, m.resolution)
+				read, address, buf = read+int(n), address+n, buf[n:]
+				continue
+			}
+			if !hadFailed {
+				softerrors = append(softerrors, fmt.Errorf("Reading %s from the process instead (%v)", m.path, err))
+			}
+		} else if i < len(s.files) && s.files[i].start-address < n {
+			n = s.files[i].start - address
+		}
+
+		harderror, serrs := s.b.ReadAt(address, buf[:n])
+		softerrors = append(softerrors, serrs...)
+		if harderror != nil {
+			return read, harderror, softerrors
+		}
+		s.recordSource(0, uint64(n), "")
+		read, address, buf = read+int(n), address+n, buf[n:]
+	}
+	return read, nil, softerrors
+}
+
+func (s *scanner) recordSource(fileBytes uint64, processBytes uint64, resolution PathResolution) {
+	if !s.recordSources {
+		return
+	}
+	source := &s.stats.Sources[len(s.stats.Sources)-1]
+	source.FileBytes += fileBytes
+	source.ProcessBytes += processBytes
+	if resolution != "" {
+		source.Resolution = resolution
+	}
+}
+
+func (s *scanner) close() {
+	for _, m := range s.files {
+		m.close()
+	}
+	s.opts.Budget.Release(s.reserved)
+	s.reserved = 0
+}
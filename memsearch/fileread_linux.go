@@ -0,0 +1,167 @@
+package memsearch
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"github.com/polyverse/masche/common"
+	"github.com/polyverse/masche/memaccess"
+	"github.com/polyverse/masche/process"
+)
+
+// fileMappings returns the readable file backed mappings of p whose memory is the same as their files.
+//
+// Private_Dirty is not enough to tell that, as it also counts the pages of the file that were modified in the page
+// cache and not written back yet. Those are still what reading the file returns. Modified copies of the pages of the
+// mapping are anonymous memory, either resident or swapped.
+func fileMappings(p process.Process) (mappings []*fileMapping, err error) {
+	entries, err := common.ReadSmapsFile(uint(p.Pid()))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if entry.Inode == 0 || !strings.HasPrefix(entry.Path, "/") || strings.HasSuffix(entry.Path, " (deleted)") ||
+			entry.Permissions[0] != 'r' || entry.Anonymous != 0 || entry.Swap != 0 {
+
+			continue
+		}
+
+		mappings = append(mappings, &fileMapping{
+			pid:    p.Pid(),
+			start:  entry.Start,
+			end:    entry.End,
+			path:   entry.Path,
+			offset: entry.Offset,
+			dev:    uint64(entry.DevMajor)<<32 | uint64(entry.DevMinor),
+			inode:  entry.Inode,
+		})
+	}
+	return mappings, nil
+}
+
+// sharedFileMapping returns the shared file backed mapping of p holding address, its region, and the amount of its
+// dirty memory.
+func sharedFileMapping(p process.Process, address uintptr) (m *fileMapping, region memaccess.MemoryRegion, dirty uint64,
+	err error) {
+
+	entries, err := common.ReadSmapsFile(uint(p.Pid()))
+	if err != nil {
+		return nil, region, 0, err
+	}
+	for _, entry := range entries {
+		if address < entry.Start || address >= entry.End {
+			continue
+		}
+		if entry.Inode == 0 || !strings.HasPrefix(entry.Path, "/") || strings.HasSuffix(entry.Path, " (deleted)") ||
+			entry.Permissions[0] != 'r' || entry.Permissions[3] != 's' {
+
+			return nil, region, 0, fmt.Errorf("The mapping at %x of process %d isn't a readable shared file mapping",
+				address, p.Pid())
+		}
+		m = &fileMapping{
+			pid:    p.Pid(),
+			start:  entry.Start,
+			end:    entry.End,
+			path:   entry.Path,
+			offset: entry.Offset,
+			dev:    uint64(entry.DevMajor)<<32 | uint64(entry.DevMinor),
+			inode:  entry.Inode,
+		}
+		region = memaccess.MemoryRegion{Address: entry.Start, Size: uint(entry.End - entry.Start),
+			Access: memaccess.Readable, Kind: entry.Path}
+		if entry.Permissions[1] == 'w' {
+			region.Access |= memaccess.Writable
+		}
+		if entry.Permissions[2] == 'x' {
+			region.Access |= memaccess.Executable
+		}
+		return m, region, entry.SharedDirty + entry.PrivateDirty, nil
+	}
+	return nil, region, 0, fmt.Errorf("No mapping of process %d holds %x", p.Pid(), address)
+}
+
+// openMappedFile opens the file of m, making sure it's the same file that is mapped. The paths of the maps file of a
+// process in another mount namespace, like a container, are the ones the process sees, so they are resolved in its
+// root first. The paths of a process in a chroot are the ones we see, so if the file isn't in the root of the
+// process, or the root can't be traversed, the path is resolved in our own root.
+func openMappedFile(m *fileMapping) (file *os.File, size int64, resolution PathResolution, err error) {
+	file, err = openInRoot(m.pid, m.path)
+	if err == nil {
+		if size, err = checkMappedFile(m, file); err == nil {
+			return file, size, ResolvedInNamespace, nil
+		}
+		file.Close()
+	}
+
+	file, hostErr := os.Open(m.path)
+	if hostErr != nil {
+		return nil, 0, "", fmt.Errorf("%v, and in our root: %v", err, hostErr)
+	}
+	if size, hostErr = checkMappedFile(m, file); hostErr != nil {
+		file.Close()
+		return nil, 0, "", fmt.Errorf("%v, and in our root: %v", err, hostErr)
+	}
+	return file, size, ResolvedOnHost, nil
+}
+
+// checkMappedFile returns the size of file if it's the file of m.
+func checkMappedFile(m *fileMapping, file *os.File) (size int64, err error) {
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	stat := info.Sys().(*syscall.Stat_t)
+	major, minor := common.DevMajorMinor(uint64(stat.Dev))
+	if stat.Ino != m.inode || uint64(major)<<32|uint64(minor) != m.dev {
+		return 0, fmt.Errorf("%s isn't the mapped file, it was replaced after being mapped", file.Name())
+	}
+	return info.Size(), nil
+}
+
+const (
+	// openat2 has the same number in every architecture.
+	sysOpenat2 = 437
+
+	// O_PATH isn't in the syscall package, it has the same value in every architecture Go supports.
+	oPath = 0x200000
+
+	resolveNoMagicLinks = 0x02
+	resolveInRoot       = 0x10
+)
+
+// openHow is the struct open_how of openat2.
+type openHow struct {
+	flags, mode, resolve uint64
+}
+
+// openInRoot opens path as the process with the given pid sees it, through /proc/<pid>/root. Symlinks and ".." can't
+// escape that root, as it's resolved with RESOLVE_IN_ROOT. On kernels without openat2 (before 5.6) the path is
+// resolved by the kernel from /proc/<pid>/root, where an absolute symlink escapes to our root; openMappedFile still
+// rejects any file other than the mapped one.
+func openInRoot(pid int, path string) (*os.File, error) {
+	rootPath := common.ProcFilePath(uint(pid), "root")
+	root, err := syscall.Open(rootPath, oPath|syscall.O_DIRECTORY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: rootPath, Err: err}
+	}
+	defer syscall.Close(root)
+
+	name, err := syscall.BytePtrFromString(strings.TrimPrefix(path, "/"))
+	if err != nil {
+		return nil, err
+	}
+	how := openHow{flags: syscall.O_RDONLY | syscall.O_CLOEXEC, resolve: resolveInRoot | resolveNoMagicLinks}
+	fd, _, errno := syscall.Syscall6(sysOpenat2, uintptr(root), uintptr(unsafe.Pointer(name)),
+		uintptr(unsafe.Pointer(&how)), unsafe.Sizeof(how), 0, 0)
+	if errno == syscall.ENOSYS {
+		return os.Open(filepath.Join(rootPath, path))
+	} else if errno != 0 {
+		return nil, &os.PathError{Op: "open", Path: filepath.Join(rootPath, path), Err: errno}
+	}
+	return os.NewFile(fd, filepath.Join(rootPath, path)), nil
+}
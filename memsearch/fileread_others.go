@@ -0,0 +1,25 @@
+// +build windows darwin
+
+package memsearch
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/polyverse/masche/memaccess"
+	"github.com/polyverse/masche/process"
+)
+
+func fileMappings(p process.Process) (mappings []*fileMapping, err error) {
+	return nil, fmt.Errorf("Reading memory from the mapped files is not implemented on this platform")
+}
+
+func sharedFileMapping(p process.Process, address uintptr) (m *fileMapping, region memaccess.MemoryRegion, dirty uint64,
+	err error) {
+
+	return nil, region, 0, fmt.Errorf("Reading memory from the mapped files is not implemented on this platform")
+}
+
+func openMappedFile(m *fileMapping) (file *os.File, size int64, resolution PathResolution, err error) {
+	return nil, 0, "", fmt.Errorf("Reading memory from the mapped files is not implemented on this platform")
+}
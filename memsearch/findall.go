@@ -0,0 +1,727 @@
+package memsearch
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/polyverse/masche/common"
+	"github.com/polyverse/masche/memaccess"
+	"github.com/polyverse/masche/process"
+)
+
+// Pattern is a sequence of bytes to search for with FindAll.
+type Pattern struct {
+	Bytes []byte
+
+	// Validator, if not nil, is called for every occurrence of the pattern and the occurrence is only reported if it
+	// returns true. This allows rejecting junk hits of short patterns without a second scan.
+	Validator Validator
+	// Context is the amount of bytes before and after each occurrence that are given to Validator.
+	Context int
+}
+
+// Validator decides if a raw occurrence of a pattern is a real match. surrounding contains the memory from
+// m.Address-Context to m.Address+len(m.Bytes)+Context, clipped to m.Region; use ContextOffset to locate the match in
+// it. p is nil when searching a MemoryBackend with FindAllIn.
+type Validator func(p process.Process, m Match, surrounding []byte) bool
+
+// ContextOffset returns the offset of m in the surrounding bytes given to a Validator with the given context size.
+func ContextOffset(m Match, context int) int {
+	if m.Address-m.Region.Address < uintptr(context) {
+		return int(m.Address - m.Region.Address)
+	}
+	return context
+}
+
+// Match is an occurrence of one of the patterns given to FindAll in the memory of a process.
+type Match struct {
+	Pid     int     `json:"pid"`
+	Address uintptr `json:"address"`
+	// Pattern is the index of the matched pattern in the slice given to FindAll.
+	Pattern int    `json:"pattern"`
+	Bytes   []byte `json:"bytes"`
+	// Region is the memory region containing the match.
+	Region memaccess.MemoryRegion `json:"region"`
+	// AfterCredentialChange is set on the matches found after the process changed its credentials, when annotating
+	// credential changes.
+	AfterCredentialChange bool `json:"afterCredentialChange,omitempty"`
+}
+
+func (m Match) String() string {
+	return fmt.Sprintf("Match[pid %d, pattern %d at %x in %v]", m.Pid, m.Pattern, m.Address, m.Region)
+}
+
+// ShortCircuit tells FindAll when it can stop looking for more occurrences of the patterns.
+type ShortCircuit int
+
+const (
+	// NoShortCircuit reports every occurrence of every pattern.
+	NoShortCircuit ShortCircuit = iota
+	// PerRegion reports only the first occurrence of each pattern in each region, and moves on to the next region
+	// as soon as all the patterns have been found in the current one.
+	PerRegion
+	// Global reports only the first occurrence of each pattern, and stops the whole scan as soon as all the
+	// patterns have been found.
+	Global
+)
+
+// Verification tells if the matches are read again after a scan, to drop the ones whose bytes changed while the memory
+// was being walked.
+type Verification int
+
+const (
+	// DefaultVerification is the default of each workflow: FindAll doesn't verify, to keep its latency low, and the
+	// report package does, to keep false positives out of its reports.
+	DefaultVerification Verification = iota
+	// Verify reads the bytes of every match again once the scan finished, with memaccess.ReadBatch, and drops the
+	// matches that don't have them anymore.
+	Verify
+	// NoVerification reports the matches as they were found.
+	NoVerification
+)
+
+func (v Verification) String() string {
+	switch v {
+	case DefaultVerification:
+		return "DefaultVerification"
+	case Verify:
+		return "Verify"
+	case NoVerification:
+		return "NoVerification"
+	}
+	return fmt.Sprintf("Verification(%d)", int(v))
+}
+
+// SearchOptions modifies the behaviour of FindAll. Its zero value is a sensible default.
+type SearchOptions struct {
+	ShortCircuit ShortCircuit
+
+	// BufferSize is the amount of memory read from the process at once. If it's zero DefaultBufferSize is used.
+	BufferSize uint
+
+	// PreferFileReads reads the file backed memory that the process hasn't modified from the mapped files,
+	// which is gentler on the process and works even when its memory can't be read. Memory whose file can't be read
+	// is read from the process, and ScanStats.Sources tells how each region was read.
+	PreferFileReads bool
+
+	// Divergences are the results of CompareMappedFile for the process, if any. With PreferFileReads, the mappings
+	// with divergent ranges are read from the process.
+	Divergences []Divergence
+
+	// Budget, if not nil, bounds the memory used by the scan. See MemoryBudget.
+	Budget *MemoryBudget
+
+	// Summary, if not nil, enables the HitSummary in the ScanStats.
+	Summary *SummaryOptions
+
+	// Impact enables the ImpactReport in the ScanStats. It costs two extra reads of the process stats and sampling
+	// the residency of its pages before and after the scan.
+	Impact bool
+
+	// Sampling, if not nil, reports only a sample of the matches, and counts all of them in ScanStats.Sampling. It
+	// can't be combined with a ShortCircuit.
+	Sampling *Sampling
+
+	// Credentials, if not nil, checks that the process doesn't change its credentials during the scan. Scans of a
+	// MemoryBackend don't check them.
+	Credentials *CredentialChecks
+
+	// Verification tells if the matches are read again once the scan finished. They are read from the memory, never
+	// from the files, and the matches that were read from the files aren't verified. Sampled occurrences that weren't
+	// reported aren't verified either.
+	Verification Verification
+
+	// Checkpoint, if not nil, is called with the position of the scan before each region and after each buffer is
+	// searched, so it can be resumed from there with ResumeFindAll if the program stops. If it returns false the scan
+	// is paused: it returns the matches found so far, and ScanStats.Paused is the cursor to resume it from.
+	Checkpoint func(cursor Cursor) (keepScanning bool)
+
+	// MaxDuration, if not zero, is the time the scan can take. It's checked after each buffer is searched, and once
+	// it elapsed the scan is paused where a Checkpoint could pause it: ScanStats.Partial is set, ScanStats.Coverage
+	// tells how much of each region was scanned, and ScanStats.Paused is the cursor to resume it from. The scan
+	// always searches at least one buffer, so resuming it always makes progress.
+	MaxDuration time.Duration
+
+	// Placement, if not nil, chooses the CPUs the scan of a process runs on, and ScanStats.Placement tells which ones
+	// they were. Scans of a MemoryBackend ignore it.
+	Placement *Placement
+
+	// Compiled, if not nil, are the patterns of the search compiled by Compile, so scans of the same patterns in many
+	// processes only compile them once. They must have the same bytes as the patterns given to the search. When it's
+	// nil each scan compiles its patterns.
+	Compiled *CompiledPatterns
+
+	// Executor, if not nil, schedules the regions of the scan with the other scans given to it, which takes a worker
+	// of the Executor to scan each region. Priority is the class of the scan, Background if it's empty, and Job names
+	// it in the stats of the Executor, the pid of the process if it's empty.
+	Executor *Executor
+	Priority Priority
+	Job      string
+
+	// SkipRegion, if not nil, is called with each readable region, and the regions it returns true for aren't
+	// scanned, like with SkipKernelMappings. Resumed scans must be given the same one.
+	SkipRegion func(region memaccess.MemoryRegion) bool
+
+	// resume is the cursor a resumed scan continues from.
+	resume *Cursor
+}
+
+// DefaultBufferSize is the buffer size FindAll uses when the options don't specify one.
+const DefaultBufferSize = uint(64 * 1024)
+
+// ScanStats describes the work done by FindAll.
+type ScanStats struct {
+	RegionsScanned int    `json:"regionsScanned"`
+	BytesScanned   uint64 `json:"bytesScanned"`
+	Matches        int    `json:"matches"`
+	// Rejected is the amount of occurrences that were discarded by their pattern's Validator.
+	Rejected int `json:"rejected"`
+	// StoppedEarly is true if a Global short circuit ended the scan before all the memory was read.
+	StoppedEarly bool `json:"stoppedEarly"`
+	// Impact is only set if it was enabled in the SearchOptions and it could be measured.
+	Impact *ImpactReport `json:"impact,omitempty"`
+	// BufferSize is the size of the read buffer used, which is smaller than the requested one if the MemoryBudget
+	// was short.
+	BufferSize uint `json:"bufferSize"`
+	// Summary is only set if it was enabled in the SearchOptions.
+	Summary *HitSummary `json:"summary,omitempty"`
+	// Sources are only set if PreferFileReads was enabled in the SearchOptions.
+	Sources []RegionSource `json:"sources,omitempty"`
+	// Occurrences is the amount of valid occurrences found, which is more than Matches when sampling.
+	Occurrences int `json:"occurrences"`
+	// Sampling counts the occurrences and the sampled matches of each pattern in each region. It's only set if
+	// Sampling was enabled in the SearchOptions.
+	Sampling []RegionOccurrences `json:"sampling,omitempty"`
+	// Unverified is the amount of matches dropped because their bytes changed or couldn't be read again after the
+	// scan, when verifying them.
+	Unverified int `json:"unverified"`
+	// CredentialChange is the change of the credentials of the process during the scan, if it was annotated.
+	CredentialChange *process.CredentialsError `json:"credentialChange,omitempty"`
+	// Paused is the cursor to resume the scan from, if the Checkpoint of the options paused it or MaxDuration
+	// elapsed.
+	Paused *Cursor `json:"paused,omitempty"`
+	// Partial is true if the scan was paused before it scanned all the memory, and Coverage then tells how much of
+	// each region it scanned.
+	Partial  bool             `json:"partial,omitempty"`
+	Coverage []RegionCoverage `json:"coverage,omitempty"`
+	// Resumed tells how a scan continued, if it was resumed with ResumeFindAll.
+	Resumed Disposition `json:"resumed,omitempty"`
+	// Placement tells where the scan ran, if the options had a Placement.
+	Placement *PlacementReport `json:"placement,omitempty"`
+}
+
+// FindAll finds the occurrences of patterns in the readable memory of p at or after address, and returns them sorted
+// by address and pattern index. Softerrors are sorted as common.SortSoftErrors does.
+//
+// Memory is scanned one region at a time, so occurrences spanning two regions are not found. Regions that can't be
+// read are reported as softerrors and skipped. If p replaces its image with exec(2) during the scan, it fails with a
+// *process.ExecError instead of mixing the matches of two programs.
+func FindAll(p process.Process, address uintptr, patterns []Pattern, opts SearchOptions) (matches []Match,
+	stats ScanStats, harderror error, softerrors []error) {
+
+	if harderror = checkSearch(patterns, opts); harderror != nil {
+		return nil, stats, harderror, nil
+	}
+
+	harderror, softerrors = checkAccess(p)
+	if harderror != nil && opts.PreferFileReads && errors.Is(harderror, process.ErrInsufficientPrivileges) {
+		// The files may still be readable.
+		softerrors = append(softerrors, harderror)
+		harderror = nil
+	}
+	if harderror != nil {
+		return nil, stats, harderror, softerrors
+	}
+
+	var impact *impactSampler
+	if opts.Impact {
+		var err error
+		impact, err = startImpact(p, address)
+		if err != nil {
+			softerrors = append(softerrors, fmt.Errorf("Unable to measure the impact of the scan: %v", err))
+		}
+	}
+
+	var placement *PlacementReport
+	if opts.Placement != nil {
+		report, restore, err := place(p.Pid(), *opts.Placement)
+		if err != nil {
+			softerrors = append(softerrors, fmt.Errorf("Unable to place the scan: %v", err))
+		} else {
+			placement = &report
+		}
+		if restore != nil {
+			defer restore()
+		}
+	}
+
+	matches, stats, harderror, serrs := findAll(processBackend(p), p, address, patterns, opts)
+	softerrors = append(softerrors, serrs...)
+	if harderror != nil {
+		return nil, stats, harderror, softerrors
+	}
+	stats.Placement = placement
+
+	if impact != nil {
+		report, err := impact.finish()
+		if err != nil {
+			softerrors = append(softerrors, fmt.Errorf("Unable to measure the impact of the scan: %v", err))
+		} else {
+			stats.Impact = &report
+		}
+	}
+
+	common.SortSoftErrors(softerrors)
+	return matches, stats, nil, softerrors
+}
+
+// FindAllIn works as FindAll, but it searches the memory of any MemoryBackend. The options that need a live process,
+// PreferFileReads, Impact and Placement, are ignored.
+func FindAllIn(b memaccess.MemoryBackend, address uintptr, patterns []Pattern, opts SearchOptions) (matches []Match,
+	stats ScanStats, harderror error, softerrors []error) {
+
+	if harderror = checkSearch(patterns, opts); harderror != nil {
+		return nil, stats, harderror, nil
+	}
+	opts.PreferFileReads, opts.Impact, opts.Placement = false, false, nil
+	return findAll(b, nil, address, patterns, opts)
+}
+
+func checkSearch(patterns []Pattern, opts SearchOptions) error {
+	if err := checkPatterns(patterns); err != nil {
+		return err
+	}
+	if opts.Compiled != nil && !opts.Compiled.compiles(patterns) {
+		return fmt.Errorf("The compiled patterns of the options aren't the patterns searched")
+	}
+	if opts.Priority != "" && opts.Priority != Background && opts.Priority != Interactive {
+		return fmt.Errorf("Unknown priority %q", opts.Priority)
+	}
+	if opts.Sampling != nil && opts.ShortCircuit != NoShortCircuit {
+		return fmt.Errorf("Sampling needs every occurrence, it can't be combined with a ShortCircuit")
+	}
+	if (opts.Checkpoint != nil || opts.MaxDuration != 0 || opts.resume != nil) &&
+		(opts.ShortCircuit != NoShortCircuit || opts.Sampling != nil || opts.Summary != nil) {
+
+		return fmt.Errorf("The state of short circuits, sampling and summaries isn't kept in cursors, they can't " +
+			"be combined with checkpoints nor a MaxDuration")
+	}
+	return nil
+}
+
+// findAll searches the memory of b. p is the process b reads, if any.
+func findAll(b memaccess.MemoryBackend, p process.Process, address uintptr, patterns []Pattern,
+	opts SearchOptions) (matches []Match, stats ScanStats, harderror error, softerrors []error) {
+
+	compiled := opts.Compiled
+	if compiled == nil {
+		if compiled, harderror = Compile(patterns); harderror != nil {
+			return nil, stats, harderror, nil
+		}
+	} else {
+		// The validators, which the compiled patterns don't need to have, are the ones given to the search.
+		compiled = &CompiledPatterns{patterns: patterns, maxLen: compiled.maxLen, finders: compiled.finders,
+			automaton: compiled.automaton}
+	}
+
+	s, harderror := newScanner(b, p, compiled, opts)
+	if harderror != nil {
+		return nil, stats, harderror, nil
+	}
+	defer s.close()
+	if opts.PreferFileReads {
+		if err := s.readFromFiles(); err != nil {
+			softerrors = append(softerrors, fmt.Errorf("Reading all the memory from the process (%v)", err))
+		}
+	}
+
+	watch := memaccess.WatchImage(b)
+	creds, serrs := watchCredentials(p, opts.Credentials)
+	softerrors = append(softerrors, serrs...)
+	regions, harderror, serrs := readableRegions(b, address)
+	softerrors = append(softerrors, serrs...)
+	if harderror != nil {
+		return nil, stats, harderror, softerrors
+	}
+	if opts.SkipRegion != nil {
+		kept := regions[:0]
+		for _, region := range regions {
+			if !opts.SkipRegion(region) {
+				kept = append(kept, region)
+			}
+		}
+		regions = kept
+	}
+	if opts.resume != nil {
+		disposition := s.resumeRegions(regions)
+		s.stats.Resumed = disposition
+	}
+	job := opts.Executor.start(b, opts)
+	defer opts.Executor.finish(job)
+	for i, region := range regions {
+		if err := watch.Check(); err != nil {
+			return nil, ScanStats{}, err, append(softerrors, s.softerrors...)
+		}
+		if err := s.credentialsChanged(creds.check(false)); err != nil {
+			return nil, ScanStats{}, err, append(softerrors, s.softerrors...)
+		}
+		waited := opts.Executor.acquire(job)
+		scanned := s.stats.BytesScanned
+		s.scanRegion(region, address)
+		opts.Executor.release(job, region, s.stats.BytesScanned-scanned, waited, i == len(regions)-1)
+		if s.err != nil {
+			return nil, ScanStats{}, s.err, append(softerrors, s.softerrors...)
+		}
+		if s.paused != nil {
+			s.stats.Paused = s.paused
+			s.stats.Partial = true
+			s.stats.Coverage = coverage(regions, i, s.paused.Address())
+			break
+		}
+		if s.allFound() && opts.ShortCircuit == Global {
+			s.stats.StoppedEarly = true
+			break
+		}
+	}
+	softerrors = append(softerrors, s.softerrors...)
+	if err := watch.Check(); err != nil {
+		return nil, ScanStats{}, err, softerrors
+	}
+	if err := s.credentialsChanged(creds.check(true)); err != nil {
+		return nil, ScanStats{}, err, softerrors
+	}
+
+	if opts.Verification == Verify {
+		softerrors = append(softerrors, s.verify()...)
+	}
+	s.stats.Matches = s.resumedMatches + len(s.matches)
+	sortMatches(s.matches)
+	common.SortSoftErrors(softerrors)
+	if opts.Summary != nil {
+		summary := Summarize(s.matches, *opts.Summary)
+		s.stats.Summary = &summary
+	}
+	return s.matches, s.stats, nil, softerrors
+}
+
+// SkipKernelMappings is a SearchOptions.SkipRegion that skips the pages the kernel maps in every process, like
+// [vvar], whose contents are the kernel's and which can't always be read.
+func SkipKernelMappings(region memaccess.MemoryRegion) bool {
+	return strings.HasPrefix(region.Kind, "[vvar") || region.Kind == "[vsyscall]"
+}
+
+// readableRegions returns the readable regions of b at or after address. A region containing address is clipped to
+// start at it.
+func readableRegions(b memaccess.MemoryBackend, address uintptr) (regions []memaccess.MemoryRegion,
+	harderror error, softerrors []error) {
+
+	all, harderror, softerrors := b.Regions()
+	if harderror != nil {
+		return nil, harderror, softerrors
+	}
+	for _, region := range all {
+		end := region.Address + uintptr(region.Size)
+		if region.Access&memaccess.Readable == 0 || end <= address {
+			continue
+		}
+		if region.Address < address {
+			region.Size -= uint(address - region.Address)
+			region.Address = address
+		}
+		regions = append(regions, region)
+	}
+	// Backends should list their regions in order, but the short circuits and the order of the matches must not
+	// depend on it.
+	sort.SliceStable(regions, func(i, j int) bool { return regions[i].Address < regions[j].Address })
+	return regions, nil, softerrors
+}
+
+// scanner holds the mutable state of a single FindAll call.
+type scanner struct {
+	b memaccess.MemoryBackend
+	// p is the process read by b, if any.
+	p        process.Process
+	compiled *CompiledPatterns
+	opts     SearchOptions
+	buf      []byte
+	overlap  int
+
+	// found tells which patterns were already found, it's only used when short circuiting.
+	found      []bool
+	foundCount int
+
+	matches    []Match
+	stats      ScanStats
+	softerrors []error
+
+	// reserved is the memory reserved from the budget, and err is set when it runs out.
+	reserved uint64
+	err      error
+
+	// files are the mappings read from files instead of the process when PreferFileReads is set.
+	files         []*fileMapping
+	recordSources bool
+
+	// sampler is only set when Sampling is enabled.
+	sampler *sampler
+
+	// paused is the cursor the scan was paused at, if it was. carry is the address a resumed region is resumed from,
+	// and resumedMatches the amount of matches found before resuming.
+	paused         *Cursor
+	carry          uintptr
+	resumedMatches int
+
+	// deadline is when the MaxDuration of the options elapses, if it has one. progressed tells if a buffer was
+	// searched, which the scan does before it can be paused by the deadline.
+	deadline   time.Time
+	progressed bool
+}
+
+// newScanner makes a scanner for the compiled patterns. A scanner without patterns only reads memory.
+func newScanner(b memaccess.MemoryBackend, p process.Process, compiled *CompiledPatterns,
+	opts SearchOptions) (*scanner, error) {
+
+	if compiled == nil {
+		compiled = &CompiledPatterns{maxLen: 1}
+	}
+	bufSize := opts.BufferSize
+	if bufSize == 0 {
+		bufSize = DefaultBufferSize
+	}
+
+	// Shrink the buffer until it fits in the budget.
+	overlap := compiled.maxLen - 1
+	bufSize, err := opts.Budget.ReserveBuffer(bufSize, uint(overlap))
+	if err != nil {
+		return nil, err
+	}
+
+	s := &scanner{
+		b:        b,
+		p:        p,
+		compiled: compiled,
+		opts:     opts,
+		buf:      make([]byte, overlap+int(bufSize)),
+		overlap:  overlap,
+		found:    make([]bool, compiled.Len()),
+		stats:    ScanStats{BufferSize: bufSize},
+		reserved: uint64(overlap) + uint64(bufSize),
+	}
+	if opts.Sampling != nil {
+		s.sampler = newSampler(*opts.Sampling, compiled.Len())
+	}
+	if opts.MaxDuration != 0 {
+		s.deadline = time.Now().Add(opts.MaxDuration)
+	}
+	return s, nil
+}
+
+func (s *scanner) allFound() bool {
+	return s.foundCount == s.compiled.Len()
+}
+
+func (s *scanner) resetFound() {
+	for i := range s.found {
+		s.found[i] = false
+	}
+	s.foundCount = 0
+}
+
+// scanRegion searches the patterns in region, starting at address if the region contains it. Consecutive reads
+// overlap by the length of the longest pattern minus one so that no occurrence is lost between them.
+func (s *scanner) scanRegion(region memaccess.MemoryRegion, address uintptr) {
+	if s.opts.ShortCircuit == PerRegion {
+		s.resetFound()
+	}
+	if s.sampler != nil {
+		s.sampler.startRegion(region)
+		defer s.finishSampling()
+	}
+
+	start := region.Address
+	if start < address {
+		start = address
+	}
+	end := region.Address + uintptr(region.Size)
+	chunkSize := uintptr(len(s.buf) - s.overlap)
+
+	carried := 0
+	if s.carry != 0 && s.carry == start {
+		// The region was counted before the scan was paused.
+		carried = s.readCarry(region, start)
+		s.carry = 0
+	} else {
+		if !s.checkpoint(region, start) {
+			return
+		}
+		s.stats.RegionsScanned++
+		if s.recordSources {
+			s.stats.Sources = append(s.stats.Sources, RegionSource{Region: region})
+		}
+	}
+	for addr := start; addr < end; {
+		n := chunkSize
+		if end-addr < n {
+			n = end - addr
+		}
+
+		buf := s.buf[:carried+int(n)]
+		read, harderror, serrs := s.read(addr, buf[carried:])
+		s.softerrors = append(s.softerrors, serrs...)
+		if harderror != nil {
+			// Search what could be read before the error.
+			if read > 0 {
+				s.stats.BytesScanned += uint64(read)
+				s.progressed = true
+				s.searchBuffer(region, addr-uintptr(carried), buf[:carried+read], carried)
+			}
+			s.softerrors = append(s.softerrors, &common.LocatedError{Pid: s.b.Info().Pid, Address: addr,
+				Err: fmt.Errorf("Skipping the rest of %v: %v", region, harderror)})
+			return
+		}
+		s.stats.BytesScanned += uint64(n)
+		s.progressed = true
+
+		s.searchBuffer(region, addr-uintptr(carried), buf, carried)
+		if s.err != nil || s.opts.ShortCircuit != NoShortCircuit && s.allFound() {
+			return
+		}
+
+		addr += n
+		carried = s.overlap
+		if carried > len(buf) {
+			carried = len(buf)
+		}
+		copy(s.buf, buf[len(buf)-carried:])
+		if addr < end && !s.checkpoint(region, addr) {
+			return
+		}
+	}
+}
+
+// credentialsChanged applies the CredentialPolicy to a change of credentials, if there's one. It returns the error
+// that aborts the scan.
+func (s *scanner) credentialsChanged(change *process.CredentialsError) error {
+	if change == nil {
+		return nil
+	}
+	if s.opts.Credentials.OnChange == AbortOnCredentialChange {
+		return change
+	}
+	s.stats.CredentialChange = change
+	return nil
+}
+
+// finishSampling adds the sample of the region just scanned to the matches, and its counts to the stats.
+func (s *scanner) finishSampling() {
+	matches, counts := s.sampler.finishRegion()
+	s.matches = append(s.matches, matches...)
+	s.stats.Sampling = append(s.stats.Sampling, counts...)
+}
+
+// searchBuffer looks for all the patterns in buf, which starts at bufAddress. The first carried bytes of buf were
+// already searched in the previous buffer, so occurrences fully contained in them are not reported again.
+func (s *scanner) searchBuffer(region memaccess.MemoryRegion, bufAddress uintptr, buf []byte, carried int) {
+	first := len(s.matches)
+	if s.err == nil {
+		s.compiled.search(buf, s.found, func(i int, index int) bool {
+			s.occurrence(region, bufAddress, buf, carried, i, index)
+			return s.err == nil
+		})
+	}
+	sortMatches(s.matches[first:])
+}
+
+// occurrence handles an occurrence of pattern i at index of buf, which starts at bufAddress.
+func (s *scanner) occurrence(region memaccess.MemoryRegion, bufAddress uintptr, buf []byte, carried int, i int,
+	index int) {
+
+	pattern := s.compiled.patterns[i]
+	if index+len(pattern.Bytes) <= carried {
+		return
+	}
+
+	m := Match{
+		Pid:                   s.b.Info().Pid,
+		Address:               bufAddress + uintptr(index),
+		Pattern:               i,
+		Bytes:                 append([]byte(nil), pattern.Bytes...),
+		Region:                region,
+		AfterCredentialChange: s.stats.CredentialChange != nil,
+	}
+	if !s.validate(pattern, m, bufAddress, buf) {
+		s.stats.Rejected++
+		return
+	}
+	s.stats.Occurrences++
+
+	report, keep := true, false
+	if s.sampler != nil {
+		var evicted *Match
+		report, keep, evicted = s.sampler.add(m)
+		if evicted != nil {
+			s.opts.Budget.Release(matchCost(*evicted))
+			s.reserved -= matchCost(*evicted)
+		}
+	}
+	if !report && !keep {
+		return
+	}
+	if !s.opts.Budget.Reserve(matchCost(m)) {
+		s.err = fmt.Errorf("Unable to store %v: %w", m, ErrMemoryBudgetExceeded)
+		return
+	}
+	s.reserved += matchCost(m)
+	if report {
+		s.matches = append(s.matches, m)
+	}
+
+	if s.opts.ShortCircuit != NoShortCircuit {
+		s.found[i] = true
+		s.foundCount++
+	}
+}
+
+// validate runs the pattern's Validator, if any, on m. The context is taken from buf, which starts at bufAddress,
+// when it contains it; otherwise it's read again from the process.
+func (s *scanner) validate(pattern Pattern, m Match, bufAddress uintptr, buf []byte) bool {
+	if pattern.Validator == nil {
+		return true
+	}
+
+	regionEnd := m.Region.Address + uintptr(m.Region.Size)
+	start := m.Address - uintptr(ContextOffset(m, pattern.Context))
+	end := m.Address + uintptr(len(m.Bytes)+pattern.Context)
+	if end > regionEnd || end < m.Address {
+		end = regionEnd
+	}
+
+	var surrounding []byte
+	if start >= bufAddress && end <= bufAddress+uintptr(len(buf)) {
+		surrounding = buf[start-bufAddress : end-bufAddress]
+	} else {
+		surrounding = make([]byte, end-start)
+		_, harderror, serrs := s.read(start, surrounding)
+		s.softerrors = append(s.softerrors, serrs...)
+		if harderror != nil {
+			s.softerrors = append(s.softerrors, &common.LocatedError{Pid: s.b.Info().Pid, Address: m.Address,
+				Err: fmt.Errorf("Unable to read the context of %v: %v", m, harderror)})
+			return false
+		}
+	}
+
+	return pattern.Validator(s.p, m, surrounding)
+}
+
+// sortMatches sorts matches by address, and then by pattern index.
+func sortMatches(matches []Match) {
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Address != matches[j].Address {
+			return matches[i].Address < matches[j].Address
+		}
+		return matches[i].Pattern < matches[j].Pattern
+	})
+}
@@ -0,0 +1,102 @@
+package memsearch
+
+import (
+	"bytes"
+)
+
+// byteRank estimates how common each byte is in the memory of a process, the higher the more common: zeros and
+// padding are the most, then spaces, text, by the frequency of its letters in English, the small integers and the
+// bytes of the pointers of amd64 processes, and the high bytes of binary data the least.
+var byteRank = func() (rank [256]uint8) {
+	for i := range rank {
+		switch {
+		case i == 0:
+			rank[i] = 255
+		case i == 0xff:
+			rank[i] = 220
+		case i == ' ':
+			rank[i] = 200
+		case bytes.IndexByte([]byte("etaoinshrdlu"), byte(i)) != -1:
+			rank[i] = 150
+		case i >= 'a' && i <= 'z':
+			rank[i] = 130
+		case i >= '0' && i <= '9':
+			rank[i] = 120
+		case i < 0x20, i == 0x7f, i == 0x55, i == 0x56:
+			rank[i] = 110
+		case i >= 'A' && i <= 'Z':
+			rank[i] = 100
+		case i < 0x80:
+			rank[i] = 80
+		default:
+			rank[i] = 40
+		}
+	}
+	return rank
+}()
+
+// commonRank is the rank from which a byte is too common to look for first, as most of the places it's found would
+// have to be checked.
+const commonRank = 200
+
+// finder finds a needle faster than bytes.Index when its first byte is common, like the zeros before the length of a
+// string, by looking first for its rarest byte with bytes.IndexByte, whose assembly compares many bytes at once on
+// amd64 and arm64, and checking the whole needle only where it's found. It finds the same occurrences as bytes.Index,
+// which it falls back to when the needle has no rare byte, or the rare byte turns out to be common in the memory
+// searched. It's immutable, so it can be shared by many scans.
+type finder struct {
+	needle []byte
+	// rare is the offset of the rarest byte of needle, or -1 if bytes.Index is used.
+	rare int
+}
+
+func newFinder(needle []byte) finder {
+	f := finder{needle: needle, rare: -1}
+	if len(needle) < 2 {
+		return f
+	}
+	rare := 0
+	for i, b := range needle {
+		if byteRank[b] < byteRank[needle[rare]] {
+			rare = i
+		}
+	}
+	// bytes.Index looks for the first byte itself.
+	if rare > 0 && byteRank[needle[rare]] < commonRank {
+		f.rare = rare
+	}
+	return f
+}
+
+// index returns the offset of the first occurrence of the needle in buf, or -1.
+func (f finder) index(buf []byte) int {
+	n := len(f.needle)
+	if f.rare < 0 || len(buf) < n {
+		return bytes.Index(buf, f.needle)
+	}
+
+	rare := f.needle[f.rare]
+	// The rare byte of the last place the needle fits is at last.
+	last := len(buf) - n + f.rare
+	misses := 0
+	for start := 0; start+f.rare <= last; {
+		i := bytes.IndexByte(buf[start+f.rare:last+1], rare)
+		if i == -1 {
+			return -1
+		}
+		start += i
+		if bytes.Equal(buf[start:start+n], f.needle) {
+			return start
+		}
+		start++
+		// As bytes.Index, give up on the rare byte once it has been found in too many wrong places.
+		misses++
+		if misses > 4+start>>4 {
+			if i := bytes.Index(buf[start:], f.needle); i != -1 {
+				return start + i
+			}
+			return -1
+		}
+	}
+	return -1
+}
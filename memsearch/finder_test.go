@@ -0,0 +1,171 @@
+package memsearch
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/polyverse/masche/memaccess"
+)
+
+// memoryCorpus returns size bytes that look like the memory of a process: pages of zeros, of text, of random binary
+// data, and of small integers and pointers.
+func memoryCorpus(r *rand.Rand, size int) []byte {
+	const text = "abcdefghijklmnopqrstuvwxyz    eeeetaoin"
+	buf := make([]byte, size)
+	for page := 0; page < size; page += 4096 {
+		end := page + 4096
+		if end > size {
+			end = size
+		}
+		switch r.Intn(4) {
+		case 1:
+			for i := page; i < end; i++ {
+				buf[i] = text[r.Intn(len(text))]
+			}
+		case 2:
+			r.Read(buf[page:end])
+		case 3:
+			for i := page; i+8 <= end; i += 8 {
+				buf[i] = byte(r.Intn(3))
+				buf[i+5] = 0x55
+			}
+		}
+	}
+	return buf
+}
+
+// finderNeedles returns needles for the corpus: pieces of it, which are found, and random words of alphabet, which
+// mostly aren't.
+func finderNeedles(r *rand.Rand, corpus []byte, alphabet string, count int) [][]byte {
+	var needles [][]byte
+	for i := 0; i < count; i++ {
+		n := 1 + r.Intn(24)
+		if i%2 == 0 {
+			start := r.Intn(len(corpus) - n)
+			needles = append(needles, corpus[start:start+n])
+			continue
+		}
+		needle := make([]byte, n)
+		for j := range needle {
+			needle[j] = alphabet[r.Intn(len(alphabet))]
+		}
+		needles = append(needles, needle)
+	}
+	return needles
+}
+
+// checkFinder compares the finder of needle with bytes.Index from every offset of buf.
+func checkFinder(t *testing.T, buf []byte, needle []byte) {
+	f := newFinder(needle)
+	for from := 0; from <= len(buf); from++ {
+		if got, expected := f.index(buf[from:]), bytes.Index(buf[from:], needle); got != expected {
+			t.Fatalf("Found %q at %d of %q, bytes.Index found it at %d", needle, got, buf[from:], expected)
+		}
+	}
+}
+
+func TestFinder(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, alphabet := range []string{"ab", "\x00\x00\x00\x00a", "\x00 ea\xff", binaryAlphabet} {
+		corpus := make([]byte, 1024)
+		for i := range corpus {
+			corpus[i] = alphabet[r.Intn(len(alphabet))]
+		}
+		for _, needle := range finderNeedles(r, corpus, alphabet, 50) {
+			checkFinder(t, corpus, needle)
+		}
+	}
+	corpus := memoryCorpus(r, 4*4096)
+	for _, needle := range finderNeedles(r, corpus, binaryAlphabet, 20) {
+		checkFinder(t, corpus[:2048], needle)
+		if got, expected := newFinder(needle).index(corpus), bytes.Index(corpus, needle); got != expected {
+			t.Errorf("Found %q at %d, bytes.Index found it at %d", needle, got, expected)
+		}
+	}
+
+	// The needle at every offset of the buffer, its rare byte everywhere else.
+	for _, needle := range [][]byte{[]byte("\x00\x00\x00\x0cMASCHE"), []byte("\x00\x00Z"), []byte(" \xc3 ")} {
+		f := newFinder(needle)
+		if f.rare <= 0 {
+			t.Fatalf("The finder of %q doesn't look for its rare byte", needle)
+		}
+		for offset := 0; offset+len(needle) <= 256; offset++ {
+			for _, filler := range []byte{0, needle[f.rare]} {
+				buf := bytes.Repeat([]byte{filler}, 256)
+				copy(buf[offset:], needle)
+				if got, expected := f.index(buf), bytes.Index(buf, needle); got != expected {
+					t.Fatalf("Found %q at %d of %q, bytes.Index found it at %d", needle, got, buf, expected)
+				}
+			}
+		}
+	}
+
+	for _, needle := range []string{"", "a", "\x00\x00\x00\x00", "a\x00", "\x00 \x00"} {
+		if f := newFinder([]byte(needle)); f.rare != -1 {
+			t.Errorf("The finder of %q looks for the byte at %d", needle, f.rare)
+		}
+		checkFinder(t, []byte("\x00\x00\x00\x00\x00 \x00a\x00"), []byte(needle))
+	}
+}
+
+// The finders find the same matches as naiveFindAll across the reads of the scan.
+func TestFinderFindAllIn(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	corpus := memoryCorpus(r, 8*4096)
+	var patterns []Pattern
+	for _, needle := range finderNeedles(r, corpus, binaryAlphabet, automatonMinPatterns-1) {
+		patterns = append(patterns, Pattern{Bytes: needle})
+	}
+	b, err := memaccess.NewStaticBackend(memaccess.BackendInfo{Kind: "static", Pid: 42}, []memaccess.Segment{
+		{Region: memaccess.MemoryRegion{Address: 0x10000, Size: uint(len(corpus)), Access: memaccess.Readable},
+			Data: corpus}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := naiveFindAll(t, b, patterns)
+	for _, bufferSize := range []uint{24, 100, 4096, DefaultBufferSize} {
+		matches, _, err, softerrors := FindAllIn(b, 0, patterns, SearchOptions{BufferSize: bufferSize})
+		if err != nil || len(softerrors) != 0 {
+			t.Fatal(err, softerrors)
+		}
+		for _, m := range matches {
+			if !expected[[2]uintptr{m.Address, uintptr(m.Pattern)}] {
+				t.Errorf("Unexpected match %v with %d bytes buffers", m, bufferSize)
+			}
+		}
+		if len(matches) != len(expected) {
+			t.Errorf("Found %d matches with %d bytes buffers, expected %d", len(matches), bufferSize,
+				len(expected))
+		}
+	}
+}
+
+// Searching the memory of a process for needles whose first byte is common, and for one whose first byte is rare.
+func BenchmarkFinder(b *testing.B) {
+	corpus := memoryCorpus(rand.New(rand.NewSource(1)), 16<<20)
+	for _, c := range []struct {
+		name   string
+		needle string
+	}{
+		{"Length", "\x00\x00\x00\x0cpublic-key!!"},
+		{"Text", "eeeeMASCHEMARKER"},
+		{"Marker", "MASCHEMK"},
+		{"Long", "e masche e masche e masche e masche e masche e masche e masche e masche"},
+	} {
+		needle := []byte(c.needle)
+		f := newFinder(needle)
+		b.Run(c.name+"/bytes.Index", func(b *testing.B) {
+			b.SetBytes(int64(len(corpus)))
+			for i := 0; i < b.N; i++ {
+				bytes.Index(corpus, needle)
+			}
+		})
+		b.Run(c.name+"/finder", func(b *testing.B) {
+			b.SetBytes(int64(len(corpus)))
+			for i := 0; i < b.N; i++ {
+				f.index(corpus)
+			}
+		})
+	}
+}
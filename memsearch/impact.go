@@ -0,0 +1,24 @@
+package memsearch
+
+import (
+	"time"
+)
+
+// ImpactReport describes the effect a scan had on the scanned process. It's filled in ScanStats when
+// SearchOptions.Impact is set.
+type ImpactReport struct {
+	// CPUTime is the user and system CPU time the process consumed during the scan.
+	CPUTime     time.Duration `json:"cpuTime"`
+	MinorFaults uint64        `json:"minorFaults"`
+	MajorFaults uint64        `json:"majorFaults"`
+
+	// PagesSampled is the number of pages whose residency was checked before and after the scan, and PagesFaultedIn
+	// how many of them weren't resident before it and were after it.
+	PagesSampled   int `json:"pagesSampled"`
+	PagesFaultedIn int `json:"pagesFaultedIn"`
+	// EstimatedBytesFaultedIn extrapolates PagesFaultedIn to all the memory the sample was taken from.
+	EstimatedBytesFaultedIn uint64 `json:"estimatedBytesFaultedIn"`
+}
+
+// impactSamplePages is the maximum amount of pages whose residency is sampled for an ImpactReport.
+const impactSamplePages = 256
@@ -0,0 +1,120 @@
+package memsearch
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/polyverse/masche/common"
+	"github.com/polyverse/masche/process"
+)
+
+// pagemapPresent is the bit of a /proc/PID/pagemap entry that tells if the page is resident.
+const pagemapPresent = 1 << 63
+
+// impactSampler takes the measurements needed for an ImpactReport before the scan starts.
+type impactSampler struct {
+	p           process.Process
+	pid         int
+	before      common.ProcStat
+	pages       []uintptr
+	resident    []bool
+	sampledFrom uint64
+}
+
+func startImpact(p process.Process, address uintptr) (*impactSampler, error) {
+	s := &impactSampler{p: p, pid: p.Pid()}
+
+	maps, err := process.OpenResource(p, process.MapsResource)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := common.ParseMapsFile(maps)
+	maps.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	// Spread the sample evenly over the readable memory that is going to be scanned.
+	var readable []common.MapsEntry
+	for _, entry := range entries {
+		if entry.Permissions[0] == 'r' && entry.End > address {
+			if entry.Start < address {
+				entry.Start = address
+			}
+			readable = append(readable, entry)
+			s.sampledFrom += uint64(entry.End - entry.Start)
+		}
+	}
+	pageSize := uint64(os.Getpagesize())
+	step := (s.sampledFrom/pageSize/impactSamplePages + 1) * pageSize
+	// next is the offset of the next sampled page in the readable memory, as if it was contiguous.
+	var next, offset uint64
+	for _, entry := range readable {
+		size := uint64(entry.End - entry.Start)
+		for ; next < offset+size; next += step {
+			s.pages = append(s.pages, entry.Start+uintptr(next-offset))
+		}
+		offset += size
+	}
+
+	s.resident, err = residentPages(p, s.pages)
+	if err != nil {
+		return nil, err
+	}
+
+	// The stat file is read last so reading the pagemap doesn't count as part of the scan.
+	s.before, err = common.ReadStatFile(uint(s.pid))
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *impactSampler) finish() (report ImpactReport, err error) {
+	after, err := common.ReadStatFile(uint(s.pid))
+	if err != nil {
+		return report, err
+	}
+	resident, err := residentPages(s.p, s.pages)
+	if err != nil {
+		return report, err
+	}
+
+	ticks := (after.Utime + after.Stime) - (s.before.Utime + s.before.Stime)
+	report.CPUTime = time.Duration(ticks) * time.Second / common.ClockTicks
+	report.MinorFaults = after.Minflt - s.before.Minflt
+	report.MajorFaults = after.Majflt - s.before.Majflt
+
+	report.PagesSampled = len(s.pages)
+	for i := range s.pages {
+		if !s.resident[i] && resident[i] {
+			report.PagesFaultedIn++
+		}
+	}
+	if report.PagesSampled > 0 {
+		report.EstimatedBytesFaultedIn = s.sampledFrom * uint64(report.PagesFaultedIn) / uint64(report.PagesSampled)
+	}
+	return report, nil
+}
+
+// residentPages tells which of the pages are resident in memory, according to /proc/PID/pagemap.
+func residentPages(p process.Process, pages []uintptr) ([]bool, error) {
+	pagemap, err := process.OpenResource(p, process.PagemapResource)
+	if err != nil {
+		return nil, err
+	}
+	defer pagemap.Close()
+
+	pageSize := uintptr(os.Getpagesize())
+	resident := make([]bool, len(pages))
+	entry := make([]byte, 8)
+	for i, page := range pages {
+		if _, err := pagemap.ReadAt(entry, int64(page/pageSize)*8); err != nil {
+			return nil, fmt.Errorf("Unable to read the pagemap entry of %x (%v)", page, err)
+		}
+		resident[i] = binary.LittleEndian.Uint64(entry)&pagemapPresent != 0
+	}
+	return resident, nil
+}
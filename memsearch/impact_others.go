@@ -0,0 +1,19 @@
+// +build windows darwin
+
+package memsearch
+
+import (
+	"fmt"
+
+	"github.com/polyverse/masche/process"
+)
+
+type impactSampler struct{}
+
+func startImpact(p process.Process, address uintptr) (*impactSampler, error) {
+	return nil, fmt.Errorf("Impact reports are not implemented on this platform")
+}
+
+func (s *impactSampler) finish() (report ImpactReport, err error) {
+	return report, fmt.Errorf("Impact reports are not implemented on this platform")
+}
@@ -1,12 +1,28 @@
 package memsearch
 
 import (
-	"bytes"
+	"fmt"
 	"github.com/polyverse/masche/memaccess"
 	"github.com/polyverse/masche/process"
 	"regexp"
 )
 
+// checkAccess makes sure that the memory of p can be read before starting a search. If it can't, a single harderror
+// wrapping process.ErrInsufficientPrivileges is returned instead of letting the walk fail once per region.
+func checkAccess(p process.Process) (harderror error, softerrors []error) {
+	level, harderror, softerrors := p.AccessLevel()
+	if harderror != nil {
+		return harderror, softerrors
+	}
+
+	if level != process.FullAccess {
+		return fmt.Errorf("Process %d: %w (access level %v)", p.Pid(), process.ErrInsufficientPrivileges, level),
+			softerrors
+	}
+
+	return nil, softerrors
+}
+
 // FindByFindBytesSequence finds for the first occurrence of needle in the Process starting at a given address (in the
 // process address space). If the needle is found the first argument will be true and the second one will contain it's
 // address.
@@ -21,9 +37,15 @@ func FindBytesSequence(p process.Process, address uintptr, needle []byte) (found
 
 	foundAddress = uintptr(0)
 	found = false
-	harderror, softerrors = memaccess.SlidingWalkMemory(p, address, buffer_size,
+	harderror, softerrors = checkAccess(p)
+	if harderror != nil {
+		return
+	}
+
+	f := newFinder(needle)
+	harderror, serrs := memaccess.SlidingWalkMemory(p, address, buffer_size,
 		func(address uintptr, buf []byte) (keepSearching bool) {
-			i := bytes.Index(buf, needle)
+			i := f.index(buf)
 			if i == -1 {
 				return true
 			}
@@ -32,6 +54,10 @@ func FindBytesSequence(p process.Process, address uintptr, needle []byte) (found
 			found = true
 			return false
 		})
+	softerrors = append(softerrors, serrs...)
+	if harderror != nil {
+		return false, 0, harderror, softerrors
+	}
 	return
 }
 
@@ -45,7 +71,12 @@ func FindRegexpMatch(p process.Process, address uintptr, r *regexp.Regexp) (foun
 
 	foundAddress = uintptr(0)
 	found = false
-	harderror, softerrors = memaccess.SlidingWalkMemory(p, address, buffer_size,
+	harderror, softerrors = checkAccess(p)
+	if harderror != nil {
+		return
+	}
+
+	harderror, serrs := memaccess.SlidingWalkMemory(p, address, buffer_size,
 		func(address uintptr, buf []byte) (keepSearching bool) {
 			loc := r.FindIndex(buf)
 			if loc == nil {
@@ -56,6 +87,10 @@ func FindRegexpMatch(p process.Process, address uintptr, r *regexp.Regexp) (foun
 			found = true
 			return false
 		})
+	softerrors = append(softerrors, serrs...)
+	if harderror != nil {
+		return false, 0, harderror, softerrors
+	}
 
 	return
 }
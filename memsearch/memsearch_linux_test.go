@@ -0,0 +1,896 @@
+package memsearch
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/polyverse/masche/common"
+	"github.com/polyverse/masche/memaccess"
+	"github.com/polyverse/masche/process"
+	"github.com/polyverse/masche/test"
+)
+
+func TestFindAllImpact(t *testing.T) {
+	cmd, err := test.LaunchTestCaseAndWaitForInitialization()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	proc, err, softerrors := process.OpenFromPid(cmd.Process.Pid)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proc.Close()
+
+	patterns := findAllPatterns(buffersToFind)
+	_, stats, err, softerrors := FindAll(proc, 0, patterns, SearchOptions{})
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Impact != nil {
+		t.Fatal("Got an impact report without asking for it")
+	}
+
+	start := time.Now()
+	_, stats, err, softerrors = FindAll(proc, 0, patterns, SearchOptions{Impact: true})
+	elapsed := time.Since(start)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	impact := stats.Impact
+	if impact == nil {
+		t.Fatal("No impact report")
+	}
+	// The test case is sleeping, so it can't use more CPU than the scan took, give or take a clock tick.
+	if impact.CPUTime > elapsed+10*time.Millisecond {
+		t.Error("Implausible CPU time", impact.CPUTime)
+	}
+	if impact.PagesSampled == 0 || impact.PagesSampled > impactSamplePages {
+		t.Error("Implausible amount of sampled pages", impact.PagesSampled)
+	}
+	if impact.PagesFaultedIn > impact.PagesSampled || impact.EstimatedBytesFaultedIn > stats.BytesScanned {
+		t.Error("More memory faulted in than scanned", impact)
+	}
+}
+
+const namespaceHelperEnv = "MASCHE_NAMESPACE_DIR"
+
+// TestNamespaceHelper is not a real test: it's run by TestFindAllPreferFileReadsInNamespace in a subprocess with its
+// own mount namespace, which mounts a tmpfs on the given directory and maps a file from it until its stdin is closed.
+func TestNamespaceHelper(t *testing.T) {
+	dir := os.Getenv(namespaceHelperEnv)
+	if dir == "" {
+		t.Skip("only run as a helper of TestFindAllPreferFileReadsInNamespace")
+	}
+	if err := syscall.Mount("tmpfs", dir, "tmpfs", 0, ""); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "mapped.bin")
+	if err := ioutil.WriteFile(path, namespacedFile([]byte("MASCHENSFILE")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := syscall.Mmap(int(f.Fd()), 0, os.Getpagesize(), syscall.PROT_READ, syscall.MAP_PRIVATE); err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println("mapped")
+	ioutil.ReadAll(os.Stdin)
+}
+
+// namespacedFile is a page starting with data.
+func namespacedFile(data []byte) []byte {
+	return append(data, make([]byte, os.Getpagesize()-len(data))...)
+}
+
+// The paths of the files mapped by a process in another mount namespace are resolved in its root.
+func TestFindAllPreferFileReadsInNamespace(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Only root can make mount namespaces")
+	}
+	// The file has the same path in the namespace and in ours, but only the one in the namespace has the marker.
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "mapped.bin"), namespacedFile([]byte("DECOY")), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	helper := exec.Command(os.Args[0], "-test.run=^TestNamespaceHelper$")
+	helper.Env = append(os.Environ(), namespaceHelperEnv+"="+dir)
+	helper.SysProcAttr = &syscall.SysProcAttr{Unshareflags: syscall.CLONE_NEWNS}
+	stdin, err := helper.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := helper.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := helper.Start(); err != nil {
+		t.Skipf("Unable to make a mount namespace, skipping: %v", err)
+	}
+	defer func() {
+		stdin.Close()
+		helper.Wait()
+	}()
+	out := make([]byte, len("mapped"))
+	if _, err := io.ReadFull(stdout, out); err != nil || string(out) != "mapped" {
+		t.Skipf("The helper couldn't map a file in its namespace, skipping: %q (%v)", out, err)
+	}
+
+	proc, err, softerrors := process.OpenFromPid(helper.Process.Pid)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proc.Close()
+
+	matches, stats, err, softerrors := FindAll(proc, 0, []Pattern{{Bytes: []byte("MASCHENSFILE")}},
+		SearchOptions{PreferFileReads: true})
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The marker is also in the memory of the helper, where it was written from.
+	var mapped *Match
+	for i, m := range matches {
+		if m.Region.Kind == filepath.Join(dir, "mapped.bin") {
+			mapped = &matches[i]
+		}
+	}
+	if mapped == nil {
+		t.Fatal("Expected the marker in the mapped file, got", matches)
+	}
+	for _, source := range stats.Sources {
+		if source.Region.Address == mapped.Region.Address &&
+			(source.FileBytes == 0 || source.Resolution != ResolvedInNamespace) {
+
+			t.Error("Expected the region to be read from the file in the namespace, got", source)
+		}
+	}
+}
+
+// Symlinks in the root of a process can't make openInRoot open a file outside of it.
+func TestOpenInRoot(t *testing.T) {
+	defer func(root string) { common.ProcRoot = root }(common.ProcRoot)
+	common.ProcRoot = t.TempDir()
+	root := filepath.Join(common.ProcRoot, "4747", "root")
+	if err := os.MkdirAll(filepath.Join(root, "lib"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "lib", "libfake.so"), []byte("namespaced"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	outside := filepath.Join(t.TempDir(), "outside")
+	if err := ioutil.WriteFile(outside, []byte("outside"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for name, target := range map[string]string{"absolute": outside, "relative": "../../../../../../.." + outside} {
+		if err := os.Symlink(target, filepath.Join(root, "lib", name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f, err := openInRoot(4747, "/lib/libfake.so")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(f)
+	f.Close()
+	if err != nil || string(data) != "namespaced" {
+		t.Errorf("Expected the file in the root, got %q (%v)", data, err)
+	}
+	for _, name := range []string{"absolute", "relative"} {
+		if f, err := openInRoot(4747, "/lib/"+name); err == nil {
+			f.Close()
+			t.Errorf("The %s symlink escaped the root", name)
+		}
+	}
+}
+
+func TestFindAllPreferFileReads(t *testing.T) {
+	cmd, err := test.LaunchTestCaseAndWaitForInitialization()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	proc, err, softerrors := process.OpenFromPid(cmd.Process.Pid)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proc.Close()
+
+	// The string literal is in the read only data of the executable, which is never modified.
+	patterns := findAllPatterns(append(buffersToFind, []byte(regexpToMatch[0])))
+	expected, _, err, softerrors := FindAll(proc, 0, patterns, SearchOptions{})
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	matches, stats, err, softerrors := FindAll(proc, 0, patterns, SearchOptions{PreferFileReads: true})
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(matches, expected) {
+		t.Errorf("Reading from files found %v instead of %v", matches, expected)
+	}
+	var fileBytes uint64
+	for _, source := range stats.Sources {
+		fileBytes += source.FileBytes
+	}
+	if len(stats.Sources) != stats.RegionsScanned || fileBytes == 0 {
+		t.Error("Unexpected read sources", stats.Sources)
+	}
+	for _, source := range stats.Sources {
+		if source.FileBytes > 0 && source.Resolution != ResolvedInNamespace {
+			t.Error("Expected the files to be resolved in the root of the process", source)
+		}
+	}
+
+	// Without access to the process memory only the matches in the files are found, which include the literal.
+	defer func(f func(process.Process) memaccess.MemoryBackend) { processBackend = f }(processBackend)
+	processBackend = func(p process.Process) memaccess.MemoryBackend {
+		return deniedBackend{memaccess.ProcessBackend(p)}
+	}
+
+	matches, _, err, _ = FindAll(proc, 0, patterns, SearchOptions{PreferFileReads: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	foundLiteral := false
+	for _, m := range matches {
+		if !containsMatch(expected, m) {
+			t.Error("Unexpected match", m)
+		}
+		foundLiteral = foundLiteral || m.Pattern == len(patterns)-1
+	}
+	if !foundLiteral {
+		t.Error("The string literal was not found in the executable file, the matches are", matches)
+	}
+}
+
+func TestCompareMappedFile(t *testing.T) {
+	dir := t.TempDir()
+	pristine := bytes.Repeat([]byte("clean"), 4*4096/5+1)[:4*4096]
+	dirty, clean := filepath.Join(dir, "dirty"), filepath.Join(dir, "clean")
+	for _, path := range []string{dirty, clean} {
+		if err := ioutil.WriteFile(path, pristine, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cmd, err := test.LaunchTestCaseAndWaitForInitialization("--map-shared-dirty", dirty, "--map-shared", clean)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+	proc, err, _ := process.OpenFromPid(cmd.Process.Pid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proc.Close()
+
+	regions, err, _ := memaccess.MemoryRegions(proc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addresses := map[string]uintptr{}
+	for _, region := range regions {
+		addresses[region.Kind] = region.Address
+	}
+	start := addresses[dirty]
+
+	// The clean mapping has no dirty pages, so it isn't compared.
+	divergence, err, _ := CompareMappedFile(proc, addresses[clean], DivergenceOptions{})
+	if err != nil || divergence.Dirty != 0 || divergence.Compared != 0 || divergence.Path != clean {
+		t.Errorf("Unexpected divergence of the clean mapping %+v (%v)", divergence, err)
+	}
+
+	// The dirty pages are the page cache of the file, which reading it returns.
+	divergence, err, _ = CompareMappedFile(proc, start+4096, DivergenceOptions{})
+	if err != nil || divergence.Dirty == 0 || divergence.Compared != 4*4096 || divergence.Region.Address != start ||
+		divergence.Ranges != nil {
+
+		t.Errorf("Unexpected divergence of the dirty mapping %+v (%v)", divergence, err)
+	}
+
+	// A file whose reads don't see the dirty pages, like on some network filesystems, diverges where they were
+	// written.
+	read := readMappedFile
+	defer func() { readMappedFile = read }()
+	readMappedFile = func(m *fileMapping, address uintptr, buf []byte) error {
+		if m.path != dirty {
+			return read(m, address, buf)
+		}
+		copy(buf, pristine[address-m.start:])
+		return nil
+	}
+	divergence, err, _ = CompareMappedFile(proc, start, DivergenceOptions{ChunkSize: 4096})
+	expected := []DivergentRange{{Address: start + 100, Size: 12}, {Address: start + 2*4096 + 5, Size: 12}}
+	if err != nil || !reflect.DeepEqual(divergence.Ranges, expected) || divergence.Truncated {
+		t.Errorf("Expected the divergent ranges %v, got %+v (%v)", expected, divergence, err)
+	}
+	capped, err, _ := CompareMappedFile(proc, start, DivergenceOptions{MaxBytes: 4096})
+	if err != nil || !reflect.DeepEqual(capped.Ranges, expected[:1]) || !capped.Truncated ||
+		capped.Compared != 4096 {
+
+		t.Errorf("Unexpected capped divergence %+v (%v)", capped, err)
+	}
+
+	if _, err, _ := CompareMappedFile(proc, regions[0].Address, DivergenceOptions{}); err == nil {
+		t.Error("Compared a mapping that isn't shared")
+	}
+
+	// PreferFileReads reads the divergent mapping from the process once it's known.
+	patterns := []Pattern{{Bytes: []byte("MASCHE DIRTY")}}
+	matches, _, err, _ := FindAll(proc, start, patterns, SearchOptions{PreferFileReads: true})
+	if err != nil || len(matches) != 0 {
+		t.Errorf("Expected no matches in the diverging file, got %v (%v)", matches, err)
+	}
+	matches, stats, err, _ := FindAll(proc, start, patterns, SearchOptions{PreferFileReads: true,
+		Divergences: []Divergence{divergence}})
+	if err != nil || len(matches) != 2 || matches[0].Address != expected[0].Address {
+		t.Errorf("Expected the 2 markers, got %v (%v)", matches, err)
+	}
+	if len(stats.Sources) == 0 || stats.Sources[0].Region.Address != start || stats.Sources[0].FileBytes != 0 {
+		t.Errorf("The divergent mapping was read from the file: %v", stats.Sources)
+	}
+}
+
+// deniedBackend lists the memory of a process but can't read it.
+type deniedBackend struct {
+	memaccess.MemoryBackend
+}
+
+func (deniedBackend) ReadAt(address uintptr, buf []byte) (error, []error) {
+	return fmt.Errorf("Access denied by the test"), nil
+}
+
+func containsMatch(matches []Match, m Match) bool {
+	for _, other := range matches {
+		if reflect.DeepEqual(other, m) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestReverify(t *testing.T) {
+	cmd, err := test.LaunchTestCaseAndWaitForInitialization()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	proc, err, softerrors := process.OpenFromPid(cmd.Process.Pid)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proc.Close()
+
+	patterns := []Pattern{{Bytes: []byte("MASCHEMK")}, {Bytes: []byte(regexpToMatch[0])}}
+	matches, _, err, softerrors := FindAll(proc, 0, patterns, SearchOptions{})
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var markers []Match
+	var literal Match
+	for _, m := range matches {
+		if m.Pattern == 0 {
+			markers = append(markers, m)
+		} else if m.Region.Kind == test.GetTestCasePath() {
+			literal = m
+		}
+	}
+	if len(markers) != 4 || literal.Bytes == nil {
+		t.Fatal("Unexpected matches", matches)
+	}
+
+	// The test case changes the magic of the second marker when it gets SIGUSR1.
+	if err := cmd.Process.Signal(syscall.SIGUSR1); err != nil {
+		t.Fatal(err)
+	}
+	var results []Reverification
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+		results, err, softerrors = Reverify(proc, markers, SearchOptions{})
+		test.PrintSoftErrors(softerrors)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if results[1].Status != StillPresent {
+			break
+		}
+	}
+	for i, r := range results {
+		expected := StillPresent
+		if i == 1 {
+			expected = Changed
+		}
+		if r.Status != expected {
+			t.Errorf("Expected marker %d to be %v, but it's %v", i, expected, r.Status)
+		}
+	}
+	if string(results[1].Bytes) != "XASCHEMK" {
+		t.Errorf("Unexpected changed bytes %q", results[1].Bytes)
+	}
+
+	// A match in a region that isn't there anymore is looked for where its file is mapped now, while one in
+	// anonymous memory can't be found.
+	moved := literal
+	moved.Address += 1 << 40
+	moved.Region.Address += 1 << 40
+	gone := markers[0]
+	gone.Address += 1 << 40
+	results, err, softerrors = Reverify(proc, []Match{moved, gone}, SearchOptions{})
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The executable can have more than one copy of the page, any of them will do.
+	if results[0].Status != StillPresent || results[0].Address == moved.Address {
+		t.Error("The moved match was not found at its new address", results[0])
+	}
+	if results[1].Status != Unmapped {
+		t.Error("Expected the match to be unmapped", results[1])
+	}
+}
+
+// failingBackend fails to list its regions, with a softerror explaining why.
+type failingBackend struct {
+	memaccess.MemoryBackend
+}
+
+var errFailingSoft = errors.New("the soft reason of the failure")
+
+func (failingBackend) Regions() ([]memaccess.MemoryRegion, error, []error) {
+	return nil, errors.New("no regions"), []error{errFailingSoft}
+}
+
+func (failingBackend) Info() memaccess.BackendInfo {
+	return memaccess.BackendInfo{Kind: "failing"}
+}
+
+// The functions returning (result, harderror, softerrors) must return a zero result along with a harderror, and keep
+// the softerrors.
+func TestErrorContract(t *testing.T) {
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	p := process.GetProcess(cmd.Process.Pid)
+	patterns := []Pattern{{Bytes: []byte("MASCHEMK")}}
+
+	if found, address, err, _ := FindBytesSequence(p, 0, patterns[0].Bytes); err == nil || found || address != 0 {
+		t.Errorf("FindBytesSequence in an exited process returned %v, %x, %v", found, address, err)
+	}
+	if found, address, err, _ := FindRegexpMatch(p, 0, regexp.MustCompile(".")); err == nil || found ||
+		address != 0 {
+		t.Errorf("FindRegexpMatch in an exited process returned %v, %x, %v", found, address, err)
+	}
+	matches, stats, err, _ := FindAll(p, 0, patterns, SearchOptions{Impact: true})
+	if err == nil || matches != nil || !reflect.DeepEqual(stats, ScanStats{}) {
+		t.Errorf("FindAll in an exited process returned %v, %+v, %v", matches, stats, err)
+	}
+	if results, err, _ := Reverify(p, []Match{{Address: 0x1000, Bytes: patterns[0].Bytes}}, SearchOptions{}); err != nil ||
+		len(results) != 1 || results[0].Status != Unmapped {
+		t.Errorf("Reverify in an exited process returned %v, %v", results, err)
+	}
+
+	matches, stats, err, softerrors := FindAllIn(failingBackend{}, 0, patterns, SearchOptions{})
+	if err == nil || matches != nil || !reflect.DeepEqual(stats, ScanStats{}) {
+		t.Errorf("FindAllIn of a failing backend returned %v, %+v, %v", matches, stats, err)
+	}
+	if len(softerrors) != 1 || softerrors[0] != errFailingSoft {
+		t.Errorf("FindAllIn of a failing backend lost its softerrors: %v", softerrors)
+	}
+}
+
+func TestFindAllDetectsExec(t *testing.T) {
+	second, err := test.CopyTestCase(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd, err := test.LaunchTestCaseAndWaitForInitialization("--exec", second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { cmd.Process.Kill(); cmd.Wait() }()
+
+	p, err, softerrors := process.OpenFromPid(cmd.Process.Pid)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	before, err := process.CurrentImage(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The test case execs the copy when the first marker is found, in the middle of the scan.
+	execed := false
+	execOnce := func(p process.Process, m Match, surrounding []byte) bool {
+		if !execed {
+			execed = true
+			cmd.Process.Signal(syscall.SIGUSR2)
+			for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+				if image, err := process.CurrentImage(p); err == nil && !image.Same(before) {
+					break
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		}
+		return true
+	}
+	patterns := []Pattern{{Bytes: []byte("MASCHEMK"), Validator: execOnce}}
+
+	matches, stats, err, softerrors := FindAll(p, 0, patterns, SearchOptions{})
+	var execErr *process.ExecError
+	if !errors.As(err, &execErr) {
+		t.Fatalf("Expected an ExecError, got %v, softerrors %v", err, softerrors)
+	}
+	if execErr.OldExecutable != test.GetTestCasePath() || execErr.NewExecutable != second {
+		t.Errorf("Unexpected executables in %v", execErr)
+	}
+	if matches != nil || stats.Matches != 0 {
+		t.Errorf("Expected no results, got %v, %+v", matches, stats)
+	}
+}
+
+func TestFindAllCredentialChange(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Switching the user of the test case needs root")
+	}
+
+	for _, policy := range []CredentialPolicy{AbortOnCredentialChange, AnnotateCredentialChange} {
+		cmd, err := test.LaunchTestCaseAndWaitForInitialization("--setuid", "65534")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer cmd.Process.Kill()
+
+		p, err, softerrors := process.OpenFromPid(cmd.Process.Pid)
+		test.PrintSoftErrors(softerrors)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer p.Close()
+
+		// The test case switches to nobody when the first marker is found, in the middle of the scan.
+		switched := false
+		switchOnce := func(p process.Process, m Match, surrounding []byte) bool {
+			if !switched {
+				switched = true
+				cmd.Process.Signal(syscall.SIGHUP)
+				for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+					if creds, err := process.CurrentCredentials(p); err == nil && creds.Uids[1] == 65534 {
+						break
+					}
+					time.Sleep(10 * time.Millisecond)
+				}
+			}
+			return true
+		}
+		// The bytes of the stack of the test case are found after the markers, which are in the heap.
+		patterns := []Pattern{{Bytes: []byte("MASCHEMK"), Validator: switchOnce},
+			{Bytes: []byte{0xd, 0xe, 0xa, 0xd, 0xb, 0xe, 0xe, 0xf}}}
+		opts := SearchOptions{Credentials: &CredentialChecks{OnChange: policy}}
+		matches, stats, err, softerrors := FindAll(p, 0, patterns, opts)
+		test.PrintSoftErrors(softerrors)
+
+		var credsErr *process.CredentialsError
+		if policy == AbortOnCredentialChange {
+			if !errors.As(err, &credsErr) || !errors.Is(err, process.ErrCredentialsChanged) {
+				t.Fatalf("Expected a CredentialsError, got %v", err)
+			}
+		} else {
+			if err != nil {
+				t.Fatal(err)
+			}
+			credsErr = stats.CredentialChange
+			if credsErr == nil {
+				t.Fatal("The credential change wasn't reported")
+			}
+			annotated := 0
+			for _, m := range matches {
+				if m.AfterCredentialChange {
+					annotated++
+				}
+			}
+			if annotated == 0 || annotated == len(matches) {
+				t.Errorf("Expected the matches after the change to be annotated, %d of %d are", annotated,
+					len(matches))
+			}
+		}
+		if credsErr.Before.Uids[1] != 0 || credsErr.After.Uids != [4]int{65534, 65534, 65534, 65534} {
+			t.Errorf("Unexpected change of credentials %v", credsErr)
+		}
+	}
+}
+
+// switchingBackend changes the uid in the fake status file of its process when it's first read.
+type switchingBackend struct {
+	memaccess.MemoryBackend
+	status   string
+	switched bool
+}
+
+func (b *switchingBackend) ReadAt(address uintptr, buf []byte) (error, []error) {
+	if !b.switched {
+		b.switched = true
+		writeFakeStatus(b.status, 1000)
+	}
+	return b.MemoryBackend.ReadAt(address, buf)
+}
+
+func writeFakeStatus(path string, uid int) {
+	status := fmt.Sprintf("Name:\tfake\nUid:\t%d\t%d\t%d\t%d\nGid:\t0\t0\t0\t0\nCapEff:\t0000000000000000\n", uid, uid,
+		uid, uid)
+	ioutil.WriteFile(path, []byte(status), 0644)
+}
+
+func TestFindAllCredentialChangeOfFakeProcess(t *testing.T) {
+	defer func(root string) { common.ProcRoot = root }(common.ProcRoot)
+	common.ProcRoot = t.TempDir()
+	const pid = 4242
+	status := filepath.Join(common.ProcRoot, strconv.Itoa(pid), "status")
+	if err := os.MkdirAll(filepath.Dir(status), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// The pattern is in three separate regions, the uid changes while reading the first one.
+	var segments []memaccess.Segment
+	for _, address := range []uintptr{0x1000, 0x3000, 0x5000} {
+		segments = append(segments, memaccess.Segment{Region: memaccess.MemoryRegion{Address: address, Size: 4,
+			Access: memaccess.Readable}, Data: []byte("abcd")})
+	}
+	static, err := memaccess.NewStaticBackend(memaccess.BackendInfo{Kind: "static", Pid: pid}, segments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patterns := []Pattern{{Bytes: []byte("bc")}}
+
+	for _, policy := range []CredentialPolicy{AbortOnCredentialChange, AnnotateCredentialChange} {
+		writeFakeStatus(status, 0)
+		b := &switchingBackend{MemoryBackend: static, status: status}
+		opts := SearchOptions{Credentials: &CredentialChecks{OnChange: policy}}
+		matches, stats, err, _ := findAll(b, process.GetProcess(pid), 0, patterns, opts)
+
+		if policy == AbortOnCredentialChange {
+			if !errors.Is(err, process.ErrCredentialsChanged) || matches != nil {
+				t.Errorf("Expected the scan to abort, got %v, %v", matches, err)
+			}
+			continue
+		}
+		if err != nil || len(matches) != 3 || stats.CredentialChange == nil {
+			t.Fatalf("Expected three matches and the change, got %v, %+v, %v", matches, stats, err)
+		}
+		for i, m := range matches {
+			if m.AfterCredentialChange != (i > 0) {
+				t.Errorf("Match %d should be annotated only if it's after the first region: %v", i, m)
+			}
+		}
+		if change := stats.CredentialChange; change.Before.Uids[0] != 0 || change.After.Uids[0] != 1000 {
+			t.Errorf("Unexpected change %v", change)
+		}
+	}
+}
+
+func TestCapabilitiesMatchBehavior(t *testing.T) {
+	cmd, err := test.LaunchTestCaseAndWaitForInitialization()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	proc, err, softerrors := process.OpenFromPid(cmd.Process.Pid)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proc.Close()
+
+	impact, _ := common.FindCapability(common.ProcessCapabilities(proc.Pid()), CapabilityImpact)
+	_, stats, err, softerrors := FindAll(proc, 0, findAllPatterns(buffersToFind), SearchOptions{Impact: true})
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (impact.Availability == common.Available) != (stats.Impact != nil) {
+		t.Errorf("%v, but the impact report is %v", impact, stats.Impact)
+	}
+
+	// A process hidden from us, as hidepid does.
+	defer func(root string) { common.ProcRoot = root }(common.ProcRoot)
+	common.ProcRoot = t.TempDir()
+	if c, _ := common.FindCapability(common.ProcessCapabilities(proc.Pid()), CapabilityFileReads); c.Availability !=
+		common.NeedsPrivilege {
+
+		t.Errorf("Expected file reads of a hidden process to need privileges, got %v", c)
+	}
+}
+
+func TestFindSecrets(t *testing.T) {
+	cmd, err := test.LaunchTestCaseAndWaitForInitialization()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	p, err, softerrors := process.OpenFromPid(cmd.Process.Pid)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	// The secrets are in the heap, and also in the data segment of the test case.
+	secrets, _, err, softerrors := FindSecrets(p, 0, SearchOptions{})
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := map[SecretType]int{}
+	for _, secret := range secrets {
+		found[secret.Type]++
+		checkRedacted(t, secret)
+		if secret.Match.Pid != p.Pid() {
+			t.Errorf("%v isn't in the test case", secret)
+		}
+	}
+	for _, typ := range SecretTypes() {
+		if found[typ] == 0 {
+			t.Errorf("No %s found, found %v", typ, secrets)
+		}
+	}
+}
+
+func TestFindAllPlacement(t *testing.T) {
+	cmd, err := test.LaunchTestCaseAndWaitForInitialization()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	proc, err, softerrors := process.OpenFromPid(cmd.Process.Pid)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proc.Close()
+
+	// The affinity of the thread is checked before, during and after the scan.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	before, err := getAffinity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cpu := 0
+	for before[cpu/64]&(1<<uint(cpu%64)) == 0 {
+		cpu++
+	}
+	var during []cpuMask
+	opts := SearchOptions{Placement: &Placement{CPUs: []int{cpu}}, Checkpoint: func(Cursor) bool {
+		mask, err := getAffinity()
+		if err != nil {
+			t.Error(err)
+		}
+		during = append(during, mask)
+		return true
+	}}
+	_, stats, err, softerrors := FindAll(proc, 0, findAllPatterns(buffersToFind), opts)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var pinned cpuMask
+	pinned[cpu/64] = 1 << uint(cpu%64)
+	if len(during) == 0 || during[0] != pinned {
+		t.Errorf("Expected the scan to run on CPU %d only, got the mask %x", cpu, during)
+	}
+	if after, err := getAffinity(); err != nil || after != before {
+		t.Errorf("The affinity wasn't restored: %x before, %x after (%v)", before, after, err)
+	}
+	if stats.Placement == nil || !stats.Placement.Pinned || !reflect.DeepEqual(stats.Placement.CPUs, []int{cpu}) {
+		t.Errorf("Unexpected placement %+v", stats.Placement)
+	}
+}
+
+func TestMemoryNodes(t *testing.T) {
+	defer func(proc, sys string) { common.ProcRoot, common.SysRoot = proc, sys }(common.ProcRoot, common.SysRoot)
+	common.ProcRoot, common.SysRoot = t.TempDir(), t.TempDir()
+	write := func(path string, data string) {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	nodes := filepath.Join(common.SysRoot, "devices", "system", "node")
+	write(filepath.Join(common.ProcRoot, "42", "numa_maps"),
+		"55d0a1c00000 default file=/bin/app mapped=10 N0=2 N2=8 kernelpagesize_kB=4\n"+
+			"55d0a2c00000 default heap anon=90 dirty=90 N1=50 N2=40 kernelpagesize_kB=4\n"+
+			"7ffd5e000000 default stack anon=5 dirty=5 N3=5 kernelpagesize_kB=4\n")
+
+	// A single node isn't worth pinning to.
+	write(filepath.Join(nodes, "online"), "0\n")
+	if nodes, cpus, err := memoryNodes(42); err != nil || nodes != nil || cpus != nil {
+		t.Errorf("Expected no nodes, got %v %v %v", nodes, cpus, err)
+	}
+
+	// Nodes 1 and 2 hold most of the 105 pages.
+	write(filepath.Join(nodes, "online"), "0-3\n")
+	write(filepath.Join(nodes, "node1", "cpulist"), "4-5,12\n")
+	write(filepath.Join(nodes, "node2", "cpulist"), "8-9\n")
+	found, cpus, err := memoryNodes(42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(found, []int{1, 2}) || !reflect.DeepEqual(cpus, []int{4, 5, 8, 9, 12}) {
+		t.Errorf("Expected the CPUs of nodes 1 and 2, got nodes %v and CPUs %v", found, cpus)
+	}
+}
+
+// runtimeStringHolder keeps a string header at a known address while the memory is searched.
+type runtimeStringHolder struct {
+	before uint64
+	s      string
+	after  uint64
+}
+
+// The Go strings of the test binary are found with the address of their header.
+func TestFindRuntimeStringsGo(t *testing.T) {
+	holder := &runtimeStringHolder{s: "masche planted Go string " + strconv.Itoa(os.Getpid())}
+	header := uintptr(unsafe.Pointer(&holder.s))
+	self, err, softerrors := process.OpenFromPid(os.Getpid())
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer self.Close()
+
+	found, err, _ := FindRuntimeStrings(self, RuntimeStringOptions{Runtime: GoRuntime, MinLength: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var planted *RuntimeString
+	for i := range found {
+		if found[i].HeaderAddress == header {
+			planted = &found[i]
+		}
+	}
+	data := uintptr(unsafe.Pointer(unsafe.StringData(holder.s)))
+	if planted == nil || planted.Value != holder.s || planted.DataAddress != data || planted.Confidence < 0.8 {
+		t.Errorf("Expected %q at %x, got %+v", holder.s, header, planted)
+	}
+	runtime.KeepAlive(holder)
+}
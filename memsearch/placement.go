@@ -0,0 +1,20 @@
+package memsearch
+
+// Placement chooses the CPUs that scan the memory of a process. On large NUMA machines reading memory from a far node
+// is slower, and disturbs the process more.
+type Placement struct {
+	// CPUs, if not empty, are the CPUs the scan runs on.
+	CPUs []int
+	// FollowMemory, if CPUs is empty, runs the scan on the CPUs of the NUMA nodes that hold most of the memory of the
+	// process. On machines with a single node the scan runs wherever the scheduler puts it.
+	FollowMemory bool
+}
+
+// PlacementReport tells where a scan ran.
+type PlacementReport struct {
+	// Nodes are the NUMA nodes the CPUs were chosen from, if they were chosen by FollowMemory.
+	Nodes []int `json:"nodes,omitempty"`
+	CPUs  []int `json:"cpus,omitempty"`
+	// Pinned is false if the scan wasn't pinned to the CPUs, because the machine has a single NUMA node.
+	Pinned bool `json:"pinned"`
+}
@@ -0,0 +1,186 @@
+package memsearch
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"github.com/polyverse/masche/common"
+)
+
+// affinityWords is the size of the CPU masks given to sched_setaffinity(2), enough for 1024 CPUs.
+const affinityWords = 16
+
+type cpuMask [affinityWords]uint64
+
+// place pins the calling goroutine to the CPUs chosen by placement for the process with the given pid. restore undoes
+// it, and it's nil if the goroutine wasn't pinned.
+func place(pid int, placement Placement) (report PlacementReport, restore func(), err error) {
+	report.CPUs = placement.CPUs
+	if len(report.CPUs) == 0 && placement.FollowMemory {
+		report.Nodes, report.CPUs, err = memoryNodes(pid)
+		if err != nil || len(report.CPUs) == 0 {
+			return report, nil, err
+		}
+	}
+	if len(report.CPUs) == 0 {
+		return report, nil, nil
+	}
+
+	var mask cpuMask
+	for _, cpu := range report.CPUs {
+		if cpu < 0 || cpu >= affinityWords*64 {
+			return report, nil, fmt.Errorf("Invalid CPU %d", cpu)
+		}
+		mask[cpu/64] |= 1 << uint(cpu%64)
+	}
+
+	runtime.LockOSThread()
+	old, err := getAffinity()
+	if err == nil {
+		err = setAffinity(&mask)
+	}
+	if err != nil {
+		runtime.UnlockOSThread()
+		return report, nil, fmt.Errorf("Unable to set the CPU affinity of the scan: %v", err)
+	}
+	report.Pinned = true
+	return report, func() {
+		setAffinity(&old)
+		runtime.UnlockOSThread()
+	}, nil
+}
+
+func getAffinity() (mask cpuMask, err error) {
+	_, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_GETAFFINITY, 0, unsafe.Sizeof(mask),
+		uintptr(unsafe.Pointer(&mask)))
+	if errno != 0 {
+		return mask, errno
+	}
+	return mask, nil
+}
+
+func setAffinity(mask *cpuMask) error {
+	_, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_SETAFFINITY, 0, unsafe.Sizeof(*mask),
+		uintptr(unsafe.Pointer(mask)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// memoryNodes returns the fewest NUMA nodes that hold at least half of the memory of the process, and their CPUs.
+// It returns no nodes on machines with a single node.
+func memoryNodes(pid int) (nodes []int, cpus []int, err error) {
+	online, err := ioutil.ReadFile(filepath.Join(common.SysRoot, "devices", "system", "node", "online"))
+	if os.IsNotExist(err) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if all, err := parseCPUList(string(online)); err != nil || len(all) < 2 {
+		return nil, nil, err
+	}
+
+	pages, err := nodePages(pid)
+	if err != nil {
+		return nil, nil, err
+	}
+	var total uint64
+	for node, n := range pages {
+		nodes = append(nodes, node)
+		total += n
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return pages[nodes[i]] > pages[nodes[j]] || pages[nodes[i]] == pages[nodes[j]] && nodes[i] < nodes[j]
+	})
+	var held uint64
+	for i, node := range nodes {
+		held += pages[node]
+		if 2*held >= total {
+			nodes = nodes[:i+1]
+			break
+		}
+	}
+	sort.Ints(nodes)
+
+	for _, node := range nodes {
+		list, err := ioutil.ReadFile(filepath.Join(common.SysRoot, "devices", "system", "node",
+			fmt.Sprintf("node%d", node), "cpulist"))
+		if err != nil {
+			return nil, nil, err
+		}
+		nodeCPUs, err := parseCPUList(string(list))
+		if err != nil {
+			return nil, nil, err
+		}
+		cpus = append(cpus, nodeCPUs...)
+	}
+	sort.Ints(cpus)
+	return nodes, cpus, nil
+}
+
+// nodePages returns the amount of pages of the process in each NUMA node, from its numa_maps file.
+func nodePages(pid int) (map[int]uint64, error) {
+	f, err := os.Open(common.ProcFilePath(uint(pid), "numa_maps"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	pages := make(map[int]uint64)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		for _, field := range strings.Fields(scanner.Text()) {
+			if !strings.HasPrefix(field, "N") || !strings.Contains(field, "=") {
+				continue
+			}
+			parts := strings.SplitN(field[1:], "=", 2)
+			node, err := strconv.Atoi(parts[0])
+			if err != nil {
+				continue
+			}
+			n, err := strconv.ParseUint(parts[1], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid numa_maps field %q", field)
+			}
+			pages[node] += n
+		}
+	}
+	return pages, scanner.Err()
+}
+
+// parseCPUList parses lists like "0-3,8,10-11", as found in the sys filesystem.
+func parseCPUList(list string) ([]int, error) {
+	var cpus []int
+	list = strings.TrimSpace(list)
+	if list == "" {
+		return nil, nil
+	}
+	for _, item := range strings.Split(list, ",") {
+		bounds := strings.SplitN(item, "-", 2)
+		first, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid CPU list %q", list)
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, err = strconv.Atoi(bounds[1]); err != nil || last < first {
+				return nil, fmt.Errorf("Invalid CPU list %q", list)
+			}
+		}
+		for cpu := first; cpu <= last; cpu++ {
+			cpus = append(cpus, cpu)
+		}
+	}
+	return cpus, nil
+}
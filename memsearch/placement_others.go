@@ -0,0 +1,11 @@
+// +build windows darwin
+
+package memsearch
+
+import (
+	"fmt"
+)
+
+func place(pid int, placement Placement) (report PlacementReport, restore func(), err error) {
+	return report, nil, fmt.Errorf("Placing scans is not implemented on this platform")
+}
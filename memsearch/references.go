@@ -0,0 +1,164 @@
+package memsearch
+
+import (
+	"fmt"
+	"sort"
+	"unicode/utf16"
+	"unsafe"
+
+	"github.com/polyverse/masche/memaccess"
+	"github.com/polyverse/masche/process"
+)
+
+// String encodings searched by FindStringAndReferences.
+const (
+	UTF8    = "utf-8"
+	UTF16LE = "utf-16le"
+)
+
+// Reference is a pointer to a string instance found in memory.
+type Reference struct {
+	// Address is where the pointer is.
+	Address uintptr `json:"address"`
+	// Offset is how far into the instance it points.
+	Offset uintptr `json:"offset"`
+	// Region is the memory region containing the pointer. Its kind tells the module, if any.
+	Region memaccess.MemoryRegion `json:"region"`
+}
+
+// StringInstance is a copy of a string found in memory, with the pointers to it.
+type StringInstance struct {
+	Match      Match       `json:"match"`
+	Encoding   string      `json:"encoding"`
+	References []Reference `json:"references"`
+}
+
+// ReferenceOptions modifies the behaviour of FindStringAndReferences. Its zero value is a sensible default.
+type ReferenceOptions struct {
+	// Search are the options of the search of the string. Only one instance per region is found if it short
+	// circuits.
+	Search SearchOptions
+	// Slack is how far into an instance a pointer can point to be a reference. If it's zero, only pointers to its
+	// first byte count.
+	Slack uintptr
+}
+
+// FindStringAndReferences finds the copies of s in the memory of p, encoded both in UTF-8 and UTF-16LE, and the
+// pointers to each of them.
+//
+// Pointers are aligned words of the process' memory whose value is the address of an instance, or up to opts.Slack
+// bytes after it. All the instances are looked for in a single pass over the memory. They have the size and byte
+// order of the pointers of p, which can't be bigger than the ones of the current process.
+func FindStringAndReferences(p process.Process, s string, opts ReferenceOptions) (instances []StringInstance,
+	harderror error, softerrors []error) {
+
+	return findStringAndReferences(processBackend(p), s, opts, func(patterns []Pattern) ([]Match, error, []error) {
+		matches, _, harderror, softerrors := FindAll(p, 0, patterns, opts.Search)
+		return matches, harderror, softerrors
+	})
+}
+
+// FindStringAndReferencesIn works as FindStringAndReferences, but it searches any MemoryBackend. The size and byte
+// order of its pointers are the ones of its architecture, see memaccess.BackendArch.
+func FindStringAndReferencesIn(b memaccess.MemoryBackend, s string, opts ReferenceOptions) (
+	instances []StringInstance, harderror error, softerrors []error) {
+
+	return findStringAndReferences(b, s, opts, func(patterns []Pattern) ([]Match, error, []error) {
+		matches, _, harderror, softerrors := FindAllIn(b, 0, patterns, opts.Search)
+		return matches, harderror, softerrors
+	})
+}
+
+// findStringAndReferences finds the instances of s with find, and their references in b.
+func findStringAndReferences(b memaccess.MemoryBackend, s string, opts ReferenceOptions,
+	find func(patterns []Pattern) ([]Match, error, []error)) (instances []StringInstance, harderror error,
+	softerrors []error) {
+
+	if s == "" {
+		return nil, fmt.Errorf("The string to search for is empty"), nil
+	}
+	// The architecture is checked first, not to search the whole memory for nothing.
+	target, harderror, softerrors := memaccess.BackendArch(b)
+	if harderror == nil {
+		harderror = process.CheckArch(b.Info().Pid, "The pointer scan", target, func(t process.TargetArch) bool {
+			return t.PointerSize <= int(unsafe.Sizeof(uintptr(0)))
+		})
+	}
+	if harderror != nil {
+		return nil, harderror, softerrors
+	}
+
+	wide := make([]byte, 0, 2*len(s))
+	for _, unit := range utf16.Encode([]rune(s)) {
+		wide = append(wide, byte(unit), byte(unit>>8))
+	}
+	encodings := []string{UTF8, UTF16LE}
+	patterns := []Pattern{{Bytes: []byte(s)}, {Bytes: wide}}
+
+	matches, harderror, serrs := find(patterns)
+	softerrors = append(softerrors, serrs...)
+	if harderror != nil {
+		return nil, harderror, softerrors
+	}
+
+	instances = make([]StringInstance, 0, len(matches))
+	for _, m := range matches {
+		instances = append(instances, StringInstance{Match: m, Encoding: encodings[m.Pattern]})
+	}
+	if len(instances) == 0 {
+		return instances, nil, softerrors
+	}
+
+	harderror, serrs = findReferences(b, target, instances, opts.Slack)
+	softerrors = append(softerrors, serrs...)
+	if harderror != nil {
+		return nil, harderror, softerrors
+	}
+	return instances, nil, softerrors
+}
+
+// findReferences adds the references to instances, which are sorted by address, walking the memory of b once. Its
+// pointers are the ones of arch.
+func findReferences(b memaccess.MemoryBackend, arch process.TargetArch, instances []StringInstance,
+	slack uintptr) (harderror error, softerrors []error) {
+
+	regions, harderror, softerrors := b.Regions()
+	if harderror != nil {
+		return harderror, softerrors
+	}
+
+	// The instance a value points to is the last one starting at or before it, if it's within the slack.
+	target := func(value uintptr) int {
+		i := sort.Search(len(instances), func(i int) bool { return instances[i].Match.Address > value }) - 1
+		if i < 0 || value-instances[i].Match.Address > slack {
+			return -1
+		}
+		return i
+	}
+	region := func(address uintptr) memaccess.MemoryRegion {
+		i := sort.Search(len(regions), func(i int) bool {
+			return regions[i].Address+uintptr(regions[i].Size) > address
+		})
+		if i < len(regions) && regions[i].Address <= address {
+			return regions[i]
+		}
+		return memaccess.NoRegionAvailable
+	}
+
+	harderror, serrs := memaccess.WalkBackend(b, 0, uint(DefaultBufferSize), func(address uintptr, buf []byte) bool {
+		// Regions and buffers are aligned, except the start of a walk.
+		size := arch.PointerSize
+		for offset := int(-address) & (size - 1); offset+size <= len(buf); offset += size {
+			value := uintptr(arch.Pointer(buf[offset:]))
+
+			if i := target(value); i != -1 {
+				at := address + uintptr(offset)
+				instances[i].References = append(instances[i].References, Reference{Address: at,
+					Offset: value - instances[i].Match.Address, Region: region(at)})
+			}
+		}
+		return true
+	})
+	softerrors = append(softerrors, serrs...)
+	return harderror, softerrors
+}
@@ -0,0 +1,143 @@
+package memsearch
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+
+	"github.com/polyverse/masche/memaccess"
+	"github.com/polyverse/masche/process"
+)
+
+// MatchStatus tells what Reverify found where a match was.
+type MatchStatus int
+
+const (
+	// StillPresent matches have the same bytes they had when they were found.
+	StillPresent MatchStatus = iota
+	// Changed matches are still readable, but their bytes are different.
+	Changed
+	// Unmapped matches can't be read anymore.
+	Unmapped
+)
+
+func (s MatchStatus) String() string {
+	switch s {
+	case StillPresent:
+		return "still present"
+	case Changed:
+		return "changed"
+	case Unmapped:
+		return "unmapped"
+	}
+	return fmt.Sprintf("MatchStatus(%d)", int(s))
+}
+
+// Reverification is the result of checking a match again.
+type Reverification struct {
+	Match  Match       `json:"match"`
+	Status MatchStatus `json:"status"`
+	// Address is where the match was checked. It's different from Match.Address if its region was found somewhere
+	// else.
+	Address uintptr `json:"address"`
+	// Bytes are the current bytes of Changed matches.
+	Bytes []byte `json:"bytes,omitempty"`
+}
+
+// Reverify checks if matches, previously found in p, are still there, reading only their bytes.
+//
+// If a match in a file backed region can't be read at its address anymore, it's looked for at the same offset of the
+// regions of the same size backed by the same file, in case the file was mapped again somewhere else. Regions only
+// carry their file on Linux.
+//
+// Of the options, only PreferFileReads and Budget are used.
+func Reverify(p process.Process, matches []Match, opts SearchOptions) (results []Reverification, harderror error,
+	softerrors []error) {
+
+	s, harderror := newScanner(processBackend(p), p, nil, opts)
+	if harderror != nil {
+		return nil, harderror, nil
+	}
+	defer s.close()
+	if opts.PreferFileReads {
+		if err := s.readFromFiles(); err != nil {
+			softerrors = append(softerrors, fmt.Errorf("Reading all the memory from the process (%v)", err))
+		}
+	}
+
+	// The regions are only listed if a match has to be relocated.
+	var regions []memaccess.MemoryRegion
+	listed := false
+
+	// Unless they are read from files, the bytes of all the matches are read at once.
+	var batch []memaccess.ReadResult
+	if len(s.files) == 0 {
+		reqs := make([]memaccess.ReadRequest, len(matches))
+		for i, m := range matches {
+			reqs[i] = memaccess.ReadRequest{Address: m.Address, Size: uint(len(m.Bytes))}
+		}
+		var serrs []error
+		batch, _, serrs = memaccess.ReadBackendBatch(s.b, reqs)
+		softerrors = append(softerrors, serrs...)
+	}
+
+	results = make([]Reverification, 0, len(matches))
+	for i, m := range matches {
+		r := Reverification{Match: m, Address: m.Address}
+		current := make([]byte, len(m.Bytes))
+		var err error
+		var serrs []error
+		if batch != nil {
+			err = batch[i].Err
+			copy(current, batch[i].Data)
+		} else {
+			_, err, serrs = s.read(m.Address, current)
+			softerrors = append(softerrors, serrs...)
+		}
+
+		if err != nil && filepath.IsAbs(m.Region.Kind) {
+			if !listed {
+				regions, harderror, serrs = readableRegions(s.b, 0)
+				softerrors = append(softerrors, serrs...)
+				if harderror != nil {
+					return nil, harderror, softerrors
+				}
+				listed = true
+			}
+
+			// Several regions can be backed by the same file, the one that still has the bytes is preferred.
+			candidate := make([]byte, len(m.Bytes))
+			for _, region := range regions {
+				if region.Kind != m.Region.Kind || region.Size != m.Region.Size || region.Address == m.Region.Address {
+					continue
+				}
+				address := region.Address + (m.Address - m.Region.Address)
+				_, rerr, serrs := s.read(address, candidate)
+				softerrors = append(softerrors, serrs...)
+				if rerr != nil {
+					continue
+				}
+				if err != nil || bytes.Equal(candidate, m.Bytes) {
+					err, r.Address = nil, address
+					copy(current, candidate)
+				}
+				if bytes.Equal(candidate, m.Bytes) {
+					break
+				}
+			}
+		}
+
+		switch {
+		case err != nil:
+			r.Status = Unmapped
+		case bytes.Equal(current, m.Bytes):
+			r.Status = StillPresent
+		default:
+			r.Status = Changed
+			r.Bytes = current
+		}
+		results = append(results, r)
+	}
+
+	return results, nil, softerrors
+}
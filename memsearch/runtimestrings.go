@@ -0,0 +1,354 @@
+package memsearch
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/polyverse/masche/memaccess"
+	"github.com/polyverse/masche/process"
+)
+
+// Runtime is a language runtime whose string objects FindRuntimeStrings recognizes.
+type Runtime string
+
+const (
+	// GoRuntime strings are found by their headers: a pointer to their bytes followed by their length.
+	GoRuntime Runtime = "go"
+	// JVMRuntime strings are found by the arrays that hold their value, with the layout of 64 bits JVMs with
+	// compressed class pointers, their default: a mark word, a 32 bits class pointer and a 32 bits length, followed
+	// by the elements. The java.lang.String objects themselves point to them with compressed references, which can't
+	// be followed without the JVM.
+	JVMRuntime Runtime = "jvm"
+)
+
+// Defaults of RuntimeStringOptions.
+const (
+	DefaultRuntimeStringMinLength = 4
+	DefaultRuntimeStringMaxLength = 4096
+)
+
+// runtimeStringBatch is how many candidates are verified with a single batch of reads.
+const runtimeStringBatch = 4096
+
+// RuntimeStringOptions chooses the strings FindRuntimeStrings looks for.
+type RuntimeStringOptions struct {
+	Runtime Runtime
+	// MinLength and MaxLength bound the length in characters of the strings found. If they are zero
+	// DefaultRuntimeStringMinLength and DefaultRuntimeStringMaxLength are used. Short strings are mostly
+	// coincidences.
+	MinLength int
+	MaxLength int
+	// JVMVersion is the feature version of the JVM, like 8 or 17. Up to 8 strings hold UTF-16 char arrays, and from 9
+	// on byte arrays of Latin-1 or UTF-16. If it's zero both layouts are tried.
+	JVMVersion int
+	// MinConfidence drops the strings found with a lower confidence.
+	MinConfidence float64
+}
+
+// RuntimeString is a string object found by FindRuntimeStrings.
+type RuntimeString struct {
+	Runtime Runtime `json:"runtime"`
+	// HeaderAddress is where the object describing the string is: the header of a Go string, or the array holding
+	// the value of a Java string.
+	HeaderAddress uintptr `json:"headerAddress"`
+	// DataAddress is where the characters of the string are.
+	DataAddress uintptr `json:"dataAddress"`
+	Value       string  `json:"value"`
+	// Confidence, between 0 and 1, is how likely it is that the string is a real object of the runtime and not a
+	// coincidence. Strings of printable ASCII, long ones, and the ones whose layout matches the runtime more closely,
+	// like Go strings whose bytes are read only as literals are, get higher confidences.
+	Confidence float64 `json:"confidence"`
+}
+
+// FindRuntimeStrings finds the string objects of a language runtime in the memory of p, with their addresses, which
+// raw byte searches can't tell. It's a heuristic: it walks the aligned words of the memory looking for pairs that
+// describe a string of printable characters in readable memory, and verifies them with batches of reads. Dead
+// strings whose memory wasn't reused yet are found too, and coincidences can't be ruled out, so each string comes
+// with a confidence. They are sorted by header address. Only the layouts of 64 bits little endian processes are
+// known, the search fails with a process.ArchError on the rest.
+func FindRuntimeStrings(p process.Process, opts RuntimeStringOptions) (found []RuntimeString, harderror error,
+	softerrors []error) {
+
+	return FindRuntimeStringsIn(processBackend(p), opts)
+}
+
+// FindRuntimeStringsIn works as FindRuntimeStrings, but it searches any MemoryBackend.
+func FindRuntimeStringsIn(b memaccess.MemoryBackend, opts RuntimeStringOptions) (found []RuntimeString,
+	harderror error, softerrors []error) {
+
+	if opts.Runtime != GoRuntime && opts.Runtime != JVMRuntime {
+		return nil, fmt.Errorf("Unknown runtime %q", opts.Runtime), nil
+	}
+	if opts.MinLength == 0 {
+		opts.MinLength = DefaultRuntimeStringMinLength
+	}
+	if opts.MaxLength == 0 {
+		opts.MaxLength = DefaultRuntimeStringMaxLength
+	}
+	if opts.MinLength < 1 || opts.MaxLength < opts.MinLength {
+		return nil, fmt.Errorf("Invalid string lengths from %d to %d", opts.MinLength, opts.MaxLength), nil
+	}
+
+	target, harderror, softerrors := memaccess.BackendArch(b)
+	if harderror == nil {
+		harderror = process.CheckArch(b.Info().Pid, "The search of runtime strings", target,
+			func(t process.TargetArch) bool { return t.PointerSize == 8 && !t.BigEndian })
+	}
+	if harderror != nil {
+		return nil, harderror, softerrors
+	}
+
+	regions, harderror, serrs := readableRegions(b, 0)
+	softerrors = append(softerrors, serrs...)
+	if harderror != nil {
+		return nil, harderror, softerrors
+	}
+	finder := &runtimeStringFinder{b: b, opts: opts, regions: regions}
+
+	// The pairs of words that straddle two buffers are put together from the last word of the previous one.
+	var previous uint64
+	previousEnd := uintptr(0)
+	harderror, serrs = memaccess.WalkBackend(b, 0, uint(DefaultBufferSize), func(address uintptr, buf []byte) bool {
+		offset := int(-address) & 7
+		if offset == 0 && address == previousEnd && address != 0 && len(buf) >= 8 {
+			finder.pair(address-8, previous, binary.LittleEndian.Uint64(buf))
+		}
+		for ; offset+16 <= len(buf); offset += 8 {
+			finder.pair(address+uintptr(offset), binary.LittleEndian.Uint64(buf[offset:]),
+				binary.LittleEndian.Uint64(buf[offset+8:]))
+		}
+		if offset+8 <= len(buf) {
+			previous, previousEnd = binary.LittleEndian.Uint64(buf[offset:]), address+uintptr(offset+8)
+		}
+		if len(finder.candidates) >= runtimeStringBatch {
+			finder.verify()
+		}
+		return true
+	})
+	softerrors = append(softerrors, serrs...)
+	if harderror != nil {
+		return nil, harderror, softerrors
+	}
+	finder.verify()
+	softerrors = append(softerrors, finder.softerrors...)
+
+	// Retried reads can walk some memory twice.
+	sort.Slice(finder.found, func(i, j int) bool {
+		return finder.found[i].HeaderAddress < finder.found[j].HeaderAddress
+	})
+	found = make([]RuntimeString, 0, len(finder.found))
+	for _, s := range finder.found {
+		if len(found) == 0 || found[len(found)-1].HeaderAddress != s.HeaderAddress {
+			found = append(found, s)
+		}
+	}
+	return found, nil, softerrors
+}
+
+// stringEncoding is how the characters of a candidate are encoded.
+type stringEncoding int
+
+const (
+	utf8Encoding stringEncoding = iota
+	latin1Encoding
+	utf16Encoding
+)
+
+// runtimeStringCandidate is a pair of words that may describe a string, waiting to be verified.
+type runtimeStringCandidate struct {
+	header   uintptr
+	data     uintptr
+	size     uint
+	encoding stringEncoding
+	// readOnly is set if the data is in memory that isn't writable.
+	readOnly bool
+	// plainMark is set for the JVM arrays whose mark word has no hash, age or lock.
+	plainMark bool
+}
+
+// runtimeStringFinder holds the state of a FindRuntimeStrings call.
+type runtimeStringFinder struct {
+	b          memaccess.MemoryBackend
+	opts       RuntimeStringOptions
+	regions    []memaccess.MemoryRegion
+	candidates []runtimeStringCandidate
+	found      []RuntimeString
+	softerrors []error
+}
+
+// region returns the readable region containing address, if any.
+func (f *runtimeStringFinder) region(address uintptr) (memaccess.MemoryRegion, bool) {
+	i := sort.Search(len(f.regions), func(i int) bool {
+		return f.regions[i].Address+uintptr(f.regions[i].Size) > address
+	})
+	if i < len(f.regions) && f.regions[i].Address <= address {
+		return f.regions[i], true
+	}
+	return memaccess.NoRegionAvailable, false
+}
+
+// contains tells if the size bytes at address are in a single readable region, and if that region is writable.
+func (f *runtimeStringFinder) contains(address uintptr, size uint) (ok bool, writable bool) {
+	region, ok := f.region(address)
+	if !ok || address+uintptr(size) < address || address+uintptr(size) > region.Address+uintptr(region.Size) {
+		return false, false
+	}
+	return true, region.Access&memaccess.Writable != 0
+}
+
+// pair looks at the aligned words first and second, the first at address.
+func (f *runtimeStringFinder) pair(address uintptr, first uint64, second uint64) {
+	if f.opts.Runtime == GoRuntime {
+		f.goPair(address, first, second)
+	} else {
+		f.jvmPair(address, first, second)
+	}
+}
+
+// goPair is a Go string header if first points to second bytes of readable memory.
+func (f *runtimeStringFinder) goPair(address uintptr, first uint64, second uint64) {
+	if second < uint64(f.opts.MinLength) || second > uint64(f.opts.MaxLength)*utf8.UTFMax {
+		return
+	}
+	data, size := uintptr(first), uint(second)
+	if uint64(data) != first {
+		return
+	}
+	if ok, writable := f.contains(data, size); ok {
+		f.candidates = append(f.candidates, runtimeStringCandidate{header: address, data: data, size: size,
+			encoding: utf8Encoding, readOnly: !writable})
+	}
+}
+
+// jvmPair is the start of an array if first is an unlocked mark word, and second a class pointer and a length whose
+// elements fit in the region of the array.
+func (f *runtimeStringFinder) jvmPair(address uintptr, first uint64, second uint64) {
+	// Unlocked marks end in 001, and have at most 31 bits of hash over 7 bits of age and flags.
+	if first&7 != 1 || first>>39 != 0 || uint32(second) == 0 {
+		return
+	}
+	length := uint(second >> 32)
+	data := address + 16
+
+	var encodings []stringEncoding
+	switch {
+	case f.opts.JVMVersion == 0:
+		encodings = []stringEncoding{latin1Encoding, utf16Encoding}
+	case f.opts.JVMVersion <= 8:
+		// char[], whose length counts characters.
+		length *= 2
+		encodings = []stringEncoding{utf16Encoding}
+	default:
+		// byte[], whose length counts bytes, of a string encoded with its coder.
+		encodings = []stringEncoding{latin1Encoding, utf16Encoding}
+	}
+	for _, encoding := range encodings {
+		chars := length
+		if encoding == utf16Encoding {
+			chars /= 2
+		}
+		if chars < uint(f.opts.MinLength) || chars > uint(f.opts.MaxLength) {
+			continue
+		}
+		if ok, _ := f.contains(address, 16+length); ok {
+			f.candidates = append(f.candidates, runtimeStringCandidate{header: address, data: data, size: length,
+				encoding: encoding, plainMark: first == 1})
+		}
+	}
+}
+
+// verify reads the candidates, and keeps the strings of printable characters.
+func (f *runtimeStringFinder) verify() {
+	if len(f.candidates) == 0 {
+		return
+	}
+	reqs := make([]memaccess.ReadRequest, len(f.candidates))
+	for i, c := range f.candidates {
+		reqs[i] = memaccess.ReadRequest{Address: c.data, Size: c.size}
+	}
+	results, err, serrs := memaccess.ReadBackendBatch(f.b, reqs)
+	f.softerrors = append(f.softerrors, serrs...)
+	if err != nil {
+		f.softerrors = append(f.softerrors, fmt.Errorf("Unable to verify %d candidate strings (%v)",
+			len(f.candidates), err))
+		f.candidates = f.candidates[:0]
+		return
+	}
+
+	for i, c := range f.candidates {
+		if results[i].Err != nil {
+			continue
+		}
+		value, ascii, ok := decodeRuntimeString(results[i].Data, c.encoding)
+		if !ok {
+			continue
+		}
+		chars := utf8.RuneCountInString(value)
+		if chars < f.opts.MinLength || chars > f.opts.MaxLength {
+			continue
+		}
+		s := RuntimeString{Runtime: f.opts.Runtime, HeaderAddress: c.header, DataAddress: c.data, Value: value,
+			Confidence: runtimeStringConfidence(c, chars, ascii)}
+		if s.Confidence >= f.opts.MinConfidence {
+			f.found = append(f.found, s)
+		}
+	}
+	f.candidates = f.candidates[:0]
+}
+
+// runtimeStringConfidence scores a verified candidate of chars characters. It's counted in tenths so equal scores
+// are equal floats.
+func runtimeStringConfidence(c runtimeStringCandidate, chars int, ascii bool) float64 {
+	tenths := 4
+	if ascii {
+		tenths += 2
+	}
+	if chars >= 8 {
+		tenths += 2
+	}
+	// Go string literals are in the read only data of the executable, and most Java strings were never locked or
+	// hashed by identity.
+	if c.readOnly || c.plainMark {
+		tenths += 2
+	}
+	return float64(tenths) / 10
+}
+
+// decodeRuntimeString decodes data, and tells if it's made of printable characters only, and if they are ASCII.
+func decodeRuntimeString(data []byte, encoding stringEncoding) (value string, ascii bool, ok bool) {
+	var runes []rune
+	switch encoding {
+	case utf8Encoding:
+		if !utf8.Valid(data) {
+			return "", false, false
+		}
+		runes = []rune(string(data))
+	case latin1Encoding:
+		runes = make([]rune, len(data))
+		for i, b := range data {
+			runes[i] = rune(b)
+		}
+	case utf16Encoding:
+		if len(data)%2 != 0 {
+			return "", false, false
+		}
+		units := make([]uint16, len(data)/2)
+		for i := range units {
+			units[i] = binary.LittleEndian.Uint16(data[2*i:])
+		}
+		runes = utf16.Decode(units)
+	}
+
+	ascii = true
+	for _, r := range runes {
+		if r == utf8.RuneError || (!unicode.IsPrint(r) && r != '\t' && r != '\n' && r != '\r') {
+			return "", false, false
+		}
+		ascii = ascii && r < utf8.RuneSelf
+	}
+	return string(runes), ascii, true
+}
@@ -0,0 +1,163 @@
+package memsearch
+
+import (
+	"github.com/polyverse/masche/memaccess"
+)
+
+// Sampling makes FindAll report only a sample of the matches of patterns too common to report them all, while still
+// counting all of them. The sample only depends on the memory and Seed, so scans of the same memory with the same seed
+// report the same matches.
+//
+// Both limits apply to each pattern in each region separately, and can be combined: Every is applied first.
+type Sampling struct {
+	// Every reports one of every Every matches, systematically: the seed picks which one in the first Every matches,
+	// and then every Every-th match after it is reported. Zero or one reports all of them.
+	Every int
+	// PerRegion is the most matches reported. When there are more, a uniform random sample of them is reported.
+	// Zero is unlimited.
+	PerRegion int
+	// Seed chooses the sample.
+	Seed uint64
+}
+
+// RegionOccurrences counts the occurrences of a pattern in a region, and how many of them were sampled.
+type RegionOccurrences struct {
+	Region      memaccess.MemoryRegion `json:"region"`
+	Pattern     int                    `json:"pattern"`
+	Occurrences int                    `json:"occurrences"`
+	Sampled     int                    `json:"sampled"`
+}
+
+// sampleKey is the pseudo random, but deterministic, number that decides the sample. It's a splitmix64 hash of the
+// arguments.
+func sampleKey(seed uint64, address uintptr, pattern int) uint64 {
+	x := seed ^ uint64(address)*0x9e3779b97f4a7c15 ^ uint64(pattern)*0xbf58476d1ce4e5b9
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// keyedMatch is a match kept for the PerRegion sample, the ones with the lowest keys are reported.
+type keyedMatch struct {
+	Match
+	key uint64
+}
+
+// sampler chooses the sample of the region being scanned.
+type sampler struct {
+	opts   Sampling
+	region memaccess.MemoryRegion
+	// occurrences of each pattern in the region, and those that passed the Every filter.
+	occurrences []int
+	systematic  []int
+	// kept are the PerRegion samples of each pattern, as max-heaps by key.
+	kept [][]keyedMatch
+}
+
+func newSampler(opts Sampling, patterns int) *sampler {
+	return &sampler{
+		opts:        opts,
+		occurrences: make([]int, patterns),
+		systematic:  make([]int, patterns),
+		kept:        make([][]keyedMatch, patterns),
+	}
+}
+
+func (s *sampler) startRegion(region memaccess.MemoryRegion) {
+	s.region = region
+	for i := range s.occurrences {
+		s.occurrences[i] = 0
+		s.systematic[i] = 0
+		s.kept[i] = s.kept[i][:0]
+	}
+}
+
+// add decides what to do with an occurrence of the current region: report it right away, keep it in the PerRegion
+// sample, or drop it. If keeping it evicts another match, that one is returned too.
+func (s *sampler) add(m Match) (report bool, keep bool, evicted *Match) {
+	i := m.Pattern
+	ordinal := s.occurrences[i]
+	s.occurrences[i]++
+
+	if every := uint64(s.opts.Every); every > 1 {
+		if uint64(ordinal)%every != sampleKey(s.opts.Seed, s.region.Address, i)%every {
+			return false, false, nil
+		}
+	}
+	s.systematic[i]++
+
+	if s.opts.PerRegion <= 0 {
+		return true, false, nil
+	}
+
+	km := keyedMatch{Match: m, key: sampleKey(s.opts.Seed, m.Address, i)}
+	h := &matchHeap{s.kept[i]}
+	if len(h.matches) < s.opts.PerRegion {
+		h.push(km)
+	} else if km.key < h.matches[0].key {
+		old := h.matches[0].Match
+		evicted = &old
+		h.replaceTop(km)
+	} else {
+		return false, false, nil
+	}
+	s.kept[i] = h.matches
+	return false, true, evicted
+}
+
+// finishRegion returns the PerRegion samples of the region sorted, and the occurrence counts of its patterns.
+func (s *sampler) finishRegion() (matches []Match, counts []RegionOccurrences) {
+	for i, occurrences := range s.occurrences {
+		if occurrences == 0 {
+			continue
+		}
+		sampled := s.systematic[i]
+		if s.opts.PerRegion > 0 {
+			sampled = len(s.kept[i])
+			for _, km := range s.kept[i] {
+				matches = append(matches, km.Match)
+			}
+		}
+		counts = append(counts, RegionOccurrences{Region: s.region, Pattern: i, Occurrences: occurrences,
+			Sampled: sampled})
+	}
+	sortMatches(matches)
+	return matches, counts
+}
+
+// matchHeap is a max-heap of keyed matches. container/heap would need an interface conversion per operation.
+type matchHeap struct {
+	matches []keyedMatch
+}
+
+func (h *matchHeap) push(km keyedMatch) {
+	h.matches = append(h.matches, km)
+	for i := len(h.matches) - 1; i > 0; {
+		parent := (i - 1) / 2
+		if h.matches[parent].key >= h.matches[i].key {
+			break
+		}
+		h.matches[parent], h.matches[i] = h.matches[i], h.matches[parent]
+		i = parent
+	}
+}
+
+func (h *matchHeap) replaceTop(km keyedMatch) {
+	h.matches[0] = km
+	for i := 0; ; {
+		largest := i
+		for _, child := range []int{2*i + 1, 2*i + 2} {
+			if child < len(h.matches) && h.matches[child].key > h.matches[largest].key {
+				largest = child
+			}
+		}
+		if largest == i {
+			return
+		}
+		h.matches[largest], h.matches[i] = h.matches[i], h.matches[largest]
+		i = largest
+	}
+}
@@ -0,0 +1,124 @@
+package memsearch
+
+import (
+	"bytes"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/polyverse/masche/memaccess"
+)
+
+// frequentBackend has a region where "ab" occurs 2048 times, and another with 3 occurrences of it and one of "zz".
+func frequentBackend(t *testing.T) (b memaccess.MemoryBackend, dense, sparse memaccess.MemoryRegion) {
+	dense = memaccess.MemoryRegion{Address: 0x10000, Size: 4096, Access: memaccess.Readable}
+	sparse = memaccess.MemoryRegion{Address: 0x20000, Size: 16, Access: memaccess.Readable}
+	b, err := memaccess.NewStaticBackend(memaccess.BackendInfo{Kind: "static"}, []memaccess.Segment{
+		{Region: dense, Data: bytes.Repeat([]byte("ab"), 2048)},
+		{Region: sparse, Data: []byte("ab..ab..zz..ab..")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b, dense, sparse
+}
+
+var frequentPatterns = []Pattern{{Bytes: []byte("ab")}, {Bytes: []byte("zz")}}
+
+func sampledAddresses(matches []Match, region memaccess.MemoryRegion) (addresses []uintptr) {
+	for _, m := range matches {
+		if m.Region.Address == region.Address {
+			addresses = append(addresses, m.Address)
+		}
+	}
+	return addresses
+}
+
+func TestSamplingEvery(t *testing.T) {
+	b, dense, sparse := frequentBackend(t)
+	sampling := &Sampling{Every: 100, Seed: 7}
+	matches, stats, err, _ := FindAllIn(b, 0, frequentPatterns, SearchOptions{Sampling: sampling})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var expected []uintptr
+	first := sampleKey(7, dense.Address, 0) % 100
+	for ordinal := uint64(0); ordinal < 2048; ordinal++ {
+		if ordinal%100 == first {
+			expected = append(expected, dense.Address+uintptr(2*ordinal))
+		}
+	}
+	if addresses := sampledAddresses(matches, dense); !reflect.DeepEqual(addresses, expected) {
+		t.Errorf("Expected the dense region sample %x, got %x", expected, addresses)
+	}
+
+	if stats.Occurrences != 2048+3+1 || stats.Matches != len(matches) {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+	if len(stats.Sampling) != 3 || stats.Sampling[0] != (RegionOccurrences{Region: dense, Pattern: 0,
+		Occurrences: 2048, Sampled: len(expected)}) || stats.Sampling[1].Region != sparse ||
+		stats.Sampling[1].Occurrences != 3 || stats.Sampling[2].Occurrences != 1 {
+
+		t.Errorf("Unexpected occurrences %+v", stats.Sampling)
+	}
+}
+
+func TestSamplingPerRegion(t *testing.T) {
+	b, dense, sparse := frequentBackend(t)
+	budget := NewMemoryBudget(1 << 20)
+	opts := SearchOptions{Sampling: &Sampling{PerRegion: 10, Seed: 42}, Budget: budget}
+	matches, stats, err, _ := FindAllIn(b, 0, frequentPatterns, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The sample are the occurrences with the lowest keys.
+	var all []keyedMatch
+	for address := dense.Address; address < dense.Address+4096; address += 2 {
+		all = append(all, keyedMatch{Match: Match{Address: address}, key: sampleKey(42, address, 0)})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].key < all[j].key })
+	var expected []uintptr
+	for _, km := range all[:10] {
+		expected = append(expected, km.Address)
+	}
+	sort.Slice(expected, func(i, j int) bool { return expected[i] < expected[j] })
+
+	if addresses := sampledAddresses(matches, dense); !reflect.DeepEqual(addresses, expected) {
+		t.Errorf("Expected the dense region sample %x, got %x", expected, addresses)
+	}
+	// Regions with fewer occurrences report them all.
+	expectedSparse := []uintptr{0x20000, 0x20004, 0x20008, 0x2000c}
+	if addresses := sampledAddresses(matches, sparse); !reflect.DeepEqual(addresses, expectedSparse) {
+		t.Errorf("Expected all the sparse region matches %x, got %x", expectedSparse, addresses)
+	}
+	if stats.Occurrences != 2052 || stats.Matches != 14 || stats.Sampling[0].Sampled != 10 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+	if budget.Used() != 0 {
+		t.Errorf("Expected the evicted matches to be released from the budget, %d bytes are still used", budget.Used())
+	}
+
+	// The sample is reproducible, and depends on the seed.
+	again, _, _, _ := FindAllIn(b, 0, frequentPatterns, opts)
+	if !reflect.DeepEqual(again, matches) {
+		t.Errorf("Expected the same sample with the same seed, got %v and %v", matches, again)
+	}
+	opts.Sampling = &Sampling{PerRegion: 10, Seed: 43}
+	if other, _, _, _ := FindAllIn(b, 0, frequentPatterns, opts); reflect.DeepEqual(other, matches) {
+		t.Error("Expected a different sample with a different seed")
+	}
+
+	// Both limits combined.
+	opts.Sampling = &Sampling{Every: 2, PerRegion: 5, Seed: 42}
+	_, stats, err, _ = FindAllIn(b, 0, frequentPatterns, opts)
+	if err != nil || stats.Sampling[0].Sampled != 5 || stats.Sampling[0].Occurrences != 2048 {
+		t.Errorf("Unexpected stats %+v, error %v", stats, err)
+	}
+
+	if _, _, err, _ := FindAllIn(b, 0, frequentPatterns, SearchOptions{ShortCircuit: PerRegion,
+		Sampling: &Sampling{Every: 2}}); err == nil {
+		t.Error("Expected sampling with a short circuit to fail")
+	}
+}
@@ -0,0 +1,337 @@
+package memsearch
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/polyverse/masche/common"
+	"github.com/polyverse/masche/memaccess"
+	"github.com/polyverse/masche/process"
+)
+
+// SecretType is the kind of a secret found by FindSecrets.
+type SecretType string
+
+const (
+	// PEMPrivateKey is a private key in PEM format, like the ones written by openssl.
+	PEMPrivateKey SecretType = "pem-private-key"
+	// OpenSSHPrivateKey is the binary form of a private key in the format of OpenSSH, as it's kept in memory by
+	// ssh-agent and similar programs once decoded.
+	OpenSSHPrivateKey SecretType = "openssh-private-key"
+	// JWT is a JSON Web Token, whose header has an algorithm.
+	JWT SecretType = "jwt"
+	// AWSAccessKey is the ID of a long term AWS access key. Its secret is usually stored next to it.
+	AWSAccessKey SecretType = "aws-access-key"
+	// BearerToken is a token in an HTTP Authorization header.
+	BearerToken SecretType = "bearer-token"
+)
+
+// Secret is a secret found by FindSecrets.
+type Secret struct {
+	Type SecretType `json:"type"`
+	// Match is the occurrence of the pattern that found the secret, which starts at the beginning of the secret. Its
+	// bytes only identify the type of the secret.
+	Match Match `json:"match"`
+	// Length is the length of the whole secret. It's only as long as could be read for secrets that are longer than
+	// their type allows.
+	Length int `json:"length"`
+	// Preview shows the parts of the secret that identify it, like the header of a private key, and redacts the rest.
+	Preview string `json:"preview"`
+	// Paired is set for AWS access keys that have something that looks like their secret near them.
+	Paired bool `json:"paired,omitempty"`
+}
+
+func (s Secret) String() string {
+	return fmt.Sprintf("%s at %x in process %d: %s", s.Type, s.Match.Address, s.Match.Pid, s.Preview)
+}
+
+// secretKind describes how to find and inspect a type of secret.
+type secretKind struct {
+	typ    SecretType
+	prefix string
+	// context is the amount of bytes around the prefix needed to validate an occurrence, and maxLength the length of
+	// the longest secret of its type.
+	context   int
+	maxLength int
+	// inspect validates the secret that starts at offset in data, and fills its Length, Preview and Paired fields.
+	inspect func(data []byte, offset int) (secret Secret, ok bool)
+}
+
+var secretKinds = []secretKind{
+	{typ: PEMPrivateKey, prefix: "-----BEGIN ", context: 128, maxLength: 16 * 1024, inspect: inspectPEM},
+	{typ: OpenSSHPrivateKey, prefix: "openssh-key-v1\x00", context: 256, maxLength: 32 * 1024,
+		inspect: inspectOpenSSH},
+	{typ: JWT, prefix: "eyJ", context: 4096, maxLength: 8 * 1024, inspect: inspectJWT},
+	{typ: AWSAccessKey, prefix: "AKIA", context: 256, maxLength: 20, inspect: inspectAWSAccessKey},
+	{typ: BearerToken, prefix: "Bearer ", context: 64, maxLength: 4096, inspect: inspectBearerToken},
+}
+
+// SecretTypes returns the types of the secrets found by FindSecrets, in the order of SecretPatterns.
+func SecretTypes() []SecretType {
+	types := make([]SecretType, len(secretKinds))
+	for i, kind := range secretKinds {
+		types[i] = kind.typ
+	}
+	return types
+}
+
+// SecretPatterns returns the patterns FindSecrets searches for, so they can be searched along with others in a single
+// FindAll. The pattern at index i finds the secrets of type SecretTypes()[i]. Their validators keep out the
+// occurrences that don't look like real secrets.
+func SecretPatterns() []Pattern {
+	patterns := make([]Pattern, len(secretKinds))
+	for i, kind := range secretKinds {
+		kind := kind
+		patterns[i] = Pattern{
+			Bytes:   []byte(kind.prefix),
+			Context: kind.context,
+			Validator: func(p process.Process, m Match, surrounding []byte) bool {
+				_, ok := kind.inspect(surrounding, ContextOffset(m, kind.context))
+				return ok
+			},
+		}
+	}
+	return patterns
+}
+
+// FindSecrets finds the common secrets in the readable memory of p at or after address: private keys, JWTs, AWS
+// access keys and bearer tokens. It works as FindAll with SecretPatterns, and then reads each secret whole to describe
+// it. The secrets that change before they are read again are reported as softerrors.
+func FindSecrets(p process.Process, address uintptr, opts SearchOptions) (secrets []Secret, stats ScanStats,
+	harderror error, softerrors []error) {
+
+	matches, stats, harderror, softerrors := FindAll(p, address, SecretPatterns(), opts)
+	if harderror != nil {
+		return nil, stats, harderror, softerrors
+	}
+	secrets, serrs := describeSecrets(processBackend(p), matches)
+	softerrors = append(softerrors, serrs...)
+	common.SortSoftErrors(softerrors)
+	return secrets, stats, nil, softerrors
+}
+
+// FindSecretsIn works as FindSecrets, but it searches the memory of any MemoryBackend.
+func FindSecretsIn(b memaccess.MemoryBackend, address uintptr, opts SearchOptions) (secrets []Secret,
+	stats ScanStats, harderror error, softerrors []error) {
+
+	matches, stats, harderror, softerrors := FindAllIn(b, address, SecretPatterns(), opts)
+	if harderror != nil {
+		return nil, stats, harderror, softerrors
+	}
+	secrets, serrs := describeSecrets(b, matches)
+	softerrors = append(softerrors, serrs...)
+	common.SortSoftErrors(softerrors)
+	return secrets, stats, nil, softerrors
+}
+
+// describeSecrets reads the secrets found by matches of SecretPatterns, with their context, and inspects them again.
+func describeSecrets(b memaccess.MemoryBackend, matches []Match) (secrets []Secret, softerrors []error) {
+	reqs := make([]memaccess.ReadRequest, len(matches))
+	offsets := make([]int, len(matches))
+	for i, m := range matches {
+		kind := secretKinds[m.Pattern]
+		start := m.Address - uintptr(ContextOffset(m, kind.context))
+		after := kind.maxLength
+		if kind.context > after {
+			after = kind.context
+		}
+		end := m.Address + uintptr(len(m.Bytes)+after)
+		if regionEnd := m.Region.Address + uintptr(m.Region.Size); end > regionEnd || end < m.Address {
+			end = regionEnd
+		}
+		reqs[i] = memaccess.ReadRequest{Address: start, Size: uint(end - start)}
+		offsets[i] = int(m.Address - start)
+	}
+
+	results, harderror, softerrors := memaccess.ReadBackendBatch(b, reqs)
+	if harderror != nil {
+		return nil, append(softerrors, fmt.Errorf("Unable to read the secrets found (%v)", harderror))
+	}
+	for i, m := range matches {
+		if results[i].Err != nil {
+			softerrors = append(softerrors, &common.LocatedError{Pid: m.Pid, Address: m.Address,
+				Err: fmt.Errorf("Unable to read the secret at %x: %v", m.Address, results[i].Err)})
+			continue
+		}
+		kind := secretKinds[m.Pattern]
+		secret, ok := kind.inspect(results[i].Data, offsets[i])
+		if !ok {
+			softerrors = append(softerrors, &common.LocatedError{Pid: m.Pid, Address: m.Address,
+				Err: fmt.Errorf("The %s at %x changed before it could be read", kind.typ, m.Address)})
+			continue
+		}
+		secret.Type, secret.Match = kind.typ, m
+		secrets = append(secrets, secret)
+	}
+	return secrets, softerrors
+}
+
+// redacted returns a preview that shows visible, and only the length of the rest of a secret.
+func redacted(visible string, hidden int) string {
+	return fmt.Sprintf("%s[%d bytes redacted]", visible, hidden)
+}
+
+// run returns the length of the prefix of data made of the bytes in set.
+func run(data []byte, set string) int {
+	for i, c := range data {
+		if strings.IndexByte(set, c) == -1 {
+			return i
+		}
+	}
+	return len(data)
+}
+
+const (
+	base64Chars    = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+	base64URLChars = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_"
+)
+
+var pemHeader = regexp.MustCompile(`^-----BEGIN ((?:RSA |EC |DSA |OPENSSH |ENCRYPTED )?PRIVATE KEY)-----\r?\n`)
+
+// inspectPEM accepts the headers of private keys followed by some base64.
+func inspectPEM(data []byte, offset int) (secret Secret, ok bool) {
+	data = data[offset:]
+	header := pemHeader.FindSubmatch(data)
+	if header == nil {
+		return secret, false
+	}
+	body := data[len(header[0]):]
+	n := run(body, base64Chars+"=\r\n")
+	if n < 16 {
+		return secret, false
+	}
+	secret.Length = len(header[0]) + n
+	if footer := "-----END " + string(header[1]) + "-----"; bytes.HasPrefix(body[n:], []byte(footer)) {
+		secret.Length += len(footer)
+	}
+	secret.Preview = redacted(string(bytes.TrimSpace(header[0]))+" ", secret.Length-len(header[0]))
+	return secret, true
+}
+
+// inspectOpenSSH accepts the keys whose cipher and kdf names are printable, followed by a plausible amount of keys.
+func inspectOpenSSH(data []byte, offset int) (secret Secret, ok bool) {
+	data = data[offset:]
+	pos := len("openssh-key-v1\x00")
+	str := func(max int) ([]byte, bool) {
+		if len(data) < pos+4 {
+			return nil, false
+		}
+		n := int(binary.BigEndian.Uint32(data[pos:]))
+		if n > max || len(data) < pos+4+n {
+			return nil, false
+		}
+		s := data[pos+4 : pos+4+n]
+		pos += 4 + n
+		return s, true
+	}
+	printable := func(s []byte) bool {
+		return len(s) > 0 && run(s, base64URLChars+"@.") == len(s)
+	}
+
+	cipher, ok := str(64)
+	if !ok || !printable(cipher) {
+		return secret, false
+	}
+	kdf, ok := str(64)
+	if !ok || !printable(kdf) {
+		return secret, false
+	}
+	if _, ok := str(1024); !ok || len(data) < pos+4 {
+		return secret, false
+	}
+	keys := int(binary.BigEndian.Uint32(data[pos:]))
+	if keys < 1 || keys > 16 {
+		return secret, false
+	}
+	pos += 4
+
+	// The keys may be longer than the context given to validators; the length is what could be read.
+	complete := true
+	for i := 0; i <= keys && complete; i++ {
+		_, complete = str(32 * 1024)
+	}
+	secret.Length = pos
+	if !complete {
+		secret.Length = len(data)
+	}
+	visible := fmt.Sprintf("openssh-key-v1 cipher=%s kdf=%s ", cipher, kdf)
+	secret.Preview = redacted(visible, secret.Length-len("openssh-key-v1\x00"))
+	return secret, true
+}
+
+// inspectJWT accepts three base64url segments, the first of which decodes to a JSON object with an algorithm, and the
+// second to a JSON object. The signature can only be empty if the algorithm is "none".
+func inspectJWT(data []byte, offset int) (secret Secret, ok bool) {
+	data = data[offset:]
+	var segments [3][]byte
+	pos := 0
+	for i := range segments {
+		n := run(data[pos:], base64URLChars)
+		segments[i] = data[pos : pos+n]
+		pos += n
+		if i < 2 {
+			if pos == len(data) || data[pos] != '.' {
+				return secret, false
+			}
+			pos++
+		}
+	}
+
+	var header struct {
+		Alg *string `json:"alg"`
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(string(segments[0]))
+	if err != nil || json.Unmarshal(decoded, &header) != nil || header.Alg == nil {
+		return secret, false
+	}
+	var claims map[string]interface{}
+	decoded, err = base64.RawURLEncoding.DecodeString(string(segments[1]))
+	if err != nil || json.Unmarshal(decoded, &claims) != nil {
+		return secret, false
+	}
+	if len(segments[2]) == 0 && *header.Alg != "none" {
+		return secret, false
+	}
+
+	secret.Length = pos
+	secret.Preview = redacted(string(segments[0])+".", pos-len(segments[0])-1)
+	return secret, true
+}
+
+var awsSecretKey = regexp.MustCompile(`(?:^|[^A-Za-z0-9/+])[A-Za-z0-9/+]{40}(?:$|[^A-Za-z0-9/+])`)
+
+// inspectAWSAccessKey accepts AKIA followed by 16 characters of the base32 alphabet, as a whole word. It's paired if
+// there's a word of 40 base64 characters, like its secret, around it.
+func inspectAWSAccessKey(data []byte, offset int) (secret Secret, ok bool) {
+	const alphanumeric = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+	id := data[offset:]
+	if len(id) < 20 || run(id[4:20], "ABCDEFGHIJKLMNOPQRSTUVWXYZ234567") != 16 {
+		return secret, false
+	}
+	if len(id) > 20 && run(id[20:21], alphanumeric) == 1 || offset > 0 && run(data[offset-1:offset], alphanumeric) == 1 {
+		return secret, false
+	}
+
+	secret.Length = 20
+	secret.Preview = redacted("AKIA", 16)
+	secret.Paired = awsSecretKey.Match(data)
+	return secret, true
+}
+
+// inspectBearerToken accepts tokens of at least 16 characters, in the syntax of RFC 6750.
+func inspectBearerToken(data []byte, offset int) (secret Secret, ok bool) {
+	token := data[offset+len("Bearer "):]
+	n := run(token, base64URLChars+".~+/")
+	n += run(token[n:], "=")
+	if n < 16 {
+		return secret, false
+	}
+	secret.Length = len("Bearer ") + n
+	secret.Preview = redacted("Bearer ", n)
+	return secret, true
+}
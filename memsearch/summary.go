@@ -0,0 +1,210 @@
+package memsearch
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/polyverse/masche/memaccess"
+)
+
+// Cluster is a range of the memory of a process with matches no further than the clustering distance from each other.
+type Cluster struct {
+	Pid   int     `json:"pid"`
+	Start uintptr `json:"start"`
+	// End is the address right after the last byte of the last match.
+	End   uintptr `json:"end"`
+	Count int     `json:"count"`
+}
+
+func (c Cluster) String() string {
+	return fmt.Sprintf("Cluster[pid %d, %x-%x, %d matches]", c.Pid, c.Start, c.End, c.Count)
+}
+
+// Clusterer groups matches into clusters as they are found. Matches must be added sorted by pid and then by address,
+// like FindAll returns them.
+type Clusterer struct {
+	distance uintptr
+	current  Cluster
+}
+
+// NewClusterer returns a Clusterer that puts in the same cluster matches that start at most distance bytes after the
+// end of the previous one.
+func NewClusterer(distance uintptr) *Clusterer {
+	return &Clusterer{distance: distance}
+}
+
+// Add adds m to the running cluster. If m is too far from it, or in another process, the running cluster is returned
+// with done set to true and m starts a new one.
+func (c *Clusterer) Add(m Match) (finished Cluster, done bool) {
+	end := m.Address + uintptr(len(m.Bytes))
+	far := m.Address >= c.current.End && m.Address-c.current.End > c.distance
+	if c.current.Count > 0 && (m.Pid != c.current.Pid || far) {
+		finished, done = c.current, true
+		c.current = Cluster{}
+	}
+
+	if c.current.Count == 0 {
+		c.current.Pid, c.current.Start = m.Pid, m.Address
+	}
+	if end > c.current.End {
+		c.current.End = end
+	}
+	c.current.Count++
+	return finished, done
+}
+
+// Flush returns the running cluster, if any, and starts over.
+func (c *Clusterer) Flush() (finished Cluster, done bool) {
+	finished, done = c.current, c.current.Count > 0
+	c.current = Cluster{}
+	return finished, done
+}
+
+// ClusterMatches groups sorted matches into clusters, see NewClusterer.
+func ClusterMatches(matches []Match, distance uintptr) (clusters []Cluster) {
+	c := NewClusterer(distance)
+	for _, m := range matches {
+		if cluster, done := c.Add(m); done {
+			clusters = append(clusters, cluster)
+		}
+	}
+	if cluster, done := c.Flush(); done {
+		clusters = append(clusters, cluster)
+	}
+	return clusters
+}
+
+// RegionSummary counts the matches in a memory region of a process.
+type RegionSummary struct {
+	Pid     int                    `json:"pid"`
+	Region  memaccess.MemoryRegion `json:"region"`
+	Matches int                    `json:"matches"`
+	// Patterns is the amount of matches of each pattern, by pattern index.
+	Patterns map[int]int `json:"patterns"`
+}
+
+// ModuleSummary counts the matches in the regions of the same kind, which is the mapped file for file backed
+// regions.
+type ModuleSummary struct {
+	Module  string `json:"module"`
+	Regions int    `json:"regions"`
+	Matches int    `json:"matches"`
+}
+
+// SummarizeRegions counts the matches in each region, and returns the counts sorted by pid and region address.
+func SummarizeRegions(matches []Match) (summaries []RegionSummary) {
+	type regionKey struct {
+		pid     int
+		address uintptr
+	}
+	index := make(map[regionKey]int)
+	for _, m := range matches {
+		key := regionKey{m.Pid, m.Region.Address}
+		i, ok := index[key]
+		if !ok {
+			i = len(summaries)
+			index[key] = i
+			summaries = append(summaries, RegionSummary{Pid: m.Pid, Region: m.Region, Patterns: make(map[int]int)})
+		}
+		summaries[i].Matches++
+		summaries[i].Patterns[m.Pattern]++
+	}
+
+	sort.SliceStable(summaries, func(i, j int) bool {
+		if summaries[i].Pid != summaries[j].Pid {
+			return summaries[i].Pid < summaries[j].Pid
+		}
+		return summaries[i].Region.Address < summaries[j].Region.Address
+	})
+	return summaries
+}
+
+// SummarizeModules groups region summaries by module, in the order each module first appears. The regions of every
+// process mapping a module count for it.
+func SummarizeModules(regions []RegionSummary) (summaries []ModuleSummary) {
+	index := make(map[string]int)
+	for _, region := range regions {
+		i, ok := index[region.Region.Kind]
+		if !ok {
+			i = len(summaries)
+			index[region.Region.Kind] = i
+			summaries = append(summaries, ModuleSummary{Module: region.Region.Kind})
+		}
+		summaries[i].Regions++
+		summaries[i].Matches += region.Matches
+	}
+	return summaries
+}
+
+// TopRegions returns the n regions with the most matches, in decreasing order. Regions with the same amount of matches
+// keep their order.
+func TopRegions(regions []RegionSummary, n int) []RegionSummary {
+	top := append([]RegionSummary(nil), regions...)
+	sort.SliceStable(top, func(i, j int) bool { return top[i].Matches > top[j].Matches })
+	if n >= 0 && n < len(top) {
+		top = top[:n]
+	}
+	return top
+}
+
+// SummaryOptions configures the HitSummary of a scan.
+type SummaryOptions struct {
+	// ClusterDistance is the maximum gap between matches of the same cluster.
+	ClusterDistance uintptr
+	// TopN is the amount of regions in HitSummary.Top. If it's zero DefaultTopN is used.
+	TopN int
+}
+
+// DefaultTopN is the amount of top regions in a HitSummary when the options don't specify it.
+const DefaultTopN = 10
+
+// HitSummary condenses many matches, of one process or of many, into clusters and counts per region and module.
+type HitSummary struct {
+	Matches  int             `json:"matches"`
+	Clusters []Cluster       `json:"clusters"`
+	Regions  []RegionSummary `json:"regions"`
+	Modules  []ModuleSummary `json:"modules"`
+	// Top are the regions with the most matches.
+	Top []RegionSummary `json:"top"`
+}
+
+// Summarize builds the HitSummary of matches sorted by pid and then by address, of one process or of many.
+func Summarize(matches []Match, opts SummaryOptions) HitSummary {
+	topN := opts.TopN
+	if topN == 0 {
+		topN = DefaultTopN
+	}
+
+	regions := SummarizeRegions(matches)
+	return HitSummary{
+		Matches:  len(matches),
+		Clusters: ClusterMatches(matches, opts.ClusterDistance),
+		Regions:  regions,
+		Modules:  SummarizeModules(regions),
+		Top:      TopRegions(regions, topN),
+	}
+}
+
+// String returns the summary as a text table.
+func (s HitSummary) String() string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%d matches in %d clusters, %d regions and %d modules\n", s.Matches, len(s.Clusters),
+		len(s.Regions), len(s.Modules))
+
+	fmt.Fprintf(&b, "Top regions:\n")
+	for _, region := range s.Top {
+		fmt.Fprintf(&b, "  %8d  pid %d %x-%x %v %s\n", region.Matches, region.Pid, region.Region.Address,
+			region.Region.Address+uintptr(region.Region.Size), region.Region.Access, region.Region.Kind)
+	}
+
+	fmt.Fprintf(&b, "Modules:\n")
+	for _, module := range s.Modules {
+		name := module.Module
+		if name == "" {
+			name = "[anonymous]"
+		}
+		fmt.Fprintf(&b, "  %8d  %s (%d regions)\n", module.Matches, name, module.Regions)
+	}
+	return b.String()
+}
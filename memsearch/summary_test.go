@@ -0,0 +1,111 @@
+package memsearch
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/polyverse/masche/memaccess"
+)
+
+var (
+	heapRegion = memaccess.MemoryRegion{Address: 0x1000, Size: 0x1000, Access: memaccess.Readable, Kind: "[heap]"}
+	libRegion  = memaccess.MemoryRegion{Address: 0x8000, Size: 0x1000, Access: memaccess.Readable, Kind: "/lib/a.so"}
+	lib2Region = memaccess.MemoryRegion{Address: 0x9000, Size: 0x1000, Access: memaccess.Readable, Kind: "/lib/a.so"}
+)
+
+func syntheticMatch(address uintptr, pattern int, region memaccess.MemoryRegion) Match {
+	return Match{Address: address, Pattern: pattern, Bytes: []byte("abcd"), Region: region}
+}
+
+func TestClusterMatches(t *testing.T) {
+	// A dense run every 8 bytes, an overlapping pair, and sparse matches.
+	var matches []Match
+	for address := uintptr(0x1000); address < 0x1100; address += 8 {
+		matches = append(matches, syntheticMatch(address, 0, heapRegion))
+	}
+	matches = append(matches, syntheticMatch(0x1200, 0, heapRegion), syntheticMatch(0x1202, 1, heapRegion),
+		syntheticMatch(0x8000, 0, libRegion), syntheticMatch(0x9000, 0, lib2Region))
+
+	expected := []Cluster{{0, 0x1000, 0x10fc, 32}, {0, 0x1200, 0x1206, 2}, {0, 0x8000, 0x8004, 1}, {0, 0x9000, 0x9004, 1}}
+	if clusters := ClusterMatches(matches, 4); !reflect.DeepEqual(clusters, expected) {
+		t.Errorf("Expected clusters %v, got %v", expected, clusters)
+	}
+
+	// Matches exactly distance bytes apart are in the same cluster.
+	sparse := matches[len(matches)-2:]
+	expected = []Cluster{{0, 0x8000, 0x9004, 2}}
+	if clusters := ClusterMatches(sparse, 0x9000-0x8004); !reflect.DeepEqual(clusters, expected) {
+		t.Errorf("Expected clusters %v, got %v", expected, clusters)
+	}
+	if clusters := ClusterMatches(sparse, 0x9000-0x8004-1); len(clusters) != 2 {
+		t.Errorf("Expected 2 clusters, got %v", clusters)
+	}
+
+	// A distance too small for the dense run splits it in single matches.
+	if clusters := ClusterMatches(matches, 3); len(clusters) != 35 {
+		t.Errorf("Expected 35 clusters, got %d", len(clusters))
+	}
+
+	if clusters := ClusterMatches(nil, 4); clusters != nil {
+		t.Errorf("Expected no clusters without matches, got %v", clusters)
+	}
+
+	// The matches of different processes are never in the same cluster, even at the same address.
+	other := syntheticMatch(0x1000, 0, heapRegion)
+	other.Pid = 2
+	expected = []Cluster{{0, 0x1000, 0x1004, 1}, {2, 0x1000, 0x1004, 1}}
+	if clusters := ClusterMatches([]Match{matches[0], other}, 4); !reflect.DeepEqual(clusters, expected) {
+		t.Errorf("Expected clusters %v, got %v", expected, clusters)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	matches := []Match{
+		syntheticMatch(0x1000, 0, heapRegion),
+		syntheticMatch(0x1010, 1, heapRegion),
+		syntheticMatch(0x8000, 0, libRegion),
+		syntheticMatch(0x9000, 0, lib2Region),
+		syntheticMatch(0x9100, 0, lib2Region),
+		syntheticMatch(0x9200, 1, lib2Region),
+	}
+
+	summary := Summarize(matches, SummaryOptions{ClusterDistance: 0x10, TopN: 2})
+	if summary.Matches != 6 || len(summary.Clusters) != 5 {
+		t.Errorf("Unexpected summary %+v", summary)
+	}
+
+	expectedRegions := []RegionSummary{
+		{Region: heapRegion, Matches: 2, Patterns: map[int]int{0: 1, 1: 1}},
+		{Region: libRegion, Matches: 1, Patterns: map[int]int{0: 1}},
+		{Region: lib2Region, Matches: 3, Patterns: map[int]int{0: 2, 1: 1}},
+	}
+	if !reflect.DeepEqual(summary.Regions, expectedRegions) {
+		t.Errorf("Expected regions %v, got %v", expectedRegions, summary.Regions)
+	}
+
+	expectedModules := []ModuleSummary{{Module: "[heap]", Regions: 1, Matches: 2},
+		{Module: "/lib/a.so", Regions: 2, Matches: 4}}
+	if !reflect.DeepEqual(summary.Modules, expectedModules) {
+		t.Errorf("Expected modules %v, got %v", expectedModules, summary.Modules)
+	}
+
+	// The heap and the first library region tie after the second one.
+	expectedTop := []RegionSummary{expectedRegions[2], expectedRegions[0]}
+	if !reflect.DeepEqual(summary.Top, expectedTop) {
+		t.Errorf("Expected top regions %v, got %v", expectedTop, summary.Top)
+	}
+
+	// The same region of another process is counted apart, but for the same module.
+	other := syntheticMatch(0x8000, 1, libRegion)
+	other.Pid = 2
+	summary = Summarize(append(matches, other), SummaryOptions{})
+	expectedRegions = append(expectedRegions, RegionSummary{Pid: 2, Region: libRegion, Matches: 1,
+		Patterns: map[int]int{1: 1}})
+	if !reflect.DeepEqual(summary.Regions, expectedRegions) {
+		t.Errorf("Expected regions %v, got %v", expectedRegions, summary.Regions)
+	}
+	expectedModules[1] = ModuleSummary{Module: "/lib/a.so", Regions: 3, Matches: 5}
+	if !reflect.DeepEqual(summary.Modules, expectedModules) {
+		t.Errorf("Expected modules %v, got %v", expectedModules, summary.Modules)
+	}
+}
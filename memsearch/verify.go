@@ -0,0 +1,56 @@
+package memsearch
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/polyverse/masche/memaccess"
+)
+
+// verify reads the bytes of the matches again, all at once and straight from the memory, and drops the matches that
+// don't have them anymore. Walk buffers can hold bytes that changed while the rest of the region was being read, so
+// this keeps them from becoming false positives. If the memory can't be read at all the matches are kept, and the
+// error is returned as a softerror.
+func (s *scanner) verify() (softerrors []error) {
+	var reqs []memaccess.ReadRequest
+	var owners []int
+	for i, m := range s.matches {
+		if s.fromFile(m) {
+			continue
+		}
+		reqs = append(reqs, memaccess.ReadRequest{Address: m.Address, Size: uint(len(m.Bytes))})
+		owners = append(owners, i)
+	}
+	if len(reqs) == 0 {
+		return nil
+	}
+
+	results, harderror, softerrors := memaccess.ReadBackendBatch(s.b, reqs)
+	if harderror != nil {
+		return append(softerrors, fmt.Errorf("Unable to verify the matches (%v)", harderror))
+	}
+	dropped := make(map[int]bool)
+	for i, result := range results {
+		if result.Err != nil || !bytes.Equal(result.Data, s.matches[owners[i]].Bytes) {
+			dropped[owners[i]] = true
+		}
+	}
+
+	verified := s.matches[:0]
+	for i, m := range s.matches {
+		if !dropped[i] {
+			verified = append(verified, m)
+		}
+	}
+	s.matches = verified
+	s.stats.Unverified += len(dropped)
+	return softerrors
+}
+
+// fromFile tells if m was read from a mapped file.
+func (s *scanner) fromFile(m Match) bool {
+	i := sort.Search(len(s.files), func(i int) bool { return s.files[i].end > m.Address })
+	return i < len(s.files) && s.files[i].start <= m.Address && m.Address+uintptr(len(m.Bytes)) <= s.files[i].end &&
+		s.files[i].err == nil
+}
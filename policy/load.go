@@ -0,0 +1,268 @@
+package policy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// ParseError is a problem with a rules document, at a position of it.
+type ParseError struct {
+	// Line and Column are the position of the problem, starting at 1. Columns count bytes.
+	Line   int
+	Column int
+	// Path is the path to the offending value, like rules[2].cmdline[0], if the problem is about a value.
+	Path    string
+	Message string
+}
+
+func (e *ParseError) Error() string {
+	if e.Path != "" {
+		return fmt.Sprintf("line %d, column %d: %s: %s", e.Line, e.Column, e.Path, e.Message)
+	}
+	return fmt.Sprintf("line %d, column %d: %s", e.Line, e.Column, e.Message)
+}
+
+// LoadRules reads and parses the rules document at path. See ParseRules.
+func LoadRules(path string) (rules []Rule, err error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseRules(data)
+}
+
+// ParseRules parses a rules document, which is a JSON object with the list of rules:
+//
+//	{
+//		"rules": [
+//			{"name": "outside-system-dirs", "exeNotIn": ["/usr/**", "/bin/*", "/sbin/*"]},
+//			{"name": "debuggable-root", "uids": [{"min": 0, "max": 0}], "cmdline": ["--inspect(-brk)?\\b"]}
+//		]
+//	}
+//
+// Unknown fields are errors, as are the rules Evaluate would reject and rules with the same name. Errors are of type
+// *ParseError.
+func ParseRules(data []byte) (rules []Rule, err error) {
+	p := &documentParser{data: data, dec: json.NewDecoder(bytes.NewReader(data))}
+
+	if err := p.expectDelim('{', "a rules document must be an object"); err != nil {
+		return nil, err
+	}
+	found := false
+	for p.dec.More() {
+		key, err := p.key()
+		if err != nil {
+			return nil, err
+		}
+		if key != "rules" {
+			return nil, p.errorAt(p.lastOffset, "", fmt.Sprintf("unknown field %q, the only field is \"rules\"", key))
+		}
+		if found {
+			return nil, p.errorAt(p.lastOffset, "", "duplicate field \"rules\"")
+		}
+		found = true
+		if rules, err = p.rules(); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := p.token(); err != nil {
+		return nil, err
+	}
+	trailing := p.valueStart(p.dec.InputOffset())
+	if _, err := p.dec.Token(); err != io.EOF {
+		return nil, p.errorAt(trailing, "", "unexpected data after the rules document")
+	}
+	if !found {
+		return nil, p.errorAt(0, "", "missing field \"rules\"")
+	}
+	return rules, nil
+}
+
+// documentParser keeps the position in the document being parsed.
+type documentParser struct {
+	data []byte
+	dec  *json.Decoder
+	// lastOffset is the start of the last token read.
+	lastOffset int64
+}
+
+func (p *documentParser) token() (json.Token, error) {
+	p.lastOffset = p.valueStart(p.dec.InputOffset())
+	t, err := p.dec.Token()
+	if err != nil {
+		return nil, p.jsonError(err, 0, "")
+	}
+	return t, nil
+}
+
+func (p *documentParser) expectDelim(delim json.Delim, message string) error {
+	t, err := p.token()
+	if err != nil {
+		return err
+	}
+	if t != delim {
+		return p.errorAt(p.lastOffset, "", message)
+	}
+	return nil
+}
+
+func (p *documentParser) key() (string, error) {
+	t, err := p.token()
+	if err != nil {
+		return "", err
+	}
+	return t.(string), nil
+}
+
+// rules parses the list of rules, and validates each one.
+func (p *documentParser) rules() (rules []Rule, err error) {
+	if err := p.expectDelim('[', "rules must be a list"); err != nil {
+		return nil, err
+	}
+	names := map[string]bool{}
+	for i := 0; p.dec.More(); i++ {
+		path := fmt.Sprintf("rules[%d]", i)
+		start := p.valueStart(p.dec.InputOffset())
+		var raw json.RawMessage
+		if err := p.dec.Decode(&raw); err != nil {
+			return nil, p.jsonError(err, 0, path)
+		}
+		if len(raw) == 0 || raw[0] != '{' {
+			return nil, p.errorAt(start, path, "a rule must be an object")
+		}
+
+		var rule Rule
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&rule); err != nil {
+			return nil, p.jsonError(err, start, path)
+		}
+		if _, err := compile(rule); err != nil {
+			re := err.(*ruleError)
+			if re.Path == "" {
+				return nil, p.errorAt(start, path, re.Message)
+			}
+			return nil, p.errorAt(start+locate(raw, re.Path), path+"."+re.Path, re.Message)
+		}
+		if names[rule.Name] {
+			return nil, p.errorAt(start+locate(raw, "name"), path+".name",
+				fmt.Sprintf("there is already a rule named %q", rule.Name))
+		}
+		names[rule.Name] = true
+		rules = append(rules, rule)
+	}
+	if _, err := p.token(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// valueStart skips the whitespace and separators at offset, which is where the decoder stopped, to the start of the
+// next value.
+func (p *documentParser) valueStart(offset int64) int64 {
+	return skipSeparators(p.data, offset)
+}
+
+// jsonError converts an error of encoding/json into a *ParseError. Its offsets are relative to base, which is the start
+// of the rule at path when decoding one.
+func (p *documentParser) jsonError(err error, base int64, path string) error {
+	var syntaxError *json.SyntaxError
+	var typeError *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxError):
+		// The offset is after the offending byte, unless the document ended too soon.
+		offset := base + syntaxError.Offset
+		if offset < int64(len(p.data)) {
+			offset--
+		}
+		return p.errorAt(offset, "", syntaxError.Error())
+	case errors.As(err, &typeError):
+		// The offset is after the offending value, whose start is only found for the fields of the rule.
+		offset := base + typeError.Offset
+		if typeError.Field != "" && !strings.Contains(typeError.Field, ".") {
+			offset = base + locate(p.data[base:], typeError.Field)
+		}
+		if typeError.Field != "" {
+			path += "." + typeError.Field
+		}
+		return p.errorAt(offset, path, fmt.Sprintf("expected %v, found %s", typeError.Type, typeError.Value))
+	case err == io.EOF || err == io.ErrUnexpectedEOF:
+		return p.errorAt(int64(len(p.data)), "", "unexpected end of the document")
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field, _ := strconv.Unquote(strings.TrimPrefix(err.Error(), "json: unknown field "))
+		return p.errorAt(base, path, fmt.Sprintf("unknown field %q", field))
+	}
+	return p.errorAt(base, path, err.Error())
+}
+
+// errorAt returns a *ParseError at the given offset of the document.
+func (p *documentParser) errorAt(offset int64, path string, message string) *ParseError {
+	if offset > int64(len(p.data)) {
+		offset = int64(len(p.data))
+	}
+	before := p.data[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := len(before) - bytes.LastIndexByte(before, '\n')
+	return &ParseError{Line: line, Column: column, Path: path, Message: message}
+}
+
+// locate returns the offset in the object raw of the value at path, which is a field, optionally followed by an
+// index, like cmdline[1]. It returns 0, the start of the object, if the value isn't found.
+func locate(raw []byte, path string) int64 {
+	field, index := path, -1
+	if i := strings.IndexByte(path, '['); i != -1 {
+		field = path[:i]
+		index, _ = strconv.Atoi(strings.TrimSuffix(path[i+1:], "]"))
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if _, err := dec.Token(); err != nil {
+		return 0
+	}
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return 0
+		}
+		if t != field {
+			var skipped json.RawMessage
+			if dec.Decode(&skipped) != nil {
+				return 0
+			}
+			continue
+		}
+
+		start := skipSeparators(raw, dec.InputOffset())
+		if index < 0 {
+			return start
+		}
+		if t, err := dec.Token(); err != nil || t != json.Delim('[') {
+			return start
+		}
+		for i := 0; dec.More(); i++ {
+			if i == index {
+				return skipSeparators(raw, dec.InputOffset())
+			}
+			var skipped json.RawMessage
+			if dec.Decode(&skipped) != nil {
+				break
+			}
+		}
+		return start
+	}
+	return 0
+}
+
+// skipSeparators returns the offset of the first byte at or after offset that isn't whitespace or a separator.
+func skipSeparators(raw []byte, offset int64) int64 {
+	for offset < int64(len(raw)) && strings.IndexByte(" \t\r\n,:", raw[offset]) != -1 {
+		offset++
+	}
+	return offset
+}
@@ -0,0 +1,385 @@
+// Package policy finds the processes that break rules about what may run, like "no process runs an executable outside
+// of these directories" or "no process has this library loaded".
+package policy
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/polyverse/masche/common"
+	"github.com/polyverse/masche/listlibs"
+	"github.com/polyverse/masche/process"
+)
+
+// Rule describes processes that shouldn't exist. A process breaks a rule if it meets all of its conditions, and a rule
+// needs at least one condition.
+//
+// Exe globs are matched against the whole path of the executable. They are path.Match patterns, where an element
+// "**" also matches any amount of directories: "/usr/**" matches every file under /usr.
+type Rule struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+
+	// Exe is met if the executable matches any of the globs.
+	Exe []string `json:"exe,omitempty"`
+	// ExeNotIn is met if the executable matches none of the globs, which makes it an allowlist.
+	ExeNotIn []string `json:"exeNotIn,omitempty"`
+	// Cmdline is met if any of the regexps matches the command line, which are the arguments joined by spaces.
+	Cmdline []string `json:"cmdline,omitempty"`
+	// Uids is met if the real uid of the process is in any of the ranges.
+	Uids []UIDRange `json:"uids,omitempty"`
+	// Libraries is met if any of the regexps matches the path of any loaded library, as listed by listlibs.
+	Libraries []string `json:"libraries,omitempty"`
+}
+
+// UIDRange are the uids from Min to Max, both included.
+type UIDRange struct {
+	Min int `json:"min"`
+	Max int `json:"max"`
+}
+
+func (r UIDRange) String() string {
+	if r.Min == r.Max {
+		return fmt.Sprint(r.Min)
+	}
+	return fmt.Sprintf("%d-%d", r.Min, r.Max)
+}
+
+// Evidence is what met a condition of a rule.
+type Evidence struct {
+	// Field is the condition met: exe, exeNotIn, cmdline, uids or libraries.
+	Field string `json:"field"`
+	// Value is the value of the process that met it. The secrets of command lines, like the values of --password
+	// flags, are redacted with the common.DefaultRedactor.
+	Value string `json:"value"`
+	// Pattern is the glob, regexp or range that matched the value. It's empty for exeNotIn, which is met when none
+	// matches.
+	Pattern string `json:"pattern,omitempty"`
+}
+
+func (e Evidence) String() string {
+	if e.Pattern == "" {
+		return fmt.Sprintf("%s %q", e.Field, e.Value)
+	}
+	return fmt.Sprintf("%s %q matches %q", e.Field, e.Value, e.Pattern)
+}
+
+// Violation is a process that breaks a rule.
+type Violation struct {
+	Rule     string     `json:"rule"`
+	Pid      int        `json:"pid"`
+	Evidence []Evidence `json:"evidence"`
+}
+
+func (v Violation) String() string {
+	evidence := make([]string, len(v.Evidence))
+	for i, e := range v.Evidence {
+		evidence[i] = e.String()
+	}
+	return fmt.Sprintf("process %d breaks %s: %s", v.Pid, v.Rule, strings.Join(evidence, ", "))
+}
+
+// Evaluate returns the violations of rules by procs, sorted by pid and then ordered as rules. A harderror is only
+// returned if a rule is invalid.
+//
+// Some processes lack some of the fields the conditions look at, and their conditions are never met:
+//   - Kernel threads have no executable, command line or libraries, so only uid conditions can be met by them.
+//   - Processes whose executable was deleted are matched by the path it had. The evidence has the " (deleted)" suffix
+//     the OS adds to it.
+//   - The fields that can't be read, because of missing privileges or because the process exited, are reported as
+//     softerrors naming the rules that couldn't be evaluated.
+func Evaluate(procs []process.Process, rules []Rule) (violations []Violation, harderror error, softerrors []error) {
+	compiled := make([]*compiledRule, len(rules))
+	for i, rule := range rules {
+		if compiled[i], harderror = compile(rule); harderror != nil {
+			return nil, fmt.Errorf("Rule %d (%s): %v", i, rule.Name, harderror), nil
+		}
+	}
+
+	for _, p := range procs {
+		facts := &processFacts{p: p}
+		if err := facts.gather(); err != nil {
+			softerrors = append(softerrors, &common.LocatedError{Pid: p.Pid(),
+				Err: fmt.Errorf("Skipping process %d: %v", p.Pid(), err)})
+			continue
+		}
+		for _, rule := range compiled {
+			evidence, unknown := rule.evaluate(facts)
+			if unknown != nil {
+				softerrors = append(softerrors, &common.LocatedError{Pid: p.Pid(),
+					Err: fmt.Errorf("Unable to evaluate rule %s for process %d: %v", rule.name, p.Pid(), unknown)})
+				continue
+			}
+			if evidence != nil {
+				violations = append(violations, Violation{Rule: rule.name, Pid: p.Pid(), Evidence: evidence})
+			}
+		}
+	}
+	sort.SliceStable(violations, func(i, j int) bool { return violations[i].Pid < violations[j].Pid })
+	common.SortSoftErrors(softerrors)
+	return violations, nil, softerrors
+}
+
+// compiledRule is a validated Rule, with its regexps compiled.
+type compiledRule struct {
+	name      string
+	exe       []string
+	exeNotIn  []string
+	cmdline   []*regexp.Regexp
+	uids      []UIDRange
+	libraries []*regexp.Regexp
+}
+
+// ruleError is a problem with a rule. Path is the path to the offending field from the rule, like cmdline[1], or empty
+// if the problem is with the whole rule.
+type ruleError struct {
+	Path    string
+	Message string
+}
+
+func (e *ruleError) Error() string {
+	if e.Path == "" {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+func compile(rule Rule) (*compiledRule, error) {
+	if rule.Name == "" {
+		return nil, &ruleError{Path: "name", Message: "a rule needs a name"}
+	}
+	if len(rule.Exe)+len(rule.ExeNotIn)+len(rule.Cmdline)+len(rule.Uids)+len(rule.Libraries) == 0 {
+		return nil, &ruleError{Message: "a rule needs at least one condition"}
+	}
+
+	c := &compiledRule{name: rule.Name, exe: rule.Exe, exeNotIn: rule.ExeNotIn, uids: rule.Uids}
+	for field, globs := range map[string][]string{"exe": rule.Exe, "exeNotIn": rule.ExeNotIn} {
+		for i, glob := range globs {
+			if !strings.HasPrefix(glob, "/") && !strings.HasPrefix(glob, "*") {
+				return nil, &ruleError{Path: fmt.Sprintf("%s[%d]", field, i),
+					Message: fmt.Sprintf("glob %q doesn't match absolute paths", glob)}
+			}
+			if _, err := path.Match(glob, ""); err != nil {
+				return nil, &ruleError{Path: fmt.Sprintf("%s[%d]", field, i),
+					Message: fmt.Sprintf("invalid glob %q", glob)}
+			}
+		}
+	}
+	var err error
+	if c.cmdline, err = compileRegexps("cmdline", rule.Cmdline); err != nil {
+		return nil, err
+	}
+	if c.libraries, err = compileRegexps("libraries", rule.Libraries); err != nil {
+		return nil, err
+	}
+	for i, r := range rule.Uids {
+		if r.Min < 0 || r.Max < r.Min {
+			return nil, &ruleError{Path: fmt.Sprintf("uids[%d]", i),
+				Message: fmt.Sprintf("invalid range from %d to %d", r.Min, r.Max)}
+		}
+	}
+	return c, nil
+}
+
+func compileRegexps(field string, exprs []string) (compiled []*regexp.Regexp, err error) {
+	for i, expr := range exprs {
+		r, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, &ruleError{Path: fmt.Sprintf("%s[%d]", field, i),
+				Message: fmt.Sprintf("invalid regexp (%v)", err)}
+		}
+		compiled = append(compiled, r)
+	}
+	return compiled, nil
+}
+
+// evaluate returns the evidence of the violation of the rule, or nil if it isn't violated. If a field needed to know
+// can't be read unknown is the reason.
+func (r *compiledRule) evaluate(f *processFacts) (evidence []Evidence, unknown error) {
+	// The cheapest conditions go first, so the expensive facts are only gathered when needed.
+	if len(r.uids) > 0 {
+		e := r.matchUid(f.uid)
+		if e == nil {
+			return nil, nil
+		}
+		evidence = append(evidence, *e)
+	}
+
+	if len(r.exe) > 0 || len(r.exeNotIn) > 0 {
+		if f.kernelThread {
+			return nil, nil
+		}
+		if f.exeErr != nil {
+			return nil, fmt.Errorf("unreadable executable (%v)", f.exeErr)
+		}
+		if len(r.exe) > 0 {
+			glob, ok := matchGlobs(r.exe, f.exe)
+			if !ok {
+				return nil, nil
+			}
+			evidence = append(evidence, Evidence{Field: "exe", Value: f.exeEvidence(), Pattern: glob})
+		}
+		if len(r.exeNotIn) > 0 {
+			if _, ok := matchGlobs(r.exeNotIn, f.exe); ok {
+				return nil, nil
+			}
+			evidence = append(evidence, Evidence{Field: "exeNotIn", Value: f.exeEvidence()})
+		}
+	}
+
+	if len(r.cmdline) > 0 {
+		if f.kernelThread {
+			return nil, nil
+		}
+		cmdline, err := f.cmdline()
+		if err != nil {
+			return nil, fmt.Errorf("unreadable command line (%v)", err)
+		}
+		e := matchRegexps("cmdline", r.cmdline, []string{cmdline})
+		if e == nil {
+			return nil, nil
+		}
+		// The rules match the secrets in the arguments, but the evidence doesn't disclose them.
+		e.Value = f.redactedCmdline()
+		evidence = append(evidence, *e)
+	}
+
+	if len(r.libraries) > 0 {
+		if f.kernelThread {
+			return nil, nil
+		}
+		libraries, err := f.libraries()
+		if err != nil {
+			return nil, fmt.Errorf("unreadable libraries (%v)", err)
+		}
+		e := matchRegexps("libraries", r.libraries, libraries)
+		if e == nil {
+			return nil, nil
+		}
+		evidence = append(evidence, *e)
+	}
+	return evidence, nil
+}
+
+func (r *compiledRule) matchUid(uid int) *Evidence {
+	for _, uids := range r.uids {
+		if uids.Min <= uid && uid <= uids.Max {
+			return &Evidence{Field: "uids", Value: fmt.Sprint(uid), Pattern: uids.String()}
+		}
+	}
+	return nil
+}
+
+// matchRegexps returns the evidence of the first value matched by any regexp, or nil.
+func matchRegexps(field string, regexps []*regexp.Regexp, values []string) *Evidence {
+	for _, value := range values {
+		for _, r := range regexps {
+			if r.MatchString(value) {
+				return &Evidence{Field: field, Value: value, Pattern: r.String()}
+			}
+		}
+	}
+	return nil
+}
+
+// matchGlobs returns the first glob matching name.
+func matchGlobs(globs []string, name string) (glob string, matches bool) {
+	for _, glob := range globs {
+		if matchGlob(strings.Split(glob, "/"), strings.Split(name, "/")) {
+			return glob, true
+		}
+	}
+	return "", false
+}
+
+// matchGlob matches the elements of a path with the ones of a glob, where "**" matches any amount of elements.
+func matchGlob(glob []string, name []string) bool {
+	for len(glob) > 0 {
+		if glob[0] == "**" {
+			for skip := 0; skip <= len(name); skip++ {
+				if matchGlob(glob[1:], name[skip:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(glob[0], name[0]); !ok {
+			return false
+		}
+		glob, name = glob[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// processFacts are the fields of a process the rules look at. The expensive ones are only read when a rule needs them,
+// and then only once.
+type processFacts struct {
+	p            process.Process
+	uid          int
+	kernelThread bool
+	exe          string
+	exeDeleted   bool
+	exeErr       error
+
+	cmdlineRead bool
+	cmdlineArgs []string
+	cmdlineVal  string
+	cmdlineErr  error
+
+	librariesRead bool
+	librariesVal  []string
+	librariesErr  error
+}
+
+// gather reads the cheap facts. It only fails if the process can't be inspected at all.
+func (f *processFacts) gather() error {
+	facts, harderror, _ := process.GatherFacts(f.p)
+	if harderror != nil {
+		return harderror
+	}
+	uid, ok := facts["uid"].(int64)
+	if !ok {
+		return fmt.Errorf("Unable to read the uid of process %d", f.p.Pid())
+	}
+	f.uid = int(uid)
+	f.kernelThread, _ = facts["kernel_thread"].(bool)
+	if !f.kernelThread {
+		f.exe, f.exeDeleted, f.exeErr = executable(f.p.Pid())
+	}
+	return nil
+}
+
+func (f *processFacts) exeEvidence() string {
+	if f.exeDeleted {
+		return f.exe + deletedSuffix
+	}
+	return f.exe
+}
+
+func (f *processFacts) cmdline() (string, error) {
+	if !f.cmdlineRead {
+		f.cmdlineRead = true
+		f.cmdlineArgs, f.cmdlineErr = commandLine(f.p.Pid())
+		f.cmdlineVal = strings.Join(f.cmdlineArgs, " ")
+	}
+	return f.cmdlineVal, f.cmdlineErr
+}
+
+// redactedCmdline returns the command line with the secrets removed by the common.DefaultRedactor.
+func (f *processFacts) redactedCmdline() string {
+	return strings.Join(common.DefaultRedactor().RedactArgv(f.cmdlineArgs), " ")
+}
+
+func (f *processFacts) libraries() ([]string, error) {
+	if !f.librariesRead {
+		f.librariesRead = true
+		f.librariesVal, f.librariesErr, _ = listlibs.ListLoadedLibraries(f.p)
+	}
+	return f.librariesVal, f.librariesErr
+}
@@ -0,0 +1,39 @@
+package policy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/polyverse/masche/common"
+)
+
+// deletedSuffix is what the kernel appends to the path of a deleted executable.
+const deletedSuffix = " (deleted)"
+
+// executable returns the path of the executable of a process, and if it was deleted. The link is read instead of
+// resolved, as deleted executables can't be resolved.
+func executable(pid int) (exe string, deleted bool, err error) {
+	exe, err = os.Readlink(common.ProcFilePath(uint(pid), "exe"))
+	if err != nil {
+		return "", false, err
+	}
+	if strings.HasSuffix(exe, deletedSuffix) {
+		return strings.TrimSuffix(exe, deletedSuffix), true, nil
+	}
+	return exe, false, nil
+}
+
+// commandLine returns the arguments of a process, as it reports them now.
+func commandLine(pid int) (args []string, err error) {
+	data, err := ioutil.ReadFile(common.ProcFilePath(uint(pid), "cmdline"))
+	if err != nil {
+		return nil, err
+	}
+	// Only kernel threads and zombies have no arguments, and zombies have no executable either.
+	if len(data) == 0 {
+		return nil, fmt.Errorf("process %d has no arguments", pid)
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\x00"), "\x00"), nil
+}
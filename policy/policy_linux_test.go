@@ -0,0 +1,138 @@
+package policy
+
+import (
+	"os"
+	"os/exec"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/polyverse/masche/process"
+	"github.com/polyverse/masche/test"
+)
+
+// launchDeleted launches a copy of the test case, and deletes the copy.
+func launchDeleted(t *testing.T) (cmd *exec.Cmd, exe string) {
+	exe, err := test.CopyTestCase(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd = exec.Command(exe)
+	if err := test.StartAndWaitForInitialization(cmd); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(exe); err != nil {
+		t.Fatal(err)
+	}
+	return cmd, exe
+}
+
+func TestEvaluate(t *testing.T) {
+	cmd, exe := launchDeleted(t)
+	defer cmd.Process.Kill()
+	p, err, softerrors := process.OpenFromPid(cmd.Process.Pid)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	uid := os.Getuid()
+	rules := []Rule{
+		{Name: "allowlist", ExeNotIn: []string{"/usr/**", "/bin/*"}},
+		{Name: "in-temp", Exe: []string{"/**/test"}, Cmdline: []string{regexp.QuoteMeta(exe) + "$"}},
+		{Name: "other-users", Uids: []UIDRange{{Min: uid + 1, Max: uid + 1000}}, ExeNotIn: []string{"/usr/**"}},
+		{Name: "our-user-libc", Uids: []UIDRange{{Min: 0, Max: uid}}, Libraries: []string{`/libc[.-]`}},
+		{Name: "flags", Cmdline: []string{"--map"}},
+	}
+	violations, err, softerrors := Evaluate([]process.Process{p}, rules)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(violations) != 3 {
+		t.Fatalf("Expected 3 violations, got %v", violations)
+	}
+	pid := cmd.Process.Pid
+	expected := []Violation{
+		{Rule: "allowlist", Pid: pid, Evidence: []Evidence{{Field: "exeNotIn", Value: exe + " (deleted)"}}},
+		{Rule: "in-temp", Pid: pid, Evidence: []Evidence{
+			{Field: "exe", Value: exe + " (deleted)", Pattern: "/**/test"},
+			{Field: "cmdline", Value: exe, Pattern: regexp.QuoteMeta(exe) + "$"}}},
+	}
+	if !reflect.DeepEqual(violations[:2], expected) {
+		t.Errorf("Expected %v and got %v", expected, violations[:2])
+	}
+	libc := violations[2]
+	if libc.Rule != "our-user-libc" || len(libc.Evidence) != 2 || libc.Evidence[0].Field != "uids" ||
+		libc.Evidence[1].Field != "libraries" || !strings.Contains(libc.Evidence[1].Value, "libc") {
+
+		t.Errorf("Unexpected violation %v", libc)
+	}
+
+	if _, err, _ := Evaluate([]process.Process{p}, []Rule{{Name: "bad", Cmdline: []string{"("}}}); err == nil {
+		t.Error("An invalid rule was evaluated")
+	}
+}
+
+// The evidence of command lines doesn't disclose the secrets in them, which the rules still match.
+func TestEvaluateRedactsCmdline(t *testing.T) {
+	cmd, err := test.LaunchTestCaseAndWaitForInitialization("--user", "alice", "--password=hunter2", "--token", "t0k3n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+	p, err, softerrors := process.OpenFromPid(cmd.Process.Pid)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	violations, err, softerrors := Evaluate([]process.Process{p}, []Rule{{Name: "leak", Cmdline: []string{"hunter2"}}})
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := test.GetTestCasePath() + " --user alice --password=[REDACTED] --token [REDACTED]"
+	if len(violations) != 1 || len(violations[0].Evidence) != 1 || violations[0].Evidence[0].Value != expected {
+		t.Fatalf("Expected the evidence %q, got %v", expected, violations)
+	}
+	if strings.Contains(violations[0].String(), "t0k3n") {
+		t.Errorf("The violation %s discloses the token", violations[0])
+	}
+}
+
+func TestEvaluateEdgeCases(t *testing.T) {
+	exited := exec.Command("true")
+	if err := exited.Run(); err != nil {
+		t.Fatal(err)
+	}
+	procs := []process.Process{process.GetProcess(exited.Process.Pid)}
+
+	// kthreadd is the parent of every kernel thread.
+	kthreadd := process.GetProcess(2)
+	if facts, err, _ := process.GatherFacts(kthreadd); err == nil && facts["kernel_thread"] == true {
+		procs = append(procs, kthreadd)
+	} else {
+		t.Log("Not checking kernel threads, there is no kthreadd")
+	}
+
+	rules := []Rule{
+		{Name: "allowlist", ExeNotIn: []string{"/usr/**"}},
+		{Name: "cmdline", Cmdline: []string{""}},
+		{Name: "root", Uids: []UIDRange{{Min: 0, Max: 0}}},
+	}
+	violations, err, softerrors := Evaluate(procs, rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(softerrors) != 1 || !strings.Contains(softerrors[0].Error(), "Skipping process") {
+		t.Errorf("Expected the exited process to be skipped, got %v", softerrors)
+	}
+	if len(procs) == 2 && (len(violations) != 1 || violations[0].Rule != "root" || violations[0].Pid != 2) {
+		t.Errorf("Expected kthreadd to only break the uid rule, got %v", violations)
+	}
+}
@@ -0,0 +1,21 @@
+// +build windows darwin
+
+package policy
+
+import (
+	"fmt"
+
+	"github.com/polyverse/masche/process"
+)
+
+// deletedSuffix is never added, as deleted executables aren't detected on this platform.
+const deletedSuffix = " (deleted)"
+
+func executable(pid int) (exe string, deleted bool, err error) {
+	exe, err = process.ProcessExe(pid)
+	return exe, false, err
+}
+
+func commandLine(pid int) (args []string, err error) {
+	return nil, fmt.Errorf("command lines are only read on linux")
+}
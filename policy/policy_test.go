@@ -0,0 +1,93 @@
+package policy
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMatchGlobs(t *testing.T) {
+	for _, c := range []struct {
+		glob    string
+		name    string
+		matches bool
+	}{
+		{"/usr/**", "/usr/bin/ls", true},
+		{"/usr/**", "/usr", true},
+		{"/usr/**", "/usrlocal/bin/ls", false},
+		{"/usr/*", "/usr/bin/ls", false},
+		{"/usr/**/ls", "/usr/ls", true},
+		{"/usr/**/ls", "/usr/local/bin/ls", true},
+		{"/usr/**/ls", "/usr/local/bin/lsof", false},
+		{"/opt/*/bin/*", "/opt/app/bin/app", true},
+		{"/tmp/[a-c]?", "/tmp/b1", true},
+		{"**", "/anything/at/all", true},
+	} {
+		if _, ok := matchGlobs([]string{c.glob}, c.name); ok != c.matches {
+			t.Errorf("Expected %q matching %q to be %v", c.glob, c.name, c.matches)
+		}
+	}
+}
+
+func TestParseRules(t *testing.T) {
+	rules, err := ParseRules([]byte(`{
+	"rules": [
+		{"name": "outside", "description": "Executables outside the system", "exeNotIn": ["/usr/**", "/bin/*"]},
+		{"name": "root-debug", "uids": [{"min": 0, "max": 0}], "cmdline": ["--inspect"], "libraries": ["libfoo"]}
+	]
+}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Rule{
+		{Name: "outside", Description: "Executables outside the system", ExeNotIn: []string{"/usr/**", "/bin/*"}},
+		{Name: "root-debug", Uids: []UIDRange{{Min: 0, Max: 0}}, Cmdline: []string{"--inspect"},
+			Libraries: []string{"libfoo"}},
+	}
+	if !reflect.DeepEqual(rules, expected) {
+		t.Errorf("Expected %+v and got %+v", expected, rules)
+	}
+}
+
+func TestParseRulesErrors(t *testing.T) {
+	for _, c := range []struct {
+		document string
+		expected ParseError
+	}{
+		{"[]", ParseError{Line: 1, Column: 1, Message: "a rules document must be an object"}},
+		{"{}", ParseError{Line: 1, Column: 1, Message: "missing field \"rules\""}},
+		{`{"rules": [], "more": 1}`, ParseError{Line: 1, Column: 15,
+			Message: "unknown field \"more\", the only field is \"rules\""}},
+		{"{\"rules\": [\n  {\"name\": \"a\",}\n]}", ParseError{Line: 2, Column: 16,
+			Message: "invalid character '}' looking for beginning of object key string"}},
+		{"{\"rules\": [\n  {\"name\": \"a\", \"exe\": \"/bin/*\"}\n]}", ParseError{Line: 2, Column: 24,
+			Path: "rules[0].exe", Message: "expected []string, found string"}},
+		{"{\"rules\": [\n  {\"name\": \"a\", \"exes\": []}\n]}", ParseError{Line: 2, Column: 3, Path: "rules[0]",
+			Message: "unknown field \"exes\""}},
+		{"{\"rules\": [\n  {\"name\": \"a\", \"uids\": [{\"min\": 0, \"max\": 1}]},\n  3\n]}",
+			ParseError{Line: 3, Column: 3, Path: "rules[1]", Message: "a rule must be an object"}},
+		{"{\"rules\": [\n  {\"name\": \"a\"}\n]}", ParseError{Line: 2, Column: 3, Path: "rules[0]",
+			Message: "a rule needs at least one condition"}},
+		{"{\"rules\": [\n  {\"exe\": [\"/bin/*\"]}\n]}", ParseError{Line: 2, Column: 3, Path: "rules[0].name",
+			Message: "a rule needs a name"}},
+		{"{\"rules\": [\n  {\"name\": \"a\",\n   \"cmdline\": [\"ok\", \"(unclosed\"]}\n]}", ParseError{Line: 3,
+			Column: 22, Path: "rules[0].cmdline[1]",
+			Message: "invalid regexp (error parsing regexp: missing closing ): `(unclosed`)"}},
+		{"{\"rules\": [{\"name\": \"a\", \"exe\": [\"bin/*\"]}]}", ParseError{Line: 1, Column: 34,
+			Path: "rules[0].exe[0]", Message: "glob \"bin/*\" doesn't match absolute paths"}},
+		{"{\"rules\": [{\"name\": \"a\", \"exeNotIn\": [\"/bin/[\"]}]}", ParseError{Line: 1, Column: 39,
+			Path: "rules[0].exeNotIn[0]", Message: "invalid glob \"/bin/[\""}},
+		{"{\"rules\": [{\"name\": \"a\", \"uids\": [{\"min\": 10, \"max\": 1}]}]}", ParseError{Line: 1, Column: 35,
+			Path: "rules[0].uids[0]", Message: "invalid range from 10 to 1"}},
+		{"{\"rules\": [\n  {\"name\": \"a\", \"uids\": [{\"min\": 0, \"max\": 0}]},\n  {\"uids\": [], \"name\": \"a\"," +
+			" \"exe\": [\"/*\"]}\n]}", ParseError{Line: 3, Column: 24, Path: "rules[1].name",
+			Message: "there is already a rule named \"a\""}},
+		{`{"rules": [`, ParseError{Line: 1, Column: 12, Message: "unexpected end of JSON input"}},
+		{`{"rules": []} {}`, ParseError{Line: 1, Column: 15, Message: "unexpected data after the rules document"}},
+	} {
+		_, err := ParseRules([]byte(c.document))
+		parseError, ok := err.(*ParseError)
+		if !ok || *parseError != c.expected {
+			t.Errorf("Parsing %q, expected the error %v and got %v", c.document, &c.expected, err)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package procargs
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/polyverse/masche/process"
+)
+
+// Changes of a variable along a lineage.
+const (
+	// Introduced is the first process in the lineage with the variable set.
+	Introduced = "introduced"
+	// Changed is a process with a different value than its closest readable ancestor.
+	Changed = "changed"
+	// Removed is a process without the variable, while its closest readable ancestor had it.
+	Removed = "removed"
+)
+
+// LineageEntry is the value of a variable in the environment of a process of a lineage.
+type LineageEntry struct {
+	Pid        int    `json:"pid"`
+	Executable string `json:"executable"`
+	Value      string `json:"value"`
+	// Set is false if the variable isn't in the environment, then Value is empty.
+	Set bool `json:"set"`
+	// Readable is false if the environment of the process couldn't be read, then the value of the variable is
+	// unknown.
+	Readable bool `json:"readable"`
+	// Change is how the variable changed from the closest readable ancestor: Introduced, Changed, Removed, or empty if
+	// it's inherited as it is or the environment isn't readable.
+	Change string `json:"change,omitempty"`
+}
+
+// VariableLineage traces how the environment variable name was inherited by p. It returns the ancestors of p, from
+// the root of the process tree down to p itself, with the value the variable had in the environment each of them was
+// started with. The ones whose environment can't be read are reported as not readable, with the reason as a softerror.
+//
+// The ancestors are found with process.Ancestry, so the ones that exited before p was inspected are missing, and a
+// variable can look introduced by the process below the gap.
+func VariableLineage(p process.Process, name string) (lineage []LineageEntry, harderror error, softerrors []error) {
+	ancestors, harderror, softerrors := process.Ancestry(p.Pid())
+	if harderror != nil {
+		return nil, harderror, softerrors
+	}
+
+	lineage = make([]LineageEntry, len(ancestors)+1)
+	previous := -1
+	for i := range lineage {
+		entry := &lineage[i]
+		var err error
+		if i == len(ancestors) {
+			entry.Pid = p.Pid()
+			entry.Value, entry.Set, err = lookupVariable(p, name)
+		} else {
+			entry.Pid = ancestors[len(ancestors)-1-i]
+			entry.Value, entry.Set, err = lookupVariableOfPid(entry.Pid, name)
+		}
+		entry.Executable, _ = process.ProcessExe(entry.Pid)
+		if err != nil {
+			softerrors = append(softerrors, err)
+			continue
+		}
+		entry.Readable = true
+
+		inherited := previous != -1 && lineage[previous].Set
+		switch {
+		case entry.Set && !inherited:
+			entry.Change = Introduced
+		case entry.Set && entry.Value != lineage[previous].Value:
+			entry.Change = Changed
+		case !entry.Set && inherited:
+			entry.Change = Removed
+		}
+		previous = i
+	}
+	return lineage, nil, softerrors
+}
+
+func lookupVariableOfPid(pid int, name string) (value string, set bool, err error) {
+	p, err, _ := process.OpenFromPid(pid)
+	if err != nil {
+		return "", false, fmt.Errorf("Unable to open process %d (%v)", pid, err)
+	}
+	defer p.Close()
+	return lookupVariable(p, name)
+}
+
+func lookupVariable(p process.Process, name string) (value string, set bool, err error) {
+	envp, err := environment(p)
+	if err != nil {
+		return "", false, fmt.Errorf("Unable to read the environment of process %d (%v)", p.Pid(), err)
+	}
+	for _, variable := range envp {
+		if key, value, ok := strings.Cut(variable, "="); ok && key == name {
+			return value, true, nil
+		}
+	}
+	return "", false, nil
+}
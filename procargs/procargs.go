@@ -0,0 +1,29 @@
+// Package procargs recovers the arguments and environment a process was started with.
+package procargs
+
+import (
+	"github.com/polyverse/masche/process"
+)
+
+// Args are the arguments and environment of a process.
+type Args struct {
+	Argv []string `json:"argv"`
+	Envp []string `json:"envp"`
+
+	// Reconstructed is true if Argv and Envp were recovered from the initial stack of the process. They are the
+	// values the process was started with, which can be stale if the process changed them afterwards.
+	//
+	// If it's false the reconstruction failed, and they are what the OS reports, which the process may have scrubbed.
+	Reconstructed bool `json:"reconstructed"`
+
+	// Redactor removes the secrets of Argv and Envp when they are serialized to JSON. If it's nil the
+	// DefaultRedactor is used, NoRedaction disables it.
+	Redactor *Redactor `json:"-"`
+}
+
+// ReconstructArgs is a best-effort attempt to recover the original arguments and environment of p, even if it
+// overwrote them. If the reconstruction fails the reason is reported as a softerror, and the arguments are taken from
+// the OS instead.
+func ReconstructArgs(p process.Process) (args Args, harderror error, softerrors []error) {
+	return reconstructArgs(p)
+}
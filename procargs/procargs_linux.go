@@ -0,0 +1,226 @@
+package procargs
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/polyverse/masche/common"
+	"github.com/polyverse/masche/memaccess"
+	"github.com/polyverse/masche/process"
+)
+
+// atExecfn is the auxv entry holding the address of the executable's filename, which the kernel places right above
+// the environment strings.
+const atExecfn = 31
+
+func reconstructArgs(p process.Process) (args Args, harderror error, softerrors []error) {
+	args, err, softerrors := fromStack(p)
+	if err == nil {
+		return args, nil, softerrors
+	}
+	softerrors = append(softerrors, fmt.Errorf("Unable to reconstruct the arguments of process %d: %v", p.Pid(), err))
+
+	args.Argv, harderror = readNulSeparated(p.Pid(), "cmdline")
+	if harderror != nil {
+		return args, harderror, softerrors
+	}
+	args.Envp, err = readNulSeparated(p.Pid(), "environ")
+	if err != nil {
+		softerrors = append(softerrors, err)
+	}
+	return args, nil, softerrors
+}
+
+// environment returns the environment p was started with, or the one the OS reports if the reconstruction fails.
+func environment(p process.Process) (envp []string, err error) {
+	args, err, _ := fromStack(p)
+	if err == nil {
+		return args.Envp, nil
+	}
+	return readNulSeparated(p.Pid(), "environ")
+}
+
+func readNulSeparated(pid int, file string) ([]string, error) {
+	data, err := ioutil.ReadFile(common.ProcFilePath(uint(pid), file))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, nil
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\x00"), "\x00"), nil
+}
+
+// fromStack recovers the arguments from the initial stack. The kernel lays it out as:
+//
+//	argc, argv pointers, NULL, envp pointers, NULL, auxv, ..., argv strings, envp strings, executable filename
+//
+// The auxv, which the kernel keeps a copy of, anchors the pointer arrays, from which argc and envc are taken. The
+// strings are then read backwards from the filename pointed by AT_EXECFN, so they are found even if the pointers
+// were changed. It fails if the strings themselves were overwritten, as they are then no longer the original ones.
+func fromStack(p process.Process) (args Args, harderror error, softerrors []error) {
+	order, wordSize, err := processWordFormat(p.Pid())
+	if err != nil {
+		return args, err, nil
+	}
+
+	auxv, err := ioutil.ReadFile(common.ProcFilePath(uint(p.Pid()), "auxv"))
+	if err != nil {
+		return args, err, nil
+	}
+	execfn, found := auxvValue(auxv, order, wordSize, atExecfn)
+	if !found {
+		return args, fmt.Errorf("No AT_EXECFN entry in the auxiliary vector"), nil
+	}
+
+	stackStart, stack, err, softerrors := readStack(p)
+	if err != nil {
+		return args, err, softerrors
+	}
+
+	auxvOffset := bytes.Index(stack, auxv)
+	if auxvOffset == -1 || auxvOffset%wordSize != 0 {
+		return args, fmt.Errorf("The auxiliary vector is not in the stack"), softerrors
+	}
+
+	word := func(offset int) uint64 {
+		if wordSize == 8 {
+			return order.Uint64(stack[offset:])
+		}
+		return uint64(order.Uint32(stack[offset:]))
+	}
+	inStack := func(address uint64) bool {
+		return address >= uint64(stackStart) && address < uint64(stackStart)+uint64(len(stack))
+	}
+
+	// Walk the pointer arrays backwards from the auxv.
+	offset := auxvOffset - wordSize
+	if offset < 0 || word(offset) != 0 {
+		return args, fmt.Errorf("The environment array is not terminated"), softerrors
+	}
+	envc := 0
+	for offset -= wordSize; offset >= 0 && word(offset) != 0; offset -= wordSize {
+		envc++
+	}
+	// The argument pointers may have been replaced, so argc is only recognized by its value.
+	argc := 0
+	for offset -= wordSize; offset >= 0 && (word(offset) != uint64(argc) || argc == 0); offset -= wordSize {
+		argc++
+	}
+	if offset < 0 || argc == 0 {
+		return args, fmt.Errorf("No argument count found in the stack"), softerrors
+	}
+
+	if !inStack(execfn) {
+		return args, fmt.Errorf("AT_EXECFN %x is outside the stack", execfn), softerrors
+	}
+	strs, err := stringsBefore(stack, int(execfn-uint64(stackStart)), argc+envc)
+	if err != nil {
+		return args, err, softerrors
+	}
+
+	// The environment strings follow the argument strings, and both end right before the filename.
+	argvEnd := int(execfn - uint64(stackStart))
+	for _, s := range strs[argc:] {
+		argvEnd -= len(s) + 1
+	}
+	if argStart, err := argStart(p.Pid()); err != nil {
+		softerrors = append(softerrors, &common.LocatedError{Pid: p.Pid(), Err: err})
+	} else if inStack(argStart) && overwritten(stack, int(argStart-uint64(stackStart)), argvEnd, strs[:argc]) {
+		return args, fmt.Errorf("The arguments were overwritten in place at %x, as setproctitle does", argStart),
+			softerrors
+	}
+
+	args.Argv = strs[:argc]
+	args.Envp = strs[argc:]
+	args.Reconstructed = true
+	return args, nil, softerrors
+}
+
+// overwritten tells if the argument strings of the stack from start to end, where the OS reads the arguments it
+// reports, aren't the argv found before the environment strings. The process then overwrote them in place, like
+// setproctitle(3) does, and argv is what the OS reports instead of the original arguments.
+func overwritten(stack []byte, start, end int, argv []string) bool {
+	return start > end || string(stack[start:end]) != strings.Join(argv, "\x00")+"\x00"
+}
+
+// argStart returns the address of the arguments the OS reports, from the arg_start field of the stat file, which is
+// on the stack unless the process moved it elsewhere with prctl(2). It's zero if it can't be read.
+func argStart(pid int) (uint64, error) {
+	statPath := common.StatFilePathFromPid(uint(pid))
+	data, err := ioutil.ReadFile(statPath)
+	if err != nil {
+		return 0, err
+	}
+	// arg_start is the 48th field, the 46th after the command name, which can have spaces.
+	fields := strings.Fields(string(data[bytes.LastIndexByte(data, ')')+1:]))
+	if len(fields) < 46 {
+		return 0, nil
+	}
+	return strconv.ParseUint(fields[45], 10, 64)
+}
+
+// stringsBefore returns the count NUL terminated strings that end right before end in data, in order.
+func stringsBefore(data []byte, end int, count int) ([]string, error) {
+	strs := make([]string, count)
+	for i := count - 1; i >= 0; i-- {
+		if end == 0 || data[end-1] != 0 {
+			return nil, fmt.Errorf("Malformed strings area in the stack")
+		}
+		start := bytes.LastIndexByte(data[:end-1], 0) + 1
+		strs[i] = string(data[start : end-1])
+		end = start
+	}
+	return strs, nil
+}
+
+func processWordFormat(pid int) (order binary.ByteOrder, wordSize int, err error) {
+	f, err := elf.Open(common.ProcFilePath(uint(pid), "exe"))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	wordSize = 8
+	if f.Class == elf.ELFCLASS32 {
+		wordSize = 4
+	}
+	return f.ByteOrder, wordSize, nil
+}
+
+func auxvValue(auxv []byte, order binary.ByteOrder, wordSize int, key uint64) (value uint64, found bool) {
+	for i := 0; i+2*wordSize <= len(auxv); i += 2 * wordSize {
+		var k, v uint64
+		if wordSize == 8 {
+			k, v = order.Uint64(auxv[i:]), order.Uint64(auxv[i+wordSize:])
+		} else {
+			k, v = uint64(order.Uint32(auxv[i:])), uint64(order.Uint32(auxv[i+wordSize:]))
+		}
+		if k == key {
+			return v, true
+		}
+	}
+	return 0, false
+}
+
+func readStack(p process.Process) (start uintptr, stack []byte, harderror error, softerrors []error) {
+	entries, err := common.ReadMapsFile(uint(p.Pid()))
+	if err != nil {
+		return 0, nil, err, nil
+	}
+
+	for _, entry := range entries {
+		if entry.Path != "[stack]" {
+			continue
+		}
+		stack = make([]byte, entry.End-entry.Start)
+		harderror, softerrors = memaccess.CopyMemory(p, entry.Start, stack)
+		return entry.Start, stack, harderror, softerrors
+	}
+	return 0, nil, fmt.Errorf("No stack region found"), nil
+}
@@ -0,0 +1,216 @@
+package procargs
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/polyverse/masche/process"
+	"github.com/polyverse/masche/test"
+)
+
+func TestReconstructArgs(t *testing.T) {
+	cmd, err := test.LaunchTestCaseAndWaitForInitialization("--scrub", "original-argument")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	proc, err, softerrors := process.OpenFromPid(cmd.Process.Pid)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proc.Close()
+
+	args, err, softerrors := ReconstructArgs(proc)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !args.Reconstructed {
+		t.Fatal("The arguments were not reconstructed")
+	}
+	expected := []string{test.GetTestCasePath(), "--scrub", "original-argument"}
+	if !reflect.DeepEqual(args.Argv, expected) {
+		t.Errorf("Expected arguments %q and got %q", expected, args.Argv)
+	}
+	if len(args.Envp) == 0 {
+		t.Error("No environment reconstructed")
+	}
+}
+
+// Arguments overwritten in place, as setproctitle does, can't be recovered, and aren't presented as if they were.
+func TestReconstructOverwrittenArgs(t *testing.T) {
+	cmd, err := test.LaunchTestCaseAndWaitForInitialization("--overwrite", "original-argument")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	proc, err, softerrors := process.OpenFromPid(cmd.Process.Pid)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proc.Close()
+
+	args, err, softerrors := ReconstructArgs(proc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if args.Reconstructed || len(args.Argv) == 0 || args.Argv[0] != "scrubbed" {
+		t.Errorf("Expected the overwritten arguments the OS reports, got %+v", args)
+	}
+	if len(softerrors) == 0 || !strings.Contains(softerrors[len(softerrors)-1].Error(), "overwritten in place") {
+		t.Errorf("Expected a softerror about the overwritten arguments, got %v", softerrors)
+	}
+	for _, arg := range args.Argv {
+		if strings.Contains(arg, "original-argument") {
+			t.Errorf("Found the original argument in %q", args.Argv)
+		}
+	}
+}
+
+func TestStringsBefore(t *testing.T) {
+	data := []byte("junk\x00first\x00\x00third\x00filename\x00")
+	strs, err := stringsBefore(data, len("junk\x00first\x00\x00third\x00"), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(strs, []string{"first", "", "third"}) {
+		t.Errorf("Unexpected strings %q", strs)
+	}
+
+	if _, err := stringsBefore(data, len("junk\x00first"), 1); err == nil {
+		t.Error("Expected an error for a string that isn't terminated")
+	}
+}
+
+func TestRedactedJSON(t *testing.T) {
+	cmd := exec.Command(test.GetTestCasePath(), "--user", "alice", "--password=hunter2", "--token", "t0k3n",
+		"--verbose")
+	cmd.Args[0] = "test"
+	cmd.Env = []string{"HOME=/home/alice", "AWS_SECRET_ACCESS_KEY=planted-aws-secret", "GITHUB_TOKEN=planted-token",
+		"DB_PASSWORD=planted-password", "PASSWORD_HINT=not a secret", "LANG=C"}
+	if err := test.StartAndWaitForInitialization(cmd); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	proc, err, softerrors := process.OpenFromPid(cmd.Process.Pid)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proc.Close()
+
+	args, err, softerrors := ReconstructArgs(proc)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := json.MarshalIndent(args, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden, err := ioutil.ReadFile(filepath.Join("testdata", "redacted.golden"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != strings.TrimSpace(string(golden)) {
+		t.Errorf("Expected:\n%s\ngot:\n%s", golden, data)
+	}
+
+	// The secrets are still in memory, and serialized when redaction is disabled.
+	if args.Argv[3] != "--password=hunter2" || args.Envp[2] != "GITHUB_TOKEN=planted-token" {
+		t.Errorf("The arguments were changed: %q %q", args.Argv, args.Envp)
+	}
+	args.Redactor = NoRedaction
+	data, err = json.Marshal(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "hunter2") || !strings.Contains(string(data), "planted-aws-secret") {
+		t.Errorf("Expected the secrets without redaction, got %s", data)
+	}
+
+	// A custom redactor.
+	args.Redactor = &Redactor{Env: func(key, value string) (string, bool) {
+		return strings.Repeat("*", len(value)), key == "HOME"
+	}}
+	data, err = json.Marshal(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"HOME=***********"`) || !strings.Contains(string(data), "hunter2") {
+		t.Errorf("Unexpected custom redaction %s", data)
+	}
+}
+
+// childOf returns the pid of the only child of the process with the given pid.
+func childOf(t *testing.T, pid int) int {
+	pids, err, _ := process.GetAllPids()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, candidate := range pids {
+		if info, err := process.GetProcessInfo(candidate); err == nil && (*info).GetParentProcessId() == pid {
+			return candidate
+		}
+	}
+	t.Fatalf("Process %d has no children", pid)
+	return 0
+}
+
+func TestVariableLineage(t *testing.T) {
+	const name = "MASCHE_LINEAGE"
+	os.Unsetenv(name)
+	tool := test.GetTestCasePath()
+	// The variable is introduced by the process in the middle of the chain.
+	top, err := test.LaunchTestCaseAndWaitForInitialization("--spawn", "env", name+"=middle", tool, "--spawn", tool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer top.Process.Kill()
+	middle := childOf(t, top.Process.Pid)
+	bottom := childOf(t, middle)
+
+	p, err, softerrors := process.OpenFromPid(bottom)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	lineage, err, softerrors := VariableLineage(p, name)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lineage) < 4 {
+		t.Fatalf("Expected the test, the three test cases and their ancestors, got %+v", lineage)
+	}
+	if root := lineage[0].Pid; root != 1 {
+		t.Errorf("Expected the lineage to start at init, it starts at %d", root)
+	}
+
+	expected := []LineageEntry{
+		{Pid: os.Getpid(), Readable: true},
+		{Pid: top.Process.Pid, Executable: tool, Readable: true},
+		{Pid: middle, Executable: tool, Value: "middle", Set: true, Readable: true, Change: Introduced},
+		{Pid: bottom, Executable: tool, Value: "middle", Set: true, Readable: true},
+	}
+	last := lineage[len(lineage)-len(expected):]
+	expected[0].Executable = last[0].Executable
+	if !reflect.DeepEqual(last, expected) {
+		t.Errorf("Expected the lineage to end with %+v, got %+v", expected, last)
+	}
+}
@@ -0,0 +1,17 @@
+// +build windows darwin
+
+package procargs
+
+import (
+	"fmt"
+
+	"github.com/polyverse/masche/process"
+)
+
+func reconstructArgs(p process.Process) (args Args, harderror error, softerrors []error) {
+	return args, fmt.Errorf("Reconstructing the arguments is not implemented on this platform"), nil
+}
+
+func environment(p process.Process) (envp []string, err error) {
+	return nil, fmt.Errorf("Reading the environment is not implemented on this platform")
+}
@@ -0,0 +1,39 @@
+package procargs
+
+import (
+	"encoding/json"
+
+	"github.com/polyverse/masche/common"
+)
+
+// Redacted replaces the secrets removed by a Redactor.
+const Redacted = common.Redacted
+
+// ArgvRule redacts the arguments that match a regexp. See common.ArgvRule.
+type ArgvRule = common.ArgvRule
+
+// Redactor removes secrets from arguments and environments when they are serialized. It's shared with the other
+// packages that serialize arguments, like process. See common.Redactor.
+type Redactor = common.Redactor
+
+// NoRedaction serializes Args as they are.
+var NoRedaction = common.NoRedaction
+
+// DefaultRedactor returns the Redactor used when Args don't have one. See common.DefaultRedactor.
+func DefaultRedactor() *Redactor {
+	return common.DefaultRedactor()
+}
+
+// Redact returns a copy of args with the secrets redacted by r, or by the DefaultRedactor if r is nil.
+func Redact(args Args, r *Redactor) Args {
+	redacted := args
+	redacted.Argv = r.RedactArgv(args.Argv)
+	redacted.Envp = r.RedactEnvp(args.Envp)
+	return redacted
+}
+
+// MarshalJSON serializes args with its Redactor, or with the DefaultRedactor if it has none.
+func (args Args) MarshalJSON() ([]byte, error) {
+	type plain Args
+	return json.Marshal(plain(Redact(args, args.Redactor)))
+}
@@ -0,0 +1,35 @@
+package process
+
+import (
+	"errors"
+)
+
+// AccessLevel describes how much of a process can be inspected with the privileges masche is running with.
+type AccessLevel int
+
+const (
+	// NoAccess means that neither the process' metadata (its memory map) nor its memory can be read.
+	NoAccess AccessLevel = iota
+	// MetadataOnly means that the process' memory map can be read, but its memory can't. This is the usual case for
+	// setuid binaries and processes that marked themselves as non-dumpable.
+	MetadataOnly
+	// FullAccess means that both the process' memory map and its memory can be read.
+	FullAccess
+)
+
+func (a AccessLevel) String() string {
+	switch a {
+	case NoAccess:
+		return "NoAccess"
+	case MetadataOnly:
+		return "MetadataOnly"
+	case FullAccess:
+		return "FullAccess"
+	}
+	return "Unknown"
+}
+
+// ErrInsufficientPrivileges is returned (wrapped) by the functions that need to read a process' memory when the
+// process' AccessLevel says that it can't be read. It allows callers to skip such processes with a single, accurate
+// disposition instead of getting an error for every region they try to read.
+var ErrInsufficientPrivileges = errors.New("skipped: insufficient privileges")
@@ -0,0 +1,15 @@
+package process
+
+import "github.com/polyverse/masche/common"
+
+// Ancestry returns the pids of the ancestors of the process with the given pid, from its parent up to the root of the
+// process tree (init, or the process whose parent is unknown).
+//
+// The processes whose parent exited were reparented to init or to a subreaper, so the ancestors that exited are
+// missing from the chain, and it can't be told apart from one where they never existed.
+//
+// On Linux the chain is checked with the start times of the processes: a parent started after its child means the
+// pid of the real parent was reused after it exited, and then the chain ends there with a softerror.
+func Ancestry(pid int) (ancestors []int, harderror error, softerrors []error) {
+	return common.Result(ancestry(pid))
+}
@@ -0,0 +1,34 @@
+package process
+
+import (
+	"fmt"
+
+	"github.com/polyverse/masche/common"
+)
+
+// maxAncestry bounds the chain, in case the stat files of a changing process tree make it look like a cycle.
+const maxAncestry = 4096
+
+func ancestry(pid int) (ancestors []int, harderror error, softerrors []error) {
+	stat, err := common.ReadStatFile(uint(pid))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read stat of process %d (%v)", pid, err), nil
+	}
+
+	for stat.Ppid != 0 && len(ancestors) < maxAncestry {
+		parent, err := common.ReadStatFile(uint(stat.Ppid))
+		if err != nil {
+			softerrors = append(softerrors, fmt.Errorf("The ancestry of process %d ends at process %d: its parent %d "+
+				"can't be read (%v)", pid, stat.Pid, stat.Ppid, err))
+			break
+		}
+		if parent.StartTime > stat.StartTime {
+			softerrors = append(softerrors, fmt.Errorf("The ancestry of process %d ends at process %d: its parent "+
+				"exited and pid %d was reused", pid, stat.Pid, stat.Ppid))
+			break
+		}
+		ancestors = append(ancestors, parent.Pid)
+		stat = parent
+	}
+	return ancestors, nil, softerrors
+}
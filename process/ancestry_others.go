@@ -0,0 +1,11 @@
+// +build windows darwin
+
+package process
+
+import (
+	"fmt"
+)
+
+func ancestry(pid int) (ancestors []int, harderror error, softerrors []error) {
+	return nil, fmt.Errorf("Ancestry is not implemented on this platform"), nil
+}
@@ -0,0 +1,188 @@
+package process
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"runtime"
+)
+
+// Arch is the instruction set a process runs.
+type Arch string
+
+const (
+	ArchUnknown Arch = "unknown"
+	ArchX86     Arch = "x86"
+	ArchX86_64  Arch = "x86_64"
+	ArchARM     Arch = "arm"
+	ArchARM64   Arch = "arm64"
+)
+
+// PointerSize returns the size in bytes of the pointers of the processes of the architecture, or 0 if it's unknown.
+func (a Arch) PointerSize() int {
+	switch a {
+	case ArchX86, ArchARM:
+		return 4
+	case ArchX86_64, ArchARM64:
+		return 8
+	}
+	return 0
+}
+
+// hostArch returns the architecture of the current process.
+func hostArch() Arch {
+	switch runtime.GOARCH {
+	case "386":
+		return ArchX86
+	case "amd64":
+		return ArchX86_64
+	case "arm":
+		return ArchARM
+	case "arm64":
+		return ArchARM64
+	}
+	return ArchUnknown
+}
+
+// compatArch returns the 32 bits architecture that the processors of a 64 bits one can run too, or the same one if
+// it isn't of 64 bits.
+func compatArch(arch Arch) Arch {
+	switch arch {
+	case ArchX86_64:
+		return ArchX86
+	case ArchARM64:
+		return ArchARM
+	}
+	return arch
+}
+
+// TargetArch is the architecture of the memory of a process, which the features that read pointers or structures from
+// it need to know: the instruction set, the size of its pointers and its byte order. The process can be of another
+// machine, like the one of a core dump. Its zero value is an unknown architecture.
+type TargetArch struct {
+	Arch Arch `json:"arch"`
+	// PointerSize is the size in bytes of the pointers of the process, which is known for some processes of unknown
+	// instruction sets, or 0 if it's unknown.
+	PointerSize int  `json:"pointerSize"`
+	BigEndian   bool `json:"bigEndian,omitempty"`
+}
+
+// TargetOf returns the TargetArch of the processes running arch, which are all little endian.
+func TargetOf(arch Arch) TargetArch {
+	if arch.PointerSize() == 0 {
+		return TargetArch{Arch: ArchUnknown}
+	}
+	return TargetArch{Arch: arch, PointerSize: arch.PointerSize()}
+}
+
+// HostTarget returns the TargetArch of the current process, the one of the scanner.
+func HostTarget() TargetArch {
+	return TargetOf(hostArch())
+}
+
+// ElfTarget returns the TargetArch described by the header of an ELF file, like a core dump. The pointer size and
+// the byte order are known even if the machine isn't one of the known architectures.
+func ElfTarget(machine elf.Machine, class elf.Class, data elf.Data) TargetArch {
+	target := TargetArch{Arch: ArchUnknown, BigEndian: data == elf.ELFDATA2MSB}
+	switch class {
+	case elf.ELFCLASS32:
+		target.PointerSize = 4
+	case elf.ELFCLASS64:
+		target.PointerSize = 8
+	}
+	switch {
+	case class == elf.ELFCLASS32 && machine == elf.EM_386:
+		target.Arch = ArchX86
+	case class == elf.ELFCLASS64 && machine == elf.EM_X86_64:
+		target.Arch = ArchX86_64
+	case class == elf.ELFCLASS32 && machine == elf.EM_ARM:
+		target.Arch = ArchARM
+	case class == elf.ELFCLASS64 && machine == elf.EM_AARCH64:
+		target.Arch = ArchARM64
+	}
+	return target
+}
+
+// Known tells if the size and the byte order of the pointers of the architecture are known.
+func (t TargetArch) Known() bool {
+	return t.PointerSize != 0
+}
+
+// ByteOrder returns the byte order of the architecture.
+func (t TargetArch) ByteOrder() binary.ByteOrder {
+	if t.BigEndian {
+		return binary.BigEndian
+	}
+	return binary.LittleEndian
+}
+
+// Pointer decodes the pointer at the start of b, which must hold at least PointerSize bytes.
+func (t TargetArch) Pointer(b []byte) uint64 {
+	if t.PointerSize == 4 {
+		return uint64(t.ByteOrder().Uint32(b))
+	}
+	return t.ByteOrder().Uint64(b)
+}
+
+func (t TargetArch) String() string {
+	if !t.Known() {
+		return string(ArchUnknown)
+	}
+	arch, order := t.Arch, "little endian"
+	if arch == "" {
+		arch = ArchUnknown
+	}
+	if t.BigEndian {
+		order = "big endian"
+	}
+	return fmt.Sprintf("%s (%d bits, %s)", arch, 8*t.PointerSize, order)
+}
+
+// ErrArchMismatch is the error matched by the ArchErrors of features that only handle the architecture of the
+// scanner, when the target is another one.
+var ErrArchMismatch = errors.New("architecture mismatch")
+
+// ErrUnsupportedArch is the error matched by the ArchErrors of features that can't handle the architecture of the
+// target, nor the one of the scanner, or when it's unknown.
+var ErrUnsupportedArch = errors.New("unsupported architecture")
+
+// ArchError reports that a feature can't read the memory of a process of some architecture, instead of reading it
+// as if it were of another one.
+type ArchError struct {
+	Pid     int        `json:"pid"`
+	Feature string     `json:"feature"`
+	Target  TargetArch `json:"target"`
+	Scanner TargetArch `json:"scanner"`
+	// Err is ErrArchMismatch or ErrUnsupportedArch.
+	Err error `json:"-"`
+}
+
+func (e *ArchError) Error() string {
+	return fmt.Sprintf("Process %d: %v (%s can't read the memory of %v with the scanner on %v)", e.Pid, e.Err,
+		e.Feature, e.Target, e.Scanner)
+}
+
+// Location makes ArchErrors sort by their process in softerrors. See common.SortSoftErrors.
+func (e *ArchError) Location() (pid int, address uintptr) {
+	return e.Pid, 0
+}
+
+// Unwrap makes errors.Is(err, ErrArchMismatch) or errors.Is(err, ErrUnsupportedArch) true for ArchErrors.
+func (e *ArchError) Unwrap() error {
+	return e.Err
+}
+
+// CheckArch returns nil if feature supports the architecture of the memory of pid, or an ArchError otherwise. It's
+// an ErrArchMismatch if feature supports the architecture of the scanner, and an ErrUnsupportedArch if it doesn't or
+// target is unknown.
+func CheckArch(pid int, feature string, target TargetArch, supports func(TargetArch) bool) error {
+	if target.Known() && supports(target) {
+		return nil
+	}
+	e := &ArchError{Pid: pid, Feature: feature, Target: target, Scanner: HostTarget(), Err: ErrUnsupportedArch}
+	if target.Known() && supports(e.Scanner) {
+		e.Err = ErrArchMismatch
+	}
+	return e
+}
@@ -0,0 +1,102 @@
+package process
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/polyverse/masche/common"
+)
+
+// Constants of elf(5) and getauxval(3).
+const (
+	elfClass32   = 1
+	elfClass64   = 2
+	elfDataMSB   = 2
+	elfMachine   = 18
+	emX86        = 3
+	emARM        = 40
+	emX86_64     = 62
+	emAArch64    = 183
+	atPhent      = 4
+	elf32PhdrLen = 32
+	elf64PhdrLen = 56
+)
+
+func (p linuxProcess) Architecture() (arch Arch, harderror error, softerrors []error) {
+	return processArchitecture(p.pid)
+}
+
+// processArchitecture reads the architecture of the executable of pid, or guesses it from its auxiliary vector.
+func processArchitecture(pid int) (arch Arch, harderror error, softerrors []error) {
+	exePath := common.ProcFilePath(uint(pid), "exe")
+	header := make([]byte, elfMachine+2)
+	exe, err := os.Open(exePath)
+	if err == nil {
+		_, err = io.ReadFull(exe, header)
+		exe.Close()
+	}
+	if err == nil {
+		arch, err = elfArch(header)
+		if err != nil {
+			return ArchUnknown, fmt.Errorf("Unable to read the architecture of %s (%v)", exePath, err), nil
+		}
+		return arch, nil, nil
+	}
+	softerrors = append(softerrors, &common.LocatedError{Pid: pid,
+		Err: fmt.Errorf("Guessing the architecture of process %d, its executable can't be read (%v)", pid, err)})
+
+	arch, err = auxvArch(pid)
+	if err != nil {
+		return ArchUnknown, err, softerrors
+	}
+	return arch, nil, softerrors
+}
+
+// elfArch returns the architecture of an executable given the start of its ELF header.
+func elfArch(header []byte) (Arch, error) {
+	if len(header) < elfMachine+2 || string(header[:4]) != "\x7fELF" {
+		return ArchUnknown, fmt.Errorf("Not an ELF header")
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if header[5] == elfDataMSB {
+		order = binary.BigEndian
+	}
+
+	class, machine := header[4], order.Uint16(header[elfMachine:])
+	switch {
+	case class == elfClass32 && machine == emX86:
+		return ArchX86, nil
+	case class == elfClass64 && machine == emX86_64:
+		return ArchX86_64, nil
+	case class == elfClass32 && machine == emARM:
+		return ArchARM, nil
+	case class == elfClass64 && machine == emAArch64:
+		return ArchARM64, nil
+	}
+	return ArchUnknown, nil
+}
+
+// auxvArch guesses the architecture of pid by the size of its program headers in its auxiliary vector, whose entries
+// have the size of its words. The processor is assumed to be the one of the current process, which can run the 32
+// bits processes of its family.
+func auxvArch(pid int) (Arch, error) {
+	auxv, err := ioutil.ReadFile(common.ProcFilePath(uint(pid), "auxv"))
+	if err != nil {
+		return ArchUnknown, fmt.Errorf("Unable to read the auxiliary vector of process %d (%v)", pid, err)
+	}
+
+	for i := 0; i+16 <= len(auxv); i += 16 {
+		if nativeEndian.Uint64(auxv[i:]) == atPhent && nativeEndian.Uint64(auxv[i+8:]) == elf64PhdrLen {
+			return hostArch(), nil
+		}
+	}
+	for i := 0; i+8 <= len(auxv); i += 8 {
+		if nativeEndian.Uint32(auxv[i:]) == atPhent && nativeEndian.Uint32(auxv[i+4:]) == elf32PhdrLen {
+			return compatArch(hostArch()), nil
+		}
+	}
+	return ArchUnknown, fmt.Errorf("No program header size in the auxiliary vector of process %d", pid)
+}
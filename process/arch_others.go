@@ -0,0 +1,19 @@
+// +build windows darwin
+
+package process
+
+// pidTarget returns the TargetArch of the process with the given pid, which is opened to read its Architecture.
+func pidTarget(pid int) (target TargetArch, harderror error, softerrors []error) {
+	p, harderror, softerrors := openFromPid(pid)
+	if harderror != nil {
+		return target, harderror, softerrors
+	}
+	defer p.Close()
+
+	arch, harderror, softs := p.Architecture()
+	softerrors = append(softerrors, softs...)
+	if harderror != nil {
+		return target, harderror, softerrors
+	}
+	return TargetOf(arch), nil, softerrors
+}
@@ -0,0 +1,19 @@
+package process
+
+import (
+	"github.com/polyverse/masche/common"
+)
+
+// Features of this package in the capabilities matrix.
+const (
+	// CapabilityCredentials is reading the credentials of processes with CurrentCredentials.
+	CapabilityCredentials = "credentials"
+	// CapabilityUnixSocketPeers is finding the peers of unix sockets with UnixSocketPeers. The peers can be guessed
+	// without it, but the guesses can be wrong.
+	CapabilityUnixSocketPeers = "unix-socket-peers"
+)
+
+func init() {
+	common.RegisterCapability(CapabilityCredentials, credentialsProbe)
+	common.RegisterCapability(CapabilityUnixSocketPeers, unixSocketPeersProbe)
+}
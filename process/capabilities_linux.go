@@ -0,0 +1,43 @@
+package process
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"syscall"
+
+	"github.com/polyverse/masche/common"
+)
+
+// credentialsProbe reads the credentials of the process. Our own status file lacks them only if the kernel is too old,
+// but the status files of other processes are also missing if the proc filesystem hides them from us (hidepid).
+func credentialsProbe(pid int) (common.Availability, string) {
+	if pid == 0 {
+		if _, err := currentCredentials(os.Getpid()); err != nil {
+			return common.UnsupportedKernel, err.Error()
+		}
+		return common.Available, ""
+	}
+	if _, err := currentCredentials(pid); err != nil {
+		return common.NeedsPrivilege, err.Error()
+	}
+	return common.Available, ""
+}
+
+// unixSocketPeersProbe opens a sock_diag(7) socket on the host, and lists the file descriptors of processes.
+func unixSocketPeersProbe(pid int) (common.Availability, string) {
+	if pid != 0 {
+		if _, err := ioutil.ReadDir(common.ProcFilePath(uint(pid), "fd")); err != nil {
+			return common.NeedsPrivilege, fmt.Sprintf("Unable to list the file descriptors of process %d (%v)", pid,
+				err)
+		}
+		return common.Available, ""
+	}
+
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, netlinkSockDiag)
+	if err != nil {
+		return common.UnsupportedKernel, fmt.Sprintf("sock_diag is unavailable (%v), so peers can only be guessed", err)
+	}
+	syscall.Close(fd)
+	return common.Available, ""
+}
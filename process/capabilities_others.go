@@ -0,0 +1,15 @@
+// +build windows darwin
+
+package process
+
+import (
+	"github.com/polyverse/masche/common"
+)
+
+func credentialsProbe(pid int) (common.Availability, string) {
+	return common.UnsupportedPlatform, "credentials are only read on linux"
+}
+
+func unixSocketPeersProbe(pid int) (common.Availability, string) {
+	return common.UnsupportedPlatform, "unix sockets are only listed on linux"
+}
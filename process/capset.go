@@ -0,0 +1,88 @@
+package process
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// CapabilitySet is a set of Linux capabilities, like the effective set of a process, where bit n is capability n.
+// It's a slice of 64 bit words, the lowest first, so the sets of kernels with more than 64 capabilities fit in it.
+// Its JSON is the names of its capabilities.
+type CapabilitySet []uint64
+
+// capabilityNames are the names of the capabilities, by number, as of Linux 5.9.
+var capabilityNames = []string{
+	"CAP_CHOWN", "CAP_DAC_OVERRIDE", "CAP_DAC_READ_SEARCH", "CAP_FOWNER", "CAP_FSETID", "CAP_KILL", "CAP_SETGID",
+	"CAP_SETUID", "CAP_SETPCAP", "CAP_LINUX_IMMUTABLE", "CAP_NET_BIND_SERVICE", "CAP_NET_BROADCAST", "CAP_NET_ADMIN",
+	"CAP_NET_RAW", "CAP_IPC_LOCK", "CAP_IPC_OWNER", "CAP_SYS_MODULE", "CAP_SYS_RAWIO", "CAP_SYS_CHROOT",
+	"CAP_SYS_PTRACE", "CAP_SYS_PACCT", "CAP_SYS_ADMIN", "CAP_SYS_BOOT", "CAP_SYS_NICE", "CAP_SYS_RESOURCE",
+	"CAP_SYS_TIME", "CAP_SYS_TTY_CONFIG", "CAP_MKNOD", "CAP_LEASE", "CAP_AUDIT_WRITE", "CAP_AUDIT_CONTROL",
+	"CAP_SETFCAP", "CAP_MAC_OVERRIDE", "CAP_MAC_ADMIN", "CAP_SYSLOG", "CAP_WAKE_ALARM", "CAP_BLOCK_SUSPEND",
+	"CAP_AUDIT_READ", "CAP_PERFMON", "CAP_BPF", "CAP_CHECKPOINT_RESTORE",
+}
+
+// Capabilities that tell what a process can do to others, for CapabilitySet.Has.
+const (
+	CapSysModule = 16
+	CapSysPtrace = 19
+	CapSysAdmin  = 21
+)
+
+// ParseCapabilitySet parses a capability set written in hexadecimal, as in the Cap lines of /proc/<pid>/status. It
+// can have any amount of digits.
+func ParseCapabilitySet(s string) (CapabilitySet, error) {
+	if s == "" {
+		return nil, fmt.Errorf("Empty capability set")
+	}
+	var set CapabilitySet
+	for end := len(s); end > 0; end -= 16 {
+		start := end - 16
+		if start < 0 {
+			start = 0
+		}
+		word, err := strconv.ParseUint(s[start:end], 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid capability set %s", s)
+		}
+		set = append(set, word)
+	}
+	return set, nil
+}
+
+// Has tells if the set has the capability with the given number, like CapSysPtrace.
+func (s CapabilitySet) Has(capability int) bool {
+	if capability < 0 || capability/64 >= len(s) {
+		return false
+	}
+	return s[capability/64]&(1<<uint(capability%64)) != 0
+}
+
+// Names returns the names of the capabilities in the set, by number, like CAP_SYS_PTRACE. The capabilities newer
+// than masche are named CAP_UNKNOWN_<number>.
+func (s CapabilitySet) Names() []string {
+	names := []string{}
+	for i, word := range s {
+		for word != 0 {
+			bit := bits.TrailingZeros64(word)
+			word &^= 1 << uint(bit)
+			capability := i*64 + bit
+			if capability < len(capabilityNames) {
+				names = append(names, capabilityNames[capability])
+			} else {
+				names = append(names, fmt.Sprintf("CAP_UNKNOWN_%d", capability))
+			}
+		}
+	}
+	return names
+}
+
+func (s CapabilitySet) String() string {
+	return strings.Join(s.Names(), ",")
+}
+
+func (s CapabilitySet) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.Names())
+}
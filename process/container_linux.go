@@ -0,0 +1,78 @@
+package process
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/polyverse/masche/common"
+)
+
+// readCgroups returns the lines of the cgroup file of pid, each a hierarchy id, its controllers and the path of the
+// process in it, like "4:memory:/docker/<id>".
+func readCgroups(pid int) ([]string, error) {
+	path := common.ProcFilePath(uint(pid), "cgroup")
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read proc %d's cgroup file at %s (%v)", pid, path, err)
+	}
+	var cgroups []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			cgroups = append(cgroups, line)
+		}
+	}
+	return cgroups, nil
+}
+
+// containerId finds the id of the container in the paths of cgroups, the 64 hex characters component that docker,
+// containerd and cri-o name their cgroups with: /docker/<id>, /system.slice/docker-<id>.scope,
+// /kubepods/.../cri-containerd-<id>.scope or /kubepods/.../crio-<id>.scope. It's empty if no path has one.
+func containerId(cgroups []string) string {
+	for _, line := range cgroups {
+		fields := strings.SplitN(line, ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		for _, component := range strings.Split(fields[2], "/") {
+			component = strings.TrimSuffix(component, ".scope")
+			component = component[strings.LastIndex(component, "-")+1:]
+			if isContainerId(component) {
+				return component
+			}
+		}
+	}
+	return ""
+}
+
+func isContainerId(s string) bool {
+	if len(s) != 64 {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// namespaceInode returns the inode of the namespace of kind of pid, from the target of its link in the ns directory,
+// like "pid:[4026531836]".
+func namespaceInode(pid int, kind string) (uint64, error) {
+	path := common.ProcFilePath(uint(pid), "ns/"+kind)
+	target, err := os.Readlink(path)
+	if err != nil {
+		return 0, fmt.Errorf("Unable to read proc %d's %s namespace at %s (%v)", pid, kind, path, err)
+	}
+	if !strings.HasPrefix(target, kind+":[") || !strings.HasSuffix(target, "]") {
+		return 0, fmt.Errorf("Unexpected %s namespace %q of proc %d", kind, target, pid)
+	}
+	inode, err := strconv.ParseUint(target[len(kind)+2:len(target)-1], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Unexpected %s namespace %q of proc %d (%v)", kind, target, pid, err)
+	}
+	return inode, nil
+}
@@ -0,0 +1,52 @@
+package process
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Credentials are the identities and capabilities of a process, which decide what it's allowed to do.
+type Credentials struct {
+	// Uids and Gids are the real, effective, saved set and filesystem ids.
+	Uids [4]int `json:"uids"`
+	Gids [4]int `json:"gids"`
+	// The inheritable, permitted, effective, bounding and ambient capability sets.
+	CapInh uint64 `json:"capInh"`
+	CapPrm uint64 `json:"capPrm"`
+	CapEff uint64 `json:"capEff"`
+	CapBnd uint64 `json:"capBnd"`
+	CapAmb uint64 `json:"capAmb"`
+}
+
+// CurrentCredentials returns the credentials p has now. It's only implemented on Linux, where reading them costs a
+// read of /proc/<pid>/status.
+func CurrentCredentials(p Process) (creds Credentials, harderror error) {
+	return currentCredentials(p.Pid())
+}
+
+// ErrCredentialsChanged is the error matched by every CredentialsError.
+var ErrCredentialsChanged = errors.New("the process changed its credentials")
+
+// CredentialsError reports that a process changed its credentials during an operation, with setuid(2) or similar, so
+// the memory read before and after the change may mean different things.
+type CredentialsError struct {
+	Pid    int         `json:"pid"`
+	Before Credentials `json:"before"`
+	After  Credentials `json:"after"`
+}
+
+func (e *CredentialsError) Error() string {
+	return fmt.Sprintf("Process %d: %v (uids %v to %v, gids %v to %v, effective capabilities %x to %x)", e.Pid,
+		ErrCredentialsChanged, e.Before.Uids, e.After.Uids, e.Before.Gids, e.After.Gids, e.Before.CapEff,
+		e.After.CapEff)
+}
+
+// Location makes CredentialsErrors sort by their process in softerrors. See common.SortSoftErrors.
+func (e *CredentialsError) Location() (pid int, address uintptr) {
+	return e.Pid, 0
+}
+
+// Unwrap makes errors.Is(err, ErrCredentialsChanged) true for every CredentialsError.
+func (e *CredentialsError) Unwrap() error {
+	return ErrCredentialsChanged
+}
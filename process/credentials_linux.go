@@ -0,0 +1,45 @@
+package process
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/polyverse/masche/common"
+)
+
+func currentCredentials(pid int) (creds Credentials, harderror error) {
+	statusPath := common.ProcFilePath(uint(pid), "status")
+	data, err := ioutil.ReadFile(statusPath)
+	if err != nil {
+		return creds, fmt.Errorf("Unable to read proc %d's status file at %s (%v)", pid, statusPath, err)
+	}
+
+	ids := map[string]*[4]int{"Uid": &creds.Uids, "Gid": &creds.Gids}
+	caps := map[string]*uint64{"CapInh": &creds.CapInh, "CapPrm": &creds.CapPrm, "CapEff": &creds.CapEff,
+		"CapBnd": &creds.CapBnd, "CapAmb": &creds.CapAmb}
+	err = forEachStatusLine(data, func(key string, value string) error {
+		if target, ok := ids[key]; ok {
+			fields := strings.Fields(value)
+			if len(fields) != len(target) {
+				return fmt.Errorf("Unrecognised %s line in %s: %s", key, statusPath, value)
+			}
+			for i, field := range fields {
+				id, err := strconv.Atoi(field)
+				if err != nil {
+					return fmt.Errorf("Unrecognised %s line in %s: %s", key, statusPath, value)
+				}
+				target[i] = id
+			}
+		} else if target, ok := caps[key]; ok {
+			set, err := strconv.ParseUint(value, 16, 64)
+			if err != nil {
+				return fmt.Errorf("Unrecognised %s line in %s: %s", key, statusPath, value)
+			}
+			*target = set
+		}
+		return nil
+	})
+	return creds, err
+}
@@ -0,0 +1,11 @@
+// +build windows darwin
+
+package process
+
+import (
+	"fmt"
+)
+
+func currentCredentials(pid int) (creds Credentials, harderror error) {
+	return Credentials{}, fmt.Errorf("CurrentCredentials is not implemented on this platform")
+}
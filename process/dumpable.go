@@ -0,0 +1,38 @@
+package process
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// Dumpability tells if a process is dumpable, which on Linux decides who can read its memory: the memory of a process
+// that isn't dumpable can only be read with CAP_SYS_PTRACE, even by its own user. Processes stop being dumpable when
+// they call prctl(PR_SET_DUMPABLE, 0), and when they change their user, like setuid binaries do.
+type Dumpability int
+
+const (
+	// DumpabilityUnknown means that it can't be told if the process is dumpable, like on other systems than Linux.
+	DumpabilityUnknown Dumpability = iota
+	// NotDumpable means that only processes with CAP_SYS_PTRACE can read the memory of the process.
+	NotDumpable
+	// Dumpable means that the processes of the same user can read the memory of the process.
+	Dumpable
+)
+
+func (d Dumpability) String() string {
+	switch d {
+	case NotDumpable:
+		return "NotDumpable"
+	case Dumpable:
+		return "Dumpable"
+	}
+	return "Unknown"
+}
+
+func (d Dumpability) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// ErrNotDumpable is wrapped by the soft errors of AccessLevel when the memory of a process can't be read and the
+// process isn't dumpable, which is why.
+var ErrNotDumpable = errors.New("the process is not dumpable")
@@ -0,0 +1,75 @@
+package process
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"syscall"
+
+	"github.com/polyverse/masche/common"
+)
+
+// parseDumpability parses the Dumpable key of the status file, which some kernels have: 1 is dumpable, and 0 or 2,
+// dumpable only by root, aren't.
+func parseDumpability(value string) (Dumpability, error) {
+	switch value {
+	case "1":
+		return Dumpable, nil
+	case "0", "2":
+		return NotDumpable, nil
+	}
+	return DumpabilityUnknown, fmt.Errorf("Invalid dumpable value %s", value)
+}
+
+// inferDumpability tells if the process with the given effective uid is dumpable by the owner of the files in its proc
+// directory, which the kernel makes root while it isn't. The directory itself keeps its owner. The processes of root
+// can't be told apart.
+func inferDumpability(pid int, euid int) Dumpability {
+	if euid == 0 {
+		return DumpabilityUnknown
+	}
+	info, err := os.Stat(common.ProcFilePath(uint(pid), "status"))
+	if err != nil {
+		return DumpabilityUnknown
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return DumpabilityUnknown
+	}
+	switch int(stat.Uid) {
+	case euid:
+		return Dumpable
+	case 0:
+		return NotDumpable
+	}
+	return DumpabilityUnknown
+}
+
+// dumpability tells if the process with the given pid is dumpable, as linuxProcessInfo.Dumpable.
+func dumpability(pid int) Dumpability {
+	data, err := ioutil.ReadFile(common.ProcFilePath(uint(pid), "status"))
+	if err != nil {
+		return DumpabilityUnknown
+	}
+	var status struct {
+		EffectiveUserId int         `statusFileKey:"Uid,1"`
+		Dumpable        Dumpability `statusFileKey:"Dumpable"`
+	}
+	if err := parseStatusToStruct(data, &status); err != nil {
+		return DumpabilityUnknown
+	}
+	if status.Dumpable == DumpabilityUnknown {
+		return inferDumpability(pid, status.EffectiveUserId)
+	}
+	return status.Dumpable
+}
+
+// withDumpability adds to the soft errors of an access level that can't read the memory of p the reason, when it's
+// that p isn't dumpable.
+func withDumpability(p Process, softerrors []error) []error {
+	if dumpability(p.Pid()) != NotDumpable {
+		return softerrors
+	}
+	return append(softerrors, &common.LocatedError{Pid: p.Pid(),
+		Err: fmt.Errorf("Unable to read memory of process %d (%w)", p.Pid(), ErrNotDumpable)})
+}
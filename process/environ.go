@@ -0,0 +1,33 @@
+package process
+
+import (
+	"fmt"
+
+	"github.com/polyverse/masche/common"
+)
+
+// ErrPermissionDenied is the error matched by every PermissionError, and by the errors of the platforms that deny the
+// access to a process.
+var ErrPermissionDenied = common.ErrPermissionDenied
+
+// PermissionError reports that a file of a process couldn't be read because it belongs to another user. It tells
+// such processes apart from the ones that exited, whose files are gone.
+type PermissionError struct {
+	Pid  int    `json:"pid"`
+	Path string `json:"path"`
+	Err  error  `json:"-"`
+}
+
+func (e *PermissionError) Error() string {
+	return fmt.Sprintf("Process %d: %v reading %s (%v)", e.Pid, ErrPermissionDenied, e.Path, e.Err)
+}
+
+// Location makes PermissionErrors sort by their process in softerrors. See common.SortSoftErrors.
+func (e *PermissionError) Location() (pid int, address uintptr) {
+	return e.Pid, 0
+}
+
+// Unwrap makes errors.Is(err, ErrPermissionDenied) true for every PermissionError.
+func (e *PermissionError) Unwrap() error {
+	return ErrPermissionDenied
+}
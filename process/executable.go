@@ -0,0 +1,36 @@
+package process
+
+// ExeState tells if the executable a process runs is still the file at its path.
+type ExeState string
+
+const (
+	// ExePresent is an executable that is still the file at its path.
+	ExePresent ExeState = "present"
+	// ExeDeleted is an executable that was deleted, and nothing took its path.
+	ExeDeleted ExeState = "deleted"
+	// ExeReplaced is an executable whose path is now another file, like after an upgrade that renamed a new version
+	// over it, or a binary swapped after the process started.
+	ExeReplaced ExeState = "replaced"
+)
+
+// ExeStatus describes the executable a process runs, and the file found at its path.
+type ExeStatus struct {
+	// Path is where the process was started from, without the " (deleted)" suffix the kernel adds to it.
+	Path  string   `json:"path"`
+	State ExeState `json:"state"`
+	// Device and Inode identify the file the process runs, even if it was deleted.
+	Device uint64 `json:"device"`
+	Inode  uint64 `json:"inode"`
+	// PathDevice and PathInode identify the file at Path when the executable was replaced.
+	PathDevice uint64 `json:"pathDevice,omitempty"`
+	PathInode  uint64 `json:"pathInode,omitempty"`
+}
+
+// ExecutableStatus tells if the executable p runs was deleted or replaced after it started, as processes running a
+// binary that isn't on disk anymore are a common sign of an intrusion, and of services that weren't restarted after
+// an upgrade. It's only implemented on Linux.
+//
+// Kernel threads have no executable, so their status is a harderror.
+func ExecutableStatus(p Process) (status ExeStatus, harderror error) {
+	return executableStatus(p.Pid())
+}
@@ -0,0 +1,50 @@
+package process
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/polyverse/masche/common"
+)
+
+// deletedSuffix is appended by the kernel to the target of /proc/PID/exe when the executable was deleted.
+const deletedSuffix = " (deleted)"
+
+func executableStatus(pid int) (status ExeStatus, harderror error) {
+	exePath := common.ProcFilePath(uint(pid), "exe")
+	link, err := os.Readlink(exePath)
+	if err != nil {
+		return status, fmt.Errorf("Unable to read the executable of process %d (%v)", pid, err)
+	}
+
+	// Stat follows the link to the file the process runs, even if it was deleted.
+	var exe syscall.Stat_t
+	if err := syscall.Stat(exePath, &exe); err != nil {
+		return status, fmt.Errorf("Unable to stat the executable of process %d (%v)", pid, err)
+	}
+	status = ExeStatus{Path: link, State: ExePresent, Device: uint64(exe.Dev), Inode: uint64(exe.Ino)}
+
+	// The paths are looked up from the root of the process, which is another one in a container. The suffix is only
+	// trimmed if there's no file with it, which is the one the process runs.
+	root := common.ProcFilePath(uint(pid), "root")
+	var onDisk syscall.Stat_t
+	err = syscall.Stat(filepath.Join(root, link), &onDisk)
+	if os.IsNotExist(err) && strings.HasSuffix(link, deletedSuffix) {
+		status.Path = strings.TrimSuffix(link, deletedSuffix)
+		err = syscall.Stat(filepath.Join(root, status.Path), &onDisk)
+	}
+
+	switch {
+	case os.IsNotExist(err):
+		status.State = ExeDeleted
+	case err != nil:
+		return status, fmt.Errorf("Unable to stat %s, the executable of process %d (%v)", status.Path, pid, err)
+	case onDisk.Dev != exe.Dev || onDisk.Ino != exe.Ino:
+		status.State = ExeReplaced
+		status.PathDevice, status.PathInode = uint64(onDisk.Dev), uint64(onDisk.Ino)
+	}
+	return status, nil
+}
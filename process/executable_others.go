@@ -0,0 +1,11 @@
+// +build windows darwin
+
+package process
+
+import (
+	"fmt"
+)
+
+func executableStatus(pid int) (status ExeStatus, harderror error) {
+	return ExeStatus{}, fmt.Errorf("ExecutableStatus is not implemented on this platform")
+}
@@ -0,0 +1,32 @@
+package process
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+)
+
+// HashExecutable returns the SHA-256 of the executable of p, in hex. Hashing large executables is expensive, so it's
+// only done when asked, here or with InfoOptions.HashExecutable. On Linux the executable is read through
+// /proc/<pid>/exe, which is the file the process runs even if it was deleted or replaced on disk since it started.
+// Elsewhere the file at the path of the executable is hashed.
+func HashExecutable(p Process) (digest string, err error) {
+	return hashExecutable(p.Pid())
+}
+
+// hashFile returns the SHA-256 of the file at path, in hex.
+func hashFile(pid int, path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("Unable to hash the executable of process %d (%v)", pid, err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("Unable to hash the executable of process %d (%v)", pid, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,7 @@
+package process
+
+import "github.com/polyverse/masche/common"
+
+func hashExecutable(pid int) (string, error) {
+	return hashFile(pid, common.ProcFilePath(uint(pid), "exe"))
+}
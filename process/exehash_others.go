@@ -0,0 +1,11 @@
+// +build windows darwin
+
+package process
+
+func hashExecutable(pid int) (string, error) {
+	exe, err := processExe(pid)
+	if err != nil {
+		return "", err
+	}
+	return hashFile(pid, exe)
+}
@@ -0,0 +1,55 @@
+package process
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/polyverse/masche/common"
+)
+
+// ErrProcessExited is the error matched by every ExitedError.
+var ErrProcessExited = errors.New("the process exited")
+
+// ErrProcessNotFound is the error matched by every NotFoundError, and by the errors of the platforms that can't find a
+// process.
+var ErrProcessNotFound = common.ErrProcessNotFound
+
+// NotFoundError reports that there is no process with a pid, when it's opened or its info is read. The processes that
+// exit after being opened report ExitedErrors instead.
+type NotFoundError struct {
+	Pid int `json:"pid"`
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("Process %d: %v", e.Pid, ErrProcessNotFound)
+}
+
+// Location makes NotFoundErrors sort by their process in softerrors. See common.SortSoftErrors.
+func (e *NotFoundError) Location() (pid int, address uintptr) {
+	return e.Pid, 0
+}
+
+// Unwrap makes errors.Is(err, ErrProcessNotFound) true for every NotFoundError.
+func (e *NotFoundError) Unwrap() error {
+	return ErrProcessNotFound
+}
+
+// ExitedError reports that an operation failed because its process exited, or because its pid now belongs to another
+// process. Unlike other errors it won't go away by retrying the operation, so the Process should be closed.
+type ExitedError struct {
+	Pid int `json:"pid"`
+}
+
+func (e *ExitedError) Error() string {
+	return fmt.Sprintf("Process %d: %v", e.Pid, ErrProcessExited)
+}
+
+// Location makes ExitedErrors sort by their process in softerrors. See common.SortSoftErrors.
+func (e *ExitedError) Location() (pid int, address uintptr) {
+	return e.Pid, 0
+}
+
+// Unwrap makes errors.Is(err, ErrProcessExited) true for every ExitedError.
+func (e *ExitedError) Unwrap() error {
+	return ErrProcessExited
+}
@@ -0,0 +1,53 @@
+package process
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Image identifies the program a process is running. It changes when the process calls exec(2), even though its pid
+// stays the same.
+type Image struct {
+	// Executable is the path of the executable, as reported by the OS. It's only for reporting: it changes when the
+	// executable is renamed, or deleted or replaced on disk, like by a package upgrade, while the process runs it.
+	Executable string `json:"executable"`
+	// fingerprint tells apart images, even of the same executable.
+	fingerprint uint64
+}
+
+// Same tells if i and other are the same image of the same process, by their fingerprints alone.
+func (i Image) Same(other Image) bool {
+	return i.fingerprint == other.fingerprint
+}
+
+// CurrentImage returns the image p is running. It's only implemented on Linux, where exec is detected by the inode of
+// the executable and the auxiliary vector of the process, which the kernel fills anew on every exec. An exec of the
+// same executable can only be told apart if the address space is randomized.
+func CurrentImage(p Process) (image Image, harderror error) {
+	return currentImage(p.Pid())
+}
+
+// ErrProcessExeced is the error matched by every ExecError.
+var ErrProcessExeced = errors.New("the process replaced its image with exec")
+
+// ExecError reports that a process replaced its image with exec during an operation, so the memory it read belongs to
+// two different programs. The operation should be repeated if the new image is of interest.
+type ExecError struct {
+	Pid           int    `json:"pid"`
+	OldExecutable string `json:"oldExecutable"`
+	NewExecutable string `json:"newExecutable"`
+}
+
+func (e *ExecError) Error() string {
+	return fmt.Sprintf("Process %d: %v (%s to %s)", e.Pid, ErrProcessExeced, e.OldExecutable, e.NewExecutable)
+}
+
+// Location makes ExecErrors sort by their process in softerrors. See common.SortSoftErrors.
+func (e *ExecError) Location() (pid int, address uintptr) {
+	return e.Pid, 0
+}
+
+// Unwrap makes errors.Is(err, ErrProcessExeced) true for every ExecError.
+func (e *ExecError) Unwrap() error {
+	return ErrProcessExeced
+}
@@ -0,0 +1,35 @@
+package process
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"os"
+	"syscall"
+
+	"github.com/polyverse/masche/common"
+)
+
+func currentImage(pid int) (image Image, harderror error) {
+	exePath := common.ProcFilePath(uint(pid), "exe")
+	executable, err := os.Readlink(exePath)
+	if err != nil {
+		return Image{}, fmt.Errorf("Unable to read the executable of process %d (%v)", pid, err)
+	}
+
+	// Stat follows the link, so it works even if the executable was deleted.
+	var st syscall.Stat_t
+	if err := syscall.Stat(exePath, &st); err != nil {
+		return Image{}, fmt.Errorf("Unable to stat the executable of process %d (%v)", pid, err)
+	}
+
+	auxv, err := ioutil.ReadFile(common.ProcFilePath(uint(pid), "auxv"))
+	if err != nil {
+		return Image{}, fmt.Errorf("Unable to read the auxiliary vector of process %d (%v)", pid, err)
+	}
+
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%d:%d:", st.Dev, st.Ino)
+	h.Write(auxv)
+	return Image{Executable: executable, fingerprint: h.Sum64()}, nil
+}
@@ -0,0 +1,11 @@
+// +build windows darwin
+
+package process
+
+import (
+	"fmt"
+)
+
+func currentImage(pid int) (image Image, harderror error) {
+	return Image{}, fmt.Errorf("CurrentImage is not implemented on this platform")
+}
@@ -0,0 +1,46 @@
+package process
+
+import (
+	"fmt"
+	"net/netip"
+
+	"github.com/polyverse/masche/common"
+)
+
+// InetSocket is a TCP or UDP socket open in a process.
+type InetSocket struct {
+	Fd int `json:"fd"`
+	// Protocol is tcp or udp, for both IPv4 and IPv6.
+	Protocol string `json:"protocol"`
+	// Local and Remote are the addresses of the socket. The remote address is unspecified, like 0.0.0.0:0, for
+	// sockets that aren't connected. IPv4 addresses mapped to IPv6 ones are reported as IPv4 addresses.
+	Local  netip.AddrPort `json:"local"`
+	Remote netip.AddrPort `json:"remote"`
+	// State is the state of TCP sockets, like LISTEN or ESTABLISHED. It's empty for UDP sockets.
+	State string `json:"state,omitempty"`
+	Inode uint64 `json:"inode"`
+}
+
+// Listening is true for TCP sockets accepting connections, and for UDP sockets that aren't connected.
+func (s InetSocket) Listening() bool {
+	if s.Protocol == "tcp" {
+		return s.State == "LISTEN"
+	}
+	return !s.Remote.Addr().IsValid() || s.Remote.Addr().IsUnspecified()
+}
+
+// String describes the socket as OpenFile.Description does, like "tcp 127.0.0.1:8080->127.0.0.1:41234 ESTABLISHED".
+func (s InetSocket) String() string {
+	description := fmt.Sprintf("%s %s->%s", s.Protocol, s.Local, s.Remote)
+	if s.State != "" {
+		description += " " + s.State
+	}
+	return description
+}
+
+// InetSockets lists the TCP and UDP sockets p has open, sorted by file descriptor. They are read from the tables of
+// the network namespace of p, so the addresses of a process in a container are the ones it sees. It's only
+// implemented on Linux. The tables that can't be read are left out with a softerror.
+func InetSockets(p Process) (sockets []InetSocket, harderror error, softerrors []error) {
+	return common.Result(inetSockets(p.Pid()))
+}
@@ -0,0 +1,127 @@
+package process
+
+import (
+	"sync"
+	"time"
+)
+
+// CachedProcess is a Process whose name and ProcessInfo are cached, so reading them repeatedly is nearly free.
+//
+// The cached values are dropped when they are older than the maximum age, when Refresh is called, and when the
+// process is found to have exited. In the last case an error is returned instead of reading the metadata again, as
+// its pid could already belong to another process.
+type CachedProcess struct {
+	Process
+	maxAge   time.Duration
+	identity *identity
+
+	mu          sync.Mutex
+	info        ProcessInfo
+	infoFetched time.Time
+	name        string
+	nameFetched time.Time
+	hasInfo     bool
+	hasName     bool
+}
+
+// NewCachedProcess wraps p in a CachedProcess. If maxAge is zero the cached values never get too old, and they are
+// only dropped by Refresh or when the process exits.
+//
+// On Darwin the reuse of a pid is not detected, so the cached values can belong to a process that already exited
+// for up to maxAge.
+func NewCachedProcess(p Process, maxAge time.Duration) (c *CachedProcess, harderror error, softerrors []error) {
+	id, err := newIdentity(p.Pid())
+	if err != nil {
+		return nil, err, nil
+	}
+	return &CachedProcess{Process: p, maxAge: maxAge, identity: id}, nil, nil
+}
+
+// Unwrap returns the process c wraps.
+func (c *CachedProcess) Unwrap() Process {
+	return c.Process
+}
+
+// Info returns the ProcessInfo of the process, reading it only if there's no valid cached one.
+func (c *CachedProcess) Info() (info ProcessInfo, harderror error, softerrors []error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if harderror = c.checkAlive(); harderror != nil {
+		return nil, harderror, nil
+	}
+	if c.hasInfo && c.young(c.infoFetched) {
+		return c.info, nil, nil
+	}
+
+	i, harderror, softerrors := processInfo(c.Pid(), InfoOptions{})
+	if harderror != nil {
+		return nil, harderror, softerrors
+	}
+	c.info, c.infoFetched, c.hasInfo = i, time.Now(), true
+	return c.info, nil, softerrors
+}
+
+// Name returns the name of the process, reading it only if there's no valid cached one.
+func (c *CachedProcess) Name() (name string, harderror error, softerrors []error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if harderror = c.checkAlive(); harderror != nil {
+		return "", harderror, nil
+	}
+	if c.hasName && c.young(c.nameFetched) {
+		return c.name, nil, nil
+	}
+
+	name, harderror, softerrors = c.Process.Name()
+	if harderror != nil {
+		return "", harderror, softerrors
+	}
+	c.name, c.nameFetched, c.hasName = name, time.Now(), true
+	return c.name, nil, softerrors
+}
+
+// Refresh drops the cached values, so the next calls read them again.
+func (c *CachedProcess) Refresh() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.hasInfo, c.hasName = false, false
+}
+
+// IsAlive checks the process with the identity taken when c was created, which is a pidfd on recent Linux kernels.
+// The cached values are dropped if it exited.
+func (c *CachedProcess) IsAlive() (alive bool, harderror error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	alive, harderror = c.identity.alive()
+	if harderror != nil || !alive {
+		c.hasInfo, c.hasName = false, false
+	}
+	return alive, harderror
+}
+
+func (c *CachedProcess) Close() (harderror error, softerrors []error) {
+	c.identity.close()
+	return c.Process.Close()
+}
+
+func (c *CachedProcess) young(fetched time.Time) bool {
+	return c.maxAge == 0 || time.Since(fetched) <= c.maxAge
+}
+
+// checkAlive drops the cached values and returns an error if the process exited.
+func (c *CachedProcess) checkAlive() error {
+	alive, err := c.identity.alive()
+	if err == nil && alive {
+		return nil
+	}
+
+	c.hasInfo, c.hasName = false, false
+	if err != nil {
+		return err
+	}
+	return &ExitedError{Pid: c.Pid()}
+}
@@ -0,0 +1,21 @@
+package process
+
+import (
+	"syscall"
+)
+
+// identity tells if a process is still running. Darwin has no way to tell apart processes that got the same pid.
+type identity struct {
+	pid int
+}
+
+func newIdentity(pid int) (*identity, error) {
+	return &identity{pid: pid}, nil
+}
+
+func (id *identity) alive() (bool, error) {
+	return syscall.Kill(id.pid, 0) != syscall.ESRCH, nil
+}
+
+func (id *identity) close() {
+}
@@ -0,0 +1,63 @@
+package process
+
+import (
+	"syscall"
+)
+
+// pidfd_send_signal has the same number in every architecture.
+const sysPidfdSendSignal = 424
+
+// identity tells if a process is still the same one it was when the identity was created. It uses a pidfd when
+// available, which makes the check a single syscall, and compares the start time of the process otherwise.
+type identity struct {
+	pid       int
+	startTime uint64
+	pidfd     int
+}
+
+func newIdentity(pid int) (*identity, error) {
+	startTime, alive, err := startTimeIfAlive(pid)
+	if err != nil {
+		return nil, err
+	}
+	if !alive {
+		return nil, &ExitedError{Pid: pid}
+	}
+
+	id := &identity{pid: pid, startTime: startTime, pidfd: -1}
+	if usePidfd() {
+		fd, _, errno := syscall.Syscall(sysPidfdOpen, uintptr(pid), 0, 0)
+		if errno == 0 {
+			id.pidfd = int(fd)
+		}
+
+		// The pid could have been reused before opening the pidfd.
+		if running, err := stillRunning(pid, startTime); err != nil || !running {
+			id.close()
+			return nil, &ExitedError{Pid: pid}
+		}
+	}
+	return id, nil
+}
+
+func (id *identity) alive() (bool, error) {
+	if id.pidfd == -1 {
+		return stillRunning(id.pid, id.startTime)
+	}
+
+	// Sending the signal 0 only checks that the process exists.
+	_, _, errno := syscall.Syscall6(sysPidfdSendSignal, uintptr(id.pidfd), 0, 0, 0, 0, 0)
+	if errno == syscall.ESRCH {
+		return false, nil
+	} else if errno != 0 {
+		return stillRunning(id.pid, id.startTime)
+	}
+	return true, nil
+}
+
+func (id *identity) close() {
+	if id.pidfd != -1 {
+		syscall.Close(id.pidfd)
+		id.pidfd = -1
+	}
+}
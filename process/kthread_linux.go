@@ -0,0 +1,44 @@
+package process
+
+import (
+	"io/ioutil"
+
+	"github.com/polyverse/masche/common"
+)
+
+// isKernelThread tells if pid is a kernel thread by the Kthread key of its status file. On older kernels, which don't
+// have it, kernel threads are the processes without a command line, like zombies, that are kthreadd, whose pid is 2,
+// or one of its children.
+func isKernelThread(pid int) (kernel bool, err error) {
+	data, err := ioutil.ReadFile(common.ProcFilePath(uint(pid), "status"))
+	if err != nil {
+		return false, err
+	}
+	var status struct {
+		Kthread string `statusFileKey:"Kthread"`
+		PPid    int    `statusFileKey:"PPid"`
+	}
+	if err := parseStatusToStruct(data, &status); err != nil {
+		return false, err
+	}
+	if status.Kthread != "" {
+		return status.Kthread == "1", nil
+	}
+
+	if pid != 2 && status.PPid != 2 {
+		return false, nil
+	}
+	cmdline, err := ioutil.ReadFile(common.ProcFilePath(uint(pid), "cmdline"))
+	if err != nil {
+		return false, err
+	}
+	return len(cmdline) == 0, nil
+}
+
+func (p linuxProcess) IsKernelThread() (kernel bool, harderror error) {
+	kernel, err := isKernelThread(p.pid)
+	if err != nil {
+		return false, p.exited(err)
+	}
+	return kernel, nil
+}
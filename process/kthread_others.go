@@ -0,0 +1,8 @@
+// +build windows darwin
+
+package process
+
+// isKernelThread is always false on other systems than Linux.
+func isKernelThread(pid int) (kernel bool, err error) {
+	return false, nil
+}
@@ -0,0 +1,37 @@
+package process
+
+import "github.com/polyverse/masche/common"
+
+// MappedRange is a range of a process' address space mapping a file.
+type MappedRange struct {
+	Start       uintptr `json:"start"`
+	End         uintptr `json:"end"`
+	Permissions string  `json:"permissions"`
+	Offset      uint64  `json:"offset"`
+}
+
+// FileMapping describes how a process maps a given file.
+type FileMapping struct {
+	Process Process       `json:"-"`
+	Pid     int           `json:"pid"`
+	Ranges  []MappedRange `json:"ranges"`
+	// Executable is true if any of the ranges mapping the file is executable.
+	Executable bool `json:"executable"`
+}
+
+// ProcessesMappingFile returns all the processes that currently map the file at path, sorted by pid.
+//
+// Files are compared by device and inode, so a process is found even if it mapped the file through another path.
+// Processes whose memory map can't be read are reported as softerrors.
+func ProcessesMappingFile(path string) (mappings []FileMapping, harderror error, softerrors []error) {
+	// This function is implemented by the OS-specific processesMappingFile function.
+	return common.Result(processesMappingFile(path))
+}
+
+// ProcessesMappingInode works as ProcessesMappingFile, but receives the device (as in syscall.Stat_t's Dev) and inode
+// of the file instead of its path. This allows finding processes mapping files that were renamed or deleted.
+func ProcessesMappingInode(dev uint64, inode uint64) (mappings []FileMapping, harderror error,
+	softerrors []error) {
+	// This function is implemented by the OS-specific processesMappingInode function.
+	return processesMappingInode(dev, inode)
+}
@@ -0,0 +1,107 @@
+package process
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"sync"
+	"syscall"
+
+	"github.com/polyverse/masche/common"
+)
+
+func processesMappingFile(path string) (mappings []FileMapping, harderror error, softerrors []error) {
+	var st syscall.Stat_t
+	if err := syscall.Stat(path, &st); err != nil {
+		return nil, fmt.Errorf("Unable to stat %s (%v)", path, err), nil
+	}
+
+	return processesMappingInode(uint64(st.Dev), uint64(st.Ino))
+}
+
+func processesMappingInode(dev uint64, inode uint64) (mappings []FileMapping, harderror error,
+	softerrors []error) {
+
+	pids, harderror, softerrors := GetAllPids()
+	if harderror != nil {
+		return nil, harderror, softerrors
+	}
+
+	major, minor := common.DevMajorMinor(dev)
+	type result struct {
+		mapping FileMapping
+		found   bool
+		err     error
+	}
+	results := make([]result, len(pids))
+
+	// Reading thousands of maps files is mostly waiting for the kernel, so we do it in parallel.
+	var wg sync.WaitGroup
+	indexes := make(chan int)
+	for w := 0; w < runtime.NumCPU(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				results[i].mapping, results[i].found, results[i].err = findInodeMapping(pids[i], major, minor,
+					inode)
+			}
+		}()
+	}
+	for i := range pids {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	for i, r := range results {
+		if r.err != nil {
+			softerrors = append(softerrors, fmt.Errorf("Unable to read memory map of process %d (%v)", pids[i], r.err))
+			continue
+		}
+		if r.found {
+			mappings = append(mappings, r.mapping)
+		}
+	}
+
+	sort.Slice(mappings, func(i, j int) bool { return mappings[i].Pid < mappings[j].Pid })
+	return mappings, nil, softerrors
+}
+
+func findInodeMapping(pid int, major uint32, minor uint32, inode uint64) (mapping FileMapping, found bool,
+	err error) {
+
+	entries, err := common.ReadMapsFile(uint(pid))
+	if err != nil {
+		if os.IsNotExist(err) {
+			// The process has just finished.
+			err = nil
+		}
+		return mapping, false, err
+	}
+
+	for _, entry := range entries {
+		if entry.Inode != inode || entry.DevMajor != major || entry.DevMinor != minor {
+			continue
+		}
+
+		mapping.Ranges = append(mapping.Ranges, MappedRange{
+			Start:       entry.Start,
+			End:         entry.End,
+			Permissions: entry.Permissions,
+			Offset:      entry.Offset,
+		})
+		if entry.Permissions[2] == 'x' {
+			mapping.Executable = true
+		}
+	}
+
+	if len(mapping.Ranges) == 0 {
+		return mapping, false, nil
+	}
+
+	mapping.Pid = pid
+	mapping.Process = getProcess(pid)
+	return mapping, true, nil
+}
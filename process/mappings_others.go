@@ -0,0 +1,16 @@
+// +build windows darwin
+
+package process
+
+import (
+	"fmt"
+)
+
+func processesMappingFile(path string) (mappings []FileMapping, harderror error, softerrors []error) {
+	return nil, fmt.Errorf("ProcessesMappingFile is not implemented on this platform"), nil
+}
+
+func processesMappingInode(dev uint64, inode uint64) (mappings []FileMapping, harderror error,
+	softerrors []error) {
+	return nil, fmt.Errorf("ProcessesMappingInode is not implemented on this platform"), nil
+}
@@ -0,0 +1,46 @@
+package process
+
+import (
+	"sync"
+)
+
+// matchedProcess is a process returned by OpenMatching. Its pid may belong to another process by the time it's used,
+// if it exited after it was listed, so the first use of its memory checks that it's still the process that matched.
+type matchedProcess struct {
+	Process
+	once sync.Once
+	err  error
+}
+
+func (p *matchedProcess) Unwrap() Process {
+	return p.Process
+}
+
+// verify checks, the first time it's called, that the process is still the one that was matched.
+func (p *matchedProcess) verify() error {
+	p.once.Do(func() {
+		// When it can't be told, the use of the process fails by itself if it exited.
+		if alive, err := p.Process.IsAlive(); err == nil && !alive {
+			p.err = &ExitedError{Pid: p.Pid()}
+		}
+	})
+	return p.err
+}
+
+// VerifyMatch returns an *ExitedError if p was opened by OpenByName or OpenMatching, or wraps a process that was, and
+// it exited or its pid was reused by another process since it was matched, which IsAlive tells by the start time of
+// the process on Linux. The process is only checked the first time, which the functions that read the memory of a
+// process do, and later calls return the same result. It returns nil for the rest of the processes.
+func VerifyMatch(p Process) error {
+	for p != nil {
+		if m, ok := p.(*matchedProcess); ok {
+			return m.verify()
+		}
+		w, ok := p.(Wrapper)
+		if !ok {
+			return nil
+		}
+		p = w.Unwrap()
+	}
+	return nil
+}
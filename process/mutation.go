@@ -0,0 +1,149 @@
+package process
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// MutationToken names the process a caller means to modify. Both fields must match the live process, which forces
+// the caller to state what it thinks it's patching instead of trusting a pid that could have a typo.
+type MutationToken struct {
+	Pid        int    `json:"pid"`
+	Executable string `json:"executable"`
+}
+
+// ErrMutationNotAllowed is the error matched by every MutationError.
+var ErrMutationNotAllowed = errors.New("mutation not allowed")
+
+// MutationError reports that a process can't be modified, because it wasn't allowed with AllowMutation or it's not
+// the process that was allowed anymore.
+type MutationError struct {
+	Pid int `json:"pid"`
+	// Mismatch tells what didn't match.
+	Mismatch string `json:"mismatch"`
+}
+
+func (e *MutationError) Error() string {
+	return fmt.Sprintf("Process %d: %v (%s)", e.Pid, ErrMutationNotAllowed, e.Mismatch)
+}
+
+// Location makes MutationErrors sort by their process in softerrors. See common.SortSoftErrors.
+func (e *MutationError) Location() (pid int, address uintptr) {
+	return e.Pid, 0
+}
+
+// Unwrap makes errors.Is(err, ErrMutationNotAllowed) true for every MutationError.
+func (e *MutationError) Unwrap() error {
+	return ErrMutationNotAllowed
+}
+
+// mutationGrant is a process allowed to be modified. Its start time tells it apart from a process that reuses its
+// pid, which could run the same executable.
+type mutationGrant struct {
+	token     MutationToken
+	startTime uint64
+	buildID   []byte
+}
+
+var (
+	mutationMu     sync.Mutex
+	mutationGrants = map[int]mutationGrant{}
+	strictMutation bool
+)
+
+// AllowMutation allows the operations that modify a process to modify p, if token names it: its pid and the path of
+// its executable. Every operation that modifies a process must call CheckMutation first, so none of them can modify
+// the processes that weren't allowed. The grant lasts until RevokeMutation is called.
+func AllowMutation(p Process, token MutationToken) error {
+	grant := mutationGrant{token: token}
+	if err := verifyMutationToken(p, token); err != nil {
+		return err
+	}
+	startTime, err := mutationStartTime(p.Pid())
+	if err != nil {
+		return &MutationError{Pid: p.Pid(), Mismatch: fmt.Sprintf("its start time can't be read (%v)", err)}
+	}
+	grant.startTime = startTime
+	// The build-id is recorded even out of strict mode, so switching to it doesn't allow a different executable.
+	grant.buildID, _ = exeBuildID(p.Pid())
+
+	mutationMu.Lock()
+	defer mutationMu.Unlock()
+	mutationGrants[p.Pid()] = grant
+	return nil
+}
+
+// RevokeMutation stops allowing p to be modified.
+func RevokeMutation(p Process) {
+	mutationMu.Lock()
+	defer mutationMu.Unlock()
+	delete(mutationGrants, p.Pid())
+}
+
+// SetStrictMutation makes CheckMutation also require the build-id of the executable of the process to be the one it
+// had when it was allowed, which tells apart an executable replaced by another build at the same path. Processes
+// whose build-id can't be read can't be modified in strict mode. It's only implemented on Linux.
+func SetStrictMutation(strict bool) {
+	mutationMu.Lock()
+	defer mutationMu.Unlock()
+	strictMutation = strict
+}
+
+// CheckMutation returns a *MutationError unless p was allowed to be modified with AllowMutation, and it's still the
+// process that was allowed: the same one that is alive, with the pid and the executable of its token, and the start
+// time it had when it was allowed.
+func CheckMutation(p Process) error {
+	mutationMu.Lock()
+	grant, allowed := mutationGrants[p.Pid()]
+	strict := strictMutation
+	mutationMu.Unlock()
+
+	if !allowed {
+		return &MutationError{Pid: p.Pid(), Mismatch: "it wasn't allowed with AllowMutation"}
+	}
+	if err := verifyMutationToken(p, grant.token); err != nil {
+		return err
+	}
+	startTime, err := mutationStartTime(p.Pid())
+	if err != nil {
+		return &MutationError{Pid: p.Pid(), Mismatch: fmt.Sprintf("its start time can't be read (%v)", err)}
+	}
+	if startTime != grant.startTime {
+		return &MutationError{Pid: p.Pid(), Mismatch: fmt.Sprintf(
+			"it started at %d, not %d, so another process reused its pid", startTime, grant.startTime)}
+	}
+	if !strict {
+		return nil
+	}
+	buildID, err := exeBuildID(p.Pid())
+	if err != nil {
+		return &MutationError{Pid: p.Pid(), Mismatch: fmt.Sprintf("its build-id can't be read in strict mode (%v)",
+			err)}
+	}
+	if grant.buildID == nil || !bytes.Equal(buildID, grant.buildID) {
+		return &MutationError{Pid: p.Pid(), Mismatch: fmt.Sprintf("its build-id is %x, not %x", buildID,
+			grant.buildID)}
+	}
+	return nil
+}
+
+// verifyMutationToken checks that token names p, and that p is alive.
+func verifyMutationToken(p Process, token MutationToken) error {
+	if token.Pid != p.Pid() {
+		return &MutationError{Pid: p.Pid(), Mismatch: fmt.Sprintf("the token is for pid %d", token.Pid)}
+	}
+	if alive, err := p.IsAlive(); err != nil || !alive {
+		return &MutationError{Pid: p.Pid(), Mismatch: fmt.Sprintf("it isn't alive (%v)", err)}
+	}
+	exe, err := ProcessExe(p.Pid())
+	if err != nil {
+		return &MutationError{Pid: p.Pid(), Mismatch: fmt.Sprintf("its executable can't be read (%v)", err)}
+	}
+	if exe != token.Executable {
+		return &MutationError{Pid: p.Pid(), Mismatch: fmt.Sprintf("its executable is %s, not %s", exe,
+			token.Executable)}
+	}
+	return nil
+}
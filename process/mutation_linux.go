@@ -0,0 +1,60 @@
+package process
+
+import (
+	"debug/elf"
+	"fmt"
+
+	"github.com/polyverse/masche/common"
+)
+
+// ntGNUBuildID is the type of the note holding the build-id.
+const ntGNUBuildID = 3
+
+// mutationStartTime reads the start time of pid, in clock ticks since boot.
+func mutationStartTime(pid int) (uint64, error) {
+	startTime, alive, err := startTimeIfAlive(pid)
+	if err == nil && !alive {
+		err = &ExitedError{Pid: pid}
+	}
+	return startTime, err
+}
+
+// exeBuildID reads the GNU build-id of the executable of pid from its notes.
+func exeBuildID(pid int) ([]byte, error) {
+	f, err := elf.Open(common.ProcFilePath(uint(pid), "exe"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	for _, prog := range f.Progs {
+		if prog.Type != elf.PT_NOTE {
+			continue
+		}
+		notes := make([]byte, prog.Filesz)
+		if _, err := prog.ReadAt(notes, 0); err != nil {
+			return nil, err
+		}
+		// Each note is a name size, a description size and a type, followed by the name and the description, both
+		// padded to 4 bytes.
+		for len(notes) >= 12 {
+			nameSize := uint64(f.ByteOrder.Uint32(notes))
+			descSize := uint64(f.ByteOrder.Uint32(notes[4:]))
+			noteType := f.ByteOrder.Uint32(notes[8:])
+			descStart := 12 + (nameSize+3)&^3
+			descEnd := descStart + descSize
+			if descEnd > uint64(len(notes)) {
+				break
+			}
+			if noteType == ntGNUBuildID && string(notes[12:12+nameSize]) == "GNU\x00" {
+				return notes[descStart:descEnd], nil
+			}
+			next := (descEnd + 3) &^ 3
+			if next > uint64(len(notes)) {
+				break
+			}
+			notes = notes[next:]
+		}
+	}
+	return nil, fmt.Errorf("No build-id in the executable of process %d", pid)
+}
@@ -0,0 +1,15 @@
+// +build windows darwin
+
+package process
+
+import "errors"
+
+func exeBuildID(pid int) ([]byte, error) {
+	return nil, errors.New("Reading the build-id of an executable is only implemented on Linux")
+}
+
+// mutationStartTime is zero for every process, as their start time isn't read on other systems than Linux, so only
+// their pid and executable tell them apart.
+func mutationStartTime(pid int) (uint64, error) {
+	return 0, nil
+}
@@ -0,0 +1,65 @@
+package process
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/polyverse/masche/common"
+)
+
+// NameSource tells where the name of a process was read from. Each source is less trustworthy than the previous one:
+// the arguments and the comm of a process can be changed by the process itself.
+type NameSource string
+
+const (
+	// NameFromExe is the path of the executable of the process.
+	NameFromExe NameSource = "exe"
+	// NameFromCmdline is the first argument of the process, which is the path it was started with, as given.
+	NameFromCmdline NameSource = "cmdline"
+	// NameFromComm is the short name the kernel keeps for the process, in square brackets like ps(1) shows it.
+	NameFromComm NameSource = "comm"
+	// NameFromStatus is the name in the status file of the process, which is its comm too, in square brackets.
+	NameFromStatus NameSource = "status"
+)
+
+// NameAndSource returns the name of p, as Name does, and where it was read from. On Linux the name is read from the
+// first source that can be read, in the order of the NameSource constants, and the sources that can't be read are
+// reported as softerrors. This lets processes still be found by their name where the executable of other users'
+// processes can't be read, like with hidepid. The harderror is only returned if no source can be read, or an
+// ExitedError if the process exited, even if it wasn't reaped yet. Elsewhere the name is always the executable's.
+func NameAndSource(p Process) (name string, source NameSource, harderror error, softerrors []error) {
+	return nameAndSource(p)
+}
+
+// NameMatch is a process whose name matched, with the name and where it was read from.
+type NameMatch struct {
+	Process
+	Name   string
+	Source NameSource
+}
+
+// OpenByNameMatches works as OpenByName, but returns where the name of each process was read from, so the matches of
+// names that aren't executables can be trusted less.
+func OpenByNameMatches(r *regexp.Regexp) (matches []NameMatch, harderror error, softerrors []error) {
+	procs, harderror, softerrors := OpenAll()
+	if harderror != nil {
+		return nil, harderror, softerrors
+	}
+
+	matches = make([]NameMatch, 0)
+	for _, p := range procs {
+		name, source, err, softs := NameAndSource(p)
+		softerrors = append(softerrors, softs...)
+		if err != nil {
+			softerrors = append(softerrors, &common.LocatedError{Pid: p.Pid(),
+				Err: fmt.Errorf("Unable to match the name of process %d (%w)", p.Pid(), err)})
+		}
+		if err == nil && r.MatchString(name) {
+			matches = append(matches, NameMatch{Process: &matchedProcess{Process: p}, Name: name, Source: source})
+		} else {
+			p.Close()
+		}
+	}
+	common.SortSoftErrors(softerrors)
+	return matches, nil, softerrors
+}
@@ -0,0 +1,83 @@
+package process
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"syscall"
+
+	"github.com/polyverse/masche/common"
+)
+
+// namer is a process that reads its name from its own sources, like the preopened processes.
+type namer interface {
+	nameWithSource() (name string, source NameSource, harderror error, softerrors []error)
+}
+
+func nameAndSource(p Process) (name string, source NameSource, harderror error, softerrors []error) {
+	if n, ok := p.(namer); ok {
+		return n.nameWithSource()
+	}
+	return getProcess(p.Pid()).nameWithSource()
+}
+
+// nameWithSource reads the name of the process from the first source that can be read, in the order of the
+// NameSource constants.
+func (p linuxProcess) nameWithSource() (name string, source NameSource, harderror error, softerrors []error) {
+	name, err := ProcessExe(p.pid)
+	if err == nil {
+		return name, NameFromExe, nil, nil
+	}
+	// The executable of exiting processes and zombies can't be read either, but their name would outlive them in the
+	// other sources.
+	if p.gone() {
+		return "", "", &ExitedError{Pid: p.pid}, nil
+	}
+	softerrors = append(softerrors, &common.LocatedError{Pid: p.pid, Err: err})
+
+	// Kernel threads have no arguments, and an argument can be empty.
+	cmdlinePath := common.ProcFilePath(uint(p.pid), "cmdline")
+	data, err := ioutil.ReadFile(cmdlinePath)
+	if err != nil {
+		softerrors = append(softerrors, &common.LocatedError{Pid: p.pid,
+			Err: fmt.Errorf("Unable to read the arguments of process %d at %s (%v)", p.pid, cmdlinePath, err)})
+	} else if argv0, _, _ := strings.Cut(string(data), "\x00"); argv0 != "" {
+		return argv0, NameFromCmdline, nil, softerrors
+	}
+
+	commPath := common.ProcFilePath(uint(p.pid), "comm")
+	data, err = ioutil.ReadFile(commPath)
+	if err == nil {
+		return "[" + strings.TrimSuffix(string(data), "\n") + "]", NameFromComm, nil, softerrors
+	}
+	softerrors = append(softerrors, &common.LocatedError{Pid: p.pid,
+		Err: fmt.Errorf("Unable to read the comm of process %d at %s (%v)", p.pid, commPath, err)})
+
+	name, err = statusName(p.pid)
+	if err == nil {
+		return name, NameFromStatus, nil, softerrors
+	}
+	softerrors = append(softerrors, &common.LocatedError{Pid: p.pid, Err: err})
+
+	return "", "", p.exited(fmt.Errorf("No name of process %d can be read", p.pid)), softerrors
+}
+
+// gone tells if the process exited, is exiting, or its pid was reused.
+func (p linuxProcess) gone() bool {
+	stat, err := common.ReadStatFile(uint(p.pid))
+	if err != nil {
+		return os.IsNotExist(err) || err == syscall.ESRCH
+	}
+	return stat.State == "Z" || stat.State == "X" || stat.Flags&common.PF_EXITING != 0 ||
+		(p.startTime != 0 && stat.StartTime != p.startTime)
+}
+
+// nameWithSource doesn't fall back to the other sources when the executable link can't be read, as they would hide
+// that the privileges were dropped.
+func (p *preopenedProcess) nameWithSource() (name string, source NameSource, harderror error, softerrors []error) {
+	if _, err := os.Readlink(common.ProcFilePath(uint(p.Pid()), "exe")); os.IsPermission(err) {
+		return "", "", p.dropped("name", err), nil
+	}
+	return p.linuxProcess.nameWithSource()
+}
@@ -0,0 +1,11 @@
+// +build windows darwin
+
+package process
+
+func nameAndSource(p Process) (name string, source NameSource, harderror error, softerrors []error) {
+	name, harderror, softerrors = p.Name()
+	if harderror != nil {
+		return "", "", harderror, softerrors
+	}
+	return name, NameFromExe, nil, softerrors
+}
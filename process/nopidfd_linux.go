@@ -0,0 +1,9 @@
+// +build masche_nopidfd
+
+package process
+
+// pidfdSupported is always false when building with the masche_nopidfd tag, so processes are polled as on kernels
+// without pidfd support.
+func pidfdSupported() bool {
+	return false
+}
@@ -0,0 +1,47 @@
+package process
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/polyverse/masche/common"
+)
+
+// Bounds of the values of /proc/PID/oom_score_adj, see proc(5).
+const (
+	MinOOMScoreAdj = -1000
+	MaxOOMScoreAdj = 1000
+)
+
+// SetOOMScoreAdj sets the OOM score adjustment of the current process, which makes the OOM killer choose it more,
+// with positive values, or less, with negative ones. MinOOMScoreAdj keeps it from being chosen at all, which agents
+// scanning memory-heavy processes can use to protect themselves. Lowering it needs CAP_SYS_RESOURCE. It's only
+// available on Linux.
+func SetOOMScoreAdj(value int) error {
+	if value < MinOOMScoreAdj || value > MaxOOMScoreAdj {
+		return fmt.Errorf("Invalid OOM score adjustment %d, it must be between %d and %d", value, MinOOMScoreAdj,
+			MaxOOMScoreAdj)
+	}
+	path := common.ProcFilePath(uint(os.Getpid()), "oom_score_adj")
+	if err := ioutil.WriteFile(path, []byte(strconv.Itoa(value)), 0); err != nil {
+		return fmt.Errorf("Unable to set the OOM score adjustment to %d (%v)", value, err)
+	}
+	return nil
+}
+
+// readOOMScoreAdj reads the OOM score adjustment of pid.
+func readOOMScoreAdj(pid int) (int, error) {
+	path := common.ProcFilePath(uint(pid), "oom_score_adj")
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("Unable to read the OOM score adjustment of proc %d (%v)", pid, err)
+	}
+	value, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, fmt.Errorf("Invalid OOM score adjustment in %s (%v)", path, err)
+	}
+	return value, nil
+}
@@ -0,0 +1,36 @@
+package process
+
+import "github.com/polyverse/masche/common"
+
+// FileKind tells what a file descriptor refers to.
+type FileKind string
+
+const (
+	// FileRegular is a file with a path, which includes directories and devices.
+	FileRegular FileKind = "regular"
+	FileSocket  FileKind = "socket"
+	FilePipe    FileKind = "pipe"
+	// FileAnon is a file without a path, like an eventfd, an epoll instance or a namespace.
+	FileAnon FileKind = "anon"
+)
+
+// OpenFile is a file descriptor open in a process.
+type OpenFile struct {
+	Fd int `json:"fd"`
+	// Path is what the file descriptor refers to, as the kernel shows it: a path for regular files, which is the same
+	// as the one of the regions that map the file, or the type and inode of the others, like socket:[1234].
+	Path string   `json:"path"`
+	Kind FileKind `json:"kind"`
+	// Inode is the inode of sockets and pipes.
+	Inode uint64 `json:"inode,omitempty"`
+	// Description tells what a socket is connected to, like "tcp 127.0.0.1:8080->127.0.0.1:41234 ESTABLISHED" or
+	// "unix /run/daemon.sock", if it's a TCP, UDP or unix socket of the network namespace of the process.
+	Description string `json:"description,omitempty"`
+}
+
+// OpenFiles lists the file descriptors p has open, sorted. It's only implemented on Linux. The file descriptors closed
+// while they are listed are left out with a softerror, and so are the descriptions of the sockets if they can't be
+// read.
+func OpenFiles(p Process) (files []OpenFile, harderror error, softerrors []error) {
+	return common.Result(openFiles(p.Pid()))
+}
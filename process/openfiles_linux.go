@@ -0,0 +1,218 @@
+package process
+
+import (
+	"bufio"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/polyverse/masche/common"
+)
+
+// tcpStates are the names of the states of /proc/net/tcp.
+var tcpStates = map[int64]string{
+	0x01: "ESTABLISHED",
+	0x02: "SYN_SENT",
+	0x03: "SYN_RECV",
+	0x04: "FIN_WAIT1",
+	0x05: "FIN_WAIT2",
+	0x06: "TIME_WAIT",
+	0x07: "CLOSE",
+	0x08: "CLOSE_WAIT",
+	0x09: "LAST_ACK",
+	0x0A: "LISTEN",
+	0x0B: "CLOSING",
+}
+
+func openFiles(pid int) (files []OpenFile, harderror error, softerrors []error) {
+	files, sockets, harderror, softerrors := listFds(pid)
+	if harderror != nil || !sockets {
+		return files, harderror, softerrors
+	}
+	descriptions, serrs := socketDescriptions(pid)
+	softerrors = append(softerrors, serrs...)
+	for i := range files {
+		if files[i].Kind == FileSocket {
+			files[i].Description = descriptions[files[i].Inode]
+		}
+	}
+	return files, nil, softerrors
+}
+
+// listFds lists the file descriptors of pid, sorted and without descriptions, and tells if any of them is a socket.
+func listFds(pid int) (files []OpenFile, sockets bool, harderror error, softerrors []error) {
+	fdDir := common.ProcFilePath(uint(pid), "fd")
+	dir, err := os.Open(fdDir)
+	if err != nil {
+		return nil, false, fmt.Errorf("Unable to list the file descriptors of process %d (%v)", pid, err), nil
+	}
+	names, err := dir.Readdirnames(-1)
+	dir.Close()
+	if err != nil {
+		return nil, false, fmt.Errorf("Unable to list the file descriptors of process %d (%v)", pid, err), nil
+	}
+
+	files = make([]OpenFile, 0, len(names))
+	for _, name := range names {
+		fd, err := strconv.Atoi(name)
+		if err != nil {
+			continue
+		}
+		target, err := os.Readlink(filepath.Join(fdDir, name))
+		if err != nil {
+			softerrors = append(softerrors, &common.LocatedError{Pid: pid,
+				Err: fmt.Errorf("File descriptor %d of process %d vanished while listing them (%v)", fd, pid, err)})
+			continue
+		}
+
+		file := OpenFile{Fd: fd, Path: target, Kind: FileRegular}
+		switch {
+		case strings.HasPrefix(target, "socket:["):
+			file.Kind = FileSocket
+			fmt.Sscanf(target, "socket:[%d]", &file.Inode)
+			sockets = true
+		case strings.HasPrefix(target, "pipe:["):
+			file.Kind = FilePipe
+			fmt.Sscanf(target, "pipe:[%d]", &file.Inode)
+		case !strings.HasPrefix(target, "/"):
+			file.Kind = FileAnon
+		}
+		files = append(files, file)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Fd < files[j].Fd })
+	return files, sockets, nil, softerrors
+}
+
+func inetSockets(pid int) (sockets []InetSocket, harderror error, softerrors []error) {
+	files, _, harderror, softerrors := listFds(pid)
+	if harderror != nil {
+		return nil, harderror, softerrors
+	}
+	fds := make(map[uint64]int)
+	for _, file := range files {
+		if file.Kind == FileSocket {
+			fds[file.Inode] = file.Fd
+		}
+	}
+	if len(fds) == 0 {
+		return nil, nil, softerrors
+	}
+
+	all, serrs := readNetInetTables(pid)
+	softerrors = append(softerrors, serrs...)
+	for _, socket := range all {
+		if fd, ok := fds[socket.Inode]; ok {
+			socket.Fd = fd
+			sockets = append(sockets, socket)
+		}
+	}
+	sort.Slice(sockets, func(i, j int) bool { return sockets[i].Fd < sockets[j].Fd })
+	return sockets, nil, softerrors
+}
+
+// readNetInetTables reads the TCP and UDP sockets of the network namespace of pid, from its own net directory
+// instead of the one of /proc/net, which is the namespace of the reader. The tables that can't be read are reported as
+// softerrors, except the ones of disabled protocols, like IPv6.
+func readNetInetTables(pid int) (sockets []InetSocket, softerrors []error) {
+	for _, table := range []string{"tcp", "tcp6", "udp", "udp6"} {
+		path := common.ProcFilePath(uint(pid), filepath.Join("net", table))
+		read, err := readNetInet(path, strings.TrimSuffix(table, "6"))
+		if err != nil && !os.IsNotExist(err) {
+			softerrors = append(softerrors, &common.LocatedError{Pid: pid,
+				Err: fmt.Errorf("Unable to read the sockets of process %d from %s (%v)", pid, path, err)})
+		}
+		sockets = append(sockets, read...)
+	}
+	return sockets, softerrors
+}
+
+// socketDescriptions describes the TCP, UDP and unix sockets of the network namespace of pid, by inode. The tables
+// that can't be read are reported as softerrors, except the ones of disabled protocols, like IPv6.
+func socketDescriptions(pid int) (descriptions map[uint64]string, softerrors []error) {
+	descriptions = make(map[uint64]string)
+	sockets, softerrors := readNetInetTables(pid)
+	for _, socket := range sockets {
+		descriptions[socket.Inode] = socket.String()
+	}
+
+	path := common.ProcFilePath(uint(pid), filepath.Join("net", "unix"))
+	unixSockets, err := readNetUnixFile(path)
+	if err != nil {
+		return descriptions, append(softerrors, &common.LocatedError{Pid: pid,
+			Err: fmt.Errorf("Unable to describe the sockets of process %d with %s (%v)", pid, path, err)})
+	}
+	for inode, socket := range unixSockets {
+		descriptions[inode] = strings.TrimSpace("unix " + socket.path)
+	}
+	return descriptions, softerrors
+}
+
+// readNetInet reads the sockets of a /proc/net/{tcp,tcp6,udp,udp6} file, without their file descriptors. The sockets
+// of the lines read before an error are returned with it.
+func readNetInet(path string, protocol string) (sockets []InetSocket, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// sl local_address rem_address st tx_queue:rx_queue tr:tm->when retrnsmt uid timeout inode ...
+	scanner := bufio.NewScanner(f)
+	for first := true; scanner.Scan(); first = false {
+		if first {
+			continue
+		}
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 {
+			return sockets, fmt.Errorf("Unrecognised line: %s", scanner.Text())
+		}
+		socket := InetSocket{Fd: -1, Protocol: protocol}
+		if socket.Local, err = parseInetAddress(fields[1]); err != nil {
+			return sockets, err
+		}
+		if socket.Remote, err = parseInetAddress(fields[2]); err != nil {
+			return sockets, err
+		}
+		state, err := strconv.ParseInt(fields[3], 16, 32)
+		if err != nil {
+			return sockets, fmt.Errorf("Unrecognised state: %s", scanner.Text())
+		}
+		// UDP sockets have no states besides the ones of TCP they borrow.
+		if protocol == "tcp" {
+			socket.State = tcpStates[state]
+		}
+		if socket.Inode, err = strconv.ParseUint(fields[9], 10, 64); err != nil {
+			return sockets, fmt.Errorf("Unrecognised inode: %s", scanner.Text())
+		}
+		sockets = append(sockets, socket)
+	}
+	return sockets, scanner.Err()
+}
+
+// parseInetAddress parses an address of /proc/net/tcp, like 0100007F:1F90. The address is made of 32 bit words in
+// native byte order, and the port is big endian. IPv4 addresses mapped to IPv6 ones, the peers of dual stack sockets,
+// are returned as IPv4 addresses.
+func parseInetAddress(s string) (netip.AddrPort, error) {
+	hexIP, hexPort, ok := strings.Cut(s, ":")
+	if !ok || (len(hexIP) != 8 && len(hexIP) != 32) {
+		return netip.AddrPort{}, fmt.Errorf("Unrecognised address %s", s)
+	}
+	ip := make([]byte, len(hexIP)/2)
+	for i := 0; i < len(ip); i += 4 {
+		word, err := strconv.ParseUint(hexIP[i*2:i*2+8], 16, 32)
+		if err != nil {
+			return netip.AddrPort{}, fmt.Errorf("Unrecognised address %s", s)
+		}
+		nativeEndian.PutUint32(ip[i:], uint32(word))
+	}
+	port, err := strconv.ParseUint(hexPort, 16, 16)
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("Unrecognised address %s", s)
+	}
+	addr, _ := netip.AddrFromSlice(ip)
+	return netip.AddrPortFrom(addr.Unmap(), uint16(port)), nil
+}
@@ -0,0 +1,15 @@
+// +build windows darwin
+
+package process
+
+import (
+	"fmt"
+)
+
+func openFiles(pid int) (files []OpenFile, harderror error, softerrors []error) {
+	return nil, fmt.Errorf("OpenFiles is not implemented on this platform"), nil
+}
+
+func inetSockets(pid int) (sockets []InetSocket, harderror error, softerrors []error) {
+	return nil, fmt.Errorf("InetSockets is not implemented on this platform"), nil
+}
@@ -0,0 +1,27 @@
+// +build !masche_nopidfd
+
+package process
+
+import (
+	"os"
+	"sync"
+	"syscall"
+)
+
+var (
+	pidfdOnce      sync.Once
+	pidfdAvailable bool
+)
+
+// pidfdSupported tells if the kernel supports pidfds (Linux 5.3+). Building with the masche_nopidfd tag always uses
+// the polling fallback instead.
+func pidfdSupported() bool {
+	pidfdOnce.Do(func() {
+		fd, _, errno := syscall.Syscall(sysPidfdOpen, uintptr(os.Getpid()), 0, 0)
+		if errno == 0 {
+			syscall.Close(int(fd))
+			pidfdAvailable = true
+		}
+	})
+	return pidfdAvailable
+}
@@ -0,0 +1,102 @@
+package process
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/polyverse/masche/common"
+)
+
+// OpenOptions modifies the behaviour of OpenFromPidWithOptions.
+type OpenOptions struct {
+	// PreopenResources opens the files the memory of the process is read through when the process is opened, and
+	// keeps them open until it's closed. The privileges needed to open them, like CAP_SYS_PTRACE, aren't needed to
+	// use them, so the program can drop those privileges and go on reading the memory of the process. See Resource
+	// for the files that are kept open. It's only implemented on Linux.
+	PreopenResources bool
+
+	// Rights are the access rights the process is opened with on Windows, where protected processes, like
+	// csrss.exe, can only be opened with RightsQuery. Other systems ignore them.
+	Rights Rights
+	// DegradeRights retries opening a process that denies Rights with fewer rights, down to RightsQuery, and reports
+	// the rights it was opened with in a softerror, as the reads of its memory will fail.
+	DegradeRights bool
+}
+
+// Resource is one of the files of a process that PreopenResources keeps open.
+//
+// Only the memory regions, the memory and the pagemap (which an ImpactReport needs) are read through them. Everything
+// else opens new files, and fails once the privileges to open them were dropped: the name of the process, its
+// environment, its mappings and libraries, the placement of scans and the reads from mapped files, and opening new
+// processes.
+type Resource string
+
+const (
+	MemResource     Resource = "mem"
+	MapsResource    Resource = "maps"
+	PagemapResource Resource = "pagemap"
+)
+
+// OpenFromPidWithOptions works as OpenFromPid, with options.
+func OpenFromPidWithOptions(pid int, opts OpenOptions) (p Process, harderror error, softerrors []error) {
+	return common.Result(openFromPidWithOptions(pid, opts))
+}
+
+// Wrapper is implemented by the processes that wrap another one, like CachedProcess, so the resources preopened
+// for the wrapped process can be found.
+type Wrapper interface {
+	Unwrap() Process
+}
+
+// ErrPrivilegesDropped is the error matched by every PrivilegesError.
+var ErrPrivilegesDropped = errors.New("the privileges to open the process were dropped")
+
+// PrivilegesError reports that an operation on a process opened with PreopenResources needed to open a file that
+// couldn't be opened anymore, because the privileges the process was opened with were dropped.
+type PrivilegesError struct {
+	Pid       int    `json:"pid"`
+	Operation string `json:"operation"`
+	Err       error  `json:"-"`
+}
+
+func (e *PrivilegesError) Error() string {
+	return fmt.Sprintf("Process %d: %s: %v (%v)", e.Pid, e.Operation, ErrPrivilegesDropped, e.Err)
+}
+
+// Location makes PrivilegesErrors sort by their process in softerrors. See common.SortSoftErrors.
+func (e *PrivilegesError) Location() (pid int, address uintptr) {
+	return e.Pid, 0
+}
+
+// Unwrap makes errors.Is(err, ErrPrivilegesDropped) true for every PrivilegesError.
+func (e *PrivilegesError) Unwrap() error {
+	return ErrPrivilegesDropped
+}
+
+// PreopenedFile returns the file preopened for the resource of p, or of the process p wraps, or nil if p wasn't opened
+// with PreopenResources. The file must not be closed, and it must be read with ReadAt, as other reads would move its
+// offset under the ones of OpenResource.
+func PreopenedFile(p Process, resource Resource) *os.File {
+	return preopenedResource(p, resource)
+}
+
+// preopener is implemented by the processes opened with PreopenResources.
+type preopener interface {
+	preopened(resource Resource) *os.File
+}
+
+// preopenedResource returns the file preopened for the resource of p, or of the process p wraps, if any.
+func preopenedResource(p Process, resource Resource) *os.File {
+	for p != nil {
+		if o, ok := p.(preopener); ok {
+			return o.preopened(resource)
+		}
+		w, ok := p.(Wrapper)
+		if !ok {
+			return nil
+		}
+		p = w.Unwrap()
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package process
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	"github.com/polyverse/masche/common"
+)
+
+// preopenedProcess is a process opened with PreopenResources.
+type preopenedProcess struct {
+	linuxProcess
+	files map[Resource]*os.File
+}
+
+func openFromPidWithOptions(pid int, opts OpenOptions) (p Process, harderror error, softerrors []error) {
+	if !opts.PreopenResources {
+		return openFromPid(pid)
+	}
+
+	pp := &preopenedProcess{linuxProcess: openedProcess(pid), files: map[Resource]*os.File{}}
+	for _, resource := range []Resource{MemResource, MapsResource, PagemapResource} {
+		path := common.ProcFilePath(uint(pid), string(resource))
+		f, err := os.Open(path)
+		if err != nil {
+			pp.Close()
+			return nil, fmt.Errorf("Unable to preopen the %s of process %d (%w)", resource, pid,
+				ProcFileError(pid, path, err)), nil
+		}
+		pp.files[resource] = f
+	}
+	return pp, nil, nil
+}
+
+func (p *preopenedProcess) preopened(resource Resource) *os.File {
+	return p.files[resource]
+}
+
+func (p *preopenedProcess) Close() (harderror error, softerrors []error) {
+	for resource, f := range p.files {
+		if err := f.Close(); err != nil {
+			softerrors = append(softerrors, fmt.Errorf("Unable to close the %s of process %d (%v)", resource,
+				p.Pid(), err))
+		}
+	}
+	p.files = nil
+	return nil, softerrors
+}
+
+func (p *preopenedProcess) Name() (name string, harderror error, softerrors []error) {
+	name, _, harderror, softerrors = p.nameWithSource()
+	return name, harderror, softerrors
+}
+
+func (p *preopenedProcess) Environ() (env map[string]string, harderror error, softerrors []error) {
+	env, harderror, softerrors = p.linuxProcess.Environ()
+	return env, p.dropped("environ", harderror), softerrors
+}
+
+func (p *preopenedProcess) AccessLevel() (level AccessLevel, harderror error, softerrors []error) {
+	return accessLevel(p)
+}
+
+// dropped turns the permission errors of operations that open new files into PrivilegesErrors, as the process could
+// be opened with the privileges they need.
+func (p *preopenedProcess) dropped(operation string, err error) error {
+	var perr *PermissionError
+	if errors.As(err, &perr) || os.IsPermission(err) {
+		return &PrivilegesError{Pid: p.Pid(), Operation: operation, Err: err}
+	}
+	return err
+}
+
+// ResourceReader reads a resource of a process.
+type ResourceReader interface {
+	io.Reader
+	io.ReaderAt
+	io.Closer
+}
+
+// OpenResource returns a reader of a resource of p: the file preopened when p was opened with PreopenResources, or
+// a new one. Closing the reader doesn't close preopened files, and their contents are read from the start, as if
+// they were opened anew. New ones that can't be opened because p doesn't exist or belongs to another user fail with a
+// NotFoundError or a PermissionError, and the ones of matched processes whose pid was reused with an ExitedError, see
+// VerifyMatch.
+func OpenResource(p Process, resource Resource) (r ResourceReader, err error) {
+	if err := VerifyMatch(p); err != nil {
+		return nil, err
+	}
+	if f := preopenedResource(p, resource); f != nil {
+		return preopenedReader{io.NewSectionReader(f, 0, math.MaxInt64)}, nil
+	}
+	path := common.ProcFilePath(uint(p.Pid()), string(resource))
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, ProcFileError(p.Pid(), path, err)
+	}
+	return f, nil
+}
+
+// preopenedReader reads a preopened file, which is left open when the reader is closed.
+type preopenedReader struct {
+	*io.SectionReader
+}
+
+func (r preopenedReader) Close() error {
+	return nil
+}
@@ -0,0 +1,16 @@
+// +build windows darwin
+
+package process
+
+import (
+	"fmt"
+)
+
+func openFromPidWithOptions(pid int, opts OpenOptions) (p Process, harderror error, softerrors []error) {
+	p, harderror, softerrors = openFromPidWithRights(pid, opts.Rights, opts.DegradeRights)
+	if harderror == nil && opts.PreopenResources {
+		softerrors = append(softerrors, fmt.Errorf("Preopening the resources of processes is not implemented on "+
+			"this platform"))
+	}
+	return p, harderror, softerrors
+}
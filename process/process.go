@@ -4,11 +4,26 @@
 package process
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"os"
 	"regexp"
 	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/polyverse/masche/common"
 )
 
+// ErrNotImplemented is wrapped by the softerrors of the accessors of Process that aren't implemented on this platform.
+var ErrNotImplemented = errors.New("not implemented on this platform")
+
+// notImplemented returns the error of doing what on a platform where it isn't implemented.
+func notImplemented(what string) error {
+	return fmt.Errorf("%s is %w", what, ErrNotImplemented)
+}
+
 // Process type represents a running processes that can be used by other modules.
 // In order to get a Process on of the Open* functions must be called, and once it's not needed it must be closed.
 type Process interface {
@@ -18,6 +33,22 @@ type Process interface {
 	// Name returns the process' binary full path.
 	Name() (name string, harderror error, softerrors []error)
 
+	// Cmdline returns the arguments the process was started with. Kernel threads, which have none, return their
+	// bracketed name as their only argument, like ps(1) shows them. It's only implemented on Linux.
+	//
+	// The accessors that aren't implemented on a platform return no value, no harderror and a softerror wrapping
+	// ErrNotImplemented.
+	Cmdline() (args []string, harderror error, softerrors []error)
+
+	// Environ returns the environment variables of the process. Values keep every '=' after the first one of their
+	// variable. If the environment belongs to another user the harderror is a *PermissionError. It's only
+	// implemented on Linux.
+	Environ() (env map[string]string, harderror error, softerrors []error)
+
+	// Threads returns the ids of the threads of the process, sorted. On Linux the main thread has the pid as its id,
+	// and threads that exit while they are listed are left out with a softerror. It's not implemented on macOS.
+	Threads() (tids []int, harderror error, softerrors []error)
+
 	// Closes this Process.
 	Close() (harderror error, softerrors []error)
 
@@ -25,6 +56,59 @@ type Process interface {
 	// It works like an interface{} that you must cast, but we are using a uintptr because we need to return C values,
 	// and casting between them in different modules panics if you use interface{}.
 	Handle() uintptr
+
+	// AccessLevel cheaply probes how much of the process can be inspected by the current user. A harderror is only
+	// returned if the probe itself can't be done (e.g. the process doesn't exist anymore).
+	// On Linux, the soft errors of a process whose memory can't be read wrap ErrNotDumpable if it isn't dumpable.
+	AccessLevel() (level AccessLevel, harderror error, softerrors []error)
+
+	// WaitForExit blocks until the process exits or ctx is done, in which case ctx's error is returned as harderror.
+	// Unlike os.Process.Wait it works with processes that aren't children of the current one.
+	WaitForExit(ctx context.Context) (status ExitStatus, harderror error, softerrors []error)
+
+	// Signal sends sig to the process. It's only implemented on Linux and macOS, elsewhere the harderror wraps
+	// ErrNotImplemented.
+	Signal(sig syscall.Signal) (harderror error, softerrors []error)
+
+	// Suspend stops every thread of the process, so its memory doesn't change while it's read. On Linux and macOS it
+	// sends SIGSTOP, on Linux it also waits for the threads to stop, with a softerror for the ones that don't stop in
+	// time, like the ones waiting for a disk. On Windows the process is suspended as a debugger does. A process can't
+	// suspend itself.
+	//
+	// A suspended process stays so until Resume is called, even if the current one exits.
+	Suspend() (harderror error, softerrors []error)
+
+	// Resume continues a process stopped by Suspend. It's safe to call even if Suspend failed, or wasn't called, so
+	// it can be deferred right before Suspend. On Linux a process that was already stopped by a signal when Suspend
+	// was called, like with SIGSTOP from a shell, is left stopped. Otherwise, and on macOS, it continues processes
+	// stopped by anyone.
+	Resume() (harderror error, softerrors []error)
+
+	// IsAlive tells if the process is still running. Zombies have exited, so they aren't alive. On Linux the start time
+	// of the process is recorded when it's opened, which makes a process whose pid was reused by a new one not alive
+	// either. The harderror is returned when it can't be told, like when the process can't be checked on Windows.
+	//
+	// The accessors of a process that exited fail with an *ExitedError where it can be detected.
+	IsAlive() (alive bool, harderror error)
+
+	// Architecture returns the instruction set the process runs, which tells the size of its pointers even if it
+	// isn't the one of the current process, like a 32 bits process read from a 64 bits one. On Linux it's read from
+	// the ELF header of its executable, or guessed from its auxiliary vector with a softerror if the executable can't
+	// be read. On Windows it's the machine IsWow64Process2 reports, and on macOS only the size of the pointers is
+	// known, so 64 bits processes are assumed to run the architecture of the current one, even under Rosetta.
+	Architecture() (arch Arch, harderror error, softerrors []error)
+
+	// IsKernelThread tells if the process is a kernel thread, like kworker and ksoftirqd on Linux, which has no memory
+	// of its own to read. It's always false on other systems.
+	IsKernelThread() (kernel bool, harderror error)
+}
+
+// ExitStatus describes how a process ended.
+type ExitStatus struct {
+	// Code is the process' exit code. It's only meaningful if CodeKnown is true, as it can't be obtained for processes
+	// that aren't children of the current one on every platform.
+	Code      int
+	CodeKnown bool
 }
 
 func GetProcess(pid int) Process {
@@ -34,28 +118,68 @@ func GetProcess(pid int) Process {
 // OpenFromPid opens a process by its pid.
 func OpenFromPid(pid int) (p Process, harderror error, softerrors []error) {
 	// This function is implemented by the OS-specific openFromPid function.
-	return openFromPid(pid)
+	return common.Result(openFromPid(pid))
+}
+
+// EnumerationOptions modifies the processes listed by GetAllPidsWithOptions and OpenAllWithOptions.
+type EnumerationOptions struct {
+	// SkipKernelThreads leaves out the kernel threads, which are more than half of the processes of many Linux
+	// servers and have no memory to read. See Process.IsKernelThread.
+	SkipKernelThreads bool
 }
 
-// GetAllPids returns a slice with al the running processes' pids.
+// GetAllPids returns a slice with al the running processes' pids, sorted.
 func GetAllPids() (pids []int, harderror error, softerrors []error) {
+	return GetAllPidsWithOptions(EnumerationOptions{})
+}
+
+// GetAllPidsWithOptions works as GetAllPids, with options.
+func GetAllPidsWithOptions(opts EnumerationOptions) (pids []int, harderror error, softerrors []error) {
 	// This function is implemented by the OS-specific getAllPids function.
 	allPids, harderror, softerrors := getAllPids()
 	if harderror != nil {
 		return nil, harderror, softerrors
 	} // if
 
+	if opts.SkipKernelThreads {
+		allPids, softerrors = withoutKernelThreads(allPids, softerrors)
+	}
 	sort.Ints(allPids)
 
 	return allPids, harderror, softerrors
 
 }
 
-// OpenAll opens all the running processes returning a slice of Process.
+// withoutKernelThreads leaves out of pids the kernel threads, and the processes that exit while they are checked. The
+// ones that can't be checked are kept, with a softerror.
+func withoutKernelThreads(pids []int, softerrors []error) ([]int, []error) {
+	kept := pids[:0]
+	for _, pid := range pids {
+		kernel, err := isKernelThread(pid)
+		if os.IsNotExist(err) || errors.Is(err, syscall.ESRCH) {
+			continue
+		}
+		if err != nil {
+			softerrors = append(softerrors, &common.LocatedError{Pid: pid,
+				Err: fmt.Errorf("Unable to tell if process %d is a kernel thread (%v)", pid, err)})
+		}
+		if !kernel {
+			kept = append(kept, pid)
+		}
+	}
+	return kept, softerrors
+}
+
+// OpenAll opens all the running processes returning a slice of Process, sorted by pid.
 // A race condition may make this generate some softerrors because from the time pids are get to actually opened some
 // of them may have dead.
 func OpenAll() (ps []Process, harderror error, softerrors []error) {
-	pids, err, softs := GetAllPids()
+	return OpenAllWithOptions(EnumerationOptions{})
+}
+
+// OpenAllWithOptions works as OpenAll, with options.
+func OpenAllWithOptions(opts EnumerationOptions) (ps []Process, harderror error, softerrors []error) {
+	pids, err, softs := GetAllPidsWithOptions(opts)
 	softerrs := make([]error, 0)
 	if softs != nil {
 		softerrs = append(softerrs, softs...)
@@ -64,19 +188,70 @@ func OpenAll() (ps []Process, harderror error, softerrors []error) {
 		return nil, err, softerrs
 	}
 
-	ps = make([]Process, 0)
-	for _, pid := range pids {
+	ps, softs = openFromPids(pids)
+	softerrs = append(softerrs, softs...)
+	common.SortSoftErrors(softerrs)
+	return ps, nil, softerrs
+}
+
+// WalkProcesses opens the running processes one at a time, as they are listed, and calls walkFn with each of them
+// until it returns false. It's cheaper than OpenAll when only some processes are needed, as the ones after the walk
+// stops aren't listed nor opened. The processes are walked in the order the platform lists them, which isn't
+// necessarily by pid. walkFn owns each process it's called with, and must close it. The processes that can't be
+// opened, like the ones that exit while they are listed, are left out with a softerror.
+func WalkProcesses(walkFn func(p Process) (keepGoing bool)) (harderror error, softerrors []error) {
+	var openErrors []error
+	harderror, softerrors = walkPids(func(pid int) bool {
 		p, err, softs := OpenFromPid(pid)
+		openErrors = append(openErrors, softs...)
 		if err != nil {
-			softerrs = append(softerrs, fmt.Errorf("Pid: %d failed to Open. Error: %v", pid, err))
+			openErrors = append(openErrors, &common.LocatedError{Pid: pid,
+				Err: fmt.Errorf("Pid: %d failed to Open. Error: %w", pid, err)})
+			return true
+		}
+		return walkFn(p)
+	})
+	softerrors = append(softerrors, openErrors...)
+	common.SortSoftErrors(softerrors)
+	return harderror, softerrors
+}
+
+// sortByPid sorts ps by pid.
+func sortByPid(ps []Process) {
+	sort.Slice(ps, func(i, j int) bool { return ps[i].Pid() < ps[j].Pid() })
+}
+
+// OpenFromPids opens the processes with the given pids, in their order, and leaves out the ones that can't be opened
+// with a softerror located at their pid. Repeated pids are only tried once. The harderror is only returned if no
+// process could be opened, so it's nil if pids is empty.
+func OpenFromPids(pids []int) (ps []Process, harderror error, softerrors []error) {
+	ps, softerrors = openFromPids(pids)
+	if len(ps) == 0 && len(pids) > 0 {
+		return nil, fmt.Errorf("None of the %d processes could be opened", len(pids)), softerrors
+	}
+	common.SortSoftErrors(softerrors)
+	return ps, nil, softerrors
+}
+
+// openFromPids opens the processes with the given pids, and reports the ones that can't be opened as softerrors.
+func openFromPids(pids []int) (ps []Process, softerrors []error) {
+	ps = make([]Process, 0, len(pids))
+	seen := make(map[int]bool, len(pids))
+	for _, pid := range pids {
+		if seen[pid] {
 			continue
 		}
-		if softs != nil {
-			softerrs = append(softerrs, softs...)
+		seen[pid] = true
+		p, err, softs := OpenFromPid(pid)
+		softerrors = append(softerrors, softs...)
+		if err != nil {
+			softerrors = append(softerrors, &common.LocatedError{Pid: pid,
+				Err: fmt.Errorf("Pid: %d failed to Open. Error: %w", pid, err)})
+			continue
 		}
 		ps = append(ps, p)
 	}
-	return ps, nil, softerrs
+	return ps, softerrors
 }
 
 // CloseAll closes all the processes from the given slice.
@@ -97,30 +272,128 @@ func CloseAll(ps []Process) (harderrors []error, softerrors []error) {
 	return harderrors, softerrors
 }
 
-// OpenByName recieves a Regexp an returns a slice with all the Processes whose name matches it.
+// OpenByName recieves a Regexp an returns a slice with all the Processes whose name matches it, sorted by pid. The
+// processes whose name can't be read don't match, and are reported as softerrors located at their pid. A process can
+// exit and its pid be reused by another one after it matched, so the first read of its memory checks that it's still
+// the same process, see VerifyMatch.
 func OpenByName(r *regexp.Regexp) (ps []Process, harderror error, softerrors []error) {
-	procs, harderror, softerrors := OpenAll()
-	if harderror != nil {
-		return nil, harderror, nil
+	return OpenMatching(r, MatchOptions{Name: true})
+}
+
+// MatchOptions chooses what OpenMatching matches the regexp against. A process matches if any of the chosen values
+// does. If none is chosen the name is.
+type MatchOptions struct {
+	// Name is the name of the process, as OpenByName matches it.
+	Name bool
+	// Cmdline are the arguments of the process joined by spaces, which tell apart the scripts run by the same
+	// interpreter. Processes whose arguments can't be read don't match by them, and are reported as softerrors. On
+	// platforms where the arguments can't be read at all, only the names are matched, if they are chosen, and a single
+	// softerror wrapping ErrNotImplemented is reported.
+	Cmdline bool
+}
+
+// OpenMatching works as OpenByName, but matches the regexp against the values chosen in opts.
+func OpenMatching(r *regexp.Regexp, opts MatchOptions) (ps []Process, harderror error, softerrors []error) {
+	if !opts.Name && !opts.Cmdline {
+		opts.Name = true
 	}
 
-	matchs := make([]Process, 0)
+	ps = make([]Process, 0)
+	var matchErrors []error
+	harderror, softerrors = WalkProcesses(func(p Process) bool {
+		matched, serrs := matchProcess(p, r, &opts)
+		matchErrors = append(matchErrors, serrs...)
+		if matched {
+			ps = append(ps, &matchedProcess{Process: p})
+		} else {
+			p.Close()
+		}
+		return true
+	})
+	softerrors = append(softerrors, matchErrors...)
+	common.SortSoftErrors(softerrors)
+	if harderror != nil {
+		CloseAll(ps)
+		return nil, harderror, softerrors
+	}
+	sortByPid(ps)
+	return ps, nil, softerrors
+}
 
-	for _, p := range procs {
-		name, err, softs := p.Name()
+// ProcessesWhere opens every process whose ProcessInfo satisfies pred, sorted by pid. The processes are returned as
+// CachedProcesses with their info already cached, so calling Info on them again doesn't read it. The processes whose
+// info can't be read, like the ones that exit while they are enumerated, are left out with a softerror.
+func ProcessesWhere(pred func(ProcessInfo) bool) (ps []*CachedProcess, harderror error, softerrors []error) {
+	ps = make([]*CachedProcess, 0)
+	var infoErrors []error
+	harderror, softerrors = WalkProcesses(func(p Process) bool {
+		c, err, softs := NewCachedProcess(p, 0)
+		infoErrors = append(infoErrors, softs...)
+		if err != nil {
+			infoErrors = append(infoErrors, &common.LocatedError{Pid: p.Pid(), Err: err})
+			p.Close()
+			return true
+		}
+		info, err, softs := c.Info()
+		infoErrors = append(infoErrors, softs...)
 		if err != nil {
-			softerrors = append(softerrors, err)
+			infoErrors = append(infoErrors, &common.LocatedError{Pid: p.Pid(), Err: err})
+			c.Close()
+			return true
 		}
-		if softs != nil {
-			softerrors = append(softerrors, softs...)
+		if pred(info) {
+			ps = append(ps, c)
+		} else {
+			c.Close()
+		}
+		return true
+	})
+	softerrors = append(softerrors, infoErrors...)
+	common.SortSoftErrors(softerrors)
+	if harderror != nil {
+		for _, c := range ps {
+			c.Close()
 		}
+		return nil, harderror, softerrors
+	}
+	sort.Slice(ps, func(i, j int) bool { return ps[i].Pid() < ps[j].Pid() })
+	return ps, nil, softerrors
+}
 
-		if r.MatchString(name) {
-			matchs = append(matchs, p)
+// matchProcess tells if p matches r as chosen in opts. Cmdline is unset in opts if the arguments can't be read on this
+// platform, so they aren't tried again for the next processes.
+func matchProcess(p Process, r *regexp.Regexp, opts *MatchOptions) (matched bool, softerrors []error) {
+	if opts.Name {
+		name, err, softs := p.Name()
+		softerrors = append(softerrors, softs...)
+		if err != nil {
+			softerrors = append(softerrors, &common.LocatedError{Pid: p.Pid(),
+				Err: fmt.Errorf("Unable to match the name of process %d (%w)", p.Pid(), err)})
 		} else {
-			p.Close()
+			matched = r.MatchString(name)
+		}
+	}
+	if !matched && opts.Cmdline {
+		args, err, softs := p.Cmdline()
+		softerrors = append(softerrors, softs...)
+		if err == nil && args == nil && containsError(softs, ErrNotImplemented) {
+			opts.Cmdline = false
+		} else if err != nil {
+			softerrors = append(softerrors, &common.LocatedError{Pid: p.Pid(),
+				Err: fmt.Errorf("Unable to match the arguments of process %d (%v)", p.Pid(), err)})
+		} else {
+			matched = r.MatchString(strings.Join(args, " "))
 		}
 	}
+	return matched, softerrors
+}
 
-	return matchs, nil, softerrors
+// containsError tells if any of errs is target, or wraps it.
+func containsError(errs []error, target error) bool {
+	for _, err := range errs {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
 }
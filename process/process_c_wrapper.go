@@ -13,16 +13,34 @@ import (
 type process struct {
 	hndl C.process_handle_t
 	pid  C.pid_tt
+	// rights are the rights the handle was opened with on Windows.
+	rights Rights
 }
 
 func (p process) Pid() int {
 	return int(p.pid)
 }
 
+func (p process) Cmdline() (args []string, harderror error, softerrors []error) {
+	return nil, nil, []error{notImplemented("Reading the command line of processes")}
+}
+
+func (p process) Environ() (env map[string]string, harderror error, softerrors []error) {
+	return nil, nil, []error{notImplemented("Reading the environment of processes")}
+}
+
 func (p process) Handle() uintptr {
 	return uintptr(p.hndl)
 }
 
+func (p process) AccessLevel() (level AccessLevel, harderror error, softerrors []error) {
+	// A process handle can only be opened with enough rights to read the process' memory, unless fewer were asked.
+	if p.rights == RightsQuery {
+		return NoAccess, nil, nil
+	}
+	return FullAccess, nil, nil
+}
+
 func (p process) Close() (harderror error, softerrors []error) {
 	resp := C.close_process_handle(p.hndl)
 	defer C.response_free(resp)
@@ -36,12 +54,12 @@ func openFromPid(pid int) (p Process, harderror error, softerrors []error) {
 	harderror, softerrors = cresponse.GetResponsesErrors(unsafe.Pointer(resp))
 	C.response_free(resp)
 
-	if harderror == nil {
-		result.pid = C.pid_tt(pid)
-	} else {
+	if harderror != nil {
 		resp = C.close_process_handle(result.hndl)
 		C.response_free(resp)
+		return nil, harderror, softerrors
 	}
 
-	return result, harderror, softerrors
+	result.pid = C.pid_tt(pid)
+	return result, nil, softerrors
 }
@@ -4,27 +4,127 @@ package process
 // #include <libproc.h>
 // #include <errno.h>
 // #include <stdlib.h>
+// #include <sys/sysctl.h>
+//
+// // Tells if the process pid has 64 bits pointers, or returns -1 with errno set.
+// static int process_is_lp64(pid_t pid) {
+//     int mib[4] = {CTL_KERN, KERN_PROC, KERN_PROC_PID, pid};
+//     struct kinfo_proc info;
+//     size_t size = sizeof(info);
+//     if (sysctl(mib, 4, &info, &size, NULL, 0) == -1) {
+//         return -1;
+//     }
+//     if (size == 0) {
+//         errno = ESRCH;
+//         return -1;
+//     }
+//     return (info.kp_proc.p_flag & P_LP64) != 0;
+// }
 import "C"
 
 import (
+	"context"
 	"fmt"
-	"path/filepath"
+	"os"
 	"reflect"
+	"syscall"
+	"time"
 	"unsafe"
+
+	"github.com/polyverse/masche/common"
 )
 
-func (p process) Name() (name string, harderror error, softerrors []error) {
-	cname := C.malloc(C.PROC_PIDPATHINFO_MAXSIZE)
-	defer C.free(cname)
+const exitPollInterval = 50 * time.Millisecond
 
-	_, err := C.proc_pidpath(C.int(p.pid), cname, C.PROC_PIDPATHINFO_MAXSIZE)
-	if err != nil {
-		harderr := fmt.Errorf("Error while reading name of process %d: %v", p.pid, err)
-		return "", harderr, nil
+// WaitForExit polls for the existence of the process, as there is no way to wait for a process that isn't a child
+// of the current one. Its exit code can't be obtained either.
+func (p process) WaitForExit(ctx context.Context) (status ExitStatus, harderror error, softerrors []error) {
+	ticker := time.NewTicker(exitPollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := syscall.Kill(int(p.pid), 0); err == syscall.ESRCH {
+			return status, nil, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return status, ctx.Err(), nil
+		case <-ticker.C:
+		}
 	}
+}
+
+// IsAlive only checks that the pid exists, as Darwin has no way to tell apart processes that got the same pid.
+func (p process) IsAlive() (alive bool, harderror error) {
+	return syscall.Kill(int(p.pid), 0) != syscall.ESRCH, nil
+}
+
+// openFromPidWithRights ignores the rights, which are only used on Windows.
+func openFromPidWithRights(pid int, rights Rights, degrade bool) (p Process, harderror error, softerrors []error) {
+	return openFromPid(pid)
+}
+
+func (p process) IsKernelThread() (kernel bool, harderror error) {
+	return false, nil
+}
 
-	name, harderror = filepath.EvalSymlinks(C.GoString((*C.char)(cname)))
-	return
+func (p process) Threads() (tids []int, harderror error, softerrors []error) {
+	return nil, nil, []error{notImplemented("Listing the threads of processes")}
+}
+
+func (p process) Signal(sig syscall.Signal) (harderror error, softerrors []error) {
+	// kill(2) signals process groups, or every process, given pids that aren't positive.
+	if p.pid <= 0 {
+		return fmt.Errorf("Unable to send %v to invalid pid %d", sig, p.pid), nil
+	}
+	if err := syscall.Kill(int(p.pid), sig); err != nil {
+		return fmt.Errorf("Unable to send %v to process %d (%v)", sig, p.pid, err), nil
+	}
+	return nil, nil
+}
+
+func (p process) Suspend() (harderror error, softerrors []error) {
+	if int(p.pid) == os.Getpid() {
+		return fmt.Errorf("Process %d can't suspend itself", p.pid), nil
+	}
+	return p.Signal(syscall.SIGSTOP)
+}
+
+func (p process) Resume() (harderror error, softerrors []error) {
+	return p.Signal(syscall.SIGCONT)
+}
+
+// Architecture reads the size of the pointers of the process with sysctl(3). 64 bits processes are assumed to run the
+// architecture of the current one, as processes translated by Rosetta can only tell they are themselves.
+func (p process) Architecture() (arch Arch, harderror error, softerrors []error) {
+	lp64, err := C.process_is_lp64(p.pid)
+	if lp64 == -1 {
+		return ArchUnknown, fmt.Errorf("Unable to read the architecture of process %d (%v)", p.pid, err), nil
+	}
+	if lp64 == 1 {
+		return hostArch(), nil, nil
+	}
+	return compatArch(hostArch()), nil, nil
+}
+
+func (p process) Name() (name string, harderror error, softerrors []error) {
+	name, harderror = processExe(int(p.pid))
+	return common.Result(name, harderror, nil)
+}
+
+// walkPids walks the pids listed by getAllPids, as macOS lists them all at once.
+func walkPids(walkFn func(pid int) (keepGoing bool)) (harderror error, softerrors []error) {
+	pids, harderror, softerrors := getAllPids()
+	if harderror != nil {
+		return harderror, softerrors
+	}
+	for _, pid := range pids {
+		if !walkFn(pid) {
+			break
+		}
+	}
+	return nil, softerrors
 }
 
 func getAllPids() (pids []int, harderror error, softerrors []error) {
@@ -5,14 +5,56 @@ type ProcessInfo interface {
 	GetCommand() string
 	GetParentProcessId() int
 	GetExecutable() string
+	// GetArch returns the architecture of the process, which is unknown if it can't be read, like the one of kernel
+	// threads.
+	GetArch() TargetArch
+	// GetRaw returns every key and value the platform provided for the process, verbatim. It's only filled when
+	// requested with InfoOptions.IncludeRaw.
+	GetRaw() map[string]string
+	// IsSandboxed tells if the process is confined by seccomp filters, which limit the system calls it can make. It's
+	// always false on other systems than Linux.
+	IsSandboxed() bool
+	// GetDumpable tells if the process is dumpable, which decides if the processes of its user can read its memory.
+	GetDumpable() Dumpability
 }
 
+// The seccomp modes of Linux processes. Processes without seccomp are in mode 0.
+const (
+	// SeccompStrict only allows the read, write, _exit and sigreturn system calls.
+	SeccompStrict = 1
+	// SeccompFilter allows the system calls the BPF filters installed by the process allow.
+	SeccompFilter = 2
+)
+
+// InfoOptions modifies the ProcessInfo returned by GetProcessInfoWithOptions.
+type InfoOptions struct {
+	// IncludeRaw makes the info also include the raw key/value pairs it was parsed from (on Linux, the whole
+	// /proc/<pid>/status file), including the ones that aren't modeled by ProcessInfo.
+	IncludeRaw bool
+	// HashExecutable makes the info also include the SHA-256 of the executable of the process, see HashExecutable.
+	// It's only filled on Linux. An executable that can't be hashed leaves it empty, with a softerror.
+	HashExecutable bool
+}
+
+// GetProcessInfo returns the ProcessInfo of the process with the given pid. On error it returns nil.
+//
+// The executable of some processes can't be resolved, like the one of kernel threads or of processes whose binary was
+// deleted. That's not an error: GetExecutable returns an empty string. Use CachedProcess.Info to get the reason as a
+// softerror.
 func GetProcessInfo(pid int) (*ProcessInfo, error) {
+	return GetProcessInfoWithOptions(pid, InfoOptions{})
+}
+
+// GetProcessInfoWithOptions works as GetProcessInfo, with options.
+func GetProcessInfoWithOptions(pid int, opts InfoOptions) (*ProcessInfo, error) {
 	var info ProcessInfo
-	info, err := processInfo(pid)
-	return &info, err
+	info, err, _ := processInfo(pid, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &info, nil
 }
 
 func ProcessExe(pid int) (string, error) {
 	return processExe(pid)
-}
\ No newline at end of file
+}
@@ -0,0 +1,101 @@
+package process
+
+// #cgo CFLAGS: -std=c99
+// #include <libproc.h>
+// #include <stdlib.h>
+// #include <sys/proc_info.h>
+import "C"
+
+import (
+	"fmt"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"unsafe"
+)
+
+type darwinProcessInfo struct {
+	Id              int        `json:"id"`
+	Command         string     `json:"command"`
+	UserId          int        `json:"userId"`
+	UserName        string     `json:"userName"`
+	ParentProcessId int        `json:"parentProcessId"`
+	Executable      string     `json:"executable"`
+	Arch            TargetArch `json:"arch"`
+}
+
+func (dpi darwinProcessInfo) GetId() int {
+	return dpi.Id
+}
+
+func (dpi darwinProcessInfo) GetCommand() string {
+	return dpi.Command
+}
+
+func (dpi darwinProcessInfo) GetParentProcessId() int {
+	return dpi.ParentProcessId
+}
+
+func (dpi darwinProcessInfo) GetExecutable() string {
+	return dpi.Executable
+}
+
+func (dpi darwinProcessInfo) GetArch() TargetArch {
+	return dpi.Arch
+}
+
+func (dpi darwinProcessInfo) GetRaw() map[string]string {
+	// There is no raw status to expose on darwin.
+	return nil
+}
+
+func (dpi darwinProcessInfo) IsSandboxed() bool {
+	return false
+}
+
+func (dpi darwinProcessInfo) GetDumpable() Dumpability {
+	return DumpabilityUnknown
+}
+
+func processInfo(pid int, opts InfoOptions) (info darwinProcessInfo, harderror error, softerrors []error) {
+	var bsdInfo C.struct_proc_bsdinfo
+	size := C.int(unsafe.Sizeof(bsdInfo))
+	n, err := C.proc_pidinfo(C.int(pid), C.PROC_PIDTBSDINFO, 0, unsafe.Pointer(&bsdInfo), size)
+	if n != size {
+		return info, fmt.Errorf("Unable to get the info of process %d (%v)", pid, err), nil
+	}
+
+	info.Id = int(bsdInfo.pbi_pid)
+	info.ParentProcessId = int(bsdInfo.pbi_ppid)
+	info.UserId = int(bsdInfo.pbi_uid)
+	info.Command = C.GoString(&bsdInfo.pbi_comm[0])
+
+	// The executable and the user name are missing for some processes, the rest of the info is still good.
+	info.Executable, err = processExe(pid)
+	if err != nil {
+		softerrors = append(softerrors, err)
+	}
+	if u, err := user.LookupId(strconv.Itoa(info.UserId)); err != nil {
+		softerrors = append(softerrors, fmt.Errorf("Unable to find the name of user %d (%v)", info.UserId, err))
+	} else {
+		info.UserName = u.Username
+	}
+	arch, err, softs := pidTarget(pid)
+	softerrors = append(softerrors, softs...)
+	if err != nil {
+		softerrors = append(softerrors, err)
+	}
+	info.Arch = arch
+	return info, nil, softerrors
+}
+
+func processExe(pid int) (string, error) {
+	cname := C.malloc(C.PROC_PIDPATHINFO_MAXSIZE)
+	defer C.free(cname)
+
+	_, err := C.proc_pidpath(C.int(pid), cname, C.PROC_PIDPATHINFO_MAXSIZE)
+	if err != nil {
+		return "", fmt.Errorf("Error while reading name of process %d: %v", pid, err)
+	}
+	return filepath.EvalSymlinks(C.GoString((*C.char)(cname)))
+}
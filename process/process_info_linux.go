@@ -7,22 +7,73 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"os/user"
 	"path/filepath"
 	"reflect"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
+
+	"github.com/polyverse/masche/common"
 )
 
+// linuxProcessInfo is the info of a process read from its status file. UserId and GroupId are its real ids, and
+// UserName and GroupName their names, which are empty when the ids have no entry in the user and group databases, as
+// often happens in containers. The Vm sizes are in bytes, and zero for processes without memory of their own, like
+// kernel threads and zombies. State and StartTime are read from the stat file: State is its state character, like R
+// for running, S for sleeping, D for waiting on disk, Z for zombie or T for stopped.
+//
+// Cgroups are the lines of the cgroup file, and ContainerId the id of the docker, containerd or cri-o container the
+// process runs in, found in their paths. It's empty for processes in no container, or in one of a runtime that isn't
+// recognized, which PidNamespace and MountNamespace still tell apart: they are the inodes of the namespaces of the
+// process, the same for every process in them. They are empty when they can't be read.
+//
+// ExecutableSHA256 is only filled if InfoOptions.HashExecutable is set.
 type linuxProcessInfo struct {
-	Id              int    `json:"id" statusFileKey:"Pid"`
-	Command         string `json:"command" statusFileKey:"Name"`
-	UserId          int    `json:"userId" statusFileKey:"Uid"`
-	UserName        string `json:"userName" statusFileKey:""`
-	GroupId         int    `json:"groupId" statusFileKey:"Gid"`
-	GroupName       string `json:"groupName" statusFileKey:""`
-	ParentProcessId int    `json:"parentProcessId" statusFileKey:"PPid"`
-	Executable      string `json:"executable"`
+	Id               int        `json:"id" statusFileKey:"Pid"`
+	Command          string     `json:"command" statusFileKey:"Name"`
+	UserId           int        `json:"userId" statusFileKey:"Uid"`
+	EffectiveUserId  int        `json:"effectiveUserId" statusFileKey:"Uid,1"`
+	UserName         string     `json:"userName"`
+	GroupId          int        `json:"groupId" statusFileKey:"Gid"`
+	EffectiveGroupId int        `json:"effectiveGroupId" statusFileKey:"Gid,1"`
+	GroupName        string     `json:"groupName"`
+	ParentProcessId  int        `json:"parentProcessId" statusFileKey:"PPid"`
+	VmSize           uint64     `json:"vmSizeBytes" statusFileKey:"VmSize"`
+	VmRSS            uint64     `json:"vmRSSBytes" statusFileKey:"VmRSS"`
+	VmSwap           uint64     `json:"vmSwapBytes" statusFileKey:"VmSwap"`
+	VmData           uint64     `json:"vmDataBytes" statusFileKey:"VmData"`
+	Executable       string     `json:"executable"`
+	ExecutableSHA256 string     `json:"executableSHA256,omitempty"`
+	Arch             TargetArch `json:"arch"`
+	State            string     `json:"state"`
+	StartTime        time.Time  `json:"startTime"`
+	Nice             int        `json:"nice"`
+	Priority         int        `json:"priority"`
+	OOMScoreAdj      int        `json:"oomScoreAdj"`
+	Cgroups          []string   `json:"cgroups,omitempty"`
+	ContainerId      string     `json:"containerId,omitempty"`
+	PidNamespace     uint64     `json:"pidNamespace,omitempty"`
+	MountNamespace   uint64     `json:"mountNamespace,omitempty"`
+	// The inheritable, permitted, effective, bounding and ambient capability sets, which tell if the process can
+	// ptrace others or load kernel modules. They are nil on kernels without them.
+	CapInh CapabilitySet `json:"capInh" statusFileKey:"CapInh"`
+	CapPrm CapabilitySet `json:"capPrm" statusFileKey:"CapPrm"`
+	CapEff CapabilitySet `json:"capEff" statusFileKey:"CapEff"`
+	CapBnd CapabilitySet `json:"capBnd" statusFileKey:"CapBnd"`
+	CapAmb CapabilitySet `json:"capAmb" statusFileKey:"CapAmb"`
+	// Seccomp is the seccomp mode of the process, SeccompStrict or SeccompFilter when it's sandboxed, and
+	// SeccompFilters the amount of filters installed. NoNewPrivs is 1 if the process can't gain privileges by
+	// executing setuid binaries. They are zero on kernels that don't report them.
+	Seccomp        int `json:"seccomp" statusFileKey:"Seccomp"`
+	SeccompFilters int `json:"seccompFilters" statusFileKey:"Seccomp_filters"`
+	NoNewPrivs     int `json:"noNewPrivs" statusFileKey:"NoNewPrivs"`
+	// Dumpable is read from the status file on the kernels that have it, and inferred from the owner of the files
+	// in the proc directory of the process on the rest.
+	Dumpable Dumpability `json:"dumpable" statusFileKey:"Dumpable"`
+	// Raw has every key and value of the status file, it's only filled if InfoOptions.IncludeRaw is set.
+	Raw map[string]string `json:"raw,omitempty"`
 }
 
 func (lpi linuxProcessInfo) GetId() int {
@@ -41,42 +92,136 @@ func (lpi linuxProcessInfo) GetExecutable() string {
 	return lpi.Executable
 }
 
+func (lpi linuxProcessInfo) GetArch() TargetArch {
+	return lpi.Arch
+}
+
+func (lpi linuxProcessInfo) GetRaw() map[string]string {
+	return lpi.Raw
+}
+
+func (lpi linuxProcessInfo) IsSandboxed() bool {
+	return lpi.Seccomp == SeccompFilter
+}
+
+func (lpi linuxProcessInfo) GetDumpable() Dumpability {
+	return lpi.Dumpable
+}
+
 var (
-	tmpLpi         = linuxProcessInfo{}
-	keyToFieldName = map[string]string{}
-	mtx            = &sync.RWMutex{}
+	keyToFields = map[statusFieldKey][]statusField{}
+	mtx         = &sync.RWMutex{}
 )
 
-func processInfo(pid int) (linuxProcessInfo, error) {
-	statusPath := filepath.Join("/proc", fmt.Sprintf("%d", pid), "status")
+// statusFieldKey identifies the fields of a struct type that receive a given key of the status file.
+type statusFieldKey struct {
+	t   reflect.Type
+	key string
+}
+
+// statusField is a field that receives the token at index token of the value of a key of the status file.
+type statusField struct {
+	name  string
+	token int
+}
+
+func processInfo(pid int, opts InfoOptions) (info linuxProcessInfo, harderror error, softerrors []error) {
+	statusPath := common.ProcFilePath(uint(pid), "status")
 	statusFile, err := os.Open(statusPath)
 	if err != nil {
-		return linuxProcessInfo{}, fmt.Errorf("Unable to open proc %d's status file at %s (%v)", pid, statusPath, err)
+		return info, fmt.Errorf("Unable to open proc %d's status file at %s (%w)", pid, statusPath,
+			ProcFileError(pid, statusPath, err)), nil
 	}
 	defer statusFile.Close()
 
 	data, err := ioutil.ReadAll(statusFile)
 	if err != nil {
-		return linuxProcessInfo{}, fmt.Errorf("Unable to read data from proc %d's status file at %s (%v)", pid, statusPath, err)
+		return info, fmt.Errorf("Unable to read data from proc %d's status file at %s (%v)", pid, statusPath, err),
+			nil
 	}
 
 	lpi := linuxProcessInfo{}
 	err = parseStatusToStruct(data, &lpi)
 	if err != nil {
-		return linuxProcessInfo{}, fmt.Errorf("Unable to process data from %s into linuxProcessInfo struct (%v)", statusPath, err)
+		return info, fmt.Errorf("Unable to process data from %s into linuxProcessInfo struct (%v)", statusPath, err),
+			nil
+	}
+
+	if opts.IncludeRaw {
+		lpi.Raw = map[string]string{}
+		err = parseStatusToMap(data, lpi.Raw)
+		if err != nil {
+			return info, fmt.Errorf("Unable to process data from %s into a map (%v)", statusPath, err), nil
+		}
 	}
 
-	//we ignore this error
+	if lpi.Dumpable == DumpabilityUnknown {
+		lpi.Dumpable = inferDumpability(pid, lpi.EffectiveUserId)
+	}
+
+	if stat, err := common.ReadStatFile(uint(pid)); err != nil {
+		softerrors = append(softerrors, fmt.Errorf("Unable to read proc %d's stat file (%v)", pid, err))
+	} else {
+		lpi.State = stat.State
+		lpi.Nice, lpi.Priority = int(stat.Nice), int(stat.Priority)
+		if boot, err := common.BootTime(); err != nil {
+			softerrors = append(softerrors, fmt.Errorf("Unable to find the start time of proc %d (%v)", pid, err))
+		} else {
+			lpi.StartTime = stat.StartedAt(boot)
+		}
+	}
+	if lpi.OOMScoreAdj, err = readOOMScoreAdj(pid); err != nil {
+		softerrors = append(softerrors, err)
+	}
+
+	// Kernel threads and processes whose binary was deleted have no executable, the rest of the info is still good.
 	lpi.Executable, err = ProcessExe(pid)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "[Warning] Error when expanding symlink to executable: %v\n", err)
+		softerrors = append(softerrors, err)
+	}
+	// Kernel threads have no executable nor architecture.
+	if arch, err, softs := processArchitecture(pid); err == nil {
+		lpi.Arch = TargetOf(arch)
+		softerrors = append(softerrors, softs...)
+	} else if lpi.Executable != "" {
+		softerrors = append(softerrors, err)
+	}
+	if opts.HashExecutable {
+		if lpi.ExecutableSHA256, err = hashExecutable(pid); err != nil {
+			softerrors = append(softerrors, err)
+		}
+	}
+
+	if lpi.Cgroups, err = readCgroups(pid); err != nil {
+		softerrors = append(softerrors, err)
+	}
+	lpi.ContainerId = containerId(lpi.Cgroups)
+	if lpi.PidNamespace, err = namespaceInode(pid, "pid"); err != nil {
+		softerrors = append(softerrors, err)
+	}
+	if lpi.MountNamespace, err = namespaceInode(pid, "mnt"); err != nil {
+		softerrors = append(softerrors, err)
+	}
+
+	// Ids without a name are common in containers, they don't make the info wrong.
+	if u, err := user.LookupId(strconv.Itoa(lpi.UserId)); err != nil {
+		softerrors = append(softerrors, fmt.Errorf("Unable to find the name of user %d of proc %d (%v)", lpi.UserId,
+			pid, err))
+	} else {
+		lpi.UserName = u.Username
+	}
+	if g, err := user.LookupGroupId(strconv.Itoa(lpi.GroupId)); err != nil {
+		softerrors = append(softerrors, fmt.Errorf("Unable to find the name of group %d of proc %d (%v)",
+			lpi.GroupId, pid, err))
+	} else {
+		lpi.GroupName = g.Name
 	}
 
-	return lpi, err
+	return lpi, nil, softerrors
 }
 
 func processExe(pid int) (string, error) {
-	exePath := filepath.Join("/proc", fmt.Sprintf("%d", pid), "exe")
+	exePath := common.ProcFilePath(uint(pid), "exe")
 	name, err := filepath.EvalSymlinks(exePath)
 	if err != nil {
 		return "", fmt.Errorf("Unable to expand process executable symlink %s (%v)", exePath, err)
@@ -84,44 +229,96 @@ func processExe(pid int) (string, error) {
 	return name, nil
 }
 
-func parseStatusToStruct(data []byte, lpi *linuxProcessInfo) error {
-	if lpi == nil {
-		return fmt.Errorf("Cannot parse Process Status into a nil linuxProcessInfo")
+// ParseProcStatus parses the contents of a /proc/<pid>/status file into target, which can be:
+//   - a pointer to a struct: each field tagged with a statusFileKey receives the first token of the value of that key,
+//     or the token at the index following a comma, like `statusFileKey:"Uid,1"` for the effective uid. Tokens that
+//     aren't in the value leave the field untouched. uint64 fields receive the whole value as a size in bytes instead,
+//     like 1359872 for "1328 kB". Only string, int, uint64, CapabilitySet and Dumpability fields are supported.
+//   - a map[string]string, or a pointer to one: it receives every key with its whole value, verbatim.
+func ParseProcStatus(data []byte, target interface{}) error {
+	switch t := target.(type) {
+	case map[string]string:
+		if t == nil {
+			return fmt.Errorf("Cannot parse Process Status into a nil map")
+		}
+		return parseStatusToMap(data, t)
+	case *map[string]string:
+		if t == nil {
+			return fmt.Errorf("Cannot parse Process Status into a nil map pointer")
+		}
+		if *t == nil {
+			*t = map[string]string{}
+		}
+		return parseStatusToMap(data, *t)
 	}
 
+	return parseStatusToStruct(data, target)
+}
+
+// forEachStatusLine calls fn with the key and the whole, trimmed value of each line of the status file data.
+func forEachStatusLine(data []byte, fn func(key string, value string) error) error {
 	r := bufio.NewReader(bytes.NewReader(data))
-	for line, err := r.ReadString('\n'); err != io.EOF; line, err = r.ReadString('\n') {
-		if err != nil {
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil && err != io.EOF {
 			return fmt.Errorf("Error when parsing Status line from Proc Status data (%v)", err)
 		}
 
-		statusComponents := strings.Split(line, ":")
-		if len(statusComponents) != 2 {
-			continue
+		statusComponents := strings.SplitN(line, ":", 2)
+		if len(statusComponents) == 2 {
+			key := strings.TrimSpace(statusComponents[0])
+			value := strings.TrimSpace(statusComponents[1])
+			if fnErr := fn(key, value); fnErr != nil {
+				return fnErr
+			}
 		}
 
-		key := strings.TrimSpace(statusComponents[0])
-		value := strings.TrimSpace(statusComponents[1])
-
-		vals := strings.Fields(value)
-		if len(vals) > 0 {
-			value = vals[0]
+		if err == io.EOF {
+			return nil
 		}
+	}
+}
 
-		fieldName := getFieldNameForKey(key)
-		vfield := reflect.ValueOf(lpi).Elem().FieldByName(fieldName)
-		if !vfield.IsValid() {
-			continue //Nobody wants this value
-		}
+func parseStatusToMap(data []byte, raw map[string]string) error {
+	return forEachStatusLine(data, func(key string, value string) error {
+		raw[key] = value
+		return nil
+	})
+}
 
-		val, err := stringToReflectValue(value, vfield.Type())
-		if err != nil {
-			return err
+func parseStatusToStruct(data []byte, target interface{}) error {
+	v := reflect.ValueOf(target)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("Cannot parse Process Status into %T, a non nil pointer to a struct is needed", target)
+	}
+	v = v.Elem()
+
+	return forEachStatusLine(data, func(key string, value string) error {
+		fields := getFieldsForKey(v.Type(), key)
+		if len(fields) == 0 {
+			return nil //Nobody wants this value
 		}
+		tokens := strings.Fields(value)
+		for _, field := range fields {
+			vfield := v.FieldByName(field.name)
+			token := value
+			switch {
+			case vfield.Kind() == reflect.Uint64:
+				// Sizes have their unit in a second token, they are parsed whole.
+			case field.token < len(tokens):
+				token = tokens[field.token]
+			case field.token > 0 || len(tokens) > 0:
+				continue
+			}
 
-		vfield.Set(val)
-	}
-	return nil
+			val, err := stringToReflectValue(token, vfield.Type())
+			if err != nil {
+				return err
+			}
+			vfield.Set(val)
+		}
+		return nil
+	})
 }
 
 func stringToReflectValue(value string, t reflect.Type) (reflect.Value, error) {
@@ -134,35 +331,64 @@ func stringToReflectValue(value string, t reflect.Type) (reflect.Value, error) {
 			return reflect.Value{}, fmt.Errorf("Error converting string %s into an integer. (%v)", value, err)
 		}
 		return reflect.ValueOf(intVal), nil
+	case "uint64":
+		size, err := parseStatusSize(value)
+		if err == nil && size < 0 {
+			err = fmt.Errorf("negative size")
+		}
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("Error converting string %s into a size. (%v)", value, err)
+		}
+		return reflect.ValueOf(uint64(size)), nil
+	case "Dumpability":
+		dumpable, err := parseDumpability(value)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		return reflect.ValueOf(dumpable), nil
+	case "CapabilitySet":
+		set, err := ParseCapabilitySet(value)
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("Error converting string %s into a capability set. (%v)", value, err)
+		}
+		return reflect.ValueOf(set), nil
 	}
 	return reflect.Value{}, fmt.Errorf("Unsupported Converstion: string %s to value of type %v", value, t)
 }
 
-func getFieldNameForKey(key string) string {
+func getFieldsForKey(t reflect.Type, key string) []statusField {
+	cacheKey := statusFieldKey{t, key}
 	mtx.RLock()
-	fieldName, ok := keyToFieldName[key]
+	fields, ok := keyToFields[cacheKey]
 	mtx.RUnlock()
 	if ok {
-		return fieldName
+		return fields
 	}
 
-	t := reflect.TypeOf(tmpLpi)
-	fieldForKey, found := t.FieldByNameFunc(func(name string) bool {
-		fieldCandidate, found := t.FieldByName(name)
-		if found && fieldCandidate.Tag.Get("statusFileKey") == key {
-			return true
+	for i := 0; i < t.NumField(); i++ {
+		tag, ok := t.Field(i).Tag.Lookup("statusFileKey")
+		if !ok {
+			continue
 		}
-		return false
-	})
-
-	if !found {
-		return ""
+		name, index, hasIndex := strings.Cut(tag, ",")
+		if name != key {
+			continue
+		}
+		field := statusField{name: t.Field(i).Name}
+		if hasIndex {
+			token, err := strconv.Atoi(index)
+			if err != nil || token < 0 {
+				continue
+			}
+			field.token = token
+		}
+		fields = append(fields, field)
 	}
 
 	mtx.Lock()
 	defer mtx.Unlock()
-	keyToFieldName[key] = fieldForKey.Name
-	return keyToFieldName[key]
+	keyToFields[cacheKey] = fields
+	return fields
 }
 
 func appendError(errs []error, err error, format string, params ...interface{}) []error {
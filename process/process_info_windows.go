@@ -8,13 +8,14 @@ import (
 )
 
 type windowsProcessInfo struct {
-	Id              int     `json:"id" statusFileKey:"Pid"`
-	Handle          int     `json:"handle"`
-	Command         string  `json:"command" statusFileKey:"Name"`
-	UserName        string  `json:"userName" statusFileKey:""`
-	ParentProcessId int     `json:"parentProcessId" statusFileKey:"PPid"`
-	Executable      string  `json:"executable"`
-	SessionId       int     `json:"sessionId" statusFileKey:"Sid"`
+	Id              int        `json:"id" statusFileKey:"Pid"`
+	Handle          int        `json:"handle"`
+	Command         string     `json:"command" statusFileKey:"Name"`
+	UserName        string     `json:"userName" statusFileKey:""`
+	ParentProcessId int        `json:"parentProcessId" statusFileKey:"PPid"`
+	Executable      string     `json:"executable"`
+	SessionId       int        `json:"sessionId" statusFileKey:"Sid"`
+	Arch            TargetArch `json:"arch"`
 }
 
 func (wpi windowsProcessInfo) GetId() int {
@@ -33,20 +34,43 @@ func (wpi windowsProcessInfo) GetExecutable() string {
 	return wpi.Executable
 }
 
-func processInfo(pid int) (windowsProcessInfo, error) {
+func (wpi windowsProcessInfo) GetArch() TargetArch {
+	return wpi.Arch
+}
+
+func (wpi windowsProcessInfo) GetRaw() map[string]string {
+	// There is no raw status to expose on windows.
+	return nil
+}
+
+func (wpi windowsProcessInfo) IsSandboxed() bool {
+	return false
+}
+
+func (wpi windowsProcessInfo) GetDumpable() Dumpability {
+	return DumpabilityUnknown
+}
+
+func processInfo(pid int, opts InfoOptions) (info windowsProcessInfo, harderror error, softerrors []error) {
 	lpi := windowsProcessInfo{}
 	lpi.Id = pid
 	var err error
 	lpi.Command, lpi.Executable, lpi.ParentProcessId, lpi.Handle, lpi.SessionId, err =
 		commandExecutablePPIdPPIdHandleAndSessionId(pid)
 	if err != nil {
-		return lpi, err
+		return info, err, nil
 	}
 	lpi.UserName, err = userName(pid)
 	if err != nil {
-		return lpi, err
+		return info, err, nil
 	}
-	return lpi, nil
+	// The architecture is missing for the processes that can't be opened, the rest of the info is still good.
+	arch, err, softerrors := pidTarget(pid)
+	if err != nil {
+		softerrors = append(softerrors, err)
+	}
+	lpi.Arch = arch
+	return lpi, nil, softerrors
 }
 
 func processExe(pid int) (string, error) {
@@ -55,7 +79,7 @@ func processExe(pid int) (string, error) {
 }
 
 func commandExecutablePPIdPPIdHandleAndSessionId(pid int) (string, string, int, int, int, error) {
-	wmicCommand := exec.Command("wmic", "path", "win32_process", "where", "processid=" +
+	wmicCommand := exec.Command("wmic", "path", "win32_process", "where", "processid="+
 		strconv.FormatUint(uint64(pid), 10), "get", "commandline,", "executablepath,",
 		"handle,", "parentprocessid,", "sessionid")
 	wmicOutput, err := wmicCommand.Output()
@@ -104,10 +128,10 @@ func commandExecutablePPIdPPIdHandleAndSessionId(pid int) (string, string, int,
 
 func findHeading(headingLine []byte, targetHeading []byte, searchStart int) (start int, end int) {
 	for charIndex := searchStart; charIndex < len(headingLine); charIndex++ {
-		if len(headingLine) < charIndex + len(targetHeading) {
+		if len(headingLine) < charIndex+len(targetHeading) {
 			return -1, -1
 		}
-		if bytes.Equal(headingLine[charIndex:charIndex + len(targetHeading)], targetHeading) {
+		if bytes.Equal(headingLine[charIndex:charIndex+len(targetHeading)], targetHeading) {
 			return charIndex, charIndex + len(targetHeading)
 		}
 	}
@@ -115,7 +139,7 @@ func findHeading(headingLine []byte, targetHeading []byte, searchStart int) (sta
 }
 
 func userName(pid int) (string, error) {
-	tasklistCommand := exec.Command("tasklist", "/v", "/fi", "PID eq " + string(pid))
+	tasklistCommand := exec.Command("tasklist", "/v", "/fi", "PID eq "+string(pid))
 	tasklistOutput, err := tasklistCommand.Output()
 	if err != nil {
 		return "", err
@@ -128,8 +152,8 @@ func userName(pid int) (string, error) {
 	for index, line := range lines {
 		if len(line) >= 10 && bytes.Equal(line[:10], []byte("Image Name")) {
 			headingLine = line
-			processLine = lines[index + 2]
-			separatorLineTokens = bytes.Split(lines[index + 1], []byte(" "))
+			processLine = lines[index+2]
+			separatorLineTokens = bytes.Split(lines[index+1], []byte(" "))
 			break
 		}
 	}
@@ -147,7 +171,7 @@ func userName(pid int) (string, error) {
 		}
 	}
 
-	userNameBytes := bytes.TrimSpace(processLine[position:position + len(separatorToken)])
+	userNameBytes := bytes.TrimSpace(processLine[position : position+len(separatorToken)])
 	userNameTokens := bytes.Split(userNameBytes, []byte("\\"))
-	return string(userNameTokens[len(userNameTokens) - 1]), nil
-}
\ No newline at end of file
+	return string(userNameTokens[len(userNameTokens)-1]), nil
+}
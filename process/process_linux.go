@@ -2,60 +2,164 @@ package process
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"github.com/polyverse/masche/common"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"syscall"
 )
 
-type linuxProcess int
+// linuxProcess is a process identified by its pid and, if it was opened, by its start time, which tells it apart
+// from a later process with the same pid. A zero startTime wasn't recorded.
+type linuxProcess struct {
+	pid       int
+	startTime uint64
+}
 
 func getProcess(pid int) linuxProcess {
-	return linuxProcess(pid)
+	return linuxProcess{pid: pid}
+}
+
+// openedProcess returns the process with the given pid, recording its start time if it can be read.
+func openedProcess(pid int) linuxProcess {
+	p := linuxProcess{pid: pid}
+	if stat, err := common.ReadStatFile(uint(pid)); err == nil {
+		p.startTime = stat.StartTime
+	}
+	return p
 }
 
 func (p linuxProcess) Pid() int {
-	return int(p)
+	return p.pid
 }
 
+func (p linuxProcess) IsAlive() (alive bool, harderror error) {
+	if p.startTime == 0 {
+		_, alive, harderror = startTimeIfAlive(p.pid)
+		return alive, harderror
+	}
+	return stillRunning(p.pid, p.startTime)
+}
+
+// exited returns an *ExitedError instead of err if the process is gone, or its pid was reused. Zombies aren't gone,
+// as most of their files can still be read.
+func (p linuxProcess) exited(err error) error {
+	stat, statErr := common.ReadStatFile(uint(p.pid))
+	if os.IsNotExist(statErr) || statErr == syscall.ESRCH ||
+		(statErr == nil && p.startTime != 0 && stat.StartTime != p.startTime) {
+		return &ExitedError{Pid: p.pid}
+	}
+	return err
+}
+
+// Name mimics ps(1), which shows the arguments or the name in the status file of the processes whose executable can't
+// be read, as it may not be present anymore, or the process didn't start from a file. See NameAndSource.
 func (p linuxProcess) Name() (name string, harderror error, softerrors []error) {
-	name, err := ProcessExe(p.Pid())
+	name, _, harderror, softerrors = p.nameWithSource()
+	return name, harderror, softerrors
+}
 
+// statusName returns the name of the process in its status file, in square brackets to be consistent with ps(1).
+func statusName(pid int) (name string, err error) {
+	statusFile, err := os.Open(common.ProcFilePath(uint(pid), "status"))
 	if err != nil {
-		// If the exe link doesn't take us to the real path of the binary of the process maybe it's not present anymore
-		// or the process didn't started from a file. We mimic this ps(1) trick and take the name form
-		// /proc/<pid>/status in that case.
+		return "", err
+	}
+	defer statusFile.Close()
 
-		statusPath := filepath.Join("/proc", fmt.Sprintf("%d", p.Pid()), "status")
-		statusFile, err := os.Open(statusPath)
+	r := bufio.NewReader(statusFile)
+	for line, _, err := r.ReadLine(); err != io.EOF; line, _, err = r.ReadLine() {
 		if err != nil {
-			return name, err, nil
+			return "", err
 		}
-		defer statusFile.Close()
 
-		r := bufio.NewReader(statusFile)
-		for line, _, err := r.ReadLine(); err != io.EOF; line, _, err = r.ReadLine() {
-			if err != nil {
-				return name, err, nil
-			}
+		namePrefix := "Name:"
+		if strings.HasPrefix(string(line), namePrefix) {
+			name := strings.Trim(string(line[len(namePrefix):]), " \t")
+			return "[" + name + "]", nil
+		}
+	}
 
-			namePrefix := "Name:"
-			if strings.HasPrefix(string(line), namePrefix) {
-				name := strings.Trim(string(line[len(namePrefix):]), " \t")
+	return "", fmt.Errorf("No name found for pid %v", pid)
+}
 
-				// We add the square brackets to be consistent with ps(1) output.
-				return "[" + name + "]", nil, nil
-			}
+func (p linuxProcess) Cmdline() (args []string, harderror error, softerrors []error) {
+	data, err := ioutil.ReadFile(common.ProcFilePath(uint(p.Pid()), "cmdline"))
+	if err != nil {
+		return nil, p.exited(err), nil
+	}
+	if len(data) == 0 {
+		// Kernel threads have no arguments.
+		name, err := statusName(p.Pid())
+		if err != nil {
+			return nil, p.exited(err), nil
 		}
+		return []string{name}, nil, nil
+	}
+	// Each argument ends with a NUL, the last one included.
+	return strings.Split(strings.TrimSuffix(string(data), "\x00"), "\x00"), nil, nil
+}
 
-		return name, fmt.Errorf("No name found for pid %v", p.Pid()), nil
+func (p linuxProcess) Environ() (env map[string]string, harderror error, softerrors []error) {
+	path := common.ProcFilePath(uint(p.Pid()), "environ")
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsPermission(err) {
+			return nil, &PermissionError{Pid: p.Pid(), Path: path, Err: err}, nil
+		}
+		return nil, p.exited(err), nil
 	}
+	return parseEnviron(data), nil, nil
+}
 
-	return name, err, nil
+// parseEnviron parses the contents of an environ file, a NUL terminated NAME=value string for each variable.
+func parseEnviron(data []byte) map[string]string {
+	env := map[string]string{}
+	for _, variable := range strings.Split(string(data), "\x00") {
+		if variable == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(variable, "=")
+		env[name] = value
+	}
+	return env
+}
+
+func (p linuxProcess) Threads() (tids []int, harderror error, softerrors []error) {
+	taskPath := common.ProcFilePath(uint(p.Pid()), "task")
+	task, err := os.Open(taskPath)
+	if err != nil {
+		return nil, p.exited(fmt.Errorf("Unable to open the threads of proc %d at %s (%v)", p.Pid(), taskPath, err)),
+			nil
+	}
+	defer task.Close()
+	names, err := task.Readdirnames(-1)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to list the threads of proc %d at %s (%v)", p.Pid(), taskPath, err), nil
+	}
+
+	tids = make([]int, 0, len(names))
+	for _, name := range names {
+		tid, err := strconv.Atoi(name)
+		if err != nil {
+			continue
+		}
+		// The thread may have exited since it was listed.
+		if _, err := os.Lstat(filepath.Join(taskPath, name)); err != nil {
+			softerrors = append(softerrors, &common.LocatedError{Pid: p.Pid(),
+				Err: fmt.Errorf("Thread %d of proc %d vanished while listing its threads (%v)", tid, p.Pid(), err)})
+			continue
+		}
+		tids = append(tids, tid)
+	}
+	sort.Ints(tids)
+	return tids, nil, softerrors
 }
 
 func (p linuxProcess) Close() (harderror error, softerrors []error) {
@@ -63,26 +167,129 @@ func (p linuxProcess) Close() (harderror error, softerrors []error) {
 }
 
 func (p linuxProcess) Handle() uintptr {
-	return uintptr(p)
+	return uintptr(p.pid)
 }
 
-func getAllPids() (pids []int, harderror error, softerrors []error) {
-	files, err := ioutil.ReadDir("/proc/")
+func (p linuxProcess) AccessLevel() (level AccessLevel, harderror error, softerrors []error) {
+	return accessLevel(p)
+}
+
+// accessLevel probes the access level of p through its resources, which are the preopened ones if it has them.
+func accessLevel(p Process) (level AccessLevel, harderror error, softerrors []error) {
+	memPath := common.MemFilePathFromPid(uint(p.Pid()))
+	if _, err := os.Stat(memPath); err != nil {
+		return NoAccess, fmt.Errorf("Unable to probe access level of process %d (%v)", p.Pid(), err), nil
+	}
+
+	address, found, err := firstReadableAddress(p)
 	if err != nil {
-		return nil, err, nil
+		return NoAccess, nil, []error{err}
 	}
 
-	pids = make([]int, 0)
+	mem, err := OpenResource(p, MemResource)
+	if err != nil {
+		return MetadataOnly, nil, withDumpability(p, []error{err})
+	}
+	defer mem.Close()
 
-	for _, f := range files {
-		pid, err := strconv.Atoi(f.Name())
-		if err != nil {
+	// Processes without readable regions (i.e. kernel threads) have nothing we could fail to read.
+	if !found {
+		return FullAccess, nil, nil
+	}
+
+	// Depending on the kernel version opening the mem file can succeed even if reading from it is not permitted, so
+	// we also read a byte we know is mapped.
+	buf := make([]byte, 1)
+	offset, err := common.MemFileOffset(address, len(buf))
+	if err != nil {
+		return MetadataOnly, nil, []error{err}
+	}
+	if _, err := mem.ReadAt(buf, offset); err != nil {
+		return MetadataOnly, nil, withDumpability(p, []error{fmt.Errorf("Unable to read memory of process %d at %x (%v)",
+			p.Pid(), address, err)})
+	}
+
+	return FullAccess, nil, nil
+}
+
+// firstReadableAddress returns the start address of the first readable region listed in the process' maps file.
+func firstReadableAddress(p Process) (address uintptr, found bool, err error) {
+	mapsFile, err := OpenResource(p, MapsResource)
+	if err != nil {
+		return 0, false, err
+	}
+	defer mapsFile.Close()
+
+	scanner := bufio.NewScanner(mapsFile)
+	for scanner.Scan() {
+		entry, err := common.ParseMapsFileEntry(scanner.Text())
+		if err != nil || entry.Permissions[0] != 'r' {
+			continue
+		}
+
+		// These are special pages mapped by the kernel that can't be read through the mem file.
+		if entry.Path == "[vsyscall]" || strings.HasPrefix(entry.Path, "[vvar") {
 			continue
 		}
-		pids = append(pids, int(pid))
+		return entry.Start, true, nil
 	}
 
-	return pids, nil, nil
+	return 0, false, scanner.Err()
+}
+
+func getAllPids() (pids []int, harderror error, softerrors []error) {
+	pids = make([]int, 0)
+	harderror, softerrors = walkPids(func(pid int) bool {
+		pids = append(pids, pid)
+		return true
+	})
+	if harderror != nil {
+		return nil, harderror, softerrors
+	}
+	return pids, nil, softerrors
+}
+
+// walkPidsBatch is how many entries of the proc directory walkPids reads at once.
+const walkPidsBatch = 256
+
+// walkPids walks the pids of the proc directory as it's read, a batch of entries at a time.
+func walkPids(walkFn func(pid int) (keepGoing bool)) (harderror error, softerrors []error) {
+	dir, err := os.Open(common.ProcRoot)
+	if err != nil {
+		return err, nil
+	}
+	defer dir.Close()
+
+	for {
+		names, err := dir.Readdirnames(walkPidsBatch)
+		for _, name := range names {
+			pid, err := strconv.Atoi(name)
+			if err != nil {
+				continue
+			}
+			if !walkFn(pid) {
+				return nil, nil
+			}
+		}
+		if err == io.EOF {
+			return nil, nil
+		} else if err != nil {
+			return err, nil
+		}
+	}
+}
+
+// ProcFileError returns a NotFoundError if err is the failure to open a file of pid in the proc filesystem because
+// the process doesn't exist, or a PermissionError if it's because it belongs to another user. Otherwise err is
+// returned. It lets errors.Is tell those failures apart from the rest. It's only available on Linux.
+func ProcFileError(pid int, path string, err error) error {
+	switch {
+	case os.IsNotExist(err) || errors.Is(err, syscall.ESRCH):
+		return &NotFoundError{Pid: pid}
+	case os.IsPermission(err):
+		return &PermissionError{Pid: pid, Path: path, Err: err}
+	}
+	return err
 }
 
 func openFromPid(pid int) (p Process, harderror error, softerrors []error) {
@@ -90,10 +297,9 @@ func openFromPid(pid int) (p Process, harderror error, softerrors []error) {
 	memPath := common.MemFilePathFromPid(uint(pid))
 	memFile, err := os.Open(memPath)
 	if err != nil {
-		harderror = fmt.Errorf("Permission denied to access memory of process %v", pid)
-		return
+		return nil, ProcFileError(pid, memPath, err), nil
 	}
 	defer memFile.Close()
 
-	return linuxProcess(pid), nil, nil
+	return openedProcess(pid), nil, nil
 }
@@ -0,0 +1,2495 @@
+package process
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/polyverse/masche/common"
+	"github.com/polyverse/masche/test"
+)
+
+const accessLevelHelperEnv = "MASCHE_ACCESS_LEVEL_OF_PID"
+
+// TestAccessLevelHelper is not a real test: it's run by TestAccessLevelOfPrivilegedProcess in a subprocess with
+// dropped privileges, and prints the access level it gets for the given pid.
+func TestAccessLevelHelper(t *testing.T) {
+	pidStr := os.Getenv(accessLevelHelperEnv)
+	if pidStr == "" {
+		t.Skip("only run as a helper of TestAccessLevelOfPrivilegedProcess")
+	}
+
+	pid, err := strconv.Atoi(pidStr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	level, err, _ := GetProcess(pid).AccessLevel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Printf("access level: %v\n", level)
+}
+
+func TestAccessLevelOfPrivilegedProcess(t *testing.T) {
+	if os.Geteuid() != 0 {
+		// pid 1 is owned by root and we can't read its memory.
+		level, err, softerrors := GetProcess(1).AccessLevel()
+		test.PrintSoftErrors(softerrors)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if level == FullAccess {
+			t.Error("Unprivileged user got full access to pid 1")
+		}
+		return
+	}
+
+	// We are root: launch a root owned process and probe it from a helper that drops its privileges.
+	cmd, err := test.LaunchTestCase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	// The test binary usually lives in a directory only root can traverse, so run a copy of it.
+	helperPath, err := copyExecutable(os.Args[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(filepath.Dir(helperPath))
+
+	helper := exec.Command(helperPath, "-test.run=^TestAccessLevelHelper$", "-test.v")
+	helper.Env = append(os.Environ(), fmt.Sprintf("%s=%d", accessLevelHelperEnv, cmd.Process.Pid))
+	helper.SysProcAttr = &syscall.SysProcAttr{Credential: &syscall.Credential{Uid: 65534, Gid: 65534}}
+	out, err := helper.CombinedOutput()
+	if err != nil {
+		t.Skipf("Unable to run the unprivileged helper, skipping: %v\n%s", err, out)
+	}
+
+	if strings.Contains(string(out), "access level: "+FullAccess.String()) {
+		t.Errorf("Unprivileged helper got full access to a root owned process:\n%s", out)
+	}
+	if !strings.Contains(string(out), "access level: ") {
+		t.Errorf("Unexpected helper output:\n%s", out)
+	}
+}
+
+// copyExecutable copies the file at path into a new temporary directory anybody can read and execute.
+func copyExecutable(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	dir, err := ioutil.TempDir("", "masche")
+	if err != nil {
+		return "", err
+	}
+	if err := os.Chmod(dir, 0755); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+
+	copyPath := filepath.Join(dir, filepath.Base(path))
+	if err := ioutil.WriteFile(copyPath, data, 0755); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+
+	return copyPath, nil
+}
+
+func TestProcessesMappingFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "masche")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write([]byte("mapped by the test case")); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	var mapping []int
+	for i := 0; i < 2; i++ {
+		cmd, err := test.LaunchTestCaseAndWaitForInitialization("--map", f.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer cmd.Process.Kill()
+		mapping = append(mapping, cmd.Process.Pid)
+	}
+
+	notMapping, err := test.LaunchTestCaseAndWaitForInitialization()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer notMapping.Process.Kill()
+
+	checkMappings := func(mappings []FileMapping) {
+		found := make(map[int]bool)
+		for _, m := range mappings {
+			found[m.Pid] = true
+			if m.Process.Pid() != m.Pid {
+				t.Errorf("Mapping of pid %d has a process with pid %d", m.Pid, m.Process.Pid())
+			}
+			if m.Executable || len(m.Ranges) == 0 {
+				t.Errorf("Unexpected mapping %+v", m)
+			}
+		}
+
+		for _, pid := range mapping {
+			if !found[pid] {
+				t.Errorf("Process %d maps the file and wasn't found", pid)
+			}
+		}
+		if found[notMapping.Process.Pid] {
+			t.Errorf("Process %d doesn't map the file and was found", notMapping.Process.Pid)
+		}
+	}
+
+	mappings, err, softerrors := ProcessesMappingFile(f.Name())
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkMappings(mappings)
+
+	// Once deleted the file can only be found by its inode.
+	var st syscall.Stat_t
+	if err := syscall.Stat(f.Name(), &st); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(f.Name())
+
+	mappings, err, softerrors = ProcessesMappingInode(uint64(st.Dev), uint64(st.Ino))
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkMappings(mappings)
+}
+
+func TestParseProcStatusFixture(t *testing.T) {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "status"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lpi := linuxProcessInfo{}
+	if err := ParseProcStatus(data, &lpi); err != nil {
+		t.Fatal(err)
+	}
+	if lpi.Id != 4242 || lpi.Command != "sleep" || lpi.ParentProcessId != 1 || lpi.UserId != 1000 ||
+		lpi.EffectiveUserId != 1001 || lpi.GroupId != 100 || lpi.EffectiveGroupId != 101 ||
+		lpi.VmSize != 2640*1024 || lpi.VmRSS != 1328*1024 || lpi.VmData != 360*1024 || lpi.VmSwap != 0 ||
+		len(lpi.CapEff.Names()) != 0 || len(lpi.CapBnd.Names()) != 40 || !lpi.CapBnd.Has(CapSysModule) {
+		t.Errorf("Unexpected typed view of the status: %+v", lpi)
+	}
+
+	var raw map[string]string
+	if err := ParseProcStatus(data, &raw); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"Name":                     "sleep",
+		"Pid":                      "4242",
+		"Uid":                      "1000\t1001\t1002\t1003",
+		"State":                    "S (sleeping)",
+		"VmRSS":                    "1328 kB",
+		"voluntary_ctxt_switches":  "17",
+		"Speculation_Store_Bypass": "thread vulnerable",
+	}
+	for key, value := range expected {
+		if raw[key] != value {
+			t.Errorf("Expected raw value %q for %s and got %q", value, key, raw[key])
+		}
+	}
+
+	if err := ParseProcStatus(data, lpi); err == nil {
+		t.Error("Parsing into a non pointer struct should fail")
+	}
+}
+
+func TestProcessInfoIncludeRaw(t *testing.T) {
+	info, err := GetProcessInfoWithOptions(os.Getpid(), InfoOptions{IncludeRaw: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw := (*info).GetRaw()
+	if raw["Pid"] != strconv.Itoa(os.Getpid()) {
+		t.Errorf("Expected raw Pid %d and got %q", os.Getpid(), raw["Pid"])
+	}
+	if _, ok := raw["voluntary_ctxt_switches"]; !ok {
+		t.Error("Unmodeled keys are missing from the raw status")
+	}
+
+	info, err = GetProcessInfo(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (*info).GetRaw() != nil {
+		t.Error("The raw status was included without being requested")
+	}
+}
+
+func TestProcessInfoNames(t *testing.T) {
+	current, err := user.Current()
+	if err != nil {
+		t.Skipf("The current user has no name (%v)", err)
+	}
+	group, err := user.LookupGroupId(current.Gid)
+	if err != nil {
+		t.Skipf("The current group has no name (%v)", err)
+	}
+
+	info, err := GetProcessInfo(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lpi := (*info).(linuxProcessInfo)
+	if strconv.Itoa(lpi.UserId) != current.Uid || lpi.UserName != current.Username {
+		t.Errorf("Expected user %s (%s) and got %d (%s)", current.Uid, current.Username, lpi.UserId, lpi.UserName)
+	}
+	if lpi.EffectiveUserId != os.Geteuid() || lpi.EffectiveGroupId != os.Getegid() {
+		t.Errorf("Expected effective ids %d and %d and got %d and %d", os.Geteuid(), os.Getegid(),
+			lpi.EffectiveUserId, lpi.EffectiveGroupId)
+	}
+	if lpi.GroupName != group.Name {
+		t.Errorf("Expected group %s and got %s", group.Name, lpi.GroupName)
+	}
+}
+
+func TestProcessInfoMemory(t *testing.T) {
+	info, err := GetProcessInfo(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lpi := (*info).(linuxProcessInfo)
+	if lpi.VmSize == 0 || lpi.VmRSS == 0 || lpi.VmData == 0 {
+		t.Fatalf("Expected the sizes of the test process and got %+v", lpi)
+	}
+	if lpi.VmRSS > lpi.VmSize || lpi.VmData > lpi.VmSize {
+		t.Errorf("Resident size %d and data size %d larger than the virtual size %d", lpi.VmRSS, lpi.VmData,
+			lpi.VmSize)
+	}
+
+	// statm has the same sizes in pages. They move a bit between both reads, as the runtime allocates memory.
+	data, err := ioutil.ReadFile(common.ProcFilePath(uint(os.Getpid()), "statm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := strings.Fields(string(data))
+	pages, err := strconv.ParseUint(fields[0], 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := pages * uint64(os.Getpagesize())
+	if lpi.VmSize > size*2 || size > lpi.VmSize*2 {
+		t.Errorf("Virtual size %d isn't close to the %d of statm", lpi.VmSize, size)
+	}
+}
+
+func TestProcessInfoStartTime(t *testing.T) {
+	cmd, err := test.LaunchTestCaseAndWaitForInitialization()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	info, err, softerrors := processInfo(cmd.Process.Pid, InfoOptions{})
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.State != "S" && info.State != "R" {
+		t.Errorf("Expected the test case to be sleeping or running and got state %q", info.State)
+	}
+	if age := time.Since(info.StartTime); age < -time.Second || age > 5*time.Second {
+		t.Errorf("The test case started at %v, %v ago", info.StartTime, age)
+	}
+
+	data, err := json.Marshal(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"state":"`+info.State+`","startTime":"`) {
+		t.Errorf("Unexpected JSON %s", data)
+	}
+}
+
+// Ids without an entry in the user and group databases leave the names empty, with a softerror each.
+func TestProcessInfoUnknownIds(t *testing.T) {
+	defer func(root string) { common.ProcRoot = root }(common.ProcRoot)
+	common.ProcRoot = t.TempDir()
+
+	const pid, id = 4444, 2147483000
+	writeFakeProc(t, common.ProcRoot, pid, "ghost", 100)
+	status := fmt.Sprintf("Name:\tghost\nPid:\t%d\nPPid:\t1\nUid:\t%d\t0\t0\t0\nGid:\t%d\t0\t0\t0\n", pid, id, id)
+	if err := ioutil.WriteFile(filepath.Join(common.ProcRoot, strconv.Itoa(pid), "status"), []byte(status),
+		0644); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err, softerrors := processInfo(pid, InfoOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.UserId != id || info.UserName != "" || info.GroupId != id || info.GroupName != "" ||
+		info.ContainerId != fakeContainerId || info.PidNamespace != 4026531836 || len(softerrors) != 2 {
+
+		t.Errorf("Unexpected info %+v and softerrors %v", info, softerrors)
+	}
+	// The fake process started 100 ticks after a boot 1000 seconds ago.
+	if age := time.Since(info.StartTime); info.State != "S" || age < 998*time.Second || age > 1001*time.Second {
+		t.Errorf("Unexpected state %q and start time %v ago", info.State, age)
+	}
+}
+
+func TestProcessInfoNamespaces(t *testing.T) {
+	info, err, softerrors := processInfo(os.Getpid(), InfoOptions{})
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(info.Cgroups) == 0 {
+		t.Error("The test has no cgroups")
+	}
+	for kind, inode := range map[string]uint64{"pid": info.PidNamespace, "mnt": info.MountNamespace} {
+		var st syscall.Stat_t
+		if err := syscall.Stat("/proc/self/ns/"+kind, &st); err != nil {
+			t.Fatal(err)
+		}
+		if inode != st.Ino {
+			t.Errorf("Expected the %s namespace %d and got %d", kind, st.Ino, inode)
+		}
+	}
+}
+
+func TestProcessInfoScheduling(t *testing.T) {
+	defer func(root string) { common.ProcRoot = root }(common.ProcRoot)
+	common.ProcRoot = t.TempDir()
+	writeFakeProc(t, common.ProcRoot, 4242, "sched", 100)
+
+	info, err, _ := processInfo(4242, InfoOptions{})
+	if err != nil || info.Nice != 0 || info.Priority != 20 || info.OOMScoreAdj != -500 {
+		t.Errorf("Unexpected scheduling info %d, %d, %d (%v)", info.Nice, info.Priority, info.OOMScoreAdj, err)
+	}
+}
+
+func TestProcessInfoCapabilities(t *testing.T) {
+	info, err, _ := processInfo(os.Getpid(), InfoOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.CapEff == nil || info.CapBnd == nil || !info.CapBnd.Has(CapSysAdmin) {
+		t.Fatalf("Unexpected capability sets %+v", info)
+	}
+
+	capsh, err := exec.LookPath("capsh")
+	if err != nil {
+		t.Skip("capsh is needed to check the names of the capabilities")
+	}
+	out, err := exec.Command(capsh, "--print").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		if bounding := strings.TrimPrefix(line, "Bounding set ="); bounding != line {
+			if expected := strings.ToUpper(bounding); info.CapBnd.String() != expected {
+				t.Errorf("Expected the bounding set %s, got %s", expected, info.CapBnd)
+			}
+		}
+	}
+
+	// capsh names the effective set relative to the others, so it's decoded instead.
+	out, err = exec.Command(capsh, fmt.Sprintf("--decode=%x", info.CapEff[0])).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, decoded, _ := strings.Cut(strings.TrimSpace(string(out)), "=")
+	if expected := strings.ToUpper(decoded); info.CapEff.String() != expected {
+		t.Errorf("Expected the effective set %s, got %s", expected, info.CapEff)
+	}
+}
+
+func TestProcessInfoSeccomp(t *testing.T) {
+	info, err, _ := processInfo(os.Getpid(), InfoOptions{IncludeRaw: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Tests usually run without seccomp, but container runtimes install filters by default.
+	if strconv.Itoa(info.Seccomp) != info.Raw["Seccomp"] || info.IsSandboxed() != (info.Raw["Seccomp"] == "2") {
+		t.Errorf("Unexpected seccomp mode %d for %q", info.Seccomp, info.Raw["Seccomp"])
+	}
+	if strconv.Itoa(info.NoNewPrivs) != info.Raw["NoNewPrivs"] {
+		t.Errorf("Unexpected NoNewPrivs %d for %q", info.NoNewPrivs, info.Raw["NoNewPrivs"])
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "status"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sandboxed := strings.NewReplacer("NoNewPrivs:\t0", "NoNewPrivs:\t1", "Seccomp:\t0", "Seccomp:\t2",
+		"Seccomp_filters:\t0", "Seccomp_filters:\t3").Replace(string(data))
+	lpi := linuxProcessInfo{}
+	if err := ParseProcStatus([]byte(sandboxed), &lpi); err != nil {
+		t.Fatal(err)
+	}
+	if lpi.Seccomp != SeccompFilter || lpi.SeccompFilters != 3 || lpi.NoNewPrivs != 1 || !lpi.IsSandboxed() {
+		t.Errorf("Unexpected seccomp status %+v", lpi)
+	}
+
+	// Old kernels have none of the keys.
+	old := regexp.MustCompile(`(?m)^(NoNewPrivs|Seccomp|Seccomp_filters):.*\n`).ReplaceAllString(sandboxed, "")
+	lpi = linuxProcessInfo{}
+	if err := ParseProcStatus([]byte(old), &lpi); err != nil {
+		t.Fatal(err)
+	}
+	if lpi.Id != 4242 || lpi.Seccomp != 0 || lpi.SeccompFilters != 0 || lpi.NoNewPrivs != 0 || lpi.IsSandboxed() {
+		t.Errorf("Unexpected seccomp status without the keys %+v", lpi)
+	}
+}
+
+func TestParseDumpable(t *testing.T) {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "status"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for value, expected := range map[string]Dumpability{"0": NotDumpable, "1": Dumpable, "2": NotDumpable} {
+		lpi := linuxProcessInfo{}
+		if err := ParseProcStatus(append(data, "Dumpable:\t"+value+"\n"...), &lpi); err != nil {
+			t.Fatal(err)
+		}
+		if lpi.Dumpable != expected || lpi.GetDumpable() != expected {
+			t.Errorf("Dumpable %s was parsed as %v, expected %v", value, lpi.Dumpable, expected)
+		}
+	}
+	if err := ParseProcStatus(append(data, "Dumpable:\tmaybe\n"...), &linuxProcessInfo{}); err == nil {
+		t.Error("Parsed an invalid Dumpable")
+	}
+}
+
+func TestCapabilitySet(t *testing.T) {
+	set, err := ParseCapabilitySet("3" + "0000000000080001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !set.Has(0) || !set.Has(CapSysPtrace) || set.Has(CapSysAdmin) || !set.Has(65) || set.Has(128) {
+		t.Errorf("Unexpected capabilities in %v", set)
+	}
+	expected := []string{"CAP_CHOWN", "CAP_SYS_PTRACE", "CAP_UNKNOWN_64", "CAP_UNKNOWN_65"}
+	if !reflect.DeepEqual(set.Names(), expected) {
+		t.Errorf("Expected %v, got %v", expected, set.Names())
+	}
+	if data, err := json.Marshal(CapabilitySet{0}); err != nil || string(data) != "[]" {
+		t.Errorf("Unexpected JSON of an empty set %s (%v)", data, err)
+	}
+	if _, err := ParseCapabilitySet("xyz"); err == nil {
+		t.Error("Parsed an invalid capability set")
+	}
+}
+
+func TestSetOOMScoreAdj(t *testing.T) {
+	original, err := readOOMScoreAdj(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Lowering it back needs privileges, the test process is left more likely to be killed otherwise.
+	defer SetOOMScoreAdj(original)
+
+	for _, invalid := range []int{MinOOMScoreAdj - 1, MaxOOMScoreAdj + 1} {
+		if err := SetOOMScoreAdj(invalid); err == nil {
+			t.Errorf("Set the invalid OOM score adjustment %d", invalid)
+		}
+	}
+	if current, _ := readOOMScoreAdj(os.Getpid()); current != original {
+		t.Errorf("An invalid value changed the OOM score adjustment from %d to %d", original, current)
+	}
+
+	value := original + 1
+	if value > MaxOOMScoreAdj {
+		value = MaxOOMScoreAdj
+	}
+	if err := SetOOMScoreAdj(value); err != nil {
+		t.Fatal(err)
+	}
+	info, err, _ := processInfo(os.Getpid(), InfoOptions{})
+	if err != nil || info.OOMScoreAdj != value {
+		t.Errorf("Expected the OOM score adjustment %d, got %d (%v)", value, info.OOMScoreAdj, err)
+	}
+}
+
+func TestContainerId(t *testing.T) {
+	const id = fakeContainerId
+	cases := []struct {
+		cgroups  []string
+		expected string
+	}{
+		{[]string{"12:memory:/docker/" + id}, id},
+		{[]string{"0::/system.slice/docker-" + id + ".scope"}, id},
+		{[]string{"1:name=systemd:/", "0::/kubepods.slice/kubepods-pod1.slice/cri-containerd-" + id + ".scope"}, id},
+		{[]string{"0::/kubepods/burstable/pod1/crio-" + id + ".scope"}, id},
+		{[]string{"0::/user.slice/user-1000.slice/session-2.scope", "4:memory:/process_api/worker"}, ""},
+	}
+	for _, c := range cases {
+		if found := containerId(c.cgroups); found != c.expected {
+			t.Errorf("Expected container %q in %v and found %q", c.expected, c.cgroups, found)
+		}
+	}
+}
+
+func TestOpenMatchingCmdline(t *testing.T) {
+	marker := fmt.Sprintf("masche-match-%d", os.Getpid())
+	cmd, err := test.LaunchTestCaseAndWaitForInitialization(marker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	r := regexp.MustCompile(" " + regexp.QuoteMeta(marker) + "$")
+	ps, err, _ := OpenByName(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	CloseAll(ps)
+	if len(ps) != 0 {
+		t.Errorf("The name of %d processes matched the arguments", len(ps))
+	}
+
+	ps, err, _ = OpenMatching(r, MatchOptions{Name: true, Cmdline: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseAll(ps)
+	if len(ps) != 1 || ps[0].Pid() != cmd.Process.Pid {
+		t.Errorf("Expected only process %d to match its arguments, got %d processes", cmd.Process.Pid, len(ps))
+	}
+}
+
+func TestProcessesWhere(t *testing.T) {
+	cmd, err := test.LaunchTestCase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	calls := 0
+	ps, err, softerrors := ProcessesWhere(func(info ProcessInfo) bool {
+		calls++
+		return info.(linuxProcessInfo).UserId == os.Getuid()
+	})
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		for _, p := range ps {
+			p.Close()
+		}
+	}()
+
+	found := map[int]bool{}
+	for _, p := range ps {
+		found[p.Pid()] = true
+		// The info is cached.
+		if info, err, _ := p.Info(); err != nil || info.GetId() != p.Pid() || !p.hasInfo {
+			t.Errorf("Unexpected info of process %d: %v (%v)", p.Pid(), info, err)
+		}
+	}
+	if !found[os.Getpid()] || !found[cmd.Process.Pid] {
+		t.Errorf("Expected the test and the test case among the processes of uid %d, got %d processes", os.Getuid(),
+			len(ps))
+	}
+	if calls < len(ps) {
+		t.Errorf("The predicate was called %d times for %d processes", calls, len(ps))
+	}
+}
+
+func TestThreads(t *testing.T) {
+	cmd, err := test.LaunchTestCaseAndWaitForInitialization("--threads", "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	pid := cmd.Process.Pid
+	proc, err, softerrors := OpenFromPid(pid)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proc.Close()
+
+	tids, err, softerrors := proc.Threads()
+	if err != nil || len(softerrors) != 0 {
+		t.Fatal(err, softerrors)
+	}
+	if len(tids) != 4 || tids[0] != pid || !sort.IntsAreSorted(tids) {
+		t.Errorf("Expected the main thread %d and 3 more, sorted, and got %v", pid, tids)
+	}
+
+	cmd.Process.Kill()
+	cmd.Wait()
+	if _, err, _ := proc.Threads(); err == nil {
+		t.Error("Listing the threads of an exited process should fail")
+	}
+}
+
+func TestSkipKernelThreads(t *testing.T) {
+	if _, err := os.Stat("/proc/2"); err != nil {
+		t.Skip("kthreadd isn't visible in this pid namespace")
+	}
+	// Kernel threads can't be opened, they have no memory.
+	if kernel, err := getProcess(2).IsKernelThread(); err != nil || !kernel {
+		t.Errorf("kthreadd isn't a kernel thread (%v)", err)
+	}
+	self, err, _ := OpenFromPid(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer self.Close()
+	if kernel, err := self.IsKernelThread(); err != nil || kernel {
+		t.Errorf("The current process is a kernel thread (%v)", err)
+	}
+
+	contains := func(pids []int, pid int) bool {
+		i := sort.SearchInts(pids, pid)
+		return i < len(pids) && pids[i] == pid
+	}
+	all, err, softerrors := GetAllPids()
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !contains(all, 2) || !contains(all, os.Getpid()) {
+		t.Error("GetAllPids left out kthreadd or the current process")
+	}
+	user, err, softerrors := GetAllPidsWithOptions(EnumerationOptions{SkipKernelThreads: true})
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contains(user, 2) || !contains(user, os.Getpid()) || len(user) >= len(all) {
+		t.Errorf("Skipping kernel threads listed %d of %d processes", len(user), len(all))
+	}
+
+	ps, err, _ := OpenAllWithOptions(EnumerationOptions{SkipKernelThreads: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range ps {
+		if p.Pid() == 2 {
+			t.Error("OpenAllWithOptions opened kthreadd")
+		}
+		p.Close()
+	}
+}
+
+func TestWaitForExitPolling(t *testing.T) {
+	// The fallback used on kernels without pidfd support.
+	cmd, err := test.LaunchTestCase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	startTime, alive, err := startTimeIfAlive(cmd.Process.Pid)
+	if err != nil || !alive {
+		t.Fatal("The test case isn't running", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := waitForExitPolling(ctx, cmd.Process.Pid, startTime); err != context.DeadlineExceeded {
+		t.Fatal("Expected the wait to time out and got", err)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- waitForExitPolling(context.Background(), cmd.Process.Pid, startTime)
+	}()
+
+	cmd.Process.Kill()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("waitForExitPolling didn't return after the process was killed")
+	}
+}
+
+// writeFakeProc writes the stat and status files of a fake process in the given proc root, and a boot time 1000
+// seconds ago.
+func writeFakeProc(t *testing.T, root string, pid int, name string, startTime int) {
+	system := fmt.Sprintf("cpu  1 2 3 4\nbtime %d\nprocesses 42\n", time.Now().Unix()-1000)
+	if err := ioutil.WriteFile(filepath.Join(root, "stat"), []byte(system), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := filepath.Join(root, strconv.Itoa(pid))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	stat := fmt.Sprintf("%d (%s) S 1 %d %d 0 -1 4194304 10 0 0 0 1 2 0 0 20 0 1 0 %d 8192 100\n", pid, name, pid,
+		pid, startTime)
+	status := fmt.Sprintf("Name:\t%s\nState:\tS (sleeping)\nPid:\t%d\nPPid:\t1\n", name, pid)
+	if err := ioutil.WriteFile(filepath.Join(dir, "stat"), []byte(stat), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "status"), []byte(status), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(os.Args[0], filepath.Join(dir, "exe")); err != nil && !os.IsExist(err) {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "oom_score_adj"), []byte("-500\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "cgroup"), []byte("0::/docker/"+fakeContainerId+"\n"),
+		0644); err != nil {
+
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "ns"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, kind := range []string{"pid", "mnt"} {
+		err := os.Symlink(kind+":[4026531836]", filepath.Join(dir, "ns", kind))
+		if err != nil && !os.IsExist(err) {
+			t.Fatal(err)
+		}
+	}
+}
+
+// fakeContainerId is the container writeFakeProc puts its processes in.
+const fakeContainerId = "3f4e8a0c5b2d1e6f7a8b9c0d1e2f3a4b5c6d7e8f9a0b1c2d3e4f5a6b7c8d9e0f"
+
+func TestCachedProcessPidReuse(t *testing.T) {
+	defer func(root string) { common.ProcRoot = root }(common.ProcRoot)
+	common.ProcRoot = t.TempDir()
+
+	const pid = 4242
+	writeFakeProc(t, common.ProcRoot, pid, "first", 100)
+	cached, err, _ := NewCachedProcess(getProcess(pid), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cached.Close()
+
+	command := func() string {
+		info, err, _ := cached.Info()
+		if err != nil {
+			return err.Error()
+		}
+		return info.GetCommand()
+	}
+
+	if c := command(); c != "first" {
+		t.Fatal("Expected the command to be first and got", c)
+	}
+	writeFakeProc(t, common.ProcRoot, pid, "second", 100)
+	if c := command(); c != "first" {
+		t.Error("The info was not cached, got", c)
+	}
+	cached.Refresh()
+	if c := command(); c != "second" {
+		t.Error("The info was not refreshed, got", c)
+	}
+
+	// Another process got the same pid.
+	writeFakeProc(t, common.ProcRoot, pid, "third", 200)
+	if _, err, _ := cached.Info(); err == nil {
+		t.Error("Got the info of a process that reused the pid")
+	}
+}
+
+// Name falls back through the sources of the name as they become unreadable.
+func TestNameSources(t *testing.T) {
+	defer func(root string) { common.ProcRoot = root }(common.ProcRoot)
+	common.ProcRoot = t.TempDir()
+
+	const pid = 4242
+	writeFakeProc(t, common.ProcRoot, pid, "worker", 100)
+	dir := filepath.Join(common.ProcRoot, strconv.Itoa(pid))
+	if err := ioutil.WriteFile(filepath.Join(dir, "cmdline"), []byte("./worker\x00--fast\x00"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "comm"), []byte("wrk\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	exe, err := filepath.EvalSymlinks(os.Args[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := openedProcess(pid)
+	for _, tier := range []struct {
+		name       string
+		source     NameSource
+		softerrors int
+		remove     string
+	}{
+		{exe, NameFromExe, 0, "exe"},
+		{"./worker", NameFromCmdline, 1, "cmdline"},
+		{"[wrk]", NameFromComm, 2, "comm"},
+		{"[worker]", NameFromStatus, 3, "status"},
+	} {
+		name, source, err, softerrors := NameAndSource(p)
+		if err != nil || name != tier.name || source != tier.source || len(softerrors) != tier.softerrors {
+			t.Errorf("Expected %q from %s with %d softerrors, got %q from %s, %v, %v", tier.name, tier.source,
+				tier.softerrors, name, source, err, softerrors)
+		}
+		if plain, err, _ := p.Name(); err != nil || plain != tier.name {
+			t.Errorf("Name returned %q, %v instead of %q", plain, err, tier.name)
+		}
+		if err := os.Remove(filepath.Join(dir, tier.remove)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Nothing is left but the stat, so the process is still there.
+	name, source, err, softerrors := NameAndSource(p)
+	if err == nil || errors.Is(err, ErrProcessExited) || name != "" || source != "" || len(softerrors) != 4 {
+		t.Errorf("Expected a harderror and 4 softerrors without any name, got %q, %q, %v, %v", name, source, err,
+			softerrors)
+	}
+}
+
+// An empty first argument, like kernel threads have, isn't a name.
+func TestNameEmptyCmdline(t *testing.T) {
+	defer func(root string) { common.ProcRoot = root }(common.ProcRoot)
+	common.ProcRoot = t.TempDir()
+
+	const pid = 4242
+	writeFakeProc(t, common.ProcRoot, pid, "kworker", 100)
+	dir := filepath.Join(common.ProcRoot, strconv.Itoa(pid))
+	if err := os.Remove(filepath.Join(dir, "exe")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "cmdline"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	name, source, err, _ := NameAndSource(getProcess(pid))
+	if err != nil || name != "[kworker]" || source != NameFromStatus {
+		t.Errorf("Expected [kworker] from the status, got %q from %s, %v", name, source, err)
+	}
+}
+
+// A killed process that wasn't reaped yet has no name, instead of the one of its comm.
+func TestNameOfZombie(t *testing.T) {
+	cmd, err := test.LaunchTestCaseAndWaitForInitialization()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Wait()
+	p, err, softerrors := OpenFromPid(cmd.Process.Pid)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	cmd.Process.Kill()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		if stat, err := common.ReadStatFile(uint(cmd.Process.Pid)); err != nil || stat.State == "Z" {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if name, err, _ := p.Name(); !errors.Is(err, ErrProcessExited) || name != "" {
+		t.Errorf("Expected the zombie to have exited, got the name %q, %v", name, err)
+	}
+}
+
+func TestOpenByNameMatches(t *testing.T) {
+	p, cmd, exe := launchCopy(t)
+	defer cmd.Process.Kill()
+	defer p.Close()
+
+	matches, err, softerrors := OpenByNameMatches(regexp.MustCompile(regexp.QuoteMeta(exe)))
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		for _, m := range matches {
+			m.Close()
+		}
+	}()
+	if len(matches) != 1 || matches[0].Pid() != cmd.Process.Pid || matches[0].Name != exe ||
+		matches[0].Source != NameFromExe {
+		t.Errorf("Expected process %d named %s from its exe, got %+v", cmd.Process.Pid, exe, matches)
+	}
+}
+
+func TestArchitecture(t *testing.T) {
+	p, cmd, _ := launchCopy(t)
+	defer cmd.Process.Kill()
+	defer p.Close()
+
+	arch, err, softerrors := p.Architecture()
+	if err != nil || arch != hostArch() || arch.PointerSize() == 0 || len(softerrors) != 0 {
+		t.Errorf("Expected the test case to be %s, got %s, %v, %v", hostArch(), arch, err, softerrors)
+	}
+}
+
+// elfHeader returns the start of an ELF header of the given class and machine, in the byte order of the current
+// machine.
+func elfHeader(class byte, machine uint16) []byte {
+	header := make([]byte, 64)
+	copy(header, "\x7fELF")
+	header[4], header[5] = class, 1
+	if nativeEndian.Uint16([]byte{0, 1}) == 1 {
+		header[5] = elfDataMSB
+	}
+	nativeEndian.PutUint16(header[elfMachine:], machine)
+	return header
+}
+
+// auxvWithPhent returns an auxiliary vector with the given program header size, and words of the given size.
+func auxvWithPhent(wordSize int, phent uint64) []byte {
+	auxv := make([]byte, 0, 6*wordSize)
+	for _, word := range []uint64{atPhent, phent, 0, 0} {
+		w := make([]byte, 8)
+		nativeEndian.PutUint64(w, word)
+		if wordSize == 4 {
+			w = make([]byte, 4)
+			nativeEndian.PutUint32(w, uint32(word))
+		}
+		auxv = append(auxv, w...)
+	}
+	return auxv
+}
+
+// The architecture of 32 bits executables, and of the processes whose executable can't be read.
+func TestArchitectureFakeProc(t *testing.T) {
+	defer func(root string) { common.ProcRoot = root }(common.ProcRoot)
+	common.ProcRoot = t.TempDir()
+
+	const pid = 4242
+	writeFakeProc(t, common.ProcRoot, pid, "arch", 100)
+	exe := filepath.Join(common.ProcRoot, strconv.Itoa(pid), "exe")
+	auxv := filepath.Join(common.ProcRoot, strconv.Itoa(pid), "auxv")
+	for _, c := range []struct {
+		exe        []byte
+		auxv       []byte
+		arch       Arch
+		softerrors int
+	}{
+		{elfHeader(elfClass32, emX86), nil, ArchX86, 0},
+		{elfHeader(elfClass64, emAArch64), nil, ArchARM64, 0},
+		{elfHeader(elfClass32, emARM), nil, ArchARM, 0},
+		{elfHeader(elfClass64, 0x1234), nil, ArchUnknown, 0},
+		{nil, auxvWithPhent(8, elf64PhdrLen), hostArch(), 1},
+		{nil, auxvWithPhent(4, elf32PhdrLen), compatArch(hostArch()), 1},
+	} {
+		os.Remove(exe)
+		os.Remove(auxv)
+		if c.exe != nil {
+			if err := ioutil.WriteFile(exe, c.exe, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if c.auxv != nil {
+			if err := ioutil.WriteFile(auxv, c.auxv, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		arch, err, softerrors := getProcess(pid).Architecture()
+		if err != nil || arch != c.arch || len(softerrors) != c.softerrors {
+			t.Errorf("Expected %s with %d softerrors, got %s, %v, %v", c.arch, c.softerrors, arch, err, softerrors)
+		}
+		if info, err, _ := processInfo(pid, InfoOptions{}); err != nil || info.GetArch() != TargetOf(c.arch) {
+			t.Errorf("Expected the info to have %s, got %v (%v)", c.arch, info.GetArch(), err)
+		}
+	}
+	if ArchX86.PointerSize() != 4 || ArchARM64.PointerSize() != 8 || ArchUnknown.PointerSize() != 0 {
+		t.Error("Wrong pointer sizes")
+	}
+
+	// Kernel threads have neither.
+	os.Remove(auxv)
+	if arch, err, _ := getProcess(pid).Architecture(); err == nil || arch != ArchUnknown {
+		t.Errorf("Expected an error without an executable and an auxiliary vector, got %s, %v", arch, err)
+	}
+}
+
+func TestIsAlive(t *testing.T) {
+	p, cmd, _ := launchCopy(t)
+	defer cmd.Process.Kill()
+	defer p.Close()
+
+	if alive, err := p.IsAlive(); err != nil || !alive {
+		t.Fatalf("IsAlive of a running process returned %v, %v", alive, err)
+	}
+
+	// Killed but not waited for, it's a zombie.
+	if err := cmd.Process.Kill(); err != nil {
+		t.Fatal(err)
+	}
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		alive, err := p.IsAlive()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !alive {
+			break
+		}
+		if time.Since(start) > time.Second {
+			t.Fatal("IsAlive of a killed process kept returning true")
+		}
+	}
+
+	cmd.Wait()
+	if alive, err := p.IsAlive(); err != nil || alive {
+		t.Errorf("IsAlive of a reaped process returned %v, %v", alive, err)
+	}
+	if _, err, _ := p.Name(); !errors.Is(err, ErrProcessExited) {
+		t.Errorf("Name of an exited process returned %v", err)
+	}
+	if _, err, _ := p.Cmdline(); !errors.Is(err, ErrProcessExited) {
+		t.Errorf("Cmdline of an exited process returned %v", err)
+	}
+	if _, err, _ := p.Environ(); !errors.Is(err, ErrProcessExited) {
+		t.Errorf("Environ of an exited process returned %v", err)
+	}
+	if _, err, _ := p.Threads(); !errors.Is(err, ErrProcessExited) {
+		t.Errorf("Threads of an exited process returned %v", err)
+	}
+}
+
+func TestIsAlivePidReuse(t *testing.T) {
+	defer func(root string) { common.ProcRoot = root }(common.ProcRoot)
+	common.ProcRoot = t.TempDir()
+
+	const pid = 4242
+	writeFakeProc(t, common.ProcRoot, pid, "first", 100)
+	opened, unknown := openedProcess(pid), getProcess(pid)
+	if alive, err := opened.IsAlive(); err != nil || !alive {
+		t.Fatalf("IsAlive of a running process returned %v, %v", alive, err)
+	}
+
+	// Another process got the same pid, only the opened one knows its start time.
+	writeFakeProc(t, common.ProcRoot, pid, "second", 200)
+	if alive, err := opened.IsAlive(); err != nil || alive {
+		t.Errorf("IsAlive of a process whose pid was reused returned %v, %v", alive, err)
+	}
+	if alive, err := unknown.IsAlive(); err != nil || !alive {
+		t.Errorf("IsAlive of a process without a start time returned %v, %v", alive, err)
+	}
+	if err := opened.exited(errors.New("failed")); !errors.Is(err, ErrProcessExited) {
+		t.Errorf("The error of a process whose pid was reused is %v", err)
+	}
+}
+
+func TestVerifyMatchPidReuse(t *testing.T) {
+	defer func(root string) { common.ProcRoot = root }(common.ProcRoot)
+	common.ProcRoot = t.TempDir()
+
+	for _, pid := range []int{4242, 4243} {
+		writeFakeProc(t, common.ProcRoot, pid, "first", 100)
+		for _, file := range []string{"mem", "maps"} {
+			if err := ioutil.WriteFile(filepath.Join(common.ProcRoot, strconv.Itoa(pid), file), nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	ps, err, softerrors := OpenByName(regexp.MustCompile(regexp.QuoteMeta(os.Args[0])))
+	test.PrintSoftErrors(softerrors)
+	if err != nil || len(ps) != 2 {
+		t.Fatalf("Expected the 2 fake processes, got %v (%v)", ps, err)
+	}
+	defer CloseAll(ps)
+
+	// The first one is used before its pid is reused, so it's not checked again.
+	if err := VerifyMatch(ps[0]); err != nil {
+		t.Errorf("A process that is still running failed its verification: %v", err)
+	}
+	writeFakeProc(t, common.ProcRoot, 4242, "second", 200)
+	writeFakeProc(t, common.ProcRoot, 4243, "second", 200)
+	if err := VerifyMatch(ps[0]); err != nil {
+		t.Errorf("A verified process was checked again: %v", err)
+	}
+
+	if err := VerifyMatch(ps[1]); !errors.Is(err, ErrProcessExited) {
+		t.Errorf("The verification of a process whose pid was reused returned %v", err)
+	}
+	if _, err := OpenResource(ps[1], MapsResource); !errors.Is(err, ErrProcessExited) {
+		t.Errorf("Opened the maps of a process whose pid was reused: %v", err)
+	}
+	if err := VerifyMatch(getProcess(4243)); err != nil {
+		t.Errorf("A process that wasn't matched was verified: %v", err)
+	}
+}
+
+// OpenByName is run while short lived processes with the same name start and exit.
+func TestOpenByNameUnderChurn(t *testing.T) {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			cmd := exec.Command(test.GetTestCasePath())
+			if err := cmd.Start(); err != nil {
+				t.Error(err)
+				return
+			}
+			time.Sleep(time.Duration(i%5) * time.Millisecond)
+			cmd.Process.Kill()
+			cmd.Wait()
+		}
+	}()
+	defer func() { close(stop); <-done }()
+
+	r := regexp.MustCompile(regexp.QuoteMeta(test.GetTestCasePath()))
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); {
+		ps, err, softerrors := OpenByName(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, err := range softerrors {
+			var located common.Locator
+			if !errors.As(err, &located) {
+				t.Errorf("The softerror %v isn't located at a process", err)
+			}
+		}
+		for _, p := range ps {
+			if err := VerifyMatch(p); err != nil {
+				if !errors.Is(err, ErrProcessExited) {
+					t.Errorf("Unexpected verification error %v", err)
+				}
+				continue
+			}
+			// The process can still exit before its name is read, which then fails with an ExitedError.
+			name, source, err, _ := NameAndSource(p)
+			switch {
+			case err != nil && !errors.Is(err, ErrProcessExited):
+				t.Errorf("Unexpected error reading the name of process %d: %v", p.Pid(), err)
+			case err == nil && source != NameFromExe:
+				t.Errorf("Process %d was named %s from its %s", p.Pid(), name, source)
+			case err == nil && !r.MatchString(name):
+				t.Errorf("Process %d named %s matched", p.Pid(), name)
+			}
+		}
+		CloseAll(ps)
+	}
+}
+
+// exitedPid returns the pid of a process that already exited.
+func exitedPid(t *testing.T) int {
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	return cmd.Process.Pid
+}
+
+func TestErrorKinds(t *testing.T) {
+	exited := exitedPid(t)
+	if _, err, _ := OpenFromPid(exited); !errors.Is(err, ErrProcessNotFound) || errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("Expected process %d not to be found, got %v", exited, err)
+	}
+	if _, err := GetProcessInfo(exited); !errors.Is(err, ErrProcessNotFound) {
+		t.Errorf("Expected the info of process %d not to be found, got %v", exited, err)
+	}
+
+	if os.Geteuid() != 0 {
+		if _, err, _ := OpenFromPid(1); !errors.Is(err, ErrPermissionDenied) {
+			t.Errorf("Expected the permission to open init to be denied, got %v", err)
+		}
+	} else {
+		// Root can open every process, the errors it would get are checked instead.
+		denied := &os.PathError{Op: "open", Path: "/proc/1/mem", Err: syscall.EACCES}
+		if err := ProcFileError(1, "/proc/1/mem", denied); !errors.Is(err, ErrPermissionDenied) {
+			t.Errorf("Expected a permission error, got %v", err)
+		}
+	}
+
+	// Processes that exit after being opened are gone, not missing.
+	p, cmd, _ := launchCopy(t)
+	defer p.Close()
+	cmd.Process.Kill()
+	cmd.Wait()
+	if _, err, _ := p.Name(); !errors.Is(err, ErrProcessExited) {
+		t.Errorf("Expected the process to have exited, got %v", err)
+	}
+}
+
+func TestWalkProcesses(t *testing.T) {
+	// The proc directory lists the pids in increasing order, so a walk stopping at init sees only it.
+	if initProc, err, _ := OpenFromPid(1); err == nil {
+		initProc.Close()
+		calls := 0
+		err, softerrors := WalkProcesses(func(p Process) bool {
+			calls++
+			defer p.Close()
+			return p.Pid() != 1
+		})
+		test.PrintSoftErrors(softerrors)
+		if err != nil || calls != 1 {
+			t.Errorf("Expected a single call stopping at init, got %d (%v)", calls, err)
+		}
+	}
+
+	cmd, err := test.LaunchTestCase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+	all, err, _ := GetAllPids()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	calls, seenSelf, seenChild := 0, false, false
+	err, softerrors := WalkProcesses(func(p Process) bool {
+		defer p.Close()
+		calls++
+		seenSelf = seenSelf || p.Pid() == os.Getpid()
+		seenChild = seenChild || p.Pid() == cmd.Process.Pid
+		return p.Pid() != os.Getpid()
+	})
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !seenSelf || calls >= len(all) {
+		t.Errorf("Expected the walk to stop at the test, got %d calls of %d processes", calls, len(all))
+	}
+	// Unless the pids wrapped around, the test case started later has a higher pid and isn't reached.
+	if cmd.Process.Pid > os.Getpid() && seenChild {
+		t.Errorf("The walk went on after the test to the test case %d", cmd.Process.Pid)
+	}
+}
+
+func TestOpenFromPids(t *testing.T) {
+	first, err := test.LaunchTestCaseAndWaitForInitialization()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Process.Kill()
+	second, err := test.LaunchTestCaseAndWaitForInitialization()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Process.Kill()
+
+	// init can't be opened without privileges, which root can lack in a container.
+	exited := exitedPid(t)
+	initProc, initErr, _ := OpenFromPid(1)
+	privileged := initErr == nil
+	if privileged {
+		initProc.Close()
+	}
+	ps, err, softerrors := OpenFromPids([]int{second.Process.Pid, exited, 1, first.Process.Pid, second.Process.Pid})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseAll(ps)
+
+	expected := []int{second.Process.Pid, first.Process.Pid}
+	failed := []int{exited, 1}
+	if privileged {
+		expected, failed = []int{second.Process.Pid, 1, first.Process.Pid}, []int{exited}
+	}
+	var pids []int
+	for _, p := range ps {
+		pids = append(pids, p.Pid())
+	}
+	if !reflect.DeepEqual(pids, expected) {
+		t.Errorf("Expected the processes %v, got %v", expected, pids)
+	}
+	var located []int
+	for _, e := range softerrors {
+		var l *common.LocatedError
+		if errors.As(e, &l) {
+			located = append(located, l.Pid)
+		}
+	}
+	sort.Ints(failed)
+	if !reflect.DeepEqual(located, failed) {
+		t.Errorf("Expected softerrors for %v, got %v", failed, softerrors)
+	}
+
+	if ps, err, softerrors := OpenFromPids([]int{exited}); err == nil || ps != nil || len(softerrors) != 1 {
+		t.Errorf("Opening only an exited process returned %v, %v, %v", ps, err, softerrors)
+	}
+	if ps, err, _ := OpenFromPids(nil); err != nil || len(ps) != 0 {
+		t.Errorf("Opening no process returned %v, %v", ps, err)
+	}
+}
+
+// The functions returning (result, harderror, softerrors) must return a zero result along with a harderror.
+func TestErrorContract(t *testing.T) {
+	pid := exitedPid(t)
+
+	if p, err, _ := OpenFromPid(pid); err == nil || p != nil {
+		t.Errorf("OpenFromPid of an exited process returned %v, %v", p, err)
+	}
+
+	p := GetProcess(pid)
+	if name, err, _ := p.Name(); err == nil || name != "" {
+		t.Errorf("Name of an exited process returned %q, %v", name, err)
+	}
+	if level, err, _ := p.AccessLevel(); err == nil || level != NoAccess {
+		t.Errorf("AccessLevel of an exited process returned %v, %v", level, err)
+	}
+	if info, err := GetProcessInfo(pid); err == nil || info != nil {
+		t.Errorf("GetProcessInfo of an exited process returned %v, %v", info, err)
+	}
+	if ancestors, err, _ := Ancestry(pid); err == nil || ancestors != nil {
+		t.Errorf("Ancestry of an exited process returned %v, %v", ancestors, err)
+	}
+	if files, err, _ := OpenFiles(p); err == nil || files != nil {
+		t.Errorf("OpenFiles of an exited process returned %v, %v", files, err)
+	}
+	if sockets, err, _ := InetSockets(p); err == nil || sockets != nil {
+		t.Errorf("InetSockets of an exited process returned %v, %v", sockets, err)
+	}
+	if peers, err, _ := UnixSocketPeers(p); err == nil || peers != nil {
+		t.Errorf("UnixSocketPeers of an exited process returned %v, %v", peers, err)
+	}
+	if facts, err, _ := GatherFacts(p); err == nil || facts != nil {
+		t.Errorf("GatherFacts of an exited process returned %+v, %v", facts, err)
+	}
+
+	defer func(root string) { common.ProcRoot = root }(common.ProcRoot)
+	common.ProcRoot = filepath.Join(t.TempDir(), "missing")
+	if pids, err, _ := GetAllPids(); err == nil || pids != nil {
+		t.Errorf("GetAllPids without a proc filesystem returned %v, %v", pids, err)
+	}
+	if ps, err, _ := OpenByName(regexp.MustCompile(".")); err == nil || ps != nil {
+		t.Errorf("OpenByName without a proc filesystem returned %v, %v", ps, err)
+	}
+	if snapshots, err, _ := SnapshotAll(); err == nil || snapshots != nil {
+		t.Errorf("SnapshotAll without a proc filesystem returned %v, %v", snapshots, err)
+	}
+	if mappings, err, _ := ProcessesMappingFile("/bin/sh"); err == nil || mappings != nil {
+		t.Errorf("ProcessesMappingFile without a proc filesystem returned %v, %v", mappings, err)
+	}
+}
+
+// A process without an executable, like a kernel thread, still has the rest of its info.
+func TestInfoWithoutExecutable(t *testing.T) {
+	defer func(root string) { common.ProcRoot = root }(common.ProcRoot)
+	common.ProcRoot = t.TempDir()
+
+	const pid = 4343
+	writeFakeProc(t, common.ProcRoot, pid, "kthread", 100)
+	if err := os.Remove(filepath.Join(common.ProcRoot, strconv.Itoa(pid), "exe")); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := GetProcessInfo(pid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (*info).GetCommand() != "kthread" || (*info).GetExecutable() != "" {
+		t.Errorf("Unexpected info %+v", *info)
+	}
+
+	cached, err, _ := NewCachedProcess(getProcess(pid), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cached.Close()
+	if _, err, softerrors := cached.Info(); err != nil || len(softerrors) != 1 {
+		t.Errorf("Expected the missing executable as a softerror, got %v, %v", err, softerrors)
+	}
+}
+
+// launchCopy launches a copy of the test case, and returns it opened with the path of the copy.
+func launchCopy(t *testing.T) (p Process, cmd *exec.Cmd, exe string) {
+	exe, err := test.CopyTestCase(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd = exec.Command(exe)
+	if err := test.StartAndWaitForInitialization(cmd); err != nil {
+		t.Fatal(err)
+	}
+	p, err, softerrors := OpenFromPid(cmd.Process.Pid)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		cmd.Process.Kill()
+		t.Fatal(err)
+	}
+	return p, cmd, exe
+}
+
+// Renaming or deleting the executable of a process doesn't change its image.
+func TestCurrentImageOfMovedExecutable(t *testing.T) {
+	p, cmd, exe := launchCopy(t)
+	defer cmd.Process.Kill()
+	defer p.Close()
+	before, err := CurrentImage(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Rename(exe, exe+".old"); err != nil {
+		t.Fatal(err)
+	}
+	renamed, err := CurrentImage(p)
+	if err != nil || !renamed.Same(before) || renamed.Executable != exe+".old" {
+		t.Errorf("Renaming the executable changed the image from %+v to %+v, %v", before, renamed, err)
+	}
+	if err := os.Remove(exe + ".old"); err != nil {
+		t.Fatal(err)
+	}
+	if deleted, err := CurrentImage(p); err != nil || !deleted.Same(before) {
+		t.Errorf("Deleting the executable changed the image from %+v to %+v, %v", before, deleted, err)
+	}
+}
+
+func TestExecutableStatus(t *testing.T) {
+	p, cmd, exe := launchCopy(t)
+	defer cmd.Process.Kill()
+	defer p.Close()
+	status, err := ExecutableStatus(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.State != ExePresent || status.Path != exe || status.Inode == 0 || status.PathInode != 0 {
+		t.Errorf("Unexpected status of a present executable %+v", status)
+	}
+	running := status
+
+	if err := os.Remove(exe); err != nil {
+		t.Fatal(err)
+	}
+	if status, err = ExecutableStatus(p); err != nil {
+		t.Fatal(err)
+	}
+	if status.State != ExeDeleted || status.Path != exe || status.Inode != running.Inode {
+		t.Errorf("Unexpected status of a deleted executable %+v", status)
+	}
+
+	// An upgrade renames a new file over the executable.
+	replaced, replacedCmd, replacedExe := launchCopy(t)
+	defer replacedCmd.Process.Kill()
+	defer replaced.Close()
+	upgrade := filepath.Join(t.TempDir(), "upgrade")
+	if err := ioutil.WriteFile(upgrade, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(upgrade, replacedExe); err != nil {
+		t.Fatal(err)
+	}
+	var st syscall.Stat_t
+	if err := syscall.Stat(replacedExe, &st); err != nil {
+		t.Fatal(err)
+	}
+	if status, err = ExecutableStatus(replaced); err != nil {
+		t.Fatal(err)
+	}
+	if status.State != ExeReplaced || status.Path != replacedExe || status.PathInode != uint64(st.Ino) ||
+		status.Inode == status.PathInode {
+
+		t.Errorf("Unexpected status of a replaced executable %+v", status)
+	}
+
+	if _, err := ExecutableStatus(getProcess(1 << 30)); err == nil {
+		t.Error("The status of a process that doesn't exist should fail")
+	}
+}
+
+func TestMutationInterlock(t *testing.T) {
+	p, cmd, exe := launchCopy(t)
+	defer cmd.Process.Kill()
+	defer p.Close()
+	defer RevokeMutation(p)
+
+	if err := CheckMutation(p); !errors.Is(err, ErrMutationNotAllowed) {
+		t.Errorf("A process that wasn't allowed can be modified (%v)", err)
+	}
+	if err := AllowMutation(p, MutationToken{Pid: p.Pid() + 1, Executable: exe}); !errors.Is(err,
+		ErrMutationNotAllowed) {
+
+		t.Errorf("A token with another pid was accepted (%v)", err)
+	}
+	if err := AllowMutation(p, MutationToken{Pid: p.Pid(), Executable: exe + ".other"}); !errors.Is(err,
+		ErrMutationNotAllowed) {
+
+		t.Errorf("A token with another executable was accepted (%v)", err)
+	}
+	if err := CheckMutation(p); err == nil {
+		t.Error("A rejected token allowed the process to be modified")
+	}
+
+	if err := AllowMutation(p, MutationToken{Pid: p.Pid(), Executable: exe}); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckMutation(p); err != nil {
+		t.Errorf("An allowed process can't be modified (%v)", err)
+	}
+	if _, err := exeBuildID(p.Pid()); err == nil {
+		SetStrictMutation(true)
+		err := CheckMutation(p)
+		SetStrictMutation(false)
+		if err != nil {
+			t.Errorf("An allowed process can't be modified in strict mode (%v)", err)
+		}
+	}
+
+	// A process that reuses the pid and runs the same executable has another start time.
+	mutationMu.Lock()
+	grant := mutationGrants[p.Pid()]
+	grant.startTime++
+	mutationGrants[p.Pid()] = grant
+	mutationMu.Unlock()
+	if err := CheckMutation(p); !errors.Is(err, ErrMutationNotAllowed) {
+		t.Errorf("A process with another start time can be modified (%v)", err)
+	}
+	if err := AllowMutation(p, MutationToken{Pid: p.Pid(), Executable: exe}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The executable isn't the allowed one anymore once it's deleted.
+	if err := os.Remove(exe); err != nil {
+		t.Fatal(err)
+	}
+	err := CheckMutation(p)
+	var mutationErr *MutationError
+	if !errors.As(err, &mutationErr) || mutationErr.Pid != p.Pid() {
+		t.Errorf("A process whose executable was deleted can be modified (%v)", err)
+	}
+
+	RevokeMutation(p)
+	if err := CheckMutation(p); err == nil {
+		t.Error("A revoked process can be modified")
+	}
+}
+
+func TestHashExecutable(t *testing.T) {
+	p, cmd, exe := launchCopy(t)
+	defer cmd.Process.Kill()
+	defer p.Close()
+
+	data, err := ioutil.ReadFile(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := fmt.Sprintf("%x", sha256.Sum256(data))
+	if digest, err := HashExecutable(p); err != nil || digest != expected {
+		t.Errorf("Expected the hash %s and got %s (%v)", expected, digest, err)
+	}
+	info, err, softerrors := processInfo(p.Pid(), InfoOptions{HashExecutable: true})
+	test.PrintSoftErrors(softerrors)
+	if err != nil || info.ExecutableSHA256 != expected {
+		t.Errorf("Expected the hash %s in the info and got %s (%v)", expected, info.ExecutableSHA256, err)
+	}
+	if info, _, _ := processInfo(p.Pid(), InfoOptions{}); info.ExecutableSHA256 != "" {
+		t.Error("The executable was hashed without being asked")
+	}
+
+	// The running binary is hashed, not the one replacing it on disk.
+	if err := ioutil.WriteFile(exe+".new", []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(exe+".new", exe); err != nil {
+		t.Fatal(err)
+	}
+	if digest, err := HashExecutable(p); err != nil || digest != expected {
+		t.Errorf("Expected the hash %s of the replaced executable and got %s (%v)", expected, digest, err)
+	}
+
+	if _, err := HashExecutable(getProcess(1 << 30)); err == nil {
+		t.Error("Hashing the executable of a process that doesn't exist should fail")
+	}
+}
+
+// threadStates returns the states of the threads of p.
+func threadStates(t *testing.T, p Process) []string {
+	tids, err, _ := p.Threads()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var states []string
+	for _, tid := range tids {
+		data, err := ioutil.ReadFile(filepath.Join(common.ProcFilePath(uint(p.Pid()), "task"), strconv.Itoa(tid),
+			"stat"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		stat, err := common.ParseStatFile(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		states = append(states, stat.State)
+	}
+	return states
+}
+
+func TestSuspend(t *testing.T) {
+	cmd, err := test.LaunchTestCaseAndWaitForInitialization("--threads", "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+	p, err, softerrors := OpenFromPid(cmd.Process.Pid)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	// Resuming a process that wasn't suspended does nothing.
+	if err, softerrors := p.Resume(); err != nil || len(softerrors) != 0 {
+		t.Errorf("Resuming a running process failed: %v, %v", err, softerrors)
+	}
+
+	if err, softerrors := p.Suspend(); err != nil || len(softerrors) != 0 {
+		t.Fatalf("Unable to suspend the test case: %v, %v", err, softerrors)
+	}
+	states := threadStates(t, p)
+	if len(states) != 4 || strings.Trim(strings.Join(states, ""), "T") != "" {
+		t.Errorf("The threads are %v once suspended", states)
+	}
+
+	if err, _ := p.Resume(); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); strings.Contains(strings.Join(threadStates(t, p), ""), "T"); {
+		if time.Now().After(deadline) {
+			t.Fatalf("The threads are %v once resumed", threadStates(t, p))
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	self, err, _ := OpenFromPid(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer self.Close()
+	if err, _ := self.Suspend(); err == nil {
+		t.Error("A process shouldn't suspend itself")
+	}
+	if err, _ := getProcess(0).Signal(syscall.SIGCONT); err == nil {
+		t.Error("Signaling pid 0 should fail, not signal the process group")
+	}
+
+	cmd.Process.Kill()
+	cmd.Wait()
+	if err, _ := p.Resume(); err == nil {
+		t.Error("Resuming a process that exited should fail")
+	}
+}
+
+func TestResumeAlreadyStopped(t *testing.T) {
+	cmd, err := test.LaunchTestCaseAndWaitForInitialization("--threads", "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+	p, err, softerrors := OpenFromPid(cmd.Process.Pid)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	if err := cmd.Process.Signal(syscall.SIGSTOP); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); strings.Trim(strings.Join(threadStates(t, p), ""), "T") != ""; {
+		if time.Now().After(deadline) {
+			t.Fatalf("The threads are %v once stopped", threadStates(t, p))
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if err, softerrors := p.Suspend(); err != nil || len(softerrors) != 0 {
+		t.Fatalf("Unable to suspend a stopped process: %v, %v", err, softerrors)
+	}
+	if err, _ := p.Resume(); err != nil {
+		t.Fatal(err)
+	}
+	// Give a SIGCONT time to be delivered.
+	time.Sleep(50 * time.Millisecond)
+	if states := threadStates(t, p); strings.Trim(strings.Join(states, ""), "T") != "" {
+		t.Errorf("A process stopped before it was suspended was resumed, its threads are %v", states)
+	}
+
+	// Once it was left stopped, Resume continues it as any other.
+	if err, _ := p.Resume(); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); strings.Contains(strings.Join(threadStates(t, p), ""), "T"); {
+		if time.Now().After(deadline) {
+			t.Fatalf("The threads are %v once resumed", threadStates(t, p))
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestOpenWhere(t *testing.T) {
+	cmd, err := test.LaunchTestCaseAndWaitForInitialization()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	s, err := Select(fmt.Sprintf(`pid == %d && name == "test" && rss > 0 && !kernel_thread && state =~ "^[RS]$"`,
+		cmd.Process.Pid))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ps, err, softerrors := OpenWhere(s)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ps) != 1 || ps[0].Pid() != cmd.Process.Pid {
+		t.Errorf("Expected only the test case, got %v", ps)
+	}
+	for _, p := range ps {
+		p.Close()
+	}
+}
+
+func TestGatherFactsOfKernelThread(t *testing.T) {
+	defer func(root string) { common.ProcRoot = root }(common.ProcRoot)
+	common.ProcRoot = t.TempDir()
+
+	// Kernel threads have no Vm* keys nor executable.
+	const pid = 4343
+	writeFakeProc(t, common.ProcRoot, pid, "kworker", 100)
+	if err := os.Remove(filepath.Join(common.ProcRoot, strconv.Itoa(pid), "exe")); err != nil {
+		t.Fatal(err)
+	}
+
+	facts, err, softerrors := GatherFacts(getProcess(pid))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(softerrors) != 1 || facts["kernel_thread"] != true || facts["name"] != "kworker" || facts["exe"] != "" {
+		t.Errorf("Unexpected facts %v, softerrors %v", facts, softerrors)
+	}
+	if _, ok := facts["rss"]; ok {
+		t.Errorf("Expected no rss for a kernel thread, got %v", facts["rss"])
+	}
+
+	// A zombie has no Vm* keys either.
+	status := fmt.Sprintf("Name:\tzombie\nState:\tZ (zombie)\nPid:\t%d\nPPid:\t1\n", pid)
+	if err := ioutil.WriteFile(filepath.Join(common.ProcRoot, strconv.Itoa(pid), "status"), []byte(status),
+		0644); err != nil {
+		t.Fatal(err)
+	}
+	if facts, _, _ := GatherFacts(getProcess(pid)); facts["kernel_thread"] != false || facts["state"] != "Z" {
+		t.Errorf("Unexpected facts of a zombie %v", facts)
+	}
+}
+
+func TestUnixSocketPeers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "socket")
+	server, err := test.LaunchTestCaseAndWaitForInitialization("--listen", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Process.Kill()
+	client, err := test.LaunchTestCaseAndWaitForInitialization("--connect", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Process.Kill()
+
+	// The server accepts the connection once initialized.
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		serverPeer := findPeer(t, server.Process.Pid, client.Process.Pid)
+		clientPeer := findPeer(t, client.Process.Pid, server.Process.Pid)
+		if serverPeer != nil && clientPeer != nil {
+			if serverPeer.Path != path || clientPeer.Path != "" || serverPeer.PeerInode != clientPeer.Inode ||
+				clientPeer.PeerExecutable != test.GetTestCasePath() || serverPeer.Heuristic {
+
+				t.Errorf("Unexpected peers %+v and %+v", *serverPeer, *clientPeer)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("The test cases aren't peers")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestOpenFiles(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(file, []byte("open"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	socket := filepath.Join(dir, "socket")
+	cmd, err := test.LaunchTestCaseAndWaitForInitialization("--open", file, "--listen", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	files, err, softerrors := OpenFiles(GetProcess(cmd.Process.Pid))
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	foundFile, foundSocket := false, false
+	for i, f := range files {
+		if i > 0 && files[i-1].Fd >= f.Fd {
+			t.Errorf("The files aren't sorted by fd: %+v", files)
+		}
+		switch {
+		case f.Path == file:
+			foundFile = f.Kind == FileRegular
+		case f.Kind == FileSocket:
+			foundSocket = f.Inode != 0 && f.Description == "unix "+socket
+		}
+	}
+	if !foundFile || !foundSocket {
+		t.Errorf("Expected %s and a socket listening on %s, got %+v", file, socket, files)
+	}
+}
+
+func TestOpenFilesInetSockets(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer listener.Close()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+
+	files, err, softerrors := OpenFiles(GetProcess(os.Getpid()))
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listening := fmt.Sprintf("tcp %s->0.0.0.0:0 LISTEN", listener.Addr())
+	foundListener, foundPipe := false, false
+	for _, f := range files {
+		foundListener = foundListener || (f.Kind == FileSocket && f.Description == listening)
+		foundPipe = foundPipe || (f.Fd == int(r.Fd()) && f.Kind == FilePipe && f.Inode != 0)
+	}
+	if !foundListener || !foundPipe {
+		t.Errorf("Expected a socket described as %q and a pipe at fd %d, got %+v", listening, r.Fd(), files)
+	}
+}
+
+// The fixtures are little endian, as the tables of the machines they were taken from.
+func TestReadNetInetFixtures(t *testing.T) {
+	if nativeEndian.Uint16([]byte{1, 0}) != 1 {
+		t.Skip("The fixtures are little endian")
+	}
+	expected := map[string][]string{
+		"net_tcp": {
+			"tcp 127.0.0.1:8080->0.0.0.0:0 LISTEN",
+			"tcp 10.0.0.5:41200->10.2.3.4:443 ESTABLISHED",
+		},
+		"net_tcp6": {
+			"tcp [::]:8443->[::]:0 LISTEN",
+			"tcp 10.0.0.5:8443->10.2.3.4:443 ESTABLISHED",
+			"tcp [2001:db8::1]:8080->[2001:db8::2]:50000 TIME_WAIT",
+		},
+	}
+	for name, descriptions := range expected {
+		sockets, err := readNetInet(filepath.Join("testdata", name), "tcp")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var found []string
+		for _, socket := range sockets {
+			found = append(found, socket.String())
+		}
+		if !reflect.DeepEqual(found, descriptions) {
+			t.Errorf("Expected the sockets %q in %s and found %q", descriptions, name, found)
+		}
+		if !sockets[0].Listening() || sockets[1].Listening() {
+			t.Errorf("Only the first socket of %s should be listening: %+v", name, sockets)
+		}
+	}
+
+	if _, err := parseInetAddress("0100007F"); err == nil {
+		t.Error("An address without a port should fail")
+	}
+	if _, err := parseInetAddress("0100007:1F90"); err == nil {
+		t.Error("An address of an unexpected length should fail")
+	}
+}
+
+// InetSockets reads the tables of the network namespace of the process, not the ones of /proc/net.
+func TestInetSocketsFakeProc(t *testing.T) {
+	if nativeEndian.Uint16([]byte{1, 0}) != 1 {
+		t.Skip("The fixtures are little endian")
+	}
+	defer func(root string) { common.ProcRoot = root }(common.ProcRoot)
+	common.ProcRoot = t.TempDir()
+
+	const pid = 4545
+	writeFakeProc(t, common.ProcRoot, pid, "server", 100)
+	dir := filepath.Join(common.ProcRoot, strconv.Itoa(pid))
+	for _, d := range []string{"fd", "net"} {
+		if err := os.Mkdir(filepath.Join(dir, d), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for fd, target := range map[int]string{3: "socket:[1002]", 4: "socket:[1001]", 5: "pipe:[1003]"} {
+		if err := os.Symlink(target, filepath.Join(dir, "fd", strconv.Itoa(fd))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "net_tcp6"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "net", "tcp6"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	sockets, err, softerrors := InetSockets(GetProcess(pid))
+	if err != nil || len(softerrors) != 0 {
+		t.Fatal(err, softerrors)
+	}
+	if len(sockets) != 2 || sockets[0].Fd != 3 || sockets[0].Remote.String() != "10.2.3.4:443" ||
+		sockets[1].Fd != 4 || !sockets[1].Listening() {
+
+		t.Errorf("Unexpected sockets %+v", sockets)
+	}
+}
+
+// findPeer returns the socket of pid connected to peerPid, if any.
+func findPeer(t *testing.T, pid int, peerPid int) *UnixSocketPeer {
+	peers, err, softerrors := UnixSocketPeers(GetProcess(pid))
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, peer := range peers {
+		if peer.PeerPid == peerPid {
+			return &peer
+		}
+	}
+	return nil
+}
+
+func TestUnixSocketPeersHeuristic(t *testing.T) {
+	defer func(root string) { common.ProcRoot = root }(common.ProcRoot)
+	common.ProcRoot = t.TempDir()
+	defer func() { useSockDiag = true }()
+	useSockDiag = false
+
+	// 4242 listens on a path and accepted a connection from 4343, created right after. 4343 also has a socket that
+	// isn't connected.
+	netUnix := "Num       RefCount Protocol Flags    Type St Inode Path\n" +
+		"0000000000000000: 00000002 00000000 00010000 0001 01 100 /run/server.sock\n" +
+		"0000000000000000: 00000003 00000000 00000000 0001 03 201 /run/server.sock\n" +
+		"0000000000000000: 00000003 00000000 00000000 0001 03 202\n" +
+		"0000000000000000: 00000002 00000000 00000000 0002 01 300 @abstract\n"
+	for pid, inodes := range map[int][]int{4242: {100, 201}, 4343: {202, 300}} {
+		writeFakeProc(t, common.ProcRoot, pid, "test", 100)
+		// The sockets are read from the net directory of the process, which is the one of its network namespace.
+		netDir := filepath.Join(common.ProcRoot, strconv.Itoa(pid), "net")
+		if err := os.MkdirAll(netDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(netDir, "unix"), []byte(netUnix), 0644); err != nil {
+			t.Fatal(err)
+		}
+		fdDir := filepath.Join(common.ProcRoot, strconv.Itoa(pid), "fd")
+		if err := os.MkdirAll(fdDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		for fd, inode := range inodes {
+			if err := os.Symlink(fmt.Sprintf("socket:[%d]", inode), filepath.Join(fdDir, strconv.Itoa(fd+3))); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	peers, err, _ := UnixSocketPeers(GetProcess(4343))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []UnixSocketPeer{
+		{Inode: 202, PeerInode: 201, PeerPid: 4242, PeerExecutable: os.Args[0], Heuristic: true},
+		{Inode: 300, Path: "@abstract"},
+	}
+	if !reflect.DeepEqual(peers, expected) {
+		t.Errorf("Expected peers %+v, got %+v", expected, peers)
+	}
+}
+
+func TestAncestry(t *testing.T) {
+	ancestors, err, softerrors := Ancestry(os.Getpid())
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ancestors) == 0 || ancestors[0] != os.Getppid() || ancestors[len(ancestors)-1] != 1 {
+		t.Errorf("Expected the ancestors from %d up to init, got %v", os.Getppid(), ancestors)
+	}
+
+	// Process 30 is the parent of 20, but it started later: the real parent exited and its pid was reused.
+	defer func(root string) { common.ProcRoot = root }(common.ProcRoot)
+	common.ProcRoot = t.TempDir()
+	for _, p := range []struct{ pid, ppid, startTime int }{{10, 20, 300}, {20, 30, 200}, {30, 1, 250}} {
+		dir := filepath.Join(common.ProcRoot, strconv.Itoa(p.pid))
+		stat := fmt.Sprintf("%d (fake) S %d %d %d 0 -1 4194304 10 0 0 0 1 2 0 0 20 0 1 0 %d 8192 100\n", p.pid, p.ppid,
+			p.pid, p.pid, p.startTime)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, "stat"), []byte(stat), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ancestors, err, softerrors = Ancestry(10)
+	if err != nil || !reflect.DeepEqual(ancestors, []int{20}) || len(softerrors) != 1 {
+		t.Errorf("Expected the ancestry to end at the reused pid, got %v, %v, %v", ancestors, err, softerrors)
+	}
+}
+
+// writeFakeVisibility fills a fake proc filesystem with the given mount options, kernel tasks and processes, by their
+// amount of threads. The processes with no threads have an unreadable status.
+func writeFakeVisibility(t *testing.T, root string, options string, tasks int, nspids string, threads ...int) {
+	mounts := fmt.Sprintf("sysfs /sys sysfs rw 0 0\nproc %s proc %s 0 0\n", root, options)
+	loadavg := fmt.Sprintf("0.20 0.18 0.12 1/%d 11206\n", tasks)
+	self := filepath.Join(root, "self")
+	if err := os.MkdirAll(self, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for file, data := range map[string]string{"mounts": mounts, "loadavg": loadavg,
+		"self/status": fmt.Sprintf("Name:\tprocess.test\nNSpid:\t%s\n", nspids)} {
+
+		if err := ioutil.WriteFile(filepath.Join(root, file), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i, n := range threads {
+		dir := filepath.Join(root, strconv.Itoa(100+i))
+		if n == 0 {
+			// Reading a directory fails, even as root.
+			if err := os.MkdirAll(filepath.Join(dir, "status"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		status := fmt.Sprintf("Name:\tfake\nThreads:\t%d\n", n)
+		if err := ioutil.WriteFile(filepath.Join(dir, "status"), []byte(status), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestAssessVisibility(t *testing.T) {
+	defer func(root string) { common.ProcRoot = root }(common.ProcRoot)
+
+	for _, c := range []struct {
+		options  string
+		tasks    int
+		nspids   string
+		threads  []int
+		expected Visibility
+	}{
+		{"rw,relatime", 30, "42", []int{10, 10, 10},
+			Visibility{Level: FullVisibility, Coverage: 100, Listed: 3, Tasks: 30, VisibleTasks: 30}},
+		{"rw,hidepid=0", 31, "42", []int{10, 10, 10},
+			Visibility{Level: FullVisibility, Coverage: 100 * 30.0 / 31, Listed: 3, Tasks: 31, VisibleTasks: 30}},
+		{"rw,nosuid,hidepid=2", 40, "42", []int{10, 10, 10},
+			Visibility{Level: PartialVisibility, Coverage: 75, HidePid: "2", Listed: 3, Tasks: 40, VisibleTasks: 30}},
+		{"rw,hidepid=invisible,gid=10", 100, "42", []int{10, 10, 10},
+			Visibility{Level: SeverelyRestricted, Coverage: 30, HidePid: "invisible", Listed: 3, Tasks: 100,
+				VisibleTasks: 30}},
+		{"rw,hidepid=1", 30, "42", []int{29, 0},
+			Visibility{Level: PartialVisibility, Coverage: 100 * 29.0 / 30, HidePid: "1", Listed: 2, Unreadable: 1,
+				Tasks: 30, VisibleTasks: 29}},
+		// In a pid namespace the tasks of the kernel aren't comparable.
+		{"rw,hidepid=1", 1000, "42\t7", []int{1, 0, 0, 1},
+			Visibility{Level: PartialVisibility, Coverage: 50, HidePid: "1", Listed: 4, Unreadable: 2,
+				VisibleTasks: 2}},
+	} {
+		common.ProcRoot = t.TempDir()
+		writeFakeVisibility(t, common.ProcRoot, c.options, c.tasks, c.nspids, c.threads...)
+		visibility, err, softerrors := AssessVisibility()
+		if err != nil || len(softerrors) > 0 {
+			t.Fatal(err, softerrors)
+		}
+		if visibility != c.expected {
+			t.Errorf("With %s, expected %+v and got %+v", c.options, c.expected, visibility)
+		}
+	}
+
+	err := error(&VisibilityError{Visibility: Visibility{Level: PartialVisibility, Coverage: 75}})
+	if !errors.Is(err, ErrRestrictedVisibility) || err.Error() != "not every process can be seen: partial "+
+		"visibility, 75.0% coverage" {
+
+		t.Errorf("Unexpected VisibilityError %v", err)
+	}
+}
+
+func TestGetAllPidsSorted(t *testing.T) {
+	defer func(root string) { common.ProcRoot = root }(common.ProcRoot)
+	common.ProcRoot = t.TempDir()
+	// The directory is listed by name, which isn't the order of the pids.
+	for _, name := range []string{"10", "9", "self", "100", "2", "1"} {
+		if err := os.Mkdir(filepath.Join(common.ProcRoot, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pids, err, _ := GetAllPids()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []int{1, 2, 9, 10, 100}; !reflect.DeepEqual(pids, expected) {
+		t.Errorf("Expected %v, got %v", expected, pids)
+	}
+}
+
+// softErrorsSorted tells if softerrors are in the order of common.SortSoftErrors.
+func softErrorsSorted(softerrors []error) bool {
+	last, located := 0, false
+	for _, err := range softerrors {
+		var l common.Locator
+		if !errors.As(err, &l) {
+			if located {
+				return false
+			}
+			continue
+		}
+		pid, _ := l.Location()
+		if located && pid < last {
+			return false
+		}
+		last, located = pid, true
+	}
+	return true
+}
+
+// The functions that add their own softerrors to the ones of the walk still sort them.
+func TestOpenMatchingSoftErrorsSorted(t *testing.T) {
+	cmd, err := test.LaunchTestCaseAndWaitForInitialization()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { cmd.Process.Kill(); cmd.Wait() }()
+
+	r := regexp.MustCompile("test")
+	ps, _, softerrors := OpenMatching(r, MatchOptions{Name: true, Cmdline: true})
+	CloseAll(ps)
+	if !softErrorsSorted(softerrors) {
+		t.Errorf("OpenMatching returned unsorted softerrors %v", softerrors)
+	}
+
+	cached, _, softerrors := ProcessesWhere(func(ProcessInfo) bool { return false })
+	if len(cached) != 0 || !softErrorsSorted(softerrors) {
+		t.Errorf("ProcessesWhere returned %d processes and the softerrors %v", len(cached), softerrors)
+	}
+
+	matches, _, softerrors := OpenByNameMatches(r)
+	for _, m := range matches {
+		m.Close()
+	}
+	if !softErrorsSorted(softerrors) {
+		t.Errorf("OpenByNameMatches returned unsorted softerrors %v", softerrors)
+	}
+
+	s, err := Select(`name =~ "test"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps, _, softerrors = OpenWhere(s)
+	CloseAll(ps)
+	if !softErrorsSorted(softerrors) {
+		t.Errorf("OpenWhere returned unsorted softerrors %v", softerrors)
+	}
+}
+
+func TestCmdline(t *testing.T) {
+	args := []string{"first", "with spaces", "", "last=1"}
+	cmd, err := test.LaunchTestCaseAndWaitForInitialization(args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	p, err, softerrors := OpenFromPid(cmd.Process.Pid)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	cmdline, err, _ := p.Cmdline()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := append([]string{test.GetTestCasePath()}, args...); !reflect.DeepEqual(cmdline, expected) {
+		t.Errorf("Expected the arguments %q, got %q", expected, cmdline)
+	}
+
+	// Kernel threads have an empty cmdline file.
+	defer func(root string) { common.ProcRoot = root }(common.ProcRoot)
+	common.ProcRoot = t.TempDir()
+	dir := filepath.Join(common.ProcRoot, "2")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "cmdline"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "status"), []byte("Name:\tkthreadd\nState:\tS\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if cmdline, err, _ := GetProcess(2).Cmdline(); err != nil || !reflect.DeepEqual(cmdline, []string{"[kthreadd]"}) {
+		t.Errorf("Expected the bracketed name of the kernel thread, got %q (%v)", cmdline, err)
+	}
+}
+
+func TestEnviron(t *testing.T) {
+	cmd := exec.Command(test.GetTestCasePath())
+	cmd.Env = []string{"LD_PRELOAD=/tmp/inject.so", "OPTS=a=b==c", "EMPTY="}
+	if err := test.StartAndWaitForInitialization(cmd); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	env, err, softerrors := GetProcess(cmd.Process.Pid).Environ()
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"LD_PRELOAD": "/tmp/inject.so", "OPTS": "a=b==c", "EMPTY": ""}
+	if !reflect.DeepEqual(env, expected) {
+		t.Errorf("Expected the environment %q, got %q", expected, env)
+	}
+
+	cmd.Process.Kill()
+	cmd.Wait()
+	if _, err, _ := GetProcess(cmd.Process.Pid).Environ(); err == nil || errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("Expected an error other than a permission error for a dead process, got %v", err)
+	}
+
+	// The environment of the processes of other users can only be read by root.
+	if os.Geteuid() == 0 {
+		return
+	}
+	_, err, _ = GetProcess(1).Environ()
+	var perr *PermissionError
+	if !errors.As(err, &perr) || perr.Pid != 1 || !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("Expected a permission error reading the environment of init, got %v", err)
+	}
+}
+
+func FuzzParseProcStatus(f *testing.F) {
+	if data, err := ioutil.ReadFile(filepath.Join("testdata", "status")); err == nil {
+		f.Add(data)
+	}
+	f.Add([]byte("Name:\tcat\nPid:\t42\nPPid:\t1\nUid:\t1000\t1000\t1000\t1000\n"))
+	f.Add([]byte("Name:\nPid:\tx\n:\n\n"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		lpi := linuxProcessInfo{}
+		ParseProcStatus(data, &lpi)
+		raw := map[string]string{}
+		ParseProcStatus(data, raw)
+	})
+}
+
+// findSnapshot returns the snapshot of pid in snapshots.
+func findSnapshot(snapshots []ProcessSnapshot, pid int) (ProcessSnapshot, bool) {
+	for _, s := range snapshots {
+		if s.Pid == pid {
+			return s, true
+		}
+	}
+	return ProcessSnapshot{}, false
+}
+
+// takeSnapshot takes a snapshot of every process, failing the test on a hard error.
+func takeSnapshot(t *testing.T) []ProcessSnapshot {
+	snapshots, harderror, softerrors := SnapshotAll()
+	test.PrintSoftErrors(softerrors)
+	if harderror != nil {
+		t.Fatal(harderror)
+	}
+	return snapshots
+}
+
+func TestSnapshotDiff(t *testing.T) {
+	before := takeSnapshot(t)
+	cmd, err := test.LaunchTestCaseAndWaitForInitialization()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+	pid := cmd.Process.Pid
+
+	running := takeSnapshot(t)
+	if !sort.SliceIsSorted(running, func(i, j int) bool { return running[i].Pid < running[j].Pid }) {
+		t.Error("The snapshots aren't sorted by pid")
+	}
+	started, ok := findSnapshot(Diff(before, running).Started, pid)
+	if !ok {
+		t.Fatalf("The test case %d didn't start between the snapshots", pid)
+	}
+	path, err := filepath.EvalSymlinks(test.GetTestCasePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if started.Name != path || started.Executable != path || len(started.Cmdline) != 1 ||
+		started.UserId != os.Getuid() || started.ParentProcessId != os.Getpid() {
+		t.Errorf("Unexpected snapshot of the test case %+v", started)
+	}
+	if age := time.Since(started.StartTime); age < -time.Second || age > 5*time.Second {
+		t.Errorf("The test case started at %v, %v ago", started.StartTime, age)
+	}
+
+	data, err := json.Marshal(started)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded ProcessSnapshot
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Pid != pid || decoded.Name != started.Name || !decoded.StartTime.Equal(started.StartTime) {
+		t.Errorf("The JSON %s decodes to %+v", data, decoded)
+	}
+
+	// The secrets in the arguments are redacted, unless redaction is disabled.
+	secret := ProcessSnapshot{Pid: 42, Cmdline: []string{"db", "--password=hunter2", "--token", "t0k3n", "-v"}}
+	data, err = json.Marshal(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"cmdline":["db","--password=[REDACTED]","--token","[REDACTED]","-v"]`) ||
+		secret.Cmdline[1] != "--password=hunter2" {
+		t.Errorf("Unexpected JSON %s of the arguments %q", data, secret.Cmdline)
+	}
+	secret.Redactor = common.NoRedaction
+	if data, err := json.Marshal(secret); err != nil || !strings.Contains(string(data), "hunter2") {
+		t.Errorf("Expected the secrets without redaction, got %s, %v", data, err)
+	}
+
+	// The start time of a process is the same in every snapshot.
+	again, _ := findSnapshot(takeSnapshot(t), pid)
+	if !again.StartTime.Equal(started.StartTime) {
+		t.Errorf("The test case started at %v, and at %v in a later snapshot", started.StartTime, again.StartTime)
+	}
+	if info, err, _ := processInfo(pid, InfoOptions{}); err != nil || !info.StartTime.Equal(started.StartTime) {
+		t.Errorf("The test case started at %v, and at %v in its info (%v)", started.StartTime, info.StartTime, err)
+	}
+
+	cmd.Process.Kill()
+	cmd.Wait()
+	diff := Diff(running, takeSnapshot(t))
+	if _, ok := findSnapshot(diff.Exited, pid); !ok {
+		t.Errorf("The test case %d didn't exit between the snapshots", pid)
+	}
+	if _, ok := findSnapshot(diff.Started, pid); ok {
+		t.Errorf("The test case %d started again", pid)
+	}
+}
+
+func TestSnapshotDiffExec(t *testing.T) {
+	second, err := test.CopyTestCase(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd, err := test.LaunchTestCaseAndWaitForInitialization("--exec", second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { cmd.Process.Kill(); cmd.Wait() }()
+	pid := cmd.Process.Pid
+
+	before := takeSnapshot(t)
+	cmd.Process.Signal(syscall.SIGUSR2)
+	var after []ProcessSnapshot
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		after = takeSnapshot(t)
+		if s, _ := findSnapshot(after, pid); s.Executable == second {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	diff := Diff(before, after)
+	var replaced *ReplacedProcess
+	for i := range diff.Replaced {
+		if diff.Replaced[i].Current.Pid == pid {
+			replaced = &diff.Replaced[i]
+		}
+	}
+	if replaced == nil {
+		t.Fatalf("The test case %d didn't execute %s between the snapshots", pid, second)
+	}
+	if replaced.Current.Name != second || !replaced.Current.StartTime.Equal(replaced.Previous.StartTime) {
+		t.Errorf("Unexpected replacement %+v", *replaced)
+	}
+}
+
+// A pid reused by another process is replaced, and the executables that can't be read aren't compared.
+func TestDiffReplaced(t *testing.T) {
+	boot := time.Unix(1600000000, 0)
+	prev := []ProcessSnapshot{
+		{Pid: 1, Name: "/sbin/init", StartTime: boot, Executable: "/sbin/init"},
+		{Pid: 10, Name: "/bin/a", StartTime: boot.Add(time.Second), Executable: "/bin/a"},
+		{Pid: 20, Name: "/bin/zombie", StartTime: boot.Add(time.Second), Executable: "/bin/zombie"},
+		{Pid: 30, Name: "/bin/gone", StartTime: boot.Add(time.Second), Executable: "/bin/gone"},
+	}
+	cur := []ProcessSnapshot{
+		{Pid: 1, Name: "/sbin/init", StartTime: boot, Executable: "/sbin/init"},
+		{Pid: 10, Name: "/bin/b", StartTime: boot.Add(time.Minute), Executable: "/bin/b"},
+		{Pid: 20, Name: "/bin/zombie", StartTime: boot.Add(time.Second)},
+		{Pid: 40, Name: "/bin/new", StartTime: boot.Add(time.Minute), Executable: "/bin/new"},
+	}
+	diff := Diff(prev, cur)
+	if len(diff.Replaced) != 1 || diff.Replaced[0].Previous.Name != "/bin/a" || diff.Replaced[0].Current.Name != "/bin/b" {
+		t.Errorf("Unexpected replacements %+v", diff.Replaced)
+	}
+	if len(diff.Started) != 1 || diff.Started[0].Pid != 40 || len(diff.Exited) != 1 || diff.Exited[0].Pid != 30 {
+		t.Errorf("Unexpected diff %+v", diff)
+	}
+
+	data, err := json.Marshal(Diff(nil, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"started":[],"exited":[],"replaced":[]}` {
+		t.Errorf("Unexpected JSON of an empty diff %s", data)
+	}
+}
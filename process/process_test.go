@@ -1,9 +1,16 @@
 package process
 
 import (
+	"context"
+	"debug/elf"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"os"
+	"reflect"
 	"regexp"
 	"testing"
+	"time"
 
 	"github.com/polyverse/masche/test"
 )
@@ -68,6 +75,10 @@ func TestOpenByName(t *testing.T) {
 	for _, proc := range procs {
 		name, err, softerrors := proc.Name()
 		test.PrintSoftErrors(softerrors)
+		// The test cases of other tests may exit after being opened.
+		if errors.Is(err, ErrProcessExited) {
+			continue
+		}
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -79,17 +90,302 @@ func TestOpenByName(t *testing.T) {
 }
 
 func TestProcessInfo(t *testing.T) {
-	cmd, err := test.LaunchTestCase()
+	cmd, err := test.LaunchTestCaseAndWaitForInitialization()
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer cmd.Process.Kill()
 
 	pid := int(cmd.Process.Pid)
-	procInfo, err := ProcessInfo(pid)
+	procInfo, err := GetProcessInfo(pid)
 	if err != nil {
 		t.Fatalf("Error when calling ProcInfo: %v", err)
 	}
 
-	fmt.Printf("ProcessInfo: %+v\n", procInfo)
+	fmt.Printf("ProcessInfo: %+v\n", *procInfo)
+	info := *procInfo
+	if info.GetId() != pid || info.GetParentProcessId() != os.Getpid() {
+		t.Errorf("Expected the info of process %d, child of %d, got %+v", pid, os.Getpid(), info)
+	}
+	if info.GetExecutable() != test.GetTestCasePath() {
+		t.Errorf("Expected the executable %s, got %s", test.GetTestCasePath(), info.GetExecutable())
+	}
+}
+
+func TestAccessLevel(t *testing.T) {
+	cmd, err := test.LaunchTestCase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	pid := int(cmd.Process.Pid)
+	proc, err, softerrors := OpenFromPid(pid)
+	defer proc.Close()
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	level, err, softerrors := proc.AccessLevel()
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if level != FullAccess {
+		t.Error("Expected access level", FullAccess, "and got", level)
+	}
+}
+
+func TestWaitForExit(t *testing.T) {
+	cmd, err := test.LaunchTestCase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	proc, err, softerrors := OpenFromPid(cmd.Process.Pid)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proc.Close()
+
+	// The process is running, so this must time out.
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err, softerrors = proc.WaitForExit(ctx)
+	test.PrintSoftErrors(softerrors)
+	if err != context.DeadlineExceeded {
+		t.Fatal("Expected the wait to time out and got", err)
+	}
+
+	done := make(chan error)
+	go func() {
+		_, err, softerrors := proc.WaitForExit(context.Background())
+		test.PrintSoftErrors(softerrors)
+		done <- err
+	}()
+
+	cmd.Process.Kill()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("WaitForExit didn't return after the process was killed")
+	}
+}
+
+func TestCachedProcess(t *testing.T) {
+	cmd, err := test.LaunchTestCase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	proc, err, softerrors := OpenFromPid(cmd.Process.Pid)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached, err, softerrors := NewCachedProcess(proc, 0)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cached.Close()
+
+	info, err, softerrors := cached.Info()
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.GetId() != cmd.Process.Pid {
+		t.Error("Got the info of process", info.GetId())
+	}
+
+	cmd.Process.Kill()
+	cmd.Wait()
+	if _, err, _ := cached.Info(); err == nil {
+		t.Error("Got the info of a process that exited")
+	}
+}
+
+func benchmarkInfo(b *testing.B, info func() (ProcessInfo, error)) {
+	for i := 0; i < b.N; i++ {
+		if _, err := info(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkProcessInfo(b *testing.B) {
+	benchmarkInfo(b, func() (ProcessInfo, error) {
+		info, err := GetProcessInfo(os.Getpid())
+		return *info, err
+	})
+}
+
+func BenchmarkCachedProcessInfo(b *testing.B) {
+	proc, err, _ := OpenFromPid(os.Getpid())
+	if err != nil {
+		b.Fatal(err)
+	}
+	cached, err, _ := NewCachedProcess(proc, time.Second)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer cached.Close()
+
+	benchmarkInfo(b, func() (ProcessInfo, error) {
+		info, err, _ := cached.Info()
+		return info, err
+	})
+}
+
+// unsupportedProcess is a process of a platform where its arguments can't be read.
+type unsupportedProcess struct {
+	Process
+	pid  int
+	name string
+}
+
+func (p unsupportedProcess) Pid() int {
+	return p.pid
+}
+
+func (p unsupportedProcess) Name() (string, error, []error) {
+	return p.name, nil, nil
+}
+
+func (p unsupportedProcess) Cmdline() ([]string, error, []error) {
+	return nil, nil, []error{notImplemented("Reading the command line of processes")}
+}
+
+func (p unsupportedProcess) Close() (error, []error) {
+	return nil, nil
+}
+
+// Matching the arguments where they can't be read matches the names only, and reports it once.
+func TestMatchProcessNotImplemented(t *testing.T) {
+	procs := []Process{
+		unsupportedProcess{pid: 1, name: "/usr/bin/python"},
+		unsupportedProcess{pid: 2, name: "/usr/bin/perl"},
+		unsupportedProcess{pid: 3, name: "/usr/bin/python"},
+	}
+	// matchProcess unsets Cmdline in the options once the arguments turn out not to be readable.
+	opts := MatchOptions{Name: true, Cmdline: true}
+	var matched []int
+	var softerrors []error
+	for _, p := range procs {
+		match, serrs := matchProcess(p, regexp.MustCompile("python"), &opts)
+		softerrors = append(softerrors, serrs...)
+		if match {
+			matched = append(matched, p.Pid())
+		}
+	}
+	if !reflect.DeepEqual(matched, []int{1, 3}) {
+		t.Errorf("Expected the processes named python to match, got %v", matched)
+	}
+	if len(softerrors) != 1 || !errors.Is(softerrors[0], ErrNotImplemented) {
+		t.Errorf("Expected a single ErrNotImplemented, got %v", softerrors)
+	}
+
+	opts = MatchOptions{Cmdline: true}
+	for _, p := range procs {
+		if match, _ := matchProcess(p, regexp.MustCompile("script.py"), &opts); match {
+			t.Errorf("Expected no process to match arguments that can't be read, got %d", p.Pid())
+		}
+	}
+}
+
+func TestProcessTree(t *testing.T) {
+	cmd, err := test.LaunchTestCase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	self, err, softerrors := OpenFromPid(os.Getpid())
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer self.Close()
+
+	children, err, softerrors := Children(self)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseAll(children)
+	var child Process
+	for _, c := range children {
+		if c.Pid() == cmd.Process.Pid {
+			child = c
+		}
+	}
+	if child == nil {
+		t.Fatalf("The test case %d isn't a child of the test", cmd.Process.Pid)
+	}
+
+	parent, err, softerrors := Parent(child)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer parent.Close()
+	if parent.Pid() != os.Getpid() {
+		t.Errorf("Expected the test %d to be the parent, got %d", os.Getpid(), parent.Pid())
+	}
+
+	tree, err, softerrors := ProcessTree(self)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, node := range tree.Children {
+		found = found || node.Pid == cmd.Process.Pid && node.Command != ""
+	}
+	if tree.Pid != os.Getpid() || !found {
+		t.Errorf("Expected the test case in the tree of the test, got %+v", tree)
+	}
+	if _, err := json.Marshal(tree); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCheckArch(t *testing.T) {
+	arm := TargetOf(ArchARM)
+	if arm.PointerSize != 4 || arm.BigEndian || arm.String() != "arm (32 bits, little endian)" {
+		t.Errorf("Unexpected target %+v", arm)
+	}
+	if target := ElfTarget(elf.EM_PPC64, elf.ELFCLASS64, elf.ELFDATA2MSB); target != (TargetArch{Arch: ArchUnknown,
+		PointerSize: 8, BigEndian: true}) || target.Pointer([]byte{0, 0, 0, 0, 0, 0, 1, 2}) != 0x102 {
+		t.Errorf("Unexpected target of a big endian core %+v", target)
+	}
+
+	host := HostTarget()
+	onlyHost := func(t TargetArch) bool { return t == host }
+	if err := CheckArch(42, "The feature", host, onlyHost); err != nil {
+		t.Error(err)
+	}
+	foreign := TargetArch{Arch: ArchUnknown, PointerSize: 2}
+	var archErr *ArchError
+	if err := CheckArch(42, "The feature", foreign, onlyHost); !errors.Is(err, ErrArchMismatch) ||
+		!errors.As(err, &archErr) || archErr.Pid != 42 || archErr.Target != foreign || archErr.Scanner != host {
+		t.Errorf("Expected a mismatch, got %v", err)
+	}
+	never := func(TargetArch) bool { return false }
+	if err := CheckArch(42, "The feature", foreign, never); !errors.Is(err, ErrUnsupportedArch) {
+		t.Errorf("Expected an unsupported architecture, got %v", err)
+	}
+	if err := CheckArch(42, "The feature", TargetArch{}, func(TargetArch) bool { return true }); !errors.Is(err,
+		ErrUnsupportedArch) {
+		t.Errorf("Expected an unknown architecture to be unsupported, got %v", err)
+	}
 }
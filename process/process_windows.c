@@ -1,16 +1,22 @@
 #include "process.h"
 #include "process_windows.h"
 #include <psapi.h>
+#include <tlhelp32.h>
 #include <tchar.h>
 #include <string.h>
 
 response_t *open_process_handle(pid_tt pid, process_handle_t *handle) {
+    return open_process_handle_with_access(pid, PROCESS_QUERY_INFORMATION |
+            PROCESS_VM_READ |
+            SYNCHRONIZE,
+            handle);
+}
+
+response_t *open_process_handle_with_access(pid_tt pid, DWORD access,
+        process_handle_t *handle) {
     response_t *res = response_create();
 
-    *handle = (uintptr_t) OpenProcess(PROCESS_QUERY_INFORMATION |
-            PROCESS_VM_READ,
-            FALSE,
-            pid);
+    *handle = (uintptr_t) OpenProcess(access, FALSE, pid);
 
     if (*handle == 0) {
         res->fatal_error = error_create(GetLastError());
@@ -67,14 +73,22 @@ response_t *GetProcessName(process_handle_t hndl, char **name) {
     response_t *res = response_create();
     HMODULE hMod;
     DWORD cbNeeded;
+    TCHAR buf[MAX_PATH + 1];
     // The first module is the executable.
     BOOL success = EnumProcessModules( (HANDLE) hndl, &hMod, sizeof(hMod), &cbNeeded);
     if (!success) {
-        res->fatal_error = error_create(GetLastError());
+        // Handles opened with PROCESS_QUERY_LIMITED_INFORMATION, the only
+        // rights protected processes grant, can't list the modules.
+        DWORD size = MAX_PATH;
+        if (!QueryFullProcessImageName((HANDLE) hndl, 0, buf, &size)) {
+            res->fatal_error = error_create(GetLastError());
+            return res;
+        }
+        buf[MAX_PATH] = '\0';
+        *name = (char *) _tcsdup(buf);
         return res;
     }
 
-    TCHAR buf[MAX_PATH + 1];
     
     DWORD len = GetModuleFileNameEx((HANDLE) hndl, hMod, buf, sizeof(buf) / sizeof(TCHAR)); 
     if (len == 0) {
@@ -86,3 +100,159 @@ response_t *GetProcessName(process_handle_t hndl, char **name) {
     *name = (char *) _tcsdup(buf);
     return res;
 }
+
+response_t *wait_for_process(process_handle_t hndl, DWORD timeout_ms,
+        BOOL *exited, DWORD *exit_code) {
+    response_t *res = response_create();
+    *exited = FALSE;
+
+    DWORD result = WaitForSingleObject((HANDLE) hndl, timeout_ms);
+    if (result == WAIT_FAILED) {
+        res->fatal_error = error_create(GetLastError());
+        return res;
+    }
+    if (result == WAIT_TIMEOUT) {
+        return res;
+    }
+
+    *exited = TRUE;
+    if (!GetExitCodeProcess((HANDLE) hndl, exit_code)) {
+        res->fatal_error = error_create(GetLastError());
+    }
+    return res;
+}
+
+response_t *get_process_threads(pid_tt pid, DWORD **tids, DWORD *length) {
+    response_t *res = response_create();
+    DWORD capacity = 16;
+    *tids = calloc(capacity, sizeof **tids);
+    *length = 0;
+
+    // The snapshot has the threads of every process, it can't be filtered.
+    HANDLE snapshot = CreateToolhelp32Snapshot(TH32CS_SNAPTHREAD, 0);
+    if (snapshot == INVALID_HANDLE_VALUE) {
+        res->fatal_error = error_create(GetLastError());
+        return res;
+    }
+
+    THREADENTRY32 entry;
+    entry.dwSize = sizeof(entry);
+    BOOL found = Thread32First(snapshot, &entry);
+    while (found) {
+        if (entry.th32OwnerProcessID == pid) {
+            if (*length == capacity) {
+                capacity *= 2;
+                *tids = realloc(*tids, capacity * sizeof **tids);
+            }
+            (*tids)[(*length)++] = entry.th32ThreadID;
+        }
+        found = Thread32Next(snapshot, &entry);
+    }
+    if (GetLastError() != ERROR_NO_MORE_FILES) {
+        res->fatal_error = error_create(GetLastError());
+    }
+
+    CloseHandle(snapshot);
+    return res;
+}
+
+response_t *snapshot_processes(process_handle_t *snapshot) {
+    response_t *res = response_create();
+
+    HANDLE handle = CreateToolhelp32Snapshot(TH32CS_SNAPPROCESS, 0);
+    if (handle == INVALID_HANDLE_VALUE) {
+        res->fatal_error = error_create(GetLastError());
+        *snapshot = 0;
+        return res;
+    }
+    *snapshot = (process_handle_t) handle;
+    return res;
+}
+
+response_t *next_process(process_handle_t snapshot, BOOL first, pid_tt *pid,
+        BOOL *found) {
+    response_t *res = response_create();
+
+    PROCESSENTRY32 entry;
+    entry.dwSize = sizeof(entry);
+    if (first) {
+        *found = Process32First((HANDLE) snapshot, &entry);
+    } else {
+        *found = Process32Next((HANDLE) snapshot, &entry);
+    }
+    if (*found) {
+        *pid = entry.th32ProcessID;
+    } else if (GetLastError() != ERROR_NO_MORE_FILES) {
+        res->fatal_error = error_create(GetLastError());
+    }
+    return res;
+}
+
+typedef LONG (NTAPI *suspend_resume_t)(HANDLE);
+typedef ULONG (NTAPI *status_to_error_t)(LONG);
+
+response_t *suspend_process(pid_tt pid, BOOL resume) {
+    response_t *res = response_create();
+
+    // They are exported by ntdll but not declared by the SDK headers.
+    HMODULE ntdll = GetModuleHandleA("ntdll.dll");
+    suspend_resume_t call = NULL;
+    status_to_error_t status_to_error = NULL;
+    if (ntdll != NULL) {
+        call = (suspend_resume_t) GetProcAddress(ntdll,
+                resume ? "NtResumeProcess" : "NtSuspendProcess");
+        status_to_error = (status_to_error_t) GetProcAddress(ntdll,
+                "RtlNtStatusToDosError");
+    }
+    if (call == NULL || status_to_error == NULL) {
+        res->fatal_error = error_create(GetLastError());
+        return res;
+    }
+
+    // The handles opened by open_process_handle can't suspend the process.
+    HANDLE hndl = OpenProcess(PROCESS_SUSPEND_RESUME, FALSE, pid);
+    if (hndl == NULL) {
+        res->fatal_error = error_create(GetLastError());
+        return res;
+    }
+    LONG status = call(hndl);
+    if (status < 0) {
+        res->fatal_error = error_create(status_to_error(status));
+    }
+    CloseHandle(hndl);
+    return res;
+}
+
+typedef BOOL (WINAPI *is_wow64_process2_t)(HANDLE, USHORT *, USHORT *);
+
+response_t *get_process_machine(process_handle_t hndl, USHORT *machine) {
+    response_t *res = response_create();
+
+    // It's only exported since Windows 10 1511.
+    HMODULE kernel32 = GetModuleHandleA("kernel32.dll");
+    is_wow64_process2_t is_wow64_process2 = NULL;
+    if (kernel32 != NULL) {
+        is_wow64_process2 = (is_wow64_process2_t) GetProcAddress(kernel32,
+                "IsWow64Process2");
+    }
+    if (is_wow64_process2 != NULL) {
+        USHORT process_machine, native_machine;
+        if (!is_wow64_process2((HANDLE) hndl, &process_machine,
+                    &native_machine)) {
+            res->fatal_error = error_create(GetLastError());
+            return res;
+        }
+        // The machine of the processes that aren't emulated is unknown.
+        *machine = process_machine == IMAGE_FILE_MACHINE_UNKNOWN ?
+            native_machine : process_machine;
+        return res;
+    }
+
+    BOOL wow64 = FALSE;
+    if (!IsWow64Process((HANDLE) hndl, &wow64)) {
+        res->fatal_error = error_create(GetLastError());
+        return res;
+    }
+    *machine = wow64 ? IMAGE_FILE_MACHINE_I386 : IMAGE_FILE_MACHINE_UNKNOWN;
+    return res;
+}
@@ -2,17 +2,24 @@ package process
 
 // #cgo CFLAGS: -std=c99
 // #cgo CFLAGS: -DPSAPI_VERSION=1
+// #cgo CFLAGS: -D_WIN32_WINNT=0x0600
 // #cgo LDFLAGS: -lpsapi
 // #include "process.h"
 // #include "process_windows.h"
 import "C"
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"os"
 	"reflect"
+	"sort"
 	"syscall"
+	"time"
 	"unsafe"
 
+	"github.com/polyverse/masche/common"
 	"github.com/polyverse/masche/cresponse"
 )
 
@@ -29,6 +36,197 @@ func (p process) Name() (name string, harderror error, softerrors []error) {
 	return
 }
 
+func (p process) Threads() (tids []int, harderror error, softerrors []error) {
+	return processThreads(p.Pid())
+}
+
+// processThreads lists the threads of the process pid, which doesn't need to be open.
+func processThreads(pid int) (tids []int, harderror error, softerrors []error) {
+	var ctids *C.DWORD
+	var length C.DWORD
+	r := C.get_process_threads(C.pid_tt(pid), &ctids, &length)
+	defer C.free(unsafe.Pointer(ctids))
+	harderror, softerrors = cresponse.GetResponsesErrors(unsafe.Pointer(r))
+	C.response_free(r)
+	if harderror != nil {
+		return nil, harderror, softerrors
+	}
+
+	tids = make([]int, 0, length)
+	// We use this to access C arrays without doing manual pointer arithmetic.
+	ctidsSlice := *(*[]C.DWORD)(unsafe.Pointer(
+		&reflect.SliceHeader{
+			Data: uintptr(unsafe.Pointer(ctids)),
+			Len:  int(length),
+			Cap:  int(length)}))
+	for _, tid := range ctidsSlice {
+		tids = append(tids, int(tid))
+	}
+	sort.Ints(tids)
+	return tids, nil, softerrors
+}
+
+const exitPollInterval = 100 * time.Millisecond
+
+func (p process) WaitForExit(ctx context.Context) (status ExitStatus, harderror error, softerrors []error) {
+	for {
+		var exited C.BOOL
+		var code C.DWORD
+		r := C.wait_for_process(p.hndl, C.DWORD(exitPollInterval/time.Millisecond), &exited, &code)
+		harderror, softerrors = cresponse.GetResponsesErrors(unsafe.Pointer(r))
+		C.response_free(r)
+		if harderror != nil {
+			return status, harderror, softerrors
+		}
+		if exited != 0 {
+			return ExitStatus{Code: int(code), CodeKnown: true}, nil, softerrors
+		}
+
+		select {
+		case <-ctx.Done():
+			return status, ctx.Err(), softerrors
+		default:
+		}
+	}
+}
+
+func (p process) Signal(sig syscall.Signal) (harderror error, softerrors []error) {
+	return processSignal(p.Pid(), sig)
+}
+
+func (p process) Suspend() (harderror error, softerrors []error) {
+	return suspendProcess(p.Pid(), false)
+}
+
+func (p process) Resume() (harderror error, softerrors []error) {
+	return suspendProcess(p.Pid(), true)
+}
+
+// accessMasks are the access masks of the Rights.
+var accessMasks = map[Rights]C.DWORD{
+	RightsRead:  C.PROCESS_QUERY_INFORMATION | C.PROCESS_VM_READ | C.SYNCHRONIZE,
+	RightsQuery: C.PROCESS_QUERY_LIMITED_INFORMATION | C.SYNCHRONIZE,
+	RightsFull:  C.PROCESS_ALL_ACCESS,
+}
+
+// openFromPidWithRights opens the process pid with the given rights, or with the fewer ones it grants if degrade is
+// set.
+func openFromPidWithRights(pid int, rights Rights, degrade bool) (p Process, harderror error, softerrors []error) {
+	mask, ok := accessMasks[rights]
+	if !ok {
+		return nil, fmt.Errorf("Unable to open process %d with unknown rights %d", pid, rights), nil
+	}
+	result := process{pid: C.pid_tt(pid), rights: rights}
+	resp := C.open_process_handle_with_access(C.pid_tt(pid), mask, &result.hndl)
+	harderror, softerrors = cresponse.GetResponsesErrors(unsafe.Pointer(resp))
+	C.response_free(resp)
+	if harderror == nil {
+		return result, nil, softerrors
+	}
+	resp = C.close_process_handle(result.hndl)
+	C.response_free(resp)
+
+	lesser, ok := rights.lesser()
+	if !degrade || !ok || !errors.Is(harderror, ErrPermissionDenied) {
+		return nil, harderror, softerrors
+	}
+	p, err, softs := openFromPidWithRights(pid, lesser, degrade)
+	softerrors = append(softerrors, softs...)
+	if err != nil {
+		return nil, err, softerrors
+	}
+	return p, nil, append(softerrors, &common.LocatedError{Pid: pid, Err: fmt.Errorf(
+		"Process %d was opened with %v, as it denied %v (%w)", pid, p.(process).rights, rights, harderror)})
+}
+
+// processSignal fails, as Windows has no signals.
+func processSignal(pid int, sig syscall.Signal) (harderror error, softerrors []error) {
+	return fmt.Errorf("Unable to send %v to process %d: %w", sig, pid, notImplemented("Sending signals")), nil
+}
+
+// suspendProcess suspends the process pid, which doesn't need to be open, or resumes it.
+func suspendProcess(pid int, resume bool) (harderror error, softerrors []error) {
+	if !resume && pid == os.Getpid() {
+		return fmt.Errorf("Process %d can't suspend itself", pid), nil
+	}
+	cresume := C.BOOL(0)
+	if resume {
+		cresume = 1
+	}
+	r := C.suspend_process(C.pid_tt(pid), cresume)
+	defer C.response_free(r)
+	return cresponse.GetResponsesErrors(unsafe.Pointer(r))
+}
+
+// identity tells if a process is still the same one it was when the identity was created, by keeping a handle to
+// it open.
+type identity struct {
+	proc process
+}
+
+func newIdentity(pid int) (*identity, error) {
+	proc, harderror, _ := openFromPid(pid)
+	if harderror != nil {
+		return nil, harderror
+	}
+	return &identity{proc: proc.(process)}, nil
+}
+
+func (id *identity) alive() (bool, error) {
+	return id.proc.IsAlive()
+}
+
+// Machines of the IMAGE_FILE_MACHINE_* constants.
+const (
+	imageFileMachineUnknown = 0
+	imageFileMachineI386    = 0x014c
+	imageFileMachineARMNT   = 0x01c4
+	imageFileMachineAMD64   = 0x8664
+	imageFileMachineARM64   = 0xaa64
+)
+
+func (p process) Architecture() (arch Arch, harderror error, softerrors []error) {
+	var machine C.USHORT
+	r := C.get_process_machine(p.hndl, &machine)
+	harderror, softerrors = cresponse.GetResponsesErrors(unsafe.Pointer(r))
+	C.response_free(r)
+	if harderror != nil {
+		return ArchUnknown, harderror, softerrors
+	}
+
+	switch machine {
+	case imageFileMachineUnknown:
+		return hostArch(), nil, softerrors
+	case imageFileMachineI386:
+		return ArchX86, nil, softerrors
+	case imageFileMachineARMNT:
+		return ArchARM, nil, softerrors
+	case imageFileMachineAMD64:
+		return ArchX86_64, nil, softerrors
+	case imageFileMachineARM64:
+		return ArchARM64, nil, softerrors
+	}
+	return ArchUnknown, nil, softerrors
+}
+
+func (p process) IsKernelThread() (kernel bool, harderror error) {
+	return false, nil
+}
+
+// IsAlive waits for the process with a zero timeout. The open handle keeps the pid from being reused.
+func (p process) IsAlive() (alive bool, harderror error) {
+	var exited C.BOOL
+	var code C.DWORD
+	r := C.wait_for_process(p.hndl, 0, &exited, &code)
+	harderror, _ = cresponse.GetResponsesErrors(unsafe.Pointer(r))
+	C.response_free(r)
+	return exited == 0, harderror
+}
+
+func (id *identity) close() {
+	id.proc.Close()
+}
+
 func getAllPids() (pids []int, harderror error, softerrors []error) {
 	r := C.getAllPids()
 	defer C.EnumProcessesResponse_Free(r)
@@ -55,6 +253,43 @@ func getAllPids() (pids []int, harderror error, softerrors []error) {
 	return pids, nil, nil
 }
 
+// walkPids walks the processes of a Toolhelp snapshot one at a time, instead of listing them all first.
+func walkPids(walkFn func(pid int) (keepGoing bool)) (harderror error, softerrors []error) {
+	var snapshot C.process_handle_t
+	r := C.snapshot_processes(&snapshot)
+	harderror, softerrors = cresponse.GetResponsesErrors(unsafe.Pointer(r))
+	C.response_free(r)
+	if harderror != nil {
+		return harderror, softerrors
+	}
+	defer func() {
+		r := C.close_process_handle(snapshot)
+		C.response_free(r)
+	}()
+
+	for first := C.BOOL(C.TRUE); ; first = C.FALSE {
+		var pid C.pid_tt
+		var found C.BOOL
+		r := C.next_process(snapshot, first, &pid, &found)
+		err, serrs := cresponse.GetResponsesErrors(unsafe.Pointer(r))
+		C.response_free(r)
+		softerrors = append(softerrors, serrs...)
+		if err != nil {
+			return err, softerrors
+		}
+		if found == C.FALSE {
+			return nil, softerrors
+		}
+		// pids 0 and 4 are reserved in windows.
+		if pid == 0 || pid == 4 {
+			continue
+		}
+		if !walkFn(int(pid)) {
+			return nil, softerrors
+		}
+	}
+}
+
 type windowsProcess int
 
 func getProcess(pid int) windowsProcess {
@@ -70,10 +305,80 @@ func (p windowsProcess) Name() (name string, harderror error, softerrors []error
 	return name, err, nil
 }
 
+func (p windowsProcess) Cmdline() (args []string, harderror error, softerrors []error) {
+	return nil, nil, []error{notImplemented("Reading the command line of processes")}
+}
+
+func (p windowsProcess) Environ() (env map[string]string, harderror error, softerrors []error) {
+	return nil, nil, []error{notImplemented("Reading the environment of processes")}
+}
+
+func (p windowsProcess) Threads() (tids []int, harderror error, softerrors []error) {
+	return processThreads(p.Pid())
+}
+
 func (p windowsProcess) Close() (harderror error, softerrors []error) {
 	return nil, nil
 }
 
+func (p windowsProcess) AccessLevel() (level AccessLevel, harderror error, softerrors []error) {
+	// wmic and tasklist can still give us the process' metadata when we can't open it.
+	proc, err, softs := openFromPid(p.Pid())
+	if err != nil {
+		return MetadataOnly, nil, append(softs, err)
+	}
+	proc.Close()
+
+	return FullAccess, nil, softs
+}
+
+func (p windowsProcess) IsKernelThread() (kernel bool, harderror error) {
+	return false, nil
+}
+
+// IsAlive opens the process to check it, so the harderror is returned if it can't be opened.
+func (p windowsProcess) IsAlive() (alive bool, harderror error) {
+	proc, harderror, _ := openFromPid(p.Pid())
+	if harderror != nil {
+		return false, harderror
+	}
+	defer proc.Close()
+	return proc.IsAlive()
+}
+
+func (p windowsProcess) Architecture() (arch Arch, harderror error, softerrors []error) {
+	proc, harderror, softerrors := openFromPid(p.Pid())
+	if harderror != nil {
+		return ArchUnknown, harderror, softerrors
+	}
+	defer proc.Close()
+	arch, harderror, softs := proc.Architecture()
+	return arch, harderror, append(softerrors, softs...)
+}
+
+func (p windowsProcess) WaitForExit(ctx context.Context) (status ExitStatus, harderror error, softerrors []error) {
+	proc, harderror, softerrors := openFromPid(p.Pid())
+	if harderror != nil {
+		return status, harderror, softerrors
+	}
+	defer proc.Close()
+
+	status, harderror, softs := proc.WaitForExit(ctx)
+	return status, harderror, append(softerrors, softs...)
+}
+
+func (p windowsProcess) Signal(sig syscall.Signal) (harderror error, softerrors []error) {
+	return processSignal(p.Pid(), sig)
+}
+
+func (p windowsProcess) Suspend() (harderror error, softerrors []error) {
+	return suspendProcess(p.Pid(), false)
+}
+
+func (p windowsProcess) Resume() (harderror error, softerrors []error) {
+	return suspendProcess(p.Pid(), true)
+}
+
 func (p windowsProcess) Handle() uintptr {
 	// https://gist.github.com/castaneai/ed8cc2aaedf9d1eafd68
 	kernel32 := syscall.MustLoadDLL("kernel32.dll")
@@ -10,8 +10,59 @@ typedef struct t_EnumProcessesResponse {
     DWORD length;
 } EnumProcessesResponse;
 
+/**
+ * Opens a handle to the process pid with the given access mask, as
+ * open_process_handle does with the mask that allows reading its memory.
+ **/
+response_t *open_process_handle_with_access(pid_tt pid, DWORD access,
+        process_handle_t *handle);
+
 EnumProcessesResponse *getAllPids();
 void EnumProcessesResponse_Free(EnumProcessesResponse *r);
 response_t *GetProcessName(process_handle_t hndl, char **name);
 
+/**
+ * Waits up to timeout_ms milliseconds for the process to exit. If it does,
+ * exited is set to TRUE and its exit code is stored in exit_code.
+ **/
+response_t *wait_for_process(process_handle_t hndl, DWORD timeout_ms,
+        BOOL *exited, DWORD *exit_code);
+
+/**
+ * Lists the ids of the threads owned by the process pid in a snapshot of the
+ * system's threads. tids is a malloc'ed array of length elements that must be
+ * freed by the caller, even if it's empty.
+ **/
+response_t *get_process_threads(pid_tt pid, DWORD **tids, DWORD *length);
+
+/**
+ * Suspends every thread of the process pid, or resumes them if resume is
+ * TRUE, with NtSuspendProcess and NtResumeProcess, as debuggers do. Each
+ * thread counts its suspensions, so a process suspended twice has to be
+ * resumed twice.
+ **/
+response_t *suspend_process(pid_tt pid, BOOL resume);
+
+/**
+ * Stores the machine the process runs in machine, one of the
+ * IMAGE_FILE_MACHINE_* constants, with IsWow64Process2. Where it isn't
+ * available, only x86 processes emulated on x64 are detected, and
+ * IMAGE_FILE_MACHINE_UNKNOWN is stored for the native ones.
+ **/
+response_t *get_process_machine(process_handle_t hndl, USHORT *machine);
+
+/**
+ * Takes a snapshot of the processes of the system with
+ * CreateToolhelp32Snapshot, to be walked with next_process. The snapshot must
+ * be closed with close_process_handle.
+ **/
+response_t *snapshot_processes(process_handle_t *snapshot);
+
+/**
+ * Stores the pid of the next process of the snapshot in pid, or of the first
+ * one if first is TRUE. found is set to FALSE when there are no more.
+ **/
+response_t *next_process(process_handle_t snapshot, BOOL first, pid_tt *pid,
+        BOOL *found);
+
 #endif /* PROCESS_WINDOWS_H */
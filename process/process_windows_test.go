@@ -0,0 +1,58 @@
+package process
+
+import (
+	"encoding/csv"
+	"errors"
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// csrssPid returns the pid of a csrss.exe, a protected process that only grants the rights to query it.
+func csrssPid(t *testing.T) int {
+	out, err := exec.Command("tasklist", "/FI", "IMAGENAME eq csrss.exe", "/FO", "CSV", "/NH").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(strings.NewReader(string(out))).ReadAll()
+	if err != nil || len(records) == 0 || len(records[0]) < 2 {
+		t.Skipf("No csrss.exe found in %q (%v)", out, err)
+	}
+	pid, err := strconv.Atoi(records[0][1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pid
+}
+
+func TestOpenWithRights(t *testing.T) {
+	pid := csrssPid(t)
+	if _, err, _ := OpenFromPid(pid); !errors.Is(err, ErrPermissionDenied) {
+		t.Skipf("csrss.exe isn't protected from this process (%v)", err)
+	}
+
+	p, err, _ := OpenFromPidWithOptions(pid, OpenOptions{Rights: RightsQuery})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if name, err, _ := p.Name(); err != nil || !strings.HasSuffix(strings.ToLower(name), "csrss.exe") {
+		t.Errorf("Unexpected name %q of csrss.exe (%v)", name, err)
+	}
+	if level, _, _ := p.AccessLevel(); level != NoAccess {
+		t.Errorf("The memory of a process opened to query it has access level %v", level)
+	}
+
+	degraded, err, softerrors := OpenFromPidWithOptions(pid, OpenOptions{Rights: RightsFull, DegradeRights: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer degraded.Close()
+	if len(softerrors) == 0 || !errors.Is(softerrors[len(softerrors)-1], ErrPermissionDenied) {
+		t.Errorf("The rights csrss.exe was opened with weren't reported: %v", softerrors)
+	}
+	if _, err, _ := OpenFromPidWithOptions(pid, OpenOptions{Rights: RightsFull}); err == nil {
+		t.Error("csrss.exe was opened with all the rights")
+	}
+}
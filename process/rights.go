@@ -0,0 +1,40 @@
+package process
+
+// Rights are the access rights a process is opened with on Windows, see OpenOptions.Rights. They go from the most
+// to the fewest: a process that denies some rights may still be opened with fewer.
+type Rights int
+
+const (
+	// RightsRead allows reading the memory of the process and everything about it, which is all masche needs. It's
+	// what OpenFromPid opens processes with.
+	RightsRead Rights = iota
+	// RightsQuery only allows querying the process, as its name, its info, if it's alive and waiting for it to exit.
+	// It's granted for protected processes, like csrss.exe, whose memory can't be read, so their AccessLevel is
+	// NoAccess.
+	RightsQuery
+	// RightsFull allows everything that can be done to the process, as writing its memory.
+	RightsFull
+)
+
+func (r Rights) String() string {
+	switch r {
+	case RightsRead:
+		return "RightsRead"
+	case RightsQuery:
+		return "RightsQuery"
+	case RightsFull:
+		return "RightsFull"
+	}
+	return "Unknown"
+}
+
+// lesser returns the rights to retry with when r is denied, and false if there are no fewer rights.
+func (r Rights) lesser() (Rights, bool) {
+	switch r {
+	case RightsFull:
+		return RightsRead, true
+	case RightsRead:
+		return RightsQuery, true
+	}
+	return r, false
+}
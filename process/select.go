@@ -0,0 +1,543 @@
+package process
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/polyverse/masche/common"
+)
+
+// Selector is a compiled process selection expression, see Select.
+type Selector struct {
+	expr string
+	root node
+}
+
+// Facts are the values of the fields of a process that a Selector can use. Integer and size fields are int64, string
+// fields are string and boolean fields are bool.
+type Facts map[string]interface{}
+
+type fieldType int
+
+const (
+	intField fieldType = iota
+	sizeField
+	stringField
+	boolField
+)
+
+func (t fieldType) String() string {
+	switch t {
+	case intField:
+		return "integer"
+	case sizeField:
+		return "size"
+	case stringField:
+		return "string"
+	case boolField:
+		return "boolean"
+	}
+	return fmt.Sprintf("fieldType(%d)", int(t))
+}
+
+// selectorFields are the fields that can be used in expressions.
+var selectorFields = map[string]fieldType{
+	"pid":           intField,
+	"ppid":          intField,
+	"uid":           intField,
+	"gid":           intField,
+	"threads":       intField,
+	"name":          stringField,
+	"exe":           stringField,
+	"state":         stringField,
+	"rss":           sizeField,
+	"vsz":           sizeField,
+	"kernel_thread": boolField,
+}
+
+// sizeUnits are the suffixes of size literals. All of them are powers of 1024, as in ps(1) and top(1).
+var sizeUnits = map[string]int64{
+	"B": 1, "K": 1 << 10, "KB": 1 << 10, "KiB": 1 << 10, "M": 1 << 20, "MB": 1 << 20, "MiB": 1 << 20,
+	"G": 1 << 30, "GB": 1 << 30, "GiB": 1 << 30, "T": 1 << 40, "TB": 1 << 40, "TiB": 1 << 40,
+}
+
+// Select compiles a process selection expression. The grammar is:
+//
+//	expr       = and { "||" and }
+//	and        = unary { "&&" unary }
+//	unary      = "!" unary | "(" expr ")" | comparison | field
+//	comparison = field op literal
+//	op         = "==" | "!=" | "<" | "<=" | ">" | ">=" | "=~" | "!~"
+//	literal    = string | integer | size | "true" | "false"
+//
+// Strings are double quoted, with backslash escapes as in Go. Sizes are integers followed by a unit: B, K, KB, KiB,
+// M, MB, MiB, G, GB, GiB, T, TB or TiB, all of them powers of 1024. A field alone is only valid for boolean fields.
+//
+// The fields are:
+//
+//	pid, ppid, uid, gid, threads    integers
+//	name, exe, state                strings; name is the command name, exe the resolved executable path
+//	rss, vsz                        sizes of the resident and virtual memory
+//	kernel_thread                   boolean
+//
+// Integers and sizes support every comparison but the regexp ones, and can be compared with each other. Strings
+// support ==, != and the regexp matches =~ and !~. Booleans support == and !=.
+//
+// For example: name =~ "nginx" && uid != 0 && rss > 100MB && !kernel_thread
+//
+// Errors are of type *SyntaxError.
+func Select(expr string) (*Selector, error) {
+	p := &parser{lexer: lexer{input: expr}}
+	p.next()
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != eofToken {
+		return nil, p.unexpected("&&", "||", "end of expression")
+	}
+	return &Selector{expr: expr, root: root}, nil
+}
+
+// String returns the expression the Selector was compiled from.
+func (s *Selector) String() string {
+	return s.expr
+}
+
+// MatchFacts evaluates the Selector with the given facts. Fields missing from the facts make the comparisons that use
+// them false.
+func (s *Selector) MatchFacts(facts Facts) bool {
+	return s.root.eval(facts)
+}
+
+// Match gathers the facts of p and evaluates the Selector with them. The facts that can't be gathered are reported
+// as softerrors and are missing from the evaluation.
+func (s *Selector) Match(p Process) (matches bool, harderror error, softerrors []error) {
+	facts, harderror, softerrors := GatherFacts(p)
+	if harderror != nil {
+		return false, harderror, softerrors
+	}
+	return s.MatchFacts(facts), nil, softerrors
+}
+
+// GatherFacts returns the facts of p used by selectors. Only pid and name are available on every platform.
+func GatherFacts(p Process) (facts Facts, harderror error, softerrors []error) {
+	return common.Result(gatherFacts(p))
+}
+
+// OpenWhere opens all the running processes matched by s, sorted by pid.
+func OpenWhere(s *Selector) (ps []Process, harderror error, softerrors []error) {
+	procs, harderror, softerrors := OpenAll()
+	if harderror != nil {
+		return nil, harderror, softerrors
+	}
+
+	matches := make([]Process, 0)
+	for _, p := range procs {
+		match, err, softs := s.Match(p)
+		softerrors = append(softerrors, softs...)
+		if err != nil {
+			softerrors = append(softerrors, err)
+		}
+
+		if match {
+			matches = append(matches, p)
+		} else {
+			p.Close()
+		}
+	}
+	common.SortSoftErrors(softerrors)
+
+	return matches, nil, softerrors
+}
+
+// SyntaxError is the error returned by Select for invalid expressions.
+type SyntaxError struct {
+	// Position is the position of the offending token in the expression, starting at 1.
+	Position int
+	// Found describes the offending token.
+	Found string
+	// Expected are the tokens that would have been valid instead, if that's the problem.
+	Expected []string
+	// Message describes the problem, if it's not an unexpected token.
+	Message string
+}
+
+func (e *SyntaxError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("position %d: %s", e.Position, e.Message)
+	}
+	return fmt.Sprintf("position %d: expected %s, found %s", e.Position, strings.Join(e.Expected, " or "), e.Found)
+}
+
+type tokenKind int
+
+const (
+	eofToken tokenKind = iota
+	identToken
+	stringToken
+	numberToken
+	opToken
+	andToken
+	orToken
+	notToken
+	lparenToken
+	rparenToken
+)
+
+type token struct {
+	kind tokenKind
+	// text is the token as written, except for strings, which are unquoted.
+	text string
+	// value is the value of numbers, in bytes for sizes.
+	value  int64
+	isSize bool
+	pos    int
+}
+
+func (t token) describe() string {
+	switch t.kind {
+	case eofToken:
+		return "end of expression"
+	case stringToken:
+		return strconv.Quote(t.text)
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+type lexer struct {
+	input string
+	pos   int
+}
+
+func isIdentByte(c byte, first bool) bool {
+	return c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || !first && '0' <= c && c <= '9'
+}
+
+// next returns the next token, or a SyntaxError.
+func (l *lexer) next() (token, error) {
+	for l.pos < len(l.input) && strings.IndexByte(" \t\r\n", l.input[l.pos]) != -1 {
+		l.pos++
+	}
+	start := l.pos
+	if l.pos == len(l.input) {
+		return token{kind: eofToken, pos: start + 1}, nil
+	}
+
+	for _, op := range []string{"&&", "||", "==", "!=", "<=", ">=", "=~", "!~", "<", ">", "!", "(", ")"} {
+		if strings.HasPrefix(l.input[l.pos:], op) {
+			l.pos += len(op)
+			kind := opToken
+			switch op {
+			case "&&":
+				kind = andToken
+			case "||":
+				kind = orToken
+			case "!":
+				kind = notToken
+			case "(":
+				kind = lparenToken
+			case ")":
+				kind = rparenToken
+			}
+			return token{kind: kind, text: op, pos: start + 1}, nil
+		}
+	}
+
+	c := l.input[l.pos]
+	switch {
+	case c == '"':
+		for l.pos++; l.pos < len(l.input) && l.input[l.pos] != '"'; l.pos++ {
+			if l.input[l.pos] == '\\' {
+				l.pos++
+			}
+		}
+		if l.pos >= len(l.input) {
+			return token{}, &SyntaxError{Position: start + 1, Message: "unterminated string"}
+		}
+		l.pos++
+		text, err := strconv.Unquote(l.input[start:l.pos])
+		if err != nil {
+			return token{}, &SyntaxError{Position: start + 1, Message: "invalid string " + l.input[start:l.pos]}
+		}
+		return token{kind: stringToken, text: text, pos: start + 1}, nil
+
+	case '0' <= c && c <= '9':
+		for l.pos < len(l.input) && '0' <= l.input[l.pos] && l.input[l.pos] <= '9' {
+			l.pos++
+		}
+		digits := l.input[start:l.pos]
+		for l.pos < len(l.input) && isIdentByte(l.input[l.pos], false) {
+			l.pos++
+		}
+		text := l.input[start:l.pos]
+
+		value, err := strconv.ParseInt(digits, 10, 64)
+		if err != nil {
+			return token{}, &SyntaxError{Position: start + 1, Message: "invalid number " + text}
+		}
+		t := token{kind: numberToken, text: text, value: value, pos: start + 1}
+		if unit := text[len(digits):]; unit != "" {
+			multiplier, ok := sizeUnits[unit]
+			if !ok {
+				return token{}, &SyntaxError{Position: start + 1, Message: "unknown size unit " + unit}
+			}
+			if value > (1<<63-1)/multiplier {
+				return token{}, &SyntaxError{Position: start + 1, Message: "size too big " + text}
+			}
+			t.value, t.isSize = value*multiplier, true
+		}
+		return t, nil
+
+	case isIdentByte(c, true):
+		for l.pos < len(l.input) && isIdentByte(l.input[l.pos], false) {
+			l.pos++
+		}
+		return token{kind: identToken, text: l.input[start:l.pos], pos: start + 1}, nil
+	}
+
+	return token{}, &SyntaxError{Position: start + 1, Message: fmt.Sprintf("unexpected character %q", c)}
+}
+
+type parser struct {
+	lexer lexer
+	tok   token
+	err   error
+}
+
+// next advances to the next token. Lexing errors are kept and returned by the next parse step.
+func (p *parser) next() {
+	if p.err != nil {
+		return
+	}
+	p.tok, p.err = p.lexer.next()
+}
+
+func (p *parser) unexpected(expected ...string) error {
+	if p.err != nil {
+		return p.err
+	}
+	return &SyntaxError{Position: p.tok.pos, Found: p.tok.describe(), Expected: expected}
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	for err == nil && p.err == nil && p.tok.kind == orToken {
+		p.next()
+		var right node
+		right, err = p.parseAnd()
+		left = orNode{left, right}
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+	return left, err
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseUnary()
+	for err == nil && p.err == nil && p.tok.kind == andToken {
+		p.next()
+		var right node
+		right, err = p.parseUnary()
+		left = andNode{left, right}
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+	return left, err
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+
+	switch p.tok.kind {
+	case notToken:
+		p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{operand}, nil
+
+	case lparenToken:
+		p.next()
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.tok.kind != rparenToken {
+			return nil, p.unexpected("&&", "||", ")")
+		}
+		p.next()
+		return inner, nil
+
+	case identToken:
+		return p.parseComparison()
+	}
+
+	return nil, p.unexpected("field", "!", "(")
+}
+
+func fieldNames() []string {
+	names := make([]string, 0, len(selectorFields))
+	for name := range selectorFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (p *parser) parseComparison() (node, error) {
+	field := p.tok
+	ftype, ok := selectorFields[field.text]
+	if !ok {
+		return nil, &SyntaxError{Position: field.pos, Found: field.describe(), Expected: fieldNames(),
+			Message: fmt.Sprintf("unknown field %s, the fields are %s", field.text, strings.Join(fieldNames(), ", "))}
+	}
+	p.next()
+	if p.err != nil {
+		return nil, p.err
+	}
+
+	if p.tok.kind != opToken {
+		if ftype == boolField {
+			return boolNode{field.text}, nil
+		}
+		return nil, p.unexpected("comparison operator")
+	}
+	op := p.tok
+	p.next()
+	if p.err != nil {
+		return nil, p.err
+	}
+	literal := p.tok
+
+	invalidOp := func() error {
+		return &SyntaxError{Position: op.pos, Message: fmt.Sprintf("operator %s can't be used with %s field %s",
+			op.text, ftype, field.text)}
+	}
+
+	var n node
+	switch ftype {
+	case intField, sizeField:
+		if literal.kind != numberToken {
+			return nil, p.unexpected(ftype.String())
+		}
+		if op.text == "=~" || op.text == "!~" {
+			return nil, invalidOp()
+		}
+		n = intNode{field.text, op.text, literal.value}
+
+	case stringField:
+		if literal.kind != stringToken {
+			return nil, p.unexpected("string")
+		}
+		switch op.text {
+		case "==", "!=":
+			n = stringNode{field.text, op.text, literal.text}
+		case "=~", "!~":
+			r, err := regexp.Compile(literal.text)
+			if err != nil {
+				return nil, &SyntaxError{Position: literal.pos, Message: fmt.Sprintf("invalid regexp (%v)", err)}
+			}
+			n = regexpNode{field.text, op.text == "=~", r}
+		default:
+			return nil, invalidOp()
+		}
+
+	case boolField:
+		if literal.kind != identToken || literal.text != "true" && literal.text != "false" {
+			return nil, p.unexpected("true", "false")
+		}
+		if op.text != "==" && op.text != "!=" {
+			return nil, invalidOp()
+		}
+		n = boolNode{field.text}
+		if (literal.text == "true") != (op.text == "==") {
+			n = notNode{n}
+		}
+	}
+
+	p.next()
+	return n, nil
+}
+
+type node interface {
+	eval(facts Facts) bool
+}
+
+type orNode struct{ left, right node }
+
+func (n orNode) eval(facts Facts) bool { return n.left.eval(facts) || n.right.eval(facts) }
+
+type andNode struct{ left, right node }
+
+func (n andNode) eval(facts Facts) bool { return n.left.eval(facts) && n.right.eval(facts) }
+
+type notNode struct{ operand node }
+
+func (n notNode) eval(facts Facts) bool { return !n.operand.eval(facts) }
+
+type boolNode struct{ field string }
+
+func (n boolNode) eval(facts Facts) bool {
+	value, ok := facts[n.field].(bool)
+	return ok && value
+}
+
+type intNode struct {
+	field string
+	op    string
+	value int64
+}
+
+func (n intNode) eval(facts Facts) bool {
+	value, ok := facts[n.field].(int64)
+	if !ok {
+		return false
+	}
+
+	switch n.op {
+	case "==":
+		return value == n.value
+	case "!=":
+		return value != n.value
+	case "<":
+		return value < n.value
+	case "<=":
+		return value <= n.value
+	case ">":
+		return value > n.value
+	case ">=":
+		return value >= n.value
+	}
+	return false
+}
+
+type stringNode struct {
+	field string
+	op    string
+	value string
+}
+
+func (n stringNode) eval(facts Facts) bool {
+	value, ok := facts[n.field].(string)
+	return ok && (value == n.value) == (n.op == "==")
+}
+
+type regexpNode struct {
+	field   string
+	matches bool
+	r       *regexp.Regexp
+}
+
+func (n regexpNode) eval(facts Facts) bool {
+	value, ok := facts[n.field].(string)
+	return ok && n.r.MatchString(value) == n.matches
+}
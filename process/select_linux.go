@@ -0,0 +1,72 @@
+package process
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+func gatherFacts(p Process) (facts Facts, harderror error, softerrors []error) {
+	info, harderror, softerrors := processInfo(p.Pid(), InfoOptions{IncludeRaw: true})
+	if harderror != nil {
+		return nil, harderror, softerrors
+	}
+
+	facts = Facts{
+		"pid":  int64(info.Id),
+		"ppid": int64(info.ParentProcessId),
+		"uid":  int64(info.UserId),
+		"gid":  int64(info.GroupId),
+		"name": info.Command,
+		"exe":  info.Executable,
+	}
+
+	if state := strings.Fields(info.Raw["State"]); len(state) > 0 {
+		facts["state"] = state[0]
+	}
+	if threads, err := strconv.ParseInt(info.Raw["Threads"], 10, 64); err == nil {
+		facts["threads"] = threads
+	}
+
+	// Kernel threads have no memory of their own, so they have no Vm* keys. Neither have zombies.
+	_, hasMemory := info.Raw["VmSize"]
+	for fact, key := range map[string]string{"rss": "VmRSS", "vsz": "VmSize"} {
+		if value, ok := info.Raw[key]; ok {
+			size, err := parseStatusSize(value)
+			if err != nil {
+				softerrors = append(softerrors, fmt.Errorf("Unable to parse %s of proc %d (%v)", key, p.Pid(), err))
+				continue
+			}
+			facts[fact] = size
+		}
+	}
+
+	// Newer kernels tell it explicitly.
+	if kthread, ok := info.Raw["Kthread"]; ok {
+		facts["kernel_thread"] = kthread == "1"
+	} else {
+		facts["kernel_thread"] = !hasMemory && facts["state"] != "Z"
+	}
+
+	return facts, nil, softerrors
+}
+
+// parseStatusSize parses a size of the status file, like "1234 kB", into bytes.
+func parseStatusSize(value string) (int64, error) {
+	fields := strings.Fields(value)
+	if len(fields) == 0 || len(fields) > 2 {
+		return 0, fmt.Errorf("Invalid size %q", value)
+	}
+
+	size, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if len(fields) == 2 {
+		if fields[1] != "kB" {
+			return 0, fmt.Errorf("Unknown unit in size %q", value)
+		}
+		size *= 1024
+	}
+	return size, nil
+}
@@ -0,0 +1,11 @@
+// +build windows darwin
+
+package process
+
+func gatherFacts(p Process) (facts Facts, harderror error, softerrors []error) {
+	name, harderror, softerrors := p.Name()
+	if harderror != nil {
+		return nil, harderror, softerrors
+	}
+	return Facts{"pid": int64(p.Pid()), "name": name}, nil, softerrors
+}
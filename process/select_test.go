@@ -0,0 +1,161 @@
+package process
+
+import (
+	"reflect"
+	"testing"
+)
+
+var nginxFacts = Facts{
+	"pid":           int64(1234),
+	"ppid":          int64(1),
+	"uid":           int64(33),
+	"gid":           int64(33),
+	"threads":       int64(4),
+	"name":          "nginx",
+	"exe":           "/usr/sbin/nginx",
+	"state":         "S",
+	"rss":           int64(200 << 20),
+	"vsz":           int64(1 << 30),
+	"kernel_thread": false,
+}
+
+func TestSelect(t *testing.T) {
+	kthreadFacts := Facts{"pid": int64(2), "ppid": int64(0), "uid": int64(0), "name": "kthreadd", "exe": "",
+		"kernel_thread": true}
+
+	cases := []struct {
+		expr    string
+		nginx   bool
+		kthread bool
+	}{
+		{`name =~ "nginx" && uid != 0 && rss > 100MB && !kernel_thread`, true, false},
+		{`kernel_thread`, false, true},
+		{`kernel_thread == false`, true, false},
+		{`kernel_thread != false`, false, true},
+		{`name == "nginx" || name == "kthreadd"`, true, true},
+		{`!(name == "nginx" || pid == 2)`, false, false},
+		{`name !~ "^ngi"`, false, true},
+		{`rss >= 200MiB && rss <= 209715200 && vsz == 1G`, true, false},
+		{`rss < 1K`, false, false},
+		{`pid > 1 && ppid < 1`, false, true},
+		{`uid == 0 || uid == 33 && gid == 0`, false, true},
+		{`(uid == 0 || uid == 33) && gid == 33`, true, false},
+		{`threads >= 4 && state == "S"`, true, false},
+		{`exe == ""`, false, true},
+		{`!!kernel_thread`, false, true},
+		{"name =~ \"ng\\\\w+x\"\n&&\tpid==1234", true, false},
+	}
+
+	for _, c := range cases {
+		s, err := Select(c.expr)
+		if err != nil {
+			t.Errorf("%s: %v", c.expr, err)
+			continue
+		}
+		if match := s.MatchFacts(nginxFacts); match != c.nginx {
+			t.Errorf("%s: expected %v for nginx, got %v", c.expr, c.nginx, match)
+		}
+		if match := s.MatchFacts(kthreadFacts); match != c.kthread {
+			t.Errorf("%s: expected %v for the kernel thread, got %v", c.expr, c.kthread, match)
+		}
+		if s.String() != c.expr {
+			t.Errorf("Expected %q, got %q", c.expr, s.String())
+		}
+	}
+}
+
+func TestSelectSyntaxErrors(t *testing.T) {
+	cases := []struct {
+		expr     string
+		expected SyntaxError
+	}{
+		{``, SyntaxError{Position: 1, Found: "end of expression", Expected: []string{"field", "!", "("}}},
+		{`name == "a" &&`, SyntaxError{Position: 15, Found: "end of expression", Expected: []string{"field", "!", "("}}},
+		{`name "a"`, SyntaxError{Position: 6, Found: `"a"`, Expected: []string{"comparison operator"}}},
+		{`pid == "1"`, SyntaxError{Position: 8, Found: `"1"`, Expected: []string{"integer"}}},
+		{`rss > big`, SyntaxError{Position: 7, Found: `"big"`, Expected: []string{"size"}}},
+		{`name == 1`, SyntaxError{Position: 9, Found: `"1"`, Expected: []string{"string"}}},
+		{`kernel_thread == 1`, SyntaxError{Position: 18, Found: `"1"`, Expected: []string{"true", "false"}}},
+		{`(pid == 1`, SyntaxError{Position: 10, Found: "end of expression", Expected: []string{"&&", "||", ")"}}},
+		{`pid == 1)`, SyntaxError{Position: 9, Found: `")"`, Expected: []string{"&&", "||", "end of expression"}}},
+		{`pid == 1 pid == 2`, SyntaxError{Position: 10, Found: `"pid"`,
+			Expected: []string{"&&", "||", "end of expression"}}},
+		{`pid =~ 1`, SyntaxError{Position: 5, Message: "operator =~ can't be used with integer field pid"}},
+		{`name < "a"`, SyntaxError{Position: 6, Message: "operator < can't be used with string field name"}},
+		{`kernel_thread > true`, SyntaxError{Position: 15,
+			Message: "operator > can't be used with boolean field kernel_thread"}},
+		{`name =~ "("`, SyntaxError{Position: 9,
+			Message: "invalid regexp (error parsing regexp: missing closing ): `(`)"}},
+		{`name == "abc`, SyntaxError{Position: 9, Message: "unterminated string"}},
+		{`name == "\q"`, SyntaxError{Position: 9, Message: `invalid string "\q"`}},
+		{`rss > 10XB`, SyntaxError{Position: 7, Message: "unknown size unit XB"}},
+		{`rss > 99999999999999999999`, SyntaxError{Position: 7, Message: "invalid number 99999999999999999999"}},
+		{`rss > 9999999999TB`, SyntaxError{Position: 7, Message: "size too big 9999999999TB"}},
+		{`pid == 1 & uid == 0`, SyntaxError{Position: 10, Message: "unexpected character '&'"}},
+	}
+
+	for _, c := range cases {
+		_, err := Select(c.expr)
+		syntaxErr, ok := err.(*SyntaxError)
+		if !ok {
+			t.Errorf("%s: expected a syntax error, got %v", c.expr, err)
+			continue
+		}
+		if !reflect.DeepEqual(*syntaxErr, c.expected) {
+			t.Errorf("%s: expected %#v, got %#v", c.expr, c.expected, *syntaxErr)
+		}
+	}
+
+	_, err := Select(`owner == "root"`)
+	if syntaxErr, ok := err.(*SyntaxError); !ok || syntaxErr.Position != 1 || len(syntaxErr.Expected) != len(selectorFields) {
+		t.Errorf("Expected an unknown field error listing the fields, got %v", err)
+	}
+}
+
+func TestSelectMissingFacts(t *testing.T) {
+	// Only pid and name are available on every platform, comparisons of other fields are false.
+	facts := Facts{"pid": int64(1), "name": "init"}
+	for expr, expected := range map[string]bool{
+		`uid == 0`:             false,
+		`uid != 0`:             false,
+		`!(uid == 0)`:          true,
+		`kernel_thread`:        false,
+		`pid == 1 && rss < 1G`: false,
+		`pid == 1 || rss < 1G`: true,
+	} {
+		s, err := Select(expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if match := s.MatchFacts(facts); match != expected {
+			t.Errorf("%s: expected %v, got %v", expr, expected, match)
+		}
+	}
+}
+
+func FuzzSelect(f *testing.F) {
+	for _, seed := range []string{
+		`name =~ "nginx" && uid != 0 && rss > 100MB && !kernel_thread`,
+		`!(pid == 1 || ppid >= 2) && exe !~ "^/usr"`,
+		`kernel_thread == true || state == "Z\t"`,
+		`rss > 9999999999TB`,
+		`((`,
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, expr string) {
+		s, err := Select(expr)
+		if err != nil {
+			if _, ok := err.(*SyntaxError); !ok {
+				t.Fatalf("%q: expected a syntax error, got %T %v", expr, err, err)
+			}
+			if err.Error() == "" {
+				t.Fatalf("%q: empty error", expr)
+			}
+			return
+		}
+		s.MatchFacts(nginxFacts)
+		s.MatchFacts(Facts{})
+	})
+}
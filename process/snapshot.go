@@ -0,0 +1,109 @@
+package process
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/polyverse/masche/common"
+)
+
+// ProcessSnapshot is what a process was at an instant, read once by SnapshotAll. Unlike the Process and its
+// ProcessInfo, it doesn't change, so its fields all come from the same reading, and it can be kept to compare with a
+// later snapshot. Cmdline is shared by the copies of a snapshot, so it must not be modified.
+//
+// Name is chosen as Process.Name chooses it: the executable, or the first argument, or the command name between
+// brackets for the processes without both, like kernel threads. UserId is the real uid. StartTime is the time the
+// process started, which with Pid tells apart the processes that reuse a pid. It's the same in every snapshot, and the
+// same as the StartTime of the ProcessInfo of the process.
+//
+// On other systems than Linux only Pid, Name, ParentProcessId and Executable are filled, UserId is -1 and StartTime is
+// zero.
+type ProcessSnapshot struct {
+	Pid             int       `json:"pid"`
+	Name            string    `json:"name"`
+	Cmdline         []string  `json:"cmdline,omitempty"`
+	UserId          int       `json:"userId"`
+	ParentProcessId int       `json:"parentProcessId"`
+	StartTime       time.Time `json:"startTime"`
+	Executable      string    `json:"executable,omitempty"`
+
+	// Redactor removes the secrets of Cmdline, like the values of --password flags, when it's serialized to JSON. If
+	// it's nil the common.DefaultRedactor is used, common.NoRedaction disables it.
+	Redactor *common.Redactor `json:"-"`
+}
+
+// MarshalJSON serializes s with its Cmdline redacted by its Redactor.
+func (s ProcessSnapshot) MarshalJSON() ([]byte, error) {
+	type plain ProcessSnapshot
+	if s.Cmdline != nil {
+		s.Cmdline = s.Redactor.RedactArgv(s.Cmdline)
+	}
+	return json.Marshal(plain(s))
+}
+
+// SnapshotAll takes a snapshot of every running process, sorted by pid, reading each of their files once. The
+// processes that exit while they are read are left out, and the ones with a file that can't be read are kept without
+// what it has, with a softerror. The executables that can't be read for lack of privileges are left empty without a
+// softerror, as in ProcessInfo.
+func SnapshotAll() (snapshots []ProcessSnapshot, harderror error, softerrors []error) {
+	pids, harderror, softerrors := GetAllPids()
+	if harderror != nil {
+		return nil, harderror, softerrors
+	}
+	snapshots, harderror, serrs := snapshotPids(pids)
+	return common.Result(snapshots, harderror, append(softerrors, serrs...))
+}
+
+// ReplacedProcess is a pid that belongs to another process, or runs another executable, in a later snapshot.
+type ReplacedProcess struct {
+	Previous ProcessSnapshot `json:"previous"`
+	Current  ProcessSnapshot `json:"current"`
+}
+
+// SnapshotDiff is the result of Diff, each list sorted by pid.
+type SnapshotDiff struct {
+	// Started are the processes of the current snapshot whose pid wasn't in the previous one.
+	Started []ProcessSnapshot `json:"started"`
+	// Exited are the processes of the previous snapshot whose pid isn't in the current one.
+	Exited []ProcessSnapshot `json:"exited"`
+	// Replaced are the pids in both snapshots whose process started at another time, because the previous one exited
+	// and another one got its pid, or whose executable changed, because the process executed another one.
+	// An executable that is deleted or replaced on disk while the process runs isn't a change.
+	Replaced []ReplacedProcess `json:"replaced"`
+}
+
+// Diff compares two snapshots taken by SnapshotAll, prev before cur, and returns the processes that started, exited
+// and were replaced between them.
+func Diff(prev, cur []ProcessSnapshot) SnapshotDiff {
+	previous := make(map[int]ProcessSnapshot, len(prev))
+	for _, s := range prev {
+		previous[s.Pid] = s
+	}
+
+	diff := SnapshotDiff{Started: []ProcessSnapshot{}, Exited: []ProcessSnapshot{}, Replaced: []ReplacedProcess{}}
+	for _, s := range cur {
+		p, ok := previous[s.Pid]
+		switch {
+		case !ok:
+			diff.Started = append(diff.Started, s)
+		case !p.StartTime.Equal(s.StartTime) || executed(p, s):
+			diff.Replaced = append(diff.Replaced, ReplacedProcess{Previous: p, Current: s})
+		}
+		delete(previous, s.Pid)
+	}
+	for _, s := range previous {
+		diff.Exited = append(diff.Exited, s)
+	}
+
+	sort.Slice(diff.Started, func(i, j int) bool { return diff.Started[i].Pid < diff.Started[j].Pid })
+	sort.Slice(diff.Exited, func(i, j int) bool { return diff.Exited[i].Pid < diff.Exited[j].Pid })
+	sort.Slice(diff.Replaced, func(i, j int) bool { return diff.Replaced[i].Current.Pid < diff.Replaced[j].Current.Pid })
+	return diff
+}
+
+// executed tells if the process executed another executable between the snapshots. The executable of zombies, and of
+// processes whose privileges changed, can't be read, so only the ones read in both are compared.
+func executed(prev, cur ProcessSnapshot) bool {
+	return prev.Executable != "" && cur.Executable != "" && prev.Executable != cur.Executable
+}
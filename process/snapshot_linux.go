@@ -0,0 +1,105 @@
+package process
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/polyverse/masche/common"
+)
+
+// snapshotStatus are the fields of the status file a snapshot needs.
+type snapshotStatus struct {
+	Uid int `statusFileKey:"Uid"`
+}
+
+func snapshotPids(pids []int) (snapshots []ProcessSnapshot, harderror error, softerrors []error) {
+	boot, err := common.BootTime()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read the boot time (%v)", err), nil
+	}
+	snapshots = make([]ProcessSnapshot, 0, len(pids))
+	for _, pid := range pids {
+		s, ok, serrs := snapshotPid(pid, boot)
+		softerrors = append(softerrors, serrs...)
+		if ok {
+			snapshots = append(snapshots, s)
+		}
+	}
+	return snapshots, nil, softerrors
+}
+
+// snapshotPid reads the stat, status, cmdline and exe files of a process, in that order. It returns false if the
+// process exited before its stat and status files were read.
+func snapshotPid(pid int, boot time.Time) (s ProcessSnapshot, ok bool, softerrors []error) {
+	statPath := common.StatFilePathFromPid(uint(pid))
+	data, err := ioutil.ReadFile(statPath)
+	if err != nil {
+		if !exitedWhileRead(err) {
+			softerrors = append(softerrors, &common.LocatedError{Pid: pid,
+				Err: fmt.Errorf("Unable to read the stat of process %d at %s (%v)", pid, statPath, err)})
+		}
+		return s, false, softerrors
+	}
+	stat, err := common.ParseStatFile(data)
+	if err != nil {
+		return s, false, append(softerrors, &common.LocatedError{Pid: pid,
+			Err: fmt.Errorf("Unable to parse the stat of process %d (%v)", pid, err)})
+	}
+	s = ProcessSnapshot{Pid: pid, UserId: -1, ParentProcessId: stat.Ppid, StartTime: stat.StartedAt(boot)}
+
+	statusPath := common.ProcFilePath(uint(pid), "status")
+	data, err = ioutil.ReadFile(statusPath)
+	var status snapshotStatus
+	switch {
+	case exitedWhileRead(err):
+		return s, false, softerrors
+	case err != nil:
+		softerrors = append(softerrors, &common.LocatedError{Pid: pid,
+			Err: fmt.Errorf("Unable to read the status of process %d at %s (%v)", pid, statusPath, err)})
+	case parseStatusToStruct(data, &status) != nil:
+		softerrors = append(softerrors, &common.LocatedError{Pid: pid,
+			Err: fmt.Errorf("Unable to parse the status of process %d", pid)})
+	default:
+		s.UserId = status.Uid
+	}
+
+	// The cmdline of zombies and kernel threads is empty, and their exe link can't be read.
+	cmdlinePath := common.ProcFilePath(uint(pid), "cmdline")
+	data, err = ioutil.ReadFile(cmdlinePath)
+	if err != nil && !exitedWhileRead(err) {
+		softerrors = append(softerrors, &common.LocatedError{Pid: pid,
+			Err: fmt.Errorf("Unable to read the arguments of process %d at %s (%v)", pid, cmdlinePath, err)})
+	} else if len(data) > 0 {
+		s.Cmdline = strings.Split(string(bytes.TrimSuffix(data, []byte{0})), "\x00")
+	}
+
+	exePath := common.ProcFilePath(uint(pid), "exe")
+	link, err := os.Readlink(exePath)
+	if err != nil && !exitedWhileRead(err) && !os.IsPermission(err) {
+		softerrors = append(softerrors, &common.LocatedError{Pid: pid,
+			Err: fmt.Errorf("Unable to read the executable of process %d at %s (%v)", pid, exePath, err)})
+	} else if err == nil {
+		s.Executable = strings.TrimSuffix(link, deletedSuffix)
+	}
+
+	switch {
+	case s.Executable != "":
+		s.Name = s.Executable
+	case len(s.Cmdline) > 0 && s.Cmdline[0] != "":
+		s.Name = s.Cmdline[0]
+	default:
+		s.Name = "[" + stat.Comm + "]"
+	}
+	return s, true, softerrors
+}
+
+// exitedWhileRead tells if err is the error of reading a file of a process that exited.
+func exitedWhileRead(err error) bool {
+	return os.IsNotExist(err) || errors.Is(err, syscall.ESRCH)
+}
@@ -0,0 +1,26 @@
+// +build windows darwin
+
+package process
+
+import (
+	"github.com/polyverse/masche/common"
+)
+
+func snapshotPids(pids []int) (snapshots []ProcessSnapshot, harderror error, softerrors []error) {
+	snapshots = make([]ProcessSnapshot, 0, len(pids))
+	for _, pid := range pids {
+		info, err, serrs := processInfo(pid, InfoOptions{})
+		softerrors = append(softerrors, serrs...)
+		if err != nil {
+			softerrors = append(softerrors, &common.LocatedError{Pid: pid, Err: err})
+			continue
+		}
+		name := info.GetExecutable()
+		if name == "" {
+			name = info.GetCommand()
+		}
+		snapshots = append(snapshots, ProcessSnapshot{Pid: pid, Name: name, UserId: -1,
+			ParentProcessId: info.GetParentProcessId(), Executable: info.GetExecutable()})
+	}
+	return snapshots, nil, softerrors
+}
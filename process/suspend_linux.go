@@ -0,0 +1,128 @@
+package process
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/polyverse/masche/common"
+)
+
+// suspendTimeout is how long Suspend waits for the threads of a process to stop.
+const suspendTimeout = time.Second
+
+var (
+	alreadyStoppedMu sync.Mutex
+	// alreadyStopped are the processes that were stopped when Suspend was called, by pid, with their start time.
+	// Resume leaves them stopped.
+	alreadyStopped = map[int]uint64{}
+)
+
+func (p linuxProcess) Signal(sig syscall.Signal) (harderror error, softerrors []error) {
+	// kill(2) signals process groups, or every process, given pids that aren't positive.
+	if p.Pid() <= 0 {
+		return fmt.Errorf("Unable to send %v to invalid pid %d", sig, p.Pid()), nil
+	}
+	if err := syscall.Kill(p.Pid(), sig); err != nil {
+		return fmt.Errorf("Unable to send %v to process %d (%v)", sig, p.Pid(), err), nil
+	}
+	return nil, nil
+}
+
+func (p linuxProcess) Suspend() (harderror error, softerrors []error) {
+	if p.Pid() == os.Getpid() {
+		return fmt.Errorf("Process %d can't suspend itself", p.Pid()), nil
+	}
+	stopped, startTime, harderror, softerrors := p.stoppedBySignal()
+	if harderror != nil {
+		return harderror, softerrors
+	}
+	alreadyStoppedMu.Lock()
+	if stopped {
+		alreadyStopped[p.Pid()] = startTime
+	} else {
+		delete(alreadyStopped, p.Pid())
+	}
+	alreadyStoppedMu.Unlock()
+	if stopped {
+		return nil, softerrors
+	}
+
+	if harderror, softerrors = p.Signal(syscall.SIGSTOP); harderror != nil {
+		return harderror, softerrors
+	}
+
+	// The signal stops the threads asynchronously, each one the next time it runs.
+	deadline := time.Now().Add(suspendTimeout)
+	for {
+		running, harderror, serrs := p.runningThreads()
+		if harderror != nil || len(running) == 0 {
+			return harderror, append(softerrors, serrs...)
+		}
+		if time.Now().After(deadline) {
+			return nil, append(softerrors, &common.LocatedError{Pid: p.Pid(),
+				Err: fmt.Errorf("Threads %v of proc %d didn't stop in %v", running, p.Pid(), suspendTimeout)})
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func (p linuxProcess) Resume() (harderror error, softerrors []error) {
+	alreadyStoppedMu.Lock()
+	startTime, stopped := alreadyStopped[p.Pid()]
+	delete(alreadyStopped, p.Pid())
+	alreadyStoppedMu.Unlock()
+	if stopped {
+		// A process that reused the pid wasn't stopped by anyone else.
+		if current, alive, err := startTimeIfAlive(p.Pid()); err == nil && alive && current == startTime {
+			return nil, nil
+		}
+	}
+	return p.Signal(syscall.SIGCONT)
+}
+
+// stoppedBySignal tells if every thread of the process is stopped, and the process itself by a signal, like SIGSTOP
+// from a shell, and returns its start time.
+func (p linuxProcess) stoppedBySignal() (stopped bool, startTime uint64, harderror error, softerrors []error) {
+	stat, err := common.ReadStatFile(uint(p.Pid()))
+	if err != nil {
+		return false, 0, fmt.Errorf("Unable to read the stat of process %d (%v)", p.Pid(), err), nil
+	}
+	if stat.State != "T" {
+		return false, stat.StartTime, nil, nil
+	}
+	running, harderror, softerrors := p.runningThreads()
+	return harderror == nil && len(running) == 0, stat.StartTime, harderror, softerrors
+}
+
+// runningThreads returns the threads of the process that aren't stopped. Threads that exit while they're checked
+// aren't running.
+func (p linuxProcess) runningThreads() (running []int, harderror error, softerrors []error) {
+	tids, harderror, softerrors := p.Threads()
+	if harderror != nil {
+		return nil, harderror, softerrors
+	}
+	for _, tid := range tids {
+		path := filepath.Join(common.ProcFilePath(uint(p.Pid()), "task"), strconv.Itoa(tid), "stat")
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		stat, err := common.ParseStatFile(data)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to parse %s (%v)", path, err), softerrors
+		}
+		// T is stopped by a signal, t by a debugger, and zombies and dead threads don't run either.
+		switch stat.State {
+		case "T", "t", "Z", "X":
+		default:
+			running = append(running, tid)
+		}
+	}
+	return running, nil, softerrors
+}
@@ -0,0 +1,118 @@
+package process
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/polyverse/masche/common"
+)
+
+// ProcessNode is a process of the tree returned by ProcessTree, with its descendants.
+type ProcessNode struct {
+	Pid      int           `json:"pid"`
+	Command  string        `json:"command"`
+	Children []ProcessNode `json:"children,omitempty"`
+}
+
+// treeEntry is what the process tree needs of each process.
+type treeEntry struct {
+	pid, ppid int
+	command   string
+}
+
+// Parent opens the parent of p. Processes whose parent exited were reparented to init or to a subreaper, which is
+// then their parent. Processes without a parent, like init, fail.
+func Parent(p Process) (parent Process, harderror error, softerrors []error) {
+	entry, err := readTreeEntry(p.Pid())
+	if err != nil {
+		return nil, err, nil
+	}
+	if entry.ppid == 0 {
+		return nil, fmt.Errorf("Process %d has no parent", p.Pid()), nil
+	}
+	return OpenFromPid(entry.ppid)
+}
+
+// Children opens the children of p, sorted by pid. The processes that exit while the children are looked for are left
+// out with a softerror.
+func Children(p Process) (children []Process, harderror error, softerrors []error) {
+	entries, harderror, softerrors := treeEntries()
+	if harderror != nil {
+		return nil, harderror, softerrors
+	}
+
+	children = make([]Process, 0)
+	for _, entry := range entries {
+		if entry.ppid != p.Pid() {
+			continue
+		}
+		child, err, softs := OpenFromPid(entry.pid)
+		softerrors = append(softerrors, softs...)
+		if err != nil {
+			softerrors = append(softerrors, &common.LocatedError{Pid: entry.pid,
+				Err: fmt.Errorf("Unable to open child %d of process %d (%v)", entry.pid, p.Pid(), err)})
+			continue
+		}
+		children = append(children, child)
+	}
+	return children, nil, softerrors
+}
+
+// ProcessTree returns root and all its descendants. The children of each process are sorted by pid. The processes
+// that exit while the tree is built are left out with a softerror.
+func ProcessTree(root Process) (tree ProcessNode, harderror error, softerrors []error) {
+	entries, harderror, softerrors := treeEntries()
+	if harderror != nil {
+		return ProcessNode{}, harderror, softerrors
+	}
+
+	children := make(map[int][]treeEntry)
+	tree = ProcessNode{Pid: root.Pid()}
+	found := false
+	for _, entry := range entries {
+		if entry.pid == root.Pid() {
+			tree.Command = entry.command
+			found = true
+		} else if entry.ppid != entry.pid {
+			children[entry.ppid] = append(children[entry.ppid], entry)
+		}
+	}
+	if !found {
+		return ProcessNode{}, fmt.Errorf("Process %d isn't running", root.Pid()), softerrors
+	}
+
+	// Every pid is visited once, so a tree that changed while it was read can't make a cycle.
+	visited := map[int]bool{root.Pid(): true}
+	var grow func(node *ProcessNode)
+	grow = func(node *ProcessNode) {
+		for _, entry := range children[node.Pid] {
+			if visited[entry.pid] {
+				continue
+			}
+			visited[entry.pid] = true
+			child := ProcessNode{Pid: entry.pid, Command: entry.command}
+			grow(&child)
+			node.Children = append(node.Children, child)
+		}
+	}
+	grow(&tree)
+	return tree, nil, softerrors
+}
+
+// treeEntries reads the tree entries of every process, sorted by pid.
+func treeEntries() (entries []treeEntry, harderror error, softerrors []error) {
+	pids, harderror, softerrors := GetAllPids()
+	if harderror != nil {
+		return nil, harderror, softerrors
+	}
+	for _, pid := range pids {
+		entry, err := readTreeEntry(pid)
+		if err != nil {
+			softerrors = append(softerrors, &common.LocatedError{Pid: pid, Err: err})
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].pid < entries[j].pid })
+	return entries, nil, softerrors
+}
@@ -0,0 +1,27 @@
+package process
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/polyverse/masche/common"
+)
+
+// treeStatus are the fields of the status file the process tree needs.
+type treeStatus struct {
+	PPid int    `statusFileKey:"PPid"`
+	Name string `statusFileKey:"Name"`
+}
+
+// readTreeEntry reads the tree entry of a process from its status file, without the rest of its info.
+func readTreeEntry(pid int) (treeEntry, error) {
+	data, err := ioutil.ReadFile(common.ProcFilePath(uint(pid), "status"))
+	if err != nil {
+		return treeEntry{}, fmt.Errorf("Unable to read the status of process %d (%v)", pid, err)
+	}
+	var status treeStatus
+	if err := parseStatusToStruct(data, &status); err != nil {
+		return treeEntry{}, fmt.Errorf("Unable to parse the status of process %d (%v)", pid, err)
+	}
+	return treeEntry{pid: pid, ppid: status.PPid, command: status.Name}, nil
+}
@@ -0,0 +1,11 @@
+// +build windows darwin
+
+package process
+
+func readTreeEntry(pid int) (treeEntry, error) {
+	info, err, _ := processInfo(pid, InfoOptions{})
+	if err != nil {
+		return treeEntry{}, err
+	}
+	return treeEntry{pid: pid, ppid: info.GetParentProcessId(), command: info.GetCommand()}, nil
+}
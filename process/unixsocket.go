@@ -0,0 +1,27 @@
+package process
+
+import "github.com/polyverse/masche/common"
+
+// UnixSocketPeer is a unix socket of a process, and the process at the other end.
+type UnixSocketPeer struct {
+	Inode uint64 `json:"inode"`
+	// Path is the path the socket is bound to, its abstract name starting with @, or empty if it's unnamed. Accepted
+	// connections have the path of the listening socket.
+	Path string `json:"path"`
+	// PeerInode is the socket at the other end, or zero if the socket isn't connected or its peer is unknown.
+	PeerInode uint64 `json:"peerInode"`
+	// PeerPid is the process with the lowest pid that has the peer socket open, or zero if it's unknown.
+	PeerPid        int    `json:"peerPid"`
+	PeerExecutable string `json:"peerExecutable"`
+	// Heuristic is true if the peer was guessed by pairing connected sockets with consecutive inodes, because
+	// sock_diag was unavailable. That finds the peers of socketpair(2), but usually not the ones of accepted
+	// connections, and the guess can be wrong.
+	Heuristic bool `json:"heuristic"`
+}
+
+// UnixSocketPeers lists the unix sockets p has open, and the processes they are connected to. It's only implemented
+// on Linux, where the peers are read with the sock_diag netlink interface in the network namespace of p. Entering
+// the namespace of a process in another one needs CAP_SYS_ADMIN, and without it the peers are guessed.
+func UnixSocketPeers(p Process) (peers []UnixSocketPeer, harderror error, softerrors []error) {
+	return common.Result(unixSocketPeers(p.Pid()))
+}
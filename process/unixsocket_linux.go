@@ -0,0 +1,364 @@
+package process
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"github.com/polyverse/masche/common"
+)
+
+// useSockDiag is a variable so the tests can exercise the pairing heuristic.
+var useSockDiag = true
+
+// Constants of sock_diag(7) missing from syscall.
+const (
+	netlinkSockDiag    = 4
+	sockDiagByFamily   = 20
+	udiagShowPeer      = 4
+	unixDiagPeer       = 2
+	unixDiagMsgLen     = 16
+	unixStateConnected = 3
+)
+
+// nativeEndian is the byte order of netlink messages.
+var nativeEndian binary.ByteOrder = func() binary.ByteOrder {
+	x := uint16(1)
+	if *(*byte)(unsafe.Pointer(&x)) == 1 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}()
+
+// unixSocket is an entry of /proc/net/unix, with its peer if it's known.
+type unixSocket struct {
+	path  string
+	state int
+	peer  uint64
+}
+
+func unixSocketPeers(pid int) (peers []UnixSocketPeer, harderror error, softerrors []error) {
+	sockets, err := readNetUnix(pid)
+	if err != nil {
+		return nil, err, nil
+	}
+
+	inodes, err := socketInodes(pid)
+	if err != nil {
+		return nil, err, nil
+	}
+
+	heuristic := !useSockDiag
+	if useSockDiag {
+		if err := sockDiagPeers(pid, sockets); err != nil {
+			softerrors = append(softerrors, fmt.Errorf("Guessing the peers of unix sockets, sock_diag failed (%v)",
+				err))
+			heuristic = true
+		}
+	}
+	if heuristic {
+		pairSockets(sockets)
+	}
+
+	wanted := make(map[uint64]bool)
+	for _, inode := range inodes {
+		if socket, ok := sockets[inode]; ok && socket.peer != 0 {
+			wanted[socket.peer] = true
+		}
+	}
+	owners, serrs := socketOwners(wanted)
+	softerrors = append(softerrors, serrs...)
+
+	peers = make([]UnixSocketPeer, 0, len(inodes))
+	for _, inode := range inodes {
+		socket, ok := sockets[inode]
+		if !ok {
+			// It's not a unix socket.
+			continue
+		}
+
+		peer := UnixSocketPeer{Inode: inode, Path: socket.path, PeerInode: socket.peer,
+			Heuristic: heuristic && socket.peer != 0}
+		if owner, ok := owners[socket.peer]; ok {
+			peer.PeerPid = owner
+			peer.PeerExecutable, _ = ProcessExe(owner)
+		}
+		peers = append(peers, peer)
+	}
+	return peers, nil, softerrors
+}
+
+// readNetUnix reads the unix sockets of the network namespace of pid, from its net/unix file, by inode.
+func readNetUnix(pid int) (sockets map[uint64]*unixSocket, err error) {
+	return readNetUnixFile(common.ProcFilePath(uint(pid), "net/unix"))
+}
+
+// readNetUnixFile reads the unix sockets of a file with the format of /proc/net/unix, by inode.
+func readNetUnixFile(path string) (sockets map[uint64]*unixSocket, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// Num RefCount Protocol Flags Type St Inode [Path]
+	sockets = make(map[uint64]*unixSocket)
+	scanner := bufio.NewScanner(f)
+	for first := true; scanner.Scan(); first = false {
+		if first {
+			continue
+		}
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 7 {
+			return nil, fmt.Errorf("Unrecognised line in %s: %s", path, scanner.Text())
+		}
+		state, err := strconv.ParseInt(fields[5], 16, 32)
+		if err != nil {
+			return nil, fmt.Errorf("Unrecognised state in %s: %s", path, scanner.Text())
+		}
+		inode, err := strconv.ParseUint(fields[6], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Unrecognised inode in %s: %s", path, scanner.Text())
+		}
+		socket := &unixSocket{state: int(state)}
+		if len(fields) > 7 {
+			socket.path = strings.Join(fields[7:], " ")
+		}
+		sockets[inode] = socket
+	}
+	return sockets, scanner.Err()
+}
+
+// socketInodes returns the inodes of the sockets pid has open, sorted.
+func socketInodes(pid int) (inodes []uint64, err error) {
+	fdDir := common.ProcFilePath(uint(pid), "fd")
+	fds, err := ioutil.ReadDir(fdDir)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to list the file descriptors of process %d (%v)", pid, err)
+	}
+
+	seen := make(map[uint64]bool)
+	for _, fd := range fds {
+		target, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+		if err != nil {
+			// It was closed meanwhile.
+			continue
+		}
+		var inode uint64
+		if _, err := fmt.Sscanf(target, "socket:[%d]", &inode); err == nil && !seen[inode] {
+			seen[inode] = true
+			inodes = append(inodes, inode)
+		}
+	}
+	sort.Slice(inodes, func(i, j int) bool { return inodes[i] < inodes[j] })
+	return inodes, nil
+}
+
+// socketOwners finds the lowest pid that has open each of the wanted sockets. The processes whose file descriptors
+// can't be listed are skipped, so their sockets have no owner.
+func socketOwners(wanted map[uint64]bool) (owners map[uint64]int, softerrors []error) {
+	owners = make(map[uint64]int)
+	if len(wanted) == 0 {
+		return owners, nil
+	}
+
+	pids, err, softerrors := getAllPids()
+	if err != nil {
+		return owners, append(softerrors, fmt.Errorf("Unable to find the owners of the peer sockets (%v)", err))
+	}
+	sort.Ints(pids)
+	for _, pid := range pids {
+		inodes, err := socketInodes(pid)
+		if err != nil {
+			continue
+		}
+		for _, inode := range inodes {
+			if _, found := owners[inode]; wanted[inode] && !found {
+				owners[inode] = pid
+			}
+		}
+		if len(owners) == len(wanted) {
+			break
+		}
+	}
+	return owners, softerrors
+}
+
+// pairSockets guesses the peers of the connected sockets: both ends of a socketpair(2) are created together, so they
+// have consecutive inodes.
+func pairSockets(sockets map[uint64]*unixSocket) {
+	var connected []uint64
+	for inode, socket := range sockets {
+		if socket.state == unixStateConnected && socket.peer == 0 {
+			connected = append(connected, inode)
+		}
+	}
+	sort.Slice(connected, func(i, j int) bool { return connected[i] < connected[j] })
+
+	for i := 0; i+1 < len(connected); i++ {
+		if connected[i+1] == connected[i]+1 {
+			sockets[connected[i]].peer = connected[i+1]
+			sockets[connected[i+1]].peer = connected[i]
+			i++
+		}
+	}
+}
+
+// sockDiagPeers fills the peers of sockets, the ones of the network namespace of pid, with the ones the kernel reports
+// through sock_diag(7).
+func sockDiagPeers(pid int, sockets map[uint64]*unixSocket) error {
+	fd, err := sockDiagSocket(pid)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+
+	// A struct nlmsghdr followed by a struct unix_diag_req asking for every socket, in any state.
+	request := make([]byte, syscall.NLMSG_HDRLEN+24)
+	nativeEndian.PutUint32(request[0:], uint32(len(request)))
+	nativeEndian.PutUint16(request[4:], sockDiagByFamily)
+	nativeEndian.PutUint16(request[6:], syscall.NLM_F_REQUEST|syscall.NLM_F_DUMP)
+	nativeEndian.PutUint32(request[8:], 1)
+	req := request[syscall.NLMSG_HDRLEN:]
+	req[0] = syscall.AF_UNIX
+	nativeEndian.PutUint32(req[4:], 0xffffffff)
+	nativeEndian.PutUint32(req[12:], udiagShowPeer)
+	if err := syscall.Sendto(fd, request, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 64*1024)
+	for {
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if err != nil {
+			return err
+		}
+		messages, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return err
+		}
+
+		for _, message := range messages {
+			switch message.Header.Type {
+			case syscall.NLMSG_DONE:
+				return nil
+			case syscall.NLMSG_ERROR:
+				if len(message.Data) >= 4 {
+					if errno := int32(nativeEndian.Uint32(message.Data)); errno != 0 {
+						return syscall.Errno(-errno)
+					}
+				}
+				return fmt.Errorf("Unknown netlink error")
+			}
+			parseUnixDiagMsg(message.Data, sockets)
+		}
+	}
+}
+
+// sockDiagSocket opens a sock_diag netlink socket in the network namespace of pid, as it only reports the sockets of
+// the namespace it was opened in. Opening it in the namespace of another process, like one in a container, needs
+// CAP_SYS_ADMIN.
+func sockDiagSocket(pid int) (fd int, err error) {
+	target, err := namespaceInode(pid, "net")
+	if err != nil {
+		return -1, err
+	}
+	own, err := namespaceInode(os.Getpid(), "net")
+	if err != nil {
+		return -1, err
+	}
+	if own == target {
+		return syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, netlinkSockDiag)
+	}
+
+	// Namespaces are entered by threads, so the socket is opened by a locked one, which is only unlocked if it could
+	// go back to its own namespace, and otherwise ends with its goroutine.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runtime.LockOSThread()
+		fd, err = socketInNamespace(pid)
+	}()
+	<-done
+	if err != nil {
+		return -1, fmt.Errorf("Unable to enter the network namespace of process %d (%v)", pid, err)
+	}
+	return fd, nil
+}
+
+// socketInNamespace opens a sock_diag socket in the network namespace of pid from the current thread, which must be
+// locked, and unlocks it if it gets back to its own namespace.
+func socketInNamespace(pid int) (fd int, err error) {
+	own, err := os.Open(common.ProcFilePath(uint(os.Getpid()), fmt.Sprintf("task/%d/ns/net", syscall.Gettid())))
+	if err != nil {
+		return -1, err
+	}
+	defer own.Close()
+	target, err := os.Open(common.ProcFilePath(uint(pid), "ns/net"))
+	if err != nil {
+		return -1, err
+	}
+	defer target.Close()
+
+	if err := setns(target, syscall.CLONE_NEWNET); err != nil {
+		return -1, err
+	}
+	fd, err = syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, netlinkSockDiag)
+	if setns(own, syscall.CLONE_NEWNET) == nil {
+		runtime.UnlockOSThread()
+	}
+	return fd, err
+}
+
+// sysSetns is the number of setns in each architecture, as syscall doesn't have it in all of them.
+var sysSetns = map[string]uintptr{"386": 346, "amd64": 308, "arm": 375, "arm64": 268, "ppc64": 350, "ppc64le": 350,
+	"s390x": 339}
+
+// setns moves the current thread to the namespace of kind open as f.
+func setns(f *os.File, kind int) error {
+	number, ok := sysSetns[runtime.GOARCH]
+	if !ok {
+		return fmt.Errorf("setns isn't supported on %s", runtime.GOARCH)
+	}
+	if _, _, errno := syscall.RawSyscall(number, f.Fd(), uintptr(kind), 0); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// parseUnixDiagMsg sets the peer of the socket described by a struct unix_diag_msg and its attributes.
+func parseUnixDiagMsg(data []byte, sockets map[uint64]*unixSocket) {
+	if len(data) < unixDiagMsgLen {
+		return
+	}
+	socket, ok := sockets[uint64(nativeEndian.Uint32(data[4:]))]
+	if !ok {
+		// It was created after reading /proc/net/unix.
+		return
+	}
+
+	for attrs := data[unixDiagMsgLen:]; len(attrs) >= syscall.SizeofRtAttr; {
+		length := int(nativeEndian.Uint16(attrs))
+		if length < syscall.SizeofRtAttr || length > len(attrs) {
+			return
+		}
+		if nativeEndian.Uint16(attrs[2:]) == unixDiagPeer && length >= syscall.SizeofRtAttr+4 {
+			socket.peer = uint64(nativeEndian.Uint32(attrs[syscall.SizeofRtAttr:]))
+		}
+
+		aligned := (length + syscall.RTA_ALIGNTO - 1) &^ (syscall.RTA_ALIGNTO - 1)
+		if aligned > len(attrs) {
+			return
+		}
+		attrs = attrs[aligned:]
+	}
+}
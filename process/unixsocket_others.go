@@ -0,0 +1,11 @@
+// +build windows darwin
+
+package process
+
+import (
+	"fmt"
+)
+
+func unixSocketPeers(pid int) (peers []UnixSocketPeer, harderror error, softerrors []error) {
+	return nil, fmt.Errorf("UnixSocketPeers is not implemented on this platform"), nil
+}
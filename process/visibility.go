@@ -0,0 +1,101 @@
+package process
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/polyverse/masche/common"
+)
+
+// VisibilityLevel is how much of the processes of the system can be seen, see Visibility.
+type VisibilityLevel int
+
+const (
+	// FullVisibility means that every process can be listed and its status read.
+	FullVisibility VisibilityLevel = iota
+	// PartialVisibility means that some processes are hidden or unreadable. A scan of all the processes misses them.
+	PartialVisibility
+	// SeverelyRestricted means that most processes are hidden or unreadable, so a scan of all the processes that
+	// finds nothing says little about the system.
+	SeverelyRestricted
+)
+
+func (l VisibilityLevel) String() string {
+	switch l {
+	case FullVisibility:
+		return "full"
+	case PartialVisibility:
+		return "partial"
+	case SeverelyRestricted:
+		return "severely restricted"
+	}
+	return fmt.Sprintf("VisibilityLevel(%d)", int(l))
+}
+
+// Visibility tells how many of the processes of the system can be seen. On hardened hosts the proc filesystem hides
+// the processes of other users (the hidepid mount option), or lists them but doesn't let them be read.
+type Visibility struct {
+	Level VisibilityLevel `json:"level"`
+	// Coverage is the percentage of the tasks of the kernel that belong to processes that can be listed and read.
+	// It's computed from Listed and Unreadable if the kernel doesn't tell how many tasks it has.
+	Coverage float64 `json:"coverage"`
+	// HidePid is the hidepid option of the proc filesystem, if it's set. It doesn't restrict the privileged users,
+	// nor the members of the group given by its gid option.
+	HidePid string `json:"hidepid,omitempty"`
+	// Listed is the amount of processes listed, and Unreadable is how many of them can't be read.
+	Listed     int `json:"listed"`
+	Unreadable int `json:"unreadable"`
+	// Tasks is the amount of tasks (threads) the kernel has, or zero if it's unknown. VisibleTasks is how many of
+	// them belong to the processes that can be read.
+	Tasks        int `json:"tasks,omitempty"`
+	VisibleTasks int `json:"visibleTasks,omitempty"`
+}
+
+func (v Visibility) String() string {
+	if v.Level == FullVisibility {
+		return "full visibility"
+	}
+	return fmt.Sprintf("%v visibility, %.1f%% coverage", v.Level, v.Coverage)
+}
+
+// ErrRestrictedVisibility is the error matched by every VisibilityError.
+var ErrRestrictedVisibility = errors.New("not every process can be seen")
+
+// VisibilityError warns that the processes listed are not all the processes of the system, so the results of
+// operations on all the processes are incomplete.
+type VisibilityError struct {
+	Visibility Visibility `json:"visibility"`
+}
+
+func (e *VisibilityError) Error() string {
+	return fmt.Sprintf("%v: %v", ErrRestrictedVisibility, e.Visibility)
+}
+
+// Unwrap makes errors.Is(err, ErrRestrictedVisibility) true for every VisibilityError.
+func (e *VisibilityError) Unwrap() error {
+	return ErrRestrictedVisibility
+}
+
+// Thresholds of Coverage for each VisibilityLevel. Full visibility tolerates the processes that start and exit while
+// they are being counted.
+const (
+	fullCoverage    = 95
+	minimumCoverage = 50
+)
+
+// AssessVisibility tells how many of the processes of the system can be seen. Operations on all the processes, like
+// OpenAll, can only work with those.
+func AssessVisibility() (visibility Visibility, harderror error, softerrors []error) {
+	return common.Result(assessVisibility())
+}
+
+// levelOf returns the level of a coverage.
+func levelOf(coverage float64) VisibilityLevel {
+	switch {
+	case coverage >= fullCoverage:
+		return FullVisibility
+	case coverage >= minimumCoverage:
+		return PartialVisibility
+	}
+	return SeverelyRestricted
+}
@@ -0,0 +1,141 @@
+package process
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/polyverse/masche/common"
+)
+
+func assessVisibility() (visibility Visibility, harderror error, softerrors []error) {
+	hidepid, err := procHidePid()
+	if err != nil {
+		softerrors = append(softerrors, fmt.Errorf("Unable to read the mount options of the proc filesystem (%v)", err))
+	}
+	visibility.HidePid = hidepid
+
+	pids, harderror, serrs := getAllPids()
+	softerrors = append(softerrors, serrs...)
+	if harderror != nil {
+		return visibility, harderror, softerrors
+	}
+	for _, pid := range pids {
+		threads, err := statusThreads(pid)
+		if os.IsNotExist(err) {
+			// It exited after being listed.
+			continue
+		}
+		visibility.Listed++
+		if err != nil {
+			visibility.Unreadable++
+			continue
+		}
+		visibility.VisibleTasks += threads
+	}
+
+	// The tasks of the kernel are only comparable with the ones listed if we are in its pid namespace.
+	if nested, err := inChildPidNamespace(); err != nil {
+		softerrors = append(softerrors, fmt.Errorf("Unable to tell the pid namespace (%v)", err))
+	} else if !nested {
+		visibility.Tasks, err = kernelTasks()
+		if err != nil {
+			softerrors = append(softerrors, fmt.Errorf("Unable to tell how many tasks the kernel has (%v)", err))
+		}
+	}
+	switch {
+	case visibility.Tasks > 0:
+		visibility.Coverage = 100 * float64(visibility.VisibleTasks) / float64(visibility.Tasks)
+		if visibility.Coverage > 100 {
+			// Tasks were created while the processes were being listed.
+			visibility.Coverage = 100
+		}
+	case visibility.Listed > 0:
+		visibility.Coverage = 100 * float64(visibility.Listed-visibility.Unreadable) / float64(visibility.Listed)
+	}
+
+	visibility.Level = levelOf(visibility.Coverage)
+	if visibility.Level == FullVisibility && visibility.Unreadable > 0 {
+		visibility.Level = PartialVisibility
+	}
+	return visibility, nil, softerrors
+}
+
+// procHidePid returns the hidepid option of the proc filesystem mounted at common.ProcRoot, or an empty string if it
+// doesn't hide processes.
+func procHidePid() (hidepid string, err error) {
+	data, err := ioutil.ReadFile(filepath.Join(common.ProcRoot, "mounts"))
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		// device mountpoint fstype options dump pass
+		fields := strings.Fields(line)
+		if len(fields) < 4 || fields[2] != "proc" || fields[1] != common.ProcRoot {
+			continue
+		}
+		for _, option := range strings.Split(fields[3], ",") {
+			if strings.HasPrefix(option, "hidepid=") {
+				hidepid = strings.TrimPrefix(option, "hidepid=")
+			}
+		}
+	}
+	if hidepid == "0" || hidepid == "off" {
+		hidepid = ""
+	}
+	return hidepid, nil
+}
+
+// statusThreads returns the amount of threads of a process, from its status file.
+func statusThreads(pid int) (threads int, err error) {
+	data, err := ioutil.ReadFile(common.ProcFilePath(uint(pid), "status"))
+	if err != nil {
+		return 0, err
+	}
+	threads = -1
+	err = forEachStatusLine(data, func(key string, value string) (err error) {
+		if key == "Threads" {
+			threads, err = strconv.Atoi(value)
+		}
+		return err
+	})
+	if err == nil && threads < 0 {
+		err = fmt.Errorf("No Threads in the status of process %d", pid)
+	}
+	return threads, err
+}
+
+// inChildPidNamespace tells if we are in a pid namespace other than the initial one, where the pids we see are also
+// listed by NSpid in our status.
+func inChildPidNamespace() (bool, error) {
+	data, err := ioutil.ReadFile(filepath.Join(common.ProcRoot, "self", "status"))
+	if err != nil {
+		return false, err
+	}
+	nested := false
+	err = forEachStatusLine(data, func(key string, value string) error {
+		if key == "NSpid" {
+			nested = len(strings.Fields(value)) > 1
+		}
+		return nil
+	})
+	return nested, err
+}
+
+// kernelTasks returns the amount of tasks of the kernel, which is the fourth field of loadavg after the slash:
+//
+//	0.20 0.18 0.12 1/80 11206
+func kernelTasks() (tasks int, err error) {
+	data, err := ioutil.ReadFile(filepath.Join(common.ProcRoot, "loadavg"))
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) < 4 || !strings.Contains(fields[3], "/") {
+		return 0, fmt.Errorf("Unrecognised loadavg %q", string(data))
+	}
+	return strconv.Atoi(fields[3][strings.Index(fields[3], "/")+1:])
+}
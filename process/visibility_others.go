@@ -0,0 +1,11 @@
+// +build windows darwin
+
+package process
+
+import (
+	"fmt"
+)
+
+func assessVisibility() (visibility Visibility, harderror error, softerrors []error) {
+	return visibility, fmt.Errorf("The visibility of processes is only assessed on linux"), nil
+}
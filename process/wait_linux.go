@@ -0,0 +1,162 @@
+package process
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/polyverse/masche/common"
+)
+
+const (
+	// pidfd_open has the same number in every architecture.
+	sysPidfdOpen = 434
+
+	exitPollInterval = 50 * time.Millisecond
+)
+
+// usePidfd tells if processes can be waited on and identified through pidfds instead of polling. Pidfds refer to
+// the processes of the kernel, so they aren't used with a fake ProcRoot.
+func usePidfd() bool {
+	return common.ProcRoot == "/proc" && pidfdSupported()
+}
+
+func (p linuxProcess) WaitForExit(ctx context.Context) (status ExitStatus, harderror error, softerrors []error) {
+	// The exit code of a process that isn't our child can't be obtained on Linux.
+	startTime, alive, err := startTimeIfAlive(p.Pid())
+	if err != nil {
+		return status, err, nil
+	}
+	if !alive {
+		return status, nil, nil
+	}
+
+	if usePidfd() {
+		err := waitForExitPidfd(ctx, p.Pid(), startTime)
+		if err == nil || err == ctx.Err() {
+			return status, err, nil
+		}
+		softerrors = append(softerrors, fmt.Errorf("Falling back to polling: %v", err))
+	}
+
+	return status, waitForExitPolling(ctx, p.Pid(), startTime), softerrors
+}
+
+// startTimeIfAlive returns the start time of the process with the given pid, and whether it's still running.
+// Zombies count as finished processes.
+func startTimeIfAlive(pid int) (startTime uint64, alive bool, err error) {
+	stat, err := common.ReadStatFile(uint(pid))
+	if os.IsNotExist(err) || err == syscall.ESRCH {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("Unable to read stat of process %d (%v)", pid, err)
+	}
+
+	return stat.StartTime, stat.State != "Z" && stat.State != "X", nil
+}
+
+// stillRunning tells if the process with the given pid is alive and has the given start time, that is, if it has
+// not been replaced by another process with a reused pid.
+func stillRunning(pid int, startTime uint64) (bool, error) {
+	currentStartTime, alive, err := startTimeIfAlive(pid)
+	if err != nil {
+		return false, err
+	}
+	return alive && currentStartTime == startTime, nil
+}
+
+func waitForExitPolling(ctx context.Context, pid int, startTime uint64) error {
+	ticker := time.NewTicker(exitPollInterval)
+	defer ticker.Stop()
+
+	for {
+		running, err := stillRunning(pid, startTime)
+		if err != nil {
+			return err
+		}
+		if !running {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func waitForExitPidfd(ctx context.Context, pid int, startTime uint64) error {
+	fd, _, errno := syscall.Syscall(sysPidfdOpen, uintptr(pid), 0, 0)
+	if errno == syscall.ESRCH {
+		return nil
+	} else if errno != 0 {
+		return fmt.Errorf("pidfd_open of process %d failed (%v)", pid, errno)
+	}
+	pidfd := int(fd)
+	defer syscall.Close(pidfd)
+
+	// The pid could have been reused between reading the start time and opening the pidfd.
+	running, err := stillRunning(pid, startTime)
+	if err != nil || !running {
+		return err
+	}
+
+	// A pipe becomes readable when ctx is done, so we can block on both at once.
+	var pipe [2]int
+	if err := syscall.Pipe2(pipe[:], syscall.O_CLOEXEC|syscall.O_NONBLOCK); err != nil {
+		return fmt.Errorf("pipe2 failed (%v)", err)
+	}
+	defer syscall.Close(pipe[0])
+	defer syscall.Close(pipe[1])
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		select {
+		case <-ctx.Done():
+			syscall.Write(pipe[1], []byte{0})
+		case <-stop:
+		}
+	}()
+	// The goroutine must be done with the pipe before it's closed.
+	defer wg.Wait()
+	defer close(stop)
+
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return fmt.Errorf("epoll_create1 failed (%v)", err)
+	}
+	defer syscall.Close(epfd)
+
+	for _, fd := range []int{pidfd, pipe[0]} {
+		event := syscall.EpollEvent{Events: syscall.EPOLLIN, Fd: int32(fd)}
+		if err := syscall.EpollCtl(epfd, syscall.EPOLL_CTL_ADD, fd, &event); err != nil {
+			return fmt.Errorf("epoll_ctl failed (%v)", err)
+		}
+	}
+
+	// A pidfd becomes readable when the process exits.
+	events := make([]syscall.EpollEvent, 2)
+	for {
+		n, err := syscall.EpollWait(epfd, events, -1)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("epoll_wait failed (%v)", err)
+		}
+		for _, event := range events[:n] {
+			if int(event.Fd) == pidfd {
+				return nil
+			}
+		}
+		return ctx.Err()
+	}
+}
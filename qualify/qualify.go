@@ -0,0 +1,444 @@
+// Package qualify runs a battery of checks and benchmarks against the test case of masche, to tell if a host can run
+// production sweeps before they are scheduled on it, and how fast they will read. The result is a Profile, which
+// programs can store and compare across hosts.
+package qualify
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/polyverse/masche/common"
+	"github.com/polyverse/masche/memaccess"
+	"github.com/polyverse/masche/memsearch"
+	"github.com/polyverse/masche/process"
+)
+
+// ProfileVersion is the version of the Profiles made by this version of masche. It changes when a field of a Profile
+// changes its meaning, so the programs that read stored profiles can refuse the ones they don't understand.
+const ProfileVersion = 1
+
+// Features of the Profile that aren't in the capabilities matrix.
+const (
+	// FeaturePagemap is reading the pagemap of the target, which the impact of scans and the residency of pages need.
+	FeaturePagemap = "pagemap"
+	// FeatureSoftDirty is the kernel tracking the pages written since the soft-dirty bits were cleared, which finds
+	// the memory that changed between two scans without reading it.
+	FeatureSoftDirty = "soft-dirty"
+)
+
+// Options configure the battery of Qualify. Its zero value, with a Target, is the full battery.
+type Options struct {
+	// Target is the path of the test case of masche (test/tools/test), which is launched and read by the battery.
+	Target string
+	// MapCounts are the amounts of files the targets map in the enumeration latency checks, one target for each.
+	// If it's empty they are 0, 256 and 1024.
+	MapCounts []int
+	// Enumerations is the amount of times the regions of each target are enumerated. If it's zero it's 5.
+	Enumerations int
+	// ReadBytes is the amount of bytes read from each backend to measure its throughput, reading the memory of the
+	// target again until it's reached. If it's zero it's 64 MiB.
+	ReadBytes uint64
+	// ScanBandwidth is the bandwidth the sustained scan is limited to, and ScanDuration how long it scans for. If
+	// they are zero they are 32 MiB per second and 5 seconds.
+	ScanBandwidth uint64
+	ScanDuration  time.Duration
+}
+
+// Host describes the host a Profile was made on.
+type Host struct {
+	Hostname string `json:"hostname"`
+	OS       string `json:"os"`
+	Arch     string `json:"arch"`
+	CPUs     int    `json:"cpus"`
+}
+
+// Attach is the access masche has to the target, with the privileges it ran with.
+type Attach struct {
+	AccessLevel string `json:"accessLevel"`
+	Error       string `json:"error,omitempty"`
+}
+
+// ReadThroughput is the rate at which a backend read the memory of the target.
+type ReadThroughput struct {
+	// Backend is the kind of backend: "process" reads the target with WalkBackend, "batch" reads it a page at a
+	// time with ReadBatch, and "snapshot" reads a copy of it in a StaticBackend.
+	Backend        string        `json:"backend"`
+	Bytes          uint64        `json:"bytes"`
+	Duration       time.Duration `json:"duration"`
+	BytesPerSecond float64       `json:"bytesPerSecond"`
+	Error          string        `json:"error,omitempty"`
+}
+
+// EnumerationLatency is the time it took to list the regions of a target that mapped Maps files.
+type EnumerationLatency struct {
+	Maps    int           `json:"maps"`
+	Regions int           `json:"regions"`
+	Mean    time.Duration `json:"mean"`
+	Max     time.Duration `json:"max"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// SustainedScan is the work of a scan of the target repeated for ScanDuration, limited to ScanBandwidth.
+type SustainedScan struct {
+	Bandwidth      uint64        `json:"bandwidth"`
+	Scans          int           `json:"scans"`
+	Bytes          uint64        `json:"bytes"`
+	Duration       time.Duration `json:"duration"`
+	BytesPerSecond float64       `json:"bytesPerSecond"`
+	Error          string        `json:"error,omitempty"`
+}
+
+// Profile is the result of Qualify: what masche can do on a host, and how fast. A check that failed has its Error
+// set, and the rest of the battery still runs.
+type Profile struct {
+	// Version is ProfileVersion.
+	Version  int           `json:"version"`
+	Host     Host          `json:"host"`
+	Started  time.Time     `json:"started"`
+	Duration time.Duration `json:"duration"`
+	// Capabilities is the capabilities matrix of the host, and TargetCapabilities the one of the target.
+	Capabilities       []common.Capability `json:"capabilities"`
+	TargetCapabilities []common.Capability `json:"targetCapabilities"`
+	Attach             Attach              `json:"attach"`
+	// Pagemap and SoftDirty are the availability of FeaturePagemap on the target and of FeatureSoftDirty on the
+	// host.
+	Pagemap     common.Capability    `json:"pagemap"`
+	SoftDirty   common.Capability    `json:"softDirty"`
+	Reads       []ReadThroughput     `json:"reads"`
+	Enumeration []EnumerationLatency `json:"enumeration"`
+	Scan        SustainedScan        `json:"scan"`
+	// Problems are the softerrors of the battery, for the profiles read without them.
+	Problems []string `json:"problems,omitempty"`
+}
+
+// Qualify runs the battery on this host and returns its Profile. It launches the target of opts, and kills it when
+// it's done. A harderror is returned if the options are invalid or the target can't be launched; the checks that fail
+// are reported in the Profile and as softerrors.
+//
+// If ctx is cancelled the checks left are skipped, and ctx's error is returned with the Profile made so far.
+func Qualify(ctx context.Context, opts Options) (profile Profile, harderror error, softerrors []error) {
+	profile = Profile{Version: ProfileVersion, Started: time.Now()}
+	if opts.Target == "" {
+		return profile, fmt.Errorf("No target to qualify the host with"), nil
+	}
+	if opts.MapCounts == nil {
+		opts.MapCounts = []int{0, 256, 1024}
+	}
+	if opts.Enumerations == 0 {
+		opts.Enumerations = 5
+	}
+	if opts.ReadBytes == 0 {
+		opts.ReadBytes = 64 << 20
+	}
+	if opts.ScanBandwidth == 0 {
+		opts.ScanBandwidth = 32 << 20
+	}
+	if opts.ScanDuration == 0 {
+		opts.ScanDuration = 5 * time.Second
+	}
+	for _, maps := range opts.MapCounts {
+		if maps < 0 {
+			return profile, fmt.Errorf("Invalid map count %d", maps), nil
+		}
+	}
+
+	hostname, _ := os.Hostname()
+	profile.Host = Host{Hostname: hostname, OS: runtime.GOOS, Arch: runtime.GOARCH, CPUs: runtime.NumCPU()}
+	profile.Capabilities = common.Capabilities()
+	availability, reason := softDirtyProbe()
+	profile.SoftDirty = common.Capability{Feature: FeatureSoftDirty, Availability: availability, Reason: reason}
+
+	if ctx.Err() != nil {
+		return profile, ctx.Err(), nil
+	}
+	cmd, harderror := launch(opts.Target)
+	if harderror != nil {
+		return profile, harderror, nil
+	}
+	defer kill(cmd)
+	pid := cmd.Process.Pid
+
+	profile.TargetCapabilities = common.ProcessCapabilities(pid)
+	profile.Pagemap = common.Capability{Feature: FeaturePagemap, Availability: common.UnsupportedPlatform}
+	if c, ok := common.FindCapability(profile.TargetCapabilities, memsearch.CapabilityImpact); ok {
+		profile.Pagemap = common.Capability{Feature: FeaturePagemap, Availability: c.Availability, Reason: c.Reason}
+	}
+
+	p, harderror, serrs := process.OpenFromPid(pid)
+	softerrors = append(softerrors, serrs...)
+	if harderror != nil {
+		profile.Attach = Attach{AccessLevel: process.NoAccess.String(), Error: harderror.Error()}
+		softerrors = append(softerrors, harderror)
+	} else {
+		defer p.Close()
+		profile.Attach, serrs = attach(p)
+		softerrors = append(softerrors, serrs...)
+	}
+
+	if p != nil && ctx.Err() == nil {
+		var serrs []error
+		profile.Reads, serrs = readThroughputs(p, opts.ReadBytes)
+		softerrors = append(softerrors, serrs...)
+	}
+	if ctx.Err() == nil {
+		var serrs []error
+		profile.Enumeration, serrs = enumerationLatencies(ctx, opts)
+		softerrors = append(softerrors, serrs...)
+	}
+	if p != nil && ctx.Err() == nil {
+		var err error
+		profile.Scan, err = sustainedScan(ctx, p, opts)
+		if err != nil {
+			softerrors = append(softerrors, err)
+		}
+	}
+
+	for _, err := range softerrors {
+		profile.Problems = append(profile.Problems, err.Error())
+	}
+	profile.Duration = time.Since(profile.Started)
+	return profile, ctx.Err(), softerrors
+}
+
+// launch starts the target with args, and waits until it's initialized: the target closes its stdout then.
+func launch(target string, args ...string) (*exec.Cmd, error) {
+	cmd := exec.Command(target, args...)
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("Unable to launch the target %s (%w)", target, err)
+	}
+	io.Copy(ioutil.Discard, out)
+	return cmd, nil
+}
+
+func kill(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+	cmd.Wait()
+}
+
+func attach(p process.Process) (attach Attach, softerrors []error) {
+	level, harderror, softerrors := p.AccessLevel()
+	if harderror != nil {
+		return Attach{AccessLevel: process.NoAccess.String(), Error: harderror.Error()}, append(softerrors, harderror)
+	}
+	return Attach{AccessLevel: level.String()}, softerrors
+}
+
+// readThroughputs reads at least limit bytes of the target from each backend. The softerrors of the walks, like the
+// regions that can't be read, are left out: they are the same on every host.
+func readThroughputs(p process.Process, limit uint64) (reads []ReadThroughput, softerrors []error) {
+	b := memaccess.ProcessBackend(p)
+	reads = append(reads, timeReads("process", limit, func(read func(n int) bool) error {
+		harderror, _ := memaccess.WalkBackendWithStrategy(b, 0, memaccess.IOStrategy{},
+			func(address uintptr, buf []byte) bool {
+				return read(len(buf))
+			})
+		return harderror
+	}))
+
+	segments, harderror := snapshot(b)
+	if harderror != nil {
+		softerrors = append(softerrors, harderror)
+		return reads, softerrors
+	}
+
+	var pages []memaccess.ReadRequest
+	for _, segment := range segments {
+		for offset := uint(0); offset < segment.Region.Size; offset += pageSize {
+			pages = append(pages, memaccess.ReadRequest{Address: segment.Region.Address + uintptr(offset),
+				Size: pageSize})
+		}
+	}
+	reads = append(reads, timeReads("batch", limit, func(read func(n int) bool) error {
+		results, harderror, _ := memaccess.ReadBatch(p, pages)
+		for _, result := range results {
+			if !read(len(result.Data)) {
+				break
+			}
+		}
+		return harderror
+	}))
+
+	static, harderror := memaccess.NewStaticBackend(memaccess.BackendInfo{Kind: "snapshot", Pid: p.Pid()}, segments)
+	if harderror != nil {
+		softerrors = append(softerrors, harderror)
+		return reads, softerrors
+	}
+	reads = append(reads, timeReads("snapshot", limit, func(read func(n int) bool) error {
+		harderror, _ := memaccess.WalkBackendWithStrategy(static, 0, memaccess.IOStrategy{},
+			func(address uintptr, buf []byte) bool {
+				return read(len(buf))
+			})
+		return harderror
+	}))
+
+	for _, r := range reads {
+		if r.Error != "" {
+			softerrors = append(softerrors, fmt.Errorf("Unable to read from the %s backend (%s)", r.Backend, r.Error))
+		}
+	}
+	return reads, softerrors
+}
+
+// pageSize is the size of the reads of the batch backend.
+const pageSize = 4096
+
+// timeReads calls pass until limit bytes were read by it, and returns the rate they were read at. pass calls read
+// with the amount of bytes read each time, and stops reading when it returns false.
+func timeReads(backend string, limit uint64, pass func(read func(n int) bool) error) ReadThroughput {
+	result := ReadThroughput{Backend: backend}
+	start := time.Now()
+	for result.Bytes < limit {
+		before := result.Bytes
+		err := pass(func(n int) bool {
+			result.Bytes += uint64(n)
+			return result.Bytes < limit
+		})
+		if err != nil {
+			result.Error = err.Error()
+			break
+		}
+		if result.Bytes == before {
+			result.Error = "nothing could be read"
+			break
+		}
+	}
+	result.Duration = time.Since(start)
+	if result.Duration > 0 {
+		result.BytesPerSecond = float64(result.Bytes) / result.Duration.Seconds()
+	}
+	return result
+}
+
+// snapshot copies the readable regions of b that can be read whole.
+func snapshot(b memaccess.MemoryBackend) (segments []memaccess.Segment, harderror error) {
+	regions, harderror, _ := b.Regions()
+	if harderror != nil {
+		return nil, harderror
+	}
+	for _, region := range regions {
+		if region.Access&memaccess.Readable == 0 || memsearch.SkipKernelMappings(region) {
+			continue
+		}
+		data := make([]byte, region.Size)
+		if harderror, _ := b.ReadAt(region.Address, data); harderror != nil {
+			continue
+		}
+		segments = append(segments, memaccess.Segment{Region: region, Data: data})
+	}
+	if len(segments) == 0 {
+		return nil, fmt.Errorf("No region of %v could be read", b.Info())
+	}
+	return segments, nil
+}
+
+// enumerationLatencies launches a target for each map count of opts, and times listing its regions.
+func enumerationLatencies(ctx context.Context, opts Options) (latencies []EnumerationLatency, softerrors []error) {
+	dir, err := ioutil.TempDir("", "masche-qualify")
+	if err != nil {
+		return nil, []error{fmt.Errorf("Unable to create the files mapped by the targets (%v)", err)}
+	}
+	defer os.RemoveAll(dir)
+	mapped := filepath.Join(dir, "mapped")
+	if err := ioutil.WriteFile(mapped, make([]byte, pageSize), 0644); err != nil {
+		return nil, []error{fmt.Errorf("Unable to create the files mapped by the targets (%v)", err)}
+	}
+
+	for _, maps := range opts.MapCounts {
+		if ctx.Err() != nil {
+			break
+		}
+		latency := enumerationLatency(opts.Target, mapped, maps, opts.Enumerations)
+		if latency.Error != "" {
+			softerrors = append(softerrors, fmt.Errorf("Unable to time the enumeration of %d maps (%s)", maps,
+				latency.Error))
+		}
+		latencies = append(latencies, latency)
+	}
+	return latencies, softerrors
+}
+
+func enumerationLatency(target string, mapped string, maps int, times int) (latency EnumerationLatency) {
+	latency.Maps = maps
+	var args []string
+	for i := 0; i < maps; i++ {
+		args = append(args, "--map", mapped)
+	}
+	cmd, err := launch(target, args...)
+	if err != nil {
+		latency.Error = err.Error()
+		return latency
+	}
+	defer kill(cmd)
+
+	p, harderror, _ := process.OpenFromPid(cmd.Process.Pid)
+	if harderror != nil {
+		latency.Error = harderror.Error()
+		return latency
+	}
+	defer p.Close()
+
+	var total time.Duration
+	for i := 0; i < times; i++ {
+		start := time.Now()
+		regions, harderror, _ := memaccess.MemoryRegions(p)
+		elapsed := time.Since(start)
+		if harderror != nil {
+			latency.Error = harderror.Error()
+			return latency
+		}
+		latency.Regions = len(regions)
+		total += elapsed
+		if elapsed > latency.Max {
+			latency.Max = elapsed
+		}
+	}
+	if times > 0 {
+		latency.Mean = total / time.Duration(times)
+	}
+	return latency
+}
+
+// scanPattern is searched by the sustained scan. It isn't in the target, so every scan reads all of its memory.
+var scanPattern = []memsearch.Pattern{{Bytes: []byte("masche qualification pattern")}}
+
+// sustainedScan scans the target again and again for ScanDuration, with an Executor limited to ScanBandwidth.
+func sustainedScan(ctx context.Context, p process.Process, opts Options) (scan SustainedScan, err error) {
+	scan.Bandwidth = opts.ScanBandwidth
+	executor := memsearch.NewExecutor(memsearch.ExecutorOptions{Workers: 1, BytesPerSecond: opts.ScanBandwidth})
+	start := time.Now()
+	for ctx.Err() == nil {
+		left := opts.ScanDuration - time.Since(start)
+		if left <= 0 {
+			break
+		}
+		_, stats, harderror, _ := memsearch.FindAll(p, 0, scanPattern, memsearch.SearchOptions{
+			Executor:     executor,
+			MaxDuration:  left,
+			SkipRegion:   memsearch.SkipKernelMappings,
+			Verification: memsearch.NoVerification,
+		})
+		if harderror != nil {
+			scan.Error = harderror.Error()
+			err = fmt.Errorf("Unable to run the sustained scan (%w)", harderror)
+			break
+		}
+		scan.Scans++
+		scan.Bytes += stats.BytesScanned
+	}
+	scan.Duration = time.Since(start)
+	if scan.Duration > 0 {
+		scan.BytesPerSecond = float64(scan.Bytes) / scan.Duration.Seconds()
+	}
+	return scan, err
+}
@@ -0,0 +1,128 @@
+package qualify
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/polyverse/masche/common"
+	"github.com/polyverse/masche/memaccess"
+	"github.com/polyverse/masche/test"
+)
+
+// abbreviated is a battery that runs in a couple of seconds.
+func abbreviated() Options {
+	return Options{
+		Target:        test.GetTestCasePath(),
+		MapCounts:     []int{0, 32},
+		Enumerations:  2,
+		ReadBytes:     4 << 20,
+		ScanBandwidth: 8 << 20,
+		ScanDuration:  500 * time.Millisecond,
+	}
+}
+
+func TestQualify(t *testing.T) {
+	profile, harderror, softerrors := Qualify(context.Background(), abbreviated())
+	test.PrintSoftErrors(softerrors)
+	if harderror != nil {
+		t.Fatal(harderror)
+	}
+	if len(profile.Problems) != len(softerrors) {
+		t.Errorf("%d problems for %d softerrors", len(profile.Problems), len(softerrors))
+	}
+
+	if profile.Version != ProfileVersion || profile.Host.OS == "" || profile.Host.CPUs == 0 ||
+		profile.Duration <= 0 {
+		t.Errorf("Unexpected header of the profile: %+v", profile)
+	}
+	if _, ok := common.FindCapability(profile.Capabilities, memaccess.CapabilityMemoryRead); !ok {
+		t.Error("The capabilities of the host are missing")
+	}
+	readable, ok := common.FindCapability(profile.TargetCapabilities, memaccess.CapabilityMemoryRead)
+	if !ok || readable.Availability != common.Available {
+		t.Fatalf("The memory of the target can't be read: %v", readable)
+	}
+	if profile.Attach.AccessLevel != "FullAccess" || profile.Attach.Error != "" {
+		t.Errorf("Unexpected attach check %+v", profile.Attach)
+	}
+	if profile.Pagemap.Feature != FeaturePagemap || profile.SoftDirty.Feature != FeatureSoftDirty {
+		t.Errorf("Unexpected pagemap and soft-dirty checks %v, %v", profile.Pagemap, profile.SoftDirty)
+	}
+
+	backends := map[string]bool{}
+	for _, read := range profile.Reads {
+		backends[read.Backend] = true
+		if read.Error != "" || read.Bytes < 4<<20 || read.BytesPerSecond <= 0 {
+			t.Errorf("Unexpected throughput %+v", read)
+		}
+	}
+	if len(backends) != 3 || !backends["process"] || !backends["batch"] || !backends["snapshot"] {
+		t.Errorf("Unexpected backends %v", backends)
+	}
+
+	if len(profile.Enumeration) != 2 {
+		t.Fatalf("%d enumeration latencies, expected 2", len(profile.Enumeration))
+	}
+	for i, latency := range profile.Enumeration {
+		if latency.Maps != abbreviated().MapCounts[i] || latency.Error != "" || latency.Regions == 0 ||
+			latency.Mean <= 0 || latency.Max < latency.Mean {
+			t.Errorf("Unexpected latency %+v", latency)
+		}
+	}
+	if profile.Enumeration[1].Regions < profile.Enumeration[0].Regions+32 {
+		t.Errorf("Mapping 32 files went from %d to %d regions", profile.Enumeration[0].Regions,
+			profile.Enumeration[1].Regions)
+	}
+
+	scan := profile.Scan
+	if scan.Error != "" || scan.Scans == 0 || scan.Bytes == 0 || scan.Bandwidth != 8<<20 {
+		t.Errorf("Unexpected sustained scan %+v", scan)
+	}
+	// A region can be read faster than the bandwidth, but the scan can't be twice as fast for long.
+	if scan.Duration < 500*time.Millisecond || scan.BytesPerSecond > 2*float64(scan.Bandwidth) {
+		t.Errorf("The sustained scan didn't keep to its bandwidth: %+v", scan)
+	}
+
+	data, err := json.Marshal(profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"version", "host", "capabilities", "targetCapabilities", "attach", "pagemap",
+		"softDirty", "reads", "enumeration", "scan"} {
+		if _, ok := decoded[key]; !ok {
+			t.Errorf("The JSON profile lacks %s: %s", key, data)
+		}
+	}
+}
+
+func TestQualifyErrors(t *testing.T) {
+	if _, harderror, _ := Qualify(context.Background(), Options{}); harderror == nil {
+		t.Error("Qualified without a target")
+	}
+	opts := abbreviated()
+	opts.MapCounts = []int{-1}
+	if _, harderror, _ := Qualify(context.Background(), opts); harderror == nil {
+		t.Error("Qualified with a negative map count")
+	}
+	opts = abbreviated()
+	opts.Target = "/nonexistent/target"
+	if _, harderror, _ := Qualify(context.Background(), opts); harderror == nil {
+		t.Error("Qualified without launching the target")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	profile, harderror, _ := Qualify(ctx, abbreviated())
+	if harderror != context.Canceled {
+		t.Errorf("Got %v from a cancelled battery", harderror)
+	}
+	if profile.Version != ProfileVersion || profile.Reads != nil || profile.Enumeration != nil {
+		t.Errorf("A cancelled battery ran its checks: %+v", profile)
+	}
+}
@@ -0,0 +1,49 @@
+package qualify
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"unsafe"
+
+	"github.com/polyverse/masche/common"
+)
+
+// pagemapSoftDirty is the bit of a /proc/PID/pagemap entry that tells if the page was written since the soft-dirty
+// bits were cleared.
+const pagemapSoftDirty = 1 << 55
+
+// softDirtyProbe clears the soft-dirty bits of this process, writes a page and checks that its bit is set again.
+// Kernels built without CONFIG_MEM_SOFT_DIRTY accept the clearing, but never set the bit.
+func softDirtyProbe() (common.Availability, string) {
+	self := uint(os.Getpid())
+	clearRefs := common.ProcFilePath(self, "clear_refs")
+	if err := ioutil.WriteFile(clearRefs, []byte("4"), 0); err != nil {
+		if os.IsNotExist(err) {
+			return common.UnsupportedKernel, fmt.Sprintf("the kernel lacks %s", clearRefs)
+		}
+		return common.NeedsPrivilege, err.Error()
+	}
+
+	size := uintptr(os.Getpagesize())
+	page := make([]byte, 2*size)
+	address := (uintptr(unsafe.Pointer(&page[0])) + size - 1) &^ (size - 1)
+	page[address-uintptr(unsafe.Pointer(&page[0]))] = 1
+	defer runtime.KeepAlive(page)
+
+	pagemap, err := os.Open(common.PagemapFilePathFromPid(self))
+	if err != nil {
+		return common.NeedsPrivilege, err.Error()
+	}
+	defer pagemap.Close()
+	entry := make([]byte, 8)
+	if _, err := pagemap.ReadAt(entry, int64(address/size)*8); err != nil {
+		return common.NeedsPrivilege, err.Error()
+	}
+	if binary.LittleEndian.Uint64(entry)&pagemapSoftDirty == 0 {
+		return common.UnsupportedKernel, "the kernel doesn't track soft-dirty pages"
+	}
+	return common.Available, ""
+}
@@ -0,0 +1,11 @@
+// +build windows darwin
+
+package qualify
+
+import (
+	"github.com/polyverse/masche/common"
+)
+
+func softDirtyProbe() (common.Availability, string) {
+	return common.UnsupportedPlatform, "soft-dirty bits are only tracked on linux"
+}
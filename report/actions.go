@@ -0,0 +1,281 @@
+package report
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/polyverse/masche/common"
+	"github.com/polyverse/masche/memaccess"
+	"github.com/polyverse/masche/memsearch"
+	"github.com/polyverse/masche/process"
+)
+
+// ActionKind is one of the built-in actions that ScanWithActions can run on hits.
+type ActionKind string
+
+const (
+	// DumpRegion writes the region containing the hit to a file.
+	DumpRegion ActionKind = "dump-region"
+	// CaptureProcessSnapshot records the facts of the process (see process.GatherFacts) in the outcome.
+	CaptureProcessSnapshot ActionKind = "capture-process-snapshot"
+	// SuspendProcess stops the process with process.Process.Suspend, so it can be inspected before it changes. The
+	// process stays stopped after the scan, until it's resumed; only if the scan fails or panics are the processes it
+	// suspended resumed.
+	SuspendProcess ActionKind = "suspend-process"
+	// RunCallback calls the Callback of the action.
+	RunCallback ActionKind = "run-callback"
+)
+
+// DefaultDumpNameTemplate is the template of the files written by DumpRegion when the action doesn't have one.
+const DefaultDumpNameTemplate = `{{.Pid}}-{{printf "%x" .Region.Address}}.bin`
+
+// Action is something done on the hits of a process as soon as it's scanned, before the next process is.
+type Action struct {
+	Kind ActionKind
+	// Name identifies the action in its outcomes. If it's empty the kind is used.
+	Name string
+	// FirstHitOnly runs the action on the first hit of each process only, instead of on every hit.
+	FirstHitOnly bool
+	// Limit is the most times the action runs in a scan, and MinInterval the least time between two of them. The
+	// runs over the limits are recorded as rate limited outcomes. Zero values don't limit.
+	Limit       int
+	MinInterval time.Duration
+
+	// Directory is where DumpRegion writes the regions, and NameTemplate the text/template of the names of the files,
+	// executed with a DumpName. If it's empty DefaultDumpNameTemplate is used. Slashes in the names are replaced with
+	// underscores. Each region is dumped once.
+	Directory    string
+	NameTemplate string
+
+	// Callback is called by RunCallback.
+	Callback func(p process.Process, hit Hit) error
+}
+
+// DumpName are the values available to the NameTemplate of a DumpRegion action.
+type DumpName struct {
+	Pid        int
+	Executable string
+	Hit        Hit
+	Region     memaccess.MemoryRegion
+}
+
+// ActionOutcome is the result of running an action on a hit.
+type ActionOutcome struct {
+	Action  string    `json:"action"`
+	Pid     int       `json:"pid"`
+	Address uintptr   `json:"address"`
+	Time    time.Time `json:"time"`
+	// Path is the file written by DumpRegion.
+	Path string `json:"path,omitempty"`
+	// Snapshot are the facts of the process captured by CaptureProcessSnapshot.
+	Snapshot process.Facts `json:"snapshot,omitempty"`
+	// RateLimited is true if the action didn't run because of its Limit or MinInterval.
+	RateLimited bool `json:"rateLimited,omitempty"`
+	// Error is why the action failed, if it did.
+	Error string `json:"error,omitempty"`
+}
+
+// actor runs the actions of a scan, and keeps their rate limits.
+type actor struct {
+	actions   []Action
+	templates []*template.Template
+	runs      []int
+	last      []time.Time
+	// dumped are the files already written by each action, by pid and region.
+	dumped []map[[2]uintptr]string
+	// suspended are the pids of the processes stopped by SuspendProcess actions.
+	suspended []int
+	// Regions are dumped in chunks of bufferSize bytes, whose buffer is charged to the budget of the scan.
+	bufferSize uint
+	budget     *memsearch.MemoryBudget
+}
+
+func newActor(actions []Action, opts memsearch.SearchOptions) (*actor, error) {
+	a := &actor{
+		actions:    actions,
+		templates:  make([]*template.Template, len(actions)),
+		runs:       make([]int, len(actions)),
+		last:       make([]time.Time, len(actions)),
+		dumped:     make([]map[[2]uintptr]string, len(actions)),
+		bufferSize: opts.BufferSize,
+		budget:     opts.Budget,
+	}
+	if a.bufferSize == 0 {
+		a.bufferSize = memsearch.DefaultBufferSize
+	}
+	for i, action := range actions {
+		switch action.Kind {
+		case DumpRegion:
+			if action.Directory == "" {
+				return nil, fmt.Errorf("Action %d dumps regions without a directory", i)
+			}
+			text := action.NameTemplate
+			if text == "" {
+				text = DefaultDumpNameTemplate
+			}
+			t, err := template.New(action.name()).Parse(text)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid name template of action %d: %v", i, err)
+			}
+			a.templates[i] = t
+			a.dumped[i] = make(map[[2]uintptr]string)
+		case RunCallback:
+			if action.Callback == nil {
+				return nil, fmt.Errorf("Action %d runs a callback without a Callback", i)
+			}
+		case CaptureProcessSnapshot, SuspendProcess:
+		default:
+			return nil, fmt.Errorf("Unknown kind of action %q", action.Kind)
+		}
+	}
+	return a, nil
+}
+
+func (action Action) name() string {
+	if action.Name != "" {
+		return action.Name
+	}
+	return string(action.Kind)
+}
+
+// act runs the actions on the hits of a process. The failures of the actions are returned as softerrors, and never
+// stop the scan.
+func (a *actor) act(p process.Process, pr ProcessReport) (outcomes []ActionOutcome, softerrors []error) {
+	for i, action := range a.actions {
+		for j, hit := range pr.Hits {
+			if action.FirstHitOnly && j > 0 {
+				break
+			}
+			outcome := ActionOutcome{Action: action.name(), Pid: pr.Pid, Address: hit.Match.Address, Time: time.Now()}
+			if action.Limit > 0 && a.runs[i] >= action.Limit ||
+				action.MinInterval > 0 && !a.last[i].IsZero() && outcome.Time.Sub(a.last[i]) < action.MinInterval {
+
+				outcome.RateLimited = true
+				outcomes = append(outcomes, outcome)
+				continue
+			}
+			a.runs[i]++
+			a.last[i] = outcome.Time
+
+			err, serrs := a.run(i, p, pr, hit, &outcome)
+			softerrors = append(softerrors, serrs...)
+			if err != nil {
+				outcome.Error = err.Error()
+				softerrors = append(softerrors, &common.LocatedError{Pid: pr.Pid, Address: hit.Match.Address,
+					Err: fmt.Errorf("Action %s failed: %v", action.name(), err)})
+			}
+			outcomes = append(outcomes, outcome)
+		}
+	}
+	return outcomes, softerrors
+}
+
+func (a *actor) run(i int, p process.Process, pr ProcessReport, hit Hit, outcome *ActionOutcome) (
+	harderror error, softerrors []error) {
+
+	defer func() {
+		if r := recover(); r != nil {
+			harderror = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	action := a.actions[i]
+	switch action.Kind {
+	case DumpRegion:
+		outcome.Path, harderror, softerrors = a.dump(i, p, pr, hit)
+	case CaptureProcessSnapshot:
+		outcome.Snapshot, harderror, softerrors = process.GatherFacts(p)
+	case SuspendProcess:
+		if harderror, softerrors = p.Suspend(); harderror == nil {
+			a.suspended = append(a.suspended, p.Pid())
+		}
+	case RunCallback:
+		harderror = action.Callback(p, hit)
+	}
+	return harderror, softerrors
+}
+
+// abort resumes the processes suspended by the actions, when the scan fails. Failures are returned as softerrors.
+func (a *actor) abort() (softerrors []error) {
+	for _, pid := range a.suspended {
+		err, serrs := process.GetProcess(pid).Resume()
+		softerrors = append(softerrors, serrs...)
+		if err != nil {
+			softerrors = append(softerrors, &common.LocatedError{Pid: pid,
+				Err: fmt.Errorf("Unable to resume process %d: %v", pid, err)})
+		}
+	}
+	a.suspended = nil
+	return softerrors
+}
+
+// dump writes the region containing hit to a file, unless the action already did. The region is copied in chunks;
+// the chunks that can't be read are left as zeros in the file and reported as softerrors.
+func (a *actor) dump(i int, p process.Process, pr ProcessReport, hit Hit) (path string, harderror error,
+	softerrors []error) {
+
+	region := hit.Match.Region
+	key := [2]uintptr{uintptr(pr.Pid), region.Address}
+	if path, ok := a.dumped[i][key]; ok {
+		return path, nil, nil
+	}
+
+	var name bytes.Buffer
+	err := a.templates[i].Execute(&name, DumpName{Pid: pr.Pid, Executable: pr.Executable, Hit: hit, Region: region})
+	if err != nil {
+		return "", err, nil
+	}
+	path = filepath.Join(a.actions[i].Directory, strings.ReplaceAll(name.String(), "/", "_"))
+
+	bufSize, err := a.budget.ReserveBuffer(a.bufferSize, 0)
+	if err != nil {
+		return "", err, nil
+	}
+	defer a.budget.Release(uint64(bufSize))
+	buf := make([]byte, bufSize)
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return "", err, nil
+	}
+	defer func() {
+		if err := f.Close(); err != nil && harderror == nil {
+			path, harderror = "", err
+		}
+	}()
+
+	read := false
+	for offset := uint(0); offset < region.Size; offset += bufSize {
+		chunk := buf
+		if region.Size-offset < bufSize {
+			chunk = buf[:region.Size-offset]
+		}
+		address := region.Address + uintptr(offset)
+		err, serrs := memaccess.CopyMemory(p, address, chunk)
+		softerrors = append(softerrors, serrs...)
+		if err != nil {
+			softerrors = append(softerrors, &common.LocatedError{Pid: pr.Pid, Address: address,
+				Err: fmt.Errorf("Unable to dump %d bytes: %v", len(chunk), err)})
+			continue
+		}
+		read = true
+		if _, err := f.WriteAt(chunk, int64(offset)); err != nil {
+			return "", err, softerrors
+		}
+	}
+	if !read {
+		os.Remove(path)
+		return "", fmt.Errorf("Unable to read any of the region at 0x%x", region.Address), softerrors
+	}
+	// The unreadable chunks at the end are zeros too.
+	if err := f.Truncate(int64(region.Size)); err != nil {
+		return "", err, softerrors
+	}
+	a.dumped[i][key] = path
+	return path, nil, softerrors
+}
@@ -0,0 +1,139 @@
+package report
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/polyverse/masche/lease"
+)
+
+// CoordinationMode tells what a sweep does when another instance holds the lease.
+type CoordinationMode string
+
+const (
+	// WaitForLease waits until the lease is free, and holds it for the whole sweep.
+	WaitForLease CoordinationMode = "wait"
+	// SkipIfLeased doesn't scan anything if another instance holds the lease, and reports who holds it.
+	SkipIfLeased CoordinationMode = "skip"
+	// Interleave takes the lease for each process and frees it afterwards, so the sweeps of many instances take turns.
+	Interleave CoordinationMode = "interleave"
+)
+
+// Defaults of the Coordination options.
+const (
+	DefaultLeaseTTL  = time.Minute
+	DefaultLeasePoll = time.Second
+)
+
+// Coordination makes a sweep take a host-wide lease (see package lease), so it doesn't scan at the same time as
+// other instances of masche. It's advisory: instances that don't use it aren't stopped.
+type Coordination struct {
+	Mode CoordinationMode
+	// Path is the lease file. If it's empty lease.DefaultPath is used.
+	Path string
+	// Owner names this instance in the lease. If it's empty the name of the executable is used.
+	Owner string
+	// TTL is how long the lease is held without renewing it. It's renewed before each process, so it must be longer
+	// than the scan of the largest process. If it's zero DefaultLeaseTTL is used.
+	TTL time.Duration
+	// Poll is how often a busy lease is tried again, and MaxWait the longest a sweep waits for it before failing.
+	// If Poll is zero DefaultLeasePoll is used, and if MaxWait is zero it waits forever.
+	Poll    time.Duration
+	MaxWait time.Duration
+}
+
+// coordinator holds the lease of a sweep.
+type coordinator struct {
+	c     Coordination
+	lease *lease.Lease
+}
+
+func newCoordinator(c *Coordination) (*coordinator, error) {
+	if c == nil {
+		return nil, nil
+	}
+	co := &coordinator{c: *c}
+	switch c.Mode {
+	case WaitForLease, SkipIfLeased, Interleave:
+	default:
+		return nil, fmt.Errorf("Unknown coordination mode %q", c.Mode)
+	}
+	if co.c.Path == "" {
+		co.c.Path = lease.DefaultPath
+	}
+	if co.c.Owner == "" {
+		co.c.Owner = os.Args[0]
+	}
+	if co.c.TTL == 0 {
+		co.c.TTL = DefaultLeaseTTL
+	}
+	if co.c.Poll == 0 {
+		co.c.Poll = DefaultLeasePoll
+	}
+	return co, nil
+}
+
+// start takes the lease before the sweep. It returns the holder of the lease if the sweep must be skipped.
+func (co *coordinator) start() (skippedBy *lease.Info, err error) {
+	if co == nil || co.c.Mode != SkipIfLeased {
+		return nil, nil
+	}
+	co.lease, err = lease.Acquire(co.c.Path, co.c.Owner, co.c.TTL)
+	var held *lease.HeldError
+	if errors.As(err, &held) {
+		return &held.Holder, nil
+	}
+	return nil, err
+}
+
+// hold makes sure the lease is held before scanning the process with the given pid.
+func (co *coordinator) hold(target int) error {
+	if co == nil {
+		return nil
+	}
+	if co.lease != nil {
+		err := co.lease.Renew(target)
+		if !errors.Is(err, lease.ErrHeld) || co.c.Mode == SkipIfLeased {
+			return err
+		}
+		// Another instance took the lease after it expired, wait for it again.
+		co.lease = nil
+	}
+
+	ctx := context.Background()
+	if co.c.MaxWait > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, co.c.MaxWait)
+		defer cancel()
+	}
+	l, err := lease.Wait(ctx, co.c.Path, co.c.Owner, co.c.TTL, co.c.Poll)
+	if err != nil {
+		return fmt.Errorf("Unable to take the lease %s: %w", co.c.Path, err)
+	}
+	co.lease = l
+	return l.Renew(target)
+}
+
+// done is called after scanning each process. Failures are returned as softerrors, as the lease expires anyway.
+func (co *coordinator) done() (softerrors []error) {
+	if co == nil || co.c.Mode != Interleave {
+		return nil
+	}
+	return co.release()
+}
+
+// release frees the lease, if it's held.
+func (co *coordinator) release() (softerrors []error) {
+	if co == nil || co.lease == nil {
+		return nil
+	}
+	err := co.lease.Release()
+	co.lease = nil
+	if err != nil {
+		return []error{fmt.Errorf("Unable to release the lease %s: %v", co.c.Path, err)}
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package report
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Categories of hits in a ScanDiff.
+const (
+	// Persisted hits are in both runs.
+	Persisted = "persisted"
+	// New hits are only in the new run.
+	New = "new"
+	// Resolved hits are only in the old run.
+	Resolved = "resolved"
+)
+
+// HitChange is a hit categorized by Diff.
+type HitChange struct {
+	Pid        int    `json:"pid"`
+	Executable string `json:"executable"`
+	Category   string `json:"category"`
+	// Hit is the one of the new run, except for resolved hits.
+	Hit Hit `json:"hit"`
+}
+
+// ProcessChange is a process that is only in one of the runs.
+type ProcessChange struct {
+	Pid        int    `json:"pid"`
+	Executable string `json:"executable"`
+	Hits       int    `json:"hits"`
+}
+
+// ScanDiff is the result of Diff.
+type ScanDiff struct {
+	Persisted []HitChange `json:"persisted"`
+	New       []HitChange `json:"new"`
+	Resolved  []HitChange `json:"resolved"`
+	// NewProcesses and ExitedProcesses are the processes with hits that are only in the new or the old run. Their
+	// hits are categorized as new or resolved too.
+	NewProcesses    []ProcessChange `json:"newProcesses"`
+	ExitedProcesses []ProcessChange `json:"exitedProcesses"`
+}
+
+// processKey identifies a process across runs. A restarted process is a different one, even if it runs the same
+// executable.
+type processKey struct {
+	pid        int
+	executable string
+}
+
+// Diff compares the hits of an old run and the current one, and categorizes them as persisted, new or resolved.
+//
+// Processes are the same one in both runs if they have the same pid and executable. Hits in file backed memory are
+// the same one if they are of the same pattern at the same offset of the same module, so they are found even if the
+// module was loaded at a different address. Hits in anonymous memory are the same one if they are of the same pattern,
+// in the same class of region and with the same bytes around them.
+func Diff(old, current ScanReport) (diff ScanDiff) {
+	oldProcesses := make(map[processKey]ProcessReport)
+	for _, pr := range old.Processes {
+		oldProcesses[processKey{pr.Pid, pr.Executable}] = pr
+	}
+
+	seen := make(map[processKey]bool)
+	for _, newReport := range current.Processes {
+		key := processKey{newReport.Pid, newReport.Executable}
+		seen[key] = true
+		oldReport, ok := oldProcesses[key]
+		if !ok && len(newReport.Hits) > 0 {
+			diff.NewProcesses = append(diff.NewProcesses, ProcessChange{Pid: newReport.Pid,
+				Executable: newReport.Executable, Hits: len(newReport.Hits)})
+		}
+		diff.diffProcess(oldReport.Hits, newReport)
+	}
+
+	for _, oldReport := range old.Processes {
+		key := processKey{oldReport.Pid, oldReport.Executable}
+		if seen[key] {
+			continue
+		}
+		if len(oldReport.Hits) > 0 {
+			diff.ExitedProcesses = append(diff.ExitedProcesses, ProcessChange{Pid: oldReport.Pid,
+				Executable: oldReport.Executable, Hits: len(oldReport.Hits)})
+		}
+		diff.diffProcess(oldReport.Hits, ProcessReport{Pid: oldReport.Pid, Executable: oldReport.Executable})
+	}
+	return diff
+}
+
+// diffProcess categorizes the hits of a process. Hits with the same key are paired in order.
+func (diff *ScanDiff) diffProcess(oldHits []Hit, newReport ProcessReport) {
+	pending := make(map[string][]Hit)
+	for _, hit := range oldHits {
+		pending[hit.key()] = append(pending[hit.key()], hit)
+	}
+
+	change := func(category string, hit Hit) HitChange {
+		return HitChange{Pid: newReport.Pid, Executable: newReport.Executable, Category: category, Hit: hit}
+	}
+	for _, hit := range newReport.Hits {
+		key := hit.key()
+		if len(pending[key]) > 0 {
+			pending[key] = pending[key][1:]
+			diff.Persisted = append(diff.Persisted, change(Persisted, hit))
+		} else {
+			diff.New = append(diff.New, change(New, hit))
+		}
+	}
+
+	// In the order of the old run.
+	for _, hit := range oldHits {
+		key := hit.key()
+		if len(pending[key]) > 0 && pending[key][0].Match.Address == hit.Match.Address {
+			pending[key] = pending[key][1:]
+			diff.Resolved = append(diff.Resolved, change(Resolved, hit))
+		}
+	}
+}
+
+// String returns a text summary of the diff.
+func (diff ScanDiff) String() string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%d persisted, %d new and %d resolved hits\n", len(diff.Persisted), len(diff.New),
+		len(diff.Resolved))
+
+	for _, changes := range [][]HitChange{diff.New, diff.Resolved} {
+		for _, c := range changes {
+			fmt.Fprintf(&b, "  %-9s process %d (%s): %v\n", c.Category, c.Pid, c.Executable, c.Hit)
+		}
+	}
+	for _, p := range diff.NewProcesses {
+		fmt.Fprintf(&b, "  new process %d (%s) with %d hits\n", p.Pid, p.Executable, p.Hits)
+	}
+	for _, p := range diff.ExitedProcesses {
+		fmt.Fprintf(&b, "  exited process %d (%s) had %d hits\n", p.Pid, p.Executable, p.Hits)
+	}
+	return b.String()
+}
@@ -0,0 +1,78 @@
+package report
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/polyverse/masche/process"
+)
+
+// Enricher adds to the report of a process what isn't found by searching its memory. Enrichers run as soon as the
+// process is scanned, while it's still the process whose memory was scanned, see SweepOptions. An enricher that can't
+// add everything reports the rest as softerrors.
+type Enricher func(p process.Process, pr *ProcessReport) (softerrors []error)
+
+// ProcessNetwork are the TCP and UDP sockets of a process, as seen from its network namespace. In a container they are
+// the addresses of the container, not the ones of the host.
+type ProcessNetwork struct {
+	// Listening are the sockets accepting connections, and the UDP sockets that aren't connected.
+	Listening []process.InetSocket `json:"listening,omitempty"`
+	// Connected are the rest, like the established TCP connections.
+	Connected []process.InetSocket `json:"connected,omitempty"`
+}
+
+// String correlates the network of the process, like "listening on [::]:8443, connected to 10.2.3.4:443".
+func (n ProcessNetwork) String() string {
+	var parts []string
+	if len(n.Listening) > 0 {
+		parts = append(parts, "listening on "+joinAddresses(n.Listening, false))
+	}
+	if len(n.Connected) > 0 {
+		parts = append(parts, "connected to "+joinAddresses(n.Connected, true))
+	}
+	if len(parts) == 0 {
+		return "no sockets"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// joinAddresses joins the local or remote addresses of sockets, once each.
+func joinAddresses(sockets []process.InetSocket, remote bool) string {
+	var addresses []string
+	seen := make(map[string]bool)
+	for _, socket := range sockets {
+		address := socket.Local
+		if remote {
+			address = socket.Remote
+		}
+		s := fmt.Sprintf("%s/%s", address, socket.Protocol)
+		if !seen[s] {
+			seen[s] = true
+			addresses = append(addresses, s)
+		}
+	}
+	return strings.Join(addresses, " ")
+}
+
+// EnrichNetwork is an Enricher that records the sockets of the processes with hits in their Network, so a report
+// tells that a process with a match is listening on 0.0.0.0:8443 and connected to 10.2.3.4:443. It's only implemented
+// on Linux.
+func EnrichNetwork(p process.Process, pr *ProcessReport) (softerrors []error) {
+	if len(pr.Hits) == 0 {
+		return nil
+	}
+	sockets, err, softerrors := process.InetSockets(p)
+	if err != nil {
+		return append(softerrors, fmt.Errorf("Unable to find the sockets of process %d (%v)", p.Pid(), err))
+	}
+	network := &ProcessNetwork{}
+	for _, socket := range sockets {
+		if socket.Listening() {
+			network.Listening = append(network.Listening, socket)
+		} else {
+			network.Connected = append(network.Connected, socket)
+		}
+	}
+	pr.Network = network
+	return softerrors
+}
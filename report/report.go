@@ -0,0 +1,371 @@
+// Package report keeps the results of scanning many processes, and compares the results of different runs.
+package report
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"time"
+
+	"github.com/polyverse/masche/common"
+	"github.com/polyverse/masche/compare"
+	"github.com/polyverse/masche/lease"
+	"github.com/polyverse/masche/memaccess"
+	"github.com/polyverse/masche/memsearch"
+	"github.com/polyverse/masche/process"
+)
+
+// ContextSize is the amount of bytes before and after a match in anonymous memory that identify it across runs.
+const ContextSize = 8
+
+// Hit is a match with the identifiers that find it again in a later run, even if the address space layout was
+// randomized differently.
+type Hit struct {
+	Match memsearch.Match `json:"match"`
+	// Module is the file containing the match, for file backed memory, and ModuleOffset is the offset of the match
+	// from the lowest address where the file is mapped.
+	Module       string  `json:"module,omitempty"`
+	ModuleOffset uintptr `json:"moduleOffset,omitempty"`
+	// Class is the class of the region containing the match, as returned by compare.Classify.
+	Class string `json:"class"`
+	// Context is a hash of the ContextSize bytes before and after a match in anonymous memory. It's zero if they
+	// couldn't be read.
+	Context uint64 `json:"context,omitempty"`
+}
+
+// key identifies a hit across runs.
+func (h Hit) key() string {
+	if h.Module != "" {
+		return fmt.Sprintf("%x@%s+%x", h.Match.Bytes, h.Module, h.ModuleOffset)
+	}
+	return fmt.Sprintf("%x@%s#%x", h.Match.Bytes, h.Class, h.Context)
+}
+
+func (h Hit) String() string {
+	if h.Module != "" {
+		return fmt.Sprintf("pattern %d at %s+0x%x", h.Match.Pattern, h.Module, h.ModuleOffset)
+	}
+	return fmt.Sprintf("pattern %d at 0x%x in %s memory", h.Match.Pattern, h.Match.Address, h.Class)
+}
+
+// ProcessReport are the hits in a process.
+type ProcessReport struct {
+	Pid        int    `json:"pid"`
+	Executable string `json:"executable"`
+	Hits       []Hit  `json:"hits"`
+	// Network are the sockets of the process, if the sweep had the EnrichNetwork enricher and the process had hits.
+	Network *ProcessNetwork `json:"network,omitempty"`
+}
+
+// ScanReport is the result of a scan of many processes.
+type ScanReport struct {
+	Time      time.Time       `json:"time"`
+	Processes []ProcessReport `json:"processes"`
+	// Visibility tells how many of the processes of the system could be seen when the scan was made, if it could be
+	// assessed. A report without hits from a host with restricted visibility doesn't mean the host is clean.
+	Visibility *process.Visibility `json:"visibility,omitempty"`
+	// Cursor is where the scan was paused, if the Checkpoint of the options paused it or the MaxDuration of a process
+	// elapsed. ResumeScan continues from it.
+	Cursor *memsearch.Cursor `json:"cursor,omitempty"`
+	// Resumed tells how a scan continued, if it was resumed with ResumeScan.
+	Resumed memsearch.Disposition `json:"resumed,omitempty"`
+	// Actions are the outcomes of the actions run on the hits, see SweepOptions.
+	Actions []ActionOutcome `json:"actions,omitempty"`
+	// SkippedBy is the instance that held the lease when a sweep that skips leased hosts was made, see Coordination.
+	SkippedBy *lease.Info `json:"skippedBy,omitempty"`
+	// Summary condenses the hits of every process of the report, if the Summary of the search options enabled it.
+	Summary *memsearch.HitSummary `json:"summary,omitempty"`
+}
+
+// SweepOptions are the options of Sweep that apply to the whole scan, and not to the search in each process.
+type SweepOptions struct {
+	// Actions are run on the hits of each process as soon as the process is scanned, before the next one is. Their
+	// outcomes are in the report. An action that fails is reported as a softerror, and doesn't stop the scan.
+	Actions []Action
+	// Coordination, if not nil, keeps the sweep from scanning at the same time as other instances of masche.
+	Coordination *Coordination
+	// Enrichers add to the report of each process as soon as it's scanned, before the actions run.
+	Enrichers []Enricher
+}
+
+// Scan searches patterns in every process of procs. The processes that can't be scanned are reported as softerrors
+// and left out of the report. The processes of the report are sorted by pid, whatever the order of procs. Unless the
+// options say otherwise, the matches are verified (see memsearch.Verify).
+//
+// If the Summary of the options is set, the report has the HitSummary of the hits of all its processes.
+//
+// The visibility of the processes of the system is assessed and attached to the report. If not every process can be
+// seen, a *process.VisibilityError is also returned as a softerror.
+//
+// The processes are scanned in pid order. If the options have a Checkpoint, it's also called before each process, with
+// the index of the process in that order as the Process of the cursor. If it pauses the scan, the report has the
+// processes scanned so far, the one it was paused in with the hits found until then, and the Cursor to resume it from.
+func Scan(procs []process.Process, patterns []memsearch.Pattern, opts memsearch.SearchOptions) (report ScanReport,
+	harderror error, softerrors []error) {
+
+	return sweep(procs, nil, patterns, opts, SweepOptions{})
+}
+
+// ScanWithActions works as Scan, and also runs actions on the hits of each process as soon as the process is
+// scanned, before the next one is. The outcomes of the actions are in the report. An action that fails is reported as
+// a softerror, and doesn't stop the scan. A harderror is only returned if the actions are invalid.
+func ScanWithActions(procs []process.Process, patterns []memsearch.Pattern, opts memsearch.SearchOptions,
+	actions []Action) (report ScanReport, harderror error, softerrors []error) {
+
+	return Sweep(procs, patterns, opts, SweepOptions{Actions: actions})
+}
+
+// Sweep works as Scan, with the options of the whole scan in sweepOpts. A harderror is returned if the options are
+// invalid, or if the lease of the Coordination can't be taken.
+func Sweep(procs []process.Process, patterns []memsearch.Pattern, opts memsearch.SearchOptions,
+	sweepOpts SweepOptions) (report ScanReport, harderror error, softerrors []error) {
+
+	return sweep(procs, nil, patterns, opts, sweepOpts)
+}
+
+// ResumeScan continues a scan paused at cursor, with the same patterns and options. procs are the processes of the
+// system now; the ones with a lower pid than the process the scan was paused in are skipped. The reports of the
+// paused scan and of its resumption together have the hits of a scan that wasn't paused, the process it was paused in
+// is in both with some of its hits in each.
+//
+// If the process the scan was paused in exited, the scan continues with the next one, and the Resumed of the report
+// is memsearch.ProcessGone.
+func ResumeScan(procs []process.Process, cursor memsearch.Cursor, patterns []memsearch.Pattern,
+	opts memsearch.SearchOptions) (report ScanReport, harderror error, softerrors []error) {
+
+	if cursor.Version != memsearch.CursorVersion {
+		return ScanReport{}, fmt.Errorf("%w: version %d, expected %d", memsearch.ErrInvalidCursor, cursor.Version,
+			memsearch.CursorVersion), nil
+	}
+	return sweep(procs, &cursor, patterns, opts, SweepOptions{})
+}
+
+// sweep scans procs in pid order, from resume if it's not nil.
+func sweep(procs []process.Process, resume *memsearch.Cursor, patterns []memsearch.Pattern,
+	opts memsearch.SearchOptions, sweepOpts SweepOptions) (report ScanReport, harderror error, softerrors []error) {
+
+	actor, harderror := newActor(sweepOpts.Actions, opts)
+	if harderror != nil {
+		return ScanReport{}, harderror, nil
+	}
+	// A sweep that fails or panics doesn't leave the processes it suspended stopped.
+	defer func() {
+		if r := recover(); r != nil {
+			actor.abort()
+			panic(r)
+		}
+		if harderror != nil {
+			softerrors = append(softerrors, actor.abort()...)
+		}
+	}()
+	coordinator, harderror := newCoordinator(sweepOpts.Coordination)
+	if harderror != nil {
+		return ScanReport{}, harderror, nil
+	}
+	report.Time = time.Now()
+	report.SkippedBy, harderror = coordinator.start()
+	if harderror != nil {
+		return ScanReport{}, harderror, nil
+	}
+	if report.SkippedBy != nil {
+		return report, nil, nil
+	}
+	defer func() { softerrors = append(softerrors, coordinator.release()...) }()
+
+	visibility, err, serrs := process.AssessVisibility()
+	softerrors = append(softerrors, serrs...)
+	if err != nil {
+		softerrors = append(softerrors, fmt.Errorf("Unable to assess the visibility of processes (%v)", err))
+	} else {
+		report.Visibility = &visibility
+		if visibility.Level != process.FullVisibility {
+			softerrors = append(softerrors, &process.VisibilityError{Visibility: visibility})
+		}
+	}
+
+	// The patterns are compiled once for every process. Invalid ones are left for each scan to report.
+	if opts.Compiled == nil {
+		if compiled, err := memsearch.Compile(patterns); err == nil {
+			opts.Compiled = compiled
+		}
+	}
+
+	procs = append([]process.Process(nil), procs...)
+	sort.SliceStable(procs, func(i, j int) bool { return procs[i].Pid() < procs[j].Pid() })
+	checkpoint := opts.Checkpoint
+	for i, p := range procs {
+		var from *memsearch.Cursor
+		if resume != nil && report.Resumed == "" {
+			if p.Pid() < resume.Pid {
+				continue
+			}
+			if p.Pid() == resume.Pid {
+				from = resume
+			} else {
+				report.Resumed = memsearch.ProcessGone
+			}
+		}
+		if harderror = coordinator.hold(p.Pid()); harderror != nil {
+			return ScanReport{}, harderror, softerrors
+		}
+		if checkpoint != nil {
+			index := i
+			opts.Checkpoint = func(c memsearch.Cursor) bool {
+				c.Process = index
+				return checkpoint(c)
+			}
+			start := memsearch.Cursor{Version: memsearch.CursorVersion, Process: i, Pid: p.Pid()}
+			if from == nil && !checkpoint(start) {
+				report.Cursor = &start
+				softerrors = append(softerrors, coordinator.done()...)
+				break
+			}
+		}
+
+		pr, stats, err, serrs := scanProcess(p, from, patterns, opts)
+		softerrors = append(softerrors, serrs...)
+		if err != nil {
+			softerrors = append(softerrors, &common.LocatedError{Pid: p.Pid(),
+				Err: fmt.Errorf("Skipping process %d: %v", p.Pid(), err)})
+			if from != nil {
+				report.Resumed = memsearch.ProcessGone
+			}
+			softerrors = append(softerrors, coordinator.done()...)
+			continue
+		}
+		if from != nil {
+			report.Resumed = stats.Resumed
+		}
+		for _, enrich := range sweepOpts.Enrichers {
+			softerrors = append(softerrors, enrich(p, &pr)...)
+		}
+		report.Processes = append(report.Processes, pr)
+		outcomes, serrs := actor.act(p, pr)
+		report.Actions = append(report.Actions, outcomes...)
+		softerrors = append(softerrors, serrs...)
+		softerrors = append(softerrors, coordinator.done()...)
+		if stats.Paused != nil {
+			report.Cursor = stats.Paused
+			report.Cursor.Process = i
+			break
+		}
+	}
+	if resume != nil && report.Resumed == "" {
+		report.Resumed = memsearch.ProcessGone
+	}
+	if opts.Summary != nil {
+		summary := summarize(report.Processes, *opts.Summary)
+		report.Summary = &summary
+	}
+	common.SortSoftErrors(softerrors)
+	return report, nil, softerrors
+}
+
+// summarize builds the HitSummary of the hits of every process.
+func summarize(processes []ProcessReport, opts memsearch.SummaryOptions) memsearch.HitSummary {
+	var matches []memsearch.Match
+	for _, pr := range processes {
+		for _, hit := range pr.Hits {
+			matches = append(matches, hit.Match)
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].Pid != matches[j].Pid {
+			return matches[i].Pid < matches[j].Pid
+		}
+		return matches[i].Address < matches[j].Address
+	})
+	return memsearch.Summarize(matches, opts)
+}
+
+// ScanProcess searches patterns in p, and identifies its hits. Unless the options say otherwise, the matches are
+// verified (see memsearch.Verify).
+func ScanProcess(p process.Process, patterns []memsearch.Pattern, opts memsearch.SearchOptions) (
+	report ProcessReport, harderror error, softerrors []error) {
+
+	report, _, harderror, softerrors = scanProcess(p, nil, patterns, opts)
+	return report, harderror, softerrors
+}
+
+// scanProcess works as ScanProcess, from resume if it's not nil, and also returns the stats of the search.
+func scanProcess(p process.Process, resume *memsearch.Cursor, patterns []memsearch.Pattern,
+	opts memsearch.SearchOptions) (report ProcessReport, stats memsearch.ScanStats, harderror error,
+	softerrors []error) {
+
+	if opts.Verification == memsearch.DefaultVerification {
+		opts.Verification = memsearch.Verify
+	}
+
+	report.Pid = p.Pid()
+	report.Executable, harderror, softerrors = p.Name()
+	if harderror != nil {
+		return ProcessReport{}, stats, harderror, softerrors
+	}
+	if exe, err := process.ProcessExe(p.Pid()); err == nil {
+		report.Executable = exe
+	}
+
+	regions, harderror, serrs := memaccess.MemoryRegions(p)
+	softerrors = append(softerrors, serrs...)
+	if harderror != nil {
+		return ProcessReport{}, stats, harderror, softerrors
+	}
+	moduleBases := make(map[string]uintptr)
+	for _, region := range regions {
+		class := compare.Classify(region, report.Executable)
+		if class != compare.ClassExecutable && class != compare.ClassLibrary {
+			continue
+		}
+		if base, ok := moduleBases[region.Kind]; !ok || region.Address < base {
+			moduleBases[region.Kind] = region.Address
+		}
+	}
+
+	var matches []memsearch.Match
+	if resume != nil {
+		matches, stats, harderror, serrs = memsearch.ResumeFindAll(p, *resume, patterns, opts)
+	} else {
+		matches, stats, harderror, serrs = memsearch.FindAll(p, 0, patterns, opts)
+	}
+	softerrors = append(softerrors, serrs...)
+	if harderror != nil {
+		return ProcessReport{}, stats, harderror, softerrors
+	}
+
+	report.Hits = make([]Hit, 0, len(matches))
+	for _, m := range matches {
+		hit := Hit{Match: m, Class: compare.Classify(m.Region, report.Executable)}
+		if base, ok := moduleBases[m.Region.Kind]; ok {
+			hit.Module, hit.ModuleOffset = m.Region.Kind, m.Address-base
+		} else {
+			hit.Context = contextHash(p, m)
+		}
+		report.Hits = append(report.Hits, hit)
+	}
+	return report, stats, nil, softerrors
+}
+
+// contextHash hashes the bytes around m, clipped to its region.
+func contextHash(p process.Process, m memsearch.Match) uint64 {
+	regionEnd := m.Region.Address + uintptr(m.Region.Size)
+	before := uintptr(ContextSize)
+	if m.Address-m.Region.Address < before {
+		before = m.Address - m.Region.Address
+	}
+	end := m.Address + uintptr(len(m.Bytes))
+	after := uintptr(ContextSize)
+	if regionEnd-end < after {
+		after = regionEnd - end
+	}
+
+	h := fnv.New64a()
+	for _, chunk := range []struct{ address, size uintptr }{{m.Address - before, before}, {end, after}} {
+		buf := make([]byte, chunk.size)
+		if err, _ := memaccess.CopyMemory(p, chunk.address, buf); err != nil {
+			return 0
+		}
+		h.Write(buf)
+		h.Write([]byte{0})
+	}
+	return h.Sum64()
+}
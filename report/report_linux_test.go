@@ -0,0 +1,632 @@
+package report
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/polyverse/masche/common"
+	"github.com/polyverse/masche/lease"
+	"github.com/polyverse/masche/memaccess"
+	"github.com/polyverse/masche/memsearch"
+	"github.com/polyverse/masche/process"
+	"github.com/polyverse/masche/test"
+)
+
+var markerPatterns = []memsearch.Pattern{{Bytes: []byte("MASCHEMK")}, {Bytes: []byte("XASCHEMK")}}
+
+func launch(t *testing.T) (*exec.Cmd, process.Process) {
+	cmd, err := test.LaunchTestCaseAndWaitForInitialization()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cmd.Process.Kill(); cmd.Wait() })
+
+	p, err, softerrors := process.OpenFromPid(cmd.Process.Pid)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { p.Close() })
+	return cmd, p
+}
+
+func scan(t *testing.T, procs ...process.Process) ScanReport {
+	report, err, softerrors := Scan(procs, markerPatterns, memsearch.SearchOptions{})
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+func TestDiff(t *testing.T) {
+	cmdA, a := launch(t)
+	_, b := launch(t)
+
+	old := scan(t, a, b)
+	if len(old.Processes) != 2 || len(old.Processes[0].Hits) != 4 {
+		t.Fatalf("Expected the 4 markers of each process, got %+v", old.Processes)
+	}
+	for _, hit := range old.Processes[0].Hits {
+		if hit.Class != "heap" || hit.Module != "" || hit.Context == 0 {
+			t.Errorf("Expected a heap hit with context, got %+v", hit)
+		}
+	}
+
+	// The second marker of a changes, b exits and c starts.
+	if err := cmdA.Process.Signal(syscall.SIGUSR1); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	_, c := launch(t)
+
+	diff := Diff(old, scan(t, a, c))
+	if len(diff.Persisted) != 3 || len(diff.New) != 1+4 || len(diff.Resolved) != 1+4 {
+		t.Fatalf("Unexpected diff %v", diff)
+	}
+	for _, change := range diff.Persisted {
+		if change.Pid != a.Pid() || change.Hit.Match.Pattern != 0 {
+			t.Errorf("Unexpected persisted hit %+v", change)
+		}
+	}
+	if change := diff.New[0]; change.Pid != a.Pid() || change.Hit.Match.Pattern != 1 {
+		t.Errorf("Expected the changed marker as a new hit of a, got %+v", change)
+	}
+	if change := diff.Resolved[0]; change.Pid != a.Pid() || change.Hit.Match.Pattern != 0 ||
+		change.Hit.Match.Address != diff.New[0].Hit.Match.Address {
+		t.Errorf("Expected the original marker as a resolved hit of a, got %+v", change)
+	}
+
+	expectedNew := []ProcessChange{{Pid: c.Pid(), Executable: test.GetTestCasePath(), Hits: 4}}
+	expectedExited := []ProcessChange{{Pid: b.Pid(), Executable: test.GetTestCasePath(), Hits: 4}}
+	if len(diff.NewProcesses) != 1 || diff.NewProcesses[0] != expectedNew[0] || len(diff.ExitedProcesses) != 1 ||
+		diff.ExitedProcesses[0] != expectedExited[0] {
+		t.Errorf("Unexpected process changes %+v %+v", diff.NewProcesses, diff.ExitedProcesses)
+	}
+
+	text := diff.String()
+	if !strings.HasPrefix(text, "3 persisted, 5 new and 5 resolved hits\n") ||
+		!strings.Contains(text, "exited process") {
+		t.Errorf("Unexpected text summary:\n%s", text)
+	}
+	if _, err := json.Marshal(diff); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDiffToleratesASLR(t *testing.T) {
+	_, a := launch(t)
+	report := scan(t, a)
+
+	// The same hits at other addresses, as in a new run of a restarted process with the same pid.
+	moved := ScanReport{Processes: []ProcessReport{{Pid: a.Pid(), Executable: report.Processes[0].Executable}}}
+	for _, hit := range report.Processes[0].Hits {
+		hit.Match.Address += 0x10000
+		moved.Processes[0].Hits = append(moved.Processes[0].Hits, hit)
+	}
+	moved.Processes[0].Hits = append(moved.Processes[0].Hits, Hit{Match: memsearch.Match{Bytes: []byte("MASCHEMK")},
+		Module: test.GetTestCasePath(), ModuleOffset: 0x1234})
+
+	diff := Diff(report, moved)
+	if len(diff.Persisted) != 4 || len(diff.New) != 1 || len(diff.Resolved) != 0 {
+		t.Errorf("Unexpected diff %v", diff)
+	}
+}
+
+func TestScanVisibility(t *testing.T) {
+	report := scan(t)
+	if report.Visibility == nil {
+		t.Fatal("The report has no visibility")
+	}
+
+	// A proc filesystem mounted with hidepid=2, where only one of the 1000 tasks of the kernel can be seen.
+	defer func(root string) { common.ProcRoot = root }(common.ProcRoot)
+	common.ProcRoot = t.TempDir()
+	for file, data := range map[string]string{
+		"mounts":      "proc " + common.ProcRoot + " proc rw,nosuid,hidepid=2 0 0\n",
+		"loadavg":     "0.20 0.18 0.12 1/1000 11206\n",
+		"self/status": "NSpid:\t42\n",
+		"42/status":   "Threads:\t1\n",
+	} {
+		path := filepath.Join(common.ProcRoot, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err, softerrors := Scan(nil, markerPatterns, memsearch.SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Visibility == nil || report.Visibility.Level != process.SeverelyRestricted ||
+		report.Visibility.HidePid != "2" {
+
+		t.Errorf("Expected a severely restricted visibility, got %+v", report.Visibility)
+	}
+	var visibilityError *process.VisibilityError
+	for _, e := range softerrors {
+		if errors.As(e, &visibilityError) {
+			break
+		}
+	}
+	if visibilityError == nil || visibilityError.Visibility != *report.Visibility {
+		t.Errorf("Expected a VisibilityError, got %v", softerrors)
+	}
+}
+
+func TestScanOrder(t *testing.T) {
+	var procs []process.Process
+	for i := 0; i < 3; i++ {
+		_, p := launch(t)
+		procs = append(procs, p)
+	}
+	// Two processes that exited are skipped.
+	for i := 0; i < 2; i++ {
+		cmd, p := launch(t)
+		cmd.Process.Kill()
+		cmd.Wait()
+		procs = append(procs, p)
+	}
+	sort.Slice(procs, func(i, j int) bool { return procs[i].Pid() > procs[j].Pid() })
+
+	report, err, softerrors := Scan(procs, markerPatterns, memsearch.SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Processes) != 3 {
+		t.Fatalf("Expected 3 processes in the report, got %d", len(report.Processes))
+	}
+	if !sort.SliceIsSorted(report.Processes, func(i, j int) bool {
+		return report.Processes[i].Pid < report.Processes[j].Pid
+	}) {
+		t.Errorf("The processes of the report aren't sorted by pid")
+	}
+
+	// The softerrors about a process are sorted by pid and address, after the rest.
+	var skipped []int
+	var last common.LocatedError
+	for _, err := range softerrors {
+		var located *common.LocatedError
+		if !errors.As(err, &located) {
+			if last.Err != nil {
+				t.Errorf("Softerror %v is after the located ones", err)
+			}
+			continue
+		}
+		if located.Pid < last.Pid || located.Pid == last.Pid && located.Address < last.Address {
+			t.Errorf("Softerror %v is after %v", located, &last)
+		}
+		last = *located
+		if strings.HasPrefix(err.Error(), "Skipping process") {
+			skipped = append(skipped, located.Pid)
+		}
+	}
+	if len(skipped) != 2 {
+		t.Errorf("Expected the two exited processes to be skipped, got %v", softerrors)
+	}
+}
+
+func TestScanSummary(t *testing.T) {
+	var procs []process.Process
+	for i := 0; i < 2; i++ {
+		_, p := launch(t)
+		procs = append(procs, p)
+	}
+
+	if report := scan(t, procs...); report.Summary != nil {
+		t.Errorf("A scan without the summary enabled has one: %+v", report.Summary)
+	}
+
+	opts := memsearch.SearchOptions{Summary: &memsearch.SummaryOptions{ClusterDistance: 16}}
+	report, err, softerrors := Scan(procs, markerPatterns, opts)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Summary == nil {
+		t.Fatal("The scan has no summary")
+	}
+	hits := 0
+	for _, pr := range report.Processes {
+		hits += len(pr.Hits)
+	}
+	if hits == 0 || report.Summary.Matches != hits {
+		t.Errorf("The summary has %d matches, the report %d hits", report.Summary.Matches, hits)
+	}
+
+	// Every process has its own clusters and regions.
+	pids := make(map[int]bool)
+	for _, cluster := range report.Summary.Clusters {
+		pids[cluster.Pid] = true
+	}
+	for _, p := range procs {
+		if !pids[p.Pid()] {
+			t.Errorf("Process %d has no clusters in %v", p.Pid(), report.Summary.Clusters)
+		}
+	}
+	if !sort.SliceIsSorted(report.Summary.Regions, func(i, j int) bool {
+		a, b := report.Summary.Regions[i], report.Summary.Regions[j]
+		return a.Pid < b.Pid || a.Pid == b.Pid && a.Region.Address < b.Region.Address
+	}) {
+		t.Errorf("The regions of the summary aren't sorted by pid and address")
+	}
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(data, []byte(`"summary":{"matches":`)) {
+		t.Errorf("The summary isn't in the JSON of the report: %s", data)
+	}
+}
+
+// hitAddresses lists the addresses of the hits of each process of reports.
+func hitAddresses(reports ...ScanReport) map[int][]uintptr {
+	addresses := make(map[int][]uintptr)
+	for _, report := range reports {
+		for _, pr := range report.Processes {
+			for _, hit := range pr.Hits {
+				addresses[pr.Pid] = append(addresses[pr.Pid], hit.Match.Address)
+			}
+		}
+	}
+	return addresses
+}
+
+func TestResumeScan(t *testing.T) {
+	cmdA, a := launch(t)
+	cmdB, b := launch(t)
+	if b.Pid() < a.Pid() {
+		cmdA, a, b = cmdB, b, a
+	}
+	procs := []process.Process{b, a}
+
+	var cursors []memsearch.Cursor
+	opts := memsearch.SearchOptions{Checkpoint: func(c memsearch.Cursor) bool {
+		cursors = append(cursors, c)
+		return true
+	}}
+	full, err, _ := Scan(procs, markerPatterns, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cursors) < 4 || cursors[0].Pid != a.Pid() || cursors[0].Region != 0 ||
+		cursors[len(cursors)-1].Process != 1 {
+
+		t.Fatalf("Unexpected checkpoints %+v", cursors)
+	}
+
+	// Pausing before the first process, in the middle of each process, and before the second one.
+	second := 0
+	for second < len(cursors) && cursors[second].Process == 0 {
+		second++
+	}
+	for _, k := range []int{0, second / 2, second, (second + len(cursors)) / 2} {
+		calls := 0
+		opts.Checkpoint = func(memsearch.Cursor) bool { calls++; return calls <= k }
+		paused, err, _ := Scan(procs, markerPatterns, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if paused.Cursor == nil {
+			t.Fatalf("The scan wasn't paused at checkpoint %d", k)
+		}
+		resumed, err, _ := ResumeScan(procs, *paused.Cursor, markerPatterns, memsearch.SearchOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resumed.Resumed != memsearch.Resumed {
+			t.Errorf("Checkpoint %d: expected the scan to be resumed, got %q", k, resumed.Resumed)
+		}
+		if expected, got := hitAddresses(full), hitAddresses(paused, resumed); !reflect.DeepEqual(expected, got) {
+			t.Errorf("Checkpoint %d: expected the hits %v, got %v", k, expected, got)
+		}
+	}
+
+	// A scan paused in a process that exits continues with the next one.
+	opts.Checkpoint = func(c memsearch.Cursor) bool { return c.Region == 0 }
+	paused, err, _ := Scan(procs, markerPatterns, opts)
+	if err != nil || paused.Cursor == nil || paused.Cursor.Pid != a.Pid() {
+		t.Fatalf("Expected the scan to be paused in the first process, got %+v, %v", paused.Cursor, err)
+	}
+	cmdA.Process.Kill()
+	cmdA.Wait()
+	resumed, err, _ := ResumeScan([]process.Process{b}, *paused.Cursor, markerPatterns, memsearch.SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resumed.Resumed != memsearch.ProcessGone || len(resumed.Processes) != 1 ||
+		len(resumed.Processes[0].Hits) != 4 {
+
+		t.Errorf("Expected the hits of the second process after the first one exited, got %+v", resumed)
+	}
+}
+
+func TestScanWithActions(t *testing.T) {
+	_, p := launch(t)
+	dir := t.TempDir()
+
+	calls := 0
+	actions := []Action{
+		{Kind: DumpRegion, Directory: dir, NameTemplate: `{{.Executable}}-{{printf "%x" .Region.Address}}`},
+		{Kind: CaptureProcessSnapshot, Limit: 1},
+		{Kind: RunCallback, Name: "flaky", Callback: func(process.Process, Hit) error {
+			calls++
+			if calls == 1 {
+				return errors.New("flaky callback")
+			}
+			panic("flakier callback")
+		}},
+		{Kind: SuspendProcess, FirstHitOnly: true},
+	}
+	report, err, softerrors := ScanWithActions([]process.Process{p}, markerPatterns, memsearch.SearchOptions{},
+		actions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Processes) != 1 || len(report.Processes[0].Hits) != 4 {
+		t.Fatalf("Expected the 4 markers, got %+v", report.Processes)
+	}
+
+	outcomes := make(map[string][]ActionOutcome)
+	for _, outcome := range report.Actions {
+		outcomes[outcome.Action] = append(outcomes[outcome.Action], outcome)
+	}
+	dumps := outcomes[string(DumpRegion)]
+	if len(dumps) != 4 || dumps[0].Path == "" || dumps[0].Error != "" {
+		t.Fatalf("Expected a dump of each hit, got %+v", dumps)
+	}
+	data, err := ioutil.ReadFile(dumps[0].Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "MASCHEMK") || !strings.HasPrefix(filepath.Base(dumps[0].Path), "_") {
+		t.Errorf("Expected the dump %s to contain the marker", dumps[0].Path)
+	}
+
+	snapshots := outcomes[string(CaptureProcessSnapshot)]
+	if len(snapshots) != 4 || snapshots[0].Snapshot["pid"] != int64(p.Pid()) || !snapshots[1].RateLimited ||
+		!snapshots[3].RateLimited {
+
+		t.Errorf("Expected a snapshot and 3 rate limited outcomes, got %+v", snapshots)
+	}
+
+	flaky := outcomes["flaky"]
+	if len(flaky) != 4 || flaky[0].Error != "flaky callback" || !strings.Contains(flaky[1].Error, "flakier") {
+		t.Errorf("Expected the callback to fail, got %+v", flaky)
+	}
+	failures := 0
+	for _, err := range softerrors {
+		if strings.HasPrefix(err.Error(), "Action flaky failed") {
+			failures++
+		}
+	}
+	if failures != 4 {
+		t.Errorf("Expected the failures of the callback as softerrors, got %v", softerrors)
+	}
+
+	if suspended := outcomes[string(SuspendProcess)]; len(suspended) != 1 || suspended[0].Error != "" {
+		t.Errorf("Expected the process to be suspended once, got %+v", suspended)
+	}
+	time.Sleep(100 * time.Millisecond)
+	facts, err, _ := process.GatherFacts(p)
+	if err != nil || facts["state"] != "T" {
+		t.Errorf("Expected the process to be stopped, got %v (%v)", facts["state"], err)
+	}
+
+	if _, err, _ := ScanWithActions(nil, markerPatterns, memsearch.SearchOptions{},
+		[]Action{{Kind: DumpRegion}}); err == nil {
+		t.Error("Expected an error for a dump without a directory")
+	}
+}
+
+// A sweep that panics resumes the processes it suspended.
+func TestSweepPanicResumes(t *testing.T) {
+	_, a := launch(t)
+	_, b := launch(t)
+
+	opts := memsearch.SearchOptions{Checkpoint: func(c memsearch.Cursor) bool {
+		if c.Process == 1 {
+			panic("checkpoint failed")
+		}
+		return true
+	}}
+	func() {
+		defer func() {
+			if r := recover(); r != "checkpoint failed" {
+				t.Fatal("Expected the panic of the checkpoint, got", r)
+			}
+		}()
+		Sweep([]process.Process{a, b}, markerPatterns, opts, SweepOptions{
+			Actions: []Action{{Kind: SuspendProcess, FirstHitOnly: true}}})
+	}()
+
+	first := a
+	if b.Pid() < a.Pid() {
+		first = b
+	}
+	time.Sleep(100 * time.Millisecond)
+	if facts, err, _ := process.GatherFacts(first); err != nil || facts["state"] == "T" {
+		t.Errorf("Expected process %d to be resumed, got %v (%v)", first.Pid(), facts["state"], err)
+	}
+}
+
+// A region is dumped in chunks charged to the budget, and the chunks that can't be read are left as zeros.
+func TestDumpChunks(t *testing.T) {
+	_, p := launch(t)
+	regions, err, _ := memaccess.MemoryRegions(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pageSize := uint(os.Getpagesize())
+	var region memaccess.MemoryRegion
+	for i := 1; i < len(regions) && region.Size == 0; i++ {
+		previous := regions[i-1]
+		if previous.Address+uintptr(previous.Size) < regions[i].Address-uintptr(pageSize) &&
+			regions[i].Access&memaccess.Readable != 0 {
+
+			// A page that isn't mapped followed by a readable region.
+			region = memaccess.MemoryRegion{Address: regions[i].Address - uintptr(pageSize),
+				Size: pageSize + regions[i].Size, Access: regions[i].Access}
+		}
+	}
+	if region.Size == 0 {
+		t.Skip("No readable region follows unmapped memory")
+	}
+	expected := make([]byte, region.Size)
+	if err, _ := memaccess.CopyMemory(p, region.Address+uintptr(pageSize), expected[pageSize:]); err != nil {
+		t.Fatal(err)
+	}
+
+	budget := memsearch.NewMemoryBudget(uint64(pageSize))
+	a, err := newActor([]Action{{Kind: DumpRegion, Directory: t.TempDir()}},
+		memsearch.SearchOptions{BufferSize: 4 * pageSize, Budget: budget})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hit := Hit{Match: memsearch.Match{Address: region.Address, Region: region}}
+	path, err, softerrors := a.dump(0, p, ProcessReport{Pid: p.Pid()}, hit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(softerrors) != 1 || !strings.Contains(softerrors[0].Error(), "Unable to dump") {
+		t.Errorf("Expected the unmapped page as a softerror, got %v", softerrors)
+	}
+	if data, err := ioutil.ReadFile(path); err != nil || !bytes.Equal(data, expected) {
+		t.Errorf("Unexpected dump of %d bytes (%v)", len(data), err)
+	}
+	if budget.Used() != 0 || budget.Peak() != uint64(pageSize) {
+		t.Errorf("Expected a buffer of a page, the budget used %d and peaked at %d", budget.Used(), budget.Peak())
+	}
+}
+
+func TestSweepCoordination(t *testing.T) {
+	_, a := launch(t)
+	_, b := launch(t)
+	procs := []process.Process{a, b}
+	path := filepath.Join(t.TempDir(), "masche.lease")
+
+	// Two sweeps of the same host never scan at the same time: whenever one scans, the lease is its own.
+	sweepWith := func(owner string, mode CoordinationMode, reports chan<- ScanReport) {
+		opts := memsearch.SearchOptions{Checkpoint: func(memsearch.Cursor) bool {
+			if info, err := lease.Inspect(path); err != nil || info.Owner != owner || !info.Active(time.Now()) {
+				t.Errorf("%s is scanning while the lease is %+v (%v)", owner, info, err)
+			}
+			return true
+		}}
+		coordination := &Coordination{Mode: mode, Path: path, Owner: owner, Poll: time.Millisecond}
+		report, err, _ := Sweep(procs, markerPatterns, opts, SweepOptions{Coordination: coordination})
+		if err != nil {
+			t.Error(err)
+		}
+		reports <- report
+	}
+	reports := make(chan ScanReport)
+	go sweepWith("interleaving", Interleave, reports)
+	go sweepWith("waiting", WaitForLease, reports)
+	for i := 0; i < 2; i++ {
+		if report := <-reports; len(report.Processes) != 2 || len(report.Processes[1].Hits) != 4 {
+			t.Errorf("Expected both sweeps to complete, got %+v", report.Processes)
+		}
+	}
+	if info, err := lease.Inspect(path); err != nil || info.Active(time.Now()) {
+		t.Errorf("Expected the lease to be released, got %+v (%v)", info, err)
+	}
+
+	// A sweep that pauses before a process is done with it.
+	report, err, _ := Sweep(procs, markerPatterns, memsearch.SearchOptions{
+		Checkpoint: func(memsearch.Cursor) bool { return false }},
+		SweepOptions{Coordination: &Coordination{Mode: Interleave, Path: path, Owner: "pausing"}})
+	if err != nil || report.Cursor == nil || report.Cursor.Process != 0 {
+		t.Errorf("Expected the sweep to pause before the first process, got %+v (%v)", report.Cursor, err)
+	}
+	if info, err := lease.Inspect(path); err != nil || info.Active(time.Now()) {
+		t.Errorf("Expected the lease to be released after pausing, got %+v (%v)", info, err)
+	}
+
+	// A sweep that skips leased hosts reports who holds the lease.
+	held, err := lease.Acquire(path, "other", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer held.Release()
+	report, err, _ = Sweep(procs, markerPatterns, memsearch.SearchOptions{},
+		SweepOptions{Coordination: &Coordination{Mode: SkipIfLeased, Path: path}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.SkippedBy == nil || report.SkippedBy.Owner != "other" || len(report.Processes) != 0 {
+		t.Errorf("Expected the sweep to be skipped, got %+v", report)
+	}
+	_, err, _ = Sweep(procs, markerPatterns, memsearch.SearchOptions{},
+		SweepOptions{Coordination: &Coordination{Mode: WaitForLease, Path: path, MaxWait: 50 * time.Millisecond}})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the sweep to give up waiting, got %v", err)
+	}
+}
+
+func TestEnrichNetwork(t *testing.T) {
+	listener, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Skip(err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	cmd, err := test.LaunchTestCaseAndWaitForInitialization("--listen-tcp", strconv.Itoa(port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { cmd.Process.Kill(); cmd.Wait() }()
+	p, err, softerrors := process.OpenFromPid(cmd.Process.Pid)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	report, err, softerrors := Sweep([]process.Process{p}, markerPatterns, memsearch.SearchOptions{},
+		SweepOptions{Enrichers: []Enricher{EnrichNetwork}})
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Processes) != 1 || report.Processes[0].Network == nil {
+		t.Fatalf("Expected the network of the test case in %+v", report.Processes)
+	}
+	network := report.Processes[0].Network
+	if len(network.Listening) != 1 || network.Listening[0].Local.Port() != uint16(port) ||
+		network.Listening[0].Protocol != "tcp" || len(network.Connected) != 0 {
+
+		t.Errorf("Expected the test case to listen on port %d, got %+v", port, network)
+	}
+	if expected := fmt.Sprintf("listening on [::]:%d/tcp", port); network.String() != expected {
+		t.Errorf("Expected the network %q and got %q", expected, network.String())
+	}
+
+	data, err := json.Marshal(report.Processes[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), fmt.Sprintf(`"local":"[::]:%d"`, port)) {
+		t.Errorf("Unexpected JSON %s", data)
+	}
+}
@@ -0,0 +1,138 @@
+package report
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Execer runs SQL statements. *sql.DB, *sql.Tx and *sql.Conn are Execers; the caller opens the database with the
+// driver of its choice, as masche doesn't depend on any.
+type Execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// sqlSchema are the tables written by WriteSQL, in SQLite's dialect. Addresses, sizes and hashes are stored as the
+// INTEGER with the same 64 bits.
+var sqlSchema = []string{
+	// A row for each report written.
+	`CREATE TABLE IF NOT EXISTS scans (
+		id INTEGER PRIMARY KEY,
+		time TEXT NOT NULL,
+		resumed TEXT NOT NULL,
+		paused INTEGER NOT NULL
+	)`,
+	// The processes of each scan.
+	`CREATE TABLE IF NOT EXISTS processes (
+		scan INTEGER NOT NULL REFERENCES scans(id),
+		pid INTEGER NOT NULL,
+		executable TEXT NOT NULL,
+		PRIMARY KEY (scan, pid)
+	)`,
+	// The regions with hits. access has the bits of memaccess.Access: 1 readable, 2 writable and 4 executable.
+	`CREATE TABLE IF NOT EXISTS regions (
+		scan INTEGER NOT NULL,
+		pid INTEGER NOT NULL,
+		address INTEGER NOT NULL,
+		size INTEGER NOT NULL,
+		access INTEGER NOT NULL,
+		kind TEXT NOT NULL,
+		class TEXT NOT NULL,
+		PRIMARY KEY (scan, pid, address),
+		FOREIGN KEY (scan, pid) REFERENCES processes(scan, pid)
+	)`,
+	// The hits. module is empty for anonymous memory, which is told apart by its context instead.
+	`CREATE TABLE IF NOT EXISTS matches (
+		scan INTEGER NOT NULL,
+		pid INTEGER NOT NULL,
+		address INTEGER NOT NULL,
+		pattern INTEGER NOT NULL,
+		bytes BLOB NOT NULL,
+		region INTEGER NOT NULL,
+		module TEXT NOT NULL,
+		module_offset INTEGER NOT NULL,
+		context INTEGER NOT NULL,
+		PRIMARY KEY (scan, pid, address, pattern),
+		FOREIGN KEY (scan, pid, region) REFERENCES regions(scan, pid, address)
+	)`,
+	`CREATE INDEX IF NOT EXISTS matches_by_module ON matches (module)`,
+	`CREATE INDEX IF NOT EXISTS matches_by_pattern ON matches (pattern)`,
+	// The outcomes of the actions.
+	`CREATE TABLE IF NOT EXISTS actions (
+		scan INTEGER NOT NULL,
+		pid INTEGER NOT NULL,
+		address INTEGER NOT NULL,
+		action TEXT NOT NULL,
+		time TEXT NOT NULL,
+		path TEXT NOT NULL,
+		rate_limited INTEGER NOT NULL,
+		error TEXT NOT NULL,
+		FOREIGN KEY (scan, pid) REFERENCES processes(scan, pid)
+	)`,
+}
+
+// WriteSQL writes report to the tables of a SQLite database, creating them if they don't exist. Each report is a row
+// of scans, whose id is returned, so many reports can be written to the same database and compared with SQL:
+//
+//	SELECT module, COUNT(*) FROM matches JOIN regions
+//	ON regions.scan = matches.scan AND regions.pid = matches.pid AND regions.address = matches.region
+//	WHERE regions.access = 7 GROUP BY module
+//
+// Rows are inserted one at a time, so the report isn't copied. Writing it in a *sql.Tx is much faster, and leaves
+// nothing behind if it fails.
+func WriteSQL(db Execer, report ScanReport) (scan int64, err error) {
+	for _, statement := range sqlSchema {
+		if _, err := db.Exec(statement); err != nil {
+			return 0, fmt.Errorf("Unable to create the tables (%v)", err)
+		}
+	}
+
+	result, err := db.Exec(`INSERT INTO scans (time, resumed, paused) VALUES (?, ?, ?)`,
+		report.Time.Format(time.RFC3339Nano), string(report.Resumed), report.Cursor != nil)
+	if err != nil {
+		return 0, fmt.Errorf("Unable to insert the scan (%v)", err)
+	}
+	if scan, err = result.LastInsertId(); err != nil {
+		return 0, fmt.Errorf("Unable to get the id of the scan (%v)", err)
+	}
+
+	for _, pr := range report.Processes {
+		if _, err := db.Exec(`INSERT INTO processes (scan, pid, executable) VALUES (?, ?, ?)`, scan, pr.Pid,
+			pr.Executable); err != nil {
+
+			return 0, fmt.Errorf("Unable to insert process %d (%v)", pr.Pid, err)
+		}
+
+		regions := make(map[uintptr]bool)
+		for _, hit := range pr.Hits {
+			region := hit.Match.Region
+			if !regions[region.Address] {
+				regions[region.Address] = true
+				if _, err := db.Exec(`INSERT INTO regions (scan, pid, address, size, access, kind, class)
+					VALUES (?, ?, ?, ?, ?, ?, ?)`, scan, pr.Pid, int64(region.Address), int64(region.Size),
+					int64(region.Access), region.Kind, hit.Class); err != nil {
+
+					return 0, fmt.Errorf("Unable to insert %v of process %d (%v)", region, pr.Pid, err)
+				}
+			}
+
+			if _, err := db.Exec(`INSERT INTO matches (scan, pid, address, pattern, bytes, region, module,
+				module_offset, context) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`, scan, pr.Pid, int64(hit.Match.Address),
+				hit.Match.Pattern, hit.Match.Bytes, int64(region.Address), hit.Module, int64(hit.ModuleOffset),
+				int64(hit.Context)); err != nil {
+
+				return 0, fmt.Errorf("Unable to insert %v (%v)", hit.Match, err)
+			}
+		}
+	}
+
+	for _, outcome := range report.Actions {
+		if _, err := db.Exec(`INSERT INTO actions (scan, pid, address, action, time, path, rate_limited, error)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`, scan, outcome.Pid, int64(outcome.Address), outcome.Action,
+			outcome.Time.Format(time.RFC3339Nano), outcome.Path, outcome.RateLimited, outcome.Error); err != nil {
+
+			return 0, fmt.Errorf("Unable to insert the outcome of action %s (%v)", outcome.Action, err)
+		}
+	}
+	return scan, nil
+}
@@ -0,0 +1,183 @@
+package report
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/polyverse/masche/memaccess"
+	"github.com/polyverse/masche/memsearch"
+)
+
+// recordingDriver is a database/sql driver that records the statements executed, by the name of the database.
+type recordingDriver struct {
+	mu         sync.Mutex
+	statements map[string][]recordedStatement
+}
+
+type recordedStatement struct {
+	query string
+	args  []driver.Value
+}
+
+var recorder = &recordingDriver{statements: make(map[string][]recordedStatement)}
+
+func init() {
+	sql.Register("masche-recorder", recorder)
+}
+
+func (d *recordingDriver) Open(name string) (driver.Conn, error) {
+	return &recordingConn{d: d, name: name}, nil
+}
+
+func (d *recordingDriver) recorded(name string) []recordedStatement {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.statements[name]
+}
+
+type recordingConn struct {
+	d    *recordingDriver
+	name string
+}
+
+func (c *recordingConn) Prepare(query string) (driver.Stmt, error) {
+	return &recordingStmt{c: c, query: query}, nil
+}
+
+func (c *recordingConn) Close() error {
+	return nil
+}
+
+func (c *recordingConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("Transactions aren't supported")
+}
+
+type recordingStmt struct {
+	c     *recordingConn
+	query string
+}
+
+func (s *recordingStmt) Close() error {
+	return nil
+}
+
+func (s *recordingStmt) NumInput() int {
+	return -1
+}
+
+func (s *recordingStmt) Exec(args []driver.Value) (driver.Result, error) {
+	d := s.c.d
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.statements[s.c.name] = append(d.statements[s.c.name], recordedStatement{query: s.query, args: args})
+	return driver.RowsAffected(1), nil
+}
+
+func (s *recordingStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return nil, errors.New("Queries aren't supported")
+}
+
+// lastInsertIdResult is the result of inserting a scan.
+type lastInsertIdResult int64
+
+func (r lastInsertIdResult) LastInsertId() (int64, error) {
+	return int64(r), nil
+}
+
+func (r lastInsertIdResult) RowsAffected() (int64, error) {
+	return 1, nil
+}
+
+// scanIdExecer gives the scans inserted through db the id 7, as the recording driver has no rows.
+type scanIdExecer struct {
+	db *sql.DB
+}
+
+func (e scanIdExecer) Exec(query string, args ...interface{}) (sql.Result, error) {
+	result, err := e.db.Exec(query, args...)
+	if err == nil && strings.HasPrefix(query, "INSERT INTO scans") {
+		return lastInsertIdResult(7), nil
+	}
+	return result, err
+}
+
+func TestWriteSQL(t *testing.T) {
+	db, err := sql.Open("masche-recorder", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	rwx := memaccess.MemoryRegion{Address: 0x7f0000000000, Size: 0x1000, Access: 7, Kind: "anonymous"}
+	text := memaccess.MemoryRegion{Address: 0x400000, Size: 0x2000, Access: 5, Kind: "/bin/victim"}
+	report := ScanReport{
+		Time: time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC),
+		Processes: []ProcessReport{{Pid: 42, Executable: "/bin/victim", Hits: []Hit{
+			{Match: memsearch.Match{Pid: 42, Address: 0x400010, Pattern: 0, Bytes: []byte("MASCHEMK"), Region: text},
+				Module: "/bin/victim", ModuleOffset: 0x10, Class: "image"},
+			{Match: memsearch.Match{Pid: 42, Address: 0x7f0000000100, Pattern: 1, Bytes: []byte("XASCHEMK"),
+				Region: rwx}, Class: "anonymous", Context: 1 << 63},
+			{Match: memsearch.Match{Pid: 42, Address: 0x7f0000000200, Pattern: 0, Bytes: []byte("MASCHEMK"),
+				Region: rwx}, Class: "anonymous"},
+		}}},
+		Actions: []ActionOutcome{{Action: "flaky", Pid: 42, Address: 0x400010, Time: time.Unix(0, 0).UTC(),
+			Error: "flaky callback"}},
+	}
+	scan, err := WriteSQL(scanIdExecer{db}, report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scan != 7 {
+		t.Errorf("Expected the id of the scan, got %d", scan)
+	}
+
+	rows := make(map[string][][]driver.Value)
+	for _, statement := range recorder.recorded(t.Name()) {
+		fields := strings.Fields(statement.query)
+		if fields[0] == "INSERT" {
+			rows[fields[2]] = append(rows[fields[2]], statement.args)
+		}
+	}
+	expected := map[string][][]driver.Value{
+		"scans":     {{"2026-10-16T12:00:00Z", "", false}},
+		"processes": {{int64(7), int64(42), "/bin/victim"}},
+		"regions": {
+			{int64(7), int64(42), int64(0x400000), int64(0x2000), int64(5), "/bin/victim", "image"},
+			{int64(7), int64(42), int64(0x7f0000000000), int64(0x1000), int64(7), "anonymous", "anonymous"},
+		},
+		"matches": {
+			{int64(7), int64(42), int64(0x400010), int64(0), []byte("MASCHEMK"), int64(0x400000), "/bin/victim",
+				int64(0x10), int64(0)},
+			{int64(7), int64(42), int64(0x7f0000000100), int64(1), []byte("XASCHEMK"), int64(0x7f0000000000), "",
+				int64(0), int64(-1 << 63)},
+			{int64(7), int64(42), int64(0x7f0000000200), int64(0), []byte("MASCHEMK"), int64(0x7f0000000000), "",
+				int64(0), int64(0)},
+		},
+		"actions": {{int64(7), int64(42), int64(0x400010), "flaky", "1970-01-01T00:00:00Z", "", false,
+			"flaky callback"}},
+	}
+	if !reflect.DeepEqual(rows, expected) {
+		t.Errorf("Expected the rows\n%v\ngot\n%v", expected, rows)
+	}
+
+	// Statements that fail stop the export.
+	if _, err := WriteSQL(failingExecer{}, report); err == nil {
+		t.Error("Expected the export to fail")
+	}
+}
+
+// failingExecer fails to insert the regions.
+type failingExecer struct{}
+
+func (failingExecer) Exec(query string, args ...interface{}) (sql.Result, error) {
+	if strings.HasPrefix(query, "INSERT INTO regions") {
+		return nil, errors.New("disk full")
+	}
+	return lastInsertIdResult(1), nil
+}
@@ -0,0 +1,165 @@
+// Package snapshot captures the memory of several processes at the same instant, by stopping all of them while their
+// memory is copied, so the state they share, like a shared memory segment or the two ends of a pipe protocol, is the
+// same in every capture.
+package snapshot
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/polyverse/masche/common"
+	"github.com/polyverse/masche/memaccess"
+	"github.com/polyverse/masche/memsearch"
+	"github.com/polyverse/masche/process"
+)
+
+// Options modifies the behaviour of Consistent. Its zero value is a sensible default.
+type Options struct {
+	// Select tells if the contents of a region of p are copied. The region lists are captured whole. If it's nil the
+	// readable and writable regions are copied, which is where processes keep the state that changes.
+	Select func(p process.Process, region memaccess.MemoryRegion) bool
+	// MaxStop is the longest the processes are kept stopped, from the first one stopping to the last one resuming.
+	// When it passes, the captures that didn't complete are abandoned and every process is resumed. If it's zero it's
+	// one second.
+	MaxStop time.Duration
+	// ChunkSize is the amount of memory copied between checks of MaxStop. If it's zero it's 1 MiB.
+	ChunkSize uint
+}
+
+// Capture is the memory of one of the processes of a Result.
+type Capture struct {
+	Pid int `json:"pid"`
+	// Regions are all the regions of the process, and Segments the contents of the selected ones.
+	Regions  []memaccess.MemoryRegion `json:"regions"`
+	Segments []memaccess.Segment      `json:"-"`
+	// Frozen is how long the process was stopped.
+	Frozen time.Duration `json:"frozen"`
+	// Complete is true if every selected region was copied before MaxStop passed. The regions that couldn't be read
+	// are left out of Segments, and reported as soft errors, without making the capture incomplete.
+	Complete bool `json:"complete"`
+}
+
+// Backend returns a MemoryBackend over the copied contents of the capture, to search or decode them.
+func (c Capture) Backend() (*memaccess.StaticBackend, error) {
+	return memaccess.NewStaticBackend(memaccess.BackendInfo{Kind: "snapshot", Pid: c.Pid,
+		Description: fmt.Sprintf("Snapshot of process %d", c.Pid)}, c.Segments)
+}
+
+// Result is the result of Consistent.
+type Result struct {
+	// Time is when the last process stopped, the instant the memory of every capture belongs to.
+	Time time.Time `json:"time"`
+	// Captures has a capture for each process, in the order they were given.
+	Captures []Capture `json:"captures"`
+	// Aborted is true if MaxStop passed before every capture completed.
+	Aborted bool `json:"aborted,omitempty"`
+}
+
+// Consistent stops all the processes with Process.Suspend, lists their regions and copies the contents of the
+// selected ones, and resumes them. The memory of every capture is from the same instant, so the state the processes
+// share is the same in all of them. A process can't capture itself.
+//
+// If a process can't be stopped, the ones stopped before it are resumed and a hard error is returned. The processes
+// are always resumed before Consistent returns, even when MaxStop passes, so it can be used on processes that must
+// not stay stopped for long. On Linux the processes that were already stopped, like by a debugger or SIGSTOP from a
+// shell, stay stopped, as Process.Resume leaves them.
+func Consistent(procs []process.Process, opts Options) (result Result, harderror error, softerrors []error) {
+	if len(procs) == 0 {
+		return result, fmt.Errorf("No processes to capture"), nil
+	}
+	if opts.Select == nil {
+		opts.Select = writable
+	}
+	if opts.MaxStop == 0 {
+		opts.MaxStop = time.Second
+	}
+	if opts.ChunkSize == 0 {
+		opts.ChunkSize = 1 << 20
+	}
+
+	deadline := time.Now().Add(opts.MaxStop)
+	stopped := make([]time.Time, 0, len(procs))
+	defer func() {
+		for i := range stopped {
+			err, serrs := procs[i].Resume()
+			softerrors = append(softerrors, serrs...)
+			if err != nil {
+				softerrors = append(softerrors, err)
+			}
+			if result.Captures != nil {
+				result.Captures[i].Frozen = time.Since(stopped[i])
+			}
+		}
+	}()
+	for _, p := range procs {
+		err, serrs := p.Suspend()
+		softerrors = append(softerrors, serrs...)
+		if err != nil {
+			// Resume is safe on a process that didn't stop, and this one may have stopped before failing.
+			p.Resume()
+			return result, &common.LocatedError{Pid: p.Pid(), Err: fmt.Errorf("Unable to stop process %d (%w)",
+				p.Pid(), err)}, softerrors
+		}
+		stopped = append(stopped, time.Now())
+	}
+
+	result.Time = time.Now()
+	result.Captures = make([]Capture, len(procs))
+	for i, p := range procs {
+		result.Captures[i].Pid = p.Pid()
+	}
+	for i, p := range procs {
+		complete, serrs := capture(p, &result.Captures[i], deadline, opts)
+		softerrors = append(softerrors, serrs...)
+		if !complete {
+			result.Aborted = true
+			break
+		}
+	}
+	return result, nil, softerrors
+}
+
+// writable selects the readable and writable regions, other than the ones of the kernel.
+func writable(p process.Process, region memaccess.MemoryRegion) bool {
+	return region.Access&memaccess.Readable != 0 && region.Access&memaccess.Writable != 0 &&
+		!memsearch.SkipKernelMappings(region)
+}
+
+// capture lists the regions of p and copies the selected ones into c, a chunk at a time, until deadline passes. It
+// returns false if deadline passed first.
+func capture(p process.Process, c *Capture, deadline time.Time, opts Options) (complete bool, softerrors []error) {
+	regions, harderror, softerrors := memaccess.MemoryRegions(p)
+	if harderror != nil {
+		return true, append(softerrors, harderror)
+	}
+	c.Regions = regions
+
+	for _, region := range regions {
+		if region.Access&memaccess.Readable == 0 || !opts.Select(p, region) {
+			continue
+		}
+		data := make([]byte, region.Size)
+		for read := uint(0); read < region.Size; {
+			if time.Now().After(deadline) {
+				return false, softerrors
+			}
+			n := region.Size - read
+			if n > opts.ChunkSize {
+				n = opts.ChunkSize
+			}
+			harderror, serrs := memaccess.CopyMemory(p, region.Address+uintptr(read), data[read:read+n])
+			softerrors = append(softerrors, serrs...)
+			if harderror != nil {
+				softerrors = append(softerrors, harderror)
+				data = nil
+				break
+			}
+			read += n
+		}
+		if data != nil {
+			c.Segments = append(c.Segments, memaccess.Segment{Region: region, Data: data})
+		}
+	}
+	c.Complete = true
+	return true, softerrors
+}
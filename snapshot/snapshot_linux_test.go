@@ -0,0 +1,134 @@
+package snapshot
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/polyverse/masche/memaccess"
+	"github.com/polyverse/masche/process"
+	"github.com/polyverse/masche/test"
+)
+
+// launchCounters launches count test cases incrementing the counter of the same shared file, and returns the file.
+func launchCounters(t *testing.T, count int) (procs []process.Process, cmds []*exec.Cmd, counter string) {
+	counter = filepath.Join(t.TempDir(), "counter")
+	if err := ioutil.WriteFile(counter, make([]byte, 4096), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < count; i++ {
+		cmd, err := test.LaunchTestCaseAndWaitForInitialization("--counter", counter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { cmd.Process.Kill() })
+		p, err, softerrors := process.OpenFromPid(cmd.Process.Pid)
+		test.PrintSoftErrors(softerrors)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { p.Close() })
+		procs, cmds = append(procs, p), append(cmds, cmd)
+	}
+	return procs, cmds, counter
+}
+
+// isStopped tells if the process is stopped by a signal.
+func isStopped(t *testing.T, pid int) bool {
+	stat, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+	return fields[0] == "T"
+}
+
+func TestConsistent(t *testing.T) {
+	procs, _, counter := launchCounters(t, 2)
+	// The counters keep changing, so only a consistent snapshot finds the same value in both.
+	time.Sleep(20 * time.Millisecond)
+
+	result, harderror, softerrors := Consistent(procs, Options{
+		Select: func(p process.Process, region memaccess.MemoryRegion) bool { return region.Kind == counter },
+	})
+	test.PrintSoftErrors(softerrors)
+	if harderror != nil {
+		t.Fatal(harderror)
+	}
+	if result.Aborted || result.Time.IsZero() || len(result.Captures) != 2 {
+		t.Fatalf("Unexpected result %+v", result)
+	}
+
+	var generations []uint64
+	for i, c := range result.Captures {
+		if c.Pid != procs[i].Pid() || !c.Complete || c.Frozen <= 0 || len(c.Regions) == 0 {
+			t.Errorf("Unexpected capture of process %d: %+v", procs[i].Pid(), c)
+		}
+		if len(c.Segments) != 1 {
+			t.Fatalf("Captured %d segments of process %d, expected the counter", len(c.Segments), c.Pid)
+		}
+		backend, err := c.Backend()
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 8)
+		if err, _ := backend.ReadAt(c.Segments[0].Region.Address, buf); err != nil {
+			t.Fatal(err)
+		}
+		generations = append(generations, binary.LittleEndian.Uint64(buf))
+		if isStopped(t, c.Pid) {
+			t.Errorf("Process %d was left stopped", c.Pid)
+		}
+	}
+	if generations[0] == 0 || generations[0] != generations[1] {
+		t.Errorf("The captures have the generations %v of the shared counter", generations)
+	}
+}
+
+func TestConsistentMaxStop(t *testing.T) {
+	procs, _, _ := launchCounters(t, 2)
+
+	result, harderror, softerrors := Consistent(procs, Options{MaxStop: time.Nanosecond})
+	test.PrintSoftErrors(softerrors)
+	if harderror != nil {
+		t.Fatal(harderror)
+	}
+	if !result.Aborted || len(result.Captures) != 2 {
+		t.Fatalf("Unexpected result %+v", result)
+	}
+	for _, c := range result.Captures {
+		if c.Complete {
+			t.Errorf("The capture of process %d completed after the deadline", c.Pid)
+		}
+		if c.Frozen <= 0 {
+			t.Errorf("The time process %d was stopped wasn't reported", c.Pid)
+		}
+		if isStopped(t, c.Pid) {
+			t.Errorf("Process %d was left stopped", c.Pid)
+		}
+	}
+}
+
+func TestConsistentSuspendFailure(t *testing.T) {
+	procs, cmds, _ := launchCounters(t, 2)
+	cmds[1].Process.Kill()
+	cmds[1].Wait()
+
+	_, harderror, softerrors := Consistent(procs, Options{})
+	test.PrintSoftErrors(softerrors)
+	if harderror == nil {
+		t.Fatal("Captured an exited process")
+	}
+	if isStopped(t, procs[0].Pid()) {
+		t.Error("The process stopped before the failure was left stopped")
+	}
+
+	if _, harderror, _ := Consistent(nil, Options{}); harderror == nil {
+		t.Error("Captured no processes")
+	}
+}
@@ -4,6 +4,7 @@ package test
 import (
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -12,7 +13,12 @@ import (
 
 func GetTestCasePath() string {
 	//TODO: Right now the command is hardcoded. We should decide how to fix this.
-	dirPath, err := filepath.Abs("../test/tools")
+	// The tests run from the directory of their package, which is a child of the root but for the root package.
+	dir := "../test/tools"
+	if _, err := os.Stat("test/tools"); err == nil {
+		dir = "test/tools"
+	}
+	dirPath, err := filepath.Abs(dir)
 	testFileName := "test"
 
 	// Ugly hack for windows: The testFileName must end in .exe
@@ -41,35 +47,58 @@ func PrintSoftErrors(softerrors []error) {
 }
 
 // this method redirects the process's stdout to the test stdout
-func LaunchTestCase() (*exec.Cmd, error) {
-	cmd := exec.Command(GetTestCasePath())
+func LaunchTestCase(args ...string) (*exec.Cmd, error) {
+	cmd := exec.Command(GetTestCasePath(), args...)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	err := cmd.Start()
 	return cmd, err
 }
 
-func LaunchTestCaseAndWaitForInitialization() (*exec.Cmd, error) {
-	return launchProcessAndWaitInitialization(GetTestCasePath())
+func LaunchTestCaseAndWaitForInitialization(args ...string) (*exec.Cmd, error) {
+	return launchProcessAndWaitInitialization(GetTestCasePath(), args...)
 }
 
 // starts a process and waits until it writes everythin to stdout: that way we know it has been initialized.
 // the process launched should close stdout once it has been fully initialized.
 // this method redirects the process's stdout to the test stdout
-func launchProcessAndWaitInitialization(file string) (*exec.Cmd, error) {
-	cmd := exec.Command(file)
+func launchProcessAndWaitInitialization(file string, args ...string) (*exec.Cmd, error) {
+	cmd := exec.Command(file, args...)
+	if err := StartAndWaitForInitialization(cmd); err != nil {
+		return nil, err
+	}
+	return cmd, nil
+}
 
+// StartAndWaitForInitialization works as LaunchTestCaseAndWaitForInitialization, but it starts cmd, which can have
+// its own environment or argv[0].
+func StartAndWaitForInitialization(cmd *exec.Cmd) error {
 	childout, err := cmd.StdoutPipe()
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer childout.Close()
 
 	if err := cmd.Start(); err != nil {
-		return nil, err
+		return err
 	}
 
 	io.Copy(os.Stdout, childout)
 
-	return cmd, nil
+	return nil
+}
+
+// CopyTestCase copies the test case to dir, and returns the path of the copy. The copy is a different executable, as
+// far as the OS can tell.
+func CopyTestCase(dir string) (string, error) {
+	data, err := ioutil.ReadFile(GetTestCasePath())
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(dir, filepath.Base(GetTestCasePath()))
+	if err := ioutil.WriteFile(path, data, 0755); err != nil {
+		return "", err
+	}
+	return path, nil
 }
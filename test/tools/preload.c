@@ -0,0 +1,4 @@
+// A library that does nothing, used to test the detection of preloaded libraries.
+int masche_preload_marker(void) {
+    return 0;
+}